@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/bestk/kiro2cc/pkg/client"
+	"github.com/bestk/kiro2cc/pkg/server"
+)
+
+// runAsk 处理 ask 子命令：发送一次非流式请求，只把回复文本输出到 stdout，便于在脚本中使用。
+// 退出码 0 表示成功，1 表示请求失败，2 表示参数错误
+func runAsk(args []string) {
+	fs := flag.NewFlagSet("ask", flag.ExitOnError)
+	model := fs.String("m", defaultChatModel, "使用的模型，可以是模型名的一部分，例如 claude-3-5-haiku")
+	system := fs.String("system", "", "system 提示词")
+	maxTokens := fs.Int("max-tokens", 4096, "最大输出 tokens")
+	serverURL := fs.String("server", "", "连接已运行的 kiro2cc 服务器，留空时在本进程内启动")
+	apiKey := fs.String("api-key", defaultAPIKey(), "连接服务器时使用的 API key，默认为环境变量 ANTHROPIC_API_KEY 或本地 API key")
+	verbose := fs.Bool("verbose", false, "把服务器日志输出到 stderr")
+	addJSONFlag(fs, "输出完整的响应 JSON，而不只是文本")
+	fs.Parse(args)
+
+	resolved, ok := server.MatchModel(*model)
+	if !ok {
+		exitf(2, os.Stderr, "不支持的模型: %s", *model)
+	}
+
+	// 问题为空或为 - 时从标准输入读取，方便通过管道传入
+	prompt := strings.Join(fs.Args(), " ")
+	if prompt == "" || prompt == "-" {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			exitf(2, os.Stderr, "读取标准输入失败: %v", err)
+		}
+		prompt = string(data)
+	}
+	if strings.TrimSpace(prompt) == "" {
+		exitf(2, os.Stderr, "用法: %s ask [-m 模型] \"问题\"", os.Args[0])
+	}
+
+	out := quietServerLogs(*verbose)
+	c, closeServer, err := newLocalClient(*serverURL, *apiKey)
+	if err != nil {
+		exitf(1, os.Stderr, "%v", err)
+	}
+	defer closeServer()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	req := client.MessageRequest{
+		Model:     resolved,
+		MaxTokens: *maxTokens,
+		Messages:  []client.Message{{Role: "user", Content: prompt}},
+	}
+	if *system != "" {
+		req.System = []client.SystemBlock{{Type: "text", Text: *system}}
+	}
+	resp, err := c.Messages(ctx, req)
+	if err != nil {
+		closeServer()
+		exitf(1, os.Stderr, "请求失败: %v", err)
+	}
+	if jsonOutput {
+		writeJSON(out, resp)
+		return
+	}
+	fmt.Fprintln(out, resp.Text())
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/bestk/kiro2cc/pkg/token"
+)
+
+// bootstrap 的退出码，与 refresh --if-needed 一致，便于 Ansible 的 changed_when 等判断
+const (
+	// exitBootstrapUnchanged 表示检查通过，没有需要改动的地方
+	exitBootstrapUnchanged = 0
+	// exitBootstrapFailed 表示参数、token 或配置校验失败，或者写入文件失败
+	exitBootstrapFailed = 1
+	// exitBootstrapUsage 表示命令行参数有误
+	exitBootstrapUsage = 2
+	// exitBootstrapChanged 表示检查通过，并创建或更新了目录、配置或服务单元
+	exitBootstrapChanged = 3
+)
+
+// defaultBootstrapConfig 是配置文件不存在时写入的内容
+const defaultBootstrapConfig = `# kiro2cc server 参数，键为 server 命令的参数名 (不带 --)，port 为监听端口
+port: 8080
+`
+
+// bootstrapOutput 是 bootstrap --json 的输出，ok 与退出码不为 1、2 一致
+type bootstrapOutput struct {
+	OK       bool     `json:"ok"`
+	Changed  bool     `json:"changed"`
+	Actions  []string `json:"actions"`
+	Warnings []string `json:"warnings"`
+	Errors   []string `json:"errors"`
+	// Unit 是服务单元文件的路径，没有安装时为空
+	Unit string `json:"unit,omitempty"`
+}
+
+// bootstrapper 记录 bootstrap 执行的操作和发现的问题
+type bootstrapper struct {
+	out bootstrapOutput
+}
+
+func (b *bootstrapper) action(format string, args ...any) {
+	b.out.Changed = true
+	b.out.Actions = append(b.out.Actions, fmt.Sprintf(format, args...))
+}
+
+func (b *bootstrapper) fail(format string, args ...any) {
+	b.out.Errors = append(b.out.Errors, fmt.Sprintf(format, args...))
+}
+
+// mkdir 创建目录，已经存在时不算改动
+func (b *bootstrapper) mkdir(dir string, perm os.FileMode) bool {
+	if info, err := os.Stat(dir); err == nil {
+		if !info.IsDir() {
+			b.fail("%s 已存在且不是目录", dir)
+			return false
+		}
+		return true
+	}
+	if err := os.MkdirAll(dir, perm); err != nil {
+		b.fail("创建目录 %s 失败: %v", dir, err)
+		return false
+	}
+	b.action("创建目录 %s", dir)
+	return true
+}
+
+// writeFile 在内容不同时写入文件，返回是否写入
+func (b *bootstrapper) writeFile(path string, content []byte, perm os.FileMode) bool {
+	if existing, err := os.ReadFile(path); err == nil && bytes.Equal(existing, content) {
+		return false
+	}
+	if err := os.WriteFile(path, content, perm); err != nil {
+		b.fail("写入 %s 失败: %v", path, err)
+		return false
+	}
+	b.action("写入 %s", path)
+	return true
+}
+
+// runBootstrap 处理 bootstrap 子命令：为无人值守的部署一次性创建目录、校验 token 和配置并安装 systemd 服务单元。
+// 重复执行是安全的，没有改动时以 0 退出，有改动时以 3 退出
+func runBootstrap(args []string) {
+	fs := flag.NewFlagSet("bootstrap", flag.ExitOnError)
+	configPath := fs.String("config", "", "服务器配置文件 (YAML)，不存在时创建默认配置")
+	tokenFile := fs.String("token-file", "", "服务器使用的 token 文件，需要事先放好")
+	binary := fs.String("binary", "", "服务单元中运行的 kiro2cc 路径，默认为当前程序")
+	unitDir := fs.String("unit-dir", "/etc/systemd/system", "安装 systemd 服务单元的目录，为空时不安装")
+	serviceName := fs.String("service-name", "kiro2cc", "服务单元名称")
+	user := fs.String("user", "", "以该用户运行服务，为空时使用 root")
+	systemctl := fs.Bool("systemctl", true, "服务单元变化后执行 systemctl daemon-reload 和 enable")
+	start := fs.Bool("start", false, "同时启动服务，服务单元或配置变化时重启")
+	addJSONFlag(fs, "以 JSON 输出执行结果，退出码不变")
+	fs.Parse(args)
+
+	if *configPath == "" || *tokenFile == "" {
+		exitf(exitBootstrapUsage, os.Stderr, "用法: %s bootstrap --config /etc/kiro2cc/config.yaml --token-file /var/lib/kiro2cc/token.json [--unit-dir dir] [--user name] [--start]", os.Args[0])
+	}
+	for _, path := range []*string{configPath, tokenFile, binary} {
+		if *path == "" {
+			continue
+		}
+		abs, err := filepath.Abs(*path)
+		if err != nil {
+			exitf(exitBootstrapUsage, os.Stderr, "%s: %v", *path, err)
+		}
+		*path = abs
+	}
+	if *binary == "" {
+		executable, err := os.Executable()
+		if err != nil {
+			exitf(exitBootstrapUsage, os.Stderr, "无法确定当前程序的路径，请使用 --binary: %v", err)
+		}
+		*binary = executable
+	}
+
+	b := &bootstrapper{}
+	b.bootstrap(*configPath, *tokenFile, *binary, *unitDir, *serviceName, *user, *systemctl, *start)
+
+	code := exitBootstrapUnchanged
+	switch {
+	case len(b.out.Errors) > 0:
+		code = exitBootstrapFailed
+	case b.out.Changed:
+		code = exitBootstrapChanged
+	}
+	b.out.OK = len(b.out.Errors) == 0
+	if jsonOutput {
+		b.out.Actions, b.out.Warnings, b.out.Errors = nonNil(b.out.Actions), nonNil(b.out.Warnings), nonNil(b.out.Errors)
+		writeJSON(os.Stdout, b.out)
+		os.Exit(code)
+	}
+	for _, action := range b.out.Actions {
+		fmt.Println(action)
+	}
+	for _, warning := range b.out.Warnings {
+		fmt.Printf("警告: %s\n", warning)
+	}
+	for _, problem := range b.out.Errors {
+		fmt.Printf("错误: %s\n", problem)
+	}
+	if code == exitBootstrapUnchanged {
+		fmt.Println("已是最新状态，没有改动")
+	}
+	os.Exit(code)
+}
+
+// bootstrap 按顺序执行各个步骤，校验失败时不再安装服务单元
+func (b *bootstrapper) bootstrap(configPath, tokenFile, binary, unitDir, serviceName, user string, systemctl, start bool) {
+	if !b.mkdir(filepath.Dir(configPath), 0755) || !b.mkdir(filepath.Dir(tokenFile), 0700) {
+		return
+	}
+	configChanged := false
+	if _, err := os.Stat(configPath); errors.Is(err, os.ErrNotExist) {
+		configChanged = b.writeFile(configPath, []byte(defaultBootstrapConfig), 0644)
+	}
+
+	if data, err := token.Read(tokenFile); err != nil {
+		b.fail("token: %v", err)
+	} else if data.AccessToken == "" {
+		b.fail("token: %s 中缺少 accessToken", tokenFile)
+	}
+	if _, err := loadServerConfigFile(configPath); err != nil {
+		b.fail("config: %v", err)
+	}
+	if len(b.out.Errors) > 0 {
+		return
+	}
+
+	// 与 server --config 使用同一套参数解析，参数值有误时和 server 一样输出错误后以 1 退出
+	tokenFilePath = tokenFile
+	serverFlags := flag.NewFlagSet("server", flag.ExitOnError)
+	_, config := parseServerFlags(serverFlags, []string{"--config", configPath})
+	if config.ProfileArn == "" {
+		config.ProfileArn = os.Getenv("KIRO_PROFILE_ARN")
+	}
+	problems, warnings := validateConfig(config, time.Now())
+	for _, problem := range problems {
+		b.fail("config: %s", problem)
+	}
+	b.out.Warnings = append(b.out.Warnings, warnings...)
+	if len(problems) > 0 {
+		return
+	}
+	if config.StateDir != "" && !b.mkdir(config.StateDir, 0700) {
+		return
+	}
+
+	if unitDir == "" {
+		return
+	}
+	if runtime.GOOS != "linux" {
+		b.out.Warnings = append(b.out.Warnings, fmt.Sprintf("%s 不使用 systemd，没有安装服务单元", runtime.GOOS))
+		return
+	}
+	if !b.mkdir(unitDir, 0755) {
+		return
+	}
+	unitPath := filepath.Join(unitDir, serviceName+".service")
+	b.out.Unit = unitPath
+	unitChanged := b.writeFile(unitPath, []byte(systemdUnit(binary, tokenFile, configPath, user, config.ProfileArn)), 0644)
+	if !systemctl {
+		return
+	}
+	if unitChanged {
+		b.systemctl("daemon-reload")
+		b.systemctl("enable", serviceName)
+	}
+	if start {
+		if unitChanged || configChanged {
+			b.systemctl("restart", serviceName)
+		} else if exec.Command("systemctl", "is-active", "--quiet", serviceName).Run() != nil {
+			b.systemctl("start", serviceName)
+		}
+	}
+}
+
+// systemctl 执行 systemctl 命令并记录为一次改动
+func (b *bootstrapper) systemctl(args ...string) {
+	output, err := exec.Command("systemctl", args...).CombinedOutput()
+	if err != nil {
+		b.fail("systemctl %s 失败: %v %s", strings.Join(args, " "), err, bytes.TrimSpace(output))
+		return
+	}
+	b.action("systemctl %s", strings.Join(args, " "))
+}
+
+// systemdUnit 生成运行 server 的 systemd 服务单元，profileArn 不为空时写入 KIRO_PROFILE_ARN
+func systemdUnit(binary, tokenFile, configPath, user, profileArn string) string {
+	var unit strings.Builder
+	unit.WriteString("[Unit]\n")
+	unit.WriteString("Description=kiro2cc Anthropic API proxy\n")
+	unit.WriteString("After=network-online.target\n")
+	unit.WriteString("Wants=network-online.target\n\n")
+	unit.WriteString("[Service]\n")
+	fmt.Fprintf(&unit, "ExecStart=%s -f %s server --config %s\n", systemdQuote(binary), systemdQuote(tokenFile), systemdQuote(configPath))
+	if profileArn != "" {
+		fmt.Fprintf(&unit, "Environment=%s\n", systemdQuote("KIRO_PROFILE_ARN="+profileArn))
+	}
+	if user != "" {
+		fmt.Fprintf(&unit, "User=%s\n", user)
+	}
+	unit.WriteString("Restart=on-failure\n")
+	unit.WriteString("RestartSec=5\n\n")
+	unit.WriteString("[Install]\n")
+	unit.WriteString("WantedBy=multi-user.target\n")
+	return unit.String()
+}
+
+// systemdQuote 在路径包含空白或引号时按 systemd 的规则加上双引号
+func systemdQuote(arg string) string {
+	if !strings.ContainsAny(arg, " \t\"'\\") {
+		return arg
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(arg) + `"`
+}
@@ -1,6 +1,6 @@
 @echo off
 REM 编译
-go build -o kiro2cc.exe main.go
+go build -o kiro2cc.exe .
 IF %ERRORLEVEL% NEQ 0 (
     echo 编译失败!
     pause
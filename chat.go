@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bestk/kiro2cc/pkg/client"
+	"github.com/bestk/kiro2cc/pkg/server"
+)
+
+// defaultChatModel 是 chat 等命令未指定模型时使用的模型
+const defaultChatModel = "claude-sonnet-4-20250514"
+
+// defaultAPIKey 返回连接服务器默认使用的 API key: 环境变量 ANTHROPIC_API_KEY，没有时使用已生成的本地 API key
+func defaultAPIKey() string {
+	if key := os.Getenv("ANTHROPIC_API_KEY"); key != "" {
+		return key
+	}
+	key, _ := server.LoadLocalAPIKey(localAPIKeyPath())
+	return key
+}
+
+// newLocalClient 返回访问代理的客户端。serverURL 为空时在本进程内启动服务器并监听随机端口，
+// 请求经过与 server 命令完全相同的转换流程；返回的函数用于关闭该服务器
+func newLocalClient(serverURL, apiKey string) (*client.Client, func(), error) {
+	if serverURL != "" {
+		return client.New(serverURL, apiKey), func() {}, nil
+	}
+
+	baseURL, closeServer, err := startLocalServer()
+	if err != nil {
+		return nil, nil, err
+	}
+	return client.New(baseURL, apiKey), closeServer, nil
+}
+
+// startLocalServer 在本进程内启动服务器并监听随机端口，返回其地址和关闭函数
+func startLocalServer() (string, func(), error) {
+	srv, err := server.New(server.Config{TokenPath: getTokenFilePath()})
+	if err != nil {
+		return "", nil, fmt.Errorf("创建服务器失败: %v", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, fmt.Errorf("监听本地端口失败: %v", err)
+	}
+	httpServer := &http.Server{Handler: srv.Handler()}
+	go httpServer.Serve(ln)
+	return "http://" + ln.Addr().String(), func() { httpServer.Close() }, nil
+}
+
+// quietServerLogs 把服务器打印到标准输出的请求日志转到 stderr 或丢弃，避免打断对话输出，返回原来的标准输出
+func quietServerLogs(verbose bool) *os.File {
+	out := os.Stdout
+	if verbose {
+		os.Stdout = os.Stderr
+	} else if devNull, err := os.Open(os.DevNull); err == nil {
+		os.Stdout = devNull
+	}
+	return out
+}
+
+// chatSession 保存一次交互式对话的状态
+type chatSession struct {
+	client    *client.Client
+	model     string
+	system    string
+	maxTokens int
+	messages  []client.Message
+	out       io.Writer
+}
+
+// runChat 处理 chat 子命令，在终端中与模型对话
+func runChat(args []string) {
+	fs := flag.NewFlagSet("chat", flag.ExitOnError)
+	model := fs.String("model", defaultChatModel, "使用的模型，可以是模型名的一部分，对话中可用 /model 切换")
+	system := fs.String("system", "", "system 提示词")
+	maxTokens := fs.Int("max-tokens", 4096, "每次回复的最大输出 tokens")
+	serverURL := fs.String("server", "", "连接已运行的 kiro2cc 服务器，留空时在本进程内启动")
+	apiKey := fs.String("api-key", defaultAPIKey(), "连接服务器时使用的 API key，默认为环境变量 ANTHROPIC_API_KEY 或本地 API key")
+	verbose := fs.Bool("verbose", false, "把服务器日志输出到 stderr")
+	fs.Parse(args)
+
+	resolved, ok := server.MatchModel(*model)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "不支持的模型: %s\n", *model)
+		os.Exit(1)
+	}
+
+	out := quietServerLogs(*verbose)
+	c, closeServer, err := newLocalClient(*serverURL, *apiKey)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	defer closeServer()
+
+	cs := &chatSession{client: c, model: resolved, system: *system, maxTokens: *maxTokens, out: out}
+	fmt.Fprintf(out, "kiro2cc chat · %s，输入 /help 查看命令，/exit 退出\n", cs.model)
+	cs.run(os.Stdin)
+}
+
+// run 逐行读取输入，以 / 开头的是命令，其余作为用户消息发送
+func (cs *chatSession) run(in io.Reader) {
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for {
+		fmt.Fprint(cs.out, "> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(cs.out)
+			return
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "/") {
+			if !cs.command(line) {
+				return
+			}
+			continue
+		}
+		cs.send(line)
+	}
+}
+
+// command 执行对话命令，返回 false 表示退出
+func (cs *chatSession) command(line string) bool {
+	name, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+	switch name {
+	case "/exit", "/quit":
+		return false
+	case "/help":
+		fmt.Fprintln(cs.out, "  /model [名称]  查看或切换模型")
+		fmt.Fprintln(cs.out, "  /models       列出支持的模型")
+		fmt.Fprintln(cs.out, "  /system [文本] 查看或设置 system 提示词")
+		fmt.Fprintln(cs.out, "  /save <文件>   以 markdown 保存对话")
+		fmt.Fprintln(cs.out, "  /clear        清空对话")
+		fmt.Fprintln(cs.out, "  /exit         退出")
+	case "/model":
+		if arg == "" {
+			fmt.Fprintf(cs.out, "当前模型: %s\n", cs.model)
+			break
+		}
+		model, ok := server.MatchModel(arg)
+		if !ok {
+			fmt.Fprintf(cs.out, "不支持的模型: %s，用 /models 查看支持的模型\n", arg)
+			break
+		}
+		cs.model = model
+		fmt.Fprintf(cs.out, "已切换到 %s\n", cs.model)
+	case "/models":
+		models := make([]string, 0, len(server.ModelMap))
+		for model := range server.ModelMap {
+			models = append(models, model)
+		}
+		sort.Strings(models)
+		for _, model := range models {
+			fmt.Fprintf(cs.out, "  %s\n", model)
+		}
+	case "/system":
+		if arg == "" {
+			fmt.Fprintf(cs.out, "当前 system 提示词: %q\n", cs.system)
+			break
+		}
+		cs.system = arg
+		fmt.Fprintln(cs.out, "已设置 system 提示词")
+	case "/save":
+		if arg == "" {
+			fmt.Fprintln(cs.out, "用法: /save <文件>")
+			break
+		}
+		if err := cs.save(arg); err != nil {
+			fmt.Fprintf(cs.out, "保存失败: %v\n", err)
+			break
+		}
+		fmt.Fprintf(cs.out, "已保存 %d 条消息到 %s\n", len(cs.messages), arg)
+	case "/clear":
+		cs.messages = nil
+		fmt.Fprintln(cs.out, "已清空对话")
+	default:
+		fmt.Fprintf(cs.out, "未知命令: %s，输入 /help 查看命令\n", name)
+	}
+	return true
+}
+
+// send 发送用户消息并流式输出回复，Ctrl+C 只中断当前回复
+func (cs *chatSession) send(text string) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	messages := append(cs.messages, client.Message{Role: "user", Content: text})
+	req := client.MessageRequest{Model: cs.model, MaxTokens: cs.maxTokens, Messages: messages}
+	if cs.system != "" {
+		req.System = []client.SystemBlock{{Type: "text", Text: cs.system}}
+	}
+
+	stream, err := cs.client.MessagesStream(ctx, req)
+	if err != nil {
+		fmt.Fprintf(cs.out, "请求失败: %v\n", err)
+		return
+	}
+	defer stream.Close()
+
+	var reply strings.Builder
+	for stream.Next() {
+		delta := stream.Event().TextDelta()
+		reply.WriteString(delta)
+		fmt.Fprint(cs.out, delta)
+	}
+	fmt.Fprintln(cs.out)
+	if err := stream.Err(); err != nil {
+		if errors.Is(ctx.Err(), context.Canceled) {
+			fmt.Fprintln(cs.out, "已中断")
+		} else {
+			fmt.Fprintf(cs.out, "请求失败: %v\n", err)
+		}
+		return
+	}
+
+	// 只有完整的回复才计入对话历史
+	cs.messages = append(messages, client.Message{Role: "assistant", Content: reply.String()})
+}
+
+// save 以 markdown 格式保存对话，格式与 transcript 命令导出的一致
+func (cs *chatSession) save(path string) error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "## %s · %s\n\n", time.Now().Format(time.RFC3339), cs.model)
+	if cs.system != "" {
+		fmt.Fprintf(&sb, "**system**\n\n%s\n\n", cs.system)
+	}
+	for _, msg := range cs.messages {
+		fmt.Fprintf(&sb, "**%s**\n\n%s\n\n", msg.Role, msg.Content)
+	}
+	return os.WriteFile(path, []byte(sb.String()), 0644)
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bestk/kiro2cc/pkg/server"
+)
+
+const (
+	// claudeBackupSuffix 是 claude 命令修改配置文件前保存的备份的文件名后缀，后面跟时间戳
+	claudeBackupSuffix = ".kiro2cc-backup-"
+	// claudeBackupKeep 是每个配置文件保留的备份数量
+	claudeBackupKeep = 10
+	// claudeWriteAttempts 是配置文件在读写之间被其他程序修改时的重试次数
+	claudeWriteAttempts = 5
+)
+
+// claudeModelEnv 是 Claude Code 选择模型的环境变量，写在 settings.json 的 env 中
+var claudeModelEnv = []string{"ANTHROPIC_MODEL", "ANTHROPIC_SMALL_FAST_MODEL"}
+
+// claudeOutput 是 claude 命令 --json 时的输出
+type claudeOutput struct {
+	// ClaudeJSON 是 ~/.claude.json 的路径，Changed 表示本次是否修改了它
+	ClaudeJSON string `json:"claude_json,omitempty"`
+	Changed    bool   `json:"changed"`
+	// Models 是 --models 时写入 settings.json 的模型，按 claudeModelEnv 的变量名索引
+	Settings        string            `json:"settings,omitempty"`
+	Models          map[string]string `json:"models,omitempty"`
+	SettingsChanged bool              `json:"settings_changed,omitempty"`
+	Warnings        []string          `json:"warnings,omitempty"`
+	// Restored 是 --restore 恢复的文件
+	Restored []string `json:"restored,omitempty"`
+}
+
+// setClaude 处理 claude 子命令：修改 ~/.claude.json 跳过 Claude Code 的登录引导，--models 时设置模型。
+// 每次修改前备份原文件，--restore 撤销最近一次修改
+func setClaude(args []string) {
+	fs := flag.NewFlagSet("claude", flag.ExitOnError)
+	models := fs.Bool("models", false, "同时在 ~/.claude/settings.json 中设置代理支持的 ANTHROPIC_MODEL 和 ANTHROPIC_SMALL_FAST_MODEL")
+	model := fs.String("model", "sonnet", "--models 时的主模型，可以是模型名的一部分")
+	smallFastModel := fs.String("small-fast-model", "haiku", "--models 时的后台任务模型，可以是模型名的一部分")
+	restore := fs.Bool("restore", false, "撤销最近一次 claude 命令对配置文件的修改")
+	addJSONFlag(fs, "以 JSON 输出修改了哪些文件")
+	fs.Parse(args)
+
+	// C:\Users\WIN10\.claude.json
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		exitf(1, os.Stdout, "获取用户目录失败: %v", err)
+	}
+	claudeJsonPath := filepath.Join(homeDir, ".claude.json")
+	settingsPath := claudeSettingsPath(homeDir)
+
+	if *restore {
+		restored, err := restoreClaudeConfig(claudeJsonPath, settingsPath)
+		if err != nil {
+			exitf(1, os.Stdout, "恢复失败: %v", err)
+		}
+		if jsonOutput {
+			writeJSON(os.Stdout, claudeOutput{Restored: restored})
+			return
+		}
+		for _, path := range restored {
+			fmt.Printf("已恢复 %s\n", path)
+		}
+		return
+	}
+
+	ok, _ := FileExists(claudeJsonPath)
+	if !ok {
+		exitf(1, os.Stdout, "未找到Claude配置文件，请确认是否已安装 Claude Code\nnpm install -g @anthropic-ai/claude-code")
+	}
+
+	// 同一次执行的备份使用相同的时间戳，--restore 时一起恢复
+	stamp := time.Now().Format("20060102-150405.000000")
+	changed, err := updateJSONFile(claudeJsonPath, stamp, func(jsonData map[string]any) {
+		jsonData["hasCompletedOnboarding"] = true
+		jsonData["kiro2cc"] = true
+	})
+	if err != nil {
+		exitf(1, os.Stdout, "更新 Claude 配置文件失败: %v", err)
+	}
+	output := claudeOutput{ClaudeJSON: claudeJsonPath, Changed: changed}
+	if !jsonOutput {
+		if changed {
+			fmt.Println("Claude 配置文件已更新，运行 kiro2cc claude --restore 可以撤销")
+		} else {
+			fmt.Println("Claude 配置文件无需修改")
+		}
+	}
+
+	if *models {
+		resolved, changed, err := setClaudeModels(settingsPath, stamp, *model, *smallFastModel)
+		if err != nil {
+			exitf(1, os.Stdout, "设置 Claude Code 模型失败: %v", err)
+		}
+		output.Settings, output.SettingsChanged, output.Models = settingsPath, changed, map[string]string{}
+		for i, key := range claudeModelEnv {
+			output.Models[key] = resolved[i]
+		}
+		if !jsonOutput {
+			if changed {
+				fmt.Printf("已在 %s 中设置 ANTHROPIC_MODEL=%s, ANTHROPIC_SMALL_FAST_MODEL=%s\n", settingsPath, resolved[0], resolved[1])
+			} else {
+				fmt.Printf("%s 中的模型已是 %s 和 %s，无需修改\n", settingsPath, resolved[0], resolved[1])
+			}
+		}
+	} else {
+		output.Warnings = unsupportedClaudeModels(settingsPath)
+		if !jsonOutput {
+			for _, warning := range output.Warnings {
+				fmt.Printf("警告: %s\n", warning)
+			}
+		}
+	}
+	if jsonOutput {
+		writeJSON(os.Stdout, output)
+	}
+}
+
+// claudeSettingsPath 返回 Claude Code 的用户级配置文件 ~/.claude/settings.json
+func claudeSettingsPath(homeDir string) string {
+	return filepath.Join(homeDir, ".claude", "settings.json")
+}
+
+// readClaudeSettings 读取 Claude Code 的配置，文件不存在时返回空配置
+func readClaudeSettings(path string) (map[string]any, error) {
+	settings := map[string]any{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return settings, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return nil, fmt.Errorf("解析 %s 失败: %v", path, err)
+	}
+	return settings, nil
+}
+
+// setClaudeModels 把 Claude Code 使用的模型写入 settings.json 的 env，模型先按 MatchModel 解析为代理支持的名称。
+// 返回解析后的模型和是否修改了文件
+func setClaudeModels(path, stamp, model, smallFastModel string) ([]string, bool, error) {
+	resolved := make([]string, len(claudeModelEnv))
+	for i, hint := range []string{model, smallFastModel} {
+		name, ok := server.MatchModel(hint)
+		if !ok {
+			return nil, false, fmt.Errorf("%q 不是代理支持的模型，可用的模型: %s", hint, strings.Join(supportedModels(), ", "))
+		}
+		resolved[i] = name
+	}
+
+	changed, err := updateJSONFile(path, stamp, func(settings map[string]any) {
+		env, _ := settings["env"].(map[string]any)
+		if env == nil {
+			env = map[string]any{}
+		}
+		for i, key := range claudeModelEnv {
+			env[key] = resolved[i]
+		}
+		settings["env"] = env
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return resolved, changed, nil
+}
+
+// unsupportedClaudeModels 检查 settings.json 中已配置的模型，代理不支持时返回提示使用 --models 的警告，
+// 否则 Claude Code 的第一个请求就会以 Unknown or unsupported model 失败
+func unsupportedClaudeModels(path string) []string {
+	settings, err := readClaudeSettings(path)
+	if err != nil {
+		return nil
+	}
+	var warnings []string
+	env, _ := settings["env"].(map[string]any)
+	for _, key := range claudeModelEnv {
+		model, _ := env[key].(string)
+		if model == "" {
+			continue
+		}
+		if _, ok := server.ModelMap[model]; !ok {
+			warnings = append(warnings, fmt.Sprintf("%s 中 %s=%s 不是代理支持的模型，运行 kiro2cc claude --models 修正", path, key, model))
+		}
+	}
+	return warnings
+}
+
+// supportedModels 按名称排序返回代理支持的模型
+func supportedModels() []string {
+	models := make([]string, 0, len(server.ModelMap))
+	for model := range server.ModelMap {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+	return models
+}
+
+// updateJSONFile 读取 JSON 对象文件、交给 mutate 修改后写回，内容没有变化时不写入，返回是否写入。
+// 写入前以 stamp 为时间戳备份原文件（文件原本不存在时备份为空文件），写入通过临时文件原子替换。
+// Claude Code 运行时也会改写这些文件，写入前发现文件已被修改时重新读取并重试
+func updateJSONFile(path, stamp string, mutate func(map[string]any)) (bool, error) {
+	for attempt := 0; attempt < claudeWriteAttempts; attempt++ {
+		mode := os.FileMode(0644)
+		original, err := os.ReadFile(path)
+		exists := err == nil
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return false, err
+		}
+		if info, err := os.Stat(path); err == nil {
+			mode = info.Mode().Perm()
+		}
+
+		before, data := map[string]any{}, map[string]any{}
+		if exists {
+			if err := json.Unmarshal(original, &before); err != nil {
+				return false, fmt.Errorf("解析 %s 失败: %v", path, err)
+			}
+			json.Unmarshal(original, &data)
+		}
+		mutate(data)
+		if exists && reflect.DeepEqual(before, data) {
+			return false, nil
+		}
+		updated, err := json.MarshalIndent(data, "", "  ")
+		if err != nil {
+			return false, err
+		}
+
+		backup := path + claudeBackupSuffix + stamp
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return false, err
+		}
+		if err := os.WriteFile(backup, original, mode); err != nil {
+			return false, fmt.Errorf("备份 %s 失败: %v", path, err)
+		}
+
+		current, err := os.ReadFile(path)
+		if (err == nil) != exists || !bytes.Equal(current, original) {
+			os.Remove(backup)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		if err := writeFileAtomic(path, append(updated, '\n'), mode); err != nil {
+			os.Remove(backup)
+			return false, err
+		}
+		pruneClaudeBackups(path)
+		return true, nil
+	}
+	return false, fmt.Errorf("%s 一直在被其他程序修改，请关闭 Claude Code 后重试", path)
+}
+
+// writeFileAtomic 先写入同目录下的临时文件再重命名，其他程序不会读到写了一半的文件
+func writeFileAtomic(path string, data []byte, mode os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// claudeBackups 按时间从旧到新返回 path 的备份
+func claudeBackups(path string) []string {
+	backups, _ := filepath.Glob(path + claudeBackupSuffix + "*")
+	sort.Strings(backups)
+	return backups
+}
+
+// pruneClaudeBackups 只保留最近的 claudeBackupKeep 个备份
+func pruneClaudeBackups(path string) {
+	backups := claudeBackups(path)
+	for len(backups) > claudeBackupKeep {
+		os.Remove(backups[0])
+		backups = backups[1:]
+	}
+}
+
+// restoreClaudeConfig 用最近一次执行留下的备份恢复配置文件，使用过的备份被删除，
+// 再次执行会继续撤销更早的修改。返回恢复的文件
+func restoreClaudeConfig(paths ...string) ([]string, error) {
+	latest := ""
+	for _, path := range paths {
+		if backups := claudeBackups(path); len(backups) > 0 {
+			latest = max(latest, strings.TrimPrefix(backups[len(backups)-1], path+claudeBackupSuffix))
+		}
+	}
+	if latest == "" {
+		return nil, errors.New("没有可恢复的备份")
+	}
+
+	var restored []string
+	for _, path := range paths {
+		backup := path + claudeBackupSuffix + latest
+		data, err := os.ReadFile(backup)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return restored, err
+		}
+		// 空备份表示修改前文件不存在
+		if len(data) == 0 {
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return restored, err
+			}
+			err = os.Remove(backup)
+		} else {
+			err = os.Rename(backup, path)
+		}
+		if err != nil {
+			return restored, err
+		}
+		restored = append(restored, path)
+	}
+	return restored, nil
+}
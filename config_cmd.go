@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/bestk/kiro2cc/pkg/server"
+	"github.com/bestk/kiro2cc/pkg/token"
+)
+
+// runConfig 处理 config 子命令
+func runConfig(args []string) {
+	if len(args) == 0 || args[0] != "validate" {
+		fmt.Fprintf(os.Stderr, "用法: %s config validate [--strict] [server 参数] [port]\n", os.Args[0])
+		os.Exit(1)
+	}
+
+	fs := flag.NewFlagSet("config validate", flag.ExitOnError)
+	strict := fs.Bool("strict", false, "存在警告时也以非零状态退出")
+	addJSONFlag(fs, "以 JSON 输出校验结果，退出状态不变")
+	_, config := parseServerFlags(fs, args[1:])
+	if config.ProfileArn == "" {
+		config.ProfileArn = os.Getenv("KIRO_PROFILE_ARN")
+	}
+
+	problems, warnings := validateConfig(config, time.Now())
+	code := 0
+	switch {
+	case len(problems) > 0:
+		code = 1
+	case len(warnings) > 0 && *strict:
+		code = 2
+	}
+
+	if jsonOutput {
+		writeJSON(os.Stdout, validateOutput{Valid: code == 0, Errors: nonNil(problems), Warnings: nonNil(warnings)})
+		os.Exit(code)
+	}
+	for _, problem := range problems {
+		fmt.Printf("错误: %s\n", problem)
+	}
+	for _, warning := range warnings {
+		fmt.Printf("警告: %s\n", warning)
+	}
+	if code != 0 {
+		os.Exit(code)
+	}
+	fmt.Println("配置校验通过")
+}
+
+// validateOutput 是 config validate --json 的输出，valid 与退出状态为 0 一致
+type validateOutput struct {
+	Valid    bool     `json:"valid"`
+	Errors   []string `json:"errors"`
+	Warnings []string `json:"warnings"`
+}
+
+// nonNil 把 nil 切片换成空切片，JSON 中输出 [] 而不是 null
+func nonNil(items []string) []string {
+	if items == nil {
+		return []string{}
+	}
+	return items
+}
+
+// validateConfig 校验服务器配置和它引用的token文件，返回错误和警告，不会启动服务器
+func validateConfig(config server.Config, now time.Time) (problems, warnings []string) {
+	var configErr *server.ConfigError
+	if err := config.Validate(); errors.As(err, &configErr) {
+		problems = append(problems, configErr.Problems...)
+	} else if err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	tokenData, err := token.Read(config.TokenPath)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("TokenPath: %v", err))
+	} else {
+		if tokenData.AccessToken == "" {
+			problems = append(problems, fmt.Sprintf("TokenPath: %s 中缺少 accessToken", config.TokenPath))
+		}
+		if tokenData.RefreshToken == "" {
+			warnings = append(warnings, fmt.Sprintf("TokenPath: %s 中缺少 refreshToken，token 过期后无法自动刷新", config.TokenPath))
+		}
+		if tokenData.ExpiresAt != "" {
+			expiresAt, ok := tokenData.Expiry()
+			switch {
+			case !ok:
+				warnings = append(warnings, fmt.Sprintf("TokenPath: 无法解析过期时间 %q", tokenData.ExpiresAt))
+			case !expiresAt.After(now):
+				warnings = append(warnings, fmt.Sprintf("TokenPath: token 已于 %s 过期，请运行 refresh", expiresAt.Local().Format(time.DateTime)))
+			}
+		}
+	}
+
+	if config.ProfileArn == "" {
+		warnings = append(warnings, fmt.Sprintf("ProfileArn: 未设置 KIRO_PROFILE_ARN，将使用内置的 %s", server.DefaultProfileArn))
+	}
+	if config.AdminToken != "" && tokenData.AccessToken != "" && config.AdminToken == tokenData.AccessToken {
+		warnings = append(warnings, "AdminToken: 与 Kiro access token 相同，泄露管理 token 会同时泄露上游凭证")
+	}
+	return problems, warnings
+}
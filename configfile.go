@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configFilePortKey 是配置文件中监听端口的键，对应 server 命令的位置参数
+const configFilePortKey = "port"
+
+// loadServerConfigFile 读取 server --config 的 YAML 文件。键是 server 命令的参数名 (不带 --)，
+// 另有 port 表示监听端口；列表值用于可重复的参数，其他参数按逗号连接
+func loadServerConfigFile(path string) (map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]any)
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("解析 %s 失败: %v", path, err)
+	}
+	return values, nil
+}
+
+// applyServerConfigFile 把配置文件中的参数设置到 fs 上，命令行中已经给出的参数优先。
+// 返回配置文件中的端口，没有时为空
+func applyServerConfigFile(fs *flag.FlagSet, path string) (string, error) {
+	values, err := loadServerConfigFile(path)
+	if err != nil {
+		return "", err
+	}
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var port string
+	var problems []string
+	for _, name := range names {
+		value := values[name]
+		if name == configFilePortKey {
+			port = fmt.Sprint(value)
+			continue
+		}
+		f := fs.Lookup(name)
+		if f == nil || name == "config" {
+			problems = append(problems, fmt.Sprintf("%s: 不是 server 的参数", name))
+			continue
+		}
+		if explicit[name] || value == nil {
+			continue
+		}
+		items, isList := value.([]any)
+		if !isList {
+			items = []any{value}
+		}
+		if _, repeatable := f.Value.(*stringList); !repeatable && isList {
+			parts := make([]string, len(items))
+			for i, item := range items {
+				parts[i] = fmt.Sprint(item)
+			}
+			items = []any{strings.Join(parts, ",")}
+		}
+		for _, item := range items {
+			if err := fs.Set(name, fmt.Sprint(item)); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+				break
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return "", fmt.Errorf("%s: %s", path, strings.Join(problems, "; "))
+	}
+	return port, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bestk/kiro2cc/pkg/conformance"
+)
+
+// runDev 处理 dev 子命令，提供开发代理本身时使用的工具
+func runDev(args []string) {
+	if len(args) == 0 || args[0] != "conform" {
+		exitf(2, os.Stderr, "用法: %s dev conform [--proxy url] [--anthropic-key key] [请求.json ...]", os.Args[0])
+	}
+	runConform(args[1:])
+}
+
+// runConform 把同一组请求分别发给代理和真实的 Anthropic API，输出两者响应结构的差异。
+// 退出码 0 表示没有差异，1 表示存在差异，2 表示参数错误或有用例无法完成比较
+func runConform(args []string) {
+	fs := flag.NewFlagSet("dev conform", flag.ExitOnError)
+	proxyURL := fs.String("proxy", "", "已运行的 kiro2cc 服务器地址，留空时在本进程内启动")
+	proxyKey := fs.String("proxy-key", "", "请求代理时使用的 API key")
+	anthropicURL := fs.String("anthropic-url", "https://api.anthropic.com", "Anthropic API 地址")
+	anthropicKey := fs.String("anthropic-key", os.Getenv("KIRO2CC_CONFORMANCE_ANTHROPIC_KEY"), "真实的 Anthropic API key，默认读取环境变量 KIRO2CC_CONFORMANCE_ANTHROPIC_KEY")
+	model := fs.String("model", "claude-3-5-haiku-20241022", "内置用例使用的模型，两端都必须支持")
+	timeout := fs.Duration("timeout", 2*time.Minute, "每个请求的超时时间")
+	addJSONFlag(fs, "以 JSON 输出结果")
+	verbose := fs.Bool("verbose", false, "把本进程内服务器的日志输出到 stderr")
+	fs.Parse(args)
+
+	// 不读取 ANTHROPIC_API_KEY：export 命令会把它设为代理使用的占位值
+	if *anthropicKey == "" {
+		exitf(2, os.Stderr, "需要真实的 Anthropic API key: --anthropic-key 或环境变量 KIRO2CC_CONFORMANCE_ANTHROPIC_KEY")
+	}
+
+	scenarios := conformance.DefaultScenarios(*model)
+	if fs.NArg() > 0 {
+		scenarios = nil
+		for _, path := range fs.Args() {
+			body, err := os.ReadFile(path)
+			if err != nil {
+				exitf(2, os.Stderr, "读取请求文件失败: %v", err)
+			}
+			scenarios = append(scenarios, conformance.Scenario{Name: filepath.Base(path), Body: body})
+		}
+	}
+
+	out := quietServerLogs(*verbose)
+	baseURL := *proxyURL
+	if baseURL == "" {
+		var closeServer func()
+		var err error
+		baseURL, closeServer, err = startLocalServer()
+		if err != nil {
+			exitf(2, os.Stderr, "%v", err)
+		}
+		defer closeServer()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	results := conformance.Run(ctx, &http.Client{Timeout: *timeout},
+		conformance.Target{BaseURL: baseURL, APIKey: *proxyKey},
+		conformance.Target{BaseURL: strings.TrimRight(*anthropicURL, "/"), APIKey: *anthropicKey},
+		scenarios)
+
+	deviations, failed := 0, false
+	for _, r := range results {
+		deviations += len(r.Deviations)
+		failed = failed || r.Error != ""
+	}
+	if jsonOutput {
+		writeJSON(out, map[string]any{"results": results, "deviations": deviations})
+	} else {
+		conformance.WriteReport(out, results)
+		fmt.Fprintf(out, "\n共 %d 个用例，%d 处差异\n", len(results), deviations)
+	}
+
+	switch {
+	case failed:
+		os.Exit(2)
+	case deviations > 0:
+		os.Exit(1)
+	}
+}
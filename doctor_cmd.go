@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/bestk/kiro2cc/pkg/token"
+)
+
+// doctor 检查结果的状态
+const (
+	doctorOK      = "ok"
+	doctorWarning = "warning"
+	doctorError   = "error"
+)
+
+// doctorCheck 是 doctor 的一项检查结果
+type doctorCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// doctorOutput 是 doctor --json 的输出，ok 与退出状态为 0 一致
+type doctorOutput struct {
+	OK     bool          `json:"ok"`
+	Checks []doctorCheck `json:"checks"`
+}
+
+// runDoctor 检查 token 文件与 Kiro IDE 共用时的状态：token 是否可用、刷新锁是否残留、
+// 同一账号的其他缓存文件是否还保存着已经轮换掉的 refresh token。存在错误时以 1 退出
+func runDoctor(args []string) {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	addJSONFlag(fs, "以 JSON 输出检查结果，退出状态不变")
+	fs.Parse(args)
+
+	checks := doctorChecks(getTokenFilePath(), time.Now())
+	ok := true
+	for _, check := range checks {
+		if check.Status == doctorError {
+			ok = false
+		}
+	}
+
+	if jsonOutput {
+		writeJSON(os.Stdout, doctorOutput{OK: ok, Checks: checks})
+	} else {
+		labels := map[string]string{doctorOK: "正常", doctorWarning: "警告", doctorError: "错误"}
+		for _, check := range checks {
+			fmt.Printf("%s %s: %s\n", labels[check.Status], check.Name, check.Message)
+		}
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// doctorChecks 依次检查 token 文件、刷新锁和同一账号的其他缓存文件
+func doctorChecks(path string, now time.Time) []doctorCheck {
+	var checks []doctorCheck
+	add := func(name, status, format string, args ...any) {
+		checks = append(checks, doctorCheck{Name: name, Status: status, Message: fmt.Sprintf(format, args...)})
+	}
+
+	data, err := token.Read(path)
+	if err != nil {
+		add("token", doctorError, "%v", err)
+		return checks
+	}
+	modified := ""
+	if info, err := os.Stat(path); err == nil {
+		modified = fmt.Sprintf("，最近修改于 %s", info.ModTime().Local().Format(time.DateTime))
+	}
+	switch expiresAt, known := data.Expiry(); {
+	case data.AccessToken == "":
+		add("token", doctorError, "%s 中缺少 accessToken", path)
+	case data.RefreshToken == "":
+		add("token", doctorWarning, "%s 中缺少 refreshToken，token 过期后无法自动刷新", path)
+	case !known:
+		add("token", doctorWarning, "%s 的过期时间未知%s", path, modified)
+	case !expiresAt.After(now):
+		add("token", doctorWarning, "%s 已于 %s 过期%s，请运行 refresh 或在 Kiro 中重新登录", path, expiresAt.Local().Format(time.DateTime), modified)
+	default:
+		add("token", doctorOK, "%s 有效至 %s%s", path, expiresAt.Local().Format(time.DateTime), modified)
+	}
+
+	switch held, stale := token.LockState(path, now); {
+	case stale:
+		add("refresh-lock", doctorWarning, "%s 是崩溃的进程留下的锁文件，下次刷新时会自动清除", token.LockPath(path))
+	case held:
+		add("refresh-lock", doctorOK, "有进程正在刷新 token (%s)", token.LockPath(path))
+	default:
+		add("refresh-lock", doctorOK, "没有进行中的刷新")
+	}
+
+	siblings := token.FindSiblings(path, data)
+	stale := 0
+	for _, sibling := range siblings {
+		if sibling.Stale {
+			stale++
+			add("sibling-cache", doctorWarning, "%s 中的 refresh token 与 token 文件不同，可能已因轮换失效，需要在写入它的工具中重新登录", sibling.Path)
+		}
+	}
+	if stale == 0 {
+		add("sibling-cache", doctorOK, "同一账号的其他缓存文件 %d 个，refresh token 均与 token 文件一致", len(siblings))
+	}
+	return checks
+}
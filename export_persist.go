@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// exportedEnvVars 是 export 命令设置的环境变量
+var exportedEnvVars = []string{"ANTHROPIC_BASE_URL", "ANTHROPIC_API_KEY"}
+
+// persistUserEnv 在 Windows 上把环境变量写入当前用户的注册表环境，之后新打开的终端都能看到，
+// value 为空时删除该变量。通过 PowerShell 的 SetEnvironmentVariable 写入，它会通知其他程序环境已变化，
+// 也没有 setx 的 1024 字符限制。值通过子进程的环境变量传入，不出现在命令行中
+func persistUserEnv(name, value string) error {
+	script := fmt.Sprintf("[Environment]::SetEnvironmentVariable('%s', $env:KIRO2CC_PERSIST_VALUE, 'User')", name)
+	if value == "" {
+		script = fmt.Sprintf("[Environment]::SetEnvironmentVariable('%s', $null, 'User')", name)
+	}
+	cmd := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", script)
+	cmd.Env = append(os.Environ(), "KIRO2CC_PERSIST_VALUE="+value)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("设置 %s 失败: %v %s", name, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// persistExportedEnv 持久化 export 的环境变量，values 为 nil 时删除，--json 时结果由调用方输出
+func persistExportedEnv(values map[string]string) {
+	for _, name := range exportedEnvVars {
+		if err := persistUserEnv(name, values[name]); err != nil {
+			exitf(1, os.Stderr, "%v", err)
+		}
+	}
+	if jsonOutput {
+		return
+	}
+	if values == nil {
+		fmt.Printf("已删除用户环境变量 %s，新打开的终端生效\n", strings.Join(exportedEnvVars, ", "))
+		return
+	}
+	fmt.Printf("已保存用户环境变量 %s，新打开的终端生效\n", strings.Join(exportedEnvVars, ", "))
+}
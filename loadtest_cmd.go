@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bestk/kiro2cc/pkg/loadtest"
+	"github.com/bestk/kiro2cc/pkg/server"
+)
+
+// runLoadtest 处理 loadtest 子命令：按固定速率发送合成请求，输出延迟分布、错误分类和服务器内存。
+// 退出码 0 表示全部成功，1 表示有请求失败，2 表示参数错误
+func runLoadtest(args []string) {
+	fs := flag.NewFlagSet("loadtest", flag.ExitOnError)
+	rps := fs.Float64("rps", 2, "每秒发出的请求数")
+	duration := fs.Duration("duration", time.Minute, "压测时长")
+	stream := fs.Bool("stream", false, "发送流式请求")
+	target := fs.String("target", "", "已运行的 kiro2cc 服务器地址，留空时在本进程内启动")
+	mock := fs.Bool("mock", false, "在本进程内启动使用模拟上游的服务器，不访问 CodeWhisperer")
+	mockLatency := fs.Duration("mock-latency", 500*time.Millisecond, "模拟上游的响应延迟")
+	apiKey := fs.String("api-key", "", "请求服务器时使用的 API key")
+	adminToken := fs.String("admin-token", os.Getenv("KIRO2CC_ADMIN_TOKEN"), "读取 --target 服务器 /metrics 的 admin token")
+	model := fs.String("model", defaultChatModel, "请求使用的模型")
+	maxTokens := fs.Int("max-tokens", 64, "请求的 max_tokens")
+	prompt := fs.String("prompt", "Reply with a short greeting.", "请求的内容")
+	bodyFile := fs.String("body", "", "从文件读取完整的请求体，忽略 --model、--max-tokens、--prompt 和 --stream")
+	maxInFlight := fs.Int("max-inflight", loadtest.DefaultMaxInFlight, "同时进行的请求上限，超出的请求记为 dropped")
+	timeout := fs.Duration("timeout", 2*time.Minute, "单个请求的超时时间")
+	addJSONFlag(fs, "以 JSON 输出结果")
+	fs.Parse(args)
+
+	if *target != "" && *mock {
+		exitf(2, os.Stderr, "--target 和 --mock 不能同时使用")
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"model":      *model,
+		"max_tokens": *maxTokens,
+		"stream":     *stream,
+		"messages":   []map[string]string{{"role": "user", "content": *prompt}},
+	})
+	if *bodyFile != "" {
+		body, err = os.ReadFile(*bodyFile)
+	}
+	if err != nil {
+		exitf(2, os.Stderr, "读取请求体失败: %v", err)
+	}
+
+	// 压测期间服务器的逐请求日志没有意义，丢弃
+	out := quietServerLogs(false)
+	client := &http.Client{Timeout: *timeout}
+	opts := loadtest.Options{
+		BaseURL:     *target,
+		APIKey:      *apiKey,
+		RPS:         *rps,
+		Duration:    *duration,
+		Body:        body,
+		MaxInFlight: *maxInFlight,
+		Client:      client,
+		Progress:    os.Stderr,
+	}
+	if *target == "" {
+		var closeServer func()
+		if *mock {
+			opts.BaseURL, closeServer, err = startMockServer(*mockLatency)
+		} else {
+			opts.BaseURL, closeServer, err = startLocalServer()
+		}
+		if err != nil {
+			exitf(2, os.Stderr, "%v", err)
+		}
+		defer closeServer()
+		opts.Memory = loadtest.RuntimeMemory
+	} else {
+		opts.Memory = loadtest.MetricsMemory(client, strings.TrimRight(*target, "/")+"/metrics", *adminToken)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	fmt.Fprintf(os.Stderr, "以 %g 请求/秒向 %s 压测 %s，按 Ctrl+C 提前结束\n", *rps, opts.BaseURL, *duration)
+	report, err := loadtest.Run(ctx, opts)
+	if err != nil {
+		exitf(2, os.Stderr, "%v", err)
+	}
+
+	if jsonOutput {
+		writeJSON(out, report)
+	} else {
+		loadtest.WriteReport(out, report)
+	}
+	if report.Succeeded != report.Requests {
+		os.Exit(1)
+	}
+}
+
+// startMockServer 在本进程内启动使用模拟上游的服务器，使用临时 token 文件，不需要登录
+func startMockServer(latency time.Duration) (string, func(), error) {
+	dir, err := os.MkdirTemp("", "kiro2cc-loadtest")
+	if err != nil {
+		return "", nil, err
+	}
+	tokenPath := filepath.Join(dir, "token.json")
+	if err := os.WriteFile(tokenPath, []byte(`{"accessToken":"mock","refreshToken":"mock"}`), 0600); err != nil {
+		os.RemoveAll(dir)
+		return "", nil, err
+	}
+	srv, err := server.New(server.Config{
+		TokenPath: tokenPath,
+		Backend:   loadtest.MockBackend{Latency: latency, Text: "Hello! This response comes from the kiro2cc load test mock backend."},
+	})
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", nil, fmt.Errorf("创建服务器失败: %v", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", nil, fmt.Errorf("监听本地端口失败: %v", err)
+	}
+	httpServer := &http.Server{Handler: srv.Handler()}
+	go httpServer.Serve(ln)
+	return "http://" + ln.Addr().String(), func() {
+		httpServer.Close()
+		os.RemoveAll(dir)
+	}, nil
+}
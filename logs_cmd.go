@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// runLogs 处理 logs 子命令，从运行中的服务器读取最近的日志
+func runLogs(args []string) {
+	if len(args) == 0 || args[0] != "tail" {
+		exitf(1, os.Stderr, "用法: %s logs tail [-n 100] [-f] [--server url]", os.Args[0])
+	}
+
+	fs := flag.NewFlagSet("logs tail", flag.ExitOnError)
+	serverURL := fs.String("server", "http://localhost:8080", "kiro2cc 服务器地址，包括 --base-path")
+	adminToken := fs.String("admin-token", os.Getenv("KIRO2CC_ADMIN_TOKEN"), "管理接口的访问token")
+	lines := fs.Int("n", 100, "输出最近的行数，0 表示服务器保留的全部")
+	follow := fs.Bool("f", false, "持续输出新的日志，按 Ctrl+C 结束")
+	addJSONFlag(fs, "每行日志输出为一个 JSON 对象 (JSONL)")
+	fs.Parse(args[1:])
+
+	url := fmt.Sprintf("%s/admin/logs?lines=%d", strings.TrimRight(*serverURL, "/"), *lines)
+	if *follow {
+		url += "&follow=true"
+	}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		exitf(1, os.Stderr, "读取日志失败: %v", err)
+	}
+	if *adminToken != "" {
+		req.Header.Set("Authorization", "Bearer "+*adminToken)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		exitf(1, os.Stderr, "读取日志失败: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		exitf(1, os.Stderr, "读取日志失败: 状态码: %d, 响应: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	if jsonOutput {
+		if err := writeLogLines(os.Stdout, resp.Body); err != nil {
+			exitf(1, os.Stderr, "读取日志中断: %v", err)
+		}
+		return
+	}
+	// follow 时响应不会结束，逐块复制到标准输出
+	if _, err := io.Copy(os.Stdout, resp.Body); err != nil {
+		exitf(1, os.Stderr, "读取日志中断: %v", err)
+	}
+}
+
+// logLine 是 logs tail --json 时的一行输出
+type logLine struct {
+	Line string `json:"line"`
+}
+
+// writeLogLines 把 r 中的每行日志以单行 JSON 写入 w，follow 时逐行输出
+func writeLogLines(w io.Writer, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	for scanner.Scan() {
+		encoder.Encode(logLine{Line: scanner.Text()})
+	}
+	return scanner.Err()
+}
+
+// captureStdout 把之后写到标准输出和标准 log 的内容同时复制到 w，
+// 服务器的日志都直接打印到标准输出，这样不需要改动打印日志的代码
+func captureStdout(w io.Writer) error {
+	r, pw, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	original := os.Stdout
+	os.Stdout = pw
+	log.SetOutput(pw)
+	go io.Copy(io.MultiWriter(original, w), r)
+	return nil
+}
@@ -1,346 +1,28 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
-	jsonStr "encoding/json"
+	"context"
 	"flag"
 	"fmt"
 	"io"
-	"log"
-	"math/rand"
 	"net/http"
 	"os"
-	"path/filepath"
+	"os/signal"
 	"runtime"
+	"runtime/debug"
 	"strings"
 	"time"
 
-	"github.com/bestk/kiro2cc/parser"
+	"github.com/bestk/kiro2cc/pkg/server"
+	"github.com/bestk/kiro2cc/pkg/token"
 )
 
-// TokenData 表示token文件的结构
-type TokenData struct {
-	AccessToken  string `json:"accessToken"`
-	RefreshToken string `json:"refreshToken"`
-	ExpiresAt    string `json:"expiresAt,omitempty"`
-}
-
-// RefreshRequest 刷新token的请求结构
-type RefreshRequest struct {
-	RefreshToken string `json:"refreshToken"`
-}
-
-// RefreshResponse 刷新token的响应结构
-type RefreshResponse struct {
-	AccessToken  string `json:"accessToken"`
-	RefreshToken string `json:"refreshToken"`
-	ExpiresAt    string `json:"expiresAt,omitempty"`
-}
-
-// AnthropicTool 表示 Anthropic API 的工具结构
-type AnthropicTool struct {
-	Name        string         `json:"name"`
-	Description string         `json:"description"`
-	InputSchema map[string]any `json:"input_schema"`
-}
-
-// InputSchema 表示工具输入模式的结构
-type InputSchema struct {
-	Json map[string]any `json:"json"`
-}
-
-// ToolSpecification 表示工具规范的结构
-type ToolSpecification struct {
-	Name        string      `json:"name"`
-	Description string      `json:"description"`
-	InputSchema InputSchema `json:"inputSchema"`
-}
-
-// CodeWhispererTool 表示 CodeWhisperer API 的工具结构
-type CodeWhispererTool struct {
-	ToolSpecification ToolSpecification `json:"toolSpecification"`
-}
-
-// HistoryUserMessage 表示历史记录中的用户消息
-type HistoryUserMessage struct {
-	UserInputMessage struct {
-		Content string `json:"content"`
-		ModelId string `json:"modelId"`
-		Origin  string `json:"origin"`
-	} `json:"userInputMessage"`
-}
-
-// HistoryAssistantMessage 表示历史记录中的助手消息
-type HistoryAssistantMessage struct {
-	AssistantResponseMessage struct {
-		Content  string `json:"content"`
-		ToolUses []any  `json:"toolUses"`
-	} `json:"assistantResponseMessage"`
-}
-
-// AnthropicErrorResponse 表示 Anthropic API 的错误响应结构
-type AnthropicErrorResponse struct {
-	Type  string `json:"type"`
-	Error struct {
-		Type    string `json:"type"`
-		Message string `json:"message"`
-	} `json:"error"`
-}
-
-// AnthropicRequest 表示 Anthropic API 的请求结构
-type AnthropicRequest struct {
-	Model       string                    `json:"model"`
-	MaxTokens   int                       `json:"max_tokens"`
-	Messages    []AnthropicRequestMessage `json:"messages"`
-	System      []AnthropicSystemMessage  `json:"system,omitempty"`
-	Tools       []AnthropicTool           `json:"tools,omitempty"`
-	Stream      bool                      `json:"stream"`
-	Temperature *float64                  `json:"temperature,omitempty"`
-	Metadata    map[string]any            `json:"metadata,omitempty"`
-}
-
-// AnthropicStreamResponse 表示 Anthropic 流式响应的结构
-type AnthropicStreamResponse struct {
-	Type         string `json:"type"`
-	Index        int    `json:"index"`
-	ContentDelta struct {
-		Text string `json:"text"`
-		Type string `json:"type"`
-	} `json:"delta,omitempty"`
-	Content []struct {
-		Text string `json:"text"`
-		Type string `json:"type"`
-	} `json:"content,omitempty"`
-	StopReason   string `json:"stop_reason,omitempty"`
-	StopSequence string `json:"stop_sequence,omitempty"`
-	Usage        struct {
-		InputTokens  int `json:"input_tokens"`
-		OutputTokens int `json:"output_tokens"`
-	} `json:"usage,omitempty"`
-}
-
-// AnthropicRequestMessage 表示 Anthropic API 的消息结构
-type AnthropicRequestMessage struct {
-	Role    string `json:"role"`
-	Content any    `json:"content"` // 可以是 string 或 []ContentBlock
-}
-
-type AnthropicSystemMessage struct {
-	Type string `json:"type"`
-	Text string `json:"text"` // 可以是 string 或 []ContentBlock
-}
-
-// ContentBlock 表示消息内容块的结构
-type ContentBlock struct {
-	Type      string  `json:"type"`
-	Text      *string `json:"text,omitempty"`
-	ToolUseId *string `json:"tool_use_id,omitempty"`
-	Content   *string `json:"content,omitempty"`
-	Name      *string `json:"name,omitempty"`
-	Input     *any    `json:"input,omitempty"`
-}
-
-// getMessageContent 从消息中提取文本内容
-func getMessageContent(content any) string {
-	switch v := content.(type) {
-	case string:
-		if len(strings.TrimSpace(v)) == 0 {
-			return "Please provide a response."
-		}
-		return v
-	case []interface{}:
-		var texts []string
-		for _, block := range v {
-			if m, ok := block.(map[string]interface{}); ok {
-				var cb ContentBlock
-				if data, err := jsonStr.Marshal(m); err == nil {
-					if err := jsonStr.Unmarshal(data, &cb); err == nil {
-						switch cb.Type {
-						case "tool_result":
-							if cb.Content != nil {
-								texts = append(texts, *cb.Content)
-							}
-						case "text":
-							if cb.Text != nil {
-								texts = append(texts, *cb.Text)
-							}
-						case "tool_use":
-							// Skip tool_use blocks for content extraction
-							continue
-						}
-					}
-				}
-			}
-		}
-		if len(texts) == 0 {
-			s, err := jsonStr.Marshal(content)
-			if err != nil {
-				return "Please provide a response."
-			}
-			log.Printf("Unhandled content format: %s", string(s))
-			return "Please provide a response."
-		}
-		return strings.Join(texts, "\n")
-	default:
-		s, err := jsonStr.Marshal(content)
-		if err != nil {
-			return "Please provide a response."
-		}
-		log.Printf("Unhandled content type: %s", string(s))
-		return "Please provide a response."
-	}
-}
-
-// CodeWhispererRequest 表示 CodeWhisperer API 的请求结构
-type CodeWhispererRequest struct {
-	ConversationState struct {
-		ChatTriggerType string `json:"chatTriggerType"`
-		ConversationId  string `json:"conversationId"`
-		CurrentMessage  struct {
-			UserInputMessage struct {
-				Content                 string `json:"content"`
-				ModelId                 string `json:"modelId"`
-				Origin                  string `json:"origin"`
-				UserInputMessageContext struct {
-					ToolResults []struct {
-						Content []struct {
-							Text string `json:"text"`
-						} `json:"content"`
-						Status    string `json:"status"`
-						ToolUseId string `json:"toolUseId"`
-					} `json:"toolResults,omitempty"`
-					Tools []CodeWhispererTool `json:"tools,omitempty"`
-				} `json:"userInputMessageContext"`
-			} `json:"userInputMessage"`
-		} `json:"currentMessage"`
-		History []any `json:"history"`
-	} `json:"conversationState"`
-	ProfileArn string `json:"profileArn"`
-}
-
-// CodeWhispererEvent 表示 CodeWhisperer 的事件响应
-type CodeWhispererEvent struct {
-	ContentType string `json:"content-type"`
-	MessageType string `json:"message-type"`
-	Content     string `json:"content"`
-	EventType   string `json:"event-type"`
-}
-
-var ModelMap = map[string]string{
-	"claude-3-5-sonnet-20241022": "CLAUDE_3_5_SONNET_20241022_V2_0",
-	"claude-3-5-sonnet-20240620": "CLAUDE_3_5_SONNET_20240620_V1_0",
-	"claude-3-5-haiku-20241022":  "CLAUDE_3_5_HAIKU_20241022_V1_0",
-	"claude-3-opus-20240229":     "CLAUDE_3_OPUS_20240229_V1_0",
-	"claude-3-sonnet-20240229":   "CLAUDE_3_SONNET_20240229_V1_0",
-	"claude-3-haiku-20240307":    "CLAUDE_3_HAIKU_20240307_V1_0",
-	"claude-sonnet-4-20250514":   "CLAUDE_SONNET_4_20250514_V1_0",
-}
-
-// generateUUID generates a simple UUID v4
-func generateUUID() string {
-	b := make([]byte, 16)
-	rand.Read(b)
-	b[6] = (b[6] & 0x0f) | 0x40 // Version 4
-	b[8] = (b[8] & 0x3f) | 0x80 // Variant bits
-	return fmt.Sprintf("%08x-%04x-%04x-%04x-%012x", 
-		b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
-}
-
-// buildCodeWhispererRequest 构建 CodeWhisperer 请求
-func buildCodeWhispererRequest(anthropicReq AnthropicRequest) CodeWhispererRequest {
-	// 使用环境变量或默认ProfileArn
-	profileArn := os.Getenv("KIRO_PROFILE_ARN")
-	if profileArn == "" {
-		profileArn = "arn:aws:codewhisperer:us-east-1:699475941385:profile/EHGA3GRVQMUK"
-	}
-	
-	cwReq := CodeWhispererRequest{
-		ProfileArn: profileArn,
-	}
-	cwReq.ConversationState.ChatTriggerType = "MANUAL"
-	cwReq.ConversationState.ConversationId = generateUUID()
-	
-	// 确保获取最后一条用户消息
-	lastMessage := anthropicReq.Messages[len(anthropicReq.Messages)-1]
-	content := getMessageContent(lastMessage.Content)
-	
-	// 确保内容不为空
-	if strings.TrimSpace(content) == "" {
-		content = "Please provide a response."
-	}
-	
-	cwReq.ConversationState.CurrentMessage.UserInputMessage.Content = content
-	cwReq.ConversationState.CurrentMessage.UserInputMessage.ModelId = ModelMap[anthropicReq.Model]
-	cwReq.ConversationState.CurrentMessage.UserInputMessage.Origin = "AI_EDITOR"
-	// 处理 tools 信息
-	if len(anthropicReq.Tools) > 0 {
-		var tools []CodeWhispererTool
-		for _, tool := range anthropicReq.Tools {
-			cwTool := CodeWhispererTool{}
-			cwTool.ToolSpecification.Name = tool.Name
-			cwTool.ToolSpecification.Description = tool.Description
-			cwTool.ToolSpecification.InputSchema = InputSchema{
-				Json: tool.InputSchema,
-			}
-			tools = append(tools, cwTool)
-		}
-		cwReq.ConversationState.CurrentMessage.UserInputMessage.UserInputMessageContext.Tools = tools
-	}
-
-	// 构建历史消息
-	// 先处理 system 消息或者常规历史消息
-	if len(anthropicReq.System) > 0 || len(anthropicReq.Messages) > 1 {
-		var history []any
-
-		// 首先添加每个 system 消息作为独立的历史记录项
-
-		assistantDefaultMsg := HistoryAssistantMessage{}
-		assistantDefaultMsg.AssistantResponseMessage.Content = getMessageContent("I will follow these instructions")
-		assistantDefaultMsg.AssistantResponseMessage.ToolUses = make([]any, 0)
-
-		if len(anthropicReq.System) > 0 {
-			for _, sysMsg := range anthropicReq.System {
-				userMsg := HistoryUserMessage{}
-				userMsg.UserInputMessage.Content = sysMsg.Text
-				userMsg.UserInputMessage.ModelId = ModelMap[anthropicReq.Model]
-				userMsg.UserInputMessage.Origin = "AI_EDITOR"
-				history = append(history, userMsg)
-				history = append(history, assistantDefaultMsg)
-			}
-		}
-
-		// 然后处理常规消息历史
-		for i := 0; i < len(anthropicReq.Messages)-1; i++ {
-			if anthropicReq.Messages[i].Role == "user" {
-				userMsg := HistoryUserMessage{}
-				userMsg.UserInputMessage.Content = getMessageContent(anthropicReq.Messages[i].Content)
-				userMsg.UserInputMessage.ModelId = ModelMap[anthropicReq.Model]
-				userMsg.UserInputMessage.Origin = "AI_EDITOR"
-				history = append(history, userMsg)
-
-				// 检查下一条消息是否是助手回复
-				if i+1 < len(anthropicReq.Messages)-1 && anthropicReq.Messages[i+1].Role == "assistant" {
-					assistantMsg := HistoryAssistantMessage{}
-					assistantMsg.AssistantResponseMessage.Content = getMessageContent(anthropicReq.Messages[i+1].Content)
-					assistantMsg.AssistantResponseMessage.ToolUses = make([]any, 0)
-					history = append(history, assistantMsg)
-					i++ // 跳过已处理的助手消息
-				}
-			}
-		}
-
-		cwReq.ConversationState.History = history
-	}
-
-	return cwReq
-}
-
 var tokenFilePath string
 
 func main() {
 	// 定义命令行参数
 	flag.StringVar(&tokenFilePath, "f", "", "指定token文件路径")
+	flag.BoolVar(&jsonOutput, "json", false, "以 JSON 输出命令结果，便于脚本和图形界面解析，也可以写在命令名之后")
 	
 	// 自定义用法信息
 	flag.Usage = func() {
@@ -349,13 +31,30 @@ func main() {
 		flag.PrintDefaults()
 		fmt.Fprintf(os.Stderr, "\n命令:\n")
 		fmt.Fprintf(os.Stderr, "  read    - 读取并显示token\n")
-		fmt.Fprintf(os.Stderr, "  refresh - 刷新token\n")
-		fmt.Fprintf(os.Stderr, "  export  - 导出环境变量\n")
-		fmt.Fprintf(os.Stderr, "  claude  - 跳过 claude 地区限制\n")
-		fmt.Fprintf(os.Stderr, "  server [port] - 启动Anthropic API代理服务器 (默认端口: 8080)\n")
+		fmt.Fprintf(os.Stderr, "  refresh [--if-needed] [--min-validity 10m] - 刷新token，--if-needed 时只在即将过期时刷新\n")
+		fmt.Fprintf(os.Stderr, "  export [--port 8080] [--base-path /kiro] [--persist | --unset] - 导出环境变量，Windows 上可以保存到用户环境\n")
+		fmt.Fprintf(os.Stderr, "  claude [--models] [--model sonnet] [--small-fast-model haiku] - 跳过 claude 地区限制，--models 时设置代理支持的模型\n")
+		fmt.Fprintf(os.Stderr, "  transcript [--format markdown|jsonl] - 导出运行中服务器记录的最近对话\n")
+		fmt.Fprintf(os.Stderr, "  logs tail [-n 100] [-f] - 查看运行中服务器最近的日志\n")
+		fmt.Fprintf(os.Stderr, "  dev conform [--proxy url] [请求.json ...] - 对比代理与真实 Anthropic API 的响应结构\n")
+		fmt.Fprintf(os.Stderr, "  loadtest [--rps 2] [--duration 5m] [--stream] [--mock | --target url] - 压测代理并输出延迟分布、错误分类和内存\n")
+		fmt.Fprintf(os.Stderr, "  mcp     - 以 MCP 服务器模式运行 (stdio)，提供 sampling/createMessage\n")
+		fmt.Fprintf(os.Stderr, "  chat [--model m] [--server url] - 在终端中与模型对话，用于不安装 Claude Code 时验证代理\n")
+		fmt.Fprintf(os.Stderr, "  ask [-m model] \"问题\" - 发送一次请求并只输出回复文本，失败时以非零状态退出\n")
+		fmt.Fprintf(os.Stderr, "  batch [--concurrency n] - 从标准输入逐行读取 Anthropic 请求，逐行输出响应 (JSONL)\n")
+		fmt.Fprintf(os.Stderr, "  run <模板.yaml> [--var name=value] - 渲染提示词模板并发送请求\n")
+		fmt.Fprintf(os.Stderr, "  server [--websocket] [--grpc addr] [--compat] [port] - 启动Anthropic API代理服务器 (默认端口: 8080)\n")
+		fmt.Fprintf(os.Stderr, "  profile - 列出账号可用的 CodeWhisperer profile 并给出 KIRO_PROFILE_ARN\n")
+		fmt.Fprintf(os.Stderr, "  profile cpu|heap|goroutine|trace [30s] [--server url] [-o 文件] - 从运行中的服务器下载 pprof 分析数据\n")
+		fmt.Fprintf(os.Stderr, "  config validate [--strict] [server 参数] - 校验服务器配置和token，不启动服务器\n")
+		fmt.Fprintf(os.Stderr, "  tray [--port 8080] [--start] - 在系统托盘显示服务器状态和 token 过期时间 (需要 -tags tray 编译)\n")
+		fmt.Fprintf(os.Stderr, "  paths   - 显示 token 文件以及 kiro2cc 的配置、状态、缓存和日志目录\n")
+		fmt.Fprintf(os.Stderr, "  doctor  - 检查 token 文件、刷新锁以及与 Kiro IDE 共用的缓存文件是否一致\n")
+		fmt.Fprintf(os.Stderr, "  bootstrap --config 文件 --token-file 文件 [--start] - 创建目录、校验配置并安装 systemd 服务单元，用于无人值守部署\n")
 		fmt.Fprintf(os.Stderr, "\n示例:\n")
 		fmt.Fprintf(os.Stderr, "  %s read\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s -f /path/to/token.json refresh\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s read --json\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s server 9000\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "\nauthor: https://github.com/bestK/kiro2cc\n")
 	}
@@ -374,19 +73,46 @@ func main() {
 
 	switch command {
 	case "read":
-		readToken()
+		readToken(args[1:])
 	case "refresh":
-		refreshToken()
+		refreshToken(args[1:])
 	case "export":
-		exportEnvVars()
+		exportEnvVars(args[1:])
 	case "claude":
-		setClaude()
+		setClaude(args[1:])
+	case "mcp":
+		runMCP()
+	case "chat":
+		runChat(args[1:])
+	case "ask":
+		runAsk(args[1:])
+	case "batch":
+		runBatch(args[1:])
+	case "run":
+		runTemplate(args[1:])
 	case "server":
-		port := "8080" // 默认端口
-		if len(args) > 1 {
-			port = args[1]
-		}
-		startServer(port)
+		port, config := parseServerFlags(flag.NewFlagSet("server", flag.ExitOnError), args[1:])
+		startServer(port, config)
+	case "config":
+		runConfig(args[1:])
+	case "profile":
+		runProfile(args[1:])
+	case "transcript":
+		exportTranscript(args[1:])
+	case "logs":
+		runLogs(args[1:])
+	case "dev":
+		runDev(args[1:])
+	case "loadtest":
+		runLoadtest(args[1:])
+	case "paths":
+		printPaths(args[1:])
+	case "doctor":
+		runDoctor(args[1:])
+	case "bootstrap":
+		runBootstrap(args[1:])
+	case "tray":
+		runTray(args[1:])
 	default:
 		fmt.Fprintf(os.Stderr, "未知命令: %s\n\n", command)
 		flag.Usage()
@@ -394,835 +120,621 @@ func main() {
 	}
 }
 
-// getTokenFilePath 获取跨平台的token文件路径
-func getTokenFilePath() string {
-	// 如果通过 -f 参数指定了token文件路径，则使用指定的路径
-	if tokenFilePath != "" {
-		return tokenFilePath
+// parseServerFlags 在 serverFlags 上注册并解析 server 命令的参数，config validate 使用同一套参数
+func parseServerFlags(serverFlags *flag.FlagSet, args []string) (string, server.Config) {
+	configFile := serverFlags.String("config", "", "从 YAML 文件读取 server 参数，键为参数名 (例如 token-lock: file)，另有 port 表示端口，命令行参数优先")
+	enableWebSocket := serverFlags.Bool("websocket", false, "开启 /v1/messages/ws WebSocket 流式端点")
+	websocketOrigins := serverFlags.String("websocket-origins", "", "允许浏览器连接 WebSocket 端点的其他来源，逗号分隔，例如 https://chat.example.com")
+	basePath := serverFlags.String("base-path", os.Getenv("KIRO2CC_BASE_PATH"), "所有端点的挂载前缀，部署在反向代理子路径后面时使用，例如 /kiro")
+	grpcAddr := serverFlags.String("grpc", "", "同时在指定地址启动 gRPC 服务，例如 :9090")
+	surfaces := serverFlags.String("surfaces", "", "主端口上开启的接口类别，逗号分隔 (anthropic, admin)，为空时全部开启")
+	listen := serverFlags.String("listen", "", "额外的监听地址及其接口类别，例如 127.0.0.1:9091=admin,:8081=anthropic")
+	compat := serverFlags.Bool("compat", false, "兼容 LangChain/LiteLLM 等框架的请求格式差异")
+	compatMaxTokens := serverFlags.Int("compat-max-tokens", server.DefaultCompatMaxTokens, "兼容模式下缺少 max_tokens 时的默认值")
+	defaultMaxTokens := serverFlags.String("default-max-tokens", "", "请求缺少 max_tokens 时按模型使用的默认值，例如 sonnet-4=8192,haiku=4096,*=4096")
+	adminToken := serverFlags.String("admin-token", os.Getenv("KIRO2CC_ADMIN_TOKEN"), "管理接口的访问token，为空时只允许本机访问")
+	signingSecret := serverFlags.String("signing-secret", os.Getenv("KIRO2CC_SIGNING_SECRET"), "用该密钥对 /v1/ 响应体做 HMAC-SHA256 签名，放在 x-kiro2cc-signature 中，为空时不签名")
+	requireAPIKey := serverFlags.Bool("require-api-key", false, "只接受携带本地 API key (kiro2cc export 输出的 ANTHROPIC_API_KEY) 的请求")
+	jwtSecret := serverFlags.String("jwt-secret", os.Getenv("KIRO2CC_JWT_SECRET"), "接受用该密钥以 HS256 签名的 JWT 作为客户端凭证")
+	jwksURL := serverFlags.String("jwks-url", "", "接受用该 JWKS 地址中的公钥签名的 JWT (RS256/ES256)，例如组织 SSO 的 jwks_uri")
+	jwtIssuer := serverFlags.String("jwt-issuer", "", "要求 JWT 的 iss 为该值")
+	jwtAudience := serverFlags.String("jwt-audience", "", "要求 JWT 的 aud 包含该值")
+	jwtSubjectClaim := serverFlags.String("jwt-subject-claim", server.DefaultJWTSubjectClaim, "作为用量身份的 JWT claim")
+	jwtTierClaim := serverFlags.String("jwt-tier-claim", server.DefaultJWTTierClaim, "作为限流档位的 JWT claim")
+	rateLimits := serverFlags.String("rate-limits", "", "按客户端身份的档位限制每分钟请求数，例如 free=10,pro=120,*=30")
+	quietHours := serverFlags.String("quiet-hours", "", "每周重复的维护时段，期间拒绝新请求，例如 22:00-07:00,sat-sun 00:00-24:00")
+	quietHoursTZ := serverFlags.String("quiet-hours-tz", "", "解释 --quiet-hours 的时区，例如 Asia/Shanghai，默认使用本机时区")
+	maintenanceMessage := serverFlags.String("maintenance-message", "", "维护期间返回给客户端的说明")
+	logBodies := serverFlags.Bool("log-bodies", false, "在日志中打印完整的请求体和上游响应体，默认只记录请求体的 SHA-256")
+	tenantsFile := serverFlags.String("tenants", "", "租户配置文件 (JSON)，按租户分组 API key 并设置模型范围、限流、预算和上游绑定")
+	transcripts := serverFlags.Int("transcripts", 0, "在内存中保留最近 N 次对话供导出，0 表示关闭")
+	debug := serverFlags.Bool("debug", false, "在响应头中暴露上游诊断信息 (x-kiro2cc-*)，并允许 GET /v1/messages?prompt=... 调试请求")
+	idempotencyTTL := serverFlags.Duration("idempotency-ttl", server.DefaultIdempotencyTTL, "按 Idempotency-Key 缓存响应的时长，负数表示关闭")
+	dedupWindow := serverFlags.Duration("dedup-window", 0, "在该时间窗口内复用相同的进行中请求，例如 30s，0 表示关闭")
+	tlsCert := serverFlags.String("tls-cert", "", "TLS 证书文件，与 --tls-key 一起使用时以 HTTPS 监听并启用 HTTP/2")
+	tlsKey := serverFlags.String("tls-key", "", "TLS 私钥文件")
+	h2cEnabled := serverFlags.Bool("h2c", false, "明文监听时接受 HTTP/2 (h2c)")
+	origin := serverFlags.String("origin", server.OriginAIEditor, "发送给 CodeWhisperer 的消息来源")
+	toolFreeOrigin := serverFlags.String("tool-free-origin", "", "没有工具定义的请求改用的来源，例如 CHAT，为空时不区分")
+	toolFreeMaxTokens := serverFlags.Int("tool-free-max-tokens", 0, "只有 max_tokens 不超过该值的无工具请求才使用 --tool-free-origin，0 表示不限")
+	chatTriggerType := serverFlags.String("chat-trigger-type", server.ChatTriggerManual, "发送给 CodeWhisperer 的触发类型: MANUAL, DIAGNOSTIC, INLINE_CHAT")
+	toolResultTriggerType := serverFlags.String("tool-result-trigger-type", "", "只回传 tool_result 的 agent 后续请求改用的触发类型，为空时不区分")
+	sessionFile := serverFlags.String("session-file", "", "为同一会话的多轮请求使用固定的 conversationId，并把映射保存到该文件，重启后继续生效")
+	stateDir := serverFlags.String("state-dir", "", "持久状态目录，例如 kiro2cc paths 显示的状态目录，会话映射、幂等响应和租户用量保存在其中的 state.db")
+	redisURL := serverFlags.String("redis", os.Getenv("KIRO2CC_REDIS_URL"), "多副本部署时共享会话映射的 Redis 地址，例如 redis://:password@host:6379/0")
+	auth := serverFlags.String("auth", server.AuthBearer, "上游认证方式: bearer (Kiro token 文件), sigv4 (AWS IAM 凭证签名)")
+	awsProfile := serverFlags.String("aws-profile", "", "sigv4 模式使用的 AWS 配置 profile，为空时使用环境变量中的凭证或 AWS_PROFILE")
+	tokenLock := serverFlags.String("token-lock", server.TokenLockProcess, "多副本共享 token 时刷新的协调方式: process, file (共享卷上的锁文件), redis (需要 --redis)")
+	sseWriteTimeout := serverFlags.Duration("sse-write-timeout", server.DefaultSSEWriteTimeout, "写出单个 SSE 事件的期限，负数表示不限")
+	sseBuffer := serverFlags.Int("sse-buffer", 0, "SSE 事件缓冲数，缓冲写满时断开读取过慢的客户端，0 表示直接写出")
+	honorAccept := serverFlags.Bool("honor-accept", false, "Accept 头只接受 JSON 或 SSE 之一时以 Accept 为准，而不是请求体的 stream")
+	bufferStreams := serverFlags.Bool("buffer-streams", false, "流式请求在代理内部完成后以单个 JSON 返回，用于会缓冲 SSE 的中间设备之后的客户端")
+	agentCommands := serverFlags.String("agent-commands", "", "开启 /v1/agent/messages，允许模型执行的本地程序，逗号分隔，例如 ls,cat,git")
+	agentFetchHosts := serverFlags.String("agent-fetch-hosts", "", "agent 模式 http_fetch 允许访问的主机，逗号分隔")
+	var agentMCPServers stringList
+	serverFlags.Var(&agentMCPServers, "agent-mcp", "agent 模式连接的 MCP 服务器命令 (stdio)，可重复指定，例如 \"npx -y @modelcontextprotocol/server-filesystem /tmp\"")
+	agentMaxSteps := serverFlags.Int("agent-max-steps", server.DefaultAgentMaxSteps, "agent 模式最多执行工具的轮数")
+	maxContinuations := serverFlags.Int("max-continuations", 0, "上游提前结束纯文本回复时自动续写的最多次数，0 表示不续写")
+	structuredRetries := serverFlags.Int("structured-retries", server.DefaultStructuredOutputRetries, "带 response_format 的请求输出不符合 schema 时的重试次数，负数表示不重试")
+	systemPrefix := serverFlags.String("system-prefix", "", "注入到每个请求最前面的 system 提示，例如组织策略，客户端不可见")
+	systemPrefixFile := serverFlags.String("system-prefix-file", "", "从文件读取 --system-prefix 的内容")
+	responseLanguage := serverFlags.String("response-language", "", "要求模型始终使用该语言回复，例如 zh、en")
+	responseLanguageKeys := serverFlags.String("response-language-keys", "", "按客户端 API key 覆盖 --response-language，例如 key1=en,key2=off")
+	keyModels := serverFlags.String("key-models", "", "按客户端 API key 限制可用的模型，例如 key1=haiku,key2=!opus (! 表示禁止)")
+	extraBodyField := serverFlags.String("extra-body-field", "", "允许客户端在该请求字段 (例如 extra_body) 中传入合并到 CodeWhisperer 请求的对象，为空时关闭")
+	requestHook := serverFlags.String("request-hook", "", "变换请求的外部命令，请求 JSON 写入标准输入，输出新的请求 JSON，退出码 2 表示拒绝")
+	responseHook := serverFlags.String("response-hook", "", "变换模型输出文本的外部命令，输入 {\"request\":...,\"text\":...}，输出 {\"text\":...}")
+	hookTimeout := serverFlags.Duration("hook-timeout", server.DefaultHookTimeout, "单次执行钩子的期限")
+	hookFailure := serverFlags.String("hook-failure", server.HookFailureReject, "钩子执行失败时的处理方式: reject (拒绝请求), ignore (按原内容继续)")
+	eventHistory := serverFlags.Int("event-history", server.DefaultEventHistory, "在内存中保留上游事件的最近请求数，供 /admin/requests/{id}/events 排查，负数表示关闭")
+	captureUpstream := serverFlags.Bool("capture-upstream", false, "同时保留上游响应的原始字节，用于排查解析问题")
+	rawCaptureLimit := serverFlags.Int("raw-capture-limit", server.DefaultRawCaptureLimit, "设置 --state-dir 时保留的解析失败的上游原始响应文件数，负数关闭")
+	rawCaptureRetention := serverFlags.Duration("raw-capture-retention", server.DefaultRawCaptureRetention, "解析失败的上游原始响应文件的保留时长")
+	leakThreshold := serverFlags.Int("goroutine-leak-threshold", server.DefaultGoroutineLeakThreshold, "goroutine 数量超出预期多少个时打印疑似泄漏的警告，负数表示关闭")
+	slowThreshold := serverFlags.Duration("slow-request-threshold", server.DefaultSlowRequestThreshold, "上游调用超过该时长时打印警告并记入 /admin/requests/slow，负数表示关闭")
+	logBuffer := serverFlags.Int("log-buffer", server.DefaultLogBufferLines, "在内存中保留的最近日志行数，供 logs tail 查看，负数表示关闭")
+	skipPreflight := serverFlags.Bool("skip-preflight", false, "跳过启动前对 token 和上游的预检")
+	emptyContent := serverFlags.String("empty-content", server.EmptyContentStrict, "内容为空的消息的处理方式: strict, drop, substitute")
+	contextOverflow := serverFlags.String("context-overflow", server.ContextOverflowReject, "估算的输入超过模型上下文窗口时: reject 返回错误, trim 丢弃最早的历史消息")
+	serverFlags.Parse(args)
+
+	var filePort string
+	if *configFile != "" {
+		var err error
+		if filePort, err = applyServerConfigFile(serverFlags, *configFile); err != nil {
+			fmt.Fprintf(os.Stderr, "--config: %v\n", err)
+			os.Exit(1)
+		}
 	}
 
-	// 否则使用默认路径
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		fmt.Printf("获取用户目录失败: %v\n", err)
-		os.Exit(1)
+	if *systemPrefixFile != "" {
+		data, err := os.ReadFile(*systemPrefixFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "读取 system 前缀文件失败: %v\n", err)
+			os.Exit(1)
+		}
+		*systemPrefix = strings.TrimSpace(string(data))
 	}
 
-	return filepath.Join(homeDir, ".aws", "sso", "cache", "kiro-auth-token.json")
-}
-
-// readToken 读取并显示token信息
-func readToken() {
-	tokenPath := getTokenFilePath()
-
-	data, err := os.ReadFile(tokenPath)
+	maxTokensDefaults, err := server.ParseMaxTokensDefaults(*defaultMaxTokens)
 	if err != nil {
-		fmt.Printf("读取token文件失败: %v\n", err)
+		fmt.Fprintf(os.Stderr, "--default-max-tokens: %v\n", err)
 		os.Exit(1)
 	}
 
-	var token TokenData
-	if err := jsonStr.Unmarshal(data, &token); err != nil {
-		fmt.Printf("解析token文件失败: %v\n", err)
+	languageKeys, err := server.ParseResponseLanguageKeys(*responseLanguageKeys)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "--response-language-keys: %v\n", err)
 		os.Exit(1)
 	}
-
-	fmt.Println("Token信息:")
-	fmt.Printf("Access Token: %s\n", token.AccessToken)
-	fmt.Printf("Refresh Token: %s\n", token.RefreshToken)
-	if token.ExpiresAt != "" {
-		fmt.Printf("过期时间: %s\n", token.ExpiresAt)
-	}
-}
-
-// refreshToken 刷新token
-func refreshToken() {
-	tokenPath := getTokenFilePath()
-
-	// 读取当前token
-	data, err := os.ReadFile(tokenPath)
+	modelAccess, err := server.ParseKeyModels(*keyModels)
 	if err != nil {
-		fmt.Printf("读取token文件失败: %v\n", err)
+		fmt.Fprintf(os.Stderr, "--key-models: %v\n", err)
 		os.Exit(1)
 	}
-
-	var currentToken TokenData
-	if err := jsonStr.Unmarshal(data, &currentToken); err != nil {
-		fmt.Printf("解析token文件失败: %v\n", err)
+	windows, err := server.ParseQuietHours(*quietHours)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "--quiet-hours: %v\n", err)
 		os.Exit(1)
 	}
-
-	// 准备刷新请求
-	refreshReq := RefreshRequest{
-		RefreshToken: currentToken.RefreshToken,
+	var quietLocation *time.Location
+	if *quietHoursTZ != "" {
+		quietLocation, err = time.LoadLocation(*quietHoursTZ)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "--quiet-hours-tz: %v\n", err)
+			os.Exit(1)
+		}
 	}
-
-	reqBody, err := jsonStr.Marshal(refreshReq)
+	limits, err := server.ParseRateLimits(*rateLimits)
 	if err != nil {
-		fmt.Printf("序列化请求失败: %v\n", err)
+		fmt.Fprintf(os.Stderr, "--rate-limits: %v\n", err)
 		os.Exit(1)
 	}
-
-	// 发送刷新请求
-	resp, err := http.Post(
-		"https://prod.us-east-1.auth.desktop.kiro.dev/refreshToken",
-		"application/json",
-		bytes.NewBuffer(reqBody),
-	)
+	listeners, err := server.ParseListeners(*listen)
 	if err != nil {
-		fmt.Printf("刷新token请求失败: %v\n", err)
+		fmt.Fprintf(os.Stderr, "--listen: %v\n", err)
 		os.Exit(1)
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		fmt.Printf("刷新token失败，状态码: %d, 响应: %s\n", resp.StatusCode, string(body))
-		os.Exit(1)
+	var apiKeys []string
+	if *requireAPIKey {
+		key, err := server.LoadOrCreateLocalAPIKey(localAPIKeyPath())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "--require-api-key: 读取本地 API key 失败: %v\n", err)
+			os.Exit(1)
+		}
+		apiKeys = []string{key}
+	}
+
+	port := "8080" // 默认端口
+	if filePort != "" {
+		port = filePort
+	}
+	if serverFlags.NArg() > 0 {
+		port = serverFlags.Arg(0)
+	}
+	return port, server.Config{
+		Addr:                    ":" + port,
+		TokenPath:               getTokenFilePath(),
+		BasePath:                *basePath,
+		EnableWebSocket:         *enableWebSocket,
+		WebSocketOrigins:        splitList(*websocketOrigins),
+		GRPCAddr:                *grpcAddr,
+		Surfaces:                splitList(*surfaces),
+		Listeners:               listeners,
+		CompatMode:              *compat,
+		CompatMaxTokens:         *compatMaxTokens,
+		DefaultMaxTokens:        maxTokensDefaults,
+		APIKeys:                 apiKeys,
+		JWTSecret:               *jwtSecret,
+		JWKSURL:                 *jwksURL,
+		JWTIssuer:               *jwtIssuer,
+		JWTAudience:             *jwtAudience,
+		JWTSubjectClaim:         *jwtSubjectClaim,
+		JWTTierClaim:            *jwtTierClaim,
+		RateLimits:              limits,
+		TenantsFile:             *tenantsFile,
+		LogBodies:               *logBodies,
+		QuietHours:              windows,
+		QuietHoursLocation:      quietLocation,
+		MaintenanceMessage:      *maintenanceMessage,
+		AdminToken:              *adminToken,
+		SigningSecret:           *signingSecret,
+		TranscriptLimit:         *transcripts,
+		Debug:                   *debug,
+		EmptyContent:            *emptyContent,
+		ContextOverflow:         *contextOverflow,
+		IdempotencyTTL:          *idempotencyTTL,
+		DedupWindow:             *dedupWindow,
+		TLSCertFile:             *tlsCert,
+		TLSKeyFile:              *tlsKey,
+		H2C:                     *h2cEnabled,
+		Origin:                  *origin,
+		ToolFreeOrigin:          *toolFreeOrigin,
+		ToolFreeMaxTokens:       *toolFreeMaxTokens,
+		ChatTriggerType:         *chatTriggerType,
+		ToolResultTriggerType:   *toolResultTriggerType,
+		SessionFile:             *sessionFile,
+		StateDir:                *stateDir,
+		RedisURL:                *redisURL,
+		Auth:                    *auth,
+		AWSProfile:              *awsProfile,
+		TokenLock:               *tokenLock,
+		SSEWriteTimeout:         *sseWriteTimeout,
+		SSEBufferEvents:         *sseBuffer,
+		HonorAccept:             *honorAccept,
+		BufferStreams:           *bufferStreams,
+		StructuredOutputRetries: *structuredRetries,
+		MaxContinuations:        *maxContinuations,
+		SystemPrefix:            *systemPrefix,
+		ResponseLanguage:        *responseLanguage,
+		ResponseLanguageKeys:    languageKeys,
+		KeyModels:               modelAccess,
+		ExtraBodyField:          *extraBodyField,
+		RequestHook:             *requestHook,
+		ResponseHook:            *responseHook,
+		HookTimeout:             *hookTimeout,
+		HookFailure:             *hookFailure,
+		AgentCommands:           splitList(*agentCommands),
+		AgentFetchHosts:         splitList(*agentFetchHosts),
+		AgentMCPServers:         agentMCPServers,
+		AgentMaxSteps:           *agentMaxSteps,
+		EventHistory:            *eventHistory,
+		CaptureUpstream:         *captureUpstream,
+		RawCaptureLimit:         *rawCaptureLimit,
+		RawCaptureRetention:     *rawCaptureRetention,
+		GoroutineLeakThreshold:  *leakThreshold,
+		LogBufferLines:          *logBuffer,
+		SlowRequestThreshold:    *slowThreshold,
+		SkipPreflight:           *skipPreflight,
+	}
+}
+
+// splitList 把逗号分隔的参数拆分为列表，忽略空项
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
 	}
+	return items
+}
 
-	// 解析响应
-	var refreshResp RefreshResponse
-	if err := jsonStr.NewDecoder(resp.Body).Decode(&refreshResp); err != nil {
-		fmt.Printf("解析刷新响应失败: %v\n", err)
-		os.Exit(1)
+// getTokenFilePath 获取跨平台的token文件路径
+func getTokenFilePath() string {
+	// 如果通过 -f 参数指定了token文件路径，则使用指定的路径
+	if tokenFilePath != "" {
+		return tokenFilePath
 	}
 
-	// 更新token文件
-	newToken := TokenData(refreshResp)
-
-	newData, err := jsonStr.MarshalIndent(newToken, "", "  ")
+	// 否则使用默认路径
+	path, err := token.DefaultPath()
 	if err != nil {
-		fmt.Printf("序列化新token失败: %v\n", err)
+		fmt.Printf("%v\n", err)
 		os.Exit(1)
 	}
 
-	if err := os.WriteFile(tokenPath, newData, 0600); err != nil {
-		fmt.Printf("写入token文件失败: %v\n", err)
-		os.Exit(1)
-	}
+	return path
+}
 
-	fmt.Println("Token刷新成功!")
-	fmt.Printf("新的Access Token: %s\n", newToken.AccessToken)
+// tokenOutput 是 read --json 的输出
+type tokenOutput struct {
+	Path         string `json:"path"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	// ExpiresAt 是 UTC 的过期时间，无法解析时为文件中的原值
+	ExpiresAt string `json:"expires_at,omitempty"`
+	// Expired 只在过期时间可以解析时出现
+	Expired *bool `json:"expired,omitempty"`
 }
 
-// refreshTokenSilently 静默刷新token，用于服务器内部调用
-func refreshTokenSilently() error {
-	tokenPath := getTokenFilePath()
+// readToken 读取并显示token信息
+func readToken(args []string) {
+	fs := flag.NewFlagSet("read", flag.ExitOnError)
+	addJSONFlag(fs, "以 JSON 输出 token 信息")
+	fs.Parse(args)
 
-	// 读取当前token
-	data, err := os.ReadFile(tokenPath)
+	path := getTokenFilePath()
+	tokenData, err := token.Read(path)
 	if err != nil {
-		return fmt.Errorf("读取token文件失败: %v", err)
+		exitf(1, os.Stdout, "%v", err)
 	}
 
-	var currentToken TokenData
-	if err := jsonStr.Unmarshal(data, &currentToken); err != nil {
-		return fmt.Errorf("解析token文件失败: %v", err)
-	}
-
-	// 准备刷新请求
-	refreshReq := RefreshRequest{
-		RefreshToken: currentToken.RefreshToken,
+	if jsonOutput {
+		out := tokenOutput{Path: path, AccessToken: tokenData.AccessToken, RefreshToken: tokenData.RefreshToken, ExpiresAt: tokenData.ExpiresAt}
+		if expiresAt, ok := tokenData.Expiry(); ok {
+			expired := !expiresAt.After(time.Now())
+			out.ExpiresAt, out.Expired = jsonTime(expiresAt), &expired
+		}
+		writeJSON(os.Stdout, out)
+		return
 	}
 
-	reqBody, err := jsonStr.Marshal(refreshReq)
-	if err != nil {
-		return fmt.Errorf("序列化请求失败: %v", err)
-	}
+	fmt.Println("Token信息:")
+	fmt.Printf("Access Token: %s\n", tokenData.AccessToken)
+	fmt.Printf("Refresh Token: %s\n", tokenData.RefreshToken)
+	if expiresAt, ok := tokenData.Expiry(); ok {
+		fmt.Printf("过期时间: %s\n", expiresAt.Local().Format(time.DateTime))
+	} else if tokenData.ExpiresAt != "" {
+		fmt.Printf("过期时间: %s (无法解析)\n", tokenData.ExpiresAt)
+	}
+}
+
+// refresh --if-needed 的退出码，便于在 cron 中区分结果
+const (
+	// exitRefreshNotNeeded 表示 token 仍然有效，没有发起网络请求
+	exitRefreshNotNeeded = 0
+	// exitRefreshFailed 表示读取或刷新 token 失败
+	exitRefreshFailed = 1
+	// exitRefreshed 表示 token 即将过期或过期时间未知，已经刷新
+	exitRefreshed = 3
+)
 
-	// 发送刷新请求
-	resp, err := http.Post(
-		"https://prod.us-east-1.auth.desktop.kiro.dev/refreshToken",
-		"application/json",
-		bytes.NewBuffer(reqBody),
-	)
-	if err != nil {
-		return fmt.Errorf("刷新token请求失败: %v", err)
-	}
-	defer resp.Body.Close()
+// refreshOutput 是 refresh --json 的输出
+type refreshOutput struct {
+	Refreshed   bool   `json:"refreshed"`
+	AccessToken string `json:"access_token,omitempty"`
+	ExpiresAt   string `json:"expires_at,omitempty"`
+}
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("刷新token失败，状态码: %d, 响应: %s", resp.StatusCode, string(body))
-	}
+// refreshToken 刷新token，--if-needed 时只在 token 剩余有效期不足 --min-validity 时刷新
+func refreshToken(args []string) {
+	fs := flag.NewFlagSet("refresh", flag.ExitOnError)
+	ifNeeded := fs.Bool("if-needed", false, "token 剩余有效期足够时不刷新，并以退出码区分结果: 0 未刷新, 3 已刷新, 1 失败")
+	minValidity := fs.Duration("min-validity", 10*time.Minute, "--if-needed 时要求 token 至少还能使用的时长")
+	addJSONFlag(fs, "以 JSON 输出刷新结果，退出码不变")
+	fs.Parse(args)
 
-	// 解析响应
-	var refreshResp RefreshResponse
-	if err := jsonStr.NewDecoder(resp.Body).Decode(&refreshResp); err != nil {
-		return fmt.Errorf("解析刷新响应失败: %v", err)
+	path := getTokenFilePath()
+	if *ifNeeded {
+		current, err := token.Read(path)
+		if err != nil {
+			exitf(exitRefreshFailed, os.Stdout, "%v", err)
+		}
+		if current.ValidFor(time.Now(), *minValidity) {
+			expiresAt, _ := current.Expiry()
+			if jsonOutput {
+				writeJSON(os.Stdout, refreshOutput{ExpiresAt: jsonTime(expiresAt)})
+			} else {
+				fmt.Printf("Token仍然有效，过期时间: %s，无需刷新\n", expiresAt.Local().Format(time.DateTime))
+			}
+			os.Exit(exitRefreshNotNeeded)
+		}
 	}
 
-	// 更新token文件
-	newToken := TokenData(refreshResp)
-	newData, err := jsonStr.MarshalIndent(newToken, "", "  ")
+	// 与 --token-lock file 的服务器和托盘共用 token 文件旁的锁文件，不会同时刷新
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	newToken, err := token.RefreshCoordinated(ctx, path, token.NewFileCoordinator(path))
 	if err != nil {
-		return fmt.Errorf("序列化新token失败: %v", err)
-	}
-
-	if err := os.WriteFile(tokenPath, newData, 0600); err != nil {
-		return fmt.Errorf("写入token文件失败: %v", err)
+		exitf(exitRefreshFailed, os.Stdout, "%v", err)
 	}
 
-	fmt.Printf("Token已静默刷新\n")
-	return nil
-}
-
-// exportEnvVars 导出环境变量
-func exportEnvVars() {
-	tokenPath := getTokenFilePath()
-
-	data, err := os.ReadFile(tokenPath)
-	if err != nil {
-		fmt.Printf("读取 token失败,请先安装 Kiro 并登录！: %v\n", err)
-		os.Exit(1)
+	if jsonOutput {
+		out := refreshOutput{Refreshed: true, AccessToken: newToken.AccessToken}
+		if expiresAt, ok := newToken.Expiry(); ok {
+			out.ExpiresAt = jsonTime(expiresAt)
+		}
+		writeJSON(os.Stdout, out)
+	} else {
+		fmt.Println("Token刷新成功!")
+		fmt.Printf("新的Access Token: %s\n", newToken.AccessToken)
 	}
-
-	var token TokenData
-	if err := jsonStr.Unmarshal(data, &token); err != nil {
-		fmt.Printf("解析token文件失败: %v\n", err)
-		os.Exit(1)
+	if *ifNeeded {
+		os.Exit(exitRefreshed)
 	}
+}
 
-	// 根据操作系统输出不同格式的环境变量设置命令
-	if runtime.GOOS == "windows" {
-		fmt.Println("CMD")
-		fmt.Printf("set ANTHROPIC_BASE_URL=http://localhost:8080\n")
-		fmt.Printf("set ANTHROPIC_API_KEY=%s\n\n", token.AccessToken)
-		fmt.Println("Powershell")
-		fmt.Println(`$env:ANTHROPIC_BASE_URL="http://localhost:8080"`)
-		fmt.Printf(`$env:ANTHROPIC_API_KEY="%s"`, token.AccessToken)
-	} else {
-		fmt.Printf("export ANTHROPIC_BASE_URL=http://localhost:8080\n")
-		fmt.Printf("export ANTHROPIC_API_KEY=\"%s\"\n", token.AccessToken)
-	}
+// exportOutput 是 export --json 的输出
+type exportOutput struct {
+	Env map[string]string `json:"env,omitempty"`
+	// Persisted 为 true 时 Env 已保存到用户环境 (--persist)
+	Persisted bool `json:"persisted,omitempty"`
+	// Unset 是 --unset 删除的变量
+	Unset []string `json:"unset,omitempty"`
 }
 
-func setClaude() {
-	// C:\Users\WIN10\.claude.json
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		fmt.Printf("获取用户目录失败: %v\n", err)
-		os.Exit(1)
-	}
+// exportEnvVars 导出环境变量，ANTHROPIC_BASE_URL 按 --url 或 --port 与 --base-path 生成
+func exportEnvVars(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	port := fs.String("port", "8080", "本机服务器端口")
+	basePath := fs.String("base-path", os.Getenv("KIRO2CC_BASE_PATH"), "服务器的挂载前缀，与 server --base-path 一致")
+	baseURL := fs.String("url", "", "完整的服务器地址，例如 https://example.com/kiro，设置后忽略 --port")
+	persist := fs.Bool("persist", false, "仅 Windows: 把环境变量保存到当前用户的环境中，新打开的终端自动生效")
+	unset := fs.Bool("unset", false, "仅 Windows: 删除 --persist 保存的环境变量")
+	addJSONFlag(fs, "以 JSON 输出环境变量，而不是 shell 命令")
+	fs.Parse(args)
 
-	claudeJsonPath := filepath.Join(homeDir, ".claude.json")
-	ok, _ := FileExists(claudeJsonPath)
-	if !ok {
-		fmt.Println("未找到Claude配置文件，请确认是否已安装 Claude Code")
-		fmt.Println("npm install -g @anthropic-ai/claude-code")
-		os.Exit(1)
+	if (*persist || *unset) && runtime.GOOS != "windows" {
+		exitf(2, os.Stderr, "--persist 和 --unset 只支持 Windows，其他系统请把 eval $(kiro2cc export) 加入 shell 的启动脚本")
 	}
-
-	data, err := os.ReadFile(claudeJsonPath)
-	if err != nil {
-		fmt.Printf("读取 Claude 文件失败: %v\n", err)
-		os.Exit(1)
+	if *unset {
+		persistExportedEnv(nil)
+		if jsonOutput {
+			writeJSON(os.Stdout, exportOutput{Unset: exportedEnvVars})
+		}
+		return
 	}
 
-	var jsonData map[string]interface{}
-
-	err = jsonStr.Unmarshal(data, &jsonData)
-
-	if err != nil {
-		fmt.Printf("解析 JSON 文件失败: %v\n", err)
-		os.Exit(1)
+	// 客户端使用随机生成的本地 API key，真实的 access token 只由服务器读取
+	tokenPath := getTokenFilePath()
+	if _, err := token.Read(tokenPath); err != nil {
+		exitf(1, os.Stdout, "读取 token失败,请先安装 Kiro 并登录！: %v", err)
 	}
-
-	jsonData["hasCompletedOnboarding"] = true
-	jsonData["kiro2cc"] = true
-
-	newJson, err := json.MarshalIndent(jsonData, "", "  ")
-
+	apiKey, err := server.LoadOrCreateLocalAPIKey(localAPIKeyPath())
 	if err != nil {
-		fmt.Printf("生成 JSON 文件失败: %v\n", err)
-		os.Exit(1)
+		exitf(1, os.Stdout, "读取本地 API key 失败: %v", err)
 	}
 
-	err = os.WriteFile(claudeJsonPath, newJson, 0644)
-
-	if err != nil {
-		fmt.Printf("写入 JSON 文件失败: %v\n", err)
-		os.Exit(1)
+	url := strings.TrimRight(*baseURL, "/")
+	if url == "" {
+		url = "http://localhost:" + *port + server.NormalizeBasePath(*basePath)
 	}
 
-	fmt.Println("Claude 配置文件已更新")
-
-}
-
-// getToken 获取当前token
-func getToken() (TokenData, error) {
-	tokenPath := getTokenFilePath()
-
-	data, err := os.ReadFile(tokenPath)
-	if err != nil {
-		return TokenData{}, fmt.Errorf("读取token文件失败: %v", err)
+	env := map[string]string{"ANTHROPIC_BASE_URL": url, "ANTHROPIC_API_KEY": apiKey}
+	if *persist {
+		persistExportedEnv(env)
+		if jsonOutput {
+			writeJSON(os.Stdout, exportOutput{Env: env, Persisted: true})
+		}
+		return
 	}
-
-	var token TokenData
-	if err := jsonStr.Unmarshal(data, &token); err != nil {
-		return TokenData{}, fmt.Errorf("解析token文件失败: %v", err)
+	if jsonOutput {
+		writeJSON(os.Stdout, exportOutput{Env: env})
+		return
 	}
 
-	return token, nil
-}
-
-// logMiddleware 记录所有HTTP请求的中间件
-func logMiddleware(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		startTime := time.Now()
-
-		// fmt.Printf("\n=== 收到请求 ===\n")
-		// fmt.Printf("时间: %s\n", startTime.Format("2006-01-02 15:04:05"))
-		// fmt.Printf("请求方法: %s\n", r.Method)
-		// fmt.Printf("请求路径: %s\n", r.URL.Path)
-		// fmt.Printf("客户端IP: %s\n", r.RemoteAddr)
-		// fmt.Printf("请求头:\n")
-		// for name, values := range r.Header {
-		// 	fmt.Printf("  %s: %s\n", name, strings.Join(values, ", "))
-		// }
-
-		// 调用下一个处理器
-		next(w, r)
-
-		// 计算处理时间
-		duration := time.Since(startTime)
-		fmt.Printf("处理时间: %v\n", duration)
-		fmt.Printf("=== 请求结束 ===\n\n")
+	// 根据操作系统输出不同格式的环境变量设置命令
+	if runtime.GOOS == "windows" {
+		fmt.Println("CMD")
+		fmt.Printf("set ANTHROPIC_BASE_URL=%s\n", url)
+		fmt.Printf("set ANTHROPIC_API_KEY=%s\n\n", apiKey)
+		fmt.Println("Powershell")
+		fmt.Printf("$env:ANTHROPIC_BASE_URL=\"%s\"\n", url)
+		fmt.Printf(`$env:ANTHROPIC_API_KEY="%s"`, apiKey)
+	} else {
+		fmt.Printf("export ANTHROPIC_BASE_URL=%s\n", url)
+		fmt.Printf("export ANTHROPIC_API_KEY=\"%s\"\n", apiKey)
 	}
 }
 
 // startServer 启动HTTP代理服务器
-func startServer(port string) {
-	// 创建路由器
-	mux := http.NewServeMux()
-
-	// 注册所有端点
-	mux.HandleFunc("/v1/messages", logMiddleware(func(w http.ResponseWriter, r *http.Request) {
-		// 只处理POST请求
-		if r.Method != http.MethodPost {
-			fmt.Printf("错误: 不支持的请求方法\n")
-			http.Error(w, "只支持POST请求", http.StatusMethodNotAllowed)
-			return
-		}
-
-		// 获取当前token
-		token, err := getToken()
-		if err != nil {
-			fmt.Printf("错误: 获取token失败: %v\n", err)
-			sendJSONError(w, http.StatusInternalServerError, "authentication_error", fmt.Sprintf("获取token失败: %v", err))
-			return
-		}
-		
-		// 验证token不为空
-		if strings.TrimSpace(token.AccessToken) == "" {
-			fmt.Printf("错误: AccessToken为空\n")
-			sendJSONError(w, http.StatusUnauthorized, "authentication_error", "AccessToken为空，请先登录或刷新token")
-			return
-		}
-
-		// 限制请求体大小 (10MB)
-		r.Body = http.MaxBytesReader(w, r.Body, 10<<20)
-		
-		// 读取请求体
-		body, err := io.ReadAll(r.Body)
-		if err != nil {
-			fmt.Printf("错误: 读取请求体失败: %v\n", err)
-			sendJSONError(w, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("读取请求体失败: %v", err))
-			return
-		}
-		defer r.Body.Close()
-		
-		// 验证请求体不为空
-		if len(body) == 0 {
-			sendJSONError(w, http.StatusBadRequest, "invalid_request_error", "请求体不能为空")
-			return
-		}
-
-		fmt.Printf("\n=========================Anthropic 请求体:\n%s\n=======================================\n", string(body))
-		
-		// 验证JSON格式
-		var testJson map[string]interface{}
-		if err := jsonStr.Unmarshal(body, &testJson); err != nil {
-			fmt.Printf("错误: 请求体不是有效的JSON: %v\n", err)
-			sendJSONError(w, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("请求体不是有效的JSON: %v", err))
-			return
-		}
-
-		// 解析 Anthropic 请求
-		var anthropicReq AnthropicRequest
-		if err := jsonStr.Unmarshal(body, &anthropicReq); err != nil {
-			fmt.Printf("错误: 解析请求体失败: %v\n", err)
-			http.Error(w, fmt.Sprintf("解析请求体失败: %v", err), http.StatusBadRequest)
-			return
-		}
+func startServer(port string, config server.Config) {
+	srv, err := server.New(config)
+	if err != nil {
+		fmt.Printf("创建服务器失败: %v\n", err)
+		os.Exit(1)
+	}
 
-		// 基础校验，给出明确的错误提示
-		if anthropicReq.Model == "" {
-			sendJSONError(w, http.StatusBadRequest, "invalid_request_error", "Missing required field: model")
-			return
-		}
-		if len(anthropicReq.Messages) == 0 {
-			sendJSONError(w, http.StatusBadRequest, "invalid_request_error", "Missing required field: messages")
-			return
-		}
-		if anthropicReq.MaxTokens <= 0 {
-			sendJSONError(w, http.StatusBadRequest, "invalid_request_error", "max_tokens must be a positive integer")
-			return
-		}
-		if _, ok := ModelMap[anthropicReq.Model]; !ok {
-			// 提示可用的模型名称
-			available := make([]string, 0, len(ModelMap))
-			for k := range ModelMap {
-				available = append(available, k)
-			}
-			sendJSONError(w, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("Unknown or unsupported model: %s. Available models: %s", anthropicReq.Model, strings.Join(available, ", ")))
-			return
-		}
-		
-		// 验证消息格式
-		for i, msg := range anthropicReq.Messages {
-			if msg.Role != "user" && msg.Role != "assistant" {
-				sendJSONError(w, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("Invalid role '%s' in message %d. Must be 'user' or 'assistant'", msg.Role, i))
-				return
-			}
-			if msg.Content == nil || (fmt.Sprintf("%v", msg.Content) == "" && fmt.Sprintf("%v", msg.Content) != "0") {
-				sendJSONError(w, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("Message %d has empty content", i))
-				return
+	// 请求中的 panic 由服务器自己记录，这里兜底启动和监听过程中的 panic
+	defer func() {
+		if v := recover(); v != nil {
+			stack := debug.Stack()
+			fmt.Printf("服务器发生 panic: %v\n%s\n", v, stack)
+			if path, err := srv.WriteCrashReport(v, stack); err != nil {
+				fmt.Printf("写入崩溃报告失败: %v\n", err)
+			} else {
+				fmt.Printf("崩溃报告已写入 %s，提交 issue 时请附上该文件\n", path)
 			}
+			os.Exit(2)
 		}
+	}()
 
-		// 如果是流式请求
-		if anthropicReq.Stream {
-			handleStreamRequest(w, anthropicReq, token.AccessToken)
-			return
+	// 日志同时保存在服务器的缓冲中，供 logs tail 查看
+	if config.LogBufferLines >= 0 {
+		if err := captureStdout(srv.LogWriter()); err != nil {
+			fmt.Printf("无法保存日志到缓冲: %v\n", err)
 		}
+	}
 
-		// 非流式请求处理
-		handleNonStreamRequest(w, anthropicReq, token.AccessToken)
-	}))
-
-	// 添加健康检查端点
-	mux.HandleFunc("/health", logMiddleware(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
-	}))
-
-	// 添加404处理
-	mux.HandleFunc("/", logMiddleware(func(w http.ResponseWriter, r *http.Request) {
-		fmt.Printf("警告: 访问未知端点\n")
-		http.Error(w, "404 未找到", http.StatusNotFound)
-	}))
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
 
 	// 启动服务器
 	fmt.Printf("启动Anthropic API代理服务器，监听端口: %s\n", port)
+	basePath := server.NormalizeBasePath(config.BasePath)
 	fmt.Printf("可用端点:\n")
-	fmt.Printf("  POST /v1/messages - Anthropic API代理\n")
-	fmt.Printf("  GET  /health      - 健康检查\n")
+	fmt.Printf("  POST %s/v1/messages - Anthropic API代理\n", basePath)
+	fmt.Printf("  GET  %s/v1/models   - 支持的模型及其能力\n", basePath)
+	if config.EnableWebSocket {
+		fmt.Printf("  GET  %s/v1/messages/ws - WebSocket 流式代理\n", basePath)
+	}
+	fmt.Printf("  GET  %s/health      - 健康检查\n", basePath)
+	for _, l := range config.Listeners {
+		fmt.Printf("  额外监听 %s - %s\n", l.Addr, strings.Join(l.Surfaces, ", "))
+	}
+	if config.GRPCAddr != "" {
+		fmt.Printf("  gRPC %s - kiro2cc.v1.MessagesService\n", config.GRPCAddr)
+	}
 	fmt.Printf("按Ctrl+C停止服务器\n")
 
-	if err := http.ListenAndServe(":"+port, mux); err != nil {
+	if err := srv.Start(ctx); err != nil {
 		fmt.Printf("启动服务器失败: %v\n", err)
 		os.Exit(1)
 	}
 }
 
-// handleStreamRequest 处理流式请求
-func handleStreamRequest(w http.ResponseWriter, anthropicReq AnthropicRequest, accessToken string) {
-	// 设置SSE headers
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		http.Error(w, "Streaming unsupported!", http.StatusInternalServerError)
-		return
-	}
-
-	messageId := fmt.Sprintf("msg_%s", time.Now().Format("20060102150405"))
-
-	// 构建 CodeWhisperer 请求
-	cwReq := buildCodeWhispererRequest(anthropicReq)
-
-	// 序列化请求体
-	cwReqBody, err := jsonStr.Marshal(cwReq)
+// listProfiles 查询账号可用的 profile，并输出设置 KIRO_PROFILE_ARN 的命令。--json 时输出 profile 列表
+func listProfiles() {
+	profiles, err := server.ListProfiles(context.Background(), getTokenFilePath())
 	if err != nil {
-		sendErrorEvent(w, flusher, "序列化请求失败", err)
-		return
+		exitf(1, os.Stdout, "查询 profile 失败: %v", err)
 	}
-
-	fmt.Printf("\n=========================CodeWhisperer 流式请求体:\n%s\n=======================================\n", string(cwReqBody))
-
-	// 创建流式请求
-	proxyReq, err := http.NewRequest(
-		http.MethodPost,
-		"https://codewhisperer.us-east-1.amazonaws.com/generateAssistantResponse",
-		bytes.NewBuffer(cwReqBody),
-	)
-	if err != nil {
-		sendErrorEvent(w, flusher, "创建代理请求失败", err)
-		return
-	}
-
-	// 设置请求头
-	proxyReq.Header.Set("Authorization", "Bearer "+accessToken)
-	proxyReq.Header.Set("Content-Type", "application/json")
-	proxyReq.Header.Set("Accept", "text/event-stream")
-	proxyReq.Header.Set("User-Agent", "kiro2cc/1.0")
-	proxyReq.Header.Set("X-Amz-Target", "CodeWhispererStreaming_20220101.GenerateAssistantResponse")
-
-	// 发送请求
-	client := &http.Client{
-		Timeout: 60 * time.Second, // 流式请求需要更长超时
-	}
-
-	resp, err := client.Do(proxyReq)
-	if err != nil {
-		sendErrorEvent(w, flusher, "CodeWhisperer request error", fmt.Errorf("request error: %s", err.Error()))
-		return
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		fmt.Printf("CodeWhisperer 响应错误，状态码: %d, 响应: %s\n", resp.StatusCode, string(body))
-
-		// 根据不同的状态码发送相应的错误事件
-		switch resp.StatusCode {
-		case 400:
-			sendErrorEvent(w, flusher, "请求参数错误", fmt.Errorf("Bad Request: %s", string(body)))
-		case 401:
-			sendErrorEvent(w, flusher, "认证失败", fmt.Errorf("Unauthorized: 请检查token"))
-		case 403:
-			refreshToken()
-			sendErrorEvent(w, flusher, "权限不足", fmt.Errorf("Forbidden: Token已刷新，请重试"))
-		case 429:
-			sendErrorEvent(w, flusher, "请求频率过高", fmt.Errorf("Rate Limited: 请稍后重试"))
-		case 500:
-			sendErrorEvent(w, flusher, "服务器内部错误", fmt.Errorf("Internal Server Error: CodeWhisperer服务异常"))
-		case 502, 503, 504:
-			sendErrorEvent(w, flusher, "服务不可用", fmt.Errorf("Service Unavailable: CodeWhisperer服务暂时不可用"))
-		default:
-			sendErrorEvent(w, flusher, "未知错误", fmt.Errorf("状态码: %d, 响应: %s", resp.StatusCode, string(body)))
+	if jsonOutput {
+		writeJSON(os.Stdout, map[string]any{"profiles": profiles})
+		if len(profiles) == 0 {
+			os.Exit(1)
 		}
 		return
 	}
-
-	// 先读取整个响应体
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		sendErrorEvent(w, flusher, "error", fmt.Errorf("CodeWhisperer Error 读取响应失败"))
-		return
+	if len(profiles) == 0 {
+		fmt.Println("当前账号没有可用的 CodeWhisperer profile")
+		os.Exit(1)
 	}
 
-	// os.WriteFile(messageId+"response.raw", respBody, 0644)
-
-	// 使用新的CodeWhisperer解析器
-	events := parser.ParseEvents(respBody)
-
-	if len(events) > 0 {
-
-		// 发送开始事件
-		messageStart := map[string]any{
-			"type": "message_start",
-			"message": map[string]any{
-				"id":            messageId,
-				"type":          "message",
-				"role":          "assistant",
-				"content":       []any{},
-				"model":         anthropicReq.Model,
-				"stop_reason":   nil,
-				"stop_sequence": nil,
-				"usage": map[string]any{
-					"input_tokens":  len(getMessageContent(anthropicReq.Messages[0].Content)),
-					"output_tokens": 1,
-				},
-			},
-		}
-		sendSSEEvent(w, flusher, "message_start", messageStart)
-		sendSSEEvent(w, flusher, "ping", map[string]string{
-			"type": "ping",
-		})
-
-		contentBlockStart := map[string]any{
-			"content_block": map[string]any{
-				"text": "",
-				"type": "text"},
-			"index": 0, "type": "content_block_start",
-		}
-
-		sendSSEEvent(w, flusher, "content_block_start", contentBlockStart)
-		// 处理解析出的事件
-
-		outputTokens := 0
-		for _, e := range events {
-			sendSSEEvent(w, flusher, e.Event, e.Data)
-
-			if e.Event == "content_block_delta" {
-				outputTokens = len(getMessageContent(e.Data))
-			}
-
-			// 随机延时
-			time.Sleep(time.Duration(rand.Intn(300)) * time.Millisecond)
-		}
-
-		contentBlockStop := map[string]any{
-			"index": 0,
-			"type":  "content_block_stop",
-		}
-		sendSSEEvent(w, flusher, "content_block_stop", contentBlockStop)
-
-		contentBlockStopReason := map[string]any{
-			"type": "message_delta", "delta": map[string]any{"stop_reason": "end_turn", "stop_sequence": nil}, "usage": map[string]any{
-				"output_tokens": outputTokens,
-			},
-		}
-		sendSSEEvent(w, flusher, "message_delta", contentBlockStopReason)
-
-		messageStop := map[string]any{
-			"type": "message_stop",
-		}
-		sendSSEEvent(w, flusher, "message_stop", messageStop)
+	fmt.Println("可用的 profile:")
+	for _, profile := range profiles {
+		fmt.Printf("  %s\t%s\n", profile.ProfileName, profile.Arn)
+	}
+	fmt.Println()
+	if runtime.GOOS == "windows" {
+		fmt.Printf("set KIRO_PROFILE_ARN=%s\n", profiles[0].Arn)
+	} else {
+		fmt.Printf("export KIRO_PROFILE_ARN=\"%s\"\n", profiles[0].Arn)
 	}
-
 }
 
-// handleNonStreamRequest 处理非流式请求
-func handleNonStreamRequest(w http.ResponseWriter, anthropicReq AnthropicRequest, accessToken string) {
-	// 构建 CodeWhisperer 请求
-	cwReq := buildCodeWhispererRequest(anthropicReq)
+// runMCP 在标准输入输出上运行 MCP 服务器
+func runMCP() {
+	// stdout 专用于 JSON-RPC，日志改为输出到 stderr
+	out := os.Stdout
+	os.Stdout = os.Stderr
 
-	// 序列化请求体
-	cwReqBody, err := jsonStr.Marshal(cwReq)
+	srv, err := server.New(server.Config{TokenPath: getTokenFilePath()})
 	if err != nil {
-		fmt.Printf("错误: 序列化请求失败: %v\n", err)
-		sendJSONError(w, http.StatusInternalServerError, "api_error", fmt.Sprintf("序列化请求失败: %v", err))
-		return
+		fmt.Fprintf(os.Stderr, "创建服务器失败: %v\n", err)
+		os.Exit(1)
 	}
 
-	fmt.Printf("\n=========================CodeWhisperer 请求体:\n%s\n=======================================\n", string(cwReqBody))
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
 
-	// 创建请求
-	proxyReq, err := http.NewRequest(
-		http.MethodPost,
-		"https://codewhisperer.us-east-1.amazonaws.com/generateAssistantResponse",
-		bytes.NewBuffer(cwReqBody),
-	)
-	if err != nil {
-		fmt.Printf("错误: 创建代理请求失败: %v\n", err)
-		sendJSONError(w, http.StatusInternalServerError, "api_error", fmt.Sprintf("创建代理请求失败: %v", err))
-		return
+	if err := srv.ServeMCP(ctx, os.Stdin, out); err != nil {
+		fmt.Fprintf(os.Stderr, "MCP 服务异常退出: %v\n", err)
+		os.Exit(1)
 	}
+}
 
-	// 设置请求头
-	proxyReq.Header.Set("Authorization", "Bearer "+accessToken)
-	proxyReq.Header.Set("Content-Type", "application/json")
-	proxyReq.Header.Set("User-Agent", "kiro2cc/1.0")
-	proxyReq.Header.Set("X-Amz-Target", "CodeWhispererStreaming_20220101.GenerateAssistantResponse")
+// runBatch 从标准输入逐行读取 Anthropic 请求，直接交给 Backend 处理，按输入顺序逐行输出响应
+func runBatch(args []string) {
+	fs := flag.NewFlagSet("batch", flag.ExitOnError)
+	concurrency := fs.Int("concurrency", 4, "同时处理的请求数")
+	fs.Parse(args)
 
-	// 发送请求
-	client := &http.Client{
-		Timeout: 30 * time.Second,
-	}
+	// stdout 专用于输出响应，日志改为输出到 stderr
+	out := os.Stdout
+	os.Stdout = os.Stderr
 
-	resp, err := client.Do(proxyReq)
+	srv, err := server.New(server.Config{TokenPath: getTokenFilePath()})
 	if err != nil {
-		fmt.Printf("错误: 发送请求失败: %v\n", err)
-		sendJSONError(w, http.StatusInternalServerError, "api_error", fmt.Sprintf("发送请求失败: %v", err))
-		return
+		fmt.Fprintf(os.Stderr, "创建服务器失败: %v\n", err)
+		os.Exit(1)
 	}
-	defer resp.Body.Close()
-
-	// 检查响应状态码
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		fmt.Printf("CodeWhisperer 响应错误，状态码: %d, 响应: %s\n", resp.StatusCode, string(body))
 
-		// 根据不同的状态码返回相应的错误
-		switch resp.StatusCode {
-		case 400:
-			sendJSONError(w, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("请求参数错误: %s", string(body)))
-		case 401:
-			sendJSONError(w, http.StatusUnauthorized, "authentication_error", "认证失败，请检查token")
-		case 403:
-			// 尝试刷新token
-			fmt.Printf("Token可能已过期，尝试刷新...\n")
-			if refreshErr := refreshTokenSilently(); refreshErr == nil {
-				sendJSONError(w, http.StatusForbidden, "permission_error", "Token已刷新，请重试请求")
-			} else {
-				sendJSONError(w, http.StatusForbidden, "permission_error", "权限不足且Token刷新失败，请重新登录")
-			}
-		case 429:
-			sendJSONError(w, http.StatusTooManyRequests, "rate_limit_error", "请求频率过高，请稍后重试")
-		case 500:
-			sendJSONError(w, http.StatusInternalServerError, "api_error", "CodeWhisperer服务器内部错误")
-		case 502, 503, 504:
-			sendJSONError(w, http.StatusServiceUnavailable, "overloaded_error", "CodeWhisperer服务暂时不可用，请稍后重试")
-		default:
-			sendJSONError(w, resp.StatusCode, "api_error", fmt.Sprintf("CodeWhisperer返回错误，状态码: %d, 响应: %s", resp.StatusCode, string(body)))
-		}
-		return
-	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
 
-	// 读取响应
-	cwRespBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Printf("错误: 读取响应失败: %v\n", err)
-		sendJSONError(w, http.StatusInternalServerError, "api_error", fmt.Sprintf("读取响应失败: %v", err))
-		return
+	if err := srv.ServeBatch(ctx, os.Stdin, out, *concurrency); err != nil {
+		fmt.Fprintf(os.Stderr, "读取输入失败: %v\n", err)
+		os.Exit(1)
 	}
+}
 
-	fmt.Printf("CodeWhisperer 响应体:\n%s\n", string(cwRespBody))
-
-	events := parser.ParseEvents(cwRespBody)
-
-	context := ""
-	toolName := ""
-	toolUseId := ""
-
-	contexts := []map[string]any{}
-
-	partialJsonStr := ""
-	for _, event := range events {
-		if event.Data != nil {
-			if dataMap, ok := event.Data.(map[string]any); ok {
-				switch dataMap["type"] {
-				case "content_block_start":
-					context = ""
-				case "content_block_delta":
-					if delta, ok := dataMap["delta"]; ok {
-
-						if deltaMap, ok := delta.(map[string]any); ok {
-							switch deltaMap["type"] {
-							case "text_delta":
-								if text, ok := deltaMap["text"]; ok {
-									context += text.(string)
-								}
-							case "input_json_delta":
-								toolUseId = deltaMap["id"].(string)
-								toolName = deltaMap["name"].(string)
-								if partial_json, ok := deltaMap["partial_json"]; ok {
-									if strPtr, ok := partial_json.(*string); ok && strPtr != nil {
-										partialJsonStr = partialJsonStr + *strPtr
-									} else if str, ok := partial_json.(string); ok {
-										partialJsonStr = partialJsonStr + str
-									} else {
-										log.Println("partial_json is not string or *string")
-									}
-								} else {
-									log.Println("partial_json not found")
-								}
-
-							}
-						}
-					}
-
-				case "content_block_stop":
-					if index, ok := dataMap["index"]; ok {
-						switch index {
-						case 1:
-							toolInput := map[string]interface{}{}
-							if err := jsonStr.Unmarshal([]byte(partialJsonStr), &toolInput); err != nil {
-								log.Printf("json unmarshal error:%s", err.Error())
-							}
-
-							contexts = append(contexts, map[string]interface{}{
-								"type":  "tool_use",
-								"id":    toolUseId,
-								"name":  toolName,
-								"input": toolInput,
-							})
-						case 0:
-							contexts = append(contexts, map[string]interface{}{
-								"text": context,
-								"type": "text",
-							})
-						}
-					}
-				}
-
-			}
-		}
+// exportTranscript 从运行中的服务器导出最近的对话
+func exportTranscript(args []string) {
+	fs := flag.NewFlagSet("transcript", flag.ExitOnError)
+	serverURL := fs.String("server", "http://localhost:8080", "kiro2cc 服务器地址")
+	adminToken := fs.String("admin-token", os.Getenv("KIRO2CC_ADMIN_TOKEN"), "管理接口的访问token")
+	format := fs.String("format", "markdown", "导出格式: markdown 或 jsonl")
+	limit := fs.Int("limit", 0, "只导出最近 N 次对话，0 表示全部")
+	addJSONFlag(fs, "以 JSONL 导出，相当于 --format jsonl")
+	fs.Parse(args)
+	if jsonOutput {
+		*format = "jsonl"
 	}
 
-	// 回退：如果已累积到文本但未收到 content_block_stop(index=0)，也要返回文本
-	if len(contexts) == 0 && strings.TrimSpace(context) != "" {
-		contexts = append(contexts, map[string]any{
-			"type": "text",
-			"text": context,
-		})
+	url := fmt.Sprintf("%s/admin/transcripts?format=%s&limit=%d", strings.TrimRight(*serverURL, "/"), *format, *limit)
+	body, err := adminGet(url, *adminToken)
+	if err != nil {
+		exitf(1, os.Stderr, "导出对话失败: %v", err)
 	}
+	os.Stdout.Write(body)
+}
 
-	// 检查是否是错误响应
-	if strings.Contains(string(cwRespBody), "Improperly formed request.") {
-		fmt.Printf("错误: CodeWhisperer返回格式错误: %s\n", string(cwRespBody))
-		sendJSONError(w, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("请求格式错误: %s", string(cwRespBody)))
-		return
+// adminGet 请求运行中服务器的管理接口
+func adminGet(url, adminToken string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
 	}
-
-	// 构建 Anthropic 响应
-	anthropicResp := map[string]any{
-		"content":       contexts,
-		"model":         anthropicReq.Model,
-		"role":          "assistant",
-		"stop_reason":   "end_turn",
-		"stop_sequence": nil,
-		"type":          "message",
-		"usage": map[string]any{
-			"input_tokens":  len(cwReq.ConversationState.CurrentMessage.UserInputMessage.Content),
-			"output_tokens": len(context),
-		},
+	if adminToken != "" {
+		req.Header.Set("Authorization", "Bearer "+adminToken)
 	}
 
-	// 发送响应
-	w.Header().Set("Content-Type", "application/json")
-	jsonStr.NewEncoder(w).Encode(anthropicResp)
-}
-
-// sendSSEEvent 发送 SSE 事件
-func sendSSEEvent(w http.ResponseWriter, flusher http.Flusher, eventType string, data any) {
-
-	json, err := jsonStr.Marshal(data)
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return
+		return nil, err
 	}
+	defer resp.Body.Close()
 
-	fmt.Printf("event: %s\n", eventType)
-	fmt.Printf("data: %v\n\n", string(json))
-
-	fmt.Fprintf(w, "event: %s\n", eventType)
-	fmt.Fprintf(w, "data: %s\n\n", string(json))
-	flusher.Flush()
-
-}
-
-// sendErrorEvent 发送错误事件
-func sendErrorEvent(w http.ResponseWriter, flusher http.Flusher, message string, err error) {
-	errorResp := map[string]any{
-		"type": "error",
-		"error": map[string]any{
-			"type":    "api_error",
-			"message": fmt.Sprintf("%s: %v", message, err),
-		},
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
 	}
-
-	sendSSEEvent(w, flusher, "error", errorResp)
-}
-
-// sendJSONError 发送JSON格式的错误响应
-func sendJSONError(w http.ResponseWriter, statusCode int, errorType, message string) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(statusCode)
-
-	errorResp := AnthropicErrorResponse{
-		Type: "error",
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("状态码: %d, 响应: %s", resp.StatusCode, strings.TrimSpace(string(body)))
 	}
-	errorResp.Error.Type = errorType
-	errorResp.Error.Message = message
-
-	jsonStr.NewEncoder(w).Encode(errorResp)
+	return body, nil
 }
 
 func FileExists(path string) (bool, error) {
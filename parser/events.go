@@ -0,0 +1,73 @@
+package parser
+
+// 以下类型是 SSEEvent.Data 的具体类型，序列化结果与 Anthropic 流式事件的 data 字段一致。
+// 使用结构体而不是 map[string]interface{}，避免在每个事件上重复编解码
+
+// ContentBlock 表示 content_block_start 中的内容块
+type ContentBlock struct {
+	Type  string      `json:"type"`
+	Text  *string     `json:"text,omitempty"`
+	ID    string      `json:"id,omitempty"`
+	Name  string      `json:"name,omitempty"`
+	Input interface{} `json:"input,omitempty"`
+}
+
+// ContentBlockStart 表示 content_block_start 事件
+type ContentBlockStart struct {
+	Type         string       `json:"type"`
+	Index        int          `json:"index"`
+	ContentBlock ContentBlock `json:"content_block"`
+}
+
+// Delta 表示 content_block_delta 中的增量，text_delta 使用 Text，input_json_delta 使用其余字段
+type Delta struct {
+	Type        string  `json:"type"`
+	Text        string  `json:"text,omitempty"`
+	ID          string  `json:"id,omitempty"`
+	Name        string  `json:"name,omitempty"`
+	PartialJSON *string `json:"partial_json,omitempty"`
+}
+
+// ContentBlockDelta 表示 content_block_delta 事件
+type ContentBlockDelta struct {
+	Type  string `json:"type"`
+	Index int    `json:"index"`
+	Delta Delta  `json:"delta"`
+}
+
+// ContentBlockStop 表示 content_block_stop 事件
+type ContentBlockStop struct {
+	Type  string `json:"type"`
+	Index int    `json:"index"`
+}
+
+// MessageDeltaBody 表示 message_delta 中的停止原因
+type MessageDeltaBody struct {
+	StopReason   string  `json:"stop_reason"`
+	StopSequence *string `json:"stop_sequence"`
+}
+
+// Usage 表示 message_delta 中的用量
+type Usage struct {
+	InputTokens  int `json:"input_tokens,omitempty"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// MessageDelta 表示 message_delta 事件
+type MessageDelta struct {
+	Type  string           `json:"type"`
+	Delta MessageDeltaBody `json:"delta"`
+	Usage Usage            `json:"usage"`
+}
+
+// TextDeltaEvent 构造第 0 个内容块的文本增量事件
+func TextDeltaEvent(text string) SSEEvent {
+	return SSEEvent{
+		Event: "content_block_delta",
+		Data: ContentBlockDelta{
+			Type:  "content_block_delta",
+			Index: 0,
+			Delta: Delta{Type: "text_delta", Text: text},
+		},
+	}
+}
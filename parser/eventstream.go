@@ -0,0 +1,213 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"mime"
+	"strings"
+)
+
+// Format is the wire format of an upstream response body.
+type Format string
+
+const (
+	// FormatEventStream is the binary AWS event stream (application/vnd.amazon.eventstream).
+	FormatEventStream Format = "eventstream"
+	// FormatSSE is text server-sent events with one JSON payload per data line.
+	FormatSSE Format = "sse"
+	// FormatUnknown is a body that matches neither format.
+	FormatUnknown Format = "unknown"
+)
+
+// EventStreamContentType is the Content-Type CodeWhisperer uses for binary event streams.
+const EventStreamContentType = "application/vnd.amazon.eventstream"
+
+const (
+	// preludeLen is total length (4) + headers length (4) + prelude CRC (4).
+	preludeLen = 12
+	// messageCRCLen is the trailing CRC over the whole message.
+	messageCRCLen = 4
+	// maxFrameLen rejects preludes that cannot belong to a real frame.
+	maxFrameLen = 16 << 20
+)
+
+// DetectFormat picks the decoder for an upstream response. A Content-Type naming one of
+// the formats wins; otherwise the body is checked for a valid event stream prelude
+// (lengths and CRC) and then for SSE field lines.
+func DetectFormat(contentType string, resp []byte) Format {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		switch mediaType {
+		case EventStreamContentType:
+			return FormatEventStream
+		case "text/event-stream":
+			return FormatSSE
+		}
+	}
+	if validPrelude(resp) {
+		return FormatEventStream
+	}
+	if looksLikeSSE(resp) {
+		return FormatSSE
+	}
+	return FormatUnknown
+}
+
+// validPrelude reports whether resp starts with an event stream prelude whose CRC matches.
+func validPrelude(resp []byte) bool {
+	if len(resp) < preludeLen {
+		return false
+	}
+	total := binary.BigEndian.Uint32(resp[0:4])
+	headers := binary.BigEndian.Uint32(resp[4:8])
+	if total < preludeLen+messageCRCLen || total > maxFrameLen || headers > total-preludeLen-messageCRCLen {
+		return false
+	}
+	return crc32.ChecksumIEEE(resp[0:8]) == binary.BigEndian.Uint32(resp[8:12])
+}
+
+// looksLikeSSE reports whether the first non-empty line of resp is an SSE field or comment.
+func looksLikeSSE(resp []byte) bool {
+	for _, line := range strings.Split(string(resp), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		for _, prefix := range []string{"data:", "event:", "id:", "retry:", ":"} {
+			if strings.HasPrefix(line, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+	return false
+}
+
+// frame is one decoded event stream message. Only string headers are kept, which covers
+// :message-type, :event-type, :exception-type, :error-code and :content-type.
+type frame struct {
+	headers map[string]string
+	payload []byte
+}
+
+// keepAlive reports whether f carries nothing to convert: keep-alive frames with no
+// headers or payload, and headers-only messages such as an initial-response event.
+func (f frame) keepAlive() bool {
+	return len(bytes.TrimSpace(f.payload)) == 0
+}
+
+// decodeFrames splits resp into event stream messages. Frames decoded before a malformed
+// or truncated one are returned together with the error.
+func decodeFrames(resp []byte) ([]frame, error) {
+	var frames []frame
+	for offset := 0; offset < len(resp); {
+		rest := resp[offset:]
+		if len(rest) < preludeLen {
+			return frames, fmt.Errorf("truncated event stream prelude at offset %d", offset)
+		}
+		if !validPrelude(rest) {
+			return frames, fmt.Errorf("invalid event stream prelude at offset %d", offset)
+		}
+		total := int(binary.BigEndian.Uint32(rest[0:4]))
+		headersLen := int(binary.BigEndian.Uint32(rest[4:8]))
+		if len(rest) < total {
+			return frames, fmt.Errorf("truncated event stream message at offset %d: have %d of %d bytes", offset, len(rest), total)
+		}
+		msg := rest[:total]
+		if crc32.ChecksumIEEE(msg[:total-messageCRCLen]) != binary.BigEndian.Uint32(msg[total-messageCRCLen:]) {
+			return frames, fmt.Errorf("event stream message CRC mismatch at offset %d", offset)
+		}
+		headers, err := decodeHeaders(msg[preludeLen : preludeLen+headersLen])
+		if err != nil {
+			return frames, fmt.Errorf("event stream headers at offset %d: %v", offset, err)
+		}
+		frames = append(frames, frame{headers: headers, payload: msg[preludeLen+headersLen : total-messageCRCLen]})
+		offset += total
+	}
+	return frames, nil
+}
+
+// headerValueLen is the fixed value size of each event stream header type; -1 means a
+// 2-byte length prefix follows.
+var headerValueLen = map[byte]int{
+	0: 0,  // bool true
+	1: 0,  // bool false
+	2: 1,  // byte
+	3: 2,  // short
+	4: 4,  // integer
+	5: 8,  // long
+	6: -1, // byte array
+	7: -1, // string
+	8: 8,  // timestamp
+	9: 16, // uuid
+}
+
+func decodeHeaders(b []byte) (map[string]string, error) {
+	headers := make(map[string]string)
+	for len(b) > 0 {
+		nameLen := int(b[0])
+		if len(b) < 1+nameLen+1 {
+			return nil, fmt.Errorf("truncated header name")
+		}
+		name := string(b[1 : 1+nameLen])
+		valueType := b[1+nameLen]
+		b = b[1+nameLen+1:]
+		size, ok := headerValueLen[valueType]
+		if !ok {
+			return nil, fmt.Errorf("unknown header type %d for %s", valueType, name)
+		}
+		if size < 0 {
+			if len(b) < 2 {
+				return nil, fmt.Errorf("truncated header %s", name)
+			}
+			size = int(binary.BigEndian.Uint16(b[0:2]))
+			b = b[2:]
+			if len(b) < size {
+				return nil, fmt.Errorf("truncated header %s", name)
+			}
+			if valueType == 7 {
+				headers[name] = string(b[:size])
+			}
+		} else if len(b) < size {
+			return nil, fmt.Errorf("truncated header %s", name)
+		}
+		b = b[size:]
+	}
+	return headers, nil
+}
+
+// parseEventStream converts event stream messages into SSE events. Each frame is converted
+// on its own, so a keep-alive between two tool input fragments changes nothing. Keep-alive
+// frames and payloads with nothing to convert produce no event; exception and error
+// messages, and payloads that are not JSON, are returned as errors.
+func parseEventStream(resp []byte) ([]SSEEvent, []error) {
+	events := []SSEEvent{}
+	var errs []error
+	frames, err := decodeFrames(resp)
+	for _, f := range frames {
+		switch f.headers[":message-type"] {
+		case "exception":
+			errs = append(errs, fmt.Errorf("upstream exception %s: %s", f.headers[":exception-type"], bytes.TrimSpace(f.payload)))
+			continue
+		case "error":
+			errs = append(errs, fmt.Errorf("upstream error %s: %s", f.headers[":error-code"], f.headers[":error-message"]))
+			continue
+		}
+		if f.keepAlive() {
+			continue
+		}
+		if !json.Valid(f.payload) {
+			errs = append(errs, fmt.Errorf("invalid JSON payload %q in %s event", f.payload, f.headers[":event-type"]))
+			continue
+		}
+		if evt, ok := convertPayload(f.payload); ok {
+			events = append(events, evt)
+		}
+	}
+	if err != nil {
+		errs = append(errs, err)
+	}
+	return events, errs
+}
@@ -0,0 +1,125 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+)
+
+// encodeFrame builds an event stream message with string headers.
+func encodeFrame(headers map[string]string, payload string) []byte {
+	var h bytes.Buffer
+	for name, value := range headers {
+		h.WriteByte(byte(len(name)))
+		h.WriteString(name)
+		h.WriteByte(7)
+		binary.Write(&h, binary.BigEndian, uint16(len(value)))
+		h.WriteString(value)
+	}
+	total := preludeLen + h.Len() + len(payload) + messageCRCLen
+	msg := make([]byte, 8, total)
+	binary.BigEndian.PutUint32(msg[0:4], uint32(total))
+	binary.BigEndian.PutUint32(msg[4:8], uint32(h.Len()))
+	msg = binary.BigEndian.AppendUint32(msg, crc32.ChecksumIEEE(msg[0:8]))
+	msg = append(msg, h.Bytes()...)
+	msg = append(msg, payload...)
+	return binary.BigEndian.AppendUint32(msg, crc32.ChecksumIEEE(msg))
+}
+
+func eventFrame(eventType, payload string) []byte {
+	return encodeFrame(map[string]string{":message-type": "event", ":event-type": eventType, ":content-type": "application/json"}, payload)
+}
+
+func TestDetectFormat(t *testing.T) {
+	frame := eventFrame("assistantResponseEvent", `{"content":"hi"}`)
+	for _, tc := range []struct {
+		contentType string
+		body        []byte
+		want        Format
+	}{
+		{EventStreamContentType, []byte("data: {}"), FormatEventStream},
+		{"text/event-stream; charset=utf-8", frame, FormatSSE},
+		{"", frame, FormatEventStream},
+		{"application/octet-stream", frame, FormatEventStream},
+		{"text/plain", []byte("\n\ndata: {\"content\":\"hi\"}\n\n"), FormatSSE},
+		{"", []byte(`{"message":"Improperly formed request."}`), FormatUnknown},
+		// Event stream header names appearing in text no longer mean the binary format
+		{"", []byte(`x :message-type :event-type assistantResponseEvent`), FormatUnknown},
+	} {
+		if got := DetectFormat(tc.contentType, tc.body); got != tc.want {
+			t.Errorf("DetectFormat(%q, %q) = %s, want %s", tc.contentType, tc.body, got, tc.want)
+		}
+	}
+}
+
+func TestParseEventStream(t *testing.T) {
+	var resp []byte
+	resp = append(resp, eventFrame("assistantResponseEvent", `{"content":"Hello {world}"}`)...)
+	resp = append(resp, eventFrame("toolUseEvent", `{"name":"ls","toolUseId":"t1","input":"{\"path\":"}`)...)
+	// Credits are not a token count and produce no event
+	resp = append(resp, eventFrame("meteringEvent", `{"unit":"credit","unitPlural":"credits","usage":0.01}`)...)
+	resp = append(resp, eventFrame("meteringEvent", `{"unit":"token","unitPlural":"tokens","usage":42}`)...)
+
+	events, errs := ParseEventsFormat(DetectFormat(EventStreamContentType, resp), resp)
+	if len(errs) != 0 {
+		t.Fatalf("errors: %v", errs)
+	}
+	if len(events) != 3 {
+		t.Fatalf("events = %+v", events)
+	}
+	if d := events[0].Data.(ContentBlockDelta); d.Delta.Text != "Hello {world}" {
+		t.Errorf("text = %q", d.Delta.Text)
+	}
+	if d := events[1].Data.(ContentBlockDelta); d.Delta.PartialJSON == nil || *d.Delta.PartialJSON != `{"path":` {
+		t.Errorf("tool delta = %+v", d.Delta)
+	}
+	if d, ok := events[2].Data.(MessageDelta); !ok || d.Usage.OutputTokens != 42 || d.Delta.StopReason != "" {
+		t.Errorf("usage event = %+v", events[2])
+	}
+
+	// Events before a truncated frame are kept alongside the error
+	truncated := resp[:len(resp)-5]
+	if events, errs := ParseEventsFormat(FormatEventStream, truncated); len(events) != 2 || len(errs) != 1 {
+		t.Errorf("truncated: %d events, errors %v", len(events), errs)
+	}
+
+	exception := encodeFrame(map[string]string{":message-type": "exception", ":exception-type": "ThrottlingException"}, `{"message":"slow down"}`)
+	if _, errs := ParseEventsFormat(FormatEventStream, exception); len(errs) != 1 {
+		t.Errorf("exception: errors %v", errs)
+	}
+
+	corrupt := bytes.Clone(resp)
+	corrupt[20] ^= 0xff
+	if _, errs := ParseEventsFormat(FormatEventStream, corrupt); len(errs) != 1 {
+		t.Errorf("corrupt: errors %v", errs)
+	}
+}
+
+func TestParseEventStreamKeepAlive(t *testing.T) {
+	var resp []byte
+	resp = append(resp, encodeFrame(nil, "")...)
+	resp = append(resp, encodeFrame(map[string]string{":message-type": "event", ":event-type": "initial-response"}, "")...)
+	resp = append(resp, eventFrame("toolUseEvent", `{"name":"ls","toolUseId":"t1"}`)...)
+	resp = append(resp, encodeFrame(nil, "\n")...)
+	resp = append(resp, eventFrame("toolUseEvent", `{"name":"ls","toolUseId":"t1","input":"{}"}`)...)
+	// A fragment with only the tool id converts to nothing
+	resp = append(resp, eventFrame("toolUseEvent", `{"toolUseId":"t1"}`)...)
+	resp = append(resp, eventFrame("toolUseEvent", `{}`)...)
+	resp = append(resp, eventFrame("assistantResponseEvent", `{"content":"done"}`)...)
+
+	events, errs := ParseEventsFormat(FormatEventStream, resp)
+	if len(errs) != 0 {
+		t.Fatalf("errors: %v", errs)
+	}
+	var names []string
+	for _, e := range events {
+		if e.Event == "" || e.Data == nil {
+			t.Errorf("empty event in %+v", events)
+		}
+		names = append(names, e.Event)
+	}
+	if len(names) != 3 || names[0] != "content_block_start" || names[1] != "content_block_delta" || names[2] != "content_block_delta" {
+		t.Errorf("events = %v", names)
+	}
+}
@@ -2,8 +2,8 @@ package parser
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
-	"regexp"
 	"strings"
 )
 
@@ -21,18 +21,61 @@ type usageEvent struct {
 	Usage      float64 `json:"usage"`
 }
 
+// countsTokens reports whether the metering event is measured in tokens rather than credits.
+func (u usageEvent) countsTokens() bool {
+	unit := strings.ToLower(u.Unit)
+	return unit == "token" || unit == "tokens"
+}
+
 type SSEEvent struct {
 	Event string      `json:"event"`
 	Data  interface{} `json:"data"`
 }
 
 func ParseEvents(resp []byte) []SSEEvent {
-	events := []SSEEvent{}
-	
-	// Check if this is CodeWhisperer binary format
-	if isCodeWhispererFormat(resp) {
-		return parseCodeWhispererEvents(resp)
+	events, _ := ParseEventsWithErrors(resp)
+	return events
+}
+
+// ParseEventsWithErrors is ParseEvents that also returns the payloads it failed to decode,
+// so callers can tell an upstream format change from an empty response.
+func ParseEventsWithErrors(resp []byte) ([]SSEEvent, []error) {
+	return ParseEventsFormat(DetectFormat("", resp), resp)
+}
+
+// ParseEventsFormat decodes resp with the decoder for format, usually the result of
+// DetectFormat on the upstream Content-Type and body.
+func ParseEventsFormat(format Format, resp []byte) ([]SSEEvent, []error) {
+	var events []SSEEvent
+	var errs []error
+	switch format {
+	case FormatEventStream:
+		events, errs = parseEventStream(resp)
+	case FormatSSE:
+		events, errs = parseSSE(resp)
+	default:
+		return []SSEEvent{}, []error{fmt.Errorf("unrecognized upstream response format (%d bytes)", len(resp))}
+	}
+	return dropEmptyEvents(events), errs
+}
+
+// dropEmptyEvents removes zero SSEEvent values left by inputs convertAssistantEventToSSE
+// does not recognize. Written out they become "event: \ndata: null", which strict SDK
+// parsers reject.
+func dropEmptyEvents(events []SSEEvent) []SSEEvent {
+	kept := events[:0]
+	for _, e := range events {
+		if e.Event == "" || e.Data == nil {
+			continue
+		}
+		kept = append(kept, e)
 	}
+	return kept
+}
+
+func parseSSE(resp []byte) ([]SSEEvent, []error) {
+	events := []SSEEvent{}
+	var errs []error
 	
 	// Parse standard SSE text format
 	lines := strings.Split(string(resp), "\n")
@@ -58,122 +101,89 @@ func ParseEvents(resp []byte) []SSEEvent {
 					if evt.Stop {
 						events = append(events, SSEEvent{
 							Event: "message_delta",
-							Data: map[string]interface{}{
-								"type": "message_delta",
-								"delta": map[string]interface{}{
-									"stop_reason":   "tool_use",
-									"stop_sequence": nil,
-								},
-								"usage": map[string]interface{}{"output_tokens": 0},
+							Data: MessageDelta{
+								Type:  "message_delta",
+								Delta: MessageDeltaBody{StopReason: "tool_use"},
 							},
 						})
 					}
 				}
 			} else {
 				log.Println("json unmarshal error:", err, "data:", dataStr)
+				errs = append(errs, err)
 			}
 		}
 	}
 	
-	return events
+	return events, errs
 }
 
-func isCodeWhispererFormat(resp []byte) bool {
-	// Check for CodeWhisperer specific markers
-	respStr := string(resp)
-	return strings.Contains(respStr, ":message-type") && 
-		   strings.Contains(respStr, ":event-type") &&
-		   strings.Contains(respStr, "assistantResponseEvent")
-}
+// convertPayload converts one event stream JSON payload: assistant text, usage (metering)
+// or tool use. Payloads of other event types are skipped.
+func convertPayload(payload []byte) (SSEEvent, bool) {
+	// Try to parse as content event
+	var contentEvt assistantResponseEvent
+	if err := json.Unmarshal(payload, &contentEvt); err == nil && contentEvt.Content != "" {
+		return convertAssistantEventToSSE(contentEvt), true
+	}
 
-func parseCodeWhispererEvents(resp []byte) []SSEEvent {
-	events := []SSEEvent{}
-	respStr := string(resp)
-	
-	// Extract JSON objects from the binary stream
-	jsonRegex := regexp.MustCompile(`\{"[^}]*"\}`)
-	matches := jsonRegex.FindAllString(respStr, -1)
-	
-	for _, match := range matches {
-		// Try to parse as content event
-		var contentEvt assistantResponseEvent
-		if err := json.Unmarshal([]byte(match), &contentEvt); err == nil && contentEvt.Content != "" {
-			events = append(events, convertAssistantEventToSSE(contentEvt))
-			continue
-		}
-		
-		// Try to parse as usage event
-		var usageEvt usageEvent
-		if err := json.Unmarshal([]byte(match), &usageEvt); err == nil && usageEvt.Unit != "" {
-			// Convert usage event to message_delta with usage info
-			events = append(events, SSEEvent{
-				Event: "message_delta",
-				Data: map[string]interface{}{
-					"type": "message_delta",
-					"delta": map[string]interface{}{
-						"stop_reason":   "end_turn",
-						"stop_sequence": nil,
-					},
-					"usage": map[string]interface{}{
-						"input_tokens":  0,
-						"output_tokens": int(usageEvt.Usage * 1000), // Convert to approximate token count
-					},
-				},
-			})
-			continue
-		}
-		
-		// Try to parse as tool use event
-		var toolEvt assistantResponseEvent
-		if err := json.Unmarshal([]byte(match), &toolEvt); err == nil && (toolEvt.ToolUseId != "" || toolEvt.Name != "") {
-			events = append(events, convertAssistantEventToSSE(toolEvt))
-			continue
+	// Try to parse as usage event. Metering usually reports credits, which say nothing
+	// about the token count; only a usage in tokens becomes a message_delta, otherwise the
+	// caller keeps its own estimate.
+	var usageEvt usageEvent
+	if err := json.Unmarshal(payload, &usageEvt); err == nil && usageEvt.Unit != "" {
+		if !usageEvt.countsTokens() {
+			return SSEEvent{}, false
 		}
+		return SSEEvent{
+			Event: "message_delta",
+			Data: MessageDelta{
+				Type:  "message_delta",
+				Usage: Usage{OutputTokens: int(usageEvt.Usage)},
+			},
+		}, true
 	}
-	
-	return events
+
+	// Try to parse as tool use event
+	var toolEvt assistantResponseEvent
+	if err := json.Unmarshal(payload, &toolEvt); err == nil && (toolEvt.ToolUseId != "" || toolEvt.Name != "") {
+		// A tool fragment without a name and not marking the end converts to nothing
+		evt := convertAssistantEventToSSE(toolEvt)
+		return evt, evt.Event != ""
+	}
+	return SSEEvent{}, false
 }
 
 func convertAssistantEventToSSE(evt assistantResponseEvent) SSEEvent {
 	if evt.Content != "" {
-		return SSEEvent{
-			Event: "content_block_delta",
-			Data: map[string]interface{}{
-				"type":  "content_block_delta",
-				"index": 0,
-				"delta": map[string]interface{}{
-					"type": "text_delta",
-					"text": evt.Content,
-				},
-			},
-		}
+		return TextDeltaEvent(evt.Content)
 	} else if evt.ToolUseId != "" && evt.Name != "" && !evt.Stop {
 
 		if evt.Input == nil {
 			return SSEEvent{
 				Event: "content_block_start",
-				Data: map[string]interface{}{
-					"type":  "content_block_start",
-					"index": 1,
-					"content_block": map[string]interface{}{
-						"type":  "tool_use",
-						"id":    evt.ToolUseId,
-						"name":  evt.Name,
-						"input": map[string]interface{}{},
+				Data: ContentBlockStart{
+					Type:  "content_block_start",
+					Index: 1,
+					ContentBlock: ContentBlock{
+						Type:  "tool_use",
+						ID:    evt.ToolUseId,
+						Name:  evt.Name,
+						Input: map[string]interface{}{},
 					},
 				},
 			}
 		} else {
 			return SSEEvent{
 				Event: "content_block_delta",
-				Data: map[string]interface{}{
-					"type":  "content_block_delta",
-					"index": 1,
-					"delta": map[string]interface{}{
-						"type":         "input_json_delta",
-						"id":           evt.ToolUseId,
-						"name":         evt.Name,
-						"partial_json": evt.Input,
+				Data: ContentBlockDelta{
+					Type:  "content_block_delta",
+					Index: 1,
+					Delta: Delta{
+						Type:        "input_json_delta",
+						ID:          evt.ToolUseId,
+						Name:        evt.Name,
+						PartialJSON: evt.Input,
 					},
 				},
 			}
@@ -182,9 +192,9 @@ func convertAssistantEventToSSE(evt assistantResponseEvent) SSEEvent {
 	} else if evt.Stop {
 		return SSEEvent{
 			Event: "content_block_stop",
-			Data: map[string]interface{}{
-				"type":  "content_block_stop",
-				"index": 1,
+			Data: ContentBlockStop{
+				Type:  "content_block_stop",
+				Index: 1,
 			},
 		}
 	}
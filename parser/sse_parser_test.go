@@ -42,3 +42,22 @@ data: [DONE]`
 		fmt.Printf("  data: %s\n\n", string(json))
 	}
 }
+
+func TestParseEventsDropsEmptyEvents(t *testing.T) {
+	// Keep-alive lines and a tool fragment without a name used to come out as
+	// SSEEvent{} and reach clients as "event: \ndata: null"
+	capture := `data: {"content":"","name":"","toolUseId":"","stop":false}
+
+data: {"content":"Hi","name":"","toolUseId":"","stop":false}
+
+data: {"content":"","name":"","toolUseId":"t1","stop":false}
+
+data: {}
+
+data: [DONE]`
+
+	events := ParseEvents([]byte(capture))
+	if len(events) != 1 || events[0].Event != "content_block_delta" {
+		t.Errorf("events = %+v", events)
+	}
+}
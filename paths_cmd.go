@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bestk/kiro2cc/pkg/paths"
+)
+
+// legacyAPIKeyFile 是旧版本保存在 token 文件旁的本地 API key 文件名
+const legacyAPIKeyFile = "kiro2cc-api-key"
+
+// kiro2ccDirs 返回 kiro2cc 自己的文件所在的目录，无法确定时退出
+func kiro2ccDirs() paths.Dirs {
+	dirs, err := paths.Default()
+	if err != nil {
+		exitf(1, os.Stderr, "%v", err)
+	}
+	return dirs
+}
+
+// localAPIKeyPath 返回本地 API key 文件的路径。旧版本把它放在 token 文件所在的 .aws/sso/cache 中，
+// 第一次使用时移动到配置目录；无法移动时继续使用旧文件，保证客户端和服务器读到同一个 key
+func localAPIKeyPath() string {
+	path := kiro2ccDirs().APIKeyFile()
+	legacy := filepath.Join(filepath.Dir(getTokenFilePath()), legacyAPIKeyFile)
+	moved, err := paths.Migrate(legacy, path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "警告: 移动 %s 到 %s 失败: %v\n", legacy, path, err)
+		if _, statErr := os.Stat(legacy); statErr == nil {
+			return legacy
+		}
+	}
+	if moved {
+		fmt.Fprintf(os.Stderr, "已把本地 API key 从 %s 移动到 %s\n", legacy, path)
+	}
+	return path
+}
+
+// pathsOutput 是 paths 命令 --json 时的输出
+type pathsOutput struct {
+	TokenFile  string `json:"token_file"`
+	APIKeyFile string `json:"api_key_file"`
+	paths.Dirs
+}
+
+// printPaths 处理 paths 子命令，显示各文件和目录的位置
+func printPaths(args []string) {
+	fs := flag.NewFlagSet("paths", flag.ExitOnError)
+	addJSONFlag(fs, "以 JSON 输出")
+	fs.Parse(args)
+
+	dirs := kiro2ccDirs()
+	if jsonOutput {
+		writeJSON(os.Stdout, pathsOutput{TokenFile: getTokenFilePath(), APIKeyFile: localAPIKeyPath(), Dirs: dirs})
+		return
+	}
+	fmt.Printf("token 文件:   %s\n", getTokenFilePath())
+	fmt.Printf("本地 API key: %s\n", localAPIKeyPath())
+	fmt.Printf("配置目录:     %s\n", dirs.Config)
+	fmt.Printf("状态目录:     %s\n", dirs.State)
+	fmt.Printf("缓存目录:     %s\n", dirs.Cache)
+	fmt.Printf("日志目录:     %s\n", dirs.Logs)
+}
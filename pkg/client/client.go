@@ -0,0 +1,228 @@
+// Package client 提供访问 kiro2cc 代理的 Go 客户端，
+// 使 Go 程序无需引入完整的 Anthropic SDK 即可调用代理。
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Client 表示指向某个 kiro2cc 实例的客户端
+type Client struct {
+	BaseURL    string
+	APIKey     string
+	HTTPClient *http.Client
+	// SigningSecret 不为空时校验代理的响应签名 (x-kiro2cc-signature)，与服务器的 --signing-secret 相同。
+	// 签名缺失或不符时返回 ErrInvalidSignature
+	SigningSecret string
+}
+
+// New 创建客户端，baseURL 形如 http://localhost:8080
+func New(baseURL, apiKey string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		APIKey:     apiKey,
+		HTTPClient: http.DefaultClient,
+	}
+}
+
+// Message 表示一条对话消息，Content 可以是 string 或 []ContentBlock
+type Message struct {
+	Role    string `json:"role"`
+	Content any    `json:"content"`
+}
+
+// SystemBlock 表示一条 system 消息
+type SystemBlock struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// Tool 表示可供模型调用的工具
+type Tool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	InputSchema map[string]any `json:"input_schema"`
+}
+
+// MessageRequest 表示 /v1/messages 的请求体
+type MessageRequest struct {
+	Model       string         `json:"model"`
+	MaxTokens   int            `json:"max_tokens"`
+	Messages    []Message      `json:"messages"`
+	System      []SystemBlock  `json:"system,omitempty"`
+	Tools       []Tool         `json:"tools,omitempty"`
+	Stream      bool           `json:"stream"`
+	Temperature *float64       `json:"temperature,omitempty"`
+	Metadata    map[string]any `json:"metadata,omitempty"`
+	// ResponseFormat 要求回复是符合 schema 的 JSON，由代理校验并在不符合时重试
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+}
+
+// ResponseFormat 描述结构化输出的要求，Type 为 json_schema
+type ResponseFormat struct {
+	Type   string         `json:"type"`
+	Schema map[string]any `json:"schema"`
+}
+
+// ContentBlock 表示响应中的内容块（text 或 tool_use）
+type ContentBlock struct {
+	Type      string         `json:"type"`
+	Text      string         `json:"text,omitempty"`
+	ID        string         `json:"id,omitempty"`
+	Name      string         `json:"name,omitempty"`
+	Input     map[string]any `json:"input,omitempty"`
+	ToolUseID string         `json:"tool_use_id,omitempty"`
+	Content   string         `json:"content,omitempty"`
+}
+
+// Usage 表示 token 用量
+type Usage struct {
+	InputTokens              int `json:"input_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+}
+
+// MessageResponse 表示非流式响应
+type MessageResponse struct {
+	ID           string         `json:"id"`
+	Type         string         `json:"type"`
+	Role         string         `json:"role"`
+	Model        string         `json:"model"`
+	Content      []ContentBlock `json:"content"`
+	StopReason   string         `json:"stop_reason"`
+	StopSequence *string        `json:"stop_sequence"`
+	Usage        Usage          `json:"usage"`
+}
+
+// Text 拼接响应中所有 text 内容块
+func (r *MessageResponse) Text() string {
+	var sb strings.Builder
+	for _, block := range r.Content {
+		if block.Type == "text" {
+			sb.WriteString(block.Text)
+		}
+	}
+	return sb.String()
+}
+
+// APIError 表示代理返回的 Anthropic 格式错误
+type APIError struct {
+	StatusCode int
+	Type       string
+	// Code 是 kiro2cc 的错误码，例如 KIRO2CC-UPSTREAM-THROTTLED，旧版本服务器不返回
+	Code    string
+	Message string
+}
+
+func (e *APIError) Error() string {
+	kind := e.Type
+	if e.Code != "" {
+		kind += " [" + e.Code + "]"
+	}
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("kiro2cc: %d %s: %s", e.StatusCode, kind, e.Message)
+	}
+	return fmt.Sprintf("kiro2cc: %s: %s", kind, e.Message)
+}
+
+// Messages 发送非流式请求
+func (c *Client) Messages(ctx context.Context, req MessageRequest) (*MessageResponse, error) {
+	req.Stream = false
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("读取响应失败: %v", err)
+	}
+	if c.SigningSecret != "" {
+		if err := VerifySignature(c.SigningSecret, resp.Header.Get(SignatureHeader), data); err != nil {
+			return nil, err
+		}
+	}
+	var msg MessageResponse
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, fmt.Errorf("解析响应失败: %v", err)
+	}
+	return &msg, nil
+}
+
+// MessagesStream 发送流式请求，调用方负责关闭返回的 Stream
+func (c *Client) MessagesStream(ctx context.Context, req MessageRequest) (*Stream, error) {
+	req.Stream = true
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	stream := &Stream{body: resp.Body, reader: bufio.NewReader(resp.Body)}
+	if c.SigningSecret != "" {
+		// 签名在响应体结束后作为 trailer 到达，读取时同步计算摘要
+		stream.resp = resp
+		stream.mac = hmac.New(sha256.New, []byte(c.SigningSecret))
+		stream.reader = bufio.NewReader(io.TeeReader(resp.Body, stream.mac))
+	}
+	return stream, nil
+}
+
+// do 发送请求并在非 200 状态码时返回 APIError
+func (c *Client) do(ctx context.Context, req MessageRequest) (*http.Response, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("序列化请求失败: %v", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/v1/messages", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("创建请求失败: %v", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("anthropic-version", "2023-06-01")
+	if c.APIKey != "" {
+		httpReq.Header.Set("x-api-key", c.APIKey)
+	}
+	if req.Stream {
+		httpReq.Header.Set("Accept", "text/event-stream")
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("发送请求失败: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		apiErr := &APIError{StatusCode: resp.StatusCode, Type: "api_error", Message: strings.TrimSpace(string(data))}
+		var errResp struct {
+			Error struct {
+				Type    string `json:"type"`
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(data, &errResp) == nil && errResp.Error.Type != "" {
+			apiErr.Type = errResp.Error.Type
+			apiErr.Code = errResp.Error.Code
+			apiErr.Message = errResp.Error.Message
+		}
+		return nil, apiErr
+	}
+	return resp, nil
+}
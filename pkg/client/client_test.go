@@ -0,0 +1,155 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newMockServer 模拟 kiro2cc 的 /v1/messages 端点
+func newMockServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages" || r.Method != http.MethodPost {
+			http.NotFound(w, r)
+			return
+		}
+
+		var req MessageRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+
+		if req.Model == "unknown" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"type":"error","error":{"type":"invalid_request_error","code":"KIRO2CC-INVALID-REQUEST","message":"Unknown or unsupported model: unknown"}}`)
+			return
+		}
+
+		if !req.Stream {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"id":"msg_1","type":"message","role":"assistant","model":"`+req.Model+`","content":[{"type":"text","text":"Hello world!"}],"stop_reason":"end_turn","stop_sequence":null,"usage":{"input_tokens":5,"output_tokens":12}}`)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		events := []string{
+			`event: message_start` + "\n" + `data: {"type":"message_start","message":{"id":"msg_1"}}`,
+			`event: content_block_delta` + "\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hello "}}`,
+			`event: content_block_delta` + "\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"world!"}}`,
+			`event: message_stop` + "\n" + `data: {"type":"message_stop"}`,
+		}
+		if req.Model == "broken" {
+			events = append(events[:2], `event: error`+"\n"+`data: {"type":"error","error":{"type":"api_error","message":"boom"}}`)
+		}
+		fmt.Fprint(w, strings.Join(events, "\n\n")+"\n\n")
+	}))
+}
+
+func TestMessages(t *testing.T) {
+	srv := newMockServer(t)
+	defer srv.Close()
+
+	c := New(srv.URL, "")
+	resp, err := c.Messages(context.Background(), MessageRequest{
+		Model:     "claude-sonnet-4-20250514",
+		MaxTokens: 100,
+		Messages:  []Message{{Role: "user", Content: "Hi"}},
+	})
+	if err != nil {
+		t.Fatalf("Messages: %v", err)
+	}
+	if got := resp.Text(); got != "Hello world!" {
+		t.Errorf("Text() = %q, want %q", got, "Hello world!")
+	}
+	if resp.StopReason != "end_turn" || resp.Usage.OutputTokens != 12 {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+}
+
+func TestMessagesAPIError(t *testing.T) {
+	srv := newMockServer(t)
+	defer srv.Close()
+
+	_, err := New(srv.URL, "").Messages(context.Background(), MessageRequest{Model: "unknown", MaxTokens: 1})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected *APIError, got %v", err)
+	}
+	if apiErr.StatusCode != http.StatusBadRequest || apiErr.Type != "invalid_request_error" || apiErr.Code != "KIRO2CC-INVALID-REQUEST" {
+		t.Errorf("unexpected error: %+v", apiErr)
+	}
+}
+
+func TestMessagesStream(t *testing.T) {
+	srv := newMockServer(t)
+	defer srv.Close()
+
+	stream, err := New(srv.URL, "").MessagesStream(context.Background(), MessageRequest{
+		Model:     "claude-sonnet-4-20250514",
+		MaxTokens: 100,
+		Messages:  []Message{{Role: "user", Content: "Hi"}},
+	})
+	if err != nil {
+		t.Fatalf("MessagesStream: %v", err)
+	}
+	defer stream.Close()
+
+	var types []string
+	var text strings.Builder
+	for stream.Next() {
+		types = append(types, stream.Event().Type)
+		text.WriteString(stream.Event().TextDelta())
+	}
+	if err := stream.Err(); err != nil {
+		t.Fatalf("stream error: %v", err)
+	}
+	if text.String() != "Hello world!" {
+		t.Errorf("text = %q", text.String())
+	}
+	want := "message_start,content_block_delta,content_block_delta,message_stop"
+	if got := strings.Join(types, ","); got != want {
+		t.Errorf("events = %s, want %s", got, want)
+	}
+}
+
+func TestMessagesStreamErrorEvent(t *testing.T) {
+	srv := newMockServer(t)
+	defer srv.Close()
+
+	stream, err := New(srv.URL, "").MessagesStream(context.Background(), MessageRequest{Model: "broken", MaxTokens: 1})
+	if err != nil {
+		t.Fatalf("MessagesStream: %v", err)
+	}
+	defer stream.Close()
+
+	for stream.Next() {
+	}
+	var apiErr *APIError
+	if !errors.As(stream.Err(), &apiErr) || apiErr.Message != "boom" {
+		t.Fatalf("expected api error boom, got %v", stream.Err())
+	}
+}
+
+func TestStreamJoinsDataLines(t *testing.T) {
+	body := "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\ndata:   \"delta\":{\"type\":\"text_delta\",\"text\":\"a  b\"}}\r\n\r\n"
+	stream := &Stream{body: io.NopCloser(strings.NewReader(body)), reader: bufio.NewReader(strings.NewReader(body))}
+
+	if !stream.Next() {
+		t.Fatalf("Next: %v", stream.Err())
+	}
+	want := "{\"type\":\"content_block_delta\",\n  \"delta\":{\"type\":\"text_delta\",\"text\":\"a  b\"}}"
+	if got := string(stream.Event().Data); got != want {
+		t.Errorf("data = %q, want %q", got, want)
+	}
+	if got := stream.Event().TextDelta(); got != "a  b" {
+		t.Errorf("text = %q", got)
+	}
+}
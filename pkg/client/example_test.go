@@ -0,0 +1,42 @@
+package client_test
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/bestk/kiro2cc/pkg/client"
+)
+
+func ExampleClient_Messages() {
+	c := client.New("http://localhost:8080", "")
+	resp, err := c.Messages(context.Background(), client.MessageRequest{
+		Model:     "claude-sonnet-4-20250514",
+		MaxTokens: 1024,
+		Messages:  []client.Message{{Role: "user", Content: "你好"}},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(resp.Text())
+}
+
+func ExampleClient_MessagesStream() {
+	c := client.New("http://localhost:8080", "")
+	stream, err := c.MessagesStream(context.Background(), client.MessageRequest{
+		Model:     "claude-sonnet-4-20250514",
+		MaxTokens: 1024,
+		Messages:  []client.Message{{Role: "user", Content: "写一首短诗"}},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stream.Close()
+
+	for stream.Next() {
+		fmt.Print(stream.Event().TextDelta())
+	}
+	if err := stream.Err(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,36 @@
+package client
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// SignatureHeader 是代理配置了签名密钥时携带响应签名的响应头，流式响应中作为 trailer 发送
+const SignatureHeader = "X-Kiro2cc-Signature"
+
+// ErrInvalidSignature 表示响应缺少签名或签名与响应体不符
+var ErrInvalidSignature = errors.New("kiro2cc: 响应签名无效")
+
+// VerifySignature 检查 signature (x-kiro2cc-signature 的值，形如 sha256=<hex>) 是否是用 secret
+// 对 body 计算的 HMAC-SHA256，不符时返回 ErrInvalidSignature
+func VerifySignature(secret, signature string, body []byte) error {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return checkSignature(signature, mac.Sum(nil))
+}
+
+// checkSignature 比较签名与已计算的摘要
+func checkSignature(signature string, sum []byte) error {
+	digest, ok := strings.CutPrefix(signature, "sha256=")
+	if !ok {
+		return ErrInvalidSignature
+	}
+	expected, err := hex.DecodeString(digest)
+	if err != nil || !hmac.Equal(expected, sum) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
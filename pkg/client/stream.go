@@ -0,0 +1,128 @@
+package client
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Event 表示一个 SSE 事件
+type Event struct {
+	Type string
+	Data json.RawMessage
+}
+
+// TextDelta 返回 text_delta 事件中的文本，其他事件返回空串
+func (e Event) TextDelta() string {
+	if e.Type != "content_block_delta" {
+		return ""
+	}
+	var payload struct {
+		Delta struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"delta"`
+	}
+	if err := json.Unmarshal(e.Data, &payload); err != nil || payload.Delta.Type != "text_delta" {
+		return ""
+	}
+	return payload.Delta.Text
+}
+
+// Stream 按顺序读取代理返回的 SSE 事件
+type Stream struct {
+	body   io.ReadCloser
+	reader *bufio.Reader
+	event  Event
+	err    error
+	// resp 和 mac 只在校验签名时设置，读到流末尾后比较 trailer 中的签名
+	resp *http.Response
+	mac  hash.Hash
+}
+
+// Next 读取下一个事件，流结束或出错时返回 false
+func (s *Stream) Next() bool {
+	if s.err != nil {
+		return false
+	}
+
+	// 按 SSE 规范，同一事件的多行 data 以换行连接，data: 之后只去掉一个可选的空格
+	var eventType string
+	var data []string
+	for {
+		line, err := s.reader.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+
+		switch {
+		case strings.HasPrefix(line, "event:"):
+			eventType = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			value := strings.TrimPrefix(line, "data:")
+			data = append(data, strings.TrimPrefix(value, " "))
+		case line == "" && (eventType != "" || len(data) > 0):
+			return s.emit(eventType, strings.Join(data, "\n"))
+		}
+
+		if err != nil {
+			if err != io.EOF {
+				s.err = fmt.Errorf("读取流失败: %v", err)
+				return false
+			}
+			if eventType != "" || len(data) > 0 {
+				return s.emit(eventType, strings.Join(data, "\n"))
+			}
+			s.err = io.EOF
+			if s.mac != nil {
+				if err := checkSignature(s.resp.Trailer.Get(SignatureHeader), s.mac.Sum(nil)); err != nil {
+					s.err = err
+				}
+			}
+			return false
+		}
+	}
+}
+
+// emit 保存当前事件，error 事件会转换为 APIError
+func (s *Stream) emit(eventType, data string) bool {
+	if eventType == "error" {
+		apiErr := &APIError{Type: "api_error", Message: data}
+		var payload struct {
+			Error struct {
+				Type    string `json:"type"`
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.Unmarshal([]byte(data), &payload) == nil && payload.Error.Type != "" {
+			apiErr.Type = payload.Error.Type
+			apiErr.Code = payload.Error.Code
+			apiErr.Message = payload.Error.Message
+		}
+		s.err = apiErr
+		return false
+	}
+	s.event = Event{Type: eventType, Data: json.RawMessage(data)}
+	return true
+}
+
+// Event 返回最近一次 Next 读取到的事件
+func (s *Stream) Event() Event {
+	return s.event
+}
+
+// Err 返回流中遇到的错误，正常结束时返回 nil
+func (s *Stream) Err() error {
+	if s.err == io.EOF {
+		return nil
+	}
+	return s.err
+}
+
+// Close 关闭底层连接
+func (s *Stream) Close() error {
+	return s.body.Close()
+}
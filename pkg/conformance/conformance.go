@@ -0,0 +1,354 @@
+// Package conformance 把同一个请求分别发给 kiro2cc 和真实的 Anthropic API，比较两者响应的结构
+// (字段路径、类型、流式事件序列) 而不是具体文本，列出代理与 Anthropic 的差异，用于确定转换逻辑的修复优先级
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// AnthropicVersion 是请求真实 Anthropic API 时使用的 anthropic-version
+const AnthropicVersion = "2023-06-01"
+
+// Target 是被比较的一端
+type Target struct {
+	// BaseURL 形如 http://localhost:8080 或 https://api.anthropic.com
+	BaseURL string
+	APIKey  string
+}
+
+// Scenario 是一个比较用例，Body 是 /v1/messages 的请求体，stream 字段决定比较 JSON 还是 SSE
+type Scenario struct {
+	Name string
+	Body []byte
+}
+
+// Deviation 是代理与 Anthropic 的一处差异
+type Deviation struct {
+	// Path 是差异所在的位置，例如 content[0].type、事件序列或 content_block_start/tool_use.content_block.input
+	Path      string `json:"path"`
+	Proxy     string `json:"proxy"`
+	Anthropic string `json:"anthropic"`
+}
+
+// Result 是一个用例的比较结果，Error 不为空时表示无法完成比较
+type Result struct {
+	Scenario   string      `json:"scenario"`
+	Stream     bool        `json:"stream"`
+	Deviations []Deviation `json:"deviations"`
+	Error      string      `json:"error,omitempty"`
+}
+
+// DefaultScenarios 是未指定请求文件时使用的用例：普通文本、工具调用，以及它们的流式版本
+func DefaultScenarios(model string) []Scenario {
+	text := `{"model":%q,"max_tokens":64,"stream":%t,"messages":[{"role":"user","content":"Reply with the single word: pong"}]}`
+	tool := `{"model":%q,"max_tokens":256,"stream":%t,"tool_choice":{"type":"any"},` +
+		`"tools":[{"name":"get_weather","description":"Get the current weather for a city","input_schema":{"type":"object","properties":{"city":{"type":"string"}},"required":["city"]}}],` +
+		`"messages":[{"role":"user","content":"What is the weather in Paris? Use the tool."}]}`
+	return []Scenario{
+		{"text", []byte(fmt.Sprintf(text, model, false))},
+		{"text stream", []byte(fmt.Sprintf(text, model, true))},
+		{"tool use", []byte(fmt.Sprintf(tool, model, false))},
+		{"tool use stream", []byte(fmt.Sprintf(tool, model, true))},
+	}
+}
+
+// Run 依次执行用例，单个用例失败不影响其余用例
+func Run(ctx context.Context, httpClient *http.Client, proxy, anthropic Target, scenarios []Scenario) []Result {
+	results := make([]Result, 0, len(scenarios))
+	for _, sc := range scenarios {
+		results = append(results, runScenario(ctx, httpClient, proxy, anthropic, sc))
+	}
+	return results
+}
+
+func runScenario(ctx context.Context, httpClient *http.Client, proxy, anthropic Target, sc Scenario) Result {
+	result := Result{Scenario: sc.Name}
+	var req struct {
+		Stream bool `json:"stream"`
+	}
+	if err := json.Unmarshal(sc.Body, &req); err != nil {
+		result.Error = fmt.Sprintf("请求体不是有效的 JSON: %v", err)
+		return result
+	}
+	result.Stream = req.Stream
+
+	proxyStatus, proxyBody, err := send(ctx, httpClient, proxy, sc.Body)
+	if err != nil {
+		result.Error = fmt.Sprintf("请求代理失败: %v", err)
+		return result
+	}
+	anthropicStatus, anthropicBody, err := send(ctx, httpClient, anthropic, sc.Body)
+	if err != nil {
+		result.Error = fmt.Sprintf("请求 Anthropic 失败: %v", err)
+		return result
+	}
+
+	// 状态码不同时响应体通常一个是结果一个是错误，逐字段比较没有意义
+	if proxyStatus != anthropicStatus {
+		result.Deviations = append(result.Deviations, Deviation{Path: "status", Proxy: fmt.Sprint(proxyStatus), Anthropic: fmt.Sprint(anthropicStatus)})
+		return result
+	}
+	if req.Stream && proxyStatus == http.StatusOK {
+		deviations, err := CompareStream(proxyBody, anthropicBody)
+		if err != nil {
+			result.Error = err.Error()
+		}
+		result.Deviations = append(result.Deviations, deviations...)
+		return result
+	}
+	deviations, err := CompareJSON(proxyBody, anthropicBody)
+	if err != nil {
+		result.Error = err.Error()
+	}
+	result.Deviations = append(result.Deviations, deviations...)
+	return result
+}
+
+// send 发送请求并读取完整响应，同时携带 x-api-key 和 anthropic-version，代理会忽略后者
+func send(ctx context.Context, httpClient *http.Client, target Target, body []byte) (int, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(target.BaseURL, "/")+"/v1/messages", bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("anthropic-version", AnthropicVersion)
+	if target.APIKey != "" {
+		req.Header.Set("x-api-key", target.APIKey)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.StatusCode, data, nil
+}
+
+// CompareJSON 比较两个 JSON 响应的结构：字段是否存在、类型是否一致，以及 type、role、stop_reason 等枚举字段的取值
+func CompareJSON(proxy, anthropic []byte) ([]Deviation, error) {
+	var p, a any
+	if err := json.Unmarshal(proxy, &p); err != nil {
+		return nil, fmt.Errorf("代理的响应不是有效的 JSON: %v", err)
+	}
+	if err := json.Unmarshal(anthropic, &a); err != nil {
+		return nil, fmt.Errorf("Anthropic 的响应不是有效的 JSON: %v", err)
+	}
+	var deviations []Deviation
+	compareValues("", p, a, &deviations)
+	return deviations, nil
+}
+
+// enumFields 是取值本身属于协议的一部分、需要逐值比较的字段
+var enumFields = map[string]bool{"type": true, "role": true, "stop_reason": true}
+
+// compareValues 递归比较结构。数组按位置比较，长度不同时只比较共同部分并报告长度差异，
+// 因为两端生成的文本块和工具调用数量可能合理地不同
+func compareValues(path string, p, a any, deviations *[]Deviation) {
+	if typeName(p) != typeName(a) {
+		*deviations = append(*deviations, Deviation{Path: displayPath(path), Proxy: typeName(p), Anthropic: typeName(a)})
+		return
+	}
+	switch a := a.(type) {
+	case map[string]any:
+		p := p.(map[string]any)
+		for _, key := range unionKeys(p, a) {
+			child := path + "." + key
+			pv, inProxy := p[key]
+			av, inAnthropic := a[key]
+			switch {
+			case !inProxy:
+				*deviations = append(*deviations, Deviation{Path: displayPath(child), Proxy: "(缺少)", Anthropic: typeName(av)})
+			case !inAnthropic:
+				*deviations = append(*deviations, Deviation{Path: displayPath(child), Proxy: typeName(pv), Anthropic: "(缺少)"})
+			case enumFields[key] && typeName(pv) == "string" && pv != av:
+				*deviations = append(*deviations, Deviation{Path: displayPath(child), Proxy: fmt.Sprint(pv), Anthropic: fmt.Sprint(av)})
+			default:
+				compareValues(child, pv, av, deviations)
+			}
+		}
+	case []any:
+		p := p.([]any)
+		if len(p) != len(a) {
+			*deviations = append(*deviations, Deviation{Path: displayPath(path) + ".length", Proxy: fmt.Sprint(len(p)), Anthropic: fmt.Sprint(len(a))})
+		}
+		for i := 0; i < len(p) && i < len(a); i++ {
+			compareValues(fmt.Sprintf("%s[%d]", path, i), p[i], a[i], deviations)
+		}
+	}
+}
+
+// typeName 返回 JSON 值的类型名
+func typeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func unionKeys(p, a map[string]any) []string {
+	set := map[string]bool{}
+	for key := range p {
+		set[key] = true
+	}
+	for key := range a {
+		set[key] = true
+	}
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func displayPath(path string) string {
+	if path == "" {
+		return "(根)"
+	}
+	return strings.TrimPrefix(path, ".")
+}
+
+// Frame 是一个 SSE 事件
+type Frame struct {
+	Event string
+	Data  any
+}
+
+// ParseSSE 解析 SSE 响应体
+func ParseSSE(body []byte) ([]Frame, error) {
+	var frames []Frame
+	for _, chunk := range strings.Split(strings.ReplaceAll(string(body), "\r\n", "\n"), "\n\n") {
+		var frame Frame
+		for _, line := range strings.Split(chunk, "\n") {
+			switch {
+			case strings.HasPrefix(line, "event:"):
+				frame.Event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+			case strings.HasPrefix(line, "data:"):
+				if err := json.Unmarshal([]byte(strings.TrimSpace(strings.TrimPrefix(line, "data:"))), &frame.Data); err != nil {
+					return nil, fmt.Errorf("无效的 data 行 %q: %v", line, err)
+				}
+			}
+		}
+		if frame.Event != "" {
+			frames = append(frames, frame)
+		}
+	}
+	return frames, nil
+}
+
+// CompareStream 比较两个 SSE 响应：事件序列 (连续的 content_block_delta 合并为一个，ping 忽略)，
+// 以及每种事件在两端第一次出现时的结构
+func CompareStream(proxy, anthropic []byte) ([]Deviation, error) {
+	p, err := ParseSSE(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("代理的流式响应: %v", err)
+	}
+	a, err := ParseSSE(anthropic)
+	if err != nil {
+		return nil, fmt.Errorf("Anthropic 的流式响应: %v", err)
+	}
+
+	var deviations []Deviation
+	pSeq, aSeq := eventSequence(p), eventSequence(a)
+	if strings.Join(pSeq, " ") != strings.Join(aSeq, " ") {
+		deviations = append(deviations, Deviation{Path: "事件序列", Proxy: strings.Join(pSeq, " "), Anthropic: strings.Join(aSeq, " ")})
+	}
+
+	pFirst, aFirst := firstByKind(p), firstByKind(a)
+	kinds := make([]string, 0, len(aFirst))
+	for kind := range aFirst {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		pData, ok := pFirst[kind]
+		if !ok {
+			// 缺少的事件已经体现在事件序列的差异中
+			continue
+		}
+		compareValues(kind, pData, aFirst[kind], &deviations)
+	}
+	return deviations, nil
+}
+
+// eventSequence 返回事件类型序列，连续的 content_block_delta 合并为一个，ping 的数量和位置由时序决定，不参与比较
+func eventSequence(frames []Frame) []string {
+	var sequence []string
+	for _, frame := range frames {
+		if frame.Event == "ping" {
+			continue
+		}
+		if n := len(sequence); n > 0 && frame.Event == "content_block_delta" && sequence[n-1] == frame.Event {
+			continue
+		}
+		sequence = append(sequence, frame.Event)
+	}
+	return sequence
+}
+
+// firstByKind 返回每种事件第一次出现时的 data，内容块事件再按块类型或增量类型区分，
+// 例如 content_block_start/tool_use、content_block_delta/input_json_delta
+func firstByKind(frames []Frame) map[string]any {
+	first := map[string]any{}
+	for _, frame := range frames {
+		kind := frame.Event
+		if data, ok := frame.Data.(map[string]any); ok {
+			for _, field := range []string{"content_block", "delta"} {
+				if inner, ok := data[field].(map[string]any); ok && strings.HasPrefix(frame.Event, "content_block") {
+					if t, ok := inner["type"].(string); ok {
+						kind += "/" + t
+					}
+				}
+			}
+		}
+		if _, seen := first[kind]; !seen {
+			first[kind] = frame.Data
+		}
+	}
+	return first
+}
+
+// WriteReport 以便于阅读的文本格式输出结果，返回差异总数
+func WriteReport(w io.Writer, results []Result) int {
+	total := 0
+	for _, r := range results {
+		mode := "JSON"
+		if r.Stream {
+			mode = "SSE"
+		}
+		switch {
+		case r.Error != "":
+			fmt.Fprintf(w, "✗ %s (%s): %s\n", r.Scenario, mode, r.Error)
+		case len(r.Deviations) == 0:
+			fmt.Fprintf(w, "✓ %s (%s): 结构一致\n", r.Scenario, mode)
+		default:
+			fmt.Fprintf(w, "✗ %s (%s): %d 处差异\n", r.Scenario, mode, len(r.Deviations))
+		}
+		for _, d := range r.Deviations {
+			fmt.Fprintf(w, "    %s\n        代理:      %s\n        Anthropic: %s\n", d.Path, d.Proxy, d.Anthropic)
+		}
+		total += len(r.Deviations)
+	}
+	return total
+}
@@ -0,0 +1,120 @@
+package conformance
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompareJSON(t *testing.T) {
+	anthropic := `{"id":"msg_1","type":"message","role":"assistant","content":[{"type":"text","text":"pong"}],"stop_reason":"end_turn","usage":{"input_tokens":3,"output_tokens":1}}`
+	proxy := `{"id":"msg_2","type":"message","role":"assistant","content":[{"type":"text","text":"Pong!"}],"stop_reason":"max_tokens","usage":{"input_tokens":"3"},"extra":true}`
+
+	deviations, err := CompareJSON([]byte(proxy), []byte(anthropic))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]Deviation{}
+	for _, d := range deviations {
+		got[d.Path] = d
+	}
+	want := map[string]Deviation{
+		"extra":               {Path: "extra", Proxy: "boolean", Anthropic: "(缺少)"},
+		"stop_reason":         {Path: "stop_reason", Proxy: "max_tokens", Anthropic: "end_turn"},
+		"usage.input_tokens":  {Path: "usage.input_tokens", Proxy: "string", Anthropic: "number"},
+		"usage.output_tokens": {Path: "usage.output_tokens", Proxy: "(缺少)", Anthropic: "number"},
+	}
+	if len(got) != len(want) {
+		t.Errorf("deviations = %+v", deviations)
+	}
+	for path, d := range want {
+		if got[path] != d {
+			t.Errorf("%s: got %+v, want %+v", path, got[path], d)
+		}
+	}
+}
+
+const anthropicStream = `event: message_start
+data: {"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","content":[],"usage":{"input_tokens":3,"output_tokens":1}}}
+
+event: content_block_start
+data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}
+
+event: ping
+data: {"type":"ping"}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"po"}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"ng"}}
+
+event: content_block_stop
+data: {"type":"content_block_stop","index":0}
+
+event: message_delta
+data: {"type":"message_delta","delta":{"stop_reason":"end_turn","stop_sequence":null},"usage":{"output_tokens":2}}
+
+event: message_stop
+data: {"type":"message_stop"}
+
+`
+
+func TestCompareStream(t *testing.T) {
+	// 事件数量和 ping 位置不同不算差异
+	same := strings.Replace(anthropicStream, "event: ping\ndata: {\"type\":\"ping\"}\n\n", "", 1)
+	deviations, err := CompareStream([]byte(same), []byte(anthropicStream))
+	if err != nil || len(deviations) != 0 {
+		t.Errorf("deviations = %+v, err = %v", deviations, err)
+	}
+
+	// 缺少 content_block_stop，message_delta 缺少 stop_sequence
+	broken := strings.Replace(same, "event: content_block_stop\ndata: {\"type\":\"content_block_stop\",\"index\":0}\n\n", "", 1)
+	broken = strings.Replace(broken, `,"stop_sequence":null`, "", 1)
+	deviations, err = CompareStream([]byte(broken), []byte(anthropicStream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := map[string]bool{}
+	for _, d := range deviations {
+		paths[d.Path] = true
+	}
+	if len(deviations) != 2 || !paths["事件序列"] || !paths["message_delta.delta.stop_sequence"] {
+		t.Errorf("deviations = %+v", deviations)
+	}
+}
+
+func TestRun(t *testing.T) {
+	var gotKey, gotVersion string
+	anthropic := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotKey, gotVersion = r.Header.Get("x-api-key"), r.Header.Get("anthropic-version")
+		w.Write([]byte(anthropicStream))
+	}))
+	defer anthropic.Close()
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"type":"error"}`, http.StatusBadGateway)
+	}))
+	defer proxy.Close()
+
+	results := Run(context.Background(), http.DefaultClient, Target{BaseURL: proxy.URL}, Target{BaseURL: anthropic.URL, APIKey: "sk-real"},
+		[]Scenario{{Name: "stream", Body: []byte(`{"stream":true}`)}, {Name: "bad", Body: []byte(`{`)}})
+	if gotKey != "sk-real" || gotVersion != AnthropicVersion {
+		t.Errorf("x-api-key = %q, anthropic-version = %q", gotKey, gotVersion)
+	}
+	if len(results) != 2 {
+		t.Fatalf("results = %+v", results)
+	}
+	if d := results[0].Deviations; len(d) != 1 || d[0].Path != "status" || d[0].Proxy != "502" || d[0].Anthropic != "200" {
+		t.Errorf("stream deviations = %+v", d)
+	}
+	if results[1].Error == "" {
+		t.Errorf("invalid scenario body should be reported as an error")
+	}
+
+	var report strings.Builder
+	if n := WriteReport(&report, results); n != 1 || !strings.Contains(report.String(), "✗ stream (SSE): 1 处差异") {
+		t.Errorf("report (%d deviations):\n%s", n, report.String())
+	}
+}
@@ -0,0 +1,357 @@
+// Package loadtest 以固定速率向 kiro2cc 发送合成请求，统计延迟分布、错误分类和服务器进程的内存，
+// 用于验证限流和流式输出在持续负载下的表现
+package loadtest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxInFlight 是 MaxInFlight 为 0 时允许同时进行的请求数
+const DefaultMaxInFlight = 256
+
+// ErrorDropped 是因进行中的请求达到 MaxInFlight 而没有发出的请求在错误分类中的名称
+const ErrorDropped = "dropped: 进行中的请求过多"
+
+// Options 是一次压测的参数
+type Options struct {
+	// BaseURL 形如 http://localhost:8080，请求发往 BaseURL/v1/messages
+	BaseURL string
+	APIKey  string
+	// RPS 是每秒发出的请求数，按固定间隔发出，不等待上一个请求完成
+	RPS      float64
+	Duration time.Duration
+	// Body 是请求体，其中的 stream 字段决定按 SSE 还是 JSON 读取响应
+	Body []byte
+	// MaxInFlight 限制同时进行的请求数，达到上限时本次请求记为 ErrorDropped，为 0 时使用 DefaultMaxInFlight
+	MaxInFlight int
+	// Client 为空时使用 http.DefaultClient
+	Client *http.Client
+	// Memory 不为空时每秒采样一次服务器进程的内存 (字节)
+	Memory func() (uint64, error)
+	// Progress 不为空时每 10 秒输出一行进度
+	Progress io.Writer
+}
+
+// Distribution 是延迟分布
+type Distribution struct {
+	P50  time.Duration `json:"p50"`
+	P90  time.Duration `json:"p90"`
+	P99  time.Duration `json:"p99"`
+	Max  time.Duration `json:"max"`
+	Mean time.Duration `json:"mean"`
+}
+
+// Report 是压测结果
+type Report struct {
+	Requests  int `json:"requests"`
+	Succeeded int `json:"succeeded"`
+	// Errors 按原因统计失败的请求，例如 "HTTP 429 rate_limit_error"、"stream: overloaded_error"
+	Errors map[string]int `json:"errors"`
+	// Latency 是成功请求从发出到读完响应的耗时
+	Latency Distribution `json:"latency"`
+	// FirstEvent 是成功的流式请求从发出到收到第一个 content_block_delta 的耗时
+	FirstEvent *Distribution `json:"first_event,omitempty"`
+	Elapsed    time.Duration `json:"elapsed"`
+	// MemoryPeak 和 MemoryLast 只在设置了 Options.Memory 且采样成功时有值
+	MemoryPeak  uint64 `json:"memory_peak_bytes,omitempty"`
+	MemoryLast  uint64 `json:"memory_last_bytes,omitempty"`
+	MemoryError string `json:"memory_error,omitempty"`
+}
+
+// outcome 是单个请求的结果，err 为空表示成功
+type outcome struct {
+	latency    time.Duration
+	firstEvent time.Duration
+	err        string
+}
+
+// Run 按 RPS 持续发送请求直到 Duration 结束或 ctx 取消，等待进行中的请求完成后返回结果
+func Run(ctx context.Context, opts Options) (Report, error) {
+	if opts.RPS <= 0 {
+		return Report{}, fmt.Errorf("RPS 必须大于 0")
+	}
+	if opts.Duration <= 0 {
+		return Report{}, fmt.Errorf("Duration 必须大于 0")
+	}
+	var req struct {
+		Stream bool `json:"stream"`
+	}
+	if err := json.Unmarshal(opts.Body, &req); err != nil {
+		return Report{}, fmt.Errorf("请求体不是有效的 JSON: %v", err)
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	if opts.MaxInFlight <= 0 {
+		opts.MaxInFlight = DefaultMaxInFlight
+	}
+	url := strings.TrimRight(opts.BaseURL, "/") + "/v1/messages"
+
+	var (
+		mu       sync.Mutex
+		outcomes []outcome
+		wg       sync.WaitGroup
+	)
+	record := func(o outcome) {
+		mu.Lock()
+		outcomes = append(outcomes, o)
+		mu.Unlock()
+	}
+	sem := make(chan struct{}, opts.MaxInFlight)
+
+	runCtx, cancel := context.WithTimeout(ctx, opts.Duration)
+	defer cancel()
+
+	memDone := make(chan struct{})
+	var memPeak, memLast uint64
+	var memErr error
+	if opts.Memory != nil {
+		go func() {
+			defer close(memDone)
+			ticker := time.NewTicker(time.Second)
+			defer ticker.Stop()
+			for {
+				if v, err := opts.Memory(); err != nil {
+					memErr = err
+				} else {
+					memLast = v
+					memPeak = max(memPeak, v)
+				}
+				select {
+				case <-ticker.C:
+				case <-runCtx.Done():
+					return
+				}
+			}
+		}()
+	} else {
+		close(memDone)
+	}
+
+	start := time.Now()
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / opts.RPS))
+	defer ticker.Stop()
+	progress := time.NewTicker(10 * time.Second)
+	defer progress.Stop()
+
+	sent := 0
+	send := func() {
+		sent++
+		select {
+		case sem <- struct{}{}:
+		default:
+			record(outcome{err: ErrorDropped})
+			return
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			// 请求本身不受压测时长限制，时长结束后进行中的请求仍然读完
+			record(doRequest(ctx, opts.Client, url, opts.APIKey, opts.Body, req.Stream))
+		}()
+	}
+
+	send()
+loop:
+	for {
+		select {
+		case <-ticker.C:
+			send()
+		case <-progress.C:
+			if opts.Progress != nil {
+				mu.Lock()
+				done := len(outcomes)
+				mu.Unlock()
+				fmt.Fprintf(opts.Progress, "已用 %s，已发送 %d，已完成 %d\n", time.Since(start).Round(time.Second), sent, done)
+			}
+		case <-runCtx.Done():
+			break loop
+		}
+	}
+	wg.Wait()
+	<-memDone
+
+	report := summarize(outcomes)
+	report.Elapsed = time.Since(start)
+	report.MemoryPeak, report.MemoryLast = memPeak, memLast
+	if memErr != nil {
+		report.MemoryError = memErr.Error()
+	}
+	return report, nil
+}
+
+// doRequest 发送一个请求并读完响应
+func doRequest(ctx context.Context, client *http.Client, url, apiKey string, body []byte, stream bool) outcome {
+	start := time.Now()
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return outcome{err: "request: " + err.Error()}
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		httpReq.Header.Set("x-api-key", apiKey)
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return outcome{err: "transport: " + transportError(err)}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(resp.Body)
+		return outcome{err: fmt.Sprintf("HTTP %d %s", resp.StatusCode, errorType(data))}
+	}
+	if !stream {
+		if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+			return outcome{err: "read: " + transportError(err)}
+		}
+		return outcome{latency: time.Since(start)}
+	}
+
+	var firstEvent time.Duration
+	var event string
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64<<10), 4<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+			if event == "content_block_delta" && firstEvent == 0 {
+				firstEvent = time.Since(start)
+			}
+		case strings.HasPrefix(line, "data:") && event == "error":
+			return outcome{err: "stream: " + errorType([]byte(strings.TrimSpace(strings.TrimPrefix(line, "data:"))))}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return outcome{err: "read: " + transportError(err)}
+	}
+	if event != "message_stop" {
+		return outcome{err: "stream: 未以 message_stop 结束"}
+	}
+	return outcome{latency: time.Since(start), firstEvent: firstEvent}
+}
+
+// errorType 返回 Anthropic 错误响应中的 error.type，无法解析时返回 unknown
+func errorType(data []byte) string {
+	var resp struct {
+		Error struct {
+			Type string `json:"type"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(data, &resp) != nil || resp.Error.Type == "" {
+		return "unknown"
+	}
+	return resp.Error.Type
+}
+
+// transportError 去掉错误中每个请求都不同的部分 (地址、端口)，使同类错误归为一类
+func transportError(err error) string {
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "Client.Timeout"), strings.Contains(msg, "deadline exceeded"):
+		return "timeout"
+	case strings.Contains(msg, "connection refused"):
+		return "connection refused"
+	case strings.Contains(msg, "connection reset"):
+		return "connection reset"
+	case strings.Contains(msg, "EOF"):
+		return "unexpected EOF"
+	}
+	if i := strings.LastIndex(msg, ": "); i >= 0 {
+		return msg[i+2:]
+	}
+	return msg
+}
+
+// summarize 汇总所有请求的结果
+func summarize(outcomes []outcome) Report {
+	report := Report{Requests: len(outcomes), Errors: map[string]int{}}
+	var latencies, firstEvents []time.Duration
+	for _, o := range outcomes {
+		if o.err != "" {
+			report.Errors[o.err]++
+			continue
+		}
+		report.Succeeded++
+		latencies = append(latencies, o.latency)
+		if o.firstEvent > 0 {
+			firstEvents = append(firstEvents, o.firstEvent)
+		}
+	}
+	report.Latency = distribution(latencies)
+	if len(firstEvents) > 0 {
+		d := distribution(firstEvents)
+		report.FirstEvent = &d
+	}
+	return report
+}
+
+// distribution 计算分位数，values 为空时返回零值
+func distribution(values []time.Duration) Distribution {
+	if len(values) == 0 {
+		return Distribution{}
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	var total time.Duration
+	for _, v := range values {
+		total += v
+	}
+	percentile := func(p float64) time.Duration {
+		i := int(float64(len(values))*p+0.5) - 1
+		return values[min(max(i, 0), len(values)-1)]
+	}
+	return Distribution{
+		P50:  percentile(0.50),
+		P90:  percentile(0.90),
+		P99:  percentile(0.99),
+		Max:  values[len(values)-1],
+		Mean: total / time.Duration(len(values)),
+	}
+}
+
+// WriteReport 以便于阅读的文本格式输出结果
+func WriteReport(w io.Writer, r Report) {
+	fmt.Fprintf(w, "耗时 %s，共 %d 个请求，成功 %d，失败 %d\n", r.Elapsed.Round(time.Millisecond), r.Requests, r.Succeeded, r.Requests-r.Succeeded)
+	if r.Succeeded > 0 {
+		writeDistribution(w, "延迟", r.Latency)
+	}
+	if r.FirstEvent != nil {
+		writeDistribution(w, "首个事件", *r.FirstEvent)
+	}
+	if len(r.Errors) > 0 {
+		reasons := make([]string, 0, len(r.Errors))
+		for reason := range r.Errors {
+			reasons = append(reasons, reason)
+		}
+		sort.Slice(reasons, func(i, j int) bool { return r.Errors[reasons[i]] > r.Errors[reasons[j]] })
+		fmt.Fprintf(w, "错误:\n")
+		for _, reason := range reasons {
+			fmt.Fprintf(w, "  %6d  %s\n", r.Errors[reason], reason)
+		}
+	}
+	switch {
+	case r.MemoryPeak > 0:
+		fmt.Fprintf(w, "服务器内存: 峰值 %.1f MiB，结束时 %.1f MiB\n", float64(r.MemoryPeak)/(1<<20), float64(r.MemoryLast)/(1<<20))
+	case r.MemoryError != "":
+		fmt.Fprintf(w, "服务器内存: 采样失败: %s\n", r.MemoryError)
+	}
+}
+
+func writeDistribution(w io.Writer, name string, d Distribution) {
+	fmt.Fprintf(w, "%s: p50 %s  p90 %s  p99 %s  max %s  mean %s\n", name,
+		d.P50.Round(time.Millisecond), d.P90.Round(time.Millisecond), d.P99.Round(time.Millisecond),
+		d.Max.Round(time.Millisecond), d.Mean.Round(time.Millisecond))
+}
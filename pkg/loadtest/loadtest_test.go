@@ -0,0 +1,110 @@
+package loadtest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bestk/kiro2cc/pkg/server"
+)
+
+// newMockServer 启动使用 MockBackend 的代理
+func newMockServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	tokenPath := filepath.Join(t.TempDir(), "token.json")
+	if err := os.WriteFile(tokenPath, []byte(`{"accessToken":"access","refreshToken":"refresh"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	srv, err := server.New(server.Config{
+		TokenPath: tokenPath,
+		Backend:   MockBackend{Latency: 5 * time.Millisecond, Text: "hello from the mock backend"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestRunAgainstMockServer(t *testing.T) {
+	ts := newMockServer(t)
+	for _, stream := range []bool{false, true} {
+		body := `{"model":"claude-sonnet-4-20250514","max_tokens":10,"stream":false,"messages":[{"role":"user","content":"hi"}]}`
+		if stream {
+			body = strings.Replace(body, `"stream":false`, `"stream":true`, 1)
+		}
+		report, err := Run(context.Background(), Options{
+			BaseURL:  ts.URL,
+			RPS:      50,
+			Duration: 300 * time.Millisecond,
+			Body:     []byte(body),
+			Memory:   MetricsMemory(http.DefaultClient, ts.URL+"/metrics", ""),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Requests < 5 || report.Succeeded != report.Requests || len(report.Errors) != 0 {
+			t.Errorf("stream=%v: report = %+v", stream, report)
+		}
+		if report.Latency.P50 <= 0 || report.Latency.Max < report.Latency.P99 {
+			t.Errorf("stream=%v: latency = %+v", stream, report.Latency)
+		}
+		if (report.FirstEvent != nil) != stream {
+			t.Errorf("stream=%v: first event = %+v", stream, report.FirstEvent)
+		}
+		if report.MemoryPeak == 0 || report.MemoryError != "" {
+			t.Errorf("stream=%v: memory peak = %d, error = %q", stream, report.MemoryPeak, report.MemoryError)
+		}
+	}
+}
+
+func TestRunClassifiesErrors(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls%2 == 0 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"type":"error","error":{"type":"rate_limit_error","message":"slow down"}}`))
+			return
+		}
+		w.Write([]byte("event: message_start\ndata: {}\n\nevent: error\ndata: {\"type\":\"error\",\"error\":{\"type\":\"overloaded_error\"}}\n\n"))
+	}))
+	defer ts.Close()
+
+	report, err := Run(context.Background(), Options{
+		BaseURL:     ts.URL,
+		RPS:         40,
+		Duration:    200 * time.Millisecond,
+		Body:        []byte(`{"stream":true}`),
+		MaxInFlight: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Succeeded != 0 || report.Errors["HTTP 429 rate_limit_error"] == 0 || report.Errors["stream: overloaded_error"] == 0 {
+		t.Errorf("errors = %v", report.Errors)
+	}
+
+	var out strings.Builder
+	WriteReport(&out, report)
+	if !strings.Contains(out.String(), "rate_limit_error") {
+		t.Errorf("report:\n%s", out.String())
+	}
+}
+
+func TestDistribution(t *testing.T) {
+	var values []time.Duration
+	for i := 100; i >= 1; i-- {
+		values = append(values, time.Duration(i)*time.Millisecond)
+	}
+	d := distribution(values)
+	if d.P50 != 50*time.Millisecond || d.P90 != 90*time.Millisecond || d.P99 != 99*time.Millisecond || d.Max != 100*time.Millisecond {
+		t.Errorf("distribution = %+v", d)
+	}
+}
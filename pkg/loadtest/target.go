@@ -0,0 +1,83 @@
+package loadtest
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bestk/kiro2cc/parser"
+	"github.com/bestk/kiro2cc/pkg/server"
+)
+
+// heapMetric 是 /metrics 中 Go 堆内存的指标名
+const heapMetric = "go_memstats_heap_inuse_bytes"
+
+// MockBackend 模拟上游：等待 Latency 后把 Text 按词拆分为多个增量返回，不访问网络，
+// 用于在没有真实账号或不想消耗额度时单独测量代理自身的开销
+type MockBackend struct {
+	Latency time.Duration
+	Text    string
+}
+
+// Generate 实现 server.Backend
+func (m MockBackend) Generate(ctx context.Context, anthropicReq server.AnthropicRequest) ([]parser.SSEEvent, error) {
+	select {
+	case <-time.After(m.Latency):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	var events []parser.SSEEvent
+	for _, word := range strings.SplitAfter(m.Text, " ") {
+		if word != "" {
+			events = append(events, parser.TextDeltaEvent(word))
+		}
+	}
+	return events, nil
+}
+
+// RuntimeMemory 返回本进程 Go 堆正在使用的内存，适用于服务器运行在压测进程内的情况
+func RuntimeMemory() (uint64, error) {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapInuse, nil
+}
+
+// MetricsMemory 返回从服务器 /metrics 读取 Go 堆内存的采样函数，adminToken 为空时不携带认证
+func MetricsMemory(client *http.Client, metricsURL, adminToken string) func() (uint64, error) {
+	return func() (uint64, error) {
+		req, err := http.NewRequest(http.MethodGet, metricsURL, nil)
+		if err != nil {
+			return 0, err
+		}
+		if adminToken != "" {
+			req.Header.Set("Authorization", "Bearer "+adminToken)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return 0, fmt.Errorf("%s 返回 %s", metricsURL, resp.Status)
+		}
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if value, ok := strings.CutPrefix(scanner.Text(), heapMetric+" "); ok {
+				v, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+				if err != nil {
+					return 0, fmt.Errorf("无法解析 %s: %v", heapMetric, err)
+				}
+				return uint64(v), nil
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return 0, err
+		}
+		return 0, fmt.Errorf("%s 中没有 %s", metricsURL, heapMetric)
+	}
+}
@@ -0,0 +1,185 @@
+// Package paths 决定 kiro2cc 自己的配置、状态、日志和缓存文件的位置。
+// Linux 等系统遵循 XDG Base Directory 规范，macOS 和 Windows 使用各自约定的目录，
+// 设置了 XDG_* 环境变量时在所有系统上都以环境变量为准
+package paths
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// appName 是各目录下 kiro2cc 使用的子目录名
+const appName = "kiro2cc"
+
+// Dirs 是 kiro2cc 自己的文件所在的目录
+type Dirs struct {
+	// Config 保存用户配置，例如本地 API key
+	Config string `json:"config"`
+	// State 保存需要跨重启保留但不属于配置的数据，例如会话映射和崩溃报告
+	State string `json:"state"`
+	// Cache 保存可以随时删除的数据
+	Cache string `json:"cache"`
+	// Logs 保存日志文件
+	Logs string `json:"logs"`
+}
+
+// Resolve 按 goos 的约定计算目录，getenv 读取环境变量，home 是用户目录
+func Resolve(goos string, getenv func(string) string, home string) (Dirs, error) {
+	join, isAbs := path.Join, path.IsAbs
+	if goos == "windows" {
+		join, isAbs = windowsJoin, isWindowsAbs
+	}
+	// xdg 返回环境变量 name 指定的目录，只接受绝对路径 (XDG 规范要求忽略相对路径)
+	xdg := func(name string) string {
+		dir := getenv(name)
+		if dir == "" || !isAbs(dir) {
+			return ""
+		}
+		return join(dir, appName)
+	}
+	pick := func(name, fallback string) string {
+		if dir := xdg(name); dir != "" {
+			return dir
+		}
+		return fallback
+	}
+
+	var dirs Dirs
+	switch goos {
+	case "windows":
+		appData, localAppData := getenv("APPDATA"), getenv("LOCALAPPDATA")
+		if appData == "" || localAppData == "" {
+			if home == "" {
+				return Dirs{}, errors.New("未设置 APPDATA/LOCALAPPDATA，也无法获取用户目录")
+			}
+			if appData == "" {
+				appData = join(home, "AppData", "Roaming")
+			}
+			if localAppData == "" {
+				localAppData = join(home, "AppData", "Local")
+			}
+		}
+		dirs = Dirs{
+			Config: pick("XDG_CONFIG_HOME", join(appData, appName)),
+			State:  pick("XDG_STATE_HOME", join(localAppData, appName)),
+			Cache:  pick("XDG_CACHE_HOME", join(localAppData, appName, "cache")),
+		}
+		dirs.Logs = join(dirs.State, "logs")
+	case "darwin":
+		if home == "" {
+			return Dirs{}, errors.New("无法获取用户目录")
+		}
+		support := join(home, "Library", "Application Support", appName)
+		dirs = Dirs{
+			Config: pick("XDG_CONFIG_HOME", support),
+			State:  pick("XDG_STATE_HOME", support),
+			Cache:  pick("XDG_CACHE_HOME", join(home, "Library", "Caches", appName)),
+		}
+		dirs.Logs = join(home, "Library", "Logs", appName)
+		if state := xdg("XDG_STATE_HOME"); state != "" {
+			dirs.Logs = join(state, "logs")
+		}
+	default:
+		if home == "" && (xdg("XDG_CONFIG_HOME") == "" || xdg("XDG_STATE_HOME") == "" || xdg("XDG_CACHE_HOME") == "") {
+			return Dirs{}, errors.New("无法获取用户目录")
+		}
+		dirs = Dirs{
+			Config: pick("XDG_CONFIG_HOME", join(home, ".config", appName)),
+			State:  pick("XDG_STATE_HOME", join(home, ".local", "state", appName)),
+			Cache:  pick("XDG_CACHE_HOME", join(home, ".cache", appName)),
+		}
+		dirs.Logs = join(dirs.State, "logs")
+	}
+	return dirs, nil
+}
+
+// Default 返回当前系统和用户的目录
+func Default() (Dirs, error) {
+	home, _ := os.UserHomeDir()
+	dirs, err := Resolve(runtime.GOOS, os.Getenv, home)
+	if err != nil {
+		return Dirs{}, fmt.Errorf("获取 kiro2cc 目录失败: %v", err)
+	}
+	return dirs, nil
+}
+
+// APIKeyFile 返回本地 API key 文件的路径
+func (d Dirs) APIKeyFile() string {
+	return filepath.Join(d.Config, "api-key")
+}
+
+// Migrate 在 to 不存在而 legacy 存在时把旧位置的文件移动到新位置，返回是否移动。
+// 不能重命名 (例如跨文件系统) 时复制后删除旧文件，权限保持不变
+func Migrate(legacy, to string) (bool, error) {
+	if _, err := os.Stat(to); !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+	info, err := os.Stat(legacy)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if info.IsDir() {
+		return false, fmt.Errorf("%s 是目录", legacy)
+	}
+	if err := os.MkdirAll(filepath.Dir(to), 0700); err != nil {
+		return false, err
+	}
+	if err := os.Rename(legacy, to); err == nil {
+		return true, nil
+	}
+	if err := copyFile(legacy, to, info.Mode().Perm()); err != nil {
+		os.Remove(to)
+		return false, err
+	}
+	return true, os.Remove(legacy)
+}
+
+// copyFile 以 mode 权限把 from 复制为新文件 to
+func copyFile(from, to string, mode os.FileMode) error {
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// windowsJoin 以反斜杠拼接 Windows 路径，在其他系统上计算 Windows 的目录时也能得到正确的结果
+func windowsJoin(elem ...string) string {
+	parts := make([]string, 0, len(elem))
+	for i, e := range elem {
+		e = strings.ReplaceAll(e, "/", `\`)
+		if i > 0 {
+			e = strings.TrimLeft(e, `\`)
+		}
+		if e = strings.TrimRight(e, `\`); e != "" {
+			parts = append(parts, e)
+		}
+	}
+	return strings.Join(parts, `\`)
+}
+
+// isWindowsAbs 判断 dir 是否是带盘符或 UNC 的 Windows 绝对路径
+func isWindowsAbs(dir string) bool {
+	if strings.HasPrefix(dir, `\\`) {
+		return true
+	}
+	return len(dir) >= 3 && dir[1] == ':' && (dir[2] == '\\' || dir[2] == '/')
+}
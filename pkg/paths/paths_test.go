@@ -0,0 +1,137 @@
+package paths
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name string
+		goos string
+		env  map[string]string
+		home string
+		want Dirs
+	}{
+		{
+			name: "linux defaults",
+			goos: "linux",
+			home: "/home/alice",
+			want: Dirs{
+				Config: "/home/alice/.config/kiro2cc",
+				State:  "/home/alice/.local/state/kiro2cc",
+				Cache:  "/home/alice/.cache/kiro2cc",
+				Logs:   "/home/alice/.local/state/kiro2cc/logs",
+			},
+		},
+		{
+			name: "linux xdg",
+			goos: "linux",
+			env:  map[string]string{"XDG_CONFIG_HOME": "/etc/xdg-alice", "XDG_STATE_HOME": "/var/lib/alice", "XDG_CACHE_HOME": "relative/cache"},
+			home: "/home/alice",
+			want: Dirs{
+				Config: "/etc/xdg-alice/kiro2cc",
+				State:  "/var/lib/alice/kiro2cc",
+				Cache:  "/home/alice/.cache/kiro2cc",
+				Logs:   "/var/lib/alice/kiro2cc/logs",
+			},
+		},
+		{
+			name: "macos",
+			goos: "darwin",
+			home: "/Users/alice",
+			want: Dirs{
+				Config: "/Users/alice/Library/Application Support/kiro2cc",
+				State:  "/Users/alice/Library/Application Support/kiro2cc",
+				Cache:  "/Users/alice/Library/Caches/kiro2cc",
+				Logs:   "/Users/alice/Library/Logs/kiro2cc",
+			},
+		},
+		{
+			name: "macos xdg",
+			goos: "darwin",
+			env:  map[string]string{"XDG_CONFIG_HOME": "/Users/alice/.config", "XDG_STATE_HOME": "/Users/alice/.local/state"},
+			home: "/Users/alice",
+			want: Dirs{
+				Config: "/Users/alice/.config/kiro2cc",
+				State:  "/Users/alice/.local/state/kiro2cc",
+				Cache:  "/Users/alice/Library/Caches/kiro2cc",
+				Logs:   "/Users/alice/.local/state/kiro2cc/logs",
+			},
+		},
+		{
+			name: "windows",
+			goos: "windows",
+			env:  map[string]string{"APPDATA": `C:\Users\alice\AppData\Roaming`, "LOCALAPPDATA": `C:\Users\alice\AppData\Local`},
+			home: `C:\Users\alice`,
+			want: Dirs{
+				Config: `C:\Users\alice\AppData\Roaming\kiro2cc`,
+				State:  `C:\Users\alice\AppData\Local\kiro2cc`,
+				Cache:  `C:\Users\alice\AppData\Local\kiro2cc\cache`,
+				Logs:   `C:\Users\alice\AppData\Local\kiro2cc\logs`,
+			},
+		},
+		{
+			name: "windows without appdata",
+			goos: "windows",
+			env:  map[string]string{"XDG_CONFIG_HOME": `D:\config`, "XDG_CACHE_HOME": "/not/windows"},
+			home: `C:\Users\alice`,
+			want: Dirs{
+				Config: `D:\config\kiro2cc`,
+				State:  `C:\Users\alice\AppData\Local\kiro2cc`,
+				Cache:  `C:\Users\alice\AppData\Local\kiro2cc\cache`,
+				Logs:   `C:\Users\alice\AppData\Local\kiro2cc\logs`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		got, err := Resolve(tt.goos, func(key string) string { return tt.env[key] }, tt.home)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s:\n got %+v\nwant %+v", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := Resolve("linux", func(string) string { return "" }, ""); err == nil {
+		t.Error("expected an error without a home directory")
+	}
+	env := map[string]string{"XDG_CONFIG_HOME": "/c", "XDG_STATE_HOME": "/s", "XDG_CACHE_HOME": "/k"}
+	if _, err := Resolve("linux", func(key string) string { return env[key] }, ""); err != nil {
+		t.Errorf("all XDG variables set: %v", err)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	dir := t.TempDir()
+	legacy := filepath.Join(dir, "old", "kiro2cc-api-key")
+	to := filepath.Join(dir, "config", "kiro2cc", "api-key")
+
+	if moved, err := Migrate(legacy, to); moved || err != nil {
+		t.Fatalf("missing legacy file: moved=%v err=%v", moved, err)
+	}
+
+	os.MkdirAll(filepath.Dir(legacy), 0700)
+	os.WriteFile(legacy, []byte("sk-kiro2cc-old\n"), 0600)
+	if moved, err := Migrate(legacy, to); !moved || err != nil {
+		t.Fatalf("moved=%v err=%v", moved, err)
+	}
+	if data, _ := os.ReadFile(to); string(data) != "sk-kiro2cc-old\n" {
+		t.Errorf("migrated content = %q", data)
+	}
+	if _, err := os.Stat(legacy); !os.IsNotExist(err) {
+		t.Errorf("legacy file still exists: %v", err)
+	}
+
+	// 新位置已有文件时不覆盖
+	os.WriteFile(legacy, []byte("sk-kiro2cc-stale\n"), 0600)
+	if moved, err := Migrate(legacy, to); moved || err != nil {
+		t.Fatalf("existing target: moved=%v err=%v", moved, err)
+	}
+	if data, _ := os.ReadFile(to); string(data) != "sk-kiro2cc-old\n" {
+		t.Errorf("target overwritten: %q", data)
+	}
+}
@@ -0,0 +1,159 @@
+// Package prompt 加载和渲染可复用的提示词模板，模板以 YAML 描述 model、system、messages 和 tools，
+// 字符串字段使用 text/template 语法引用变量
+package prompt
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/bestk/kiro2cc/pkg/client"
+)
+
+// DefaultMaxTokens 是模板未指定 max_tokens 时的输出上限
+const DefaultMaxTokens = 4096
+
+// Message 表示模板中的一条消息
+type Message struct {
+	Role    string `yaml:"role"`
+	Content string `yaml:"content"`
+}
+
+// Tool 表示模板中声明的工具
+type Tool struct {
+	Name        string         `yaml:"name"`
+	Description string         `yaml:"description"`
+	InputSchema map[string]any `yaml:"input_schema"`
+}
+
+// Template 表示一个提示词模板，Vars 是变量的默认值
+type Template struct {
+	Model     string            `yaml:"model"`
+	MaxTokens int               `yaml:"max_tokens"`
+	System    string            `yaml:"system"`
+	Messages  []Message         `yaml:"messages"`
+	Tools     []Tool            `yaml:"tools"`
+	Vars      map[string]string `yaml:"vars"`
+
+	// dir 是模板所在目录，file 函数的相对路径以它为准
+	dir string
+}
+
+// Load 读取并解析模板文件，未知字段视为错误，避免拼写错误被静默忽略
+func Load(path string) (*Template, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取模板失败: %v", err)
+	}
+	t, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	t.dir = filepath.Dir(path)
+	return t, nil
+}
+
+// Parse 解析模板内容
+func Parse(data []byte) (*Template, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	var t Template
+	if err := decoder.Decode(&t); err != nil {
+		return nil, fmt.Errorf("解析模板失败: %v", err)
+	}
+	if len(t.Messages) == 0 {
+		return nil, fmt.Errorf("模板缺少 messages")
+	}
+	for i, m := range t.Messages {
+		if m.Role != "user" && m.Role != "assistant" {
+			return nil, fmt.Errorf("messages[%d]: role 必须是 user 或 assistant", i)
+		}
+	}
+	return &t, nil
+}
+
+// Render 用 vars 覆盖模板中的默认值后渲染出请求。引用未定义的变量会返回错误，
+// 模型名原样返回，由调用方解析
+func (t *Template) Render(vars map[string]string) (client.MessageRequest, error) {
+	merged := make(map[string]string, len(t.Vars)+len(vars))
+	for k, v := range t.Vars {
+		merged[k] = v
+	}
+	for k, v := range vars {
+		merged[k] = v
+	}
+
+	render := func(field, text string) (string, error) {
+		tmpl, err := template.New(field).Option("missingkey=error").Funcs(t.funcs()).Parse(text)
+		if err != nil {
+			return "", fmt.Errorf("%s: %v", field, err)
+		}
+		var sb strings.Builder
+		if err := tmpl.Execute(&sb, merged); err != nil {
+			return "", fmt.Errorf("%s: %v", field, err)
+		}
+		return sb.String(), nil
+	}
+
+	req := client.MessageRequest{MaxTokens: t.MaxTokens}
+	if req.MaxTokens <= 0 {
+		req.MaxTokens = DefaultMaxTokens
+	}
+	var err error
+	if req.Model, err = render("model", t.Model); err != nil {
+		return req, err
+	}
+	if t.System != "" {
+		system, err := render("system", t.System)
+		if err != nil {
+			return req, err
+		}
+		req.System = []client.SystemBlock{{Type: "text", Text: system}}
+	}
+	for i, m := range t.Messages {
+		content, err := render(fmt.Sprintf("messages[%d]", i), m.Content)
+		if err != nil {
+			return req, err
+		}
+		req.Messages = append(req.Messages, client.Message{Role: m.Role, Content: content})
+	}
+	for i, tool := range t.Tools {
+		description, err := render(fmt.Sprintf("tools[%d]", i), tool.Description)
+		if err != nil {
+			return req, err
+		}
+		req.Tools = append(req.Tools, client.Tool{Name: tool.Name, Description: description, InputSchema: tool.InputSchema})
+	}
+	return req, nil
+}
+
+// funcs 返回模板中可用的函数：file 读取文件内容，相对路径以模板所在目录为准
+func (t *Template) funcs() template.FuncMap {
+	return template.FuncMap{
+		"file": func(path string) (string, error) {
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(t.dir, path)
+			}
+			data, err := os.ReadFile(path)
+			return string(data), err
+		},
+	}
+}
+
+// ParseVars 解析命令行中 name=value 形式的变量
+func ParseVars(pairs []string) (map[string]string, error) {
+	vars := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("变量格式应为 name=value: %q", pair)
+		}
+		vars[name] = value
+	}
+	return vars, nil
+}
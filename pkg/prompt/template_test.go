@@ -0,0 +1,93 @@
+package prompt
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const reviewTemplate = `
+model: claude-3-5-haiku
+system: 你是 {{.lang}} 代码审查员
+messages:
+  - role: user
+    content: |
+      审查下面的代码：
+      {{file "code.txt"}}
+tools:
+  - name: report
+    description: 报告 {{.lang}} 问题
+    input_schema:
+      type: object
+      properties:
+        line:
+          type: integer
+vars:
+  lang: Go
+`
+
+func TestRender(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "review.yaml")
+	os.WriteFile(path, []byte(reviewTemplate), 0644)
+	os.WriteFile(filepath.Join(dir, "code.txt"), []byte("func main() {}"), 0644)
+
+	tmpl, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := tmpl.Render(map[string]string{"lang": "Rust"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if req.Model != "claude-3-5-haiku" || req.MaxTokens != DefaultMaxTokens {
+		t.Errorf("model=%q max_tokens=%d", req.Model, req.MaxTokens)
+	}
+	if req.System[0].Text != "你是 Rust 代码审查员" {
+		t.Errorf("system = %q", req.System[0].Text)
+	}
+	if content := req.Messages[0].Content.(string); !strings.Contains(content, "func main() {}") {
+		t.Errorf("file not rendered: %q", content)
+	}
+	if req.Tools[0].Description != "报告 Rust 问题" || req.Tools[0].InputSchema["type"] != "object" {
+		t.Errorf("unexpected tool: %+v", req.Tools[0])
+	}
+
+	// 不传变量时使用模板中的默认值
+	req, err = tmpl.Render(nil)
+	if err != nil || req.System[0].Text != "你是 Go 代码审查员" {
+		t.Errorf("default var not used: %v %+v", err, req.System)
+	}
+}
+
+func TestRenderErrors(t *testing.T) {
+	tmpl, err := Parse([]byte("messages:\n  - role: user\n    content: hi {{.name}}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tmpl.Render(nil); err == nil || !strings.Contains(err.Error(), "messages[0]") {
+		t.Errorf("missing var: err = %v", err)
+	}
+
+	for _, bad := range []string{
+		"model: x\n",
+		"messages:\n  - role: system\n    content: hi\n",
+		"messages:\n  - role: user\n    content: hi\nmodle: x\n",
+	} {
+		if _, err := Parse([]byte(bad)); err == nil {
+			t.Errorf("Parse(%q) should fail", bad)
+		}
+	}
+}
+
+func TestParseVars(t *testing.T) {
+	vars, err := ParseVars([]string{"name=value", "expr=a=b", "empty="})
+	if err != nil || vars["name"] != "value" || vars["expr"] != "a=b" || vars["empty"] != "" {
+		t.Errorf("ParseVars = %v, %v", vars, err)
+	}
+	if _, err := ParseVars([]string{"novalue"}); err == nil {
+		t.Error("ParseVars should reject pairs without =")
+	}
+}
@@ -0,0 +1,65 @@
+package redis_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bestk/kiro2cc/pkg/redis"
+	"github.com/bestk/kiro2cc/pkg/redis/redistest"
+)
+
+func TestClientCommands(t *testing.T) {
+	srv := redistest.NewServer(t)
+	client, err := redis.ParseURL(srv.URL() + "/1")
+	if err != nil {
+		t.Fatalf("ParseURL: %v", err)
+	}
+	defer client.Close()
+	ctx := context.Background()
+
+	if _, err := client.Get(ctx, "missing"); !errors.Is(err, redis.ErrNil) {
+		t.Fatalf("Get missing: %v", err)
+	}
+	if ok, err := client.SetNX(ctx, "k", "v1", time.Minute); err != nil || !ok {
+		t.Fatalf("SetNX: %v %v", ok, err)
+	}
+	if ok, err := client.SetNX(ctx, "k", "v2", time.Minute); err != nil || ok {
+		t.Fatalf("second SetNX should not overwrite: %v %v", ok, err)
+	}
+	if err := client.Set(ctx, "other", "x", 0); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if value, err := client.Get(ctx, "k"); err != nil || value != "v1" {
+		t.Fatalf("Get = %q, %v", value, err)
+	}
+	keys, err := client.Scan(ctx, "k*")
+	if err != nil || len(keys) != 1 || keys[0] != "k" {
+		t.Fatalf("Scan = %v, %v", keys, err)
+	}
+	if n, err := client.IncrBy(ctx, "counter", 2, time.Minute); err != nil || n != 2 {
+		t.Fatalf("IncrBy = %d, %v", n, err)
+	}
+	if n, err := client.IncrBy(ctx, "counter", 3, time.Minute); err != nil || n != 5 {
+		t.Fatalf("second IncrBy = %d, %v", n, err)
+	}
+	if err := client.Del(ctx, "k"); err != nil {
+		t.Fatalf("Del: %v", err)
+	}
+	if _, err := client.Do(ctx, "NOPE"); err == nil {
+		t.Fatal("expected error reply")
+	}
+	// 错误回复后连接仍可继续使用
+	if value, err := client.Get(ctx, "other"); err != nil || value != "x" {
+		t.Fatalf("Get after error = %q, %v", value, err)
+	}
+}
+
+func TestParseURL(t *testing.T) {
+	for _, bad := range []string{"http://localhost", "redis://localhost/abc", "rediss://localhost"} {
+		if _, err := redis.ParseURL(bad); err == nil {
+			t.Errorf("ParseURL(%q) should fail", bad)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// forwardedHeaders 是反向代理转发请求时添加的请求头
+var forwardedHeaders = []string{"Forwarded", "X-Forwarded-For", "X-Real-IP"}
+
+// adminMiddleware 校验管理接口的访问权限：
+// 配置了 AdminToken 时要求 Authorization: Bearer <AdminToken>，否则只允许本机直接访问。
+// 同一台机器上的反向代理转发的请求也来自本机，带有转发请求头的请求因此同样需要 AdminToken
+func (s *Server) adminMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.config.AdminToken != "" {
+			provided := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(provided), []byte(s.config.AdminToken)) != 1 {
+				sendJSONError(w, http.StatusUnauthorized, "authentication_error", "管理接口需要有效的 admin token")
+				return
+			}
+		} else if !isLoopback(r.RemoteAddr) {
+			sendJSONError(w, http.StatusForbidden, "permission_error", "未配置 admin token 时管理接口只允许本机访问")
+			return
+		} else if forwarded(r) {
+			sendJSONError(w, http.StatusForbidden, "permission_error", "未配置 admin token 时管理接口不接受经反向代理转发的请求")
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// isLoopback 判断远端地址是否为本机
+func isLoopback(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// forwarded 判断请求是否经过反向代理转发
+func forwarded(r *http.Request) bool {
+	for _, header := range forwardedHeaders {
+		if r.Header.Get(header) != "" {
+			return true
+		}
+	}
+	return false
+}
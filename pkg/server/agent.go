@@ -0,0 +1,342 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultAgentMaxSteps 是 agent 模式默认最多执行工具的轮数
+const DefaultAgentMaxSteps = 8
+
+// agentToolTimeout 是单次工具调用的期限
+const agentToolTimeout = 30 * time.Second
+
+// agentOutputLimit 是返回给模型的工具输出上限，超出部分被截断
+const agentOutputLimit = 64 << 10
+
+// agent 模式内置的工具名
+const (
+	agentToolRunCommand = "run_command"
+	agentToolHTTPFetch  = "http_fetch"
+)
+
+// agentRunner 执行白名单内的本地工具，模型发出 tool_use 时由代理自己执行并继续对话
+type agentRunner struct {
+	commands   []string
+	fetchHosts []string
+	maxSteps   int
+	client     *http.Client
+	// mcpTools 记录 MCP 服务器提供的工具由哪个服务器执行
+	mcpTools   map[string]*mcpClient
+	mcpClients []*mcpClient
+}
+
+// startMCPServers 启动配置的 MCP 服务器并登记它们的工具，工具名重复时返回错误
+func (ar *agentRunner) startMCPServers(ctx context.Context, commands []string) error {
+	ar.mcpTools = make(map[string]*mcpClient)
+	for _, command := range commands {
+		c, err := startMCPClient(ctx, command)
+		if err != nil {
+			ar.close()
+			return err
+		}
+		ar.mcpClients = append(ar.mcpClients, c)
+		for _, tool := range c.tools {
+			if ar.owns(tool.Name) {
+				ar.close()
+				return fmt.Errorf("MCP 服务器 %s 的工具 %s 与已有工具重名", command, tool.Name)
+			}
+			ar.mcpTools[tool.Name] = c
+		}
+		fmt.Printf("已连接 MCP 服务器 %s，提供 %d 个工具\n", command, len(c.tools))
+	}
+	return nil
+}
+
+// close 关闭所有 MCP 服务器子进程
+func (ar *agentRunner) close() {
+	for _, c := range ar.mcpClients {
+		c.close()
+	}
+	ar.mcpClients = nil
+}
+
+// tools 返回追加到请求中的工具定义
+func (ar *agentRunner) tools() []AnthropicTool {
+	var tools []AnthropicTool
+	if len(ar.commands) > 0 {
+		tools = append(tools, AnthropicTool{
+			Name:        agentToolRunCommand,
+			Description: "Run a local program and return its combined stdout and stderr. The command is split on whitespace and run without a shell; allowed programs: " + strings.Join(ar.commands, ", "),
+			InputSchema: map[string]any{
+				"type":       "object",
+				"properties": map[string]any{"command": map[string]any{"type": "string", "description": "program followed by its arguments"}},
+				"required":   []any{"command"},
+			},
+		})
+	}
+	if len(ar.fetchHosts) > 0 {
+		tools = append(tools, AnthropicTool{
+			Name:        agentToolHTTPFetch,
+			Description: "Fetch a URL with HTTP GET and return the status and body. Allowed hosts: " + strings.Join(ar.fetchHosts, ", "),
+			InputSchema: map[string]any{
+				"type":       "object",
+				"properties": map[string]any{"url": map[string]any{"type": "string"}},
+				"required":   []any{"url"},
+			},
+		})
+	}
+	for _, c := range ar.mcpClients {
+		for _, tool := range c.tools {
+			tools = append(tools, AnthropicTool{Name: tool.Name, Description: tool.Description, InputSchema: tool.InputSchema})
+		}
+	}
+	return tools
+}
+
+// owns 判断工具是否由代理执行，其他工具的调用原样返回给客户端
+func (ar *agentRunner) owns(name string) bool {
+	if _, ok := ar.mcpTools[name]; ok {
+		return true
+	}
+	return (name == agentToolRunCommand && len(ar.commands) > 0) || (name == agentToolHTTPFetch && len(ar.fetchHosts) > 0)
+}
+
+// run 执行一次工具调用，返回输出和是否出错
+func (ar *agentRunner) run(ctx context.Context, name string, input map[string]any) (string, bool) {
+	ctx, cancel := context.WithTimeout(ctx, agentToolTimeout)
+	defer cancel()
+
+	if c, ok := ar.mcpTools[name]; ok {
+		return c.callTool(ctx, name, input)
+	}
+
+	var output string
+	var err error
+	switch name {
+	case agentToolRunCommand:
+		command, _ := input["command"].(string)
+		output, err = ar.runCommand(ctx, command)
+	case agentToolHTTPFetch:
+		rawURL, _ := input["url"].(string)
+		output, err = ar.fetch(ctx, rawURL)
+	default:
+		err = fmt.Errorf("未知的工具 %s", name)
+	}
+	if err != nil {
+		if output != "" {
+			return truncateOutput(output) + "\n" + err.Error(), true
+		}
+		return err.Error(), true
+	}
+	return truncateOutput(output), false
+}
+
+// runCommand 不经过 shell 直接执行白名单中的程序，避免模型拼接出额外的命令
+func (ar *agentRunner) runCommand(ctx context.Context, command string) (string, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return "", errors.New("command 不能为空")
+	}
+	if !slices.Contains(ar.commands, args[0]) {
+		return "", fmt.Errorf("程序 %s 不在允许列表中 (%s)", args[0], strings.Join(ar.commands, ", "))
+	}
+	output, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+	return string(output), err
+}
+
+// checkURL 检查 URL 是否为 http(s) 且主机在允许列表中
+func (ar *agentRunner) checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("无效的 URL: %q", u.String())
+	}
+	if !slices.Contains(ar.fetchHosts, u.Hostname()) {
+		return fmt.Errorf("主机 %s 不在允许列表中 (%s)", u.Hostname(), strings.Join(ar.fetchHosts, ", "))
+	}
+	return nil
+}
+
+// checkRedirect 用作 http.Client 的 CheckRedirect，每次重定向的目标都要在允许列表中，
+// 否则允许的主机可以把请求转到内网地址
+func (ar *agentRunner) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("重定向次数过多")
+	}
+	if err := ar.checkURL(req.URL); err != nil {
+		return fmt.Errorf("拒绝重定向: %v", err)
+	}
+	return nil
+}
+
+// fetch 以 GET 请求允许列表中的主机
+func (ar *agentRunner) fetch(ctx context.Context, rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("无效的 URL: %q", rawURL)
+	}
+	if err := ar.checkURL(u); err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := ar.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, agentOutputLimit+1))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("HTTP %d\n\n%s", resp.StatusCode, body), nil
+}
+
+// truncateOutput 截断过长的工具输出
+func truncateOutput(output string) string {
+	if len(output) <= agentOutputLimit {
+		return output
+	}
+	return output[:agentOutputLimit] + "\n[输出过长，已截断]"
+}
+
+// agentAccessMiddleware 限制 agent 接口的访问：它会在本机执行程序，
+// 没有配置客户端认证 (APIKeys、JWT 等) 时只允许本机直接访问，与管理接口一样不接受反向代理转发的请求
+func (s *Server) agentAccessMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(s.clientAuth) == 0 && (!isLoopback(r.RemoteAddr) || forwarded(r)) {
+			sendJSONError(w, http.StatusForbidden, "permission_error", "未配置客户端认证时 agent 接口只允许本机访问")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handleAgentMessages 处理 /v1/agent/messages：模型调用内置工具时由代理执行并把结果发回，
+// 直到模型不再调用内置工具或达到轮数上限，只返回最后一条消息。始终以非流式返回
+func (s *Server) handleAgentMessages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "只支持POST请求", http.StatusMethodNotAllowed)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		sendJSONError(w, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("读取请求体失败: %v", err))
+		return
+	}
+	r = r.WithContext(s.logRequestBody(r.Context(), "Agent", body))
+	anthropicReq, reqErr := s.parseAnthropicRequest(body)
+	if reqErr != nil {
+		sendJSONError(w, reqErr.Status, reqErr.Type, reqErr.Message)
+		return
+	}
+	for _, tool := range anthropicReq.Tools {
+		if s.agent.owns(tool.Name) {
+			sendJSONError(w, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("工具名 %s 与 agent 内置工具冲突", tool.Name))
+			return
+		}
+	}
+	anthropicReq.Stream = false
+	anthropicReq.Tools = append(anthropicReq.Tools, s.agent.tools()...)
+
+	messageId := newMessageID()
+	ctx, tracked := s.inflight.track(r.Context(), messageId, anthropicReq)
+	defer s.inflight.untrack(tracked)
+
+	resp, steps, err := s.runAgent(ctx, anthropicReq)
+	w.Header().Set("x-kiro2cc-agent-steps", strconv.Itoa(steps))
+	if err != nil {
+		code := generateErrorCode(ctx, err)
+		var policyErr *PolicyError
+		if errors.As(err, &policyErr) {
+			sendJSONErrorWithCode(w, policyErr.Status, code, policyErr.Type, policyErr.Message)
+			return
+		}
+		var upErr *UpstreamError
+		if errors.As(err, &upErr) {
+			sendJSONErrorWithCode(w, http.StatusBadGateway, code, "api_error", upErr.Error())
+			return
+		}
+		sendJSONErrorWithCode(w, http.StatusInternalServerError, code, "api_error", err.Error())
+		return
+	}
+	resp["id"] = messageId
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// runAgent 循环调用模型并执行内置工具，返回最后一条响应和执行工具的轮数
+func (s *Server) runAgent(ctx context.Context, anthropicReq AnthropicRequest) (map[string]any, int, error) {
+	for step := 0; ; step++ {
+		stepCtx, cancel := context.WithTimeout(ctx, 60*time.Second)
+		events, err := s.generate(stepCtx, anthropicReq)
+		cancel()
+		if err != nil {
+			return nil, step, err
+		}
+		s.recordTranscript(anthropicReq, events)
+		resp := buildMessageResponse(anthropicReq, events)
+
+		content, _ := resp["content"].([]map[string]any)
+		var toolUses []map[string]any
+		for _, block := range content {
+			if block["type"] == "tool_use" {
+				toolUses = append(toolUses, block)
+			}
+		}
+		if len(toolUses) > 0 {
+			resp["stop_reason"] = "tool_use"
+		}
+
+		// 调用了客户端自己的工具时交回客户端处理
+		for _, block := range toolUses {
+			if name, _ := block["name"].(string); !s.agent.owns(name) {
+				return resp, step, nil
+			}
+		}
+		if len(toolUses) == 0 {
+			return resp, step, nil
+		}
+		if step >= s.agent.maxSteps {
+			fmt.Printf("警告: agent 已执行 %d 轮工具，停止继续调用\n", step)
+			return resp, step, nil
+		}
+
+		assistantBlocks := make([]any, 0, len(content))
+		for _, block := range content {
+			assistantBlocks = append(assistantBlocks, block)
+		}
+		resultBlocks := make([]any, 0, len(toolUses))
+		for _, block := range toolUses {
+			name, _ := block["name"].(string)
+			input, _ := block["input"].(map[string]any)
+			// 工具输入可能含有文件内容、命令参数等敏感数据，只在开启 LogBodies 时打印
+			if s.config.LogBodies {
+				fmt.Printf("agent 执行工具 %s: %v\n", name, input)
+			} else {
+				fmt.Printf("agent 执行工具 %s\n", name)
+			}
+			output, isError := s.agent.run(ctx, name, input)
+			resultBlocks = append(resultBlocks, map[string]any{
+				"type":        "tool_result",
+				"tool_use_id": block["id"],
+				"content":     output,
+				"is_error":    isError,
+			})
+		}
+		anthropicReq.Messages = append(anthropicReq.Messages,
+			AnthropicRequestMessage{Role: "assistant", Content: assistantBlocks},
+			AnthropicRequestMessage{Role: "user", Content: resultBlocks},
+		)
+	}
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bestk/kiro2cc/parser"
+)
+
+// scriptedBackend 依次返回预先给定的回复，并记录收到的请求
+type scriptedBackend struct {
+	replies [][]parser.SSEEvent
+	reqs    []AnthropicRequest
+}
+
+func (b *scriptedBackend) Generate(ctx context.Context, anthropicReq AnthropicRequest) ([]parser.SSEEvent, error) {
+	b.reqs = append(b.reqs, anthropicReq)
+	if len(b.reqs) > len(b.replies) {
+		return textEvents("no more replies"), nil
+	}
+	return b.replies[len(b.reqs)-1], nil
+}
+
+// toolUseEvents 构造一次工具调用的上游事件
+func toolUseEvents(id, name, input string) []parser.SSEEvent {
+	quoted, _ := json.Marshal(input)
+	return parser.ParseEvents([]byte(fmt.Sprintf("data: {\"name\":%q,\"toolUseId\":%q}\ndata: {\"name\":%q,\"toolUseId\":%q,\"input\":%s}\ndata: {\"name\":%q,\"toolUseId\":%q,\"stop\":true}\n",
+		name, id, name, id, quoted, name, id)))
+}
+
+func postAgent(t *testing.T, srv *Server, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/v1/agent/messages", strings.NewReader(body))
+	req.RemoteAddr = "127.0.0.1:12345"
+	srv.Handler().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAgentRunsWhitelistedCommand(t *testing.T) {
+	backend := &scriptedBackend{replies: [][]parser.SSEEvent{
+		toolUseEvents("t1", "run_command", `{"command":"echo hello agent"}`),
+		toolUseEvents("t2", "run_command", `{"command":"rm -rf /tmp/nothing"}`),
+		textEvents("done"),
+	}}
+	srv := newTestServer(t, Config{Backend: backend, AgentCommands: []string{"echo"}})
+
+	rec := postAgent(t, srv, `{"model":"claude-sonnet-4-20250514","max_tokens":100,"messages":[{"role":"user","content":"say hello"}]}`)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"text":"done"`) {
+		t.Fatalf("unexpected response: %d %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("x-kiro2cc-agent-steps"); got != "2" {
+		t.Errorf("agent steps = %s, want 2", got)
+	}
+	if len(backend.reqs) != 3 {
+		t.Fatalf("backend called %d times, want 3", len(backend.reqs))
+	}
+	if tools := backend.reqs[0].Tools; len(tools) != 1 || tools[0].Name != "run_command" {
+		t.Errorf("agent tools not added: %+v", tools)
+	}
+
+	result := func(req AnthropicRequest) map[string]any {
+		blocks := req.Messages[len(req.Messages)-1].Content.([]any)
+		return blocks[0].(map[string]any)
+	}
+	if r := result(backend.reqs[1]); r["tool_use_id"] != "t1" || r["content"] != "hello agent\n" || r["is_error"] != false {
+		t.Errorf("unexpected tool result: %v", r)
+	}
+	if r := result(backend.reqs[2]); r["is_error"] != true || !strings.Contains(r["content"].(string), "不在允许列表中") {
+		t.Errorf("non-whitelisted command should fail: %v", r)
+	}
+}
+
+func TestAgentReturnsClientToolsAndStopsAtLimit(t *testing.T) {
+	backend := &scriptedBackend{replies: [][]parser.SSEEvent{toolUseEvents("t1", "get_weather", `{"city":"Paris"}`)}}
+	srv := newTestServer(t, Config{Backend: backend, AgentCommands: []string{"echo"}})
+	rec := postAgent(t, srv, `{"model":"claude-sonnet-4-20250514","max_tokens":100,"messages":[{"role":"user","content":"weather?"}],"tools":[{"name":"get_weather","input_schema":{"type":"object"}}]}`)
+	if !strings.Contains(rec.Body.String(), `"stop_reason":"tool_use"`) || len(backend.reqs) != 1 {
+		t.Errorf("client tool should be returned to the client: %s", rec.Body.String())
+	}
+
+	loop := &scriptedBackend{}
+	for i := 0; i < 5; i++ {
+		loop.replies = append(loop.replies, toolUseEvents(fmt.Sprintf("t%d", i), "run_command", `{"command":"echo again"}`))
+	}
+	srv = newTestServer(t, Config{Backend: loop, AgentCommands: []string{"echo"}, AgentMaxSteps: 2})
+	rec = postAgent(t, srv, `{"model":"claude-sonnet-4-20250514","max_tokens":100,"messages":[{"role":"user","content":"loop"}]}`)
+	if rec.Header().Get("x-kiro2cc-agent-steps") != "2" || len(loop.reqs) != 3 {
+		t.Errorf("agent should stop after 2 steps: steps=%s calls=%d", rec.Header().Get("x-kiro2cc-agent-steps"), len(loop.reqs))
+	}
+
+	rec = postAgent(t, srv, `{"model":"claude-sonnet-4-20250514","max_tokens":100,"messages":[{"role":"user","content":"x"}],"tools":[{"name":"run_command","input_schema":{"type":"object"}}]}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("conflicting tool name: status %d", rec.Code)
+	}
+}
+
+func TestAgentFetchAllowsListedHosts(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("page body"))
+	}))
+	defer upstream.Close()
+
+	ar := &agentRunner{fetchHosts: []string{"127.0.0.1"}, client: upstream.Client()}
+	if out, isErr := ar.run(context.Background(), agentToolHTTPFetch, map[string]any{"url": upstream.URL}); isErr || out != "HTTP 200\n\npage body" {
+		t.Errorf("fetch = %q, %v", out, isErr)
+	}
+	if out, isErr := ar.run(context.Background(), agentToolHTTPFetch, map[string]any{"url": "http://example.com/"}); !isErr || !strings.Contains(out, "不在允许列表中") {
+		t.Errorf("fetch of unlisted host = %q, %v", out, isErr)
+	}
+}
+
+func TestAgentFetchRefusesRedirectToUnlistedHost(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+	}))
+	defer upstream.Close()
+
+	ar := &agentRunner{fetchHosts: []string{"127.0.0.1"}}
+	ar.client = &http.Client{CheckRedirect: ar.checkRedirect}
+	if out, isErr := ar.run(context.Background(), agentToolHTTPFetch, map[string]any{"url": upstream.URL}); !isErr || !strings.Contains(out, "拒绝重定向") {
+		t.Errorf("fetch = %q, %v", out, isErr)
+	}
+}
+
+func TestAgentEndpointRequiresAuthForRemoteClients(t *testing.T) {
+	body := `{"model":"claude-sonnet-4-20250514","max_tokens":100,"messages":[{"role":"user","content":"hi"}]}`
+	remote := func(srv *Server, key string) int {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/v1/agent/messages", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("x-api-key", key)
+		}
+		srv.Handler().ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	srv := newTestServer(t, Config{Backend: &fakeBackend{events: textEvents("hi")}, AgentCommands: []string{"echo"}})
+	if code := remote(srv, ""); code != http.StatusForbidden {
+		t.Errorf("remote client without auth configured: status %d, want 403", code)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/v1/agent/messages", strings.NewReader(body))
+	req.RemoteAddr = "127.0.0.1:12345"
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("proxied request without auth configured: status %d, want 403", rec.Code)
+	}
+	srv = newTestServer(t, Config{Backend: &fakeBackend{events: textEvents("hi")}, AgentCommands: []string{"echo"}, APIKeys: []string{"k"}})
+	if code := remote(srv, "k"); code != http.StatusOK {
+		t.Errorf("authenticated remote client: status %d, want 200", code)
+	}
+}
+
+func TestAgentEndpointDisabledByDefault(t *testing.T) {
+	srv := newTestServer(t, Config{Backend: &fakeBackend{events: textEvents("hi")}})
+	if rec := postAgent(t, srv, `{}`); rec.Code != http.StatusNotFound {
+		t.Errorf("agent endpoint should not exist without agent tools: %d", rec.Code)
+	}
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// localAPIKeyPrefix 让本地 API key 一眼可以和 Kiro 的 access token 区分开
+const localAPIKeyPrefix = "sk-kiro2cc-"
+
+// LoadLocalAPIKey 读取本地 API key，文件不存在时返回 os.ErrNotExist
+func LoadLocalAPIKey(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	key := strings.TrimSpace(string(data))
+	if key == "" {
+		return "", fmt.Errorf("%s 是空文件", path)
+	}
+	return key, nil
+}
+
+// LoadOrCreateLocalAPIKey 读取本地 API key，不存在时生成一个随机的 key 并以 0600 权限保存。
+// 客户端使用这个 key 代替 Kiro 的 access token，真实的 token 不会出现在 shell 历史和客户端配置中
+func LoadOrCreateLocalAPIKey(path string) (string, error) {
+	key, err := LoadLocalAPIKey(path)
+	if !errors.Is(err, os.ErrNotExist) {
+		return key, err
+	}
+
+	random := make([]byte, 24)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	key = localAPIKeyPrefix + hex.EncodeToString(random)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+	// 先把 key 完整写入临时文件再硬链接到目标路径：链接要么成功、要么因目标已存在而失败，
+	// export 和 server 同时首次运行时使用同一个 key，读到的也不会是另一方还没写完的空文件
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(key + "\n"); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	err = os.Link(tmp.Name(), path)
+	if errors.Is(err, os.ErrExist) {
+		return LoadLocalAPIKey(path)
+	}
+	if err != nil {
+		// 文件系统不支持硬链接时退回改名，改名前目标不存在才不会覆盖另一方的 key
+		if _, statErr := os.Lstat(path); statErr == nil {
+			return LoadLocalAPIKey(path)
+		}
+		if err := os.Rename(tmp.Name(), path); err != nil {
+			return "", err
+		}
+	}
+	return key, nil
+}
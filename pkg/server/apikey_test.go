@@ -0,0 +1,90 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestLoadOrCreateLocalAPIKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kiro2cc", "api-key")
+	if _, err := LoadLocalAPIKey(path); !os.IsNotExist(err) {
+		t.Fatalf("LoadLocalAPIKey before creation: %v", err)
+	}
+
+	key, err := LoadOrCreateLocalAPIKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(key, localAPIKeyPrefix) || len(key) != len(localAPIKeyPrefix)+48 {
+		t.Errorf("key = %q", key)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("key file: %v %v", info.Mode(), err)
+	}
+
+	again, err := LoadOrCreateLocalAPIKey(path)
+	if err != nil || again != key {
+		t.Errorf("second call = %q, %v; want %q", again, err, key)
+	}
+}
+
+func TestRequireAPIKey(t *testing.T) {
+	srv := newTestServer(t, Config{APIKeys: []string{"sk-kiro2cc-local"}, Backend: &fakeBackend{events: textEvents("ok")}})
+
+	for _, tc := range []struct {
+		header, value string
+		want          int
+	}{
+		{"", "", http.StatusUnauthorized},
+		{"x-api-key", "access", http.StatusUnauthorized},
+		{"x-api-key", "sk-kiro2cc-local", http.StatusOK},
+		{"Authorization", "Bearer sk-kiro2cc-local", http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(hookTestBody))
+		if tc.header != "" {
+			req.Header.Set(tc.header, tc.value)
+		}
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s %q: status %d, want %d: %s", tc.header, tc.value, rec.Code, tc.want, rec.Body.String())
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("health: %d", rec.Code)
+	}
+}
+
+func TestLoadOrCreateLocalAPIKeyConcurrently(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api-key")
+
+	keys := make([]string, 8)
+	errs := make([]error, len(keys))
+	var wg sync.WaitGroup
+	for i := range keys {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			keys[i], errs[i] = LoadOrCreateLocalAPIKey(path)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range keys {
+		if errs[i] != nil || keys[i] != keys[0] {
+			t.Fatalf("creator %d got %q, %v; want %q", i, keys[i], errs[i], keys[0])
+		}
+	}
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("temporary files left behind: %v", entries)
+	}
+}
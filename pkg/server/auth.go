@@ -0,0 +1,69 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bestk/kiro2cc/pkg/token"
+)
+
+// 上游请求的认证方式
+const (
+	// AuthBearer 使用 Kiro 登录得到的 token 文件，以 Bearer token 认证
+	AuthBearer = "bearer"
+	// AuthSigV4 使用 AWS IAM 凭证按 Signature Version 4 签名
+	AuthSigV4 = "sigv4"
+)
+
+// upstreamAuth 为发往 CodeWhisperer 的请求添加认证信息，body 是完整的请求体
+type upstreamAuth interface {
+	authorize(req *http.Request, body []byte) error
+}
+
+// bearerAuth 每次请求时读取 token 文件，刷新后的 token 无需重启即可生效
+type bearerAuth struct {
+	tokenPath string
+}
+
+func (a bearerAuth) authorize(req *http.Request, body []byte) error {
+	tokenData, err := token.Read(a.tokenPath)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errTokenUnavailable, err)
+	}
+	if strings.TrimSpace(tokenData.AccessToken) == "" {
+		return fmt.Errorf("%w: %s 中缺少 accessToken", errTokenUnavailable, a.tokenPath)
+	}
+	req.Header.Set("Authorization", "Bearer "+tokenData.AccessToken)
+	return nil
+}
+
+// sigV4Auth 每次请求时读取 AWS 凭证并签名，凭证文件轮换后无需重启即可生效
+type sigV4Auth struct {
+	// profile 是 AWS 配置中的 profile 名，为空时按 AWS SDK 的默认顺序查找
+	profile string
+	// defaultRegion 在凭证和环境变量都没有指定区域时使用
+	defaultRegion string
+}
+
+func (a sigV4Auth) authorize(req *http.Request, body []byte) error {
+	creds, err := loadAWSCredentials(a.profile)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errTokenUnavailable, err)
+	}
+	region := creds.Region
+	if region == "" {
+		region = a.defaultRegion
+	}
+	signV4(req, body, creds, region, sigV4Service, time.Now())
+	return nil
+}
+
+// profileArnRegion 返回 profile ARN 中的区域，无法解析时返回 us-east-1
+func profileArnRegion(profileArn string) string {
+	if parts := strings.Split(profileArn, ":"); len(parts) > 3 && parts[3] != "" {
+		return parts[3]
+	}
+	return "us-east-1"
+}
@@ -0,0 +1,237 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bestk/kiro2cc/parser"
+)
+
+// CodeWhispererURL 是 CodeWhisperer generateAssistantResponse 接口地址
+const CodeWhispererURL = "https://codewhisperer.us-east-1.amazonaws.com/generateAssistantResponse"
+
+// Backend 表示把 Anthropic 请求发送到上游并返回解析后事件的后端，
+// HTTP、WebSocket、gRPC 等入口共用同一个 Backend
+type Backend interface {
+	Generate(ctx context.Context, anthropicReq AnthropicRequest) ([]parser.SSEEvent, error)
+}
+
+// UpstreamError 表示上游返回了非 200 状态码
+type UpstreamError struct {
+	StatusCode int
+	Body       string
+	// Type 是上游返回的 AWS 异常类型，例如 AccessDeniedException，无法识别时为空
+	Type string
+}
+
+func (e *UpstreamError) Error() string {
+	return fmt.Sprintf("CodeWhisperer返回错误，状态码: %d, 响应: %s", e.StatusCode, e.Body)
+}
+
+// ProfileMismatch 判断上游是否因为 ProfileArn 不属于 token 对应的账号而拒绝访问。
+// 这种情况刷新 token 没有帮助，需要改用账号自己的 profile
+func (e *UpstreamError) ProfileMismatch() bool {
+	return e.StatusCode == http.StatusForbidden && e.Type == "AccessDeniedException"
+}
+
+// newUpstreamError 根据上游的非 200 响应构造错误，异常类型优先取 x-amzn-ErrorType 响应头，其次取响应体中的 __type
+func newUpstreamError(resp *http.Response, body []byte) *UpstreamError {
+	errType := resp.Header.Get("x-amzn-ErrorType")
+	if errType == "" {
+		var payload struct {
+			Type string `json:"__type"`
+		}
+		json.Unmarshal(body, &payload)
+		errType = payload.Type
+	}
+	// 形如 AccessDeniedException:http://internal.amazon.com/... 或 com.amazon.aws.codewhisperer#AccessDeniedException
+	errType, _, _ = strings.Cut(errType, ":")
+	if i := strings.LastIndex(errType, "#"); i >= 0 {
+		errType = errType[i+1:]
+	}
+	return &UpstreamError{StatusCode: resp.StatusCode, Body: string(body), Type: errType}
+}
+
+// profileMismatchMessage 说明 ProfileArn 与账号不匹配时如何处理
+func profileMismatchMessage(profileArn string) string {
+	return fmt.Sprintf("CodeWhisperer 拒绝访问 profile %s，它可能不属于当前 token 的账号。请运行 kiro2cc profile 查询账号可用的 profile，并设置环境变量 KIRO_PROFILE_ARN 后重启服务器", profileArn)
+}
+
+// codeWhispererBackend 通过 CodeWhisperer 接口生成回复
+type codeWhispererBackend struct {
+	url        string
+	auth       upstreamAuth
+	profileArn string
+	origins    originPolicy
+	triggers   triggerPolicy
+	sessions   sessionStore
+	client     *http.Client
+	// capture 为 true 时把上游响应的原始字节记录到诊断信息中
+	capture bool
+	// logBodies 为 true 时在日志中打印完整的上游请求体和响应体
+	logBodies bool
+	// rawCapture 不为空时，解析响应失败的原始字节保存到 StateDir
+	rawCapture *rawCaptureStore
+	// formats 统计上游响应的格式，供 /metrics 导出
+	formats *upstreamFormats
+}
+
+// Generate 构建 CodeWhisperer 请求、发送并解析响应，超时由 ctx 控制
+func (b *codeWhispererBackend) Generate(ctx context.Context, anthropicReq AnthropicRequest) ([]parser.SSEEvent, error) {
+	// 构建 CodeWhisperer 请求
+	origin := b.origins.pick(anthropicReq)
+	buildStart := time.Now()
+	cwReq := buildCodeWhispererRequest(anthropicReq, b.profileArn, origin)
+	history := measureHistory(cwReq, time.Since(buildStart))
+	fmt.Printf("历史记录: %s\n", history)
+	cwReq.ConversationState.ChatTriggerType = b.triggers.pick(anthropicReq)
+	if b.sessions != nil {
+		// 会话存储不可用时退回到每个请求使用新的 conversationId
+		if id, err := b.sessions.conversationID(ctx, sessionFingerprint(anthropicReq)); err != nil {
+			fmt.Printf("获取会话 conversationId 失败: %v\n", err)
+		} else {
+			cwReq.ConversationState.ConversationId = id
+		}
+	}
+	diag := diagnosticsFrom(ctx)
+	diag.Origin = origin
+	diag.ChatTriggerType = cwReq.ConversationState.ChatTriggerType
+	diag.History = history
+
+	// 序列化请求体，缓冲区在传输层关闭请求体后放回池中
+	reqBuf := getBuffer()
+	if err := json.NewEncoder(reqBuf).Encode(cwReq); err != nil {
+		putBuffer(reqBuf)
+		return nil, fmt.Errorf("序列化请求失败: %v", err)
+	}
+	if len(anthropicReq.ExtraBody) > 0 {
+		merged, err := mergeExtraBody(reqBuf.Bytes(), anthropicReq.ExtraBody)
+		if err != nil {
+			putBuffer(reqBuf)
+			return nil, err
+		}
+		reqBuf.Reset()
+		reqBuf.Write(merged)
+		reqBuf.WriteByte('\n')
+	}
+
+	if b.logBodies {
+		fmt.Printf("\n=========================CodeWhisperer 请求体:\n%s=======================================\n", reqBuf.Bytes())
+	}
+
+	// 创建请求
+	reqBody := newPooledBody(reqBuf)
+	proxyReq, err := http.NewRequestWithContext(ctx, http.MethodPost, b.url, reqBody)
+	if err != nil {
+		reqBody.Close()
+		return nil, fmt.Errorf("创建代理请求失败: %v", err)
+	}
+	proxyReq.ContentLength = int64(reqBody.Len())
+
+	// 设置请求头，认证放在最后，SigV4 签名需要覆盖其他请求头
+	proxyReq.Header.Set("Content-Type", "application/json")
+	proxyReq.Header.Set("Accept", "text/event-stream")
+	proxyReq.Header.Set("User-Agent", "kiro2cc/1.0")
+	proxyReq.Header.Set("X-Amz-Target", "CodeWhispererStreaming_20220101.GenerateAssistantResponse")
+	if err := b.auth.authorize(proxyReq, reqBuf.Bytes()); err != nil {
+		reqBody.Close()
+		return nil, err
+	}
+
+	// 发送请求
+	startTime := time.Now()
+	resp, err := b.client.Do(proxyReq)
+	diag.Latency = time.Since(startTime)
+	if err != nil {
+		return nil, fmt.Errorf("发送请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+	diag.Status = resp.StatusCode
+
+	// 检查响应状态码
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		fmt.Printf("CodeWhisperer 响应错误，状态码: %d, 响应: %s\n", resp.StatusCode, string(body))
+		if b.capture {
+			diag.Raw = body
+		}
+		return nil, newUpstreamError(resp, body)
+	}
+
+	// 读取响应，解析出的事件不引用缓冲区的内存
+	respBuf := getBuffer()
+	defer putBuffer(respBuf)
+	if _, err := respBuf.ReadFrom(resp.Body); err != nil {
+		// 连接在响应中途断开时保留已收到的内容，而不是丢弃已经生成的输出
+		events, _ := parser.ParseEventsFormat(parser.DetectFormat(resp.Header.Get("Content-Type"), respBuf.Bytes()), respBuf.Bytes())
+		if !hasContent(events) {
+			return nil, fmt.Errorf("读取响应失败: %v", err)
+		}
+		fmt.Printf("警告: 读取响应中途失败，返回已收到的 %d 字节: %v\n", respBuf.Len(), err)
+		diag.Truncated = err
+		if b.capture {
+			diag.Raw = bytes.Clone(respBuf.Bytes())
+		}
+		return events, nil
+	}
+	cwRespBody := respBuf.Bytes()
+	if b.capture {
+		diag.Raw = bytes.Clone(cwRespBody)
+	}
+
+	if b.logBodies {
+		fmt.Printf("CodeWhisperer 响应体:\n%s\n", cwRespBody)
+	}
+
+	// 检查是否是错误响应
+	if bytes.Contains(cwRespBody, []byte("Improperly formed request.")) {
+		fmt.Printf("错误: CodeWhisperer返回格式错误: %s\n", cwRespBody)
+		return nil, &UpstreamError{StatusCode: http.StatusBadRequest, Body: string(cwRespBody)}
+	}
+
+	// os.WriteFile(messageId+"response.raw", cwRespBody, 0644)
+
+	// 按 Content-Type 和帧结构选择解码器，上游曾在文本 SSE 和二进制 event stream 之间切换过
+	format := parser.DetectFormat(resp.Header.Get("Content-Type"), cwRespBody)
+	b.formats.record(format)
+	events, decodeErrs := parser.ParseEventsFormat(format, cwRespBody)
+	if len(events) == 0 || len(decodeErrs) > 0 {
+		b.reportParseFailure(ctx, cwRespBody, len(events), decodeErrs)
+	}
+	return events, nil
+}
+
+// reportParseFailure 在上游响应没有解析出事件或有无法解码的内容时打印警告，
+// 并把原始字节保存下来，日志中给出文件路径，便于事后排查上游格式的变化
+func (b *codeWhispererBackend) reportParseFailure(ctx context.Context, raw []byte, events int, decodeErrs []error) {
+	problem := fmt.Sprintf("解析出 %d 个事件", events)
+	if len(decodeErrs) > 0 {
+		problem += fmt.Sprintf("，%d 处无法解码 (第一处: %v)", len(decodeErrs), decodeErrs[0])
+	}
+	if b.rawCapture == nil {
+		fmt.Printf("警告: 上游响应 (%d 字节) 解析异常: %s。设置 --state-dir 后会自动保存原始响应\n", len(raw), problem)
+		return
+	}
+	path, err := b.rawCapture.save(requestIDFrom(ctx), raw)
+	if err != nil {
+		fmt.Printf("警告: 上游响应 (%d 字节) 解析异常: %s，保存原始响应失败: %v\n", len(raw), problem, err)
+		return
+	}
+	fmt.Printf("警告: 上游响应 (%d 字节) 解析异常: %s，原始响应已保存到 %s\n", len(raw), problem, path)
+}
+
+// hasContent 判断事件中是否有文本或工具输入
+func hasContent(events []parser.SSEEvent) bool {
+	for _, e := range events {
+		if e.Event == "content_block_delta" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultSSEWriteTimeout 是写出单个 SSE 事件的默认期限
+const DefaultSSEWriteTimeout = 30 * time.Second
+
+// errSlowClient 表示客户端读取过慢，事件缓冲已满
+var errSlowClient = errors.New("客户端读取过慢，事件缓冲已满")
+
+// setWriteDeadline 为下一次写入设置期限，客户端停止读取时写入会在期限后失败而不是一直阻塞。
+// httptest.ResponseRecorder 等不支持期限的 ResponseWriter 会被忽略
+func setWriteDeadline(w http.ResponseWriter, timeout time.Duration) {
+	if timeout <= 0 {
+		return
+	}
+	err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(timeout))
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		fmt.Printf("设置写入期限失败: %v\n", err)
+	}
+}
+
+// bufferedEventWriter 在独立的 goroutine 中写出事件，生成端不再被慢客户端阻塞。
+// 缓冲写满时放弃剩余事件，向客户端发送 overloaded_error 后结束流
+type bufferedEventWriter struct {
+	ew      eventWriter
+	size    int
+	events  chan brokerEvent
+	done    chan struct{}
+	dropped atomic.Bool
+
+	mu  sync.Mutex
+	err error
+}
+
+func newBufferedEventWriter(ew eventWriter, size int) *bufferedEventWriter {
+	bw := &bufferedEventWriter{ew: ew, size: size, events: make(chan brokerEvent, size), done: make(chan struct{})}
+	go bw.drain()
+	return bw
+}
+
+// Header 在首个事件之前返回底层的响应头
+func (bw *bufferedEventWriter) Header() http.Header {
+	if hw, ok := bw.ew.(headerWriter); ok {
+		return hw.Header()
+	}
+	return http.Header{}
+}
+
+// WriteEvent 把事件放入缓冲，不会阻塞；之前的写入失败或缓冲已满时返回错误
+func (bw *bufferedEventWriter) WriteEvent(eventType string, data any) error {
+	if err := bw.failed(); err != nil {
+		return err
+	}
+	if bw.dropped.Load() {
+		return errSlowClient
+	}
+	select {
+	case bw.events <- brokerEvent{Type: eventType, Data: data}:
+		return nil
+	default:
+		bw.dropped.Store(true)
+		return errSlowClient
+	}
+}
+
+// close 等待缓冲中的事件写完，返回写入过程中的错误
+func (bw *bufferedEventWriter) close() error {
+	close(bw.events)
+	<-bw.done
+	return bw.failed()
+}
+
+func (bw *bufferedEventWriter) failed() error {
+	bw.mu.Lock()
+	defer bw.mu.Unlock()
+	return bw.err
+}
+
+// drain 依次写出缓冲中的事件，出错或被判定为慢客户端后丢弃剩余事件
+func (bw *bufferedEventWriter) drain() {
+	defer close(bw.done)
+	for event := range bw.events {
+		if bw.dropped.Load() || bw.failed() != nil {
+			continue
+		}
+		if err := bw.ew.WriteEvent(event.Type, event.Data); err != nil {
+			bw.mu.Lock()
+			bw.err = err
+			bw.mu.Unlock()
+		}
+	}
+	if bw.dropped.Load() && bw.failed() == nil {
+		writeErrorEvent(bw.ew, "overloaded_error", fmt.Sprintf("客户端读取过慢，已断开 (缓冲 %d 个事件)", bw.size))
+	}
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stalledResponseWriter 模拟停止读取的客户端：第一次写入阻塞，直到 release 被关闭
+type stalledResponseWriter struct {
+	header  http.Header
+	stalled chan struct{}
+	release chan struct{}
+	once    sync.Once
+
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (s *stalledResponseWriter) Header() http.Header { return s.header }
+func (s *stalledResponseWriter) WriteHeader(int)     {}
+func (s *stalledResponseWriter) Flush()              {}
+
+func (s *stalledResponseWriter) Write(p []byte) (int, error) {
+	s.once.Do(func() {
+		close(s.stalled)
+		<-s.release
+	})
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.Write(p)
+}
+
+func TestSlowSSEClientIsDisconnected(t *testing.T) {
+	delay := streamEventDelay
+	streamEventDelay = func() time.Duration { return 0 }
+	defer func() { streamEventDelay = delay }()
+
+	backend := &fakeBackend{events: textEvents(strings.Split(strings.Repeat("tok ", 100), " ")[:100]...)}
+	srv := newTestServer(t, Config{Backend: backend, SSEBufferEvents: 4})
+
+	w := &stalledResponseWriter{header: http.Header{}, stalled: make(chan struct{}), release: make(chan struct{})}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		body := `{"model":"claude-sonnet-4-20250514","max_tokens":10,"stream":true,"messages":[{"role":"user","content":"hi"}]}`
+		srv.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body)))
+	}()
+
+	<-w.stalled
+	// 客户端卡住时生成端不应被阻塞，而是在缓冲写满后放弃
+	deadline := time.Now().Add(2 * time.Second)
+	for len(srv.inflight.snapshot()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("stream producer blocked on a stalled client")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(w.release)
+	<-done
+
+	out := w.buf.String()
+	// 中途放弃的流以 error 事件结束，之前的事件仍要符合流式语法
+	assertConformantStream(t, out)
+	if !strings.Contains(out, "overloaded_error") {
+		t.Fatalf("expected overloaded_error, got:\n%s", out)
+	}
+	if strings.Count(out, "content_block_delta") >= 100 {
+		t.Fatal("all events were written despite the stalled client")
+	}
+}
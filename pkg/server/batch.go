@@ -0,0 +1,132 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// ServeBatch 从 r 逐行读取 Anthropic 请求，直接交给 Backend 处理，并按输入顺序向 w 逐行写出响应。
+// 最多同时处理 concurrency 个请求；单行出错时该行输出 Anthropic 格式的错误对象，不影响其他行。
+// 请求中的 stream 会被忽略，始终以非流式处理
+func (s *Server) ServeBatch(ctx context.Context, r io.Reader, w io.Writer, concurrency int) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	// results 按输入顺序排队，写出协程依次等待每一行的结果
+	results := make(chan chan any, concurrency)
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		encoder := json.NewEncoder(w)
+		for result := range results {
+			encoder.Encode(<-result)
+		}
+	}()
+
+	sem := make(chan struct{}, concurrency)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 10<<20)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		body := append([]byte(nil), line...)
+
+		result := make(chan any, 1)
+		results <- result
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem }()
+			result <- s.batchMessage(ctx, body)
+		}()
+	}
+	close(results)
+	<-written
+	return scanner.Err()
+}
+
+// batchMessage 处理批量输入中的一行，返回响应或错误对象
+func (s *Server) batchMessage(ctx context.Context, body []byte) any {
+	anthropicReq, reqErr := s.parseAnthropicRequest(body)
+	if reqErr != nil {
+		return batchError(reqErr.Type, reqErr.Message)
+	}
+	anthropicReq.Stream = false
+
+	messageId := newMessageID()
+	ctx, tracked := s.inflight.track(ctx, messageId, anthropicReq)
+	defer s.inflight.untrack(tracked)
+	ctx, cancel := context.WithTimeout(ctx, nonStreamRequestTimeout)
+	defer cancel()
+
+	events, err := s.generate(ctx, anthropicReq)
+	if err != nil {
+		code := generateErrorCode(ctx, err)
+		if requestTimedOut(ctx) {
+			return batchErrorWithCode(code, "timeout_error", timeoutMessage(tracked.started, nonStreamRequestTimeout))
+		}
+		var outErr *StructuredOutputError
+		if errors.As(err, &outErr) {
+			return batchErrorWithCode(code, "structured_output_error", outErr.Error())
+		}
+		var hookErr *HookError
+		if errors.As(err, &hookErr) {
+			return batchErrorWithCode(code, hookErr.errorType(), hookErr.Error())
+		}
+		var policyErr *PolicyError
+		if errors.As(err, &policyErr) {
+			return batchErrorWithCode(code, policyErr.Type, policyErr.Message)
+		}
+		var upErr *UpstreamError
+		if !errors.As(err, &upErr) {
+			return batchErrorWithCode(code, "api_error", err.Error())
+		}
+		switch upErr.StatusCode {
+		case http.StatusBadRequest:
+			return batchErrorWithCode(code, "invalid_request_error", "请求参数错误: "+upErr.Body)
+		case http.StatusUnauthorized:
+			return batchErrorWithCode(code, "authentication_error", "认证失败，请检查token")
+		case http.StatusForbidden:
+			if upErr.ProfileMismatch() {
+				return batchErrorWithCode(code, "permission_error", profileMismatchMessage(s.config.ProfileArn))
+			}
+			if refreshErr := s.refreshTokenSilently(); refreshErr == nil {
+				return batchErrorWithCode(code, "permission_error", "Token已刷新，请重试请求")
+			}
+			return batchErrorWithCode(CodeTokenRefreshFailed, "permission_error", "权限不足且Token刷新失败，请重新登录")
+		case http.StatusTooManyRequests:
+			return batchErrorWithCode(code, "rate_limit_error", "请求频率过高，请稍后重试")
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return batchErrorWithCode(code, "overloaded_error", "CodeWhisperer服务暂时不可用，请稍后重试")
+		default:
+			return batchErrorWithCode(code, "api_error", upErr.Error())
+		}
+	}
+
+	s.recordTranscript(anthropicReq, events)
+	resp := buildMessageResponse(anthropicReq, events)
+	resp["id"] = messageId
+	return resp
+}
+
+// batchError 构造与 HTTP 接口一致的错误对象，错误码按类型推断
+func batchError(errType, message string) AnthropicErrorResponse {
+	return batchErrorWithCode(defaultErrorCode(errType), errType, message)
+}
+
+// batchErrorWithCode 构造带错误码的错误对象
+func batchErrorWithCode(code ErrorCode, errType, message string) AnthropicErrorResponse {
+	var resp AnthropicErrorResponse
+	resp.Type = "error"
+	resp.Error.Type = errType
+	resp.Error.Code = code
+	resp.Error.Message = message
+	return resp
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bestk/kiro2cc/parser"
+)
+
+// echoBackend 把最后一条用户消息作为回复，内容为 "fail" 时返回上游错误；
+// 内容越短延时越长，用于验证并发处理时输出仍保持输入顺序
+type echoBackend struct{}
+
+func (echoBackend) Generate(ctx context.Context, anthropicReq AnthropicRequest) ([]parser.SSEEvent, error) {
+	prompt := getMessageContent(anthropicReq.Messages[len(anthropicReq.Messages)-1].Content)
+	if prompt == "fail" {
+		return nil, &UpstreamError{StatusCode: http.StatusTooManyRequests, Body: "throttled"}
+	}
+	time.Sleep(time.Duration(10-len(prompt)) * 5 * time.Millisecond)
+	return textEvents(prompt), nil
+}
+
+func TestServeBatch(t *testing.T) {
+	srv := newTestServer(t, Config{Backend: echoBackend{}})
+
+	line := func(prompt string) string {
+		return fmt.Sprintf(`{"model":"claude-sonnet-4-20250514","max_tokens":10,"stream":true,"messages":[{"role":"user","content":%q}]}`, prompt)
+	}
+	input := strings.Join([]string{
+		line("a"),
+		line("fail"),
+		"not json",
+		"",
+		`{"model":"claude-sonnet-4-20250514","max_tokens":10,"messages":[]}`,
+		line("abcdefg"),
+	}, "\n")
+
+	var out bytes.Buffer
+	if err := srv.ServeBatch(context.Background(), strings.NewReader(input), &out, 4); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("got %d output lines, want 5:\n%s", len(lines), out.String())
+	}
+	want := []string{"text:a", "error:rate_limit_error", "error:invalid_request_error", "error:invalid_request_error", "text:abcdefg"}
+	for i, l := range lines {
+		var resp struct {
+			Type    string `json:"type"`
+			Content []struct {
+				Text string `json:"text"`
+			} `json:"content"`
+			Error struct {
+				Type string `json:"type"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal([]byte(l), &resp); err != nil {
+			t.Fatalf("line %d is not JSON: %v", i, err)
+		}
+		got := "error:" + resp.Error.Type
+		if resp.Type == "message" && len(resp.Content) > 0 {
+			got = "text:" + resp.Content[0].Text
+		}
+		if got != want[i] {
+			t.Errorf("line %d = %s, want %s", i, got, want[i])
+		}
+	}
+}
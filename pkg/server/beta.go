@@ -0,0 +1,217 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc/metadata"
+
+	"github.com/bestk/kiro2cc/parser"
+)
+
+// 代理能够处理的 anthropic-beta 功能
+const (
+	// BetaFineGrainedToolStreaming 要求工具参数按上游给出的原始片段流式返回，不合并为完整的 JSON
+	BetaFineGrainedToolStreaming = "fine-grained-tool-streaming-2025-05-14"
+	// BetaPromptCaching 的 cache_control 会被接受并去掉，缓存用量始终为 0，见 stripCacheControl
+	BetaPromptCaching = "prompt-caching-2024-07-31"
+)
+
+// betaFeature 是 betaRegistry 中的一项
+type betaFeature struct {
+	Name string `json:"name"`
+	// Supported 为 true 时该 beta 被确认并按 Anthropic 的约定处理，否则被忽略
+	Supported   bool   `json:"supported"`
+	Description string `json:"description"`
+}
+
+// betaRegistry 列出代理认识的 anthropic-beta。Supported 的 beta 会出现在 x-kiro2cc-beta-accepted 中；
+// 已知但不支持的 beta 按默认行为处理，不提示；表中没有的 beta 同样被忽略，每个值只在日志中提示一次
+var betaRegistry = []betaFeature{
+	{BetaFineGrainedToolStreaming, true, "工具参数按上游的原始片段流式返回"},
+	{BetaPromptCaching, true, "接受并去掉 cache_control，缓存用量始终为 0"},
+	{"claude-code-20250219", false, "Claude Code 的客户端标识，无需处理"},
+	{"oauth-2025-04-20", false, "代理不使用 Anthropic 账号认证"},
+	{"interleaved-thinking-2025-05-14", false, "上游不返回思考过程"},
+	{"token-efficient-tools-2025-02-19", false, "上游没有对应的工具调用格式"},
+	{"output-128k-2025-02-19", false, "输出长度由上游决定"},
+	{"context-1m-2025-08-07", false, "上下文长度由上游决定"},
+	{"computer-use-2025-01-24", false, "不支持 Anthropic 内置工具"},
+	{"files-api-2025-04-14", false, "不支持 Files API"},
+	{"mcp-client-2025-04-04", false, "不支持由 Anthropic 连接 MCP 服务器，可使用 agent 模式"},
+}
+
+// lookupBeta 在 betaRegistry 中查找 beta
+func lookupBeta(name string) (betaFeature, bool) {
+	for _, feature := range betaRegistry {
+		if feature.Name == name {
+			return feature, true
+		}
+	}
+	return betaFeature{}, false
+}
+
+// betaContextKey 是 ctx 中请求开启的 beta 的键
+type betaContextKey struct{}
+
+// parseBetas 解析 anthropic-beta 头，多个值可以逗号分隔或重复该头，返回确认、已知但忽略和未知的 beta
+func parseBetas(values []string) (accepted, ignored, unknown []string) {
+	seen := map[string]bool{}
+	for _, value := range values {
+		for _, beta := range strings.Split(value, ",") {
+			beta = strings.TrimSpace(beta)
+			if beta == "" || seen[beta] {
+				continue
+			}
+			seen[beta] = true
+			feature, known := lookupBeta(beta)
+			switch {
+			case !known:
+				unknown = append(unknown, beta)
+			case feature.Supported:
+				accepted = append(accepted, beta)
+			default:
+				ignored = append(ignored, beta)
+			}
+		}
+	}
+	sort.Strings(accepted)
+	sort.Strings(ignored)
+	sort.Strings(unknown)
+	return accepted, ignored, unknown
+}
+
+// withBetas 把请求开启的 beta 放入 ctx
+func withBetas(ctx context.Context, accepted []string) context.Context {
+	if len(accepted) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, betaContextKey{}, accepted)
+}
+
+// betaEnabled 判断请求是否开启了指定的 beta
+func betaEnabled(ctx context.Context, beta string) bool {
+	accepted, _ := ctx.Value(betaContextKey{}).([]string)
+	for _, b := range accepted {
+		if b == beta {
+			return true
+		}
+	}
+	return false
+}
+
+// maxUnknownBetas 是最多统计的未知 beta 数量，避免客户端发送随机值占用内存
+const maxUnknownBetas = 100
+
+// unknownBetas 统计客户端发送过的未知 beta，供 /admin/betas 查看
+type unknownBetas struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+// record 计数，每个值第一次出现时打印提示
+func (u *unknownBetas) record(betas []string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, beta := range betas {
+		if u.counts == nil {
+			u.counts = map[string]int64{}
+		}
+		if u.counts[beta] == 0 {
+			if len(u.counts) >= maxUnknownBetas {
+				continue
+			}
+			fmt.Printf("忽略未知的 anthropic-beta: %s\n", beta)
+		}
+		u.counts[beta]++
+	}
+}
+
+func (u *unknownBetas) snapshot() map[string]int64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	counts := make(map[string]int64, len(u.counts))
+	for beta, n := range u.counts {
+		counts[beta] = n
+	}
+	return counts
+}
+
+// betaMiddleware 解析 anthropic-beta 头放入请求的 ctx，并在响应头中告知哪些 beta 被确认、哪些被忽略
+func (s *Server) betaMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		accepted, ignored, unknown := parseBetas(r.Header.Values("Anthropic-Beta"))
+		if len(accepted) > 0 {
+			w.Header().Set("x-kiro2cc-beta-accepted", strings.Join(accepted, ","))
+			r = r.WithContext(withBetas(r.Context(), accepted))
+		}
+		if ignored = append(ignored, unknown...); len(ignored) > 0 {
+			sort.Strings(ignored)
+			w.Header().Set("x-kiro2cc-beta-ignored", strings.Join(ignored, ","))
+		}
+		s.unknownBetas.record(unknown)
+		next(w, r)
+	}
+}
+
+// handleBetas 列出代理认识的 beta 以及客户端发送过的未知 beta 和次数
+func (s *Server) handleBetas(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"betas": betaRegistry, "unknown": s.unknownBetas.snapshot()})
+}
+
+// grpcBetas 从 gRPC 元数据的 anthropic-beta 中读取确认的 beta，约定与 HTTP 相同
+func (s *Server) grpcBetas(ctx context.Context) []string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil
+	}
+	accepted, _, unknown := parseBetas(md.Get("anthropic-beta"))
+	s.unknownBetas.record(unknown)
+	return accepted
+}
+
+// coalesceToolInputDeltas 把同一工具块的连续 input_json_delta 合并为一个，合并后的 partial_json 是完整的参数 JSON。
+// 上游的参数片段可能在任意位置切分，未开启 BetaFineGrainedToolStreaming 的客户端收到的参数与 Anthropic 默认行为一样不会被截断在值的中间
+func coalesceToolInputDeltas(events []parser.SSEEvent) []parser.SSEEvent {
+	merged := make([]parser.SSEEvent, 0, len(events))
+	// pending 是正在合并的 delta 在 merged 中的位置
+	pending := -1
+	var input strings.Builder
+	flush := func() {
+		if pending < 0 {
+			return
+		}
+		data := merged[pending].Data.(parser.ContentBlockDelta)
+		partial := input.String()
+		data.Delta.PartialJSON = &partial
+		merged[pending].Data = data
+		pending = -1
+		input.Reset()
+	}
+
+	for _, e := range events {
+		data, ok := e.Data.(parser.ContentBlockDelta)
+		if !ok || data.Delta.Type != "input_json_delta" || data.Delta.PartialJSON == nil {
+			// 用量帧不转发给客户端，不打断合并
+			if _, usage := upstreamOutputTokens(e.Data); !usage {
+				flush()
+			}
+			merged = append(merged, e)
+			continue
+		}
+		if pending >= 0 && merged[pending].Data.(parser.ContentBlockDelta).Index != data.Index {
+			flush()
+		}
+		if pending < 0 {
+			pending = len(merged)
+			merged = append(merged, e)
+		}
+		input.WriteString(*data.Delta.PartialJSON)
+	}
+	flush()
+	return merged
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bestk/kiro2cc/parser"
+)
+
+// fragmentedToolEvents 返回参数被切成多段的工具调用，与上游的实际输出一致
+func fragmentedToolEvents() []parser.SSEEvent {
+	return parser.ParseEvents([]byte(`data: {"name":"get_weather","toolUseId":"t1"}
+data: {"name":"get_weather","toolUseId":"t1","input":"{\"ci"}
+data: {"name":"get_weather","toolUseId":"t1","input":"ty\": \"Par"}
+data: {"name":"get_weather","toolUseId":"t1","input":"is\"}"}
+data: {"name":"get_weather","toolUseId":"t1","stop":true}
+`))
+}
+
+func TestFineGrainedToolStreamingBeta(t *testing.T) {
+	backend := &fakeBackend{events: fragmentedToolEvents()}
+	srv := newTestServer(t, Config{Backend: backend})
+	body := `{"model":"claude-sonnet-4-20250514","max_tokens":100,"stream":true,"messages":[{"role":"user","content":"weather?"}]}`
+
+	for _, tc := range []struct {
+		name      string
+		betas     []string
+		wantDelta int
+		accepted  string
+		ignored   string
+	}{
+		{"default", nil, 1, "", ""},
+		{"fine grained", []string{"claude-code-20250219," + BetaFineGrainedToolStreaming, "made-up-2099-01-01"}, 3,
+			BetaFineGrainedToolStreaming, "claude-code-20250219,made-up-2099-01-01"},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+		for _, beta := range tc.betas {
+			req.Header.Add("anthropic-beta", beta)
+		}
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", tc.name, rec.Code, rec.Body.String())
+		}
+
+		out := rec.Body.String()
+		assertConformantStream(t, out)
+		if got := strings.Count(out, `"type":"input_json_delta"`); got != tc.wantDelta {
+			t.Errorf("%s: %d input_json_delta events, want %d:\n%s", tc.name, got, tc.wantDelta, out)
+		}
+		if tc.wantDelta == 1 && !strings.Contains(out, `"partial_json":"{\"city\": \"Paris\"}"`) {
+			t.Errorf("%s: merged input missing:\n%s", tc.name, out)
+		}
+		if got := rec.Header().Get("x-kiro2cc-beta-accepted"); got != tc.accepted {
+			t.Errorf("%s: accepted = %q, want %q", tc.name, got, tc.accepted)
+		}
+		if got := rec.Header().Get("x-kiro2cc-beta-ignored"); got != tc.ignored {
+			t.Errorf("%s: ignored = %q, want %q", tc.name, got, tc.ignored)
+		}
+	}
+}
+
+func TestCoalesceToolInputDeltasKeepsBlocksApart(t *testing.T) {
+	events := append(fragmentedToolEvents(), parser.ParseEvents([]byte(`data: {"name":"get_time","toolUseId":"t2"}
+data: {"name":"get_time","toolUseId":"t2","input":"{}"}
+data: {"name":"get_time","toolUseId":"t2","stop":true}
+`))...)
+	// 两个工具块在解析结果中的序号相同，中间的 content_block_stop 必须打断合并
+	var inputs []string
+	for _, e := range coalesceToolInputDeltas(events) {
+		if data, ok := e.Data.(parser.ContentBlockDelta); ok && data.Delta.PartialJSON != nil {
+			inputs = append(inputs, *data.Delta.PartialJSON)
+		}
+	}
+	if len(inputs) != 2 || inputs[0] != `{"city": "Paris"}` || inputs[1] != "{}" {
+		t.Errorf("inputs = %q", inputs)
+	}
+}
+
+func TestBetaRegistryEndpoint(t *testing.T) {
+	srv := newTestServer(t, Config{Backend: &fakeBackend{events: textEvents("ok")}})
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(hookTestBody))
+		req.Header.Set("anthropic-beta", "claude-code-20250219,made-up-2099-01-01")
+		srv.Handler().ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/betas", nil)
+	req.RemoteAddr = "127.0.0.1:12345"
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Betas   []betaFeature    `json:"betas"`
+		Unknown map[string]int64 `json:"unknown"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Betas) != len(betaRegistry) {
+		t.Errorf("betas = %+v", resp.Betas)
+	}
+	// 已知的 beta 不计入未知统计
+	if len(resp.Unknown) != 1 || resp.Unknown["made-up-2099-01-01"] != 3 {
+		t.Errorf("unknown = %v", resp.Unknown)
+	}
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// replayWindow 是判断请求体重复的时间窗口
+	replayWindow = 10 * time.Minute
+	// recentBodiesSweepSize 是摘要数量超过多少时清理窗口外的记录
+	recentBodiesSweepSize = 4096
+)
+
+// seenBody 是一个请求体摘要在窗口内的出现记录
+type seenBody struct {
+	last  time.Time
+	count int
+}
+
+// recentBodies 记录最近一段时间内请求体的摘要，用于发现客户端重试或重放的请求
+type recentBodies struct {
+	window time.Duration
+	now    func() time.Time
+
+	mu   sync.Mutex
+	seen map[string]*seenBody
+}
+
+func newRecentBodies(window time.Duration) *recentBodies {
+	return &recentBodies{window: window, now: time.Now, seen: make(map[string]*seenBody)}
+}
+
+// observe 记录一次摘要，返回窗口内上一次出现距今的时长和包括本次在内的出现次数
+func (rb *recentBodies) observe(hash string) (time.Duration, int) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	now := rb.now()
+	if len(rb.seen) > recentBodiesSweepSize {
+		for key, seen := range rb.seen {
+			if now.Sub(seen.last) > rb.window {
+				delete(rb.seen, key)
+			}
+		}
+	}
+	seen, ok := rb.seen[hash]
+	if !ok || now.Sub(seen.last) > rb.window {
+		rb.seen[hash] = &seenBody{last: now, count: 1}
+		return 0, 1
+	}
+	since := now.Sub(seen.last)
+	seen.last = now
+	seen.count++
+	return since, seen.count
+}
+
+type bodyHashKey struct{}
+
+// bodyHashFrom 返回 ctx 中请求体的 SHA-256 摘要，没有时返回空字符串
+func bodyHashFrom(ctx context.Context) string {
+	hash, _ := ctx.Value(bodyHashKey{}).(string)
+	return hash
+}
+
+// logRequestBody 默认只在日志中记录请求体的 SHA-256 摘要和长度，不记录提示词内容，
+// 开启 LogBodies 时同时打印完整的请求体。窗口内出现相同的请求体时提示可能是重试或重放。
+// 返回带有摘要的 ctx，/admin/requests 中据此展示
+func (s *Server) logRequestBody(ctx context.Context, kind string, body []byte) context.Context {
+	if s.config.LogBodies {
+		fmt.Printf("\n=========================%s 请求体:\n%s\n=======================================\n", kind, body)
+	}
+	sum := sha256.Sum256(body)
+	hash := hex.EncodeToString(sum[:])
+	fmt.Printf("%s 请求体 SHA-256: %s (%d 字节)\n", kind, hash, len(body))
+	if since, count := s.bodies.observe(hash); count > 1 {
+		fmt.Printf("注意: 请求体与 %v 前的请求相同 (%v 内第 %d 次)，可能是客户端重试或重放\n",
+			since.Round(time.Millisecond), s.bodies.window, count)
+	}
+	return context.WithValue(ctx, bodyHashKey{}, hash)
+}
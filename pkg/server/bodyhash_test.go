@@ -0,0 +1,47 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+)
+
+func TestRecentBodies(t *testing.T) {
+	now := time.Now()
+	rb := newRecentBodies(time.Minute)
+	rb.now = func() time.Time { return now }
+
+	if _, count := rb.observe("a"); count != 1 {
+		t.Fatalf("first: count %d", count)
+	}
+	now = now.Add(10 * time.Second)
+	if since, count := rb.observe("a"); count != 2 || since != 10*time.Second {
+		t.Fatalf("repeat: %v %d", since, count)
+	}
+	if _, count := rb.observe("b"); count != 1 {
+		t.Errorf("other body: count %d", count)
+	}
+	// 窗口外重新计数
+	now = now.Add(2 * time.Minute)
+	if _, count := rb.observe("a"); count != 1 {
+		t.Errorf("after window: count %d", count)
+	}
+}
+
+func TestLogRequestBodyHash(t *testing.T) {
+	srv := newTestServer(t, Config{Backend: &fakeBackend{events: textEvents("ok")}})
+	body := []byte(hookTestBody)
+	ctx := srv.logRequestBody(context.Background(), "Anthropic", body)
+	sum := sha256.Sum256(body)
+	if got := bodyHashFrom(ctx); got != hex.EncodeToString(sum[:]) {
+		t.Errorf("body hash = %q", got)
+	}
+
+	_, tracked := srv.inflight.track(ctx, "msg_test", AnthropicRequest{Model: "claude-sonnet-4-20250514"})
+	defer srv.inflight.untrack(tracked)
+	if infos := srv.inflight.snapshot(); len(infos) != 1 || infos[0].BodySHA256 != bodyHashFrom(ctx) {
+		t.Errorf("requests = %+v", infos)
+	}
+}
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// brokerBufferSize 是每个观察者的事件缓冲数，缓冲写满说明客户端读取过慢，会被断开
+const brokerBufferSize = 256
+
+// brokerEvent 表示一个待转发的流式事件
+type brokerEvent struct {
+	Type string
+	Data any
+}
+
+// subscriber 表示一个附加到流上的观察者
+type subscriber struct {
+	ch      chan brokerEvent
+	dropped bool
+}
+
+// broadcast 把一次流式响应的事件分发给所有观察者，并保留已发送的事件供后来者回放
+type broadcast struct {
+	id      string
+	model   string
+	started time.Time
+
+	mu      sync.Mutex
+	history []brokerEvent
+	subs    map[*subscriber]struct{}
+	closed  bool
+}
+
+// publish 把事件发送给所有观察者，不会阻塞；缓冲已满的观察者会被断开
+func (b *broadcast) publish(eventType string, data any) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	event := brokerEvent{Type: eventType, Data: data}
+	b.history = append(b.history, event)
+	for sub := range b.subs {
+		select {
+		case sub.ch <- event:
+		default:
+			sub.dropped = true
+			close(sub.ch)
+			delete(b.subs, sub)
+		}
+	}
+}
+
+// subscribe 附加一个观察者，先回放已发送的事件；流已结束时返回 nil
+func (b *broadcast) subscribe() *subscriber {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil
+	}
+	sub := &subscriber{ch: make(chan brokerEvent, len(b.history)+brokerBufferSize)}
+	for _, event := range b.history {
+		sub.ch <- event
+	}
+	b.subs[sub] = struct{}{}
+	return sub
+}
+
+// unsubscribe 移除观察者，用于观察者主动断开
+func (b *broadcast) unsubscribe(sub *subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subs[sub]; ok {
+		delete(b.subs, sub)
+		close(sub.ch)
+	}
+}
+
+// close 结束广播，观察者读完缓冲的事件后退出
+func (b *broadcast) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for sub := range b.subs {
+		close(sub.ch)
+		delete(b.subs, sub)
+	}
+}
+
+// subscriberCount 返回当前观察者数量
+func (b *broadcast) subscriberCount() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subs)
+}
+
+// streamBroker 管理所有进行中的流式响应，供结对观察和管理面板实时查看
+type streamBroker struct {
+	mu      sync.Mutex
+	streams map[string]*broadcast
+}
+
+func newStreamBroker() *streamBroker {
+	return &streamBroker{streams: make(map[string]*broadcast)}
+}
+
+// open 登记一个新的广播
+func (sb *streamBroker) open(id, model string) *broadcast {
+	b := &broadcast{id: id, model: model, started: time.Now(), subs: make(map[*subscriber]struct{})}
+	sb.mu.Lock()
+	sb.streams[id] = b
+	sb.mu.Unlock()
+	return b
+}
+
+// finish 结束并移除广播
+func (sb *streamBroker) finish(b *broadcast) {
+	sb.mu.Lock()
+	delete(sb.streams, b.id)
+	sb.mu.Unlock()
+	b.close()
+}
+
+// get 返回指定 ID 的进行中广播
+func (sb *streamBroker) get(id string) (*broadcast, bool) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	b, ok := sb.streams[id]
+	return b, ok
+}
+
+// list 按开始时间返回所有进行中的广播
+func (sb *streamBroker) list() []*broadcast {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	streams := make([]*broadcast, 0, len(sb.streams))
+	for _, b := range sb.streams {
+		streams = append(streams, b)
+	}
+	sort.Slice(streams, func(i, j int) bool { return streams[i].started.Before(streams[j].started) })
+	return streams
+}
+
+// teeWriter 把事件写给主客户端的同时广播给观察者
+type teeWriter struct {
+	primary eventWriter
+	b       *broadcast
+}
+
+// WriteEvent 先写主客户端，观察者的快慢不影响主客户端
+func (tw *teeWriter) WriteEvent(eventType string, data any) error {
+	err := tw.primary.WriteEvent(eventType, data)
+	tw.b.publish(eventType, data)
+	return err
+}
+
+// handleStreams 列出进行中的流式响应
+func (s *Server) handleStreams(w http.ResponseWriter, r *http.Request) {
+	type streamInfo struct {
+		ID          string    `json:"id"`
+		Model       string    `json:"model"`
+		Started     time.Time `json:"started"`
+		Subscribers int       `json:"subscribers"`
+	}
+
+	streams := []streamInfo{}
+	for _, b := range s.broker.list() {
+		streams = append(streams, streamInfo{ID: b.id, Model: b.model, Started: b.started.UTC(), Subscribers: b.subscriberCount()})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"streams": streams})
+}
+
+// handleWatchStream 以 SSE 的形式附加到进行中的流式响应上，先回放已发送的事件
+func (s *Server) handleWatchStream(w http.ResponseWriter, r *http.Request) {
+	b, ok := s.broker.get(r.PathValue("id"))
+	if !ok {
+		sendJSONError(w, http.StatusNotFound, "not_found_error", "流不存在或已结束")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported!", http.StatusInternalServerError)
+		return
+	}
+	sub := b.subscribe()
+	if sub == nil {
+		sendJSONError(w, http.StatusNotFound, "not_found_error", "流不存在或已结束")
+		return
+	}
+	defer b.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	ew := &sseWriter{w: w, flusher: flusher}
+
+	for {
+		select {
+		case event, ok := <-sub.ch:
+			if !ok {
+				if sub.dropped {
+					writeErrorEvent(ew, "overloaded_error", fmt.Sprintf("观察者读取过慢，已断开 (缓冲 %d 个事件)", brokerBufferSize))
+				}
+				return
+			}
+			if err := ew.WriteEvent(event.Type, event.Data); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBroadcastDropsSlowSubscriber(t *testing.T) {
+	b := newStreamBroker().open("msg_1", "claude-sonnet-4-20250514")
+	fast := b.subscribe()
+	slow := b.subscribe()
+
+	for i := 0; i < brokerBufferSize+10; i++ {
+		b.publish("content_block_delta", i)
+		if event := <-fast.ch; event.Data != i {
+			t.Fatalf("fast subscriber got %v, want %d", event.Data, i)
+		}
+	}
+	b.close()
+
+	if _, ok := <-fast.ch; ok {
+		t.Fatal("fast subscriber channel should be closed after the broadcast ends")
+	}
+	if !slow.dropped {
+		t.Fatal("slow subscriber should have been dropped")
+	}
+	if b.subscribe() != nil {
+		t.Fatal("subscribing to a closed broadcast should fail")
+	}
+}
+
+func TestWatchStreamReplaysAndFollows(t *testing.T) {
+	srv := newTestServer(t, Config{})
+	b := srv.broker.open("msg_watch", "claude-sonnet-4-20250514")
+	b.publish("message_start", map[string]any{"type": "message_start"})
+
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/admin/streams/msg_watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	reader := bufio.NewReader(resp.Body)
+	if line, _ := reader.ReadString('\n'); line != "event: message_start\n" {
+		t.Fatalf("first line = %q", line)
+	}
+
+	b.publish("message_stop", map[string]any{"type": "message_stop"})
+	srv.broker.finish(b)
+
+	var rest strings.Builder
+	for {
+		line, err := reader.ReadString('\n')
+		rest.WriteString(line)
+		if err != nil {
+			break
+		}
+	}
+	if !strings.Contains(rest.String(), "event: message_stop") {
+		t.Fatalf("missing live event: %s", rest.String())
+	}
+
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, localRequest(http.MethodGet, "/admin/streams/msg_watch"))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("finished stream: status = %d", rec.Code)
+	}
+}
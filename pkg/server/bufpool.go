@@ -0,0 +1,45 @@
+package server
+
+import (
+	"bytes"
+	"sync"
+)
+
+// maxPooledBufferSize 超过该容量的缓冲区不放回池中，避免偶发的大请求长期占用内存
+const maxPooledBufferSize = 4 << 20
+
+// bufferPool 复用读取请求体、序列化上游请求和读取上游响应时使用的缓冲区
+var bufferPool = sync.Pool{
+	New: func() any { return new(bytes.Buffer) },
+}
+
+// getBuffer 从池中取出一个空缓冲区
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+// putBuffer 把缓冲区放回池中，调用后不能再使用缓冲区及其 Bytes() 返回的切片
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufferSize {
+		return
+	}
+	buf.Reset()
+	bufferPool.Put(buf)
+}
+
+// pooledBody 是上游请求体，传输层读取完毕并关闭后把缓冲区放回池中
+type pooledBody struct {
+	*bytes.Reader
+	buf  *bytes.Buffer
+	once sync.Once
+}
+
+func newPooledBody(buf *bytes.Buffer) *pooledBody {
+	return &pooledBody{Reader: bytes.NewReader(buf.Bytes()), buf: buf}
+}
+
+// Close 由 http.Transport 在请求体不再使用时调用
+func (pb *pooledBody) Close() error {
+	pb.once.Do(func() { putBuffer(pb.buf) })
+	return nil
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// silenceOutput 在基准测试期间丢弃 stdout 和日志输出
+func silenceOutput(b *testing.B) {
+	b.Helper()
+	stdout := os.Stdout
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	os.Stdout = devNull
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() {
+		os.Stdout = stdout
+		log.SetOutput(os.Stderr)
+		devNull.Close()
+	})
+}
+
+// largeRequestBody 构造带有较长历史的请求体，接近 Claude Code 的典型请求
+func largeRequestBody() string {
+	var messages []string
+	for i := 0; i < 100; i++ {
+		messages = append(messages, `{"role":"user","content":"`+strings.Repeat("question ", 200)+`"}`, `{"role":"assistant","content":"`+strings.Repeat("answer ", 200)+`"}`)
+	}
+	messages = append(messages, `{"role":"user","content":"final"}`)
+	return `{"model":"claude-sonnet-4-20250514","max_tokens":10,"messages":[` + strings.Join(messages, ",") + `]}`
+}
+
+// BenchmarkHandleMessagesBody 测量读取和解析大请求体的分配
+func BenchmarkHandleMessagesBody(b *testing.B) {
+	silenceOutput(b)
+	srv := newTestServer(b, Config{Backend: &fakeBackend{events: textEvents("ok")}})
+	body := largeRequestBody()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body)))
+		if rec.Code != http.StatusOK {
+			b.Fatalf("status = %d", rec.Code)
+		}
+	}
+}
+
+// BenchmarkCodeWhispererGenerate 测量序列化上游请求和读取上游响应的分配
+func BenchmarkCodeWhispererGenerate(b *testing.B) {
+	silenceOutput(b)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		io.WriteString(w, "data: {\"content\":\"hello\"}\n\n")
+	}))
+	defer upstream.Close()
+
+	srv := newTestServer(b, Config{})
+	backend := srv.backend.(*codeWhispererBackend)
+	backend.url = upstream.URL
+	req, reqErr := srv.parseAnthropicRequest([]byte(largeRequestBody()))
+	if reqErr != nil {
+		b.Fatal(reqErr)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := backend.Generate(context.Background(), req); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrUnknownCredential 由 ClientAuthProvider 返回，表示凭证不属于该认证方式，交给下一个认证方式处理
+var ErrUnknownCredential = errors.New("无法识别的凭证")
+
+// Identity 是客户端认证后的身份，用于记录用量和选择限流档位
+type Identity struct {
+	// Subject 标识调用方，例如 JWT 的 sub，出现在日志和 /admin/requests 中
+	Subject string
+	// Tier 是限流档位，对应 Config.RateLimits 的键，为空时使用 "*"
+	Tier string
+	// Provider 是认证方式的名称，例如 api-key、jwt
+	Provider string
+	// Tenant 是 API key 所属的租户，不属于任何租户时为空
+	Tenant string
+}
+
+// ClientAuthProvider 校验客户端请求携带的凭证 (x-api-key 或 Authorization: Bearer 的值)。
+// 凭证不属于自己时返回 ErrUnknownCredential，凭证属于自己但无效时返回其他错误
+type ClientAuthProvider interface {
+	Authenticate(ctx context.Context, credential string) (*Identity, error)
+}
+
+// staticKeyProvider 接受 Config.APIKeys 中的 key
+type staticKeyProvider struct {
+	keys []string
+}
+
+func (p staticKeyProvider) Authenticate(ctx context.Context, credential string) (*Identity, error) {
+	valid := false
+	for _, allowed := range p.keys {
+		if subtle.ConstantTimeCompare([]byte(credential), []byte(allowed)) == 1 {
+			valid = true
+		}
+	}
+	if !valid {
+		return nil, ErrUnknownCredential
+	}
+	// 日志和管理接口中只出现 key 的摘要
+	sum := sha256.Sum256([]byte(credential))
+	return &Identity{Subject: "key-" + hex.EncodeToString(sum[:6]), Provider: "api-key"}, nil
+}
+
+// clientAuthProviders 按顺序返回配置的认证方式：APIKeys、JWT、Config.ClientAuth
+func clientAuthProviders(config Config) []ClientAuthProvider {
+	var providers []ClientAuthProvider
+	if len(config.APIKeys) > 0 {
+		providers = append(providers, staticKeyProvider{keys: config.APIKeys})
+	}
+	if config.JWTSecret != "" || config.JWKSURL != "" {
+		providers = append(providers, newJWTProvider(config))
+	}
+	return append(providers, config.ClientAuth...)
+}
+
+// authenticate 依次交给各个认证方式校验凭证。没有配置任何认证方式时接受所有请求，返回的身份为 nil
+func (s *Server) authenticate(ctx context.Context, credential string) (*Identity, error) {
+	if len(s.clientAuth) == 0 {
+		return nil, nil
+	}
+	if credential == "" {
+		return nil, errors.New("缺少 API key 或 Bearer token")
+	}
+	for _, provider := range s.clientAuth {
+		identity, err := provider.Authenticate(ctx, credential)
+		if errors.Is(err, ErrUnknownCredential) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return identity, nil
+	}
+	return nil, errors.New("invalid x-api-key")
+}
+
+type identityKey struct{}
+
+// withIdentity 把认证后的身份放入 ctx
+func withIdentity(ctx context.Context, identity *Identity) context.Context {
+	if identity == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// identityFrom 返回 ctx 中的客户端身份，未认证时返回 nil
+func identityFrom(ctx context.Context) *Identity {
+	identity, _ := ctx.Value(identityKey{}).(*Identity)
+	return identity
+}
+
+// requireAPIKeyMiddleware 拒绝没有携带有效凭证或超出限流的请求，认证后的身份放入请求的 ctx
+func (s *Server) requireAPIKeyMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		identity, err := s.authenticate(r.Context(), requestAPIKey(r.Header))
+		if err != nil {
+			fmt.Printf("客户端认证失败: %v\n", err)
+			sendJSONError(w, http.StatusUnauthorized, "authentication_error", fmt.Sprintf("%v，请使用 kiro2cc export 输出的 ANTHROPIC_API_KEY 或有效的 token", err))
+			return
+		}
+		if retryAfter, err := s.checkRateLimits(identity); err != nil {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			sendJSONErrorWithCode(w, http.StatusTooManyRequests, CodeRateLimited, "rate_limit_error",
+				fmt.Sprintf("%v，请 %v 后重试", err, retryAfter.Round(time.Second)))
+			return
+		}
+		if identity != nil {
+			r = r.WithContext(withIdentity(r.Context(), identity))
+		}
+		next(w, r)
+	}
+}
+
+// grpcAuthenticate 对 gRPC 请求做与 requireAPIKeyMiddleware 相同的检查，返回带有身份的 ctx
+func (s *Server) grpcAuthenticate(ctx context.Context) (context.Context, error) {
+	identity, err := s.authenticate(ctx, grpcAPIKey(ctx))
+	if err != nil {
+		return ctx, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if _, err := s.checkRateLimits(identity); err != nil {
+		return ctx, status.Errorf(codes.ResourceExhausted, "[%s] %v", CodeRateLimited, err)
+	}
+	return withIdentity(ctx, identity), nil
+}
+
+// checkRateLimits 依次检查身份所在档位和所属租户的请求频率限制，超出时返回还需等待的时长
+func (s *Server) checkRateLimits(identity *Identity) (time.Duration, error) {
+	if retryAfter, ok := s.limiter.allow(identity); !ok {
+		return retryAfter, fmt.Errorf("%s 超出档位 %q 的请求频率限制", identity.Subject, identity.tier())
+	}
+	if retryAfter, ok := s.tenants.allow(identity); !ok {
+		return retryAfter, fmt.Errorf("租户 %s 超出每分钟请求数限制", identity.Tenant)
+	}
+	return 0, nil
+}
+
+// tier 返回限流档位，未设置时为 "*"
+func (id *Identity) tier() string {
+	if id.Tier == "" {
+		return "*"
+	}
+	return id.Tier
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signJWT 构造 JWT，sign 对 header.payload 计算签名
+func signJWT(t *testing.T, header, claims map[string]any, sign func([]byte) []byte) string {
+	t.Helper()
+	encode := func(v any) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := encode(header) + "." + encode(claims)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(signed)))
+}
+
+func hs256(secret string) func([]byte) []byte {
+	return func(data []byte) []byte {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(data)
+		return mac.Sum(nil)
+	}
+}
+
+// postWithKey 携带 x-api-key 请求 /v1/messages
+func postWithKey(srv *Server, key string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(hookTestBody))
+	if key != "" {
+		req.Header.Set("x-api-key", key)
+	}
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestJWTSharedSecret(t *testing.T) {
+	srv := newTestServer(t, Config{
+		Backend:     &fakeBackend{events: textEvents("ok")},
+		APIKeys:     []string{"sk-kiro2cc-local"},
+		JWTSecret:   "org-secret",
+		JWTIssuer:   "https://sso.example.com",
+		JWTAudience: "kiro2cc",
+	})
+	header := map[string]any{"alg": "HS256", "typ": "JWT"}
+	exp := time.Now().Add(time.Hour).Unix()
+	valid := map[string]any{"sub": "alice", "iss": "https://sso.example.com", "aud": []string{"other", "kiro2cc"}, "exp": exp}
+
+	for _, tc := range []struct {
+		name string
+		key  string
+		want int
+	}{
+		{"valid", signJWT(t, header, valid, hs256("org-secret")), http.StatusOK},
+		{"static key still accepted", "sk-kiro2cc-local", http.StatusOK},
+		{"wrong secret", signJWT(t, header, valid, hs256("guess")), http.StatusUnauthorized},
+		{"expired", signJWT(t, header, map[string]any{"sub": "alice", "iss": "https://sso.example.com", "aud": "kiro2cc", "exp": time.Now().Add(-time.Hour).Unix()}, hs256("org-secret")), http.StatusUnauthorized},
+		{"wrong audience", signJWT(t, header, map[string]any{"sub": "alice", "iss": "https://sso.example.com", "aud": "other", "exp": exp}, hs256("org-secret")), http.StatusUnauthorized},
+		{"wrong issuer", signJWT(t, header, map[string]any{"sub": "alice", "iss": "https://evil.example.com", "aud": "kiro2cc", "exp": exp}, hs256("org-secret")), http.StatusUnauthorized},
+		{"alg none", signJWT(t, map[string]any{"alg": "none"}, valid, func([]byte) []byte { return nil }), http.StatusUnauthorized},
+		{"missing", "", http.StatusUnauthorized},
+	} {
+		if rec := postWithKey(srv, tc.key); rec.Code != tc.want {
+			t.Errorf("%s: status %d, want %d: %s", tc.name, rec.Code, tc.want, rec.Body.String())
+		}
+	}
+}
+
+func TestJWTFromJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b64 := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	fetches := 0
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]any{
+			{"kty": "RSA", "kid": "rsa-1", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kty": "EC", "kid": "ec-1", "crv": "P-256", "x": b64(ecKey.X.FillBytes(make([]byte, 32))), "y": b64(ecKey.Y.FillBytes(make([]byte, 32)))},
+		}})
+	}))
+	defer jwks.Close()
+
+	p := newJWTProvider(Config{JWKSURL: jwks.URL, JWTTierClaim: "plan"})
+	claims := map[string]any{"sub": "bob", "plan": "pro", "exp": time.Now().Add(time.Hour).Unix()}
+	digest := func(data []byte) []byte {
+		sum := sha256.Sum256(data)
+		return sum[:]
+	}
+
+	rs := signJWT(t, map[string]any{"alg": "RS256", "kid": "rsa-1"}, claims, func(data []byte) []byte {
+		sig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sig
+	})
+	identity, err := p.Authenticate(context.Background(), rs)
+	if err != nil || identity.Subject != "bob" || identity.Tier != "pro" || identity.Provider != "jwt" {
+		t.Fatalf("RS256: %+v, %v", identity, err)
+	}
+
+	es := signJWT(t, map[string]any{"alg": "ES256", "kid": "ec-1"}, claims, func(data []byte) []byte {
+		r, s, err := ecdsa.Sign(rand.Reader, ecKey, digest(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	})
+	if _, err := p.Authenticate(context.Background(), es); err != nil {
+		t.Fatalf("ES256: %v", err)
+	}
+
+	// 用 EC 密钥的 kid 声称 RS256 时拒绝
+	mixed := signJWT(t, map[string]any{"alg": "RS256", "kid": "ec-1"}, claims, func([]byte) []byte { return []byte("x") })
+	if _, err := p.Authenticate(context.Background(), mixed); err == nil {
+		t.Error("accepted RS256 token with EC key")
+	}
+	// 未知 kid 在最短间隔内不会重新下载
+	unknown := signJWT(t, map[string]any{"alg": "RS256", "kid": "rsa-2"}, claims, func([]byte) []byte { return []byte("x") })
+	if _, err := p.Authenticate(context.Background(), unknown); err == nil {
+		t.Error("accepted unknown kid")
+	}
+	if fetches != 1 {
+		t.Errorf("JWKS fetched %d times, want 1", fetches)
+	}
+
+	if _, err := p.Authenticate(context.Background(), "sk-kiro2cc-local"); !errors.Is(err, ErrUnknownCredential) {
+		t.Errorf("non-JWT credential: %v", err)
+	}
+}
+
+// tierProvider 把凭证本身当作身份，用于测试自定义认证方式和限流
+type tierProvider struct{}
+
+func (tierProvider) Authenticate(ctx context.Context, credential string) (*Identity, error) {
+	subject, tier, ok := strings.Cut(credential, ":")
+	if !ok {
+		return nil, ErrUnknownCredential
+	}
+	return &Identity{Subject: subject, Tier: tier, Provider: "test"}, nil
+}
+
+func TestRateLimitsByTier(t *testing.T) {
+	srv := newTestServer(t, Config{
+		Backend:    &fakeBackend{events: textEvents("ok")},
+		ClientAuth: []ClientAuthProvider{tierProvider{}},
+		RateLimits: map[string]int{"free": 2, "*": 5},
+	})
+
+	for i := 0; i < 2; i++ {
+		if rec := postWithKey(srv, "alice:free"); rec.Code != http.StatusOK {
+			t.Fatalf("request %d: %d %s", i, rec.Code, rec.Body.String())
+		}
+	}
+	rec := postWithKey(srv, "alice:free")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" || !strings.Contains(rec.Body.String(), string(CodeRateLimited)) {
+		t.Fatalf("over limit: %d %q %s", rec.Code, rec.Header().Get("Retry-After"), rec.Body.String())
+	}
+	// 其他身份单独计数，未列出的档位使用 "*"
+	if rec := postWithKey(srv, "bob:free"); rec.Code != http.StatusOK {
+		t.Errorf("other subject: %d", rec.Code)
+	}
+	for i := 0; i < 5; i++ {
+		postWithKey(srv, "carol:team")
+	}
+	if rec := postWithKey(srv, "carol:team"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("default tier: %d", rec.Code)
+	}
+	if rec := postWithKey(srv, "no-tier"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("unknown credential: %d", rec.Code)
+	}
+}
+
+func TestRateLimiterRefill(t *testing.T) {
+	now := time.Now()
+	rl := newRateLimiter(map[string]int{"*": 60})
+	rl.now = func() time.Time { return now }
+	identity := &Identity{Subject: "alice"}
+	for i := 0; i < 60; i++ {
+		if _, ok := rl.allow(identity); !ok {
+			t.Fatalf("request %d rejected", i)
+		}
+	}
+	if wait, ok := rl.allow(identity); ok || wait <= 0 || wait > time.Second {
+		t.Fatalf("over limit: %v %v", wait, ok)
+	}
+	now = now.Add(time.Second)
+	if _, ok := rl.allow(identity); !ok {
+		t.Error("token not refilled after 1s")
+	}
+}
+
+func TestRateLimitsRequireAuth(t *testing.T) {
+	err := Config{RateLimits: map[string]int{"*": 10}}.Validate()
+	if err == nil || !strings.Contains(err.Error(), "RateLimits") {
+		t.Errorf("Validate = %v", err)
+	}
+}
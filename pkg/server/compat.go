@@ -0,0 +1,109 @@
+package server
+
+import (
+	"strings"
+)
+
+// DefaultCompatMaxTokens 是兼容模式下客户端未提供 max_tokens 时使用的默认值
+const DefaultCompatMaxTokens = 4096
+
+// applyCompatQuirks 修正 LangChain、LiteLLM 等框架常见的请求格式差异：
+//   - 缺少 max_tokens 时使用 defaultMaxTokens
+//   - system 为字符串时转换为内容块数组
+//   - 工具定义使用 OpenAI 的 {"type":"function","function":{...}} 或 "parameters" 字段
+//   - messages 中出现 system、function、tool 角色的 OpenAI 风格消息
+func applyCompatQuirks(req map[string]any, defaultMaxTokens int) {
+	if maxTokens, ok := req["max_tokens"].(float64); !ok || maxTokens <= 0 {
+		req["max_tokens"] = defaultMaxTokens
+	}
+
+	system := compatSystemBlocks(req["system"])
+
+	if tools, ok := req["tools"].([]any); ok {
+		for i, tool := range tools {
+			if toolMap, ok := tool.(map[string]any); ok {
+				tools[i] = compatTool(toolMap)
+			}
+		}
+	}
+
+	if messages, ok := req["messages"].([]any); ok {
+		converted := make([]any, 0, len(messages))
+		for _, msg := range messages {
+			msgMap, ok := msg.(map[string]any)
+			if !ok {
+				converted = append(converted, msg)
+				continue
+			}
+
+			switch msgMap["role"] {
+			case "system":
+				system = append(system, map[string]any{"type": "text", "text": compatText(msgMap["content"])})
+			case "function", "tool":
+				// OpenAI 的函数结果消息转换为携带 tool_result 的用户消息
+				toolUseId, _ := msgMap["tool_call_id"].(string)
+				if toolUseId == "" {
+					toolUseId, _ = msgMap["name"].(string)
+				}
+				converted = append(converted, map[string]any{
+					"role": "user",
+					"content": []any{map[string]any{
+						"type":        "tool_result",
+						"tool_use_id": toolUseId,
+						"content":     compatText(msgMap["content"]),
+					}},
+				})
+			default:
+				converted = append(converted, msgMap)
+			}
+		}
+		req["messages"] = converted
+	}
+
+	if len(system) > 0 {
+		req["system"] = system
+	}
+}
+
+// compatSystemBlocks 把字符串形式的 system 转换为内容块数组
+func compatSystemBlocks(system any) []any {
+	switch v := system.(type) {
+	case string:
+		if strings.TrimSpace(v) == "" {
+			return nil
+		}
+		return []any{map[string]any{"type": "text", "text": v}}
+	case []any:
+		return v
+	default:
+		return nil
+	}
+}
+
+// compatTool 把 OpenAI 风格的工具定义转换为 Anthropic 格式
+func compatTool(tool map[string]any) map[string]any {
+	if fn, ok := tool["function"].(map[string]any); ok && tool["type"] == "function" {
+		tool = fn
+	}
+	if _, ok := tool["input_schema"]; !ok {
+		if params, ok := tool["parameters"]; ok {
+			tool["input_schema"] = params
+			delete(tool, "parameters")
+		} else {
+			tool["input_schema"] = map[string]any{"type": "object", "properties": map[string]any{}}
+		}
+	}
+	return tool
+}
+
+// compatText 把 OpenAI 消息的 content 转换为纯文本
+func compatText(content any) string {
+	switch v := content.(type) {
+	case string:
+		return v
+	case nil:
+		return ""
+	default:
+		return getMessageContent(v)
+	}
+}
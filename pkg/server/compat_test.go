@@ -0,0 +1,49 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestCompatModeQuirks(t *testing.T) {
+	body := []byte(`{
+		"model": "claude-sonnet-4-20250514",
+		"system": "You are helpful",
+		"tools": [
+			{"type": "function", "function": {"name": "get_weather", "description": "Get weather", "parameters": {"type": "object"}}},
+			{"name": "search", "description": "Search", "parameters": {"type": "object"}}
+		],
+		"messages": [
+			{"role": "system", "content": "Answer in English"},
+			{"role": "user", "content": "Weather in Paris?"},
+			{"role": "assistant", "content": "Let me check"},
+			{"role": "function", "name": "get_weather", "content": "sunny"}
+		]
+	}`)
+
+	strict := newTestServer(t, Config{})
+	if _, reqErr := strict.parseAnthropicRequest(body); reqErr == nil {
+		t.Fatal("expected strict mode to reject the request")
+	}
+
+	srv := newTestServer(t, Config{CompatMode: true, CompatMaxTokens: 2048})
+	req, reqErr := srv.parseAnthropicRequest(body)
+	if reqErr != nil {
+		t.Fatalf("parseAnthropicRequest: %v", reqErr)
+	}
+
+	if req.MaxTokens != 2048 {
+		t.Errorf("MaxTokens = %d", req.MaxTokens)
+	}
+	if len(req.System) != 2 || req.System[0].Text != "You are helpful" || req.System[1].Text != "Answer in English" {
+		t.Errorf("System = %+v", req.System)
+	}
+	if len(req.Tools) != 2 || req.Tools[0].Name != "get_weather" || req.Tools[0].InputSchema["type"] != "object" || req.Tools[1].InputSchema == nil {
+		t.Errorf("Tools = %+v", req.Tools)
+	}
+	if len(req.Messages) != 3 || req.Messages[2].Role != "user" {
+		t.Fatalf("Messages = %+v", req.Messages)
+	}
+	if got := getMessageContent(req.Messages[2].Content); got != "sunny" {
+		t.Errorf("tool result content = %q", got)
+	}
+}
@@ -0,0 +1,343 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bestk/kiro2cc/pkg/redis"
+)
+
+// profileArnPattern 匹配 CodeWhisperer profile ARN，例如
+// arn:aws:codewhisperer:us-east-1:123456789012:profile/ABCDEF
+var profileArnPattern = regexp.MustCompile(`^arn:aws[a-z-]*:codewhisperer:[a-z0-9-]+:\d{12}:profile/[A-Za-z0-9]+$`)
+
+// originPattern 匹配 CodeWhisperer 的 Origin 枚举值，例如 AI_EDITOR、CHAT
+var originPattern = regexp.MustCompile(`^[A-Z][A-Z_]*$`)
+
+// ConfigError 汇总启动时发现的所有配置问题，每一项都以字段名开头
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return "配置校验失败:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Validate 一次性检查整个配置并返回所有问题，而不是等到第一个请求才失败。
+// 只检查已填写的字段，默认值由 New 补全
+func (c Config) Validate() error {
+	var problems []string
+	add := func(field, format string, args ...any) {
+		problems = append(problems, field+": "+fmt.Sprintf(format, args...))
+	}
+
+	if err := validateListenAddr(c.Addr); err != nil {
+		add("Addr", "%v", err)
+	}
+	if c.GRPCAddr != "" {
+		if err := validateListenAddr(c.GRPCAddr); err != nil {
+			add("GRPCAddr", "%v", err)
+		}
+	}
+
+	if err := validateSurfaces(c.Surfaces); err != nil {
+		add("Surfaces", "%v", err)
+	}
+	used := map[string]bool{c.Addr: true, c.GRPCAddr: c.GRPCAddr != ""}
+	for i, l := range c.Listeners {
+		field := fmt.Sprintf("Listeners[%d]", i)
+		if l.Addr == "" {
+			add(field, "缺少监听地址")
+		} else if err := validateListenAddr(l.Addr); err != nil {
+			add(field, "%v", err)
+		} else if used[l.Addr] {
+			add(field, "监听地址 %s 重复", l.Addr)
+		}
+		used[l.Addr] = true
+		if len(l.Surfaces) == 0 {
+			add(field, "至少需要开启一种接口类别")
+		} else if err := validateSurfaces(l.Surfaces); err != nil {
+			add(field, "%v", err)
+		}
+	}
+
+	if strings.ContainsAny(c.BasePath, " \t?#{}") {
+		add("BasePath", "%q 不是有效的路径前缀", c.BasePath)
+	} else if NormalizeBasePath(c.BasePath) != "" && c.AdminToken == "" && c.adminSurfaceEnabled() {
+		// 挂载前缀意味着部署在反向代理之后，代理转发的请求来自本机，只按来源地址无法保护管理接口
+		add("AdminToken", "设置 BasePath 时必须设置 AdminToken，或者不在任何监听地址上开启 %s 接口", SurfaceAdmin)
+	}
+
+	switch c.EmptyContent {
+	case "", EmptyContentStrict, EmptyContentDrop, EmptyContentSubstitute:
+	default:
+		add("EmptyContent", "未知的空内容处理方式 %q (可选: strict, drop, substitute)", c.EmptyContent)
+	}
+	switch c.ContextOverflow {
+	case "", ContextOverflowReject, ContextOverflowTrim:
+	default:
+		add("ContextOverflow", "未知的超出上下文窗口处理方式 %q (可选: reject, trim)", c.ContextOverflow)
+	}
+
+	if c.ProfileArn != "" && !profileArnPattern.MatchString(c.ProfileArn) {
+		add("ProfileArn", "%q 不是有效的 CodeWhisperer profile ARN (arn:aws:codewhisperer:<region>:<account>:profile/<id>)", c.ProfileArn)
+	}
+
+	if c.Origin != "" && !originPattern.MatchString(c.Origin) {
+		add("Origin", "%q 不是有效的来源 (例如 AI_EDITOR, CHAT)", c.Origin)
+	}
+	if c.ToolFreeOrigin != "" && !originPattern.MatchString(c.ToolFreeOrigin) {
+		add("ToolFreeOrigin", "%q 不是有效的来源 (例如 AI_EDITOR, CHAT)", c.ToolFreeOrigin)
+	}
+	for _, trigger := range []struct{ field, value string }{
+		{"ChatTriggerType", c.ChatTriggerType},
+		{"ToolResultTriggerType", c.ToolResultTriggerType},
+	} {
+		switch trigger.value {
+		case "", ChatTriggerManual, ChatTriggerDiagnostic, ChatTriggerInlineChat:
+		default:
+			add(trigger.field, "未知的触发类型 %q (可选: MANUAL, DIAGNOSTIC, INLINE_CHAT)", trigger.value)
+		}
+	}
+	if c.ToolFreeMaxTokens < 0 {
+		add("ToolFreeMaxTokens", "不能为负数")
+	}
+
+	switch {
+	case (c.TLSCertFile == "") != (c.TLSKeyFile == ""):
+		add("TLSCertFile", "TLSCertFile 和 TLSKeyFile 必须同时设置")
+	case c.TLSCertFile != "":
+		certErr := checkReadable(c.TLSCertFile)
+		keyErr := checkReadable(c.TLSKeyFile)
+		if certErr != nil {
+			add("TLSCertFile", "%v", certErr)
+		}
+		if keyErr != nil {
+			add("TLSKeyFile", "%v", keyErr)
+		}
+		if certErr == nil && keyErr == nil {
+			if _, err := tls.LoadX509KeyPair(c.TLSCertFile, c.TLSKeyFile); err != nil {
+				add("TLSCertFile", "证书与私钥无法加载: %v", err)
+			}
+		}
+	}
+
+	if c.StateDir != "" {
+		if info, err := os.Stat(c.StateDir); err == nil && !info.IsDir() {
+			add("StateDir", "%s 不是目录", c.StateDir)
+		}
+	}
+	if c.RedisURL != "" {
+		if c.SessionFile != "" {
+			add("RedisURL", "RedisURL 与 SessionFile 只能设置一个")
+		}
+		if _, err := redis.ParseURL(c.RedisURL); err != nil {
+			add("RedisURL", "%v", err)
+		}
+	}
+
+	switch c.TokenLock {
+	case "", TokenLockProcess, TokenLockFile:
+	case TokenLockRedis:
+		if c.RedisURL == "" {
+			add("TokenLock", "使用 redis 协调 token 刷新需要设置 RedisURL")
+		}
+	default:
+		add("TokenLock", "未知的 token 刷新协调方式 %q (可选: process, file, redis)", c.TokenLock)
+	}
+
+	switch c.Auth {
+	case "", AuthBearer, AuthSigV4:
+	default:
+		add("Auth", "未知的认证方式 %q (可选: bearer, sigv4)", c.Auth)
+	}
+	if c.AWSProfile != "" && c.Auth != AuthSigV4 {
+		add("AWSProfile", "只在 sigv4 认证方式下使用")
+	}
+
+	if c.RawCaptureRetention < 0 {
+		add("RawCaptureRetention", "不能为负数")
+	}
+
+	if c.CaptureUpstream && c.EventHistory < 0 {
+		add("CaptureUpstream", "关闭 EventHistory 时无法保留上游响应")
+	}
+
+	if anthropicRequestFields[c.ExtraBodyField] {
+		add("ExtraBodyField", "%q 是 Anthropic 请求已有的字段", c.ExtraBodyField)
+	}
+
+	if c.JWKSURL != "" {
+		if u, err := url.Parse(c.JWKSURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			add("JWKSURL", "%q 不是有效的 http(s) 地址", c.JWKSURL)
+		}
+	}
+	if (c.JWTIssuer != "" || c.JWTAudience != "") && c.JWTSecret == "" && c.JWKSURL == "" {
+		add("JWTIssuer", "需要同时设置 JWTSecret 或 JWKSURL")
+	}
+	tiers := make([]string, 0, len(c.RateLimits))
+	for tier := range c.RateLimits {
+		tiers = append(tiers, tier)
+	}
+	sort.Strings(tiers)
+	for _, tier := range tiers {
+		if c.RateLimits[tier] < 0 {
+			add("RateLimits["+tier+"]", "不能为负数")
+		}
+	}
+	if len(c.RateLimits) > 0 && len(c.APIKeys) == 0 && c.JWTSecret == "" && c.JWKSURL == "" && len(c.ClientAuth) == 0 &&
+		len(c.Tenants) == 0 && c.TenantsFile == "" {
+		add("RateLimits", "限流按客户端身份计数，需要配置 APIKeys、JWT、ClientAuth 或租户")
+	}
+	if len(c.Tenants) > 0 && c.TenantsFile != "" {
+		add("Tenants", "不能与 TenantsFile 同时设置")
+	}
+	tenants := c.Tenants
+	if c.TenantsFile != "" {
+		loaded, err := loadTenants(c.TenantsFile)
+		if err != nil {
+			add("TenantsFile", "%v", err)
+		}
+		tenants = loaded
+	}
+	c.validateTenants(tenants, add)
+
+	for i, w := range c.QuietHours {
+		if w.Start < 0 || w.Start >= 24*time.Hour || w.End <= 0 || w.End > 24*time.Hour || w.Start == w.End {
+			add(fmt.Sprintf("QuietHours[%d]", i), "开始时间应在 [0, 24h)，结束时间应在 (0, 24h]，且两者不能相同")
+		}
+	}
+
+	switch c.HookFailure {
+	case "", HookFailureReject, HookFailureIgnore:
+	default:
+		add("HookFailure", "未知的钩子失败处理方式 %q (可选: reject, ignore)", c.HookFailure)
+	}
+	if c.HookTimeout < 0 {
+		add("HookTimeout", "不能为负数")
+	}
+	validateHook := func(field, command string) {
+		if command == "" {
+			return
+		}
+		args := strings.Fields(command)
+		if len(args) == 0 {
+			add(field, "命令不能为空")
+			return
+		}
+		if _, err := exec.LookPath(args[0]); err != nil {
+			add(field, "找不到钩子程序: %v", err)
+		}
+	}
+	validateHook("RequestHook", c.RequestHook)
+	validateHook("ResponseHook", c.ResponseHook)
+	for key, language := range c.ResponseLanguageKeys {
+		// 错误信息中不包含 API key 本身
+		if strings.TrimSpace(key) == "" || strings.TrimSpace(language) == "" {
+			add("ResponseLanguageKeys", "API key 和语言都不能为空")
+			break
+		}
+	}
+	for key, access := range c.KeyModels {
+		if strings.TrimSpace(key) == "" {
+			add("KeyModels", "API key 不能为空")
+			break
+		}
+		for _, models := range [][]string{access.Allow, access.Deny} {
+			for _, model := range models {
+				if strings.TrimSpace(model) == "" {
+					add("KeyModels", "模型不能为空")
+					break
+				}
+			}
+		}
+	}
+
+	maxTokensModels := make([]string, 0, len(c.DefaultMaxTokens))
+	for model := range c.DefaultMaxTokens {
+		maxTokensModels = append(maxTokensModels, model)
+	}
+	sort.Strings(maxTokensModels)
+	for _, model := range maxTokensModels {
+		if c.DefaultMaxTokens[model] <= 0 {
+			add("DefaultMaxTokens["+model+"]", "必须是正整数")
+		}
+	}
+	if c.CompatMaxTokens < 0 {
+		add("CompatMaxTokens", "不能为负数")
+	}
+	if c.TranscriptLimit < 0 {
+		add("TranscriptLimit", "不能为负数")
+	}
+	if c.SSEBufferEvents < 0 {
+		add("SSEBufferEvents", "不能为负数")
+	}
+	if c.MaxContinuations < 0 {
+		add("MaxContinuations", "不能为负数")
+	}
+	if c.AgentMaxSteps < 0 {
+		add("AgentMaxSteps", "不能为负数")
+	}
+	for _, command := range c.AgentCommands {
+		if command == "" || strings.ContainsAny(command, " \t") {
+			add("AgentCommands", "%q 不是有效的程序名", command)
+		}
+	}
+	if c.DedupWindow < 0 {
+		add("DedupWindow", "不能为负数")
+	}
+
+	models := make([]string, 0, len(ModelMap))
+	for model := range ModelMap {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+	for _, model := range models {
+		if strings.TrimSpace(ModelMap[model]) == "" {
+			add("ModelMap["+model+"]", "未配置 CodeWhisperer 模型 ID")
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ConfigError{Problems: problems}
+	}
+	return nil
+}
+
+// validateListenAddr 检查 host:port 形式的监听地址
+func validateListenAddr(addr string) error {
+	if addr == "" {
+		return nil
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("无法解析监听地址 %q: %v", addr, err)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("监听地址 %q 的端口无效", addr)
+	}
+	if host != "" && net.ParseIP(host) == nil {
+		if _, err := net.LookupHost(host); err != nil {
+			return fmt.Errorf("无法解析监听地址 %q 的主机名: %v", addr, err)
+		}
+	}
+	return nil
+}
+
+// checkReadable 检查文件存在且可读
+func checkReadable(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
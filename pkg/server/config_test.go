@@ -0,0 +1,60 @@
+package server
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateReportsAllProblems(t *testing.T) {
+	err := Config{
+		Addr:         "localhost:99999",
+		GRPCAddr:     "no-port",
+		ProfileArn:   "not-an-arn",
+		EmptyContent: "bogus",
+		TLSCertFile:  filepath.Join(t.TempDir(), "missing.pem"),
+		TLSKeyFile:   filepath.Join(t.TempDir(), "missing.key"),
+	}.Validate()
+
+	var configErr *ConfigError
+	if !errors.As(err, &configErr) {
+		t.Fatalf("expected *ConfigError, got %v", err)
+	}
+	for _, field := range []string{"Addr:", "GRPCAddr:", "ProfileArn:", "EmptyContent:", "TLSCertFile:", "TLSKeyFile:"} {
+		found := false
+		for _, problem := range configErr.Problems {
+			if strings.HasPrefix(problem, field) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("missing problem for %s in %v", field, configErr.Problems)
+		}
+	}
+
+	if _, err := New(Config{TokenPath: "unused", Addr: "bad addr"}); !errors.As(err, &configErr) {
+		t.Fatalf("New should fail fast on invalid config, got %v", err)
+	}
+}
+
+func TestValidateAcceptsDefaults(t *testing.T) {
+	if err := (Config{Addr: ":8080", ProfileArn: DefaultProfileArn}).Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+}
+
+func TestValidateBasePathRequiresAdminToken(t *testing.T) {
+	if err := (Config{BasePath: "/kiro"}).Validate(); err == nil || !strings.Contains(err.Error(), "AdminToken:") {
+		t.Errorf("BasePath without AdminToken: %v", err)
+	}
+	for _, config := range []Config{
+		{BasePath: "/kiro", AdminToken: "s3cret"},
+		{BasePath: "/kiro", Surfaces: []string{SurfaceAnthropic}},
+		{BasePath: "/"},
+	} {
+		if err := config.Validate(); err != nil {
+			t.Errorf("Validate(%+v): %v", config, err)
+		}
+	}
+}
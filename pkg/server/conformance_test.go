@@ -0,0 +1,260 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bestk/kiro2cc/parser"
+)
+
+// sseFrame 表示一个解析后的 SSE 事件
+type sseFrame struct {
+	Event string
+	Data  map[string]any
+}
+
+// parseSSE 解析 SSE 文本，忽略无法解析的 data
+func parseSSE(t *testing.T, body string) []sseFrame {
+	t.Helper()
+	var frames []sseFrame
+	for _, chunk := range strings.Split(body, "\n\n") {
+		var frame sseFrame
+		for _, line := range strings.Split(chunk, "\n") {
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				frame.Event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &frame.Data); err != nil {
+					t.Fatalf("invalid data line %q: %v", line, err)
+				}
+			}
+		}
+		if frame.Event != "" {
+			frames = append(frames, frame)
+		}
+	}
+	return frames
+}
+
+// streamShape 返回事件序列（连续的 content_block_delta 合并为一个）以及每种事件的字段路径
+func streamShape(frames []sseFrame) ([]string, map[string][]string) {
+	var sequence []string
+	paths := map[string]map[string]bool{}
+	for _, frame := range frames {
+		if n := len(sequence); n == 0 || sequence[n-1] != frame.Event || frame.Event != "content_block_delta" {
+			sequence = append(sequence, frame.Event)
+		}
+		if paths[frame.Event] == nil {
+			paths[frame.Event] = map[string]bool{}
+		}
+		collectPaths("", frame.Data, paths[frame.Event])
+	}
+
+	shape := map[string][]string{}
+	for event, set := range paths {
+		for path := range set {
+			shape[event] = append(shape[event], path)
+		}
+		sort.Strings(shape[event])
+	}
+	return sequence, shape
+}
+
+// collectPaths 收集 JSON 对象中所有字段的路径
+func collectPaths(prefix string, value any, paths map[string]bool) {
+	obj, ok := value.(map[string]any)
+	if !ok {
+		return
+	}
+	for key, child := range obj {
+		path := prefix + "." + key
+		paths[path] = true
+		collectPaths(path, child, paths)
+	}
+}
+
+// checkStreamGrammar 验证事件顺序符合 Anthropic 的流式语法：
+// message_start → [content_block_start → content_block_delta* → content_block_stop]* → message_delta → message_stop。
+// ping 可以出现在任意位置 (等待上游时会在 message_start 之前发送)，error 只能是最后一个事件
+func checkStreamGrammar(frames []sseFrame) error {
+	const (
+		start = iota
+		betweenBlocks
+		inBlock
+		afterDelta
+		done
+	)
+	state, block := start, -1.0
+	for i, frame := range frames {
+		index, _ := frame.Data["index"].(float64)
+		if frame.Event == "error" {
+			if i != len(frames)-1 {
+				return fmt.Errorf("event %d: error must be the last event", i)
+			}
+			return nil
+		}
+		if state == done {
+			return fmt.Errorf("event %d: %s after message_stop", i, frame.Event)
+		}
+		if frame.Event == "ping" {
+			continue
+		}
+
+		switch {
+		case state == start && frame.Event == "message_start":
+			state = betweenBlocks
+		case state == betweenBlocks && frame.Event == "content_block_start":
+			if index <= block {
+				return fmt.Errorf("event %d: content block index %v does not increase", i, index)
+			}
+			state, block = inBlock, index
+		case state == inBlock && (frame.Event == "content_block_delta" || frame.Event == "content_block_stop"):
+			if index != block {
+				return fmt.Errorf("event %d: %s for block %v while block %v is open", i, frame.Event, index, block)
+			}
+			if frame.Event == "content_block_stop" {
+				state = betweenBlocks
+			}
+		case state == betweenBlocks && frame.Event == "message_delta":
+			state = afterDelta
+		case state == afterDelta && frame.Event == "message_stop":
+			state = done
+		default:
+			return fmt.Errorf("event %d: unexpected %s", i, frame.Event)
+		}
+	}
+	if state != done {
+		return fmt.Errorf("stream ended without message_stop")
+	}
+	return nil
+}
+
+// assertConformantStream 解析 SSE 响应并检查事件顺序
+func assertConformantStream(t *testing.T, body string) []sseFrame {
+	t.Helper()
+	return assertConformantFrames(t, parseSSE(t, body))
+}
+
+// assertConformantFrames 检查事件顺序，用于 NDJSON、WebSocket 等按 type 字段区分事件的输出
+func assertConformantFrames(t *testing.T, frames []sseFrame) []sseFrame {
+	t.Helper()
+	if err := checkStreamGrammar(frames); err != nil {
+		var events []string
+		for _, frame := range frames {
+			events = append(events, frame.Event)
+		}
+		t.Errorf("non-conformant stream: %v\nevents: %v", err, events)
+	}
+	return frames
+}
+
+func TestStreamGrammar(t *testing.T) {
+	delay := streamEventDelay
+	streamEventDelay = func() time.Duration { return 0 }
+	defer func() { streamEventDelay = delay }()
+
+	toolEvents := parser.ParseEvents([]byte(`data: {"content":"Let me check."}
+data: {"name":"ls","toolUseId":"t1"}
+data: {"name":"ls","toolUseId":"t1","input":"{\"path\":\".\"}"}
+data: {"name":"ls","toolUseId":"t1","stop":true}
+`))
+	usage := parser.SSEEvent{Event: "message_delta", Data: parser.MessageDelta{Type: "message_delta", Usage: parser.Usage{OutputTokens: 7}}}
+
+	scenarios := []struct {
+		name       string
+		backend    Backend
+		messages   string
+		stopReason string
+	}{
+		{"text", &fakeBackend{events: textEvents("Hello ", "world")}, `[{"role":"user","content":"hi"}]`, "end_turn"},
+		{"prefill", &fakeBackend{events: textEvents("red\"]}")}, `[{"role":"user","content":"colors"},{"role":"assistant","content":"{\"c\": [\""}]`, "end_turn"},
+		{"upstream usage", &fakeBackend{events: append(textEvents("Hi"), usage)}, `[{"role":"user","content":"hi"}]`, "end_turn"},
+		{"tool use", &fakeBackend{events: toolEvents}, `[{"role":"user","content":"list files"}]`, "tool_use"},
+		{"upstream error", &fakeBackend{err: &UpstreamError{StatusCode: http.StatusTooManyRequests, Body: "throttled"}}, `[{"role":"user","content":"hi"}]`, ""},
+	}
+	for _, sc := range scenarios {
+		srv := newTestServer(t, Config{Backend: sc.backend})
+		rec := httptest.NewRecorder()
+		body := `{"model":"claude-sonnet-4-20250514","max_tokens":10,"stream":true,"messages":` + sc.messages + `}`
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body)))
+
+		t.Run(sc.name, func(t *testing.T) {
+			frames := assertConformantStream(t, rec.Body.String())
+			for _, frame := range frames {
+				if frame.Event != "message_delta" {
+					continue
+				}
+				if got := frame.Data["delta"].(map[string]any)["stop_reason"]; got != sc.stopReason {
+					t.Errorf("stop_reason = %v, want %s", got, sc.stopReason)
+				}
+			}
+		})
+	}
+}
+
+func TestStreamMatchesAnthropicShape(t *testing.T) {
+	delay := streamEventDelay
+	streamEventDelay = func() time.Duration { return 0 }
+	defer func() { streamEventDelay = delay }()
+
+	recorded, err := os.ReadFile("testdata/anthropic_stream.sse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantSequence, wantShape := streamShape(parseSSE(t, string(recorded)))
+
+	srv := newTestServer(t, Config{Backend: &fakeBackend{events: textEvents("Hello", "!")}})
+	rec := httptest.NewRecorder()
+	body := `{"model":"claude-sonnet-4-20250514","max_tokens":10,"stream":true,"messages":[{"role":"user","content":"hi"}]}`
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body)))
+	gotSequence, gotShape := streamShape(assertConformantStream(t, rec.Body.String()))
+
+	if !reflect.DeepEqual(gotSequence, wantSequence) {
+		t.Errorf("event sequence:\n got %v\nwant %v", gotSequence, wantSequence)
+	}
+	for event, want := range wantShape {
+		if got := gotShape[event]; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s fields:\n got %v\nwant %v", event, got, want)
+		}
+	}
+}
+
+func TestPingWhileWaitingForUpstream(t *testing.T) {
+	delay, interval := streamEventDelay, pingInterval
+	streamEventDelay = func() time.Duration { return 0 }
+	pingInterval = 10 * time.Millisecond
+	defer func() { streamEventDelay, pingInterval = delay, interval }()
+
+	backend := &delayedBackend{fakeBackend: fakeBackend{events: textEvents("a", "b", "c")}, delay: 55 * time.Millisecond}
+	srv := newTestServer(t, Config{Backend: backend})
+	rec := httptest.NewRecorder()
+	body := `{"model":"claude-sonnet-4-20250514","max_tokens":10,"stream":true,"messages":[{"role":"user","content":"hi"}]}`
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body)))
+
+	frames := assertConformantStream(t, rec.Body.String())
+	waiting := 0
+	for _, frame := range frames {
+		if frame.Event != "ping" {
+			break
+		}
+		if !reflect.DeepEqual(frame.Data, map[string]any{"type": "ping"}) {
+			t.Errorf("ping data = %v", frame.Data)
+		}
+		waiting++
+	}
+	// 等待上游的 55ms 内每 10ms 一次，另有 content_block_start 之后的一次
+	if waiting < 3 {
+		t.Errorf("got %d pings before message_start, want at least 3", waiting)
+	}
+	if n := strings.Count(rec.Body.String(), "event: ping"); n != waiting+1 {
+		t.Errorf("got %d pings, want %d", n, waiting+1)
+	}
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+)
+
+// 请求超过模型上下文窗口时的处理方式
+const (
+	// ContextOverflowReject 直接返回 invalid_request_error
+	ContextOverflowReject = "reject"
+	// ContextOverflowTrim 从最早的消息开始丢弃历史，直到估算的输入不超过上下文窗口
+	ContextOverflowTrim = "trim"
+)
+
+// fitContextWindow 在请求估算的输入超过模型的上下文窗口时按 Config.ContextOverflow 裁剪历史或返回错误，
+// 不必等上游拒绝。错误消息与 Anthropic API 相同，以 "prompt is too long" 开头，Claude Code 等客户端据此压缩对话
+func (s *Server) fitContextWindow(req *AnthropicRequest) *requestError {
+	info, ok := ModelInfos[req.Model]
+	if !ok || info.ContextWindow <= 0 {
+		return nil
+	}
+	tokens := estimateInputTokens(*req)
+	if tokens <= info.ContextWindow {
+		return nil
+	}
+	if s.config.ContextOverflow == ContextOverflowTrim {
+		if dropped, trimmed := trimToContextWindow(*req, info.ContextWindow); dropped > 0 {
+			fmt.Printf("警告: 请求估算约 %d tokens，超过 %s 的上下文窗口 %d，已丢弃最早的 %d 条消息\n", tokens, req.Model, info.ContextWindow, dropped)
+			req.Messages = trimmed
+			tokens = estimateInputTokens(*req)
+		}
+		if tokens <= info.ContextWindow {
+			return nil
+		}
+	}
+	return invalidRequest("prompt is too long: %d tokens > %d maximum (estimated by kiro2cc for %s)", tokens, info.ContextWindow, req.Model)
+}
+
+// trimToContextWindow 从最早的消息开始丢弃，直到请求的估算不超过 window，返回丢弃的条数和剩余的消息。
+// 剩余的消息总是以不含 tool_result 的用户消息开头，保证工具调用和结果成对保留；
+// 最后一条用户消息 (及其后的预填充) 不会被丢弃，只剩它们仍然超出时返回能裁剪到的最短结果
+func trimToContextWindow(req AnthropicRequest, window int) (int, []AnthropicRequestMessage) {
+	var starts []int
+	for i := 1; i < len(req.Messages); i++ {
+		if req.Messages[i].Role == "user" && !hasToolResult(req.Messages[i].Content) {
+			starts = append(starts, i)
+		}
+	}
+	if len(starts) == 0 {
+		return 0, req.Messages
+	}
+	// 丢弃得越多估算越小，二分查找放得下的最少丢弃，每次估算都要转换整个请求
+	n := sort.Search(len(starts), func(i int) bool {
+		trimmed := req
+		trimmed.Messages = req.Messages[starts[i]:]
+		return estimateInputTokens(trimmed) <= window
+	})
+	if n == len(starts) {
+		n--
+	}
+	return starts[n], req.Messages[starts[n]:]
+}
+
+// hasToolResult 判断消息内容中是否有 tool_result 内容块
+func hasToolResult(content any) bool {
+	blocks, _ := content.([]any)
+	for _, block := range blocks {
+		if m, ok := block.(map[string]any); ok && m["type"] == "tool_result" {
+			return true
+		}
+	}
+	return false
+}
+
+// estimateInputTokens 估算请求的输入 token 数：把请求转换为发往上游的 CodeWhisperer 请求后按 estimateRequestTokens 计算，
+// 与调试响应头中的历史规模使用同一个口径。图片等不会发往上游的内容块不计入
+func estimateInputTokens(req AnthropicRequest) int {
+	return estimateRequestTokens(buildCodeWhispererRequest(req, "", ""))
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/bestk/kiro2cc/parser"
+)
+
+// continuationPrompt 是续写请求中追加的用户消息
+const continuationPrompt = "Your previous response was cut off. Continue exactly where it stopped, without repeating any text and without any preamble."
+
+// generateContinued 调用 generateOnce，上游提前结束时以已收到的输出作为 assistant 历史请求续写，
+// 最多续写 Config.MaxContinuations 次，各次的输出拼接为一个回复，客户端看不出区别。
+// 只续写纯文本的回复，工具调用的输入无法可靠地拼接
+func (s *Server) generateContinued(ctx context.Context, anthropicReq AnthropicRequest) ([]parser.SSEEvent, error) {
+	events, err := s.generateOnce(ctx, anthropicReq)
+	diag := diagnosticsFrom(ctx)
+	text := ""
+	for round := 0; round < s.config.MaxContinuations; round++ {
+		if err != nil || !stoppedEarly(diag, anthropicReq.MaxTokens, events) || !textOnly(events) {
+			break
+		}
+		text += eventsText(events)
+		stitched := withoutMessageDeltas(events)
+		fmt.Printf("上游输出在 %d 字符处提前结束，第 %d 次续写\n", len([]rune(text)), round+1)
+
+		diag.Truncated = nil
+		diag.Continuations++
+		var next []parser.SSEEvent
+		next, err = s.generateOnce(ctx, continuationRequest(anthropicReq, text))
+		if err != nil {
+			// 续写失败时仍返回已有的输出，并标记为不完整
+			fmt.Printf("警告: 续写失败: %v\n", err)
+			diag.Truncated = err
+			return stitched, nil
+		}
+		events = append(stitched, next...)
+	}
+	return events, err
+}
+
+// stoppedEarly 判断上游是否在回复完成前结束：连接中途断开，或上游按 token 计量的输出达到了 max_tokens。
+// 上游不会返回 max_tokens 停止原因，长度上限只能从计量事件中的输出 token 数判断
+func stoppedEarly(diag *upstreamDiagnostics, maxTokens int, events []parser.SSEEvent) bool {
+	if diag.Truncated != nil {
+		return true
+	}
+	for _, e := range events {
+		if tokens, ok := upstreamOutputTokens(e.Data); ok && maxTokens > 0 && tokens >= maxTokens {
+			return true
+		}
+	}
+	return false
+}
+
+// textOnly 判断回复是否只有文本
+func textOnly(events []parser.SSEEvent) bool {
+	for _, e := range events {
+		switch data := e.Data.(type) {
+		case parser.MessageDelta:
+		case parser.ContentBlockDelta:
+			if data.Delta.Type != "text_delta" {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return hasContent(events)
+}
+
+// eventsText 拼接事件中的文本
+func eventsText(events []parser.SSEEvent) string {
+	var b strings.Builder
+	for _, e := range events {
+		b.WriteString(deltaText(e.Data))
+	}
+	return b.String()
+}
+
+// withoutMessageDeltas 去掉中间各次输出的停止原因和用量，只保留最后一次的
+func withoutMessageDeltas(events []parser.SSEEvent) []parser.SSEEvent {
+	kept := make([]parser.SSEEvent, 0, len(events))
+	for _, e := range events {
+		if _, ok := e.Data.(parser.MessageDelta); !ok {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+// continuationRequest 构造续写请求：已有的输出作为 assistant 消息（与预填充合并），再追加一条要求继续的用户消息
+func continuationRequest(anthropicReq AnthropicRequest, text string) AnthropicRequest {
+	messages := anthropicReq.Messages
+	if prefill, ok := assistantPrefill(messages); ok {
+		messages = messages[:len(messages)-1]
+		text = prefill + text
+	}
+	continued := anthropicReq
+	continued.Messages = append(append([]AnthropicRequestMessage{}, messages...),
+		AnthropicRequestMessage{Role: "assistant", Content: text},
+		AnthropicRequestMessage{Role: "user", Content: continuationPrompt},
+	)
+	return continued
+}
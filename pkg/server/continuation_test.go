@@ -0,0 +1,104 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/bestk/kiro2cc/parser"
+)
+
+// maxTokensEvents 构造因长度上限停止的文本回复：与解析器处理按 token 计量的事件得到的一样，
+// 只有输出 token 数，没有停止原因。测试请求的 max_tokens 都是 10
+func maxTokensEvents(text string) []parser.SSEEvent {
+	return append(textEvents(text), parser.SSEEvent{
+		Event: "message_delta",
+		Data:  parser.MessageDelta{Type: "message_delta", Usage: parser.Usage{OutputTokens: 10}},
+	})
+}
+
+// responseText 返回非流式响应中的第一个文本块
+func responseText(t *testing.T, body []byte) string {
+	t.Helper()
+	var resp struct {
+		Content []struct {
+			Text string `json:"text"`
+		} `json:"content"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil || len(resp.Content) == 0 {
+		t.Fatalf("response %s: %v", body, err)
+	}
+	return resp.Content[0].Text
+}
+
+func TestContinuationStitchesOutput(t *testing.T) {
+	backend := &scriptedBackend{replies: [][]parser.SSEEvent{maxTokensEvents("Hello, wor"), textEvents("ld!")}}
+	srv := newTestServer(t, Config{Backend: backend, MaxContinuations: 2, Debug: true})
+
+	rec := postMessages(srv, hookTestBody)
+	if got := responseText(t, rec.Body.Bytes()); got != "Hello, world!" {
+		t.Errorf("text = %q", got)
+	}
+	if got := rec.Header().Get("x-kiro2cc-continuations"); got != "1" {
+		t.Errorf("continuations header = %q", got)
+	}
+	if len(backend.reqs) != 2 {
+		t.Fatalf("backend called %d times", len(backend.reqs))
+	}
+	messages := backend.reqs[1].Messages
+	if len(messages) != 3 || messages[1].Role != "assistant" || messages[1].Content != "Hello, wor" || messages[2].Content != continuationPrompt {
+		t.Errorf("continuation messages = %+v", messages)
+	}
+}
+
+func TestContinuationWithPrefill(t *testing.T) {
+	backend := &scriptedBackend{replies: [][]parser.SSEEvent{maxTokensEvents("1, 2"), textEvents(", 3")}}
+	srv := newTestServer(t, Config{Backend: backend, MaxContinuations: 1})
+
+	rec := postMessages(srv, `{"model":"claude-sonnet-4-20250514","max_tokens":10,"messages":[{"role":"user","content":"count"},{"role":"assistant","content":"0, "}]}`)
+	if got := responseText(t, rec.Body.Bytes()); got != "0, 1, 2, 3" {
+		t.Errorf("text = %q", got)
+	}
+	messages := backend.reqs[1].Messages
+	if len(messages) != 3 || messages[1].Content != "0, 1, 2" {
+		t.Errorf("continuation messages = %+v", messages)
+	}
+}
+
+func TestContinuationIsBounded(t *testing.T) {
+	backend := &scriptedBackend{replies: [][]parser.SSEEvent{maxTokensEvents("a"), maxTokensEvents("b"), maxTokensEvents("c"), maxTokensEvents("d")}}
+	srv := newTestServer(t, Config{Backend: backend, MaxContinuations: 2})
+
+	rec := postMessages(srv, hookTestBody)
+	if got := responseText(t, rec.Body.Bytes()); got != "abc" {
+		t.Errorf("text = %q", got)
+	}
+	if len(backend.reqs) != 3 {
+		t.Errorf("backend called %d times, want 3", len(backend.reqs))
+	}
+}
+
+func TestNoContinuation(t *testing.T) {
+	toolCall := append(maxTokensEvents("let me check"), parser.SSEEvent{
+		Event: "content_block_start",
+		Data:  parser.ContentBlockStart{Type: "content_block_start", Index: 1, ContentBlock: parser.ContentBlock{Type: "tool_use", ID: "t1", Name: "ls"}},
+	})
+	for name, tc := range map[string]struct {
+		limit int
+		reply []parser.SSEEvent
+	}{
+		"disabled":  {0, maxTokensEvents("partial")},
+		"completed": {2, textEvents("done")},
+		"under max_tokens": {2, append(textEvents("done"), parser.SSEEvent{
+			Event: "message_delta",
+			Data:  parser.MessageDelta{Type: "message_delta", Usage: parser.Usage{OutputTokens: 3}},
+		})},
+		"tool use": {2, toolCall},
+	} {
+		backend := &scriptedBackend{replies: [][]parser.SSEEvent{tc.reply}}
+		srv := newTestServer(t, Config{Backend: backend, MaxContinuations: tc.limit})
+		postMessages(srv, hookTestBody)
+		if len(backend.reqs) != 1 {
+			t.Errorf("%s: backend called %d times", name, len(backend.reqs))
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// emptyContentPlaceholder 是 substitute 模式下替换空内容使用的文本
+const emptyContentPlaceholder = "Please provide a response."
+
+// prefillInstruction 在上游不支持预填充时要求模型从给定文本之后继续作答
+const prefillInstruction = "\n\nBegin your response with exactly the following text and continue from where it ends, without repeating it:\n%s"
+
+// getMessageContent 从消息中提取文本内容
+func getMessageContent(content any) string {
+	switch v := content.(type) {
+	case string:
+		if len(strings.TrimSpace(v)) == 0 {
+			return emptyContentPlaceholder
+		}
+		return v
+	case []interface{}:
+		var texts []string
+		for _, block := range v {
+			// 直接读取字段，避免每个内容块都经过一次 JSON 编解码
+			if m, ok := block.(map[string]interface{}); ok {
+				switch m["type"] {
+				case "tool_result":
+					if content, ok := m["content"].(string); ok {
+						texts = append(texts, content)
+					}
+				case "text":
+					if text, ok := m["text"].(string); ok {
+						texts = append(texts, text)
+					}
+				case "tool_use":
+					// Skip tool_use blocks for content extraction
+					continue
+				}
+			}
+		}
+		if len(texts) == 0 {
+			s, err := json.Marshal(content)
+			if err != nil {
+				return emptyContentPlaceholder
+			}
+			log.Printf("Unhandled content format: %s", string(s))
+			return emptyContentPlaceholder
+		}
+		joined := strings.Join(texts, "\n")
+		if len(strings.TrimSpace(joined)) == 0 {
+			return emptyContentPlaceholder
+		}
+		return joined
+	default:
+		s, err := json.Marshal(content)
+		if err != nil {
+			return emptyContentPlaceholder
+		}
+		log.Printf("Unhandled content type: %s", string(s))
+		return emptyContentPlaceholder
+	}
+}
+
+// generateUUID generates a UUID v4 from crypto/rand, so conversation and message IDs can't be guessed
+func generateUUID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("读取系统随机数失败: %v", err))
+	}
+	b[6] = (b[6] & 0x0f) | 0x40 // Version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // Variant bits
+	return fmt.Sprintf("%08x-%04x-%04x-%04x-%012x",
+		b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// newMessageID 生成 Anthropic 风格的消息 ID，基于 UUID，同一秒内的请求也不会重复
+func newMessageID() string {
+	return "msg_" + strings.ReplaceAll(generateUUID(), "-", "")
+}
+
+// buildCodeWhispererRequest 构建 CodeWhisperer 请求，origin 写入当前消息和历史中的用户消息
+func buildCodeWhispererRequest(anthropicReq AnthropicRequest, profileArn, origin string) CodeWhispererRequest {
+	cwReq := CodeWhispererRequest{
+		ProfileArn: profileArn,
+	}
+	cwReq.ConversationState.ChatTriggerType = ChatTriggerManual
+	cwReq.ConversationState.ConversationId = generateUUID()
+
+	// 结尾的 assistant 消息是预填充，CodeWhisperer 的当前消息必须来自用户，
+	// 因此去掉预填充并通过指令让模型接着预填充的内容作答
+	messages := anthropicReq.Messages
+	prefill, hasPrefill := assistantPrefill(messages)
+	if hasPrefill {
+		messages = messages[:len(messages)-1]
+	}
+
+	// 确保获取最后一条用户消息
+	lastMessage := messages[len(messages)-1]
+	var content string
+	if results, ok := toolResultsOnly(lastMessage.Content); ok {
+		// 只有 tool_result 的轮次（客户端执行完工具后的回传）通过 toolResults 原样交给上游，
+		// 正文留空，不拼接结果文本也不填充占位提示，以免模型把它们当成新的用户输入
+		cwReq.ConversationState.CurrentMessage.UserInputMessage.UserInputMessageContext.ToolResults = results
+	} else {
+		content = getMessageContent(lastMessage.Content)
+		// 确保内容不为空
+		if strings.TrimSpace(content) == "" {
+			content = emptyContentPlaceholder
+		}
+	}
+	if prefill != "" {
+		content += fmt.Sprintf(prefillInstruction, prefill)
+	}
+
+	cwReq.ConversationState.CurrentMessage.UserInputMessage.Content = content
+	cwReq.ConversationState.CurrentMessage.UserInputMessage.ModelId = ModelMap[anthropicReq.Model]
+	cwReq.ConversationState.CurrentMessage.UserInputMessage.Origin = origin
+	// 处理 tools 信息
+	if len(anthropicReq.Tools) > 0 {
+		var tools []CodeWhispererTool
+		for _, tool := range anthropicReq.Tools {
+			cwTool := CodeWhispererTool{}
+			cwTool.ToolSpecification.Name = tool.Name
+			cwTool.ToolSpecification.Description = tool.Description
+			cwTool.ToolSpecification.InputSchema = InputSchema{
+				Json: tool.InputSchema,
+			}
+			tools = append(tools, cwTool)
+		}
+		cwReq.ConversationState.CurrentMessage.UserInputMessage.UserInputMessageContext.Tools = tools
+	}
+
+	// 构建历史消息
+	// 先处理 system 消息或者常规历史消息
+	if len(anthropicReq.System) > 0 || len(messages) > 1 {
+		var history []any
+
+		// 首先添加每个 system 消息作为独立的历史记录项
+
+		assistantDefaultMsg := HistoryAssistantMessage{}
+		assistantDefaultMsg.AssistantResponseMessage.Content = getMessageContent("I will follow these instructions")
+		assistantDefaultMsg.AssistantResponseMessage.ToolUses = make([]any, 0)
+
+		if len(anthropicReq.System) > 0 {
+			for _, sysMsg := range anthropicReq.System {
+				userMsg := HistoryUserMessage{}
+				userMsg.UserInputMessage.Content = sysMsg.Text
+				userMsg.UserInputMessage.ModelId = ModelMap[anthropicReq.Model]
+				userMsg.UserInputMessage.Origin = origin
+				history = append(history, userMsg)
+				history = append(history, assistantDefaultMsg)
+			}
+		}
+
+		// 然后处理常规消息历史，消息已在校验阶段合并为 user/assistant 交替
+		for i := 0; i < len(messages)-1; i++ {
+			switch messages[i].Role {
+			case "user":
+				userMsg := HistoryUserMessage{}
+				if results, ok := toolResultsOnly(messages[i].Content); ok {
+					// 与当前消息一样，工具结果以结构化形式回传，和上一条助手消息的 toolUses 对应
+					userMsg.UserInputMessage.UserInputMessageContext = &HistoryUserInputContext{ToolResults: results}
+				} else {
+					userMsg.UserInputMessage.Content = getMessageContent(messages[i].Content)
+				}
+				userMsg.UserInputMessage.ModelId = ModelMap[anthropicReq.Model]
+				userMsg.UserInputMessage.Origin = origin
+				history = append(history, userMsg)
+			case "assistant":
+				assistantMsg := HistoryAssistantMessage{}
+				assistantMsg.AssistantResponseMessage.ToolUses = toolUses(messages[i].Content)
+				if len(assistantMsg.AssistantResponseMessage.ToolUses) > 0 && !hasText(messages[i].Content) {
+					// 只有工具调用的轮次没有正文，不填充占位提示
+					assistantMsg.AssistantResponseMessage.Content = ""
+				} else {
+					assistantMsg.AssistantResponseMessage.Content = getMessageContent(messages[i].Content)
+				}
+				history = append(history, assistantMsg)
+			}
+		}
+
+		cwReq.ConversationState.History = history
+	}
+
+	return cwReq
+}
+
+// toolResultsOnly 判断消息内容是否只由 tool_result 块组成（允许夹杂空白文本块），
+// 是则返回对应的 CodeWhisperer 工具结果
+func toolResultsOnly(content any) ([]CodeWhispererToolResult, bool) {
+	blocks, ok := content.([]interface{})
+	if !ok {
+		return nil, false
+	}
+	var results []CodeWhispererToolResult
+	for _, block := range blocks {
+		m, ok := block.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		switch m["type"] {
+		case "tool_result":
+			result := CodeWhispererToolResult{Status: "success"}
+			result.ToolUseId, _ = m["tool_use_id"].(string)
+			if isError, _ := m["is_error"].(bool); isError {
+				result.Status = "error"
+			}
+			for _, text := range toolResultTexts(m["content"]) {
+				result.Content = append(result.Content, CodeWhispererToolResultContent{Text: text})
+			}
+			if len(result.Content) == 0 {
+				// 上游要求每个结果至少有一段内容
+				result.Content = []CodeWhispererToolResultContent{{Text: ""}}
+			}
+			results = append(results, result)
+		case "text":
+			if text, _ := m["text"].(string); strings.TrimSpace(text) != "" {
+				return nil, false
+			}
+		default:
+			return nil, false
+		}
+	}
+	return results, len(results) > 0
+}
+
+// toolUses 提取助手消息中的 tool_use 块，转换为 CodeWhisperer 的工具调用
+func toolUses(content any) []any {
+	uses := make([]any, 0)
+	for _, block := range contentBlocks(content) {
+		m, ok := block.(map[string]interface{})
+		if !ok || m["type"] != "tool_use" {
+			continue
+		}
+		use := CodeWhispererToolUse{Input: m["input"]}
+		use.ToolUseId, _ = m["id"].(string)
+		use.Name, _ = m["name"].(string)
+		if use.Input == nil {
+			use.Input = map[string]interface{}{}
+		}
+		uses = append(uses, use)
+	}
+	return uses
+}
+
+// hasText 判断消息内容中是否有非空白的文本
+func hasText(content any) bool {
+	for _, block := range contentBlocks(content) {
+		if m, ok := block.(map[string]interface{}); ok && m["type"] == "text" {
+			if text, _ := m["text"].(string); strings.TrimSpace(text) != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// toolResultTexts 提取 tool_result 的内容，内容可以是字符串或文本块数组，非文本块会被忽略
+func toolResultTexts(content any) []string {
+	switch v := content.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var texts []string
+		for _, block := range v {
+			if m, ok := block.(map[string]interface{}); ok && m["type"] == "text" {
+				if text, ok := m["text"].(string); ok {
+					texts = append(texts, text)
+				}
+			}
+		}
+		return texts
+	}
+	return nil
+}
+
+// assistantPrefill 判断消息是否以 assistant 消息结尾（Anthropic 的预填充），
+// 返回其中的文本；预填充中的 tool_use 等非文本块会被忽略
+func assistantPrefill(messages []AnthropicRequestMessage) (string, bool) {
+	if len(messages) == 0 || messages[len(messages)-1].Role != "assistant" {
+		return "", false
+	}
+
+	var texts []string
+	for _, block := range contentBlocks(messages[len(messages)-1].Content) {
+		if m, ok := block.(map[string]interface{}); ok && m["type"] == "text" {
+			if text, ok := m["text"].(string); ok {
+				texts = append(texts, text)
+			}
+		}
+	}
+	return strings.Join(texts, ""), true
+}
+
+// normalizeMessages 按 Anthropic 的语义合并相邻的同角色消息，
+// 例如 SDK 生成的多条连续 tool_result 用户消息会合并为一条
+func normalizeMessages(messages []AnthropicRequestMessage) []AnthropicRequestMessage {
+	normalized := make([]AnthropicRequestMessage, 0, len(messages))
+	for _, msg := range messages {
+		last := len(normalized) - 1
+		if last >= 0 && normalized[last].Role == msg.Role {
+			normalized[last].Content = append(contentBlocks(normalized[last].Content), contentBlocks(msg.Content)...)
+			continue
+		}
+		normalized = append(normalized, msg)
+	}
+	return normalized
+}
+
+// contentBlocks 把消息内容统一转换为内容块数组
+func contentBlocks(content any) []interface{} {
+	switch v := content.(type) {
+	case string:
+		return []interface{}{map[string]interface{}{"type": "text", "text": v}}
+	case []interface{}:
+		return append([]interface{}(nil), v...)
+	case nil:
+		return nil
+	default:
+		return []interface{}{v}
+	}
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// historyRoles 返回 CodeWhisperer 历史记录中每一项的角色
+func historyRoles(t *testing.T, cwReq CodeWhispererRequest) []string {
+	t.Helper()
+	data, err := json.Marshal(cwReq.ConversationState.History)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var items []map[string]json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		t.Fatal(err)
+	}
+	var roles []string
+	for _, item := range items {
+		switch {
+		case item["userInputMessage"] != nil:
+			roles = append(roles, "user")
+		case item["assistantResponseMessage"] != nil:
+			roles = append(roles, "assistant")
+		}
+	}
+	return roles
+}
+
+func TestNormalizeMessagesMergesConsecutiveRoles(t *testing.T) {
+	srv := newTestServer(t, Config{})
+	req, reqErr := srv.parseAnthropicRequest([]byte(`{"model":"claude-sonnet-4-20250514","max_tokens":10,"messages":[
+		{"role":"user","content":"hello"},
+		{"role":"user","content":"are you there?"},
+		{"role":"assistant","content":[{"type":"tool_use","id":"t1","name":"ls","input":{}}]},
+		{"role":"user","content":[{"type":"tool_result","tool_use_id":"t1","content":"a.txt"}]},
+		{"role":"user","content":[{"type":"tool_result","tool_use_id":"t2","content":"b.txt"}]}
+	]}`))
+	if reqErr != nil {
+		t.Fatalf("parseAnthropicRequest: %v", reqErr)
+	}
+
+	if len(req.Messages) != 3 {
+		t.Fatalf("expected 3 merged messages, got %d", len(req.Messages))
+	}
+	if got := getMessageContent(req.Messages[0].Content); got != "hello\nare you there?" {
+		t.Errorf("merged first message = %q", got)
+	}
+	if got := getMessageContent(req.Messages[2].Content); got != "a.txt\nb.txt" {
+		t.Errorf("merged tool results = %q", got)
+	}
+
+	cwReq := buildCodeWhispererRequest(req, DefaultProfileArn, OriginAIEditor)
+	roles := historyRoles(t, cwReq)
+	if len(roles) != 2 || roles[0] != "user" || roles[1] != "assistant" {
+		t.Errorf("history roles = %v", roles)
+	}
+}
+
+func TestAssistantPrefill(t *testing.T) {
+	srv := newTestServer(t, Config{})
+	req, reqErr := srv.parseAnthropicRequest([]byte(`{"model":"claude-sonnet-4-20250514","max_tokens":10,"messages":[
+		{"role":"user","content":"first"},
+		{"role":"assistant","content":"ok"},
+		{"role":"user","content":"list three colors as JSON"},
+		{"role":"assistant","content":"{\"colors\": ["}
+	]}`))
+	if reqErr != nil {
+		t.Fatalf("parseAnthropicRequest: %v", reqErr)
+	}
+
+	cwReq := buildCodeWhispererRequest(req, DefaultProfileArn, OriginAIEditor)
+	current := cwReq.ConversationState.CurrentMessage.UserInputMessage.Content
+	if !strings.HasPrefix(current, "list three colors as JSON") || !strings.Contains(current, `{"colors": [`) {
+		t.Errorf("current message = %q", current)
+	}
+	roles := historyRoles(t, cwReq)
+	if len(roles) != 2 || roles[0] != "user" || roles[1] != "assistant" {
+		t.Errorf("history roles = %v", roles)
+	}
+
+	resp := buildMessageResponse(req, textEvents(`"red"]}`))
+	content := resp["content"].([]map[string]any)
+	if len(content) != 1 || content[0]["text"] != `{"colors": ["red"]}` {
+		t.Errorf("response content = %v", content)
+	}
+
+	if _, reqErr := srv.parseAnthropicRequest([]byte(`{"model":"claude-sonnet-4-20250514","max_tokens":10,"messages":[{"role":"assistant","content":"hi"}]}`)); reqErr == nil {
+		t.Error("expected a lone assistant message to be rejected")
+	}
+}
+
+func TestToolResultOnlyTurn(t *testing.T) {
+	srv := newTestServer(t, Config{})
+	req, reqErr := srv.parseAnthropicRequest([]byte(`{"model":"claude-sonnet-4-20250514","max_tokens":10,"messages":[
+		{"role":"user","content":"list files"},
+		{"role":"assistant","content":[{"type":"tool_use","id":"t1","name":"ls","input":{}},{"type":"tool_use","id":"t2","name":"cat","input":{}}]},
+		{"role":"user","content":[
+			{"type":"tool_result","tool_use_id":"t1","content":"a.txt"},
+			{"type":"tool_result","tool_use_id":"t2","is_error":true,"content":[{"type":"text","text":"no such file"}]}
+		]}
+	]}`))
+	if reqErr != nil {
+		t.Fatalf("parseAnthropicRequest: %v", reqErr)
+	}
+
+	current := buildCodeWhispererRequest(req, DefaultProfileArn, OriginAIEditor).ConversationState.CurrentMessage.UserInputMessage
+	if current.Content != "" {
+		t.Errorf("content = %q, want empty", current.Content)
+	}
+	results := current.UserInputMessageContext.ToolResults
+	if len(results) != 2 {
+		t.Fatalf("toolResults = %+v", results)
+	}
+	if results[0].ToolUseId != "t1" || results[0].Status != "success" || results[0].Content[0].Text != "a.txt" {
+		t.Errorf("first result = %+v", results[0])
+	}
+	if results[1].ToolUseId != "t2" || results[1].Status != "error" || results[1].Content[0].Text != "no such file" {
+		t.Errorf("second result = %+v", results[1])
+	}
+
+	// 历史中的工具调用与结果同样以结构化形式发送，toolUseId 互相对应
+	req.Messages = append(req.Messages,
+		AnthropicRequestMessage{Role: "assistant", Content: "a.txt exists"},
+		AnthropicRequestMessage{Role: "user", Content: "thanks"})
+	history := buildCodeWhispererRequest(req, DefaultProfileArn, OriginAIEditor).ConversationState.History
+	if len(history) != 4 {
+		t.Fatalf("history = %+v", history)
+	}
+	call := history[1].(HistoryAssistantMessage).AssistantResponseMessage
+	if call.Content != "" || len(call.ToolUses) != 2 || call.ToolUses[0].(CodeWhispererToolUse).ToolUseId != "t1" || call.ToolUses[1].(CodeWhispererToolUse).Name != "cat" {
+		t.Errorf("tool use turn = %+v", call)
+	}
+	reply := history[2].(HistoryUserMessage).UserInputMessage
+	if reply.Content != "" || reply.UserInputMessageContext == nil || len(reply.UserInputMessageContext.ToolResults) != 2 || reply.UserInputMessageContext.ToolResults[1].Status != "error" {
+		t.Errorf("tool result turn = %+v", reply)
+	}
+	if plain := history[0].(HistoryUserMessage).UserInputMessage; plain.UserInputMessageContext != nil {
+		t.Errorf("plain user turn carries context: %+v", plain)
+	}
+
+	// 带有用户文本的轮次仍按文本发送
+	mixed := []interface{}{
+		map[string]interface{}{"type": "tool_result", "tool_use_id": "t1", "content": "a.txt"},
+		map[string]interface{}{"type": "text", "text": "now summarize"},
+	}
+	if _, ok := toolResultsOnly(mixed); ok {
+		t.Error("turn with user text should not be treated as tool results only")
+	}
+}
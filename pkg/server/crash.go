@@ -0,0 +1,203 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// crashRecentRequests 是崩溃报告中附带的最近请求 ID 数量
+const crashRecentRequests = 20
+
+// crashRedacted 替换崩溃报告中的敏感配置
+const crashRedacted = "[已隐藏]"
+
+// crashReport 是 panic 时写入状态目录的报告，用户提交 issue 时附上该文件即可
+type crashReport struct {
+	Time      time.Time `json:"time"`
+	Version   string    `json:"version"`
+	GoVersion string    `json:"go_version"`
+	Platform  string    `json:"platform"`
+	Panic     string    `json:"panic"`
+	// Request 是发生 panic 的请求，panic 不在请求处理中时为空
+	Request        string         `json:"request,omitempty"`
+	RecentRequests []string       `json:"recent_requests"`
+	Config         map[string]any `json:"config"`
+	Stack          string         `json:"stack"`
+}
+
+// recoverMiddleware 捕获处理请求时的 panic，写入崩溃报告后返回 500，服务器继续运行
+func (s *Server) recoverMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			// http.ErrAbortHandler 是主动中断响应，不是程序错误
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			path, err := s.writeCrashReport(v, debug.Stack(), r.Method+" "+r.URL.Path)
+			if err != nil {
+				fmt.Printf("请求 %s %s 发生 panic: %v，写入崩溃报告失败: %v\n", r.Method, r.URL.Path, v, err)
+			} else {
+				fmt.Printf("请求 %s %s 发生 panic: %v，崩溃报告已写入 %s，提交 issue 时请附上该文件\n", r.Method, r.URL.Path, v, path)
+			}
+			// 响应可能已经开始写出，此时客户端只会看到连接中断
+			sendJSONErrorWithCode(w, http.StatusInternalServerError, CodeInternal, "api_error", "服务器内部错误，崩溃报告已写入服务器的状态目录")
+		}()
+		next(w, r)
+	}
+}
+
+// WriteCrashReport 把 panic 的值和调用栈连同版本、最近的请求 ID 和脱敏后的配置写入崩溃报告，
+// 返回报告路径。报告位于 StateDir，未设置时位于系统临时目录。供嵌入方在自己的 recover 中使用
+func (s *Server) WriteCrashReport(value any, stack []byte) (string, error) {
+	return s.writeCrashReport(value, stack, "")
+}
+
+func (s *Server) writeCrashReport(value any, stack []byte, request string) (string, error) {
+	now := time.Now().UTC()
+	report := crashReport{
+		Time:           now,
+		Version:        buildVersion(),
+		GoVersion:      runtime.Version(),
+		Platform:       runtime.GOOS + "/" + runtime.GOARCH,
+		Panic:          fmt.Sprint(value),
+		Request:        request,
+		RecentRequests: s.inflight.recentIDs(),
+		Config:         sanitizedConfig(s.config),
+		Stack:          string(stack),
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	dir := s.config.StateDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	// 同一秒内可能有多个请求 panic，用纳秒区分
+	name := fmt.Sprintf("kiro2cc-crash-%s-%09d.json", now.Format("20060102-150405"), now.Nanosecond())
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// buildVersion 返回构建信息中的模块版本和 VCS 修订号
+func buildVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	version := info.Main.Version
+	if version == "" {
+		version = "(devel)"
+	}
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			version += " " + setting.Value
+		case "vcs.modified":
+			if setting.Value == "true" {
+				version += " (modified)"
+			}
+		}
+	}
+	return version
+}
+
+// sanitizedConfig 返回去掉敏感内容的配置: admin token、Redis 密码、注入的 system 内容和钩子命令只标明是否设置，
+// 按 API key 的设置只保留语言
+func sanitizedConfig(config Config) map[string]any {
+	backend := ""
+	if config.Backend != nil {
+		backend = fmt.Sprintf("%T", config.Backend)
+	}
+	config.Backend = nil
+	clientAuth := make([]string, len(config.ClientAuth))
+	for i, provider := range config.ClientAuth {
+		clientAuth[i] = fmt.Sprintf("%T", provider)
+	}
+	config.ClientAuth = nil
+
+	redact := func(v *string) {
+		if *v != "" {
+			*v = crashRedacted
+		}
+	}
+	redact(&config.AdminToken)
+	redact(&config.SigningSecret)
+	redact(&config.JWTSecret)
+	redact(&config.SystemPrefix)
+	redact(&config.RequestHook)
+	redact(&config.ResponseHook)
+	apiKeys := make([]string, len(config.APIKeys))
+	for i := range config.APIKeys {
+		apiKeys[i] = crashRedacted
+	}
+	config.APIKeys = apiKeys
+	tenants := make([]Tenant, len(config.Tenants))
+	for i, tenant := range config.Tenants {
+		tenant.APIKeys = make([]string, len(tenant.APIKeys))
+		for j := range tenant.APIKeys {
+			tenant.APIKeys[j] = crashRedacted
+		}
+		tenants[i] = tenant
+	}
+	config.Tenants = tenants
+	if len(config.ResponseLanguageKeys) > 0 {
+		languages := make(map[string]string, len(config.ResponseLanguageKeys))
+		i := 0
+		for _, language := range config.ResponseLanguageKeys {
+			i++
+			languages[fmt.Sprintf("%s#%d", crashRedacted, i)] = language
+		}
+		config.ResponseLanguageKeys = languages
+	}
+	if len(config.KeyModels) > 0 {
+		keyModels := make(map[string]ModelAccess, len(config.KeyModels))
+		i := 0
+		for _, access := range config.KeyModels {
+			i++
+			keyModels[fmt.Sprintf("%s#%d", crashRedacted, i)] = access
+		}
+		config.KeyModels = keyModels
+	}
+	if config.RedisURL != "" {
+		if u, err := url.Parse(config.RedisURL); err == nil {
+			config.RedisURL = u.Redacted()
+		} else {
+			config.RedisURL = crashRedacted
+		}
+	}
+
+	var fields map[string]any
+	data, err := json.Marshal(config)
+	if err == nil {
+		err = json.Unmarshal(data, &fields)
+	}
+	if err != nil {
+		return map[string]any{"error": err.Error()}
+	}
+	if backend != "" {
+		fields["Backend"] = backend
+	}
+	if len(clientAuth) > 0 {
+		fields["ClientAuth"] = clientAuth
+	}
+	return fields
+}
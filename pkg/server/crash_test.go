@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bestk/kiro2cc/parser"
+)
+
+type panicBackend struct{}
+
+func (panicBackend) Generate(ctx context.Context, anthropicReq AnthropicRequest) ([]parser.SSEEvent, error) {
+	panic("boom")
+}
+
+func TestPanicWritesCrashReport(t *testing.T) {
+	stateDir := t.TempDir()
+	srv := newTestServer(t, Config{
+		Backend:      panicBackend{},
+		StateDir:     stateDir,
+		AdminToken:   "admin-secret",
+		SystemPrefix: "组织策略",
+		RedisURL:     "redis://:redis-secret@localhost:6379/0",
+	})
+
+	rec := postMessages(srv, hookTestBody)
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), string(CodeInternal)) {
+		t.Errorf("body = %s, want error code %s", rec.Body.String(), CodeInternal)
+	}
+
+	paths, err := filepath.Glob(filepath.Join(stateDir, "kiro2cc-crash-*.json"))
+	if err != nil || len(paths) != 1 {
+		t.Fatalf("crash reports = %v, %v", paths, err)
+	}
+	data, err := os.ReadFile(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"admin-secret", "redis-secret", "组织策略"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("crash report leaks %q", secret)
+		}
+	}
+
+	var report crashReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Panic != "boom" || report.Request != "POST /v1/messages" {
+		t.Errorf("panic = %q, request = %q", report.Panic, report.Request)
+	}
+	if !strings.Contains(report.Stack, "panicBackend") {
+		t.Errorf("stack does not mention the panicking frame:\n%s", report.Stack)
+	}
+	if len(report.RecentRequests) != 1 {
+		t.Errorf("recent requests = %v, want the panicking request", report.RecentRequests)
+	}
+	if report.Config["AdminToken"] != crashRedacted || report.Config["Backend"] != "server.panicBackend" {
+		t.Errorf("config = %v", report.Config)
+	}
+	if report.Version == "" || report.GoVersion == "" {
+		t.Errorf("version = %q, go version = %q", report.Version, report.GoVersion)
+	}
+}
+
+func TestRecentIDsKeepsLatest(t *testing.T) {
+	ir := newInflightRegistry()
+	for i := 0; i < crashRecentRequests+5; i++ {
+		_, tr := ir.track(context.Background(), "req_"+string(rune('a'+i)), AnthropicRequest{})
+		ir.untrack(tr)
+	}
+	ids := ir.recentIDs()
+	if len(ids) != crashRecentRequests || ids[0] != "req_f" {
+		t.Errorf("recent ids = %v", ids)
+	}
+}
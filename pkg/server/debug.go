@@ -0,0 +1,74 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// debugDefaultModel 是调试请求未指定 model 时使用的模型
+const debugDefaultModel = "claude-sonnet-4-20250514"
+
+// debugDefaultMaxTokens 是调试请求未指定 max_tokens 时的输出上限
+const debugDefaultMaxTokens = 1024
+
+// handleDebugMessage 处理调试模式下的 GET /v1/messages，从查询参数读取 prompt 和 model，
+// 以纯文本返回回复，方便直接用 curl 验证代理是否可用
+func (s *Server) handleDebugMessage(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	prompt := query.Get("prompt")
+	if strings.TrimSpace(prompt) == "" {
+		http.Error(w, "缺少 prompt 参数，例如 /v1/messages?prompt=hello", http.StatusBadRequest)
+		return
+	}
+	model := query.Get("model")
+	if model == "" {
+		model = debugDefaultModel
+	}
+	if _, ok := ModelMap[model]; !ok {
+		http.Error(w, fmt.Sprintf("不支持的模型: %s", model), http.StatusBadRequest)
+		return
+	}
+	maxTokens := debugDefaultMaxTokens
+	if v := query.Get("max_tokens"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, fmt.Sprintf("无效的 max_tokens 参数: %s", v), http.StatusBadRequest)
+			return
+		}
+		maxTokens = n
+	}
+
+	anthropicReq := AnthropicRequest{
+		Model:     model,
+		MaxTokens: maxTokens,
+		Messages:  []AnthropicRequestMessage{{Role: "user", Content: prompt}},
+	}
+	if system := query.Get("system"); system != "" {
+		anthropicReq.System = []AnthropicSystemMessage{{Type: "text", Text: system}}
+	}
+
+	ctx, tracked := s.inflight.track(r.Context(), newMessageID(), anthropicReq)
+	defer s.inflight.untrack(tracked)
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	ctx, diag := withDiagnostics(ctx)
+
+	events, err := s.generate(ctx, anthropicReq)
+	s.setDebugHeaders(w.Header(), diag)
+	if err != nil {
+		fmt.Printf("错误: 调试请求失败: %v\n", err)
+		http.Error(w, fmt.Sprintf("上游请求失败: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	var text strings.Builder
+	for _, e := range events {
+		text.WriteString(deltaText(e.Data))
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, text.String())
+}
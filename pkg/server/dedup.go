@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bestk/kiro2cc/parser"
+)
+
+// inflightCall 表示一次正在进行的上游调用，相同请求的客户端共享它的结果
+type inflightCall struct {
+	done   chan struct{}
+	events []parser.SSEEvent
+	err    error
+	// diag 是这次上游调用自己的诊断记录，完成后复制给每个等待的客户端
+	diag    *upstreamDiagnostics
+	started time.Time
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// dedupGroup 合并窗口期内内容完全相同的并发请求，只向上游发送一次。
+// Claude Code 在认为超时后会重发请求，而原请求可能仍在生成中
+type dedupGroup struct {
+	mu     sync.Mutex
+	window time.Duration
+	calls  map[string]*inflightCall
+}
+
+func newDedupGroup(window time.Duration) *dedupGroup {
+	return &dedupGroup{window: window, calls: make(map[string]*inflightCall)}
+}
+
+// do 在窗口期内有相同 key 的调用进行中时等待它的结果，否则调用 fn。
+// 上游调用使用独立的 ctx，不受任何一个客户端的期限约束，只有所有等待的客户端都离开后才会被取消；
+// 每个客户端各自按自己 ctx 的期限等待
+func (g *dedupGroup) do(ctx context.Context, key string, fn func(ctx context.Context) ([]parser.SSEEvent, error)) ([]parser.SSEEvent, error) {
+	g.mu.Lock()
+	call, ok := g.calls[key]
+	if ok && time.Since(call.started) <= g.window {
+		call.waiters++
+		g.mu.Unlock()
+		fmt.Printf("检测到重复请求，复用进行中的上游调用\n")
+		return g.wait(ctx, key, call)
+	}
+
+	// 保留 ctx 中的身份等信息，但不随第一个客户端断开或超时而取消。诊断记录换成调用自己的，
+	// 第一个客户端离开后上游调用仍在写入，不能与它的处理函数共用
+	callCtx, diag := withDiagnostics(context.WithoutCancel(ctx))
+	callCtx, cancel := context.WithCancel(callCtx)
+	call = &inflightCall{done: make(chan struct{}), started: time.Now(), waiters: 1, cancel: cancel, diag: diag}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	go func() {
+		defer cancel()
+		call.events, call.err = fn(callCtx)
+
+		g.mu.Lock()
+		if g.calls[key] == call {
+			delete(g.calls, key)
+		}
+		g.mu.Unlock()
+		close(call.done)
+	}()
+
+	return g.wait(ctx, key, call)
+}
+
+// wait 等待调用完成并把调用的诊断记录复制到 ctx 中；ctx 先结束时退出等待，最后一个客户端离开时取消上游调用
+func (g *dedupGroup) wait(ctx context.Context, key string, call *inflightCall) ([]parser.SSEEvent, error) {
+	select {
+	case <-call.done:
+		// 续写次数由调用方自己累计，其余字段都由上游调用填写
+		diag := diagnosticsFrom(ctx)
+		continuations := diag.Continuations
+		*diag = *call.diag
+		diag.Continuations = continuations
+		return call.events, call.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			// 同时移出表中，之后的重试发起新的调用而不是加入已取消的调用
+			call.cancel()
+			if g.calls[key] == call {
+				delete(g.calls, key)
+			}
+		}
+		g.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// dedupKey 计算请求的指纹，stream 标志不影响上游请求，因此不参与计算
+func dedupKey(anthropicReq AnthropicRequest) string {
+	anthropicReq.Stream = false
+	data, _ := json.Marshal(anthropicReq)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// generate 调用 Backend，所有入口共用。请求先经过 RequestHook 变换，配置的 SystemPrefix
+// 在钩子之后注入到客户端的 system 之前，回复语言指令和结构化输出指令追加到 system 末尾，
+// 注入之后再按上下文窗口检查或裁剪，这些指令同样占用上下文。
+// 请求带 response_format 时校验输出并按需重试，否则上游提前结束时按需续写，最后输出经过 ResponseHook 变换
+func (s *Server) generate(ctx context.Context, anthropicReq AnthropicRequest) ([]parser.SSEEvent, error) {
+	anthropicReq, err := s.applyRequestHook(ctx, anthropicReq)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkModelAccess(ctx, anthropicReq.Model); err != nil {
+		return nil, err
+	}
+	if s.config.SystemPrefix != "" {
+		anthropicReq.System = append([]AnthropicSystemMessage{{Type: "text", Text: s.config.SystemPrefix}}, anthropicReq.System...)
+	}
+	if language := s.responseLanguage(ctx); language != "" {
+		anthropicReq.System = append(anthropicReq.System, AnthropicSystemMessage{Type: "text", Text: languageDirective(language)})
+	}
+	if anthropicReq.ResponseFormat != nil {
+		// 复制 system，不修改调用方的请求
+		anthropicReq.System = append(append([]AnthropicSystemMessage(nil), anthropicReq.System...), structuredOutputSystem(anthropicReq.ResponseFormat))
+	}
+	if reqErr := s.fitContextWindow(&anthropicReq); reqErr != nil {
+		return nil, reqErr.policyError(CodeInvalidRequest)
+	}
+	if err := s.tenants.admit(ctx, anthropicReq); err != nil {
+		return nil, err
+	}
+	var events []parser.SSEEvent
+	if anthropicReq.ResponseFormat != nil {
+		events, err = s.generateStructured(ctx, anthropicReq)
+	} else {
+		events, err = s.generateContinued(ctx, anthropicReq)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.tenants.charge(ctx, anthropicReq, events)
+	return s.applyResponseHook(ctx, anthropicReq, events)
+}
+
+// generateOnce 调用一次 Backend (租户绑定了自己的上游时使用租户的后端) 并记录解析出的事件，开启去重时合并相同的进行中请求
+func (s *Server) generateOnce(ctx context.Context, anthropicReq AnthropicRequest) ([]parser.SSEEvent, error) {
+	var events []parser.SSEEvent
+	var err error
+	backend := s.backend
+	if tenantBackend := s.tenants.backend(ctx); tenantBackend != nil {
+		backend = tenantBackend
+	}
+	finish := s.watchUpstream(ctx, anthropicReq)
+	if s.dedup == nil {
+		events, err = backend.Generate(ctx, anthropicReq)
+	} else {
+		// 不同租户的请求可能发往不同的上游账号，不互相合并
+		key := dedupKey(anthropicReq)
+		if identity := identityFrom(ctx); identity != nil && identity.Tenant != "" {
+			key = identity.Tenant + "/" + key
+		}
+		events, err = s.dedup.do(ctx, key, func(ctx context.Context) ([]parser.SSEEvent, error) {
+			return backend.Generate(ctx, anthropicReq)
+		})
+	}
+	finish(err)
+	s.recordEvents(ctx, anthropicReq, events, err)
+	return events, err
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bestk/kiro2cc/parser"
+)
+
+// gatedBackend 在 release 关闭前阻塞，用于构造并发进行中的请求
+type gatedBackend struct {
+	calls   atomic.Int32
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *gatedBackend) Generate(ctx context.Context, anthropicReq AnthropicRequest) ([]parser.SSEEvent, error) {
+	if b.calls.Add(1) == 1 {
+		close(b.started)
+	}
+	select {
+	case <-b.release:
+		return textEvents("shared answer"), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestDedupSharesInflightRequest(t *testing.T) {
+	backend := &gatedBackend{started: make(chan struct{}), release: make(chan struct{})}
+	srv := newTestServer(t, Config{Backend: backend, DedupWindow: time.Minute})
+
+	// 第一个客户端在上游返回前断开，不应取消仍有其他客户端等待的上游调用
+	firstCtx, cancelFirst := context.WithCancel(context.Background())
+	recs := make([]*httptest.ResponseRecorder, 3)
+	var wg sync.WaitGroup
+	for i := range recs {
+		recs[i] = httptest.NewRecorder()
+		ctx := context.Background()
+		if i == 0 {
+			ctx = firstCtx
+		}
+		stream := "false"
+		if i == 2 {
+			stream = "true"
+		}
+		body := strings.NewReader(`{"model":"claude-sonnet-4-20250514","max_tokens":10,"stream":` + stream + `,"messages":[{"role":"user","content":"hi"}]}`)
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", body).WithContext(ctx)
+		wg.Add(1)
+		go func(rec *httptest.ResponseRecorder) {
+			defer wg.Done()
+			srv.Handler().ServeHTTP(rec, req)
+		}(recs[i])
+		if i == 0 {
+			<-backend.started
+		}
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	cancelFirst()
+	time.Sleep(50 * time.Millisecond)
+	close(backend.release)
+	wg.Wait()
+
+	if n := backend.calls.Load(); n != 1 {
+		t.Fatalf("backend called %d times, want 1", n)
+	}
+	for i, rec := range recs[1:] {
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "shared answer") {
+			t.Errorf("client %d: %d %s", i+1, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestDedupDisabledByDefault(t *testing.T) {
+	if srv := newTestServer(t, Config{}); srv.dedup != nil {
+		t.Fatal("dedup should be opt-in")
+	}
+}
+
+func TestDedupRetryAfterAllWaitersLeft(t *testing.T) {
+	g := newDedupGroup(time.Minute)
+	release := make(chan struct{})
+	defer close(release)
+	var calls atomic.Int32
+	fn := func(ctx context.Context) ([]parser.SSEEvent, error) {
+		// 第一次调用在取消后仍迟迟不返回，模拟上游还没有响应取消
+		if calls.Add(1) == 1 {
+			<-release
+			return nil, ctx.Err()
+		}
+		return textEvents("retry"), nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	if _, err := g.do(ctx, "key", fn); err != context.Canceled {
+		t.Fatalf("first call err = %v", err)
+	}
+	events, err := g.do(context.Background(), "key", fn)
+	if err != nil || len(events) == 0 || calls.Load() != 2 {
+		t.Errorf("retry = %v, %v after %d calls, want a new upstream call", events, err, calls.Load())
+	}
+}
+
+func TestDedupWaitersShareDiagnosticsNotDeadline(t *testing.T) {
+	g := newDedupGroup(time.Minute)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	fn := func(ctx context.Context) ([]parser.SSEEvent, error) {
+		close(started)
+		<-release
+		diag := diagnosticsFrom(ctx)
+		diag.Status, diag.Origin = http.StatusOK, "AI_EDITOR"
+		return textEvents("shared"), ctx.Err()
+	}
+
+	// 第一个客户端的期限很短，加入的客户端不应随它一起超时
+	firstCtx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	firstErr := make(chan error, 1)
+	go func() {
+		_, err := g.do(firstCtx, "key", fn)
+		firstErr <- err
+	}()
+	<-started
+
+	joinCtx, joinDiag := withDiagnostics(context.Background())
+	joinDiag.Continuations = 1
+	joined := make(chan error, 1)
+	go func() {
+		_, err := g.do(joinCtx, "key", fn)
+		joined <- err
+	}()
+	for waiting := 0; waiting < 2; time.Sleep(time.Millisecond) {
+		g.mu.Lock()
+		waiting = g.calls["key"].waiters
+		g.mu.Unlock()
+	}
+	if err := <-firstErr; err != context.DeadlineExceeded {
+		t.Fatalf("first caller err = %v, want its own deadline", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	if err := <-joined; err != nil {
+		t.Fatalf("joined caller err = %v", err)
+	}
+	if joinDiag.Status != http.StatusOK || joinDiag.Origin != "AI_EDITOR" || joinDiag.Continuations != 1 {
+		t.Errorf("joined caller diagnostics = %+v", joinDiag)
+	}
+}
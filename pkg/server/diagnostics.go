@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// upstreamDiagnostics 记录一次上游调用的诊断信息，由 Backend 填充
+type upstreamDiagnostics struct {
+	Status  int
+	Latency time.Duration
+	Retries int
+	// Continuations 是上游提前结束后自动续写的次数
+	Continuations int
+	// Origin 和 ChatTriggerType 是实际发送给上游的取值
+	Origin          string
+	ChatTriggerType string
+	// History 是发送给上游的历史记录规模
+	History historyStats
+	// Raw 是上游响应的原始字节，只在开启 CaptureUpstream 时记录
+	Raw []byte
+	// Truncated 不为空时表示上游连接在响应中途断开，Backend 返回的只是已收到的部分
+	Truncated error
+}
+
+type diagnosticsKey struct{}
+
+// withDiagnostics 在 ctx 中附带一个待 Backend 填充的诊断记录
+func withDiagnostics(ctx context.Context) (context.Context, *upstreamDiagnostics) {
+	diag := &upstreamDiagnostics{}
+	return context.WithValue(ctx, diagnosticsKey{}, diag), diag
+}
+
+// diagnosticsFrom 返回 ctx 中的诊断记录，不存在时返回一个丢弃用的记录
+func diagnosticsFrom(ctx context.Context) *upstreamDiagnostics {
+	if diag, ok := ctx.Value(diagnosticsKey{}).(*upstreamDiagnostics); ok {
+		return diag
+	}
+	return &upstreamDiagnostics{}
+}
+
+// setDebugHeaders 在调试模式下通过响应头暴露上游诊断信息
+func (s *Server) setDebugHeaders(h http.Header, diag *upstreamDiagnostics) {
+	if !s.config.Debug {
+		return
+	}
+	h.Set("x-kiro2cc-upstream-status", strconv.Itoa(diag.Status))
+	h.Set("x-kiro2cc-upstream-latency-ms", strconv.FormatInt(diag.Latency.Milliseconds(), 10))
+	h.Set("x-kiro2cc-retries", strconv.Itoa(diag.Retries))
+	h.Set("x-kiro2cc-continuations", strconv.Itoa(diag.Continuations))
+	if diag.Origin != "" {
+		h.Set("x-kiro2cc-origin", diag.Origin)
+	}
+	if diag.ChatTriggerType != "" {
+		h.Set("x-kiro2cc-chat-trigger-type", diag.ChatTriggerType)
+	}
+	h.Set("x-kiro2cc-history-turns", strconv.Itoa(diag.History.Turns))
+	h.Set("x-kiro2cc-history-bytes", strconv.Itoa(diag.History.Bytes))
+	h.Set("x-kiro2cc-history-tokens", strconv.Itoa(diag.History.EstimatedTokens))
+}
+
+// setTruncatedHeader 在响应只包含上游部分输出时设置 x-kiro2cc-truncated，与调试模式无关
+func setTruncatedHeader(h http.Header, diag *upstreamDiagnostics) {
+	if diag.Truncated != nil {
+		h.Set("x-kiro2cc-truncated", "true")
+	}
+}
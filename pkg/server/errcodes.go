@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ErrorCode 是 kiro2cc 自己的错误码，出现在错误响应的 error.code 字段和日志中，
+// 用户可以按错误码而不是中文日志搜索文档和 issue。已发布的错误码不应改名
+type ErrorCode string
+
+const (
+	// 客户端请求本身的问题
+	CodeInvalidRequest   ErrorCode = "KIRO2CC-INVALID-REQUEST"
+	CodeAuthFailed       ErrorCode = "KIRO2CC-AUTH-FAILED"
+	CodePermissionDenied ErrorCode = "KIRO2CC-PERMISSION-DENIED"
+	CodeNotFound         ErrorCode = "KIRO2CC-NOT-FOUND"
+	CodeRequestCancelled ErrorCode = "KIRO2CC-REQUEST-CANCELLED"
+	CodeRateLimited      ErrorCode = "KIRO2CC-RATE-LIMITED"
+	CodeModelNotAllowed  ErrorCode = "KIRO2CC-MODEL-NOT-ALLOWED"
+	CodeBudgetExceeded   ErrorCode = "KIRO2CC-BUDGET-EXCEEDED"
+
+	// token 和账号配置的问题
+	CodeTokenUnavailable   ErrorCode = "KIRO2CC-TOKEN-UNAVAILABLE"
+	CodeTokenInvalid       ErrorCode = "KIRO2CC-TOKEN-INVALID"
+	CodeTokenExpired       ErrorCode = "KIRO2CC-TOKEN-EXPIRED"
+	CodeTokenRefreshFailed ErrorCode = "KIRO2CC-TOKEN-REFRESH-FAILED"
+	CodeProfileMismatch    ErrorCode = "KIRO2CC-PROFILE-MISMATCH"
+
+	// 上游 CodeWhisperer 的问题
+	CodeUpstreamBadRequest  ErrorCode = "KIRO2CC-UPSTREAM-BAD-REQUEST"
+	CodeUpstreamThrottled   ErrorCode = "KIRO2CC-UPSTREAM-THROTTLED"
+	CodeUpstreamError       ErrorCode = "KIRO2CC-UPSTREAM-ERROR"
+	CodeUpstreamUnavailable ErrorCode = "KIRO2CC-UPSTREAM-UNAVAILABLE"
+	CodeUpstreamUnreachable ErrorCode = "KIRO2CC-UPSTREAM-UNREACHABLE"
+	CodeUpstreamTimeout     ErrorCode = "KIRO2CC-UPSTREAM-TIMEOUT"
+	CodeUpstreamTruncated   ErrorCode = "KIRO2CC-UPSTREAM-TRUNCATED"
+
+	// 代理自身功能的问题
+	CodeStructuredOutput ErrorCode = "KIRO2CC-STRUCTURED-OUTPUT-INVALID"
+	CodeHookRejected     ErrorCode = "KIRO2CC-HOOK-REJECTED"
+	CodeHookFailed       ErrorCode = "KIRO2CC-HOOK-FAILED"
+	CodeOverloaded       ErrorCode = "KIRO2CC-OVERLOADED"
+	CodeMaintenance      ErrorCode = "KIRO2CC-MAINTENANCE"
+	CodeInternal         ErrorCode = "KIRO2CC-INTERNAL"
+)
+
+// errTokenUnavailable 表示读取 token 文件失败，Backend 返回的错误会包装它
+var errTokenUnavailable = errors.New("无法读取 token")
+
+// defaultErrorCode 为没有更具体分类的错误按 Anthropic 错误类型给出错误码
+func defaultErrorCode(errType string) ErrorCode {
+	switch errType {
+	case "invalid_request_error":
+		return CodeInvalidRequest
+	case "authentication_error":
+		return CodeAuthFailed
+	case "permission_error":
+		return CodePermissionDenied
+	case "not_found_error":
+		return CodeNotFound
+	case "rate_limit_error":
+		return CodeUpstreamThrottled
+	case "overloaded_error":
+		return CodeOverloaded
+	case "timeout_error":
+		return CodeUpstreamTimeout
+	case "structured_output_error":
+		return CodeStructuredOutput
+	}
+	return CodeInternal
+}
+
+// generateErrorCode 为 generate 返回的错误分类
+func generateErrorCode(ctx context.Context, err error) ErrorCode {
+	if requestCancelled(ctx) {
+		return CodeRequestCancelled
+	}
+	if requestTimedOut(ctx) {
+		return CodeUpstreamTimeout
+	}
+	var outErr *StructuredOutputError
+	if errors.As(err, &outErr) {
+		return CodeStructuredOutput
+	}
+	var hookErr *HookError
+	if errors.As(err, &hookErr) {
+		if hookErr.Rejected {
+			return CodeHookRejected
+		}
+		return CodeHookFailed
+	}
+	var policyErr *PolicyError
+	if errors.As(err, &policyErr) {
+		return policyErr.Code
+	}
+	if errors.Is(err, errTokenUnavailable) {
+		return CodeTokenUnavailable
+	}
+	var upErr *UpstreamError
+	if errors.As(err, &upErr) {
+		return upErr.code()
+	}
+	return CodeUpstreamUnreachable
+}
+
+// requestTimedOut 判断 ctx 是否因超过代理给请求的期限而结束
+func requestTimedOut(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), context.DeadlineExceeded)
+}
+
+// timeoutMessage 描述超时的请求，给出已等待的时间，客户端据此判断是否重试
+func timeoutMessage(started time.Time, limit time.Duration) string {
+	return fmt.Sprintf("CodeWhisperer 请求超时: 已等待 %s，超过 %s 的期限，可以稍后重试", time.Since(started).Round(100*time.Millisecond), limit)
+}
+
+// code 按上游状态码给出错误码
+func (e *UpstreamError) code() ErrorCode {
+	switch {
+	case e.ProfileMismatch():
+		return CodeProfileMismatch
+	case e.StatusCode == http.StatusBadRequest:
+		return CodeUpstreamBadRequest
+	case e.StatusCode == http.StatusUnauthorized:
+		return CodeTokenInvalid
+	case e.StatusCode == http.StatusForbidden:
+		return CodeTokenExpired
+	case e.StatusCode == http.StatusTooManyRequests:
+		return CodeUpstreamThrottled
+	case e.StatusCode == http.StatusBadGateway, e.StatusCode == http.StatusServiceUnavailable, e.StatusCode == http.StatusGatewayTimeout:
+		return CodeUpstreamUnavailable
+	}
+	return CodeUpstreamError
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bestk/kiro2cc/parser"
+)
+
+func TestGenerateErrorCode(t *testing.T) {
+	cancelled, cancel := context.WithCancelCause(context.Background())
+	cancel(errRequestCancelled)
+	expired, cancelExpired := context.WithTimeout(context.Background(), -time.Second)
+	defer cancelExpired()
+
+	tests := []struct {
+		ctx  context.Context
+		err  error
+		want ErrorCode
+	}{
+		{context.Background(), &UpstreamError{StatusCode: 429}, CodeUpstreamThrottled},
+		{context.Background(), &UpstreamError{StatusCode: 403}, CodeTokenExpired},
+		{context.Background(), &UpstreamError{StatusCode: 403, Type: "AccessDeniedException"}, CodeProfileMismatch},
+		{context.Background(), &UpstreamError{StatusCode: 503}, CodeUpstreamUnavailable},
+		{context.Background(), &UpstreamError{StatusCode: 418}, CodeUpstreamError},
+		{context.Background(), fmt.Errorf("%w: no such file", errTokenUnavailable), CodeTokenUnavailable},
+		{context.Background(), &HookError{Rejected: true}, CodeHookRejected},
+		{context.Background(), &StructuredOutputError{}, CodeStructuredOutput},
+		{context.Background(), errors.New("dial tcp: connection refused"), CodeUpstreamUnreachable},
+		{cancelled, context.Canceled, CodeRequestCancelled},
+		{expired, fmt.Errorf("Post: %w", context.DeadlineExceeded), CodeUpstreamTimeout},
+	}
+	for _, tt := range tests {
+		if got := generateErrorCode(tt.ctx, tt.err); got != tt.want {
+			t.Errorf("generateErrorCode(%v) = %s, want %s", tt.err, got, tt.want)
+		}
+	}
+}
+
+// slowBackend 一直等到请求的 ctx 结束
+type slowBackend struct{}
+
+func (slowBackend) Generate(ctx context.Context, anthropicReq AnthropicRequest) ([]parser.SSEEvent, error) {
+	<-ctx.Done()
+	return nil, fmt.Errorf("Post: %w", ctx.Err())
+}
+
+func TestRequestTimeoutReturns504(t *testing.T) {
+	defer func(stream, nonStream time.Duration) {
+		streamRequestTimeout, nonStreamRequestTimeout = stream, nonStream
+	}(streamRequestTimeout, nonStreamRequestTimeout)
+	streamRequestTimeout, nonStreamRequestTimeout = 50*time.Millisecond, 50*time.Millisecond
+	srv := newTestServer(t, Config{Backend: slowBackend{}})
+
+	rec := postMessages(srv, hookTestBody)
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("status = %d, want 504: %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Error struct {
+			Type    string    `json:"type"`
+			Code    ErrorCode `json:"code"`
+			Message string    `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Error.Type != "timeout_error" || resp.Error.Code != CodeUpstreamTimeout || !strings.Contains(resp.Error.Message, "已等待") {
+		t.Errorf("error = %+v", resp.Error)
+	}
+
+	rec = postMessages(srv, strings.Replace(hookTestBody, `"max_tokens"`, `"stream":true,"max_tokens"`, 1))
+	if body := rec.Body.String(); !strings.Contains(body, `"type":"timeout_error"`) || !strings.Contains(body, string(CodeUpstreamTimeout)) {
+		t.Errorf("stream body = %s", body)
+	}
+	assertConformantStream(t, rec.Body.String())
+}
+
+func TestStreamReplayOutlivesUpstreamTimeout(t *testing.T) {
+	defer func(timeout time.Duration, delay func() time.Duration) {
+		streamRequestTimeout, streamEventDelay = timeout, delay
+	}(streamRequestTimeout, streamEventDelay)
+	// 上游立即返回，回放 5 个事件共需 100ms，超过 50ms 的上游期限
+	streamRequestTimeout = 50 * time.Millisecond
+	streamEventDelay = func() time.Duration { return 20 * time.Millisecond }
+	var events []parser.SSEEvent
+	for i := 0; i < 5; i++ {
+		events = append(events, parser.TextDeltaEvent("x"))
+	}
+	srv := newTestServer(t, Config{Backend: &fakeBackend{events: events}})
+
+	rec := postMessages(srv, strings.Replace(hookTestBody, `"max_tokens"`, `"stream":true,"max_tokens"`, 1))
+	body := rec.Body.String()
+	if strings.Contains(body, "timeout_error") || !strings.Contains(body, "message_stop") {
+		t.Errorf("stream body = %s", body)
+	}
+	assertConformantStream(t, body)
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bestk/kiro2cc/parser"
+)
+
+// DefaultEventHistory 是 EventHistory 为 0 时保留上游事件的请求数
+const DefaultEventHistory = 50
+
+// eventRecord 是一个请求最近一次上游调用解析出的事件，结构化输出重试时只保留最后一次
+type eventRecord struct {
+	ID             string            `json:"id"`
+	Model          string            `json:"model"`
+	Stream         bool              `json:"stream"`
+	Recorded       time.Time         `json:"recorded"`
+	UpstreamStatus int               `json:"upstream_status,omitempty"`
+	Error          string            `json:"error,omitempty"`
+	Events         []parser.SSEEvent `json:"events"`
+	// Raw 是上游响应的原始字节，开启 CaptureUpstream 时才有，JSON 中以 base64 表示
+	Raw []byte `json:"raw,omitempty"`
+}
+
+// eventStore 按请求 ID 保留最近若干个请求的上游事件，供 /admin/requests/{id}/events 远程排查
+type eventStore struct {
+	mu      sync.Mutex
+	limit   int
+	records []*eventRecord
+}
+
+func newEventStore(limit int) *eventStore {
+	return &eventStore{limit: limit}
+}
+
+// record 保存一次上游调用的结果，同一个 ID 的旧记录被替换
+func (es *eventStore) record(rec *eventRecord) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	for i, existing := range es.records {
+		if existing.ID == rec.ID {
+			es.records = append(es.records[:i], es.records[i+1:]...)
+			break
+		}
+	}
+	es.records = append(es.records, rec)
+	if len(es.records) > es.limit {
+		es.records = es.records[len(es.records)-es.limit:]
+	}
+}
+
+func (es *eventStore) lookup(id string) *eventRecord {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	for _, rec := range es.records {
+		if rec.ID == id {
+			return rec
+		}
+	}
+	return nil
+}
+
+type requestIDKey struct{}
+
+// withRequestID 在 ctx 中附带请求 ID，由 inflightRegistry.track 设置
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// requestIDFrom 返回 ctx 中的请求 ID，不存在时返回空字符串
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// recordEvents 保存请求的上游事件，未开启或 ctx 中没有请求 ID 时忽略
+func (s *Server) recordEvents(ctx context.Context, anthropicReq AnthropicRequest, events []parser.SSEEvent, err error) {
+	id := requestIDFrom(ctx)
+	if s.events == nil || id == "" {
+		return
+	}
+	diag := diagnosticsFrom(ctx)
+	rec := &eventRecord{
+		ID:             id,
+		Model:          anthropicReq.Model,
+		Stream:         anthropicReq.Stream,
+		Recorded:       time.Now().UTC(),
+		UpstreamStatus: diag.Status,
+		Events:         events,
+		Raw:            diag.Raw,
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	s.events.record(rec)
+}
+
+// handleRequestEvents 返回最近请求的上游事件；带 ?raw 参数时直接返回上游响应的原始字节，
+// 可以保存为文件后交给 parser 复现解析问题
+func (s *Server) handleRequestEvents(w http.ResponseWriter, r *http.Request) {
+	if s.events == nil {
+		sendJSONError(w, http.StatusNotFound, "not_found_error", "未开启上游事件记录")
+		return
+	}
+	rec := s.events.lookup(r.PathValue("id"))
+	if rec == nil {
+		sendJSONError(w, http.StatusNotFound, "not_found_error", "请求不存在、仍在进行或已被更新的请求替换")
+		return
+	}
+	if !r.URL.Query().Has("raw") {
+		writeJSON(w, http.StatusOK, rec)
+		return
+	}
+	if rec.Raw == nil {
+		sendJSONError(w, http.StatusNotFound, "not_found_error", "没有该请求的原始响应，需要开启 --capture-upstream")
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+rec.ID+`.raw"`)
+	w.Write(rec.Raw)
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// getRequestEvents 以本机地址请求 /admin/requests/{id}/events
+func getRequestEvents(srv *Server, id, query string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/admin/requests/"+id+"/events"+query, nil)
+	req.RemoteAddr = "127.0.0.1:12345"
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRequestEventsCapture(t *testing.T) {
+	raw := "data: {\"content\":\"hello\"}\n\n"
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, raw)
+	}))
+	defer upstream.Close()
+
+	srv := newTestServer(t, Config{CaptureUpstream: true})
+	srv.backend.(*codeWhispererBackend).url = upstream.URL
+
+	rec := postMessages(srv, hookTestBody)
+	var resp struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.ID == "" {
+		t.Fatalf("response: %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = getRequestEvents(srv, resp.ID, "")
+	var got eventRecord
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("events: %d %s", rec.Code, rec.Body.String())
+	}
+	if got.ID != resp.ID || got.UpstreamStatus != http.StatusOK || len(got.Events) == 0 || string(got.Raw) != raw {
+		t.Errorf("record = %+v", got)
+	}
+
+	rec = getRequestEvents(srv, resp.ID, "?raw")
+	if rec.Code != http.StatusOK || rec.Body.String() != raw || rec.Header().Get("Content-Type") != "application/octet-stream" {
+		t.Errorf("raw: %d %q", rec.Code, rec.Body.String())
+	}
+
+	if rec := getRequestEvents(srv, "msg_unknown", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown id: %d", rec.Code)
+	}
+
+	// 开启捕获不影响流式响应
+	defer func(delay func() time.Duration) { streamEventDelay = delay }(streamEventDelay)
+	streamEventDelay = func() time.Duration { return 0 }
+	rec = postMessages(srv, strings.Replace(hookTestBody, `"max_tokens"`, `"stream":true,"max_tokens"`, 1))
+	assertConformantStream(t, rec.Body.String())
+}
+
+func TestRequestEventsWithoutCapture(t *testing.T) {
+	srv := newTestServer(t, Config{Backend: &fakeBackend{events: textEvents("ok")}})
+	rec := postMessages(srv, hookTestBody)
+	var resp struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+
+	rec = getRequestEvents(srv, resp.ID, "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"events"`) || strings.Contains(rec.Body.String(), `"raw"`) {
+		t.Errorf("events: %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := getRequestEvents(srv, resp.ID, "?raw"); rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), "capture-upstream") {
+		t.Errorf("raw: %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestEventStoreLimit(t *testing.T) {
+	es := newEventStore(2)
+	for _, id := range []string{"a", "b", "a", "c"} {
+		es.record(&eventRecord{ID: id})
+	}
+	if es.lookup("b") != nil || es.lookup("a") == nil || es.lookup("c") == nil {
+		t.Errorf("records = %+v", es.records)
+	}
+}
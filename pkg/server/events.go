@@ -0,0 +1,174 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// messageUsage 表示 message_start 中的用量
+type messageUsage struct {
+	InputTokens int `json:"input_tokens"`
+	// 上游没有提示缓存，缓存相关的用量始终为 0，保留字段是为了让按 Anthropic 格式统计用量的 SDK 正常工作
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+}
+
+// messageStartBody 表示 message_start 中的消息
+type messageStartBody struct {
+	ID           string       `json:"id"`
+	Type         string       `json:"type"`
+	Role         string       `json:"role"`
+	Content      []any        `json:"content"`
+	Model        string       `json:"model"`
+	StopReason   *string      `json:"stop_reason"`
+	StopSequence *string      `json:"stop_sequence"`
+	Usage        messageUsage `json:"usage"`
+}
+
+// messageStartEvent 表示 message_start 事件
+type messageStartEvent struct {
+	Type    string           `json:"type"`
+	Message messageStartBody `json:"message"`
+}
+
+// typeOnlyEvent 表示只有 type 字段的事件，例如 ping 和 message_stop
+type typeOnlyEvent struct {
+	Type string `json:"type"`
+}
+
+// eventWriter 表示流式事件的输出通道（SSE、WebSocket 等）
+type eventWriter interface {
+	WriteEvent(eventType string, data any) error
+}
+
+// headerWriter 由能在首个事件之前设置响应头的 eventWriter 实现
+type headerWriter interface {
+	Header() http.Header
+}
+
+// NDJSONContentType 是以换行分隔的 JSON 输出流式响应时的 Content-Type
+const NDJSONContentType = "application/x-ndjson"
+
+// isStreamingContentType 判断响应是否是边生成边发送的流式格式 (SSE 或 NDJSON)
+func isStreamingContentType(contentType string) bool {
+	return strings.HasPrefix(contentType, "text/event-stream") || strings.HasPrefix(contentType, NDJSONContentType)
+}
+
+// sseWriter 以 SSE 格式输出事件，复用同一个缓冲区编码每个事件
+type sseWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	buf     bytes.Buffer
+	encoder *json.Encoder
+	// writeTimeout 大于 0 时每个事件必须在该期限内写出，避免停止读取的客户端一直占用连接
+	writeTimeout time.Duration
+	// ndjson 为 true 时每个事件输出为一行 JSON，不带 event: 和 data: 前缀，事件类型见对象的 type 字段
+	ndjson bool
+	// logEvents 为 true 时同时把事件打印到日志，事件中含有模型输出，只在开启 LogBodies 时使用
+	logEvents bool
+}
+
+// Header 返回尚未写出的响应头
+func (sw *sseWriter) Header() http.Header {
+	return sw.w.Header()
+}
+
+// WriteEvent 发送 SSE 事件，或 NDJSON 的一行
+func (sw *sseWriter) WriteEvent(eventType string, data any) error {
+	if sw.encoder == nil {
+		sw.encoder = json.NewEncoder(&sw.buf)
+	}
+	sw.buf.Reset()
+	if !sw.ndjson {
+		sw.buf.WriteString("event: ")
+		sw.buf.WriteString(eventType)
+		sw.buf.WriteString("\ndata: ")
+	}
+	// Encode 会在 JSON 之后追加换行
+	if err := sw.encoder.Encode(data); err != nil {
+		return err
+	}
+	if !sw.ndjson {
+		sw.buf.WriteByte('\n')
+	}
+
+	if sw.logEvents {
+		os.Stdout.Write(sw.buf.Bytes())
+	}
+
+	setWriteDeadline(sw.w, sw.writeTimeout)
+	if _, err := sw.w.Write(sw.buf.Bytes()); err != nil {
+		return err
+	}
+	sw.flusher.Flush()
+	return nil
+}
+
+// sendErrorEvent 发送错误事件
+func sendErrorEvent(ew eventWriter, code ErrorCode, message string, err error) {
+	writeErrorEventWithCode(ew, code, "api_error", fmt.Sprintf("%s: %v", message, err))
+}
+
+// writeErrorEvent 发送指定类型的错误事件，错误码按类型推断
+func writeErrorEvent(ew eventWriter, errorType, message string) {
+	writeErrorEventWithCode(ew, defaultErrorCode(errorType), errorType, message)
+}
+
+// writeErrorEventWithCode 发送带错误码的错误事件
+func writeErrorEventWithCode(ew eventWriter, code ErrorCode, errorType, message string) {
+	fmt.Printf("错误 [%s] %s: %s\n", code, errorType, message)
+	errorResp := map[string]any{
+		"type": "error",
+		"error": map[string]any{
+			"type":    errorType,
+			"code":    code,
+			"message": message,
+		},
+	}
+
+	ew.WriteEvent("error", errorResp)
+}
+
+// sendJSONError 发送JSON格式的错误响应，错误码按类型推断
+func sendJSONError(w http.ResponseWriter, statusCode int, errorType, message string) {
+	sendJSONErrorWithCode(w, statusCode, defaultErrorCode(errorType), errorType, message)
+}
+
+// sendJSONErrorWithCode 发送带错误码的JSON格式错误响应
+func sendJSONErrorWithCode(w http.ResponseWriter, statusCode int, code ErrorCode, errorType, message string) {
+	fmt.Printf("错误 [%s] %s: %s\n", code, errorType, message)
+
+	errorResp := AnthropicErrorResponse{
+		Type: "error",
+	}
+	errorResp.Error.Type = errorType
+	errorResp.Error.Code = code
+	errorResp.Error.Message = message
+
+	writeJSON(w, statusCode, errorResp)
+}
+
+// writeJSON 先完整序列化再写出 JSON 响应并设置 Content-Length。直接用 Encoder 写出时，
+// 超过 net/http 内部缓冲的大响应会变成没有长度的 chunked 响应，部分代理和客户端会截断或无法处理
+func writeJSON(w http.ResponseWriter, statusCode int, v any) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if err := json.NewEncoder(buf).Encode(v); err != nil {
+		sendJSONErrorWithCode(w, http.StatusInternalServerError, CodeInternal, "api_error", fmt.Sprintf("序列化响应失败: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(statusCode)
+	if n, err := w.Write(buf.Bytes()); err != nil {
+		fmt.Printf("警告: 响应未完整写出 (%d/%d 字节): %v\n", n, buf.Len(), err)
+	}
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// discardResponseWriter 丢弃写入的内容，用于基准测试
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (d *discardResponseWriter) Header() http.Header         { return d.header }
+func (d *discardResponseWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (d *discardResponseWriter) WriteHeader(int)             {}
+func (d *discardResponseWriter) Flush()                      {}
+
+// BenchmarkStreamResponse50k 测量 5 万个 token 的流式响应在转换和编码上的开销
+func BenchmarkStreamResponse50k(b *testing.B) {
+	delay := streamEventDelay
+	streamEventDelay = func() time.Duration { return 0 }
+	defer func() { streamEventDelay = delay }()
+
+	// 事件日志输出到 stdout，基准测试期间丢弃
+	stdout := os.Stdout
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	os.Stdout = devNull
+	log.SetOutput(io.Discard)
+	defer func() {
+		os.Stdout = stdout
+		log.SetOutput(os.Stderr)
+		devNull.Close()
+	}()
+
+	srv, err := New(Config{TokenPath: "unused", Backend: &fakeBackend{events: textEvents(strings.Split(strings.Repeat("tok ", 50000), " ")[:50000]...)}})
+	if err != nil {
+		b.Fatal(err)
+	}
+	req := AnthropicRequest{Model: "claude-sonnet-4-20250514", MaxTokens: 10, Stream: true, Messages: []AnthropicRequestMessage{{Role: "user", Content: "hi"}}}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w := &discardResponseWriter{header: http.Header{}}
+		srv.streamResponse(context.Background(), &sseWriter{w: w, flusher: w}, req)
+	}
+}
+
+func TestStreamNDJSON(t *testing.T) {
+	delay := streamEventDelay
+	streamEventDelay = func() time.Duration { return 0 }
+	defer func() { streamEventDelay = delay }()
+	srv := newTestServer(t, Config{Backend: &fakeBackend{events: textEvents("Hello ", "world!")}})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model":"claude-sonnet-4-20250514","max_tokens":10,"stream":true,"messages":[{"role":"user","content":"hi"}]}`))
+	req.Header.Set("Accept", "application/x-ndjson")
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+
+	if ct := rec.Header().Get("Content-Type"); ct != NDJSONContentType {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	var frames []sseFrame
+	for _, line := range strings.Split(strings.TrimSuffix(rec.Body.String(), "\n"), "\n") {
+		var data map[string]any
+		if err := json.Unmarshal([]byte(line), &data); err != nil {
+			t.Fatalf("line %q: %v", line, err)
+		}
+		event, _ := data["type"].(string)
+		frames = append(frames, sseFrame{Event: event, Data: data})
+	}
+	if len(frames) < 3 {
+		t.Errorf("got %d events", len(frames))
+	}
+	assertConformantFrames(t, frames)
+
+	// 同时接受 SSE 时仍按 SSE 返回
+	req = httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(`{"model":"claude-sonnet-4-20250514","max_tokens":10,"stream":true,"messages":[{"role":"user","content":"hi"}]}`))
+	req.Header.Set("Accept", "application/x-ndjson, text/event-stream")
+	rec = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	if ct := rec.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q, want SSE", ct)
+	}
+	assertConformantStream(t, rec.Body.String())
+}
+
+func TestBufferStreams(t *testing.T) {
+	srv := newTestServer(t, Config{Backend: &fakeBackend{events: textEvents("Hello ", "world!")}, BufferStreams: true})
+	rec := postMessages(srv, `{"model":"claude-sonnet-4-20250514","max_tokens":10,"stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	if rec.Code != http.StatusOK || rec.Header().Get(StreamBufferedHeader) == "" {
+		t.Fatalf("status %d, headers %v", rec.Code, rec.Header())
+	}
+	var resp struct {
+		Type    string `json:"type"`
+		Content []struct {
+			Text string `json:"text"`
+		} `json:"content"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Type != "message" || len(resp.Content) == 0 || resp.Content[0].Text != "Hello world!" {
+		t.Errorf("response %s: %v", rec.Body.String(), err)
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// anthropicRequestFields 是 Anthropic 请求已有的字段，不能用作 ExtraBodyField
+var anthropicRequestFields = map[string]bool{
+	"model": true, "max_tokens": true, "messages": true, "system": true, "tools": true, "tool_choice": true,
+	"stream": true, "temperature": true, "top_p": true, "top_k": true, "stop_sequences": true,
+	"metadata": true, "thinking": true, "response_format": true,
+}
+
+// takeExtraBody 从请求 JSON 中取出 field 字段作为 extra_body 并删除该字段，字段不存在时返回 nil
+func takeExtraBody(req map[string]any, field string) (map[string]any, *requestError) {
+	value, ok := req[field]
+	if !ok {
+		return nil, nil
+	}
+	delete(req, field)
+	if value == nil {
+		return nil, nil
+	}
+	extra, ok := value.(map[string]any)
+	if !ok {
+		return nil, invalidRequest("%s must be an object", field)
+	}
+	return extra, nil
+}
+
+// mergeExtraBody 把 extra 合并到序列化后的 CodeWhisperer 请求中：两边都是对象时逐层合并，
+// 其他值直接覆盖，值为 null 时删除该字段。数组整体替换，不逐个元素合并
+func mergeExtraBody(data []byte, extra map[string]any) ([]byte, error) {
+	var body map[string]any
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, err
+	}
+	mergeJSONObject(body, extra)
+	merged, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("合并 extra_body 失败: %v", err)
+	}
+	return merged, nil
+}
+
+func mergeJSONObject(dst, src map[string]any) {
+	for key, value := range src {
+		if value == nil {
+			delete(dst, key)
+			continue
+		}
+		if srcObj, ok := value.(map[string]any); ok {
+			if dstObj, ok := dst[key].(map[string]any); ok {
+				mergeJSONObject(dstObj, srcObj)
+				continue
+			}
+		}
+		dst[key] = value
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMergeExtraBody(t *testing.T) {
+	data := []byte(`{"conversationState":{"chatTriggerType":"MANUAL","conversationId":"c1"},"profileArn":"arn"}`)
+	merged, err := mergeExtraBody(data, map[string]any{
+		"conversationState": map[string]any{"chatTriggerType": "INLINE_CHAT", "conversationId": nil, "agentTaskType": "vibe"},
+		"experimental":      []any{1, 2},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"conversationState":{"agentTaskType":"vibe","chatTriggerType":"INLINE_CHAT"},"experimental":[1,2],"profileArn":"arn"}`
+	if string(merged) != want {
+		t.Errorf("merged = %s\nwant     %s", merged, want)
+	}
+}
+
+func TestExtraBodyPassthrough(t *testing.T) {
+	var upstreamBodies []map[string]any
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		upstreamBodies = append(upstreamBodies, body)
+		io.WriteString(w, "data: {\"content\":\"ok\"}\n\n")
+	}))
+	defer upstream.Close()
+
+	body := `{"model":"claude-sonnet-4-20250514","max_tokens":10,"messages":[{"role":"user","content":"hi"}],
+		"extra_body":{"conversationState":{"agentTaskType":"vibe"},"experimentalFlag":true}}`
+	for _, enabled := range []bool{true, false} {
+		upstreamBodies = nil
+		config := Config{}
+		if enabled {
+			config.ExtraBodyField = "extra_body"
+		}
+		srv := newTestServer(t, config)
+		srv.backend.(*codeWhispererBackend).url = upstream.URL
+
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body)))
+		if rec.Code != http.StatusOK || len(upstreamBodies) != 1 {
+			t.Fatalf("enabled=%v: status = %d: %s", enabled, rec.Code, rec.Body.String())
+		}
+		state, _ := upstreamBodies[0]["conversationState"].(map[string]any)
+		_, hasFlag := upstreamBodies[0]["experimentalFlag"]
+		if enabled && (state["agentTaskType"] != "vibe" || state["chatTriggerType"] != ChatTriggerManual || !hasFlag) {
+			t.Errorf("extra_body not merged: %v", upstreamBodies[0])
+		}
+		if !enabled && (state["agentTaskType"] != nil || hasFlag) {
+			t.Errorf("extra_body merged while disabled: %v", upstreamBodies[0])
+		}
+	}
+}
+
+func TestExtraBodyMustBeObject(t *testing.T) {
+	srv := newTestServer(t, Config{ExtraBodyField: "x_kiro", Backend: &fakeBackend{events: textEvents("ok")}})
+	rec := postMessages(srv, `{"model":"claude-sonnet-4-20250514","max_tokens":10,"messages":[{"role":"user","content":"hi"}],"x_kiro":"oops"}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "x_kiro must be an object") {
+		t.Errorf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if err := (Config{ExtraBodyField: "metadata"}).Validate(); err == nil || !strings.Contains(err.Error(), "ExtraBodyField") {
+		t.Errorf("Validate = %v", err)
+	}
+}
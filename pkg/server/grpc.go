@@ -0,0 +1,168 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	kiro2ccv1 "github.com/bestk/kiro2cc/proto/kiro2cc/v1"
+)
+
+// grpcService 实现 kiro2ccv1.MessagesServiceServer，与 HTTP 入口共用 Backend
+type grpcService struct {
+	kiro2ccv1.UnimplementedMessagesServiceServer
+	server *Server
+}
+
+// RegisterGRPC 把 MessagesService 注册到调用方的 gRPC 服务器上
+func (s *Server) RegisterGRPC(registrar grpc.ServiceRegistrar) {
+	kiro2ccv1.RegisterMessagesServiceServer(registrar, &grpcService{server: s})
+}
+
+// Message 处理非流式请求
+func (g *grpcService) Message(ctx context.Context, req *kiro2ccv1.MessageRequest) (*kiro2ccv1.MessageResponse, error) {
+	ctx, err := g.server.grpcAuthenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := g.server.grpcCheckMaintenance(); err != nil {
+		return nil, err
+	}
+	anthropicReq, err := g.server.anthropicRequestFromProto(req)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = withBetas(withAPIKey(ctx, grpcAPIKey(ctx)), g.server.grpcBetas(ctx))
+	ctx, tracked := g.server.inflight.track(ctx, newMessageID(), anthropicReq)
+	defer g.server.inflight.untrack(tracked)
+	// 与 HTTP 非流式请求使用同一个期限，客户端没有设置 deadline 时也不会一直等待上游
+	ctx, cancel := context.WithTimeout(ctx, nonStreamRequestTimeout)
+	defer cancel()
+
+	events, err := g.server.generate(ctx, anthropicReq)
+	if err != nil {
+		return nil, g.server.grpcError(ctx, err)
+	}
+
+	payload, err := json.Marshal(buildMessageResponse(anthropicReq, events))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "序列化响应失败: %v", err)
+	}
+	var resp kiro2ccv1.MessageResponse
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(payload, &resp); err != nil {
+		return nil, status.Errorf(codes.Internal, "转换响应失败: %v", err)
+	}
+	return &resp, nil
+}
+
+// MessageStream 处理流式请求，事件顺序与 SSE 完全一致
+func (g *grpcService) MessageStream(req *kiro2ccv1.MessageRequest, stream kiro2ccv1.MessagesService_MessageStreamServer) error {
+	ctx, err := g.server.grpcAuthenticate(stream.Context())
+	if err != nil {
+		return err
+	}
+	if err := g.server.grpcCheckMaintenance(); err != nil {
+		return err
+	}
+	anthropicReq, err := g.server.anthropicRequestFromProto(req)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, streamRequestTimeout)
+	defer cancel()
+	g.server.streamResponse(withBetas(withAPIKey(ctx, grpcAPIKey(ctx)), g.server.grpcBetas(ctx)), &grpcStreamWriter{stream: stream, logEvents: g.server.config.LogBodies}, anthropicReq)
+	return nil
+}
+
+// anthropicRequestFromProto 把 gRPC 请求转换为 Anthropic 请求，并复用 HTTP 入口的校验
+func (s *Server) anthropicRequestFromProto(req *kiro2ccv1.MessageRequest) (AnthropicRequest, error) {
+	body, err := (protojson.MarshalOptions{UseProtoNames: true}).Marshal(req)
+	if err != nil {
+		return AnthropicRequest{}, status.Errorf(codes.InvalidArgument, "序列化请求失败: %v", err)
+	}
+
+	anthropicReq, reqErr := s.parseAnthropicRequest(body)
+	if reqErr != nil {
+		return AnthropicRequest{}, status.Error(codes.InvalidArgument, reqErr.Message)
+	}
+	return anthropicReq, nil
+}
+
+// grpcError 把后端错误映射为 gRPC 状态码，消息以 kiro2cc 错误码开头
+func (s *Server) grpcError(ctx context.Context, err error) error {
+	code := generateErrorCode(ctx, err)
+	if requestTimedOut(ctx) {
+		return grpcStatus(codes.DeadlineExceeded, code, "CodeWhisperer 请求超时")
+	}
+	var policyErr *PolicyError
+	if errors.As(err, &policyErr) {
+		return grpcStatus(policyErr.grpcCode(), code, policyErr.Message)
+	}
+	var upErr *UpstreamError
+	if !errors.As(err, &upErr) {
+		return grpcStatus(codes.Internal, code, err.Error())
+	}
+
+	switch upErr.StatusCode {
+	case http.StatusBadRequest:
+		return grpcStatus(codes.InvalidArgument, code, "请求参数错误: "+upErr.Body)
+	case http.StatusUnauthorized:
+		return grpcStatus(codes.Unauthenticated, code, "认证失败，请检查token")
+	case http.StatusForbidden:
+		if upErr.ProfileMismatch() {
+			return grpcStatus(codes.PermissionDenied, code, profileMismatchMessage(s.config.ProfileArn))
+		}
+		if refreshErr := s.refreshTokenSilently(); refreshErr == nil {
+			return grpcStatus(codes.PermissionDenied, code, "Token已刷新，请重试请求")
+		}
+		return grpcStatus(codes.PermissionDenied, CodeTokenRefreshFailed, "权限不足且Token刷新失败，请重新登录")
+	case http.StatusTooManyRequests:
+		return grpcStatus(codes.ResourceExhausted, code, "请求频率过高，请稍后重试")
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return grpcStatus(codes.Unavailable, code, "CodeWhisperer服务暂时不可用，请稍后重试")
+	default:
+		return grpcStatus(codes.Internal, code, upErr.Error())
+	}
+}
+
+// grpcStatus 构造以错误码开头的 gRPC 状态，例如 "[KIRO2CC-UPSTREAM-THROTTLED] 请求频率过高"
+func grpcStatus(c codes.Code, code ErrorCode, message string) error {
+	fmt.Printf("错误 [%s] %s: %s\n", code, c, message)
+	return status.Error(c, fmt.Sprintf("[%s] %s", code, message))
+}
+
+// grpcStreamWriter 把流式事件写入 gRPC 服务端流
+type grpcStreamWriter struct {
+	stream kiro2ccv1.MessagesService_MessageStreamServer
+	// logEvents 为 true 时同时把事件打印到日志，与 sseWriter 一样只在开启 LogBodies 时使用
+	logEvents bool
+}
+
+// WriteEvent 发送 gRPC 流事件
+func (gw *grpcStreamWriter) WriteEvent(eventType string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	if gw.logEvents {
+		fmt.Printf("grpc event: %s\n", eventType)
+		fmt.Printf("data: %v\n\n", string(payload))
+	}
+
+	var eventData structpb.Struct
+	if err := protojson.Unmarshal(payload, &eventData); err != nil {
+		return err
+	}
+	return gw.stream.Send(&kiro2ccv1.StreamEvent{Type: eventType, Data: &eventData})
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	kiro2ccv1 "github.com/bestk/kiro2cc/proto/kiro2cc/v1"
+)
+
+// newGRPCClient 通过内存连接启动 gRPC 服务并返回客户端
+func newGRPCClient(t *testing.T, srv *Server) kiro2ccv1.MessagesServiceClient {
+	t.Helper()
+	listener := bufconn.Listen(1 << 20)
+	grpcServer := grpc.NewServer()
+	srv.RegisterGRPC(grpcServer)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return kiro2ccv1.NewMessagesServiceClient(conn)
+}
+
+func testProtoRequest() *kiro2ccv1.MessageRequest {
+	return &kiro2ccv1.MessageRequest{
+		Model:     "claude-sonnet-4-20250514",
+		MaxTokens: 100,
+		Messages: []*kiro2ccv1.Message{{
+			Role:    "user",
+			Content: []*kiro2ccv1.ContentBlock{{Type: "text", Text: "hi"}},
+		}},
+	}
+}
+
+func TestGRPCMessage(t *testing.T) {
+	backend := &fakeBackend{events: textEvents("Hello ", "gRPC")}
+	client := newGRPCClient(t, newTestServer(t, Config{Backend: backend}))
+
+	resp, err := client.Message(context.Background(), testProtoRequest())
+	if err != nil {
+		t.Fatalf("Message: %v", err)
+	}
+	if len(resp.Content) != 1 || resp.Content[0].Text != "Hello gRPC" || resp.StopReason != "end_turn" {
+		t.Fatalf("unexpected response: %v", resp)
+	}
+	if got := getMessageContent(backend.reqs[0].Messages[0].Content); got != "hi" {
+		t.Fatalf("backend got content %q", got)
+	}
+}
+
+func TestGRPCMessageStream(t *testing.T) {
+	backend := &fakeBackend{events: textEvents("Hello")}
+	client := newGRPCClient(t, newTestServer(t, Config{Backend: backend}))
+
+	stream, err := client.MessageStream(context.Background(), testProtoRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for {
+		event, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Recv: %v", err)
+		}
+		types = append(types, event.Type)
+	}
+	if len(types) == 0 || types[0] != "message_start" || types[len(types)-1] != "message_stop" {
+		t.Fatalf("unexpected event sequence: %v", types)
+	}
+}
+
+func TestGRPCErrors(t *testing.T) {
+	backend := &fakeBackend{err: &UpstreamError{StatusCode: http.StatusTooManyRequests}}
+	client := newGRPCClient(t, newTestServer(t, Config{Backend: backend}))
+
+	_, err := client.Message(context.Background(), testProtoRequest())
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected ResourceExhausted, got %v", err)
+	}
+
+	req := testProtoRequest()
+	req.Model = "no-such-model"
+	_, err = client.Message(context.Background(), req)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument, got %v", err)
+	}
+}
+
+func TestGRPCMessageTimesOut(t *testing.T) {
+	defer func(timeout time.Duration) { nonStreamRequestTimeout = timeout }(nonStreamRequestTimeout)
+	nonStreamRequestTimeout = 50 * time.Millisecond
+	client := newGRPCClient(t, newTestServer(t, Config{Backend: slowBackend{}}))
+
+	// 客户端没有设置 deadline，请求仍在服务端的期限内结束
+	_, err := client.Message(context.Background(), testProtoRequest())
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("expected DeadlineExceeded, got %v", err)
+	}
+}
@@ -0,0 +1,510 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bestk/kiro2cc/parser"
+)
+
+// streamEventDelay 返回流式事件之间的随机延时，测试和基准测试中可替换
+var streamEventDelay = func() time.Duration {
+	return time.Duration(rand.Intn(300)) * time.Millisecond
+}
+
+// pingInterval 是等待上游期间发送 ping 的间隔，测试中可替换
+var pingInterval = 10 * time.Second
+
+// StreamBufferedHeader 出现在开启 BufferStreams 时代替流式响应返回的 JSON 响应中，说明响应为何不是 SSE
+const StreamBufferedHeader = "x-kiro2cc-stream-buffered"
+
+// streamRequestTimeout 和 nonStreamRequestTimeout 是代理等待上游的期限，超时返回 504 timeout_error，测试中可替换
+var (
+	streamRequestTimeout    = 60 * time.Second
+	nonStreamRequestTimeout = 30 * time.Second
+)
+
+// handleStreamRequest 以 SSE 返回流式响应，ndjson 为 true 时改为每行一个事件对象的 NDJSON，
+// 两者的事件内容完全相同
+func (s *Server) handleStreamRequest(ctx context.Context, w http.ResponseWriter, anthropicReq AnthropicRequest, ndjson bool) {
+	// 设置SSE headers
+	w.Header().Set("Content-Type", "text/event-stream")
+	if ndjson {
+		w.Header().Set("Content-Type", NDJSONContentType)
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported!", http.StatusInternalServerError)
+		return
+	}
+
+	var ew eventWriter = &sseWriter{w: w, flusher: flusher, writeTimeout: s.config.SSEWriteTimeout, ndjson: ndjson, logEvents: s.config.LogBodies}
+	if s.config.SSEBufferEvents > 0 {
+		bw := newBufferedEventWriter(ew, s.config.SSEBufferEvents)
+		defer func() {
+			if err := bw.close(); err != nil {
+				fmt.Printf("警告: 写入流式事件失败: %v\n", err)
+			}
+		}()
+		ew = bw
+	}
+	s.streamResponse(ctx, ew, anthropicReq)
+}
+
+// streamResponse 请求上游并把解析出的事件依次写入 ew，SSE、WebSocket 与 gRPC 共用
+func (s *Server) streamResponse(ctx context.Context, ew eventWriter, anthropicReq AnthropicRequest) {
+	messageId := newMessageID()
+
+	ctx, tracked := s.inflight.track(ctx, messageId, anthropicReq)
+	defer s.inflight.untrack(tracked)
+	ctx, diag := withDiagnostics(ctx)
+
+	// 期限只限制等待上游的时间，事件全部收到后回放给客户端不受其限制，只在客户端断开或请求被取消时停止
+	genCtx, cancel := context.WithTimeout(ctx, streamRequestTimeout)
+	stopPings := pingWhileWaiting(ew)
+	defer stopPings()
+	events, err := s.generate(genCtx, anthropicReq)
+	pinged := stopPings()
+	cancel()
+	if clientGone(ctx) {
+		fmt.Printf("警告: 客户端已断开，已取消上游请求\n")
+		return
+	}
+	// 发送过 ping 后响应头已经写出，诊断头无法再设置
+	if hw, ok := ew.(headerWriter); ok && !pinged {
+		s.setDebugHeaders(hw.Header(), diag)
+		setTruncatedHeader(hw.Header(), diag)
+	}
+	if err != nil {
+		code := generateErrorCode(genCtx, err)
+		if requestCancelled(genCtx) {
+			writeErrorEventWithCode(ew, code, "api_error", errRequestCancelled.Error())
+			return
+		}
+		if requestTimedOut(genCtx) {
+			writeErrorEventWithCode(ew, code, "timeout_error", timeoutMessage(tracked.started, streamRequestTimeout))
+			return
+		}
+		var outErr *StructuredOutputError
+		if errors.As(err, &outErr) {
+			writeErrorEventWithCode(ew, code, "structured_output_error", outErr.Error())
+			return
+		}
+		var hookErr *HookError
+		if errors.As(err, &hookErr) {
+			writeErrorEventWithCode(ew, code, hookErr.errorType(), hookErr.Error())
+			return
+		}
+		var policyErr *PolicyError
+		if errors.As(err, &policyErr) {
+			writeErrorEventWithCode(ew, code, policyErr.Type, policyErr.Message)
+			return
+		}
+		var upErr *UpstreamError
+		if !errors.As(err, &upErr) {
+			sendErrorEvent(ew, code, "CodeWhisperer request error", err)
+			return
+		}
+
+		// 根据不同的状态码发送相应的错误事件
+		switch upErr.StatusCode {
+		case 400:
+			sendErrorEvent(ew, code, "请求参数错误", fmt.Errorf("Bad Request: %s", upErr.Body))
+		case 401:
+			sendErrorEvent(ew, code, "认证失败", fmt.Errorf("Unauthorized: 请检查token"))
+		case 403:
+			if upErr.ProfileMismatch() {
+				writeErrorEventWithCode(ew, code, "permission_error", profileMismatchMessage(s.config.ProfileArn))
+				return
+			}
+			s.refreshTokenSilently()
+			sendErrorEvent(ew, code, "权限不足", fmt.Errorf("Forbidden: Token已刷新，请重试"))
+		case 429:
+			sendErrorEvent(ew, code, "请求频率过高", fmt.Errorf("Rate Limited: 请稍后重试"))
+		case 500:
+			sendErrorEvent(ew, code, "服务器内部错误", fmt.Errorf("Internal Server Error: CodeWhisperer服务异常"))
+		case 502, 503, 504:
+			sendErrorEvent(ew, code, "服务不可用", fmt.Errorf("Service Unavailable: CodeWhisperer服务暂时不可用"))
+		default:
+			sendErrorEvent(ew, code, "未知错误", fmt.Errorf("状态码: %d, 响应: %s", upErr.StatusCode, upErr.Body))
+		}
+		return
+	}
+
+	s.recordTranscript(anthropicReq, events)
+	if !betaEnabled(ctx, BetaFineGrainedToolStreaming) {
+		events = coalesceToolInputDeltas(events)
+	}
+
+	// 同时广播给附加到该流上的观察者
+	b := s.broker.open(messageId, anthropicReq.Model)
+	defer s.broker.finish(b)
+	ew = &teeWriter{primary: ew, b: b}
+
+	if len(events) > 0 {
+
+		// 发送开始事件
+		ew.WriteEvent("message_start", messageStartEvent{
+			Type: "message_start",
+			Message: messageStartBody{
+				ID:      messageId,
+				Type:    "message",
+				Role:    "assistant",
+				Content: []any{},
+				Model:   anthropicReq.Model,
+				Usage: messageUsage{
+					InputTokens:  len(getMessageContent(anthropicReq.Messages[0].Content)),
+					OutputTokens: 1,
+				},
+			},
+		})
+
+		emptyText := ""
+		ew.WriteEvent("content_block_start", parser.ContentBlockStart{
+			Type:         "content_block_start",
+			Index:        0,
+			ContentBlock: parser.ContentBlock{Type: "text", Text: &emptyText},
+		})
+		// 与 Anthropic 一致，ping 紧跟在第一个 content_block_start 之后
+		ew.WriteEvent("ping", typeOnlyEvent{Type: "ping"})
+
+		// 预填充的内容作为回复的开头返回
+		if prefill, _ := assistantPrefill(anthropicReq.Messages); prefill != "" {
+			e := parser.TextDeltaEvent(prefill)
+			ew.WriteEvent(e.Event, e.Data)
+		}
+
+		// 处理解析出的事件
+
+		outputTokens := 0
+		upstreamTokens, hasUpstreamUsage := 0, false
+		stopReason := "end_turn"
+		// openBlock 是当前未关闭的内容块，Anthropic 要求新块开始前关闭上一个块
+		openBlock := 0
+		for _, e := range events {
+			if ctx.Err() != nil {
+				if requestCancelled(ctx) {
+					writeErrorEvent(ew, "api_error", errRequestCancelled.Error())
+				}
+				return
+			}
+			// 上游的用量帧不单独转发，其数值写入最后的 message_delta
+			if tokens, ok := upstreamOutputTokens(e.Data); ok {
+				upstreamTokens, hasUpstreamUsage = tokens, true
+				continue
+			}
+			switch data := e.Data.(type) {
+			case parser.MessageDelta:
+				// 上游在工具调用结束时给出的停止原因写入最后的 message_delta，不提前发送
+				if data.Delta.StopReason != "" {
+					stopReason = data.Delta.StopReason
+				}
+				continue
+			case parser.ContentBlockStart:
+				if openBlock >= 0 && openBlock != data.Index {
+					ew.WriteEvent("content_block_stop", parser.ContentBlockStop{Type: "content_block_stop", Index: openBlock})
+				}
+				openBlock = data.Index
+			case parser.ContentBlockStop:
+				if data.Index != openBlock {
+					// 该块已在新块开始前关闭
+					continue
+				}
+				openBlock = -1
+			}
+			if err := ew.WriteEvent(e.Event, e.Data); err != nil {
+				// 客户端读取过慢或已断开，继续发送没有意义
+				fmt.Printf("警告: 停止发送流式事件: %v\n", err)
+				return
+			}
+
+			if e.Event == "content_block_delta" {
+				text := deltaText(e.Data)
+				outputTokens += len(text)
+				tracked.outputTokens.Add(int64(len(text)))
+			}
+
+			// 随机延时
+			time.Sleep(streamEventDelay())
+		}
+
+		if openBlock >= 0 {
+			ew.WriteEvent("content_block_stop", parser.ContentBlockStop{Type: "content_block_stop", Index: openBlock})
+		}
+
+		// 上游中途断开时已收到的内容照常发出，随后以错误事件结束，客户端据此知道回复不完整
+		if diag.Truncated != nil {
+			writeErrorEventWithCode(ew, CodeUpstreamTruncated, "api_error", fmt.Sprintf("CodeWhisperer 连接中途断开，以上为已收到的部分: %v", diag.Truncated))
+			return
+		}
+
+		// 只有上游没有返回用量时才使用按长度的估算
+		if hasUpstreamUsage {
+			outputTokens = upstreamTokens
+		}
+
+		contentBlockStopReason := parser.MessageDelta{
+			Type:  "message_delta",
+			Delta: parser.MessageDeltaBody{StopReason: stopReason},
+			Usage: parser.Usage{OutputTokens: outputTokens},
+		}
+		ew.WriteEvent("message_delta", contentBlockStopReason)
+
+		ew.WriteEvent("message_stop", typeOnlyEvent{Type: "message_stop"})
+	}
+
+}
+
+// pingWhileWaiting 在等待上游期间每隔 pingInterval 写入一个 ping，上游迟迟不返回时客户端和中间的代理不会因连接空闲而断开。
+// 返回的 stop 停止发送并等待发送的 goroutine 退出，之后才能继续写入 ew；stop 返回是否发送过 ping，可以重复调用
+func pingWhileWaiting(ew eventWriter) (stop func() bool) {
+	done, exited := make(chan struct{}), make(chan struct{})
+	pinged := false
+	go func() {
+		defer close(exited)
+		ticker := time.NewTicker(pingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if ew.WriteEvent("ping", typeOnlyEvent{Type: "ping"}) != nil {
+					return
+				}
+				pinged = true
+			}
+		}
+	}()
+	var once sync.Once
+	return func() bool {
+		once.Do(func() { close(done) })
+		<-exited
+		return pinged
+	}
+}
+
+// handleNonStreamRequest 处理非流式请求，客户端断开时 ctx 被取消，上游请求随之中止。
+// timeout 是等待上游的期限，缓冲返回的流式请求使用流式请求的期限
+func (s *Server) handleNonStreamRequest(ctx context.Context, w http.ResponseWriter, anthropicReq AnthropicRequest, timeout time.Duration) {
+	parentCtx := ctx
+	messageId := newMessageID()
+	ctx, tracked := s.inflight.track(ctx, messageId, anthropicReq)
+	defer s.inflight.untrack(tracked)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ctx, diag := withDiagnostics(ctx)
+
+	events, err := s.generate(ctx, anthropicReq)
+	if clientGone(parentCtx) {
+		fmt.Printf("警告: 客户端已断开，已取消上游请求\n")
+		return
+	}
+	s.setDebugHeaders(w.Header(), diag)
+	setTruncatedHeader(w.Header(), diag)
+	if err != nil {
+		code := generateErrorCode(ctx, err)
+		if requestCancelled(ctx) {
+			sendJSONErrorWithCode(w, http.StatusInternalServerError, code, "api_error", errRequestCancelled.Error())
+			return
+		}
+		if requestTimedOut(ctx) {
+			sendJSONErrorWithCode(w, http.StatusGatewayTimeout, code, "timeout_error", timeoutMessage(tracked.started, timeout))
+			return
+		}
+		var outErr *StructuredOutputError
+		if errors.As(err, &outErr) {
+			sendJSONErrorWithCode(w, http.StatusUnprocessableEntity, code, "structured_output_error", outErr.Error())
+			return
+		}
+		var hookErr *HookError
+		if errors.As(err, &hookErr) {
+			sendJSONErrorWithCode(w, hookErr.status(), code, hookErr.errorType(), hookErr.Error())
+			return
+		}
+		var policyErr *PolicyError
+		if errors.As(err, &policyErr) {
+			sendJSONErrorWithCode(w, policyErr.Status, code, policyErr.Type, policyErr.Message)
+			return
+		}
+		var upErr *UpstreamError
+		if !errors.As(err, &upErr) {
+			sendJSONErrorWithCode(w, http.StatusInternalServerError, code, "api_error", err.Error())
+			return
+		}
+
+		// 根据不同的状态码返回相应的错误
+		switch upErr.StatusCode {
+		case 400:
+			sendJSONErrorWithCode(w, http.StatusBadRequest, code, "invalid_request_error", fmt.Sprintf("请求参数错误: %s", upErr.Body))
+		case 401:
+			sendJSONErrorWithCode(w, http.StatusUnauthorized, code, "authentication_error", "认证失败，请检查token")
+		case 403:
+			if upErr.ProfileMismatch() {
+				sendJSONErrorWithCode(w, http.StatusForbidden, code, "permission_error", profileMismatchMessage(s.config.ProfileArn))
+				return
+			}
+			// 尝试刷新token
+			fmt.Printf("Token可能已过期，尝试刷新...\n")
+			if refreshErr := s.refreshTokenSilently(); refreshErr == nil {
+				sendJSONErrorWithCode(w, http.StatusForbidden, code, "permission_error", "Token已刷新，请重试请求")
+			} else {
+				sendJSONErrorWithCode(w, http.StatusForbidden, CodeTokenRefreshFailed, "permission_error", "权限不足且Token刷新失败，请重新登录")
+			}
+		case 429:
+			sendJSONErrorWithCode(w, http.StatusTooManyRequests, code, "rate_limit_error", "请求频率过高，请稍后重试")
+		case 500:
+			sendJSONErrorWithCode(w, http.StatusInternalServerError, code, "api_error", "CodeWhisperer服务器内部错误")
+		case 502, 503, 504:
+			sendJSONErrorWithCode(w, http.StatusServiceUnavailable, code, "overloaded_error", "CodeWhisperer服务暂时不可用，请稍后重试")
+		default:
+			sendJSONErrorWithCode(w, upErr.StatusCode, code, "api_error", fmt.Sprintf("CodeWhisperer返回错误，状态码: %d, 响应: %s", upErr.StatusCode, upErr.Body))
+		}
+		return
+	}
+
+	s.recordTranscript(anthropicReq, events)
+
+	// 发送响应
+	resp := buildMessageResponse(anthropicReq, events)
+	resp["id"] = messageId
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// clientGone 判断请求是否因客户端断开而被取消（超时和管理员取消不算）
+func clientGone(ctx context.Context) bool {
+	return errors.Is(ctx.Err(), context.Canceled) && !requestCancelled(ctx)
+}
+
+// deltaText 返回 content_block_delta 事件中的文本
+func deltaText(data any) string {
+	if delta, ok := data.(parser.ContentBlockDelta); ok {
+		return delta.Delta.Text
+	}
+	return ""
+}
+
+// upstreamOutputTokens 返回上游用量帧中的输出 token 数。parser 只把以 token 计量的用量帧转换为带 usage 的 message_delta，
+// 以 credit 计量的用量帧不是 token 数，会被丢弃，此时仍使用本地的估算
+func upstreamOutputTokens(data any) (int, bool) {
+	if delta, ok := data.(parser.MessageDelta); ok && delta.Usage.OutputTokens > 0 {
+		return delta.Usage.OutputTokens, true
+	}
+	return 0, false
+}
+
+// buildMessageResponse 把解析出的事件组装为 Anthropic 非流式响应
+func buildMessageResponse(anthropicReq AnthropicRequest, events []parser.SSEEvent) map[string]any {
+	context := ""
+	toolName := ""
+	toolUseId := ""
+
+	contexts := []map[string]any{}
+
+	partialJsonStr := ""
+	upstreamTokens, hasUpstreamUsage := 0, false
+	for _, event := range events {
+		if tokens, ok := upstreamOutputTokens(event.Data); ok {
+			upstreamTokens, hasUpstreamUsage = tokens, true
+			continue
+		}
+		switch data := event.Data.(type) {
+		case parser.ContentBlockStart:
+			context = ""
+			// 一次回复中可能有多个工具调用，每个工具的输入单独累积
+			partialJsonStr = ""
+		case parser.ContentBlockDelta:
+			switch data.Delta.Type {
+			case "text_delta":
+				context += data.Delta.Text
+			case "input_json_delta":
+				toolUseId = data.Delta.ID
+				toolName = data.Delta.Name
+				if data.Delta.PartialJSON != nil {
+					partialJsonStr = partialJsonStr + *data.Delta.PartialJSON
+				} else {
+					log.Println("partial_json not found")
+				}
+			}
+		case parser.ContentBlockStop:
+			switch data.Index {
+			case 1:
+				toolInput := map[string]interface{}{}
+				if err := json.Unmarshal([]byte(partialJsonStr), &toolInput); err != nil {
+					log.Printf("json unmarshal error:%s", err.Error())
+				}
+
+				contexts = append(contexts, map[string]interface{}{
+					"type":  "tool_use",
+					"id":    toolUseId,
+					"name":  toolName,
+					"input": toolInput,
+				})
+			case 0:
+				contexts = append(contexts, map[string]interface{}{
+					"text": context,
+					"type": "text",
+				})
+			}
+		}
+	}
+
+	// 回退：如果已累积到文本但未收到 content_block_stop(index=0)，也要返回文本
+	if len(contexts) == 0 && strings.TrimSpace(context) != "" {
+		contexts = append(contexts, map[string]any{
+			"type": "text",
+			"text": context,
+		})
+	}
+
+	// 预填充的内容作为回复的开头返回
+	if prefill, _ := assistantPrefill(anthropicReq.Messages); prefill != "" {
+		context = prefill + context
+		prefixed := false
+		for _, block := range contexts {
+			if block["type"] == "text" {
+				block["text"] = prefill + block["text"].(string)
+				prefixed = true
+				break
+			}
+		}
+		if !prefixed {
+			contexts = append([]map[string]any{{"type": "text", "text": prefill}}, contexts...)
+		}
+	}
+
+	// 只有上游没有返回用量时才使用按长度的估算
+	outputTokens := len(context)
+	if hasUpstreamUsage {
+		outputTokens = upstreamTokens
+	}
+
+	// 构建 Anthropic 响应
+	return map[string]any{
+		"id":            newMessageID(),
+		"content":       contexts,
+		"model":         anthropicReq.Model,
+		"role":          "assistant",
+		"stop_reason":   "end_turn",
+		"stop_sequence": nil,
+		"type":          "message",
+		"usage": map[string]any{
+			"input_tokens":                len(getMessageContent(anthropicReq.Messages[len(anthropicReq.Messages)-1].Content)),
+			"cache_creation_input_tokens": 0,
+			"cache_read_input_tokens":     0,
+			"output_tokens":               outputTokens,
+		},
+	}
+}
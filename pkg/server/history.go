@@ -0,0 +1,55 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// bytesPerToken 是估算 token 数时每个 token 对应的字节数，英文文本大约 4 字节一个 token
+const bytesPerToken = 4
+
+// historyStats 描述发送给上游的历史记录规模，用于解释请求为什么慢或被上游拒绝。
+// 历史裁剪等需要按历史长度做决策的逻辑应读取它，而不是各自重新计算
+type historyStats struct {
+	// Turns 是历史记录的条数，system 消息及其默认回复也计算在内
+	Turns int
+	// Bytes 是历史记录序列化为 JSON 后的字节数
+	Bytes int
+	// EstimatedTokens 按字节数粗略估算，只用于比较和排查，不等于上游的计费 token
+	EstimatedTokens int
+	// BuildTime 是构建整个 CodeWhisperer 请求的耗时
+	BuildTime time.Duration
+}
+
+// measureHistory 统计 CodeWhisperer 请求中历史记录的规模
+func measureHistory(cwReq CodeWhispererRequest, buildTime time.Duration) historyStats {
+	stats := historyStats{Turns: len(cwReq.ConversationState.History), BuildTime: buildTime}
+	if stats.Turns == 0 {
+		return stats
+	}
+	if data, err := json.Marshal(cwReq.ConversationState.History); err == nil {
+		stats.Bytes = len(data)
+	}
+	stats.EstimatedTokens = estimateTokens(stats.Bytes)
+	return stats
+}
+
+// estimateRequestTokens 估算整个 CodeWhisperer 请求的输入 token 数：measureHistory 统计的历史记录
+// 加上按同样方式序列化的当前消息 (包括工具定义和工具结果)
+func estimateRequestTokens(cwReq CodeWhispererRequest) int {
+	bytes := measureHistory(cwReq, 0).Bytes
+	if data, err := json.Marshal(cwReq.ConversationState.CurrentMessage); err == nil {
+		bytes += len(data)
+	}
+	return estimateTokens(bytes)
+}
+
+// estimateTokens 按字节数估算 token 数，向上取整
+func estimateTokens(bytes int) int {
+	return (bytes + bytesPerToken - 1) / bytesPerToken
+}
+
+func (h historyStats) String() string {
+	return fmt.Sprintf("%d 条, %d 字节, 约 %d tokens, 构建耗时 %s", h.Turns, h.Bytes, h.EstimatedTokens, h.BuildTime)
+}
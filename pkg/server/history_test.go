@@ -0,0 +1,72 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHistoryStatsHeaders(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "data: {\"content\":\"ok\"}\n\n")
+	}))
+	defer upstream.Close()
+
+	srv := newTestServer(t, Config{Debug: true})
+	srv.backend.(*codeWhispererBackend).url = upstream.URL
+
+	body := `{"model":"claude-sonnet-4-20250514","max_tokens":10,"system":[{"type":"text","text":"be brief"}],"messages":[
+		{"role":"user","content":"first"},
+		{"role":"assistant","content":"ok"},
+		{"role":"user","content":"second"}
+	]}`
+	rec := postMessages(srv, body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("x-kiro2cc-history-turns"); got != "4" {
+		t.Errorf("x-kiro2cc-history-turns = %q, want 4", got)
+	}
+	bytes, _ := strconv.Atoi(rec.Header().Get("x-kiro2cc-history-bytes"))
+	tokens, _ := strconv.Atoi(rec.Header().Get("x-kiro2cc-history-tokens"))
+	if bytes == 0 || tokens != estimateTokens(bytes) {
+		t.Errorf("history bytes = %d, tokens = %d", bytes, tokens)
+	}
+
+	// 未开启调试时不暴露
+	srv = newTestServer(t, Config{})
+	srv.backend.(*codeWhispererBackend).url = upstream.URL
+	if rec := postMessages(srv, body); rec.Header().Get("x-kiro2cc-history-turns") != "" {
+		t.Error("history headers should only be set in debug mode")
+	}
+}
+
+func TestMeasureHistoryWithoutHistory(t *testing.T) {
+	stats := measureHistory(CodeWhispererRequest{}, 0)
+	if stats.Turns != 0 || stats.Bytes != 0 || stats.EstimatedTokens != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+	if !strings.Contains(stats.String(), "0 条") {
+		t.Errorf("String() = %q", stats.String())
+	}
+}
+
+func TestEstimateInputTokensMatchesHistory(t *testing.T) {
+	req := AnthropicRequest{
+		Model: "claude-sonnet-4-20250514",
+		Messages: []AnthropicRequestMessage{
+			{Role: "user", Content: strings.Repeat("a", 4000)},
+			{Role: "assistant", Content: "ok"},
+			{Role: "user", Content: "next"},
+		},
+	}
+	cwReq := buildCodeWhispererRequest(req, "", "")
+	history := measureHistory(cwReq, 0)
+	// 输入估算与历史规模同一口径，只多出当前消息
+	if got := estimateInputTokens(req); got <= history.EstimatedTokens || got > history.EstimatedTokens+100 {
+		t.Errorf("estimateInputTokens = %d, history = %d tokens", got, history.EstimatedTokens)
+	}
+}
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/bestk/kiro2cc/parser"
+)
+
+// DefaultHookTimeout 是单次执行变换钩子的默认期限
+const DefaultHookTimeout = 10 * time.Second
+
+// 钩子执行失败 (超时、非 0 退出、输出无法解析) 时的处理方式
+const (
+	// HookFailureReject 拒绝请求，适合合规过滤等不能跳过的钩子
+	HookFailureReject = "reject"
+	// HookFailureIgnore 记录警告后按未变换的内容继续
+	HookFailureIgnore = "ignore"
+)
+
+// hookRejectExitCode 是钩子主动拒绝请求时使用的退出码，此时 stderr 作为拒绝原因返回给客户端
+const hookRejectExitCode = 2
+
+// 钩子阶段，通过环境变量 KIRO2CC_HOOK_STAGE 传给钩子
+const (
+	hookStageRequest  = "request"
+	hookStageResponse = "response"
+)
+
+// HookError 表示变换钩子拒绝了请求，或在 HookFailureReject 策略下执行失败
+type HookError struct {
+	Stage string
+	// Rejected 为 true 表示钩子以退出码 2 主动拒绝
+	Rejected bool
+	Message  string
+}
+
+func (e *HookError) Error() string {
+	if e.Rejected {
+		return fmt.Sprintf("%s hook 拒绝了请求: %s", e.Stage, e.Message)
+	}
+	return fmt.Sprintf("%s hook 执行失败: %s", e.Stage, e.Message)
+}
+
+// status 返回对应的 HTTP 状态码：主动拒绝视为请求错误，执行失败视为服务端错误
+func (e *HookError) status() int {
+	if e.Rejected {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func (e *HookError) errorType() string {
+	if e.Rejected {
+		return "invalid_request_error"
+	}
+	return "api_error"
+}
+
+// responseHookInput 是传给 response hook 的内容，text 是模型输出的全部文本
+type responseHookInput struct {
+	Request AnthropicRequest `json:"request"`
+	Text    string           `json:"text"`
+}
+
+// responseHookOutput 是 response hook 返回的内容
+type responseHookOutput struct {
+	Text *string `json:"text"`
+}
+
+// applyRequestHook 把请求 JSON 交给 request hook，使用其输出的 JSON 作为新的请求。
+// 钩子没有输出时请求保持不变，输出的请求无法通过校验时返回 invalid_request_error
+func (s *Server) applyRequestHook(ctx context.Context, anthropicReq AnthropicRequest) (AnthropicRequest, error) {
+	if s.config.RequestHook == "" {
+		return anthropicReq, nil
+	}
+	input, err := json.Marshal(anthropicReq)
+	if err != nil {
+		return anthropicReq, err
+	}
+	output, err := s.runHook(ctx, hookStageRequest, s.config.RequestHook, input)
+	if err != nil {
+		return anthropicReq, s.hookFailure(hookStageRequest, err)
+	}
+	if len(bytes.TrimSpace(output)) == 0 {
+		return anthropicReq, nil
+	}
+	var rewritten AnthropicRequest
+	if err := json.Unmarshal(output, &rewritten); err != nil {
+		return anthropicReq, s.hookFailure(hookStageRequest, fmt.Errorf("无法解析输出的请求: %v", err))
+	}
+	if len(rewritten.Messages) == 0 {
+		return anthropicReq, s.hookFailure(hookStageRequest, errors.New("输出的请求缺少 messages"))
+	}
+	// 改写后的请求与客户端的请求一样校验，模型、max_tokens 或消息顺序有误时不发往上游
+	rewritten, reqErr := s.validateAnthropicRequest(rewritten)
+	if reqErr != nil {
+		reqErr.Message = "request hook 输出的请求无效: " + reqErr.Message
+		return anthropicReq, reqErr.policyError(CodeInvalidRequest)
+	}
+	return rewritten, nil
+}
+
+// applyResponseHook 把模型输出的文本交给 response hook，用其返回的文本替换所有文本事件。
+// tool_use 等其他事件保持不变
+func (s *Server) applyResponseHook(ctx context.Context, anthropicReq AnthropicRequest, events []parser.SSEEvent) ([]parser.SSEEvent, error) {
+	if s.config.ResponseHook == "" {
+		return events, nil
+	}
+	var text strings.Builder
+	for _, e := range events {
+		text.WriteString(deltaText(e.Data))
+	}
+	input, err := json.Marshal(responseHookInput{Request: anthropicReq, Text: text.String()})
+	if err != nil {
+		return events, err
+	}
+	output, err := s.runHook(ctx, hookStageResponse, s.config.ResponseHook, input)
+	if err != nil {
+		return events, s.hookFailure(hookStageResponse, err)
+	}
+	if len(bytes.TrimSpace(output)) == 0 {
+		return events, nil
+	}
+	var result responseHookOutput
+	if err := json.Unmarshal(output, &result); err != nil {
+		return events, s.hookFailure(hookStageResponse, fmt.Errorf("无法解析输出: %v", err))
+	}
+	if result.Text == nil {
+		return events, s.hookFailure(hookStageResponse, errors.New("输出缺少 text 字段"))
+	}
+	return replaceText(events, *result.Text), nil
+}
+
+// replaceText 用一个文本事件替换所有文本增量，位置在原来第一个文本增量处
+func replaceText(events []parser.SSEEvent, text string) []parser.SSEEvent {
+	replaced := make([]parser.SSEEvent, 0, len(events)+1)
+	inserted := false
+	for _, e := range events {
+		if delta, ok := e.Data.(parser.ContentBlockDelta); ok && delta.Delta.Type == "text_delta" {
+			if !inserted {
+				replaced = append(replaced, parser.TextDeltaEvent(text))
+				inserted = true
+			}
+			continue
+		}
+		replaced = append(replaced, e)
+	}
+	if !inserted && text != "" {
+		replaced = append([]parser.SSEEvent{parser.TextDeltaEvent(text)}, replaced...)
+	}
+	return replaced
+}
+
+// runHook 执行钩子命令，input 写入标准输入，返回标准输出。
+// command 按空白拆分后直接执行，不经过 shell
+func (s *Server) runHook(ctx context.Context, stage, command string, input []byte) ([]byte, error) {
+	args := strings.Fields(command)
+	ctx, cancel := context.WithTimeout(ctx, s.config.HookTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = append(os.Environ(), "KIRO2CC_HOOK_STAGE="+stage)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("超过 %v 未完成", s.config.HookTimeout)
+	}
+	if err != nil {
+		message := strings.TrimSpace(stderr.String())
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == hookRejectExitCode {
+			if message == "" {
+				message = "未说明原因"
+			}
+			return nil, &HookError{Stage: stage, Rejected: true, Message: message}
+		}
+		if message != "" {
+			return nil, fmt.Errorf("%v: %s", err, message)
+		}
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+// hookFailure 按 HookFailure 策略处理钩子错误，返回 nil 表示忽略错误继续。主动拒绝不受策略影响
+func (s *Server) hookFailure(stage string, err error) error {
+	var hookErr *HookError
+	if errors.As(err, &hookErr) {
+		return hookErr
+	}
+	if s.config.HookFailure == HookFailureIgnore {
+		fmt.Printf("警告: %s hook 执行失败，按原内容继续: %v\n", stage, err)
+		return nil
+	}
+	return &HookError{Stage: stage, Message: err.Error()}
+}
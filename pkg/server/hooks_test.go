@@ -0,0 +1,183 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestHookHelperProcess 不是真正的测试，而是被作为变换钩子启动的假程序，行为由 -- 之后的参数决定
+func TestHookHelperProcess(t *testing.T) {
+	if os.Getenv("KIRO2CC_HOOK_HELPER") != "1" {
+		return
+	}
+	mode := os.Args[len(os.Args)-1]
+	input, _ := io.ReadAll(os.Stdin)
+	stage := os.Getenv("KIRO2CC_HOOK_STAGE")
+	switch mode {
+	case "rewrite":
+		if stage == hookStageRequest {
+			var req AnthropicRequest
+			json.Unmarshal(input, &req)
+			req.System = append(req.System, AnthropicSystemMessage{Type: "text", Text: "added by hook"})
+			json.NewEncoder(os.Stdout).Encode(req)
+		} else {
+			var in responseHookInput
+			json.Unmarshal(input, &in)
+			json.NewEncoder(os.Stdout).Encode(map[string]string{"text": strings.ReplaceAll(in.Text, "secret", "[redacted]")})
+		}
+	case "bad-model", "zero-max-tokens", "double-user":
+		var req AnthropicRequest
+		json.Unmarshal(input, &req)
+		switch mode {
+		case "bad-model":
+			req.Model = "gpt-4"
+		case "zero-max-tokens":
+			req.MaxTokens = 0
+		default:
+			req.Messages = append(req.Messages, AnthropicRequestMessage{Role: "user", Content: "again"})
+		}
+		json.NewEncoder(os.Stdout).Encode(req)
+	case "reject":
+		fmt.Fprint(os.Stderr, "blocked by policy")
+		os.Exit(hookRejectExitCode)
+	case "crash":
+		fmt.Fprint(os.Stderr, "boom")
+		os.Exit(1)
+	case "sleep":
+		time.Sleep(5 * time.Second)
+	}
+	os.Exit(0)
+}
+
+func hookHelperCommand(t *testing.T, mode string) string {
+	t.Setenv("KIRO2CC_HOOK_HELPER", "1")
+	if strings.ContainsAny(os.Args[0], " \t") {
+		t.Skip("测试二进制路径包含空白")
+	}
+	return os.Args[0] + " -test.run=^TestHookHelperProcess$ -- " + mode
+}
+
+func postMessages(srv *Server, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body)))
+	return rec
+}
+
+const hookTestBody = `{"model":"claude-sonnet-4-20250514","max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`
+
+func TestHooksRewriteRequestAndResponse(t *testing.T) {
+	backend := &fakeBackend{events: textEvents("the secret ", "is 42")}
+	command := hookHelperCommand(t, "rewrite")
+	srv := newTestServer(t, Config{Backend: backend, RequestHook: command, ResponseHook: command, SystemPrefix: "policy"})
+
+	rec := postMessages(srv, hookTestBody)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	system := backend.reqs[0].System
+	if len(system) != 2 || system[0].Text != "policy" || system[1].Text != "added by hook" {
+		t.Errorf("system = %+v, want prefix followed by hook addition", system)
+	}
+
+	var resp struct {
+		Content []map[string]any `json:"content"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if len(resp.Content) != 1 || resp.Content[0]["text"] != "the [redacted] is 42" {
+		t.Errorf("content = %+v", resp.Content)
+	}
+}
+
+func TestReplaceTextKeepsToolUse(t *testing.T) {
+	events := append(textEvents("a", "b"), toolUseEvents("t1", "lookup", `{"q":"x"}`)...)
+	replaced := replaceText(events, "c")
+	if len(replaced) != len(events)-1 || deltaText(replaced[0].Data) != "c" {
+		t.Fatalf("replaced = %+v", replaced)
+	}
+	for i, e := range replaced[1:] {
+		if e.Event != events[i+2].Event {
+			t.Errorf("event %d = %s, want %s", i+1, e.Event, events[i+2].Event)
+		}
+	}
+}
+
+func TestHookFailures(t *testing.T) {
+	tests := []struct {
+		mode    string
+		policy  string
+		timeout time.Duration
+		status  int
+		message string
+	}{
+		{mode: "reject", policy: HookFailureIgnore, status: http.StatusBadRequest, message: "blocked by policy"},
+		{mode: "crash", status: http.StatusInternalServerError, message: "boom"},
+		{mode: "crash", policy: HookFailureIgnore, status: http.StatusOK},
+		{mode: "sleep", timeout: 100 * time.Millisecond, status: http.StatusInternalServerError, message: "未完成"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode+"/"+tt.policy, func(t *testing.T) {
+			backend := &fakeBackend{events: textEvents("ok")}
+			srv := newTestServer(t, Config{Backend: backend, RequestHook: hookHelperCommand(t, tt.mode), HookFailure: tt.policy, HookTimeout: tt.timeout})
+
+			rec := postMessages(srv, hookTestBody)
+			if rec.Code != tt.status || !strings.Contains(rec.Body.String(), tt.message) {
+				t.Errorf("got %d %s, want %d containing %q", rec.Code, rec.Body.String(), tt.status, tt.message)
+			}
+			if tt.status != http.StatusOK && len(backend.reqs) != 0 {
+				t.Error("backend should not be called when the request hook fails")
+			}
+		})
+	}
+}
+
+func TestRequestHookOutputIsValidated(t *testing.T) {
+	for mode, message := range map[string]string{"bad-model": "Unknown or unsupported model: gpt-4", "zero-max-tokens": "max_tokens"} {
+		backend := &fakeBackend{events: textEvents("ok")}
+		srv := newTestServer(t, Config{Backend: backend, RequestHook: hookHelperCommand(t, mode), HookFailure: HookFailureIgnore})
+
+		rec := postMessages(srv, hookTestBody)
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "invalid_request_error") || !strings.Contains(rec.Body.String(), message) {
+			t.Errorf("%s: got %d %s", mode, rec.Code, rec.Body.String())
+		}
+		if len(backend.reqs) != 0 {
+			t.Errorf("%s: invalid rewritten request reached the backend", mode)
+		}
+	}
+
+	// 相邻的用户消息与客户端请求一样被合并，保证 user/assistant 交替
+	backend := &fakeBackend{events: textEvents("ok")}
+	srv := newTestServer(t, Config{Backend: backend, RequestHook: hookHelperCommand(t, "double-user")})
+	if rec := postMessages(srv, hookTestBody); rec.Code != http.StatusOK || len(backend.reqs) != 1 || len(backend.reqs[0].Messages) != 1 {
+		t.Errorf("got %d %s, messages %+v", rec.Code, rec.Body.String(), backend.reqs)
+	}
+}
+
+func TestHookEmptyOutputKeepsContent(t *testing.T) {
+	backend := &fakeBackend{events: textEvents("unchanged")}
+	command := hookHelperCommand(t, "passthrough")
+	srv := newTestServer(t, Config{Backend: backend, RequestHook: command, ResponseHook: command})
+
+	rec := postMessages(srv, hookTestBody)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "unchanged") {
+		t.Errorf("got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestValidateHooks(t *testing.T) {
+	err := Config{RequestHook: "kiro2cc-no-such-hook", HookFailure: "retry", HookTimeout: -time.Second}.Validate()
+	if err == nil {
+		t.Fatal("expected validation errors")
+	}
+	for _, field := range []string{"RequestHook", "HookFailure", "HookTimeout"} {
+		if !strings.Contains(err.Error(), field+":") {
+			t.Errorf("missing %s problem in %v", field, err)
+		}
+	}
+}
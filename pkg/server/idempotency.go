@@ -0,0 +1,203 @@
+package server
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// IdempotencyKeyHeader 是客户端重试时携带的幂等键请求头
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// DefaultIdempotencyTTL 是缓存的响应默认保留时间
+const DefaultIdempotencyTTL = 10 * time.Minute
+
+// idempotencyMaxEntries 限制缓存的响应数量，超过时淘汰最早过期的记录
+const idempotencyMaxEntries = 256
+
+// idempotentResponse 表示某个幂等键对应的已完成（或进行中）的响应
+type idempotentResponse struct {
+	bodyHash [sha256.Size]byte
+	done     bool
+	status   int
+	header   http.Header
+	body     []byte
+	expires  time.Time
+}
+
+// idempotencyCache 按幂等键缓存成功的响应，供客户端重试时直接返回。
+// db 不为空时已完成的响应同时写入状态数据库，代理重启后客户端的重试仍能命中
+type idempotencyCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	db      *sql.DB
+	entries map[string]*idempotentResponse
+}
+
+func newIdempotencyCache(ttl time.Duration, db *sql.DB) *idempotencyCache {
+	return &idempotencyCache{ttl: ttl, db: db, entries: make(map[string]*idempotentResponse)}
+}
+
+// begin 查找幂等键：命中已完成的响应时返回它；键不存在时登记为进行中并返回 nil。
+// 同一个键对应不同请求体或上一次请求尚未完成时返回错误
+func (c *idempotencyCache) begin(key string, body []byte) (*idempotentResponse, *requestError) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	hash := sha256.Sum256(body)
+	entry, ok := c.entries[key]
+	if !ok {
+		entry, ok = c.loadLocked(key, now)
+	}
+	if ok && (!entry.done || now.Before(entry.expires)) {
+		if entry.bodyHash != hash {
+			return nil, invalidRequest("Idempotency-Key %q was already used with a different request body", key)
+		}
+		if !entry.done {
+			return nil, &requestError{
+				Status:  http.StatusConflict,
+				Type:    "invalid_request_error",
+				Message: "A request with this Idempotency-Key is still in progress",
+			}
+		}
+		return entry, nil
+	}
+
+	c.evict(now)
+	c.entries[key] = &idempotentResponse{bodyHash: hash}
+	return nil, nil
+}
+
+// finish 保存已完成的响应，只缓存 200 响应，失败的请求允许客户端用同一个键重试
+func (c *idempotencyCache) finish(key string, status int, header http.Header, body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	if status != http.StatusOK {
+		delete(c.entries, key)
+		return
+	}
+	entry.done = true
+	entry.status = status
+	entry.header = header
+	entry.body = body
+	entry.expires = time.Now().Add(c.ttl)
+	if err := c.saveLocked(key, entry); err != nil {
+		fmt.Printf("保存幂等响应失败: %v\n", err)
+	}
+}
+
+// loadLocked 从状态数据库读取未过期的响应并放回内存，调用方需持有锁
+func (c *idempotencyCache) loadLocked(key string, now time.Time) (*idempotentResponse, bool) {
+	if c.db == nil {
+		return nil, false
+	}
+	entry := &idempotentResponse{done: true}
+	var hash, header []byte
+	var expires int64
+	err := c.db.QueryRow(`SELECT body_hash, status, header, body, expires FROM idempotency WHERE key = ? AND expires > ?`, key, now.UnixNano()).
+		Scan(&hash, &entry.status, &header, &entry.body, &expires)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			fmt.Printf("读取幂等响应失败: %v\n", err)
+		}
+		return nil, false
+	}
+	copy(entry.bodyHash[:], hash)
+	if err := json.Unmarshal(header, &entry.header); err != nil {
+		fmt.Printf("读取幂等响应失败: %v\n", err)
+		return nil, false
+	}
+	entry.expires = time.Unix(0, expires)
+	c.entries[key] = entry
+	return entry, true
+}
+
+// saveLocked 把已完成的响应写入状态数据库，调用方需持有锁
+func (c *idempotencyCache) saveLocked(key string, entry *idempotentResponse) error {
+	if c.db == nil {
+		return nil
+	}
+	header, err := json.Marshal(entry.header)
+	if err != nil {
+		return err
+	}
+	_, err = c.db.Exec(`INSERT OR REPLACE INTO idempotency (key, body_hash, status, header, body, expires) VALUES (?, ?, ?, ?, ?, ?)`,
+		key, entry.bodyHash[:], entry.status, header, entry.body, entry.expires.UnixNano())
+	return err
+}
+
+// evict 删除过期记录，仍然超过上限时删除最早过期的已完成记录，调用方需持有锁
+func (c *idempotencyCache) evict(now time.Time) {
+	if c.db != nil {
+		if _, err := c.db.Exec(`DELETE FROM idempotency WHERE expires <= ?`, now.UnixNano()); err != nil {
+			fmt.Printf("清理幂等响应失败: %v\n", err)
+		}
+	}
+	for key, entry := range c.entries {
+		if entry.done && !now.Before(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+	for len(c.entries) >= idempotencyMaxEntries {
+		oldest := ""
+		for key, entry := range c.entries {
+			if entry.done && (oldest == "" || entry.expires.Before(c.entries[oldest].expires)) {
+				oldest = key
+			}
+		}
+		if oldest == "" {
+			return
+		}
+		delete(c.entries, oldest)
+	}
+}
+
+// replay 把缓存的响应写回客户端
+func (r *idempotentResponse) replay(w http.ResponseWriter) {
+	for name, values := range r.header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(r.status)
+	w.Write(r.body)
+}
+
+// captureWriter 在写给客户端的同时记录状态码和响应体，支持 SSE 的 Flush
+type captureWriter struct {
+	http.ResponseWriter
+	status int
+	body   []byte
+}
+
+func (cw *captureWriter) WriteHeader(status int) {
+	if cw.status == 0 {
+		cw.status = status
+	}
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+func (cw *captureWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	cw.body = append(cw.body, p...)
+	return cw.ResponseWriter.Write(p)
+}
+
+// Flush 转发给底层的 http.Flusher
+func (cw *captureWriter) Flush() {
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
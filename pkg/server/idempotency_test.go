@@ -0,0 +1,67 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMessageIDsAreUnique(t *testing.T) {
+	srv := newTestServer(t, Config{Backend: &fakeBackend{events: textEvents("hi")}})
+
+	seen := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		body := strings.NewReader(`{"model":"claude-sonnet-4-20250514","max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`)
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/messages", body))
+
+		var resp struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(resp.ID, "msg_") || seen[resp.ID] {
+			t.Fatalf("unexpected or duplicate id %q", resp.ID)
+		}
+		seen[resp.ID] = true
+	}
+}
+
+func TestIdempotencyKeyReplaysResponse(t *testing.T) {
+	backend := &fakeBackend{events: textEvents("hello")}
+	srv := newTestServer(t, Config{Backend: backend})
+
+	send := func(key, content string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		body := strings.NewReader(`{"model":"claude-sonnet-4-20250514","max_tokens":10,"messages":[{"role":"user","content":"` + content + `"}]}`)
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", body)
+		req.Header.Set(IdempotencyKeyHeader, key)
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := send("key-1", "hi")
+	second := send("key-1", "hi")
+	if first.Code != http.StatusOK || second.Code != http.StatusOK {
+		t.Fatalf("status = %d, %d", first.Code, second.Code)
+	}
+	if first.Body.String() != second.Body.String() {
+		t.Fatalf("replayed body differs:\n%s\n%s", first.Body.String(), second.Body.String())
+	}
+	if second.Header().Get("Idempotent-Replayed") != "true" || second.Header().Get(IdempotencyKeyHeader) != "key-1" {
+		t.Fatalf("missing replay headers: %v", second.Header())
+	}
+	if len(backend.reqs) != 1 {
+		t.Fatalf("backend called %d times, want 1", len(backend.reqs))
+	}
+
+	if rec := send("key-1", "something else"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("reused key with different body: status = %d", rec.Code)
+	}
+	if send("key-2", "hi"); len(backend.reqs) != 2 {
+		t.Fatalf("new key should reach the backend")
+	}
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errRequestCancelled 是管理员取消请求时 ctx 的取消原因
+var errRequestCancelled = errors.New("请求已被管理员取消")
+
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+// trackedRequest 表示一个进行中的请求
+type trackedRequest struct {
+	id           string
+	model        string
+	stream       bool
+	user         string
+	bodyHash     string
+	started      time.Time
+	outputTokens atomic.Int64
+	cancel       context.CancelCauseFunc
+}
+
+// requestInfo 是进行中请求的快照
+type requestInfo struct {
+	ID           string    `json:"id"`
+	Model        string    `json:"model"`
+	Stream       bool      `json:"stream"`
+	User         string    `json:"user,omitempty"`
+	BodySHA256   string    `json:"body_sha256,omitempty"`
+	Started      time.Time `json:"started"`
+	ElapsedMs    int64     `json:"elapsed_ms"`
+	OutputTokens int64     `json:"output_tokens"`
+}
+
+// inflightRegistry 记录所有进行中的请求，供实时查看和取消
+type inflightRegistry struct {
+	mu       sync.Mutex
+	requests map[string]*trackedRequest
+	// recent 是最近开始的请求 ID，写入崩溃报告
+	recent []string
+}
+
+func newInflightRegistry() *inflightRegistry {
+	return &inflightRegistry{requests: make(map[string]*trackedRequest)}
+}
+
+// track 登记请求并返回可被管理员取消的 ctx，请求结束后需调用 untrack
+func (ir *inflightRegistry) track(ctx context.Context, id string, anthropicReq AnthropicRequest) (context.Context, *trackedRequest) {
+	ctx, cancel := context.WithCancelCause(withRequestID(ctx, id))
+	tr := &trackedRequest{id: id, model: anthropicReq.Model, stream: anthropicReq.Stream, bodyHash: bodyHashFrom(ctx), started: time.Now(), cancel: cancel}
+	if identity := identityFrom(ctx); identity != nil {
+		tr.user = identity.Subject
+	}
+
+	ir.mu.Lock()
+	ir.requests[id] = tr
+	ir.recent = append(ir.recent, id)
+	if len(ir.recent) > crashRecentRequests {
+		ir.recent = ir.recent[len(ir.recent)-crashRecentRequests:]
+	}
+	ir.mu.Unlock()
+	return ctx, tr
+}
+
+// untrack 移除请求并释放 ctx
+func (ir *inflightRegistry) untrack(tr *trackedRequest) {
+	ir.mu.Lock()
+	delete(ir.requests, tr.id)
+	ir.mu.Unlock()
+	tr.cancel(context.Canceled)
+}
+
+// recentIDs 按开始顺序返回最近的请求 ID，包括已经结束的请求
+func (ir *inflightRegistry) recentIDs() []string {
+	ir.mu.Lock()
+	defer ir.mu.Unlock()
+	return append([]string{}, ir.recent...)
+}
+
+// count 返回进行中的请求数
+func (ir *inflightRegistry) count() int {
+	ir.mu.Lock()
+	defer ir.mu.Unlock()
+	return len(ir.requests)
+}
+
+// cancel 取消指定的请求，请求不存在时返回 false
+func (ir *inflightRegistry) cancel(id string) bool {
+	ir.mu.Lock()
+	tr, ok := ir.requests[id]
+	ir.mu.Unlock()
+	if ok {
+		tr.cancel(errRequestCancelled)
+	}
+	return ok
+}
+
+// cancelAll 取消所有进行中的请求，返回被取消请求的 ID
+func (ir *inflightRegistry) cancelAll() []string {
+	ir.mu.Lock()
+	requests := make([]*trackedRequest, 0, len(ir.requests))
+	for _, tr := range ir.requests {
+		requests = append(requests, tr)
+	}
+	ir.mu.Unlock()
+
+	ids := make([]string, 0, len(requests))
+	for _, tr := range requests {
+		tr.cancel(errRequestCancelled)
+		ids = append(ids, tr.id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// snapshot 按开始时间返回所有进行中请求的快照
+func (ir *inflightRegistry) snapshot() []requestInfo {
+	ir.mu.Lock()
+	defer ir.mu.Unlock()
+
+	infos := make([]requestInfo, 0, len(ir.requests))
+	for _, tr := range ir.requests {
+		infos = append(infos, requestInfo{
+			ID:           tr.id,
+			Model:        tr.model,
+			Stream:       tr.stream,
+			User:         tr.user,
+			BodySHA256:   tr.bodyHash,
+			Started:      tr.started.UTC(),
+			ElapsedMs:    time.Since(tr.started).Milliseconds(),
+			OutputTokens: tr.outputTokens.Load(),
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Started.Before(infos[j].Started) })
+	return infos
+}
+
+// requestCancelled 判断 ctx 是否因管理员取消而结束
+func requestCancelled(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errRequestCancelled)
+}
+
+// handleRequests 以 JSON 列出进行中的请求
+func (s *Server) handleRequests(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"requests": s.inflight.snapshot()})
+}
+
+// handleRequestsLive 以 SSE 每秒推送一次进行中请求的快照，供管理面板使用
+func (s *Server) handleRequestsLive(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported!", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		payload, err := json.Marshal(map[string]any{"requests": s.inflight.snapshot()})
+		if err != nil {
+			return
+		}
+		if _, err := fmt.Fprintf(w, "event: requests\ndata: %s\n\n", payload); err != nil {
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-ticker.C:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// handleCancelRequest 取消指定的进行中请求，中止其上游调用
+func (s *Server) handleCancelRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "只支持POST请求", http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.PathValue("id")
+	if !s.inflight.cancel(id) {
+		sendJSONError(w, http.StatusNotFound, "not_found_error", "请求不存在或已结束")
+		return
+	}
+	fmt.Printf("管理员取消了请求 %s\n", id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"cancelled": id})
+}
+
+// handleCancelAll 取消所有进行中的请求，用于失控的 agent 持续消耗额度时紧急止损
+func (s *Server) handleCancelAll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "只支持POST请求", http.StatusMethodNotAllowed)
+		return
+	}
+	ids := s.inflight.cancelAll()
+	fmt.Printf("管理员取消了全部 %d 个进行中的请求\n", len(ids))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"cancelled": len(ids), "ids": ids})
+}
+
+// handleDashboard 返回管理面板页面
+func (s *Server) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(dashboardHTML)
+}
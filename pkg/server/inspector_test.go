@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCancelInflightRequest(t *testing.T) {
+	backend := &blockingBackend{started: make(chan struct{}), cancelled: make(chan error, 1)}
+	srv := newTestServer(t, Config{Backend: backend})
+
+	rec := httptest.NewRecorder()
+	body := strings.NewReader(`{"model":"claude-sonnet-4-20250514","max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`)
+	done := make(chan struct{})
+	go func() {
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/messages", body))
+		close(done)
+	}()
+	<-backend.started
+
+	list := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(list, localRequest(http.MethodGet, "/admin/requests"))
+	var snapshot struct {
+		Requests []requestInfo `json:"requests"`
+	}
+	if err := json.Unmarshal(list.Body.Bytes(), &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot.Requests) != 1 || snapshot.Requests[0].Model != "claude-sonnet-4-20250514" {
+		t.Fatalf("in-flight requests = %+v", snapshot.Requests)
+	}
+
+	cancel := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(cancel, localRequest(http.MethodPost, "/admin/requests/"+snapshot.Requests[0].ID+"/cancel"))
+	if cancel.Code != http.StatusOK {
+		t.Fatalf("cancel: %d %s", cancel.Code, cancel.Body.String())
+	}
+
+	select {
+	case err := <-backend.cancelled:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("upstream ctx error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("upstream request was not cancelled")
+	}
+	<-done
+	if !strings.Contains(rec.Body.String(), errRequestCancelled.Error()) {
+		t.Fatalf("client response = %d %s", rec.Code, rec.Body.String())
+	}
+
+	missing := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(missing, localRequest(http.MethodPost, "/admin/requests/"+snapshot.Requests[0].ID+"/cancel"))
+	if missing.Code != http.StatusNotFound {
+		t.Fatalf("cancelling a finished request: status = %d", missing.Code)
+	}
+}
+
+func TestCancelAllRequests(t *testing.T) {
+	backend := &gatedBackend{started: make(chan struct{}), release: make(chan struct{})}
+	srv := newTestServer(t, Config{Backend: backend, AdminToken: "secret"})
+
+	recs := make([]*httptest.ResponseRecorder, 2)
+	done := make(chan struct{}, len(recs))
+	for i := range recs {
+		recs[i] = httptest.NewRecorder()
+		body := strings.NewReader(`{"model":"claude-sonnet-4-20250514","max_tokens":10,"messages":[{"role":"user","content":"request ` + string(rune('a'+i)) + `"}]}`)
+		go func(rec *httptest.ResponseRecorder, req *http.Request) {
+			srv.Handler().ServeHTTP(rec, req)
+			done <- struct{}{}
+		}(recs[i], httptest.NewRequest(http.MethodPost, "/v1/messages", body))
+	}
+	for deadline := time.Now().Add(5 * time.Second); backend.calls.Load() < 2; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("requests did not reach the backend")
+		}
+	}
+
+	unauthorized := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(unauthorized, httptest.NewRequest(http.MethodPost, "/admin/cancel-all", nil))
+	if unauthorized.Code != http.StatusUnauthorized {
+		t.Fatalf("cancel-all without token: status = %d", unauthorized.Code)
+	}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/admin/cancel-all", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	srv.Handler().ServeHTTP(rec, req)
+	var result struct {
+		Cancelled int `json:"cancelled"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil || result.Cancelled != 2 {
+		t.Fatalf("cancel-all: %d %s", rec.Code, rec.Body.String())
+	}
+
+	for range recs {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("request was not cancelled")
+		}
+	}
+	for _, rec := range recs {
+		if !strings.Contains(rec.Body.String(), errRequestCancelled.Error()) {
+			t.Errorf("client response = %s", rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// validateJSONSchema 按 JSON Schema 的常用子集校验 value，返回所有不符合之处。
+// 支持 type、enum、const、properties、required、additionalProperties、items、
+// minItems/maxItems、minLength/maxLength、minimum/maximum、anyOf，其余关键字被忽略
+func validateJSONSchema(schema map[string]any, value any) []string {
+	var problems []string
+	checkSchema(schema, value, "$", &problems)
+	return problems
+}
+
+func checkSchema(schema map[string]any, value any, path string, problems *[]string) {
+	add := func(format string, args ...any) {
+		*problems = append(*problems, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	if t, ok := schema["type"]; ok && !matchesType(t, value) {
+		add("类型应为 %v，实际为 %s", t, jsonTypeName(value))
+		return
+	}
+	if enum, ok := schema["enum"].([]any); ok {
+		found := false
+		for _, candidate := range enum {
+			if reflect.DeepEqual(candidate, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			add("取值应为 %v 之一", enum)
+		}
+	}
+	if c, ok := schema["const"]; ok && !reflect.DeepEqual(c, value) {
+		add("取值应为 %v", c)
+	}
+	if anyOf, ok := schema["anyOf"].([]any); ok {
+		matched := false
+		for _, sub := range anyOf {
+			if subSchema, ok := sub.(map[string]any); ok && len(validateJSONSchema(subSchema, value)) == 0 {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			add("不符合 anyOf 中的任何一个 schema")
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		properties, _ := schema["properties"].(map[string]any)
+		if required, ok := schema["required"].([]any); ok {
+			for _, name := range required {
+				if key, ok := name.(string); ok {
+					if _, present := v[key]; !present {
+						add("缺少必需字段 %q", key)
+					}
+				}
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if sub, ok := properties[key].(map[string]any); ok {
+				checkSchema(sub, v[key], path+"."+key, problems)
+				continue
+			}
+			switch extra := schema["additionalProperties"].(type) {
+			case bool:
+				if !extra {
+					add("不允许的字段 %q", key)
+				}
+			case map[string]any:
+				checkSchema(extra, v[key], path+"."+key, problems)
+			}
+		}
+	case []any:
+		if n, ok := schemaNumber(schema, "minItems"); ok && float64(len(v)) < n {
+			add("至少需要 %v 项，实际为 %d", n, len(v))
+		}
+		if n, ok := schemaNumber(schema, "maxItems"); ok && float64(len(v)) > n {
+			add("最多 %v 项，实际为 %d", n, len(v))
+		}
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range v {
+				checkSchema(items, item, fmt.Sprintf("%s[%d]", path, i), problems)
+			}
+		}
+	case string:
+		length := float64(utf8.RuneCountInString(v))
+		if n, ok := schemaNumber(schema, "minLength"); ok && length < n {
+			add("长度至少为 %v", n)
+		}
+		if n, ok := schemaNumber(schema, "maxLength"); ok && length > n {
+			add("长度最多为 %v", n)
+		}
+	case float64:
+		if n, ok := schemaNumber(schema, "minimum"); ok && v < n {
+			add("不能小于 %v", n)
+		}
+		if n, ok := schemaNumber(schema, "maximum"); ok && v > n {
+			add("不能大于 %v", n)
+		}
+	}
+}
+
+// matchesType 判断 value 是否符合 type，type 可以是字符串或字符串数组
+func matchesType(t any, value any) bool {
+	switch t := t.(type) {
+	case string:
+		return matchesTypeName(t, value)
+	case []any:
+		for _, name := range t {
+			if s, ok := name.(string); ok && matchesTypeName(s, value) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func matchesTypeName(name string, value any) bool {
+	switch name {
+	case "integer":
+		f, ok := value.(float64)
+		return ok && f == math.Trunc(f)
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	}
+	return jsonTypeName(value) == name
+}
+
+// jsonTypeName 返回 encoding/json 解码结果对应的 JSON Schema 类型名
+func jsonTypeName(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return "unknown"
+}
+
+func schemaNumber(schema map[string]any, key string) (float64, bool) {
+	n, ok := schema[key].(float64)
+	return n, ok
+}
+
+// extractJSON 去掉模型常见的 markdown 代码块包裹，返回其中的 JSON 文本
+func extractJSON(text string) string {
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "```") {
+		text = strings.TrimPrefix(text, "```")
+		text = strings.TrimPrefix(text, "json")
+		text = strings.TrimSuffix(strings.TrimSpace(text), "```")
+	}
+	return strings.TrimSpace(text)
+}
+
+// parseStructuredOutput 解析并校验模型输出，返回去掉包裹后的 JSON 文本和问题列表
+func parseStructuredOutput(schema map[string]any, text string) (string, []string) {
+	cleaned := extractJSON(text)
+	var value any
+	if err := json.Unmarshal([]byte(cleaned), &value); err != nil {
+		return cleaned, []string{fmt.Sprintf("不是有效的 JSON: %v", err)}
+	}
+	return cleaned, validateJSONSchema(schema, value)
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestValidateJSONSchema(t *testing.T) {
+	var schema map[string]any
+	json.Unmarshal([]byte(`{
+		"type": "object",
+		"required": ["name", "tags"],
+		"additionalProperties": false,
+		"properties": {
+			"name": {"type": "string", "minLength": 1},
+			"age": {"type": "integer", "minimum": 0},
+			"tags": {"type": "array", "maxItems": 2, "items": {"enum": ["a", "b"]}},
+			"nickname": {"type": ["string", "null"]}
+		}
+	}`), &schema)
+
+	tests := []struct {
+		value    string
+		problems []string
+	}{
+		{`{"name":"x","tags":["a"],"nickname":null}`, nil},
+		{`{"name":"x","tags":[],"age":3}`, nil},
+		{`[]`, []string{"$: 类型应为 object"}},
+		{`{"tags":[]}`, []string{`缺少必需字段 "name"`}},
+		{`{"name":"","tags":[],"age":1.5}`, []string{"$.age: 类型应为 integer", "$.name: 长度至少为 1"}},
+		{`{"name":"x","tags":["a","c","b"]}`, []string{"$.tags: 最多 2 项", "$.tags[1]: 取值应为"}},
+		{`{"name":"x","tags":[],"extra":1}`, []string{`不允许的字段 "extra"`}},
+	}
+	for _, tt := range tests {
+		var value any
+		json.Unmarshal([]byte(tt.value), &value)
+		problems := validateJSONSchema(schema, value)
+		if len(problems) != len(tt.problems) {
+			t.Errorf("%s: problems = %v, want %d", tt.value, problems, len(tt.problems))
+			continue
+		}
+		for i, want := range tt.problems {
+			if !strings.Contains(problems[i], want) {
+				t.Errorf("%s: problem %d = %q, want it to contain %q", tt.value, i, problems[i], want)
+			}
+		}
+	}
+}
+
+func TestExtractJSON(t *testing.T) {
+	for input, want := range map[string]string{
+		`{"a":1}`:                 `{"a":1}`,
+		"```json\n{\"a\":1}\n```": `{"a":1}`,
+		"```\n[1]\n```\n":         `[1]`,
+	} {
+		if got := extractJSON(input); got != want {
+			t.Errorf("extractJSON(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
@@ -0,0 +1,349 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultJWTSubjectClaim 是 JWTSubjectClaim 为空时作为用量身份的 claim
+	DefaultJWTSubjectClaim = "sub"
+	// DefaultJWTTierClaim 是 JWTTierClaim 为空时作为限流档位的 claim
+	DefaultJWTTierClaim = "tier"
+)
+
+const (
+	// jwksRefreshInterval 是重新下载 JWKS 的间隔，签名密钥轮换后最迟在这之后生效
+	jwksRefreshInterval = time.Hour
+	// jwksMinRefetch 是遇到未知 kid 时两次下载 JWKS 的最短间隔，避免伪造的 kid 把请求打到身份提供方
+	jwksMinRefetch = time.Minute
+	// jwtClockSkew 是校验 exp 和 nbf 时允许的时钟偏差
+	jwtClockSkew = 30 * time.Second
+)
+
+// jwtProvider 校验客户端携带的 JWT：HS256/384/512 使用 JWTSecret，RS/ES 系列使用 JWKSURL 中的公钥
+type jwtProvider struct {
+	secret       []byte
+	jwks         *jwksCache
+	issuer       string
+	audience     string
+	subjectClaim string
+	tierClaim    string
+	now          func() time.Time
+}
+
+func newJWTProvider(config Config) *jwtProvider {
+	p := &jwtProvider{
+		secret:       []byte(config.JWTSecret),
+		issuer:       config.JWTIssuer,
+		audience:     config.JWTAudience,
+		subjectClaim: config.JWTSubjectClaim,
+		tierClaim:    config.JWTTierClaim,
+		now:          time.Now,
+	}
+	if config.JWKSURL != "" {
+		p.jwks = &jwksCache{url: config.JWKSURL, client: &http.Client{Timeout: 10 * time.Second}}
+	}
+	if p.subjectClaim == "" {
+		p.subjectClaim = DefaultJWTSubjectClaim
+	}
+	if p.tierClaim == "" {
+		p.tierClaim = DefaultJWTTierClaim
+	}
+	return p
+}
+
+// jwtHeader 是 JWT 头部中用到的字段
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+func (p *jwtProvider) Authenticate(ctx context.Context, credential string) (*Identity, error) {
+	parts := strings.Split(credential, ".")
+	if len(parts) != 3 {
+		return nil, ErrUnknownCredential
+	}
+	var header jwtHeader
+	if err := decodeJWTPart(parts[0], &header); err != nil || header.Alg == "" {
+		return nil, ErrUnknownCredential
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("JWT 签名不是有效的 base64url")
+	}
+	if err := p.verify(ctx, header, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]any
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("JWT claims 无法解析: %v", err)
+	}
+	if err := p.validateClaims(claims); err != nil {
+		return nil, err
+	}
+	subject, _ := claims[p.subjectClaim].(string)
+	if subject == "" {
+		return nil, fmt.Errorf("JWT 缺少 %s claim", p.subjectClaim)
+	}
+	tier, _ := claims[p.tierClaim].(string)
+	return &Identity{Subject: subject, Tier: tier, Provider: "jwt"}, nil
+}
+
+// verify 按 alg 校验签名，HS 系列需要 JWTSecret，其他算法需要 JWKSURL
+func (p *jwtProvider) verify(ctx context.Context, header jwtHeader, signed, signature []byte) error {
+	if strings.HasPrefix(header.Alg, "HS") {
+		newHash, err := jwtHash(header.Alg[2:])
+		if err != nil || len(p.secret) == 0 {
+			return fmt.Errorf("不支持的 JWT 算法 %s", header.Alg)
+		}
+		mac := hmac.New(newHash, p.secret)
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return errors.New("JWT 签名无效")
+		}
+		return nil
+	}
+	if p.jwks == nil || len(header.Alg) != 5 {
+		return fmt.Errorf("不支持的 JWT 算法 %s", header.Alg)
+	}
+	key, err := p.jwks.key(ctx, header.Kid)
+	if err != nil {
+		return err
+	}
+	newHash, err := jwtHash(header.Alg[2:])
+	if err != nil {
+		return fmt.Errorf("不支持的 JWT 算法 %s", header.Alg)
+	}
+	h := newHash()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if header.Alg[:2] != "RS" {
+			break
+		}
+		hashes := map[string]crypto.Hash{"256": crypto.SHA256, "384": crypto.SHA384, "512": crypto.SHA512}
+		if rsa.VerifyPKCS1v15(key, hashes[header.Alg[2:]], digest, signature) != nil {
+			return errors.New("JWT 签名无效")
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		if header.Alg[:2] != "ES" {
+			break
+		}
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errors.New("JWT 签名无效")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(key, digest, r, s) {
+			return errors.New("JWT 签名无效")
+		}
+		return nil
+	}
+	return fmt.Errorf("JWT 算法 %s 与密钥 %s 的类型不符", header.Alg, header.Kid)
+}
+
+// validateClaims 校验 exp、nbf，以及配置了时的 iss 和 aud
+func (p *jwtProvider) validateClaims(claims map[string]any) error {
+	now := p.now()
+	if exp, ok := claims["exp"].(float64); ok && now.After(time.Unix(int64(exp), 0).Add(jwtClockSkew)) {
+		return errors.New("JWT 已过期")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(jwtClockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return errors.New("JWT 尚未生效")
+	}
+	if p.issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != p.issuer {
+			return fmt.Errorf("JWT 签发方 %q 不是 %q", iss, p.issuer)
+		}
+	}
+	if p.audience != "" && !audienceContains(claims["aud"], p.audience) {
+		return fmt.Errorf("JWT 的受众不包含 %q", p.audience)
+	}
+	return nil
+}
+
+// audienceContains 判断 aud claim (字符串或字符串数组) 是否包含 audience
+func audienceContains(aud any, audience string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == audience
+	case []any:
+		for _, item := range aud {
+			if item == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jwtHash 返回 alg 后缀对应的摘要算法
+func jwtHash(bits string) (func() hash.Hash, error) {
+	switch bits {
+	case "256":
+		return sha256.New, nil
+	case "384":
+		return sha512.New384, nil
+	case "512":
+		return sha512.New, nil
+	}
+	return nil, fmt.Errorf("不支持的摘要长度 %s", bits)
+}
+
+// decodeJWTPart 解码 JWT 中 base64url 编码的 JSON 段
+func decodeJWTPart(part string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// jwksCache 缓存从 JWKS 地址下载的公钥，定期和遇到未知 kid 时重新下载
+type jwksCache struct {
+	url    string
+	client *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+// key 返回 kid 对应的公钥。JWT 没有 kid 且 JWKS 只有一个密钥时使用该密钥
+func (c *jwksCache) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stale := time.Since(c.fetched) > jwksRefreshInterval
+	if _, ok := c.lookupLocked(kid); stale || (!ok && time.Since(c.fetched) > jwksMinRefetch) {
+		if err := c.fetchLocked(ctx); err != nil && c.keys == nil {
+			return nil, err
+		} else if err != nil {
+			fmt.Printf("警告: 更新 JWKS 失败，继续使用已缓存的公钥: %v\n", err)
+		}
+	}
+	key, ok := c.lookupLocked(kid)
+	if !ok {
+		return nil, fmt.Errorf("JWKS 中没有 kid 为 %q 的公钥", kid)
+	}
+	return key, nil
+}
+
+func (c *jwksCache) lookupLocked(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(c.keys) == 1 {
+		for _, key := range c.keys {
+			return key, true
+		}
+	}
+	key, ok := c.keys[kid]
+	return key, ok
+}
+
+// jsonWebKey 是 JWKS 中 RSA 和 EC 公钥用到的字段
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// fetchLocked 下载并解析 JWKS，无法识别的密钥被忽略
+func (c *jwksCache) fetchLocked(ctx context.Context) error {
+	c.fetched = time.Now()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return fmt.Errorf("创建 JWKS 请求失败: %v", err)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("下载 JWKS 失败: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("下载 JWKS 失败，状态码: %d", resp.StatusCode)
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&set); err != nil {
+		return fmt.Errorf("解析 JWKS 失败: %v", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if key, err := jwk.publicKey(); err == nil {
+			keys[jwk.Kid] = key
+		}
+	}
+	c.keys = keys
+	return nil
+}
+
+// publicKey 把 JWK 转换为 RSA 或 ECDSA 公钥
+func (jwk jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	decode := func(s string) (*big.Int, error) {
+		data, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Int).SetBytes(data), nil
+	}
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decode(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(jwk.E)
+		if err != nil || !e.IsInt64() {
+			return nil, errors.New("无效的 RSA 指数")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, ok := curves[jwk.Crv]
+		if !ok {
+			return nil, fmt.Errorf("不支持的曲线 %s", jwk.Crv)
+		}
+		x, err := decode(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("不支持的密钥类型 %s", jwk.Kty)
+}
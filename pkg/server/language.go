@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// ResponseLanguageOff 用在 ResponseLanguageKeys 中，表示该 API key 的请求不注入语言指令
+const ResponseLanguageOff = "off"
+
+// apiKeyContextKey 是 ctx 中客户端 API key 的键
+type apiKeyContextKey struct{}
+
+// withAPIKey 把客户端携带的 API key 放入 ctx，供 generate 按 key 决定请求级别的行为
+func withAPIKey(ctx context.Context, key string) context.Context {
+	if key == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, apiKeyContextKey{}, key)
+}
+
+// apiKeyFrom 返回 ctx 中客户端的 API key，没有时返回空字符串
+func apiKeyFrom(ctx context.Context) string {
+	key, _ := ctx.Value(apiKeyContextKey{}).(string)
+	return key
+}
+
+// requestAPIKey 按 Anthropic SDK 的约定读取 x-api-key，没有时读取 Authorization: Bearer
+func requestAPIKey(header http.Header) string {
+	if key := header.Get("X-Api-Key"); key != "" {
+		return key
+	}
+	if auth := header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return ""
+}
+
+// apiKeyMiddleware 把请求携带的 API key 放入请求的 ctx
+func apiKeyMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if key := requestAPIKey(r.Header); key != "" {
+			r = r.WithContext(withAPIKey(r.Context(), key))
+		}
+		next(w, r)
+	}
+}
+
+// grpcAPIKey 从 gRPC 元数据中读取 API key，约定与 HTTP 相同
+func grpcAPIKey(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	header := http.Header{}
+	for _, name := range []string{"x-api-key", "authorization"} {
+		if values := md.Get(name); len(values) > 0 {
+			header.Set(name, values[0])
+		}
+	}
+	return requestAPIKey(header)
+}
+
+// responseLanguage 返回本次请求要求的回复语言，ResponseLanguageKeys 中的设置优先，为空表示不注入
+func (s *Server) responseLanguage(ctx context.Context) string {
+	language := s.config.ResponseLanguage
+	if key := apiKeyFrom(ctx); key != "" {
+		if perKey, ok := s.config.ResponseLanguageKeys[key]; ok {
+			language = perKey
+		}
+	}
+	if strings.EqualFold(language, ResponseLanguageOff) {
+		return ""
+	}
+	return language
+}
+
+// languageDirective 返回要求模型使用指定语言回复的 system 指令，常用语言使用该语言本身书写
+func languageDirective(language string) string {
+	switch strings.ToLower(language) {
+	case "zh", "zh-cn", "zh-hans", "chinese", "中文", "简体中文":
+		return "无论用户使用什么语言，请始终使用简体中文回复。"
+	case "zh-tw", "zh-hant", "繁體中文":
+		return "無論使用者使用什麼語言，請始終使用繁體中文回覆。"
+	case "en", "english":
+		return "Always respond in English, regardless of the language the user writes in."
+	}
+	return fmt.Sprintf("Always respond in %s, regardless of the language the user writes in.", language)
+}
+
+// ParseResponseLanguageKeys 解析 "key1=en,key2=off" 形式的按 API key 回复语言
+func ParseResponseLanguageKeys(value string) (map[string]string, error) {
+	languages := make(map[string]string)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		key, language, ok := strings.Cut(item, "=")
+		key, language = strings.TrimSpace(key), strings.TrimSpace(language)
+		if !ok || key == "" || language == "" {
+			// 不在错误中回显内容，避免 API key 出现在日志里
+			return nil, errors.New("无效的回复语言设置，格式应为 API key=语言")
+		}
+		languages[key] = language
+	}
+	return languages, nil
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestResponseLanguagePerAPIKey(t *testing.T) {
+	backend := &fakeBackend{events: textEvents("ok")}
+	srv := newTestServer(t, Config{
+		Backend:              backend,
+		SystemPrefix:         "Follow org policy.",
+		ResponseLanguage:     "zh",
+		ResponseLanguageKeys: map[string]string{"key-en": "en", "key-off": "off"},
+	})
+
+	body := `{"model":"claude-sonnet-4-20250514","max_tokens":10,"system":[{"type":"text","text":"You are helpful."}],"messages":[{"role":"user","content":"hi"}]}`
+	for _, tc := range []struct {
+		name   string
+		header string
+		value  string
+		want   string
+	}{
+		{"default", "", "", languageDirective("zh")},
+		{"unknown key", "X-Api-Key", "other", languageDirective("zh")},
+		{"x-api-key", "X-Api-Key", "key-en", "Always respond in English, regardless of the language the user writes in."},
+		{"bearer", "Authorization", "Bearer key-en", languageDirective("en")},
+		{"off", "X-Api-Key", "key-off", ""},
+	} {
+		backend.reqs = nil
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+		if tc.header != "" {
+			req.Header.Set(tc.header, tc.value)
+		}
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", tc.name, rec.Code, rec.Body.String())
+		}
+
+		system := backend.reqs[0].System
+		if system[0].Text != "Follow org policy." || system[1].Text != "You are helpful." {
+			t.Errorf("%s: system = %+v, want prefix and client system first", tc.name, system)
+		}
+		if tc.want == "" {
+			if len(system) != 2 {
+				t.Errorf("%s: system = %+v, want no language directive", tc.name, system)
+			}
+			continue
+		}
+		if len(system) != 3 || system[2].Text != tc.want {
+			t.Errorf("%s: system = %+v, want directive %q last", tc.name, system, tc.want)
+		}
+	}
+}
+
+func TestParseResponseLanguageKeys(t *testing.T) {
+	got, err := ParseResponseLanguageKeys(" key1=en , key2=off,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got["key1"] != "en" || got["key2"] != "off" {
+		t.Errorf("got %v", got)
+	}
+
+	_, err = ParseResponseLanguageKeys("sk-secret")
+	if err == nil || strings.Contains(err.Error(), "sk-secret") {
+		t.Errorf("err = %v, want an error without the key", err)
+	}
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// 监听地址上可以开启的接口类别
+const (
+	// SurfaceAnthropic 是 Anthropic 格式的 /v1/messages 及 WebSocket、agent 端点
+	SurfaceAnthropic = "anthropic"
+	// SurfaceAdmin 是 /admin/ 管理接口、管理面板和 /metrics
+	SurfaceAdmin = "admin"
+)
+
+// Surfaces 是所有可用的接口类别，/health 在每个监听地址上都可用
+var Surfaces = []string{SurfaceAnthropic, SurfaceAdmin}
+
+// Listener 是 Start 额外监听的地址及其开启的接口类别
+type Listener struct {
+	Addr     string
+	Surfaces []string
+}
+
+// ParseListeners 解析 "addr=surface+surface,addr=surface" 形式的额外监听地址，
+// 例如 "127.0.0.1:9091=admin,:8081=anthropic"
+func ParseListeners(value string) ([]Listener, error) {
+	var listeners []Listener
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		addr, surfaces, ok := strings.Cut(item, "=")
+		addr = strings.TrimSpace(addr)
+		if !ok || addr == "" || strings.TrimSpace(surfaces) == "" {
+			return nil, fmt.Errorf("无效的监听设置 %q，格式应为 地址=接口类别，例如 127.0.0.1:9091=admin", item)
+		}
+		listener := Listener{Addr: addr}
+		for _, surface := range strings.Split(surfaces, "+") {
+			if surface = strings.TrimSpace(surface); surface != "" {
+				listener.Surfaces = append(listener.Surfaces, surface)
+			}
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// validateSurfaces 检查接口类别都是已知的
+func validateSurfaces(surfaces []string) error {
+	for _, surface := range surfaces {
+		if surface == "openai" {
+			return fmt.Errorf("kiro2cc 还没有 OpenAI 兼容接口，可选: %s", strings.Join(Surfaces, ", "))
+		}
+		if !slices.Contains(Surfaces, surface) {
+			return fmt.Errorf("未知的接口类别 %q (可选: %s)", surface, strings.Join(Surfaces, ", "))
+		}
+	}
+	return nil
+}
+
+// surfaceEnabled 判断接口类别是否开启，surfaces 为空表示全部开启
+func surfaceEnabled(surfaces []string, surface string) bool {
+	return len(surfaces) == 0 || slices.Contains(surfaces, surface)
+}
+
+// adminSurfaceEnabled 判断是否有监听地址开启了管理接口
+func (c Config) adminSurfaceEnabled() bool {
+	if surfaceEnabled(c.Surfaces, SurfaceAdmin) {
+		return true
+	}
+	for _, l := range c.Listeners {
+		if surfaceEnabled(l.Surfaces, SurfaceAdmin) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseListeners(t *testing.T) {
+	got, err := ParseListeners(" 127.0.0.1:9091=admin , :8081=anthropic+admin,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Listener{
+		{Addr: "127.0.0.1:9091", Surfaces: []string{SurfaceAdmin}},
+		{Addr: ":8081", Surfaces: []string{SurfaceAnthropic, SurfaceAdmin}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseListeners = %+v", got)
+	}
+
+	for _, bad := range []string{":9091", "=admin", ":9091="} {
+		if _, err := ParseListeners(bad); err == nil {
+			t.Errorf("ParseListeners(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestListenerSurfaces(t *testing.T) {
+	srv := newTestServer(t, Config{Backend: &fakeBackend{events: textEvents("ok")}})
+
+	for _, tc := range []struct {
+		surfaces []string
+		path     string
+		want     int
+	}{
+		{[]string{SurfaceAnthropic}, "/v1/messages", http.StatusOK},
+		{[]string{SurfaceAnthropic}, "/admin/betas", http.StatusNotFound},
+		{[]string{SurfaceAnthropic}, "/health", http.StatusOK},
+		{[]string{SurfaceAdmin}, "/v1/messages", http.StatusNotFound},
+		{[]string{SurfaceAdmin}, "/admin/betas", http.StatusOK},
+		{[]string{SurfaceAdmin}, "/health", http.StatusOK},
+		{nil, "/v1/messages", http.StatusOK},
+		{nil, "/admin/betas", http.StatusOK},
+	} {
+		method := http.MethodGet
+		if tc.path == "/v1/messages" {
+			method = http.MethodPost
+		}
+		req := httptest.NewRequest(method, tc.path, strings.NewReader(hookTestBody))
+		req.RemoteAddr = "127.0.0.1:12345"
+		rec := httptest.NewRecorder()
+		srv.listenerHandler(tc.surfaces).ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%v %s: status %d, want %d", tc.surfaces, tc.path, rec.Code, tc.want)
+		}
+	}
+}
+
+func TestValidateListeners(t *testing.T) {
+	err := Config{
+		Addr:     ":18080",
+		Surfaces: []string{"openai"},
+		Listeners: []Listener{
+			{Addr: ":18080", Surfaces: []string{SurfaceAdmin}},
+			{Addr: ":18081", Surfaces: []string{"graphql"}},
+			{Addr: ":18082"},
+		},
+	}.Validate()
+	if err == nil {
+		t.Fatal("Validate succeeded")
+	}
+	for _, want := range []string{"Surfaces: kiro2cc 还没有 OpenAI 兼容接口", "Listeners[0]: 监听地址 :18080 重复", "Listeners[1]: 未知的接口类别", "Listeners[2]: 至少需要开启一种接口类别"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("missing %q in:\n%v", want, err)
+		}
+	}
+}
+
+// freeAddr 返回一个当前空闲的本机地址
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func TestStartServesExtraListeners(t *testing.T) {
+	mainAddr, adminAddr := freeAddr(t), freeAddr(t)
+	srv := newTestServer(t, Config{
+		Addr:          mainAddr,
+		Surfaces:      []string{SurfaceAnthropic},
+		Listeners:     []Listener{{Addr: adminAddr, Surfaces: []string{SurfaceAdmin}}},
+		SkipPreflight: true,
+		Backend:       &fakeBackend{events: textEvents("ok")},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- srv.Start(ctx) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Start: %v", err)
+		}
+	}()
+
+	get := func(addr, path string) int {
+		for i := 0; i < 50; i++ {
+			resp, err := http.Get("http://" + addr + path)
+			if err == nil {
+				resp.Body.Close()
+				return resp.StatusCode
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		t.Fatalf("%s 无法连接", addr)
+		return 0
+	}
+	if code := get(mainAddr, "/admin/betas"); code != http.StatusNotFound {
+		t.Errorf("main /admin/betas: %d", code)
+	}
+	if code := get(adminAddr, "/admin/betas"); code != http.StatusOK {
+		t.Errorf("admin /admin/betas: %d", code)
+	}
+}
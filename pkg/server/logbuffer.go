@@ -0,0 +1,152 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// DefaultLogBufferLines 是 LogBufferLines 为 0 时在内存中保留的日志行数
+const DefaultLogBufferLines = 1000
+
+// logSubscriberBuffer 是每个实时订阅者的缓冲行数，读取过慢的订阅者会丢失超出的行
+const logSubscriberBuffer = 256
+
+// logBuffer 在内存中保留最近的日志行，并把新写入的行推送给实时订阅者
+type logBuffer struct {
+	mu          sync.Mutex
+	lines       []string
+	next        int
+	full        bool
+	partial     []byte
+	subscribers map[chan string]struct{}
+}
+
+func newLogBuffer(size int) *logBuffer {
+	return &logBuffer{lines: make([]string, size), subscribers: map[chan string]struct{}{}}
+}
+
+// Write 按行切分写入的内容，未以换行结尾的部分等到下次写入再组成完整的行
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	data := append(b.partial, p...)
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		b.appendLocked(string(data[:i]))
+		data = data[i+1:]
+	}
+	b.partial = append([]byte(nil), data...)
+	return len(p), nil
+}
+
+func (b *logBuffer) appendLocked(line string) {
+	b.lines[b.next] = line
+	b.next = (b.next + 1) % len(b.lines)
+	if b.next == 0 {
+		b.full = true
+	}
+	for ch := range b.subscribers {
+		select {
+		case ch <- line:
+		default:
+		}
+	}
+}
+
+// recent 按时间顺序返回最近的 n 行，n 不大于 0 时返回全部
+func (b *logBuffer) recent(n int) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var lines []string
+	if b.full {
+		lines = append(lines, b.lines[b.next:]...)
+	}
+	lines = append(lines, b.lines[:b.next]...)
+	if n > 0 && n < len(lines) {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
+
+// subscribe 订阅之后写入的行，返回的函数用于取消订阅
+func (b *logBuffer) subscribe() (<-chan string, func()) {
+	ch := make(chan string, logSubscriberBuffer)
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subscribers, ch)
+		b.mu.Unlock()
+	}
+}
+
+// LogWriter 返回保存最近日志的缓冲，写入其中的内容可以通过 /admin/logs 查看。
+// 服务器自己的日志直接打印到标准输出，命令行会把标准输出同时复制到这里；
+// 嵌入到其他程序时可以把自己的日志输出也写入它。LogBufferLines 小于 0 时返回 io.Discard
+func (s *Server) LogWriter() io.Writer {
+	if s.logs == nil {
+		return io.Discard
+	}
+	return s.logs
+}
+
+// handleLogs 返回最近的日志行 (?lines=N，默认全部)，follow=true 时在输出历史之后持续推送新的行
+func (s *Server) handleLogs(w http.ResponseWriter, r *http.Request) {
+	if s.logs == nil {
+		sendJSONError(w, http.StatusNotFound, "not_found_error", "未开启日志缓冲")
+		return
+	}
+	n := 0
+	if v := r.URL.Query().Get("lines"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 0 {
+			sendJSONError(w, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("无效的 lines 参数 %q", v))
+			return
+		}
+		n = parsed
+	}
+	follow := r.URL.Query().Get("follow") == "true"
+
+	// 先订阅再读取历史，避免两者之间写入的行丢失
+	var live <-chan string
+	if follow {
+		ch, unsubscribe := s.logs.subscribe()
+		defer unsubscribe()
+		live = ch
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	for _, line := range s.logs.recent(n) {
+		fmt.Fprintln(w, line)
+	}
+	if !follow {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return
+	}
+	flusher.Flush()
+	for {
+		select {
+		case line := <-live:
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogBufferKeepsRecentLines(t *testing.T) {
+	b := newLogBuffer(3)
+	fmt.Fprint(b, "one\ntwo\nthr")
+	fmt.Fprint(b, "ee\nfour\n")
+	if got := strings.Join(b.recent(0), ","); got != "two,three,four" {
+		t.Errorf("recent(0) = %s", got)
+	}
+	if got := strings.Join(b.recent(2), ","); got != "three,four" {
+		t.Errorf("recent(2) = %s", got)
+	}
+}
+
+func TestAdminLogsFollow(t *testing.T) {
+	srv := newTestServer(t, Config{AdminToken: "secret"})
+	fmt.Fprintln(srv.LogWriter(), "before")
+
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	if resp, _ := http.Get(ts.URL + "/admin/logs"); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("without admin token: status %d", resp.StatusCode)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/admin/logs?lines=10&follow=true", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	lines := bufio.NewScanner(resp.Body)
+	if !lines.Scan() || lines.Text() != "before" {
+		t.Fatalf("first line = %q", lines.Text())
+	}
+	fmt.Fprintln(srv.LogWriter(), "after")
+	if !lines.Scan() || lines.Text() != "after" {
+		t.Fatalf("followed line = %q", lines.Text())
+	}
+}
+
+func TestLogBufferDisabled(t *testing.T) {
+	srv := newTestServer(t, Config{LogBufferLines: -1})
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/admin/logs", nil)
+	req.RemoteAddr = "127.0.0.1:12345"
+	srv.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d", rec.Code)
+	}
+}
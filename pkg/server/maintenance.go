@@ -0,0 +1,251 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultMaintenanceMessage 是维护期间拒绝请求时的默认说明
+const DefaultMaintenanceMessage = "代理正在维护，暂不接受新请求"
+
+// QuietWindow 是每周重复的维护时段，期间代理拒绝新请求
+type QuietWindow struct {
+	// Weekdays 是时段开始的星期，为空表示每天
+	Weekdays []time.Weekday
+	// Start 和 End 是距零点的时长，End 不大于 Start 时时段跨过零点，到次日的 End 结束
+	Start time.Duration
+	End   time.Duration
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ParseQuietHours 解析 "22:00-07:00,sat-sun 00:00-24:00" 形式的维护时段，
+// 每一项可以以星期或星期范围开头，没有时表示每天
+func ParseQuietHours(value string) ([]QuietWindow, error) {
+	var windows []QuietWindow
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		var w QuietWindow
+		days, clock, ok := strings.Cut(item, " ")
+		if !ok {
+			days, clock = "", item
+		}
+		if days != "" {
+			from, to, isRange := strings.Cut(strings.ToLower(days), "-")
+			first, ok1 := weekdayNames[from]
+			last, ok2 := weekdayNames[to]
+			if !isRange {
+				last, ok2 = first, ok1
+			}
+			if !ok1 || !ok2 {
+				return nil, fmt.Errorf("无效的星期 %q，格式应为 mon 或 mon-fri", days)
+			}
+			for d := first; ; d = (d + 1) % 7 {
+				w.Weekdays = append(w.Weekdays, d)
+				if d == last {
+					break
+				}
+			}
+		}
+		start, end, ok := strings.Cut(strings.TrimSpace(clock), "-")
+		var err error
+		if w.Start, err = parseClock(start); !ok || err != nil {
+			return nil, fmt.Errorf("无效的维护时段 %q，格式应为 [星期] HH:MM-HH:MM", item)
+		}
+		if w.End, err = parseClock(end); err != nil {
+			return nil, fmt.Errorf("无效的维护时段 %q，格式应为 [星期] HH:MM-HH:MM", item)
+		}
+		if w.Start == w.End || w.Start == 24*time.Hour {
+			return nil, fmt.Errorf("维护时段 %q 的开始和结束时间无效", item)
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+// parseClock 解析 HH:MM，允许 24:00 表示一天结束
+func parseClock(value string) (time.Duration, error) {
+	hours, minutes, ok := strings.Cut(strings.TrimSpace(value), ":")
+	h, err1 := strconv.Atoi(hours)
+	m, err2 := strconv.Atoi(minutes)
+	if !ok || err1 != nil || err2 != nil || h < 0 || m < 0 || m > 59 || h > 24 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("无效的时间 %q", value)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+// onDay 判断时段是否在该星期开始
+func (w QuietWindow) onDay(day time.Weekday) bool {
+	if len(w.Weekdays) == 0 {
+		return true
+	}
+	for _, d := range w.Weekdays {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// activeUntil 判断 now 是否在时段内，是时返回时段的结束时间
+func (w QuietWindow) activeUntil(now time.Time) (time.Time, bool) {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	offset := now.Sub(midnight)
+	if w.End > w.Start {
+		if w.onDay(now.Weekday()) && offset >= w.Start && offset < w.End {
+			return midnight.Add(w.End), true
+		}
+		return time.Time{}, false
+	}
+	// 跨零点的时段: 当天开始的部分，或前一天开始、今天结束的部分
+	if w.onDay(now.Weekday()) && offset >= w.Start {
+		return midnight.AddDate(0, 0, 1).Add(w.End), true
+	}
+	if w.onDay(midnight.AddDate(0, 0, -1).Weekday()) && offset < w.End {
+		return midnight.Add(w.End), true
+	}
+	return time.Time{}, false
+}
+
+// maintenanceStatus 是当前的维护状态
+type maintenanceStatus struct {
+	// Reason 是进入维护的原因: admin (管理员开启) 或 quiet_hours (维护时段)
+	Reason  string     `json:"reason"`
+	Message string     `json:"message"`
+	Since   *time.Time `json:"since,omitempty"`
+	Until   *time.Time `json:"until,omitempty"`
+}
+
+// describe 返回拒绝请求时给客户端的说明
+func (st maintenanceStatus) describe() string {
+	if st.Until == nil {
+		return st.Message
+	}
+	return fmt.Sprintf("%s，预计 %s 恢复", st.Message, st.Until.Format("2006-01-02 15:04 MST"))
+}
+
+// maintenanceMode 记录管理员开启的维护和配置的维护时段
+type maintenanceMode struct {
+	windows  []QuietWindow
+	location *time.Location
+	message  string
+	now      func() time.Time
+
+	mu            sync.Mutex
+	manual        bool
+	manualMessage string
+	manualSince   time.Time
+}
+
+func newMaintenanceMode(config Config) *maintenanceMode {
+	location := config.QuietHoursLocation
+	if location == nil {
+		location = time.Local
+	}
+	message := config.MaintenanceMessage
+	if message == "" {
+		message = DefaultMaintenanceMessage
+	}
+	return &maintenanceMode{windows: config.QuietHours, location: location, message: message, now: time.Now}
+}
+
+// active 返回当前的维护状态，不在维护中时返回 false。管理员开启的维护优先于维护时段
+func (m *maintenanceMode) active() (maintenanceStatus, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.manual {
+		since := m.manualSince
+		message := m.manualMessage
+		if message == "" {
+			message = m.message
+		}
+		return maintenanceStatus{Reason: "admin", Message: message, Since: &since}, true
+	}
+	now := m.now().In(m.location)
+	for _, w := range m.windows {
+		if until, ok := w.activeUntil(now); ok {
+			return maintenanceStatus{Reason: "quiet_hours", Message: m.message, Until: &until}, true
+		}
+	}
+	return maintenanceStatus{}, false
+}
+
+// set 开启或关闭管理员维护，message 为空时使用默认说明
+func (m *maintenanceMode) set(enabled bool, message string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if enabled && !m.manual {
+		m.manualSince = m.now()
+	}
+	m.manual = enabled
+	m.manualMessage = message
+}
+
+// maintenanceMiddleware 在维护期间以 503 overloaded_error 拒绝新请求，已经开始的请求不受影响
+func (s *Server) maintenanceMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if st, ok := s.maintenance.active(); ok {
+			if st.Until != nil {
+				retryAfter := time.Until(*st.Until)
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			}
+			sendJSONErrorWithCode(w, http.StatusServiceUnavailable, CodeMaintenance, "overloaded_error", st.describe())
+			return
+		}
+		next(w, r)
+	}
+}
+
+// grpcCheckMaintenance 对 gRPC 请求做与 maintenanceMiddleware 相同的检查
+func (s *Server) grpcCheckMaintenance() error {
+	if st, ok := s.maintenance.active(); ok {
+		return status.Errorf(codes.Unavailable, "[%s] %s", CodeMaintenance, st.describe())
+	}
+	return nil
+}
+
+// handleMaintenance 查看 (GET) 或切换 (POST {"enabled": true, "message": "..."}) 维护状态
+func (s *Server) handleMaintenance(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var body struct {
+			Enabled bool   `json:"enabled"`
+			Message string `json:"message"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodySize)).Decode(&body); err != nil {
+			sendJSONError(w, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("解析请求失败: %v", err))
+			return
+		}
+		s.maintenance.set(body.Enabled, body.Message)
+		if body.Enabled {
+			fmt.Printf("管理员开启了维护模式\n")
+		} else {
+			fmt.Printf("管理员关闭了维护模式\n")
+		}
+	default:
+		http.Error(w, "只支持GET和POST请求", http.StatusMethodNotAllowed)
+		return
+	}
+	st, ok := s.maintenance.active()
+	resp := map[string]any{"maintenance": ok}
+	if ok {
+		resp["status"] = st
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseQuietHours(t *testing.T) {
+	windows, err := ParseQuietHours("22:00-07:00, sat-sun 00:00-24:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(windows) != 2 || windows[0].Start != 22*time.Hour || windows[0].End != 7*time.Hour || len(windows[0].Weekdays) != 0 {
+		t.Fatalf("windows = %+v", windows)
+	}
+	if days := windows[1].Weekdays; len(days) != 2 || days[0] != time.Saturday || days[1] != time.Sunday {
+		t.Errorf("weekdays = %v", days)
+	}
+	for _, bad := range []string{"22:00", "25:00-07:00", "07:00-07:00", "xyz 01:00-02:00", "24:00-01:00"} {
+		if _, err := ParseQuietHours(bad); err == nil {
+			t.Errorf("ParseQuietHours(%q) accepted", bad)
+		}
+	}
+}
+
+func TestQuietWindowActive(t *testing.T) {
+	windows, err := ParseQuietHours("fri 22:00-07:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := windows[0]
+	// 2025-06-06 是星期五
+	at := func(day, hour int) time.Time { return time.Date(2025, 6, day, hour, 0, 0, 0, time.UTC) }
+	for _, tc := range []struct {
+		now   time.Time
+		want  bool
+		until time.Time
+	}{
+		{at(6, 23), true, at(7, 7)},
+		{at(7, 6), true, at(7, 7)},
+		{at(7, 8), false, time.Time{}},
+		{at(6, 21), false, time.Time{}},
+		{at(5, 23), false, time.Time{}},
+	} {
+		until, ok := w.activeUntil(tc.now)
+		if ok != tc.want || !until.Equal(tc.until) {
+			t.Errorf("%v: %v %v, want %v %v", tc.now, until, ok, tc.until, tc.want)
+		}
+	}
+}
+
+func TestMaintenanceRejectsRequests(t *testing.T) {
+	backend := &fakeBackend{events: textEvents("ok")}
+	srv := newTestServer(t, Config{Backend: backend, QuietHours: []QuietWindow{{Start: 22 * time.Hour, End: 7 * time.Hour}}, QuietHoursLocation: time.UTC})
+	now := time.Date(2025, 6, 6, 23, 0, 0, 0, time.UTC)
+	srv.maintenance.now = func() time.Time { return now }
+
+	rec := postMessages(srv, hookTestBody)
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "overloaded_error") || !strings.Contains(rec.Body.String(), string(CodeMaintenance)) {
+		t.Fatalf("quiet hours: %d %s", rec.Code, rec.Body.String())
+	}
+	if len(backend.reqs) != 0 {
+		t.Error("request reached backend during maintenance")
+	}
+	health := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(health, httptest.NewRequest(http.MethodGet, "/health?verbose", nil))
+	if health.Code != http.StatusServiceUnavailable || !strings.Contains(health.Body.String(), `"quiet_hours"`) {
+		t.Errorf("health: %d %s", health.Code, health.Body.String())
+	}
+
+	now = now.Add(9 * time.Hour)
+	if rec := postMessages(srv, hookTestBody); rec.Code != http.StatusOK {
+		t.Fatalf("after quiet hours: %d %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := adminRequest(srv, http.MethodPost, "/admin/maintenance", `{"enabled":true,"message":"升级中"}`); rec.Code != http.StatusOK {
+		t.Fatalf("enable: %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := postMessages(srv, hookTestBody); rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "升级中") {
+		t.Fatalf("admin maintenance: %d %s", rec.Code, rec.Body.String())
+	}
+	adminRequest(srv, http.MethodPost, "/admin/maintenance", `{"enabled":false}`)
+	health = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(health, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if health.Code != http.StatusOK || health.Body.String() != "OK" {
+		t.Errorf("health after disable: %d %s", health.Code, health.Body.String())
+	}
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxTokensWildcard 是 DefaultMaxTokens 中匹配所有模型的键
+const maxTokensWildcard = "*"
+
+// defaultMaxTokensFor 返回模型缺少 max_tokens 时的默认值：先按完整模型名匹配，
+// 再取包含在模型名中的最长的键，最后使用 "*"
+func defaultMaxTokensFor(defaults map[string]int, model string) (int, bool) {
+	if n, ok := defaults[model]; ok {
+		return n, true
+	}
+	matched := ""
+	for key := range defaults {
+		if key != maxTokensWildcard && strings.Contains(model, key) && len(key) > len(matched) {
+			matched = key
+		}
+	}
+	if matched != "" {
+		return defaults[matched], true
+	}
+	n, ok := defaults[maxTokensWildcard]
+	return n, ok
+}
+
+// ParseMaxTokensDefaults 解析 "sonnet-4=8192,haiku=4096,*=4096" 形式的按模型默认 max_tokens
+func ParseMaxTokensDefaults(value string) (map[string]int, error) {
+	defaults := make(map[string]int)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		key, raw, ok := strings.Cut(item, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("无效的默认 max_tokens %q，格式应为 模型=数量", item)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("模型 %s 的默认 max_tokens %q 不是整数", key, raw)
+		}
+		defaults[key] = n
+	}
+	return defaults, nil
+}
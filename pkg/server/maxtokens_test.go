@@ -0,0 +1,49 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestDefaultMaxTokens(t *testing.T) {
+	defaults, err := ParseMaxTokensDefaults("claude-3=4096, sonnet-4=8192,claude-3-5-haiku=2048")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newTestServer(t, Config{DefaultMaxTokens: defaults})
+
+	for model, want := range map[string]int{
+		"claude-sonnet-4-20250514":  8192,
+		"claude-3-5-haiku-20241022": 2048,
+		"claude-3-opus-20240229":    4096,
+	} {
+		req, reqErr := srv.parseAnthropicRequest([]byte(`{"model":"` + model + `","messages":[{"role":"user","content":"hi"}]}`))
+		if reqErr != nil || req.MaxTokens != want {
+			t.Errorf("%s: max_tokens = %d, err = %v, want %d", model, req.MaxTokens, reqErr, want)
+		}
+	}
+
+	// 客户端给出的值保持不变
+	req, reqErr := srv.parseAnthropicRequest([]byte(`{"model":"claude-sonnet-4-20250514","max_tokens":100,"messages":[{"role":"user","content":"hi"}]}`))
+	if reqErr != nil || req.MaxTokens != 100 {
+		t.Errorf("explicit max_tokens changed: %d %v", req.MaxTokens, reqErr)
+	}
+
+	// 没有匹配的模型仍然拒绝，加上 "*" 后使用通配值
+	if _, n := defaultMaxTokensFor(map[string]int{"haiku": 1}, "claude-sonnet-4-20250514"); n {
+		t.Error("unmatched model should have no default")
+	}
+	if n, _ := defaultMaxTokensFor(map[string]int{"haiku": 1, "*": 512}, "claude-sonnet-4-20250514"); n != 512 {
+		t.Errorf("wildcard default = %d", n)
+	}
+}
+
+func TestParseMaxTokensDefaultsErrors(t *testing.T) {
+	for _, bad := range []string{"sonnet", "=100", "sonnet=many"} {
+		if _, err := ParseMaxTokensDefaults(bad); err == nil {
+			t.Errorf("ParseMaxTokensDefaults(%q) should fail", bad)
+		}
+	}
+	if err := (Config{DefaultMaxTokens: map[string]int{"sonnet": 0}}).Validate(); err == nil {
+		t.Error("Validate should reject non-positive defaults")
+	}
+}
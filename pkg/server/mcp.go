@@ -0,0 +1,223 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// mcpProtocolVersion 是实现所依据的 MCP 协议版本
+const mcpProtocolVersion = "2024-11-05"
+
+// mcpDefaultModel 是客户端未给出可识别模型提示时使用的模型
+const mcpDefaultModel = "claude-sonnet-4-20250514"
+
+// JSON-RPC 错误码
+const (
+	jsonRPCParseError     = -32700
+	jsonRPCMethodNotFound = -32601
+	jsonRPCInvalidParams  = -32602
+	jsonRPCInternalError  = -32603
+)
+
+// jsonRPCMessage 表示 JSON-RPC 2.0 请求或通知
+type jsonRPCMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// jsonRPCError 表示 JSON-RPC 2.0 错误对象
+type jsonRPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// mcpContent 表示 MCP 消息内容
+type mcpContent struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	Data     string `json:"data,omitempty"`
+	MimeType string `json:"mimeType,omitempty"`
+}
+
+// mcpCreateMessageParams 表示 sampling/createMessage 的参数
+type mcpCreateMessageParams struct {
+	Messages []struct {
+		Role    string     `json:"role"`
+		Content mcpContent `json:"content"`
+	} `json:"messages"`
+	ModelPreferences struct {
+		Hints []struct {
+			Name string `json:"name"`
+		} `json:"hints"`
+	} `json:"modelPreferences"`
+	SystemPrompt  string         `json:"systemPrompt"`
+	Temperature   *float64       `json:"temperature"`
+	MaxTokens     int            `json:"maxTokens"`
+	StopSequences []string       `json:"stopSequences"`
+	Metadata      map[string]any `json:"metadata"`
+}
+
+// mcpCreateMessageResult 表示 sampling/createMessage 的结果
+type mcpCreateMessageResult struct {
+	Role       string     `json:"role"`
+	Content    mcpContent `json:"content"`
+	Model      string     `json:"model"`
+	StopReason string     `json:"stopReason"`
+}
+
+// ServeMCP 以 MCP 服务器身份在 r/w 上处理按行分隔的 JSON-RPC 消息，
+// 把 sampling/createMessage 请求转换为 Anthropic 请求后交给 Backend 处理
+func (s *Server) ServeMCP(ctx context.Context, r io.Reader, w io.Writer) error {
+	var mu sync.Mutex
+	encoder := json.NewEncoder(w)
+	write := func(msg any) {
+		mu.Lock()
+		defer mu.Unlock()
+		encoder.Encode(msg)
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 10<<20)
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var msg jsonRPCMessage
+		if err := json.Unmarshal([]byte(line), &msg); err != nil {
+			write(map[string]any{"jsonrpc": "2.0", "id": nil, "error": jsonRPCError{Code: jsonRPCParseError, Message: err.Error()}})
+			continue
+		}
+
+		// 没有 id 的是通知，无需应答
+		if len(msg.ID) == 0 {
+			continue
+		}
+
+		wg.Add(1)
+		go func(msg jsonRPCMessage) {
+			defer wg.Done()
+			result, rpcErr := s.handleMCPRequest(ctx, msg)
+			if rpcErr != nil {
+				write(map[string]any{"jsonrpc": "2.0", "id": msg.ID, "error": rpcErr})
+				return
+			}
+			write(map[string]any{"jsonrpc": "2.0", "id": msg.ID, "result": result})
+		}(msg)
+	}
+
+	if err := scanner.Err(); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// handleMCPRequest 分发单个 MCP 请求
+func (s *Server) handleMCPRequest(ctx context.Context, msg jsonRPCMessage) (any, *jsonRPCError) {
+	switch msg.Method {
+	case "initialize":
+		return map[string]any{
+			"protocolVersion": mcpProtocolVersion,
+			"capabilities": map[string]any{
+				"sampling": map[string]any{},
+			},
+			"serverInfo": map[string]any{
+				"name":    "kiro2cc",
+				"version": "1.0",
+			},
+		}, nil
+	case "ping":
+		return map[string]any{}, nil
+	case "sampling/createMessage":
+		var params mcpCreateMessageParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &jsonRPCError{Code: jsonRPCInvalidParams, Message: fmt.Sprintf("解析参数失败: %v", err)}
+		}
+		return s.mcpCreateMessage(ctx, params)
+	default:
+		return nil, &jsonRPCError{Code: jsonRPCMethodNotFound, Message: fmt.Sprintf("不支持的方法: %s", msg.Method)}
+	}
+}
+
+// mcpCreateMessage 把 MCP 采样请求转换为 Anthropic 请求并调用 Backend
+func (s *Server) mcpCreateMessage(ctx context.Context, params mcpCreateMessageParams) (*mcpCreateMessageResult, *jsonRPCError) {
+	anthropicReq := AnthropicRequest{
+		Model:       mcpSelectModel(params),
+		MaxTokens:   params.MaxTokens,
+		Temperature: params.Temperature,
+		Metadata:    params.Metadata,
+	}
+	if params.SystemPrompt != "" {
+		anthropicReq.System = []AnthropicSystemMessage{{Type: "text", Text: params.SystemPrompt}}
+	}
+	for i, m := range params.Messages {
+		if m.Content.Type != "text" {
+			return nil, &jsonRPCError{Code: jsonRPCInvalidParams, Message: fmt.Sprintf("消息 %d 的内容类型 %s 不受支持，仅支持 text", i, m.Content.Type)}
+		}
+		anthropicReq.Messages = append(anthropicReq.Messages, AnthropicRequestMessage{Role: m.Role, Content: m.Content.Text})
+	}
+
+	// 复用 HTTP 入口的校验逻辑
+	body, err := json.Marshal(anthropicReq)
+	if err != nil {
+		return nil, &jsonRPCError{Code: jsonRPCInternalError, Message: err.Error()}
+	}
+	anthropicReq, reqErr := s.parseAnthropicRequest(body)
+	if reqErr != nil {
+		return nil, &jsonRPCError{Code: jsonRPCInvalidParams, Message: reqErr.Message}
+	}
+
+	ctx, tracked := s.inflight.track(ctx, newMessageID(), anthropicReq)
+	defer s.inflight.untrack(tracked)
+
+	events, err := s.generate(ctx, anthropicReq)
+	if err != nil {
+		return nil, &jsonRPCError{Code: jsonRPCInternalError, Message: err.Error()}
+	}
+
+	resp := buildMessageResponse(anthropicReq, events)
+	var text strings.Builder
+	stopReason := "endTurn"
+	if contents, ok := resp["content"].([]map[string]any); ok {
+		for _, block := range contents {
+			switch block["type"] {
+			case "text":
+				if t, ok := block["text"].(string); ok {
+					text.WriteString(t)
+				}
+			case "tool_use":
+				stopReason = "toolUse"
+			}
+		}
+	}
+
+	return &mcpCreateMessageResult{
+		Role:       "assistant",
+		Content:    mcpContent{Type: "text", Text: text.String()},
+		Model:      anthropicReq.Model,
+		StopReason: stopReason,
+	}, nil
+}
+
+// mcpSelectModel 按 modelPreferences.hints 的顺序选择第一个匹配的模型
+func mcpSelectModel(params mcpCreateMessageParams) string {
+	for _, hint := range params.ModelPreferences.Hints {
+		if model, ok := MatchModel(hint.Name); ok {
+			return model
+		}
+	}
+	return mcpDefaultModel
+}
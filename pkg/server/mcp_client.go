@@ -0,0 +1,233 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// mcpStartTimeout 是启动 MCP 服务器并完成握手的期限
+const mcpStartTimeout = 30 * time.Second
+
+// mcpTool 表示 MCP 服务器通过 tools/list 声明的工具
+type mcpTool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	InputSchema map[string]any `json:"inputSchema"`
+}
+
+// mcpResponse 表示 JSON-RPC 2.0 响应
+type mcpResponse struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Result json.RawMessage `json:"result"`
+	Error  *jsonRPCError   `json:"error"`
+}
+
+// mcpClient 通过标准输入输出与一个 MCP 服务器子进程通信，供 agent 模式调用其工具
+type mcpClient struct {
+	command string
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	nextID  int
+	pending map[int]chan mcpResponse
+	done    chan struct{}
+
+	tools []mcpTool
+}
+
+// startMCPClient 启动 MCP 服务器子进程，完成 initialize 握手并读取工具列表。
+// command 按空白拆分后直接执行，不经过 shell
+func startMCPClient(ctx context.Context, command string) (*mcpClient, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, errors.New("MCP 服务器命令不能为空")
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("启动 MCP 服务器 %s 失败: %v", args[0], err)
+	}
+
+	c := &mcpClient{command: command, cmd: cmd, stdin: stdin, pending: make(map[int]chan mcpResponse), done: make(chan struct{})}
+	go c.readLoop(stdout)
+
+	ctx, cancel := context.WithTimeout(ctx, mcpStartTimeout)
+	defer cancel()
+	if err := c.initialize(ctx); err != nil {
+		c.close()
+		return nil, fmt.Errorf("MCP 服务器 %s: %v", args[0], err)
+	}
+	return c, nil
+}
+
+// initialize 完成握手并分页读取全部工具
+func (c *mcpClient) initialize(ctx context.Context) error {
+	params := map[string]any{
+		"protocolVersion": mcpProtocolVersion,
+		"capabilities":    map[string]any{},
+		"clientInfo":      map[string]any{"name": "kiro2cc", "version": "1.0"},
+	}
+	if err := c.call(ctx, "initialize", params, nil); err != nil {
+		return err
+	}
+	if err := c.notify("notifications/initialized"); err != nil {
+		return err
+	}
+
+	cursor := ""
+	for {
+		var params map[string]any
+		if cursor != "" {
+			params = map[string]any{"cursor": cursor}
+		}
+		var result struct {
+			Tools      []mcpTool `json:"tools"`
+			NextCursor string    `json:"nextCursor"`
+		}
+		if err := c.call(ctx, "tools/list", params, &result); err != nil {
+			return err
+		}
+		c.tools = append(c.tools, result.Tools...)
+		if result.NextCursor == "" {
+			return nil
+		}
+		cursor = result.NextCursor
+	}
+}
+
+// callTool 调用工具，把返回的文本内容拼接为 tool_result 的内容
+func (c *mcpClient) callTool(ctx context.Context, name string, input map[string]any) (string, bool) {
+	if input == nil {
+		input = map[string]any{}
+	}
+	var result struct {
+		Content []mcpContent `json:"content"`
+		IsError bool         `json:"isError"`
+	}
+	if err := c.call(ctx, "tools/call", map[string]any{"name": name, "arguments": input}, &result); err != nil {
+		return err.Error(), true
+	}
+	var texts []string
+	for _, content := range result.Content {
+		switch content.Type {
+		case "text":
+			texts = append(texts, content.Text)
+		default:
+			texts = append(texts, fmt.Sprintf("[%s 内容: %s]", content.Type, content.MimeType))
+		}
+	}
+	return truncateOutput(strings.Join(texts, "\n")), result.IsError
+}
+
+// call 发送请求并等待对应 id 的响应
+func (c *mcpClient) call(ctx context.Context, method string, params any, result any) error {
+	c.mu.Lock()
+	c.nextID++
+	id := c.nextID
+	ch := make(chan mcpResponse, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	msg := map[string]any{"jsonrpc": "2.0", "id": id, "method": method}
+	if params != nil {
+		msg["params"] = params
+	}
+	if err := c.write(msg); err != nil {
+		return err
+	}
+
+	select {
+	case resp := <-ch:
+		if resp.Error != nil {
+			return fmt.Errorf("%s 失败: %s (code %d)", method, resp.Error.Message, resp.Error.Code)
+		}
+		if result != nil {
+			return json.Unmarshal(resp.Result, result)
+		}
+		return nil
+	case <-c.done:
+		return errors.New("MCP 服务器已退出")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// notify 发送不需要应答的通知
+func (c *mcpClient) notify(method string) error {
+	return c.write(map[string]any{"jsonrpc": "2.0", "method": method})
+}
+
+// write 以单行 JSON 写出消息
+func (c *mcpClient) write(msg any) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err = c.stdin.Write(append(data, '\n'))
+	return err
+}
+
+// readLoop 读取子进程的输出，把响应交给等待中的调用；服务器发来的请求一律回复不支持
+func (c *mcpClient) readLoop(r io.Reader) {
+	defer close(c.done)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 10<<20)
+	for scanner.Scan() {
+		var resp mcpResponse
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil || len(resp.ID) == 0 {
+			continue
+		}
+		if resp.Method != "" {
+			c.write(map[string]any{"jsonrpc": "2.0", "id": resp.ID, "error": jsonRPCError{Code: jsonRPCMethodNotFound, Message: "kiro2cc 不支持该方法: " + resp.Method}})
+			continue
+		}
+		var id int
+		if err := json.Unmarshal(resp.ID, &id); err != nil {
+			continue
+		}
+		c.mu.Lock()
+		ch, ok := c.pending[id]
+		c.mu.Unlock()
+		if ok {
+			ch <- resp
+		}
+	}
+}
+
+// close 关闭标准输入让服务器退出，超时后强制结束
+func (c *mcpClient) close() {
+	c.stdin.Close()
+	select {
+	case <-c.done:
+	case <-time.After(5 * time.Second):
+		c.cmd.Process.Kill()
+	}
+	c.cmd.Wait()
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/bestk/kiro2cc/parser"
+)
+
+// TestMCPHelperProcess 不是真正的测试，而是被 agent 作为 MCP 服务器子进程启动的假服务器
+func TestMCPHelperProcess(t *testing.T) {
+	if os.Getenv("KIRO2CC_MCP_HELPER") != "1" {
+		return
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var msg struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+			Params struct {
+				Cursor    string         `json:"cursor"`
+				Name      string         `json:"name"`
+				Arguments map[string]any `json:"arguments"`
+			} `json:"params"`
+		}
+		json.Unmarshal(scanner.Bytes(), &msg)
+		if len(msg.ID) == 0 {
+			continue
+		}
+		var result any
+		switch {
+		case msg.Method == "initialize":
+			// 握手期间向客户端发起请求，客户端应回复不支持而不是卡住
+			encoder.Encode(map[string]any{"jsonrpc": "2.0", "id": "srv-1", "method": "roots/list"})
+			result = map[string]any{"protocolVersion": mcpProtocolVersion, "capabilities": map[string]any{"tools": map[string]any{}}}
+		case msg.Method == "tools/list" && msg.Params.Cursor == "":
+			result = map[string]any{"tools": []any{map[string]any{"name": "echo", "description": "Echo text", "inputSchema": map[string]any{"type": "object"}}}, "nextCursor": "page2"}
+		case msg.Method == "tools/list":
+			result = map[string]any{"tools": []any{map[string]any{"name": "fail", "inputSchema": map[string]any{"type": "object"}}}}
+		case msg.Method == "tools/call" && msg.Params.Name == "echo":
+			result = map[string]any{"content": []any{map[string]any{"type": "text", "text": fmt.Sprint("echo: ", msg.Params.Arguments["text"])}}}
+		case msg.Method == "tools/call":
+			result = map[string]any{"content": []any{map[string]any{"type": "text", "text": "boom"}}, "isError": true}
+		}
+		encoder.Encode(map[string]any{"jsonrpc": "2.0", "id": msg.ID, "result": result})
+	}
+	os.Exit(0)
+}
+
+func mcpHelperCommand(t *testing.T) string {
+	t.Setenv("KIRO2CC_MCP_HELPER", "1")
+	if strings.ContainsAny(os.Args[0], " \t") {
+		t.Skip("测试二进制路径包含空白")
+	}
+	return os.Args[0] + " -test.run=^TestMCPHelperProcess$"
+}
+
+func TestAgentCallsMCPTools(t *testing.T) {
+	backend := &scriptedBackend{replies: [][]parser.SSEEvent{
+		append(toolUseEvents("t1", "echo", `{"text":"hi"}`), toolUseEvents("t2", "fail", `{}`)...),
+		textEvents("finished"),
+	}}
+	srv := newTestServer(t, Config{Backend: backend, AgentMCPServers: []string{mcpHelperCommand(t)}})
+	defer srv.Close()
+
+	rec := postAgent(t, srv, `{"model":"claude-sonnet-4-20250514","max_tokens":100,"messages":[{"role":"user","content":"echo hi"}]}`)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "finished") {
+		t.Fatalf("unexpected response: %d %s", rec.Code, rec.Body.String())
+	}
+
+	var names []string
+	for _, tool := range backend.reqs[0].Tools {
+		names = append(names, tool.Name)
+	}
+	if strings.Join(names, ",") != "echo,fail" {
+		t.Errorf("advertised tools = %v, want MCP tools from both pages", names)
+	}
+	if len(backend.reqs) != 2 {
+		t.Fatalf("backend called %d times, want 2", len(backend.reqs))
+	}
+	results := backend.reqs[1].Messages[len(backend.reqs[1].Messages)-1].Content.([]any)
+	if len(results) != 2 {
+		t.Fatalf("got %d tool results, want 2", len(results))
+	}
+	if r := results[0].(map[string]any); r["content"] != "echo: hi" || r["is_error"] != false {
+		t.Errorf("echo result = %v", r)
+	}
+	if r := results[1].(map[string]any); r["content"] != "boom" || r["is_error"] != true {
+		t.Errorf("fail result = %v", r)
+	}
+}
+
+func TestAgentMCPToolNameConflict(t *testing.T) {
+	command := mcpHelperCommand(t)
+	_, err := New(Config{TokenPath: t.TempDir() + "/token.json", Backend: &fakeBackend{}, AgentMCPServers: []string{command, command}})
+	if err == nil || !strings.Contains(err.Error(), "重名") {
+		t.Errorf("duplicate MCP tools should fail: %v", err)
+	}
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestServeMCPSampling(t *testing.T) {
+	backend := &fakeBackend{events: textEvents("Hi from Kiro")}
+	srv := newTestServer(t, Config{Backend: backend})
+
+	input := strings.Join([]string{
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2024-11-05","capabilities":{}}}`,
+		`{"jsonrpc":"2.0","method":"notifications/initialized"}`,
+		`{"jsonrpc":"2.0","id":2,"method":"sampling/createMessage","params":{"messages":[{"role":"user","content":{"type":"text","text":"hello"}}],"modelPreferences":{"hints":[{"name":"haiku"}]},"systemPrompt":"be brief","maxTokens":100}}`,
+		`{"jsonrpc":"2.0","id":3,"method":"tools/list"}`,
+	}, "\n")
+
+	var out bytes.Buffer
+	if err := srv.ServeMCP(context.Background(), strings.NewReader(input), &out); err != nil {
+		t.Fatalf("ServeMCP: %v", err)
+	}
+
+	responses := map[string]map[string]any{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var resp map[string]any
+		if err := json.Unmarshal([]byte(line), &resp); err != nil {
+			t.Fatalf("invalid response %q: %v", line, err)
+		}
+		id, _ := json.Marshal(resp["id"])
+		responses[string(id)] = resp
+	}
+	if len(responses) != 3 {
+		t.Fatalf("expected 3 responses, got %d: %s", len(responses), out.String())
+	}
+
+	result, _ := responses["2"]["result"].(map[string]any)
+	content, _ := result["content"].(map[string]any)
+	if content["text"] != "Hi from Kiro" || result["model"] != "claude-3-5-haiku-20241022" {
+		t.Fatalf("unexpected sampling result: %v", responses["2"])
+	}
+	if len(backend.reqs) != 1 || len(backend.reqs[0].System) != 1 || backend.reqs[0].System[0].Text != "be brief" {
+		t.Fatalf("unexpected backend request: %+v", backend.reqs)
+	}
+
+	rpcErr, _ := responses["3"]["error"].(map[string]any)
+	if rpcErr["code"] != float64(jsonRPCMethodNotFound) {
+		t.Fatalf("expected method not found, got %v", responses["3"])
+	}
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/bestk/kiro2cc/parser"
+	"github.com/bestk/kiro2cc/pkg/token"
+)
+
+// tokenMetrics 记录 token 刷新的结果，供 /metrics 导出
+type tokenMetrics struct {
+	mu          sync.Mutex
+	successes   int
+	failures    int
+	lastSuccess time.Time
+}
+
+// recordRefresh 记录一次刷新的结果
+func (m *tokenMetrics) recordRefresh(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		m.failures++
+		return
+	}
+	m.successes++
+	m.lastSuccess = time.Now()
+}
+
+// upstreamFormats 统计上游响应的格式，上游切换格式时在日志中提示，便于及早发现上游迁移
+type upstreamFormats struct {
+	mu     sync.Mutex
+	counts map[parser.Format]int
+	last   parser.Format
+}
+
+// record 记录一次上游响应的格式
+func (f *upstreamFormats) record(format parser.Format) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.counts == nil {
+		f.counts = make(map[parser.Format]int)
+	}
+	if f.last != "" && f.last != format {
+		fmt.Printf("注意: 上游响应格式从 %s 变为 %s\n", f.last, format)
+	}
+	f.last = format
+	f.counts[format]++
+}
+
+// handleMetrics 以 Prometheus 文本格式导出 token 生命周期指标，
+// 过期时间每次抓取时从 token 文件读取，读取失败或过期时间未知时不输出该指标
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	if s.config.Auth == AuthBearer {
+		fmt.Fprintln(w, "# HELP kiro2cc_token_expiry_seconds Seconds until the access token in the token file expires, negative once expired.")
+		fmt.Fprintln(w, "# TYPE kiro2cc_token_expiry_seconds gauge")
+		if data, err := token.Read(s.config.TokenPath); err == nil {
+			if expiresAt, ok := data.Expiry(); ok {
+				fmt.Fprintf(w, "kiro2cc_token_expiry_seconds %g\n", expiresAt.Sub(now).Seconds())
+			}
+		}
+	}
+
+	m := &s.tokenMetrics
+	m.mu.Lock()
+	successes, failures, lastSuccess := m.successes, m.failures, m.lastSuccess
+	m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP kiro2cc_token_refresh_total Token refresh attempts made by this process, by result.")
+	fmt.Fprintln(w, "# TYPE kiro2cc_token_refresh_total counter")
+	fmt.Fprintf(w, "kiro2cc_token_refresh_total{result=\"success\"} %d\n", successes)
+	fmt.Fprintf(w, "kiro2cc_token_refresh_total{result=\"failure\"} %d\n", failures)
+
+	// 还没有成功刷新过时不输出，告警规则可以用 absent() 区分
+	if !lastSuccess.IsZero() {
+		fmt.Fprintln(w, "# HELP kiro2cc_token_last_refresh_success_timestamp_seconds Unix time of the last successful token refresh.")
+		fmt.Fprintln(w, "# TYPE kiro2cc_token_last_refresh_success_timestamp_seconds gauge")
+		fmt.Fprintf(w, "kiro2cc_token_last_refresh_success_timestamp_seconds %d\n", lastSuccess.Unix())
+		fmt.Fprintln(w, "# HELP kiro2cc_token_seconds_since_last_refresh_success Seconds since the last successful token refresh.")
+		fmt.Fprintln(w, "# TYPE kiro2cc_token_seconds_since_last_refresh_success gauge")
+		fmt.Fprintf(w, "kiro2cc_token_seconds_since_last_refresh_success %g\n", now.Sub(lastSuccess).Seconds())
+	}
+
+	s.formats.mu.Lock()
+	formats := make(map[parser.Format]int, len(s.formats.counts))
+	for format, count := range s.formats.counts {
+		formats[format] = count
+	}
+	s.formats.mu.Unlock()
+	fmt.Fprintln(w, "# HELP kiro2cc_upstream_response_format_total Upstream responses decoded, by detected wire format.")
+	fmt.Fprintln(w, "# TYPE kiro2cc_upstream_response_format_total counter")
+	for _, format := range []parser.Format{parser.FormatEventStream, parser.FormatSSE, parser.FormatUnknown} {
+		fmt.Fprintf(w, "kiro2cc_upstream_response_format_total{format=%q} %d\n", format, formats[format])
+	}
+
+	// 与 Prometheus Go 客户端的同名指标含义一致，压测时用于观察内存和 goroutine 是否随负载持续增长
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	fmt.Fprintln(w, "# HELP go_memstats_heap_inuse_bytes Number of heap bytes that are in use.")
+	fmt.Fprintln(w, "# TYPE go_memstats_heap_inuse_bytes gauge")
+	fmt.Fprintf(w, "go_memstats_heap_inuse_bytes %d\n", mem.HeapInuse)
+	fmt.Fprintln(w, "# HELP go_goroutines Number of goroutines that currently exist.")
+	fmt.Fprintln(w, "# TYPE go_goroutines gauge")
+	fmt.Fprintf(w, "go_goroutines %d\n", runtime.NumGoroutine())
+}
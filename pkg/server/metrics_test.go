@@ -0,0 +1,90 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bestk/kiro2cc/pkg/token"
+)
+
+func scrapeMetrics(t *testing.T, srv *Server) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.RemoteAddr = "127.0.0.1:12345"
+	srv.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	return rec.Body.String()
+}
+
+func TestTokenMetrics(t *testing.T) {
+	fail := true
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			http.Error(w, "denied", http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"accessToken": "fresh", "refreshToken": "refresh", "expiresAt": time.Now().Add(time.Hour).Format(time.RFC3339)})
+	}))
+	defer auth.Close()
+	defer func(url string) { token.RefreshURL = url }(token.RefreshURL)
+	token.RefreshURL = auth.URL
+
+	srv := newTestServer(t, Config{})
+	body := scrapeMetrics(t, srv)
+	if strings.Contains(body, "\nkiro2cc_token_expiry_seconds ") || strings.Contains(body, "\nkiro2cc_token_seconds_since_last_refresh_success ") {
+		t.Errorf("token without expiresAt and no refresh yet:\n%s", body)
+	}
+
+	srv.refreshTokenSilently()
+	fail = false
+	srv.refreshTokenSilently()
+
+	body = scrapeMetrics(t, srv)
+	for _, want := range []string{
+		`kiro2cc_token_refresh_total{result="success"} 1`,
+		`kiro2cc_token_refresh_total{result="failure"} 1`,
+		"\nkiro2cc_token_expiry_seconds 3",
+		"\nkiro2cc_token_seconds_since_last_refresh_success ",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %q in\n%s", want, body)
+		}
+	}
+
+	// token 文件不可读时仍然导出刷新计数
+	os.Remove(srv.config.TokenPath)
+	if body := scrapeMetrics(t, srv); !strings.Contains(body, `result="success"} 1`) {
+		t.Errorf("metrics without token file:\n%s", body)
+	}
+}
+
+func TestUpstreamFormatMetrics(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"content\":\"ok\"}\n\n"))
+	}))
+	defer upstream.Close()
+	srv := newTestServer(t, Config{})
+	srv.backend.(*codeWhispererBackend).url = upstream.URL
+
+	if rec := postMessages(srv, hookTestBody); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	body := scrapeMetrics(t, srv)
+	for _, want := range []string{
+		`kiro2cc_upstream_response_format_total{format="sse"} 1`,
+		`kiro2cc_upstream_response_format_total{format="eventstream"} 0`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %q in\n%s", want, body)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// ModelInfo 描述模型的能力，通过 /v1/models 告诉客户端，校验请求时也以它为准
+type ModelInfo struct {
+	DisplayName string
+	// ContextWindow 是输入的最大 token 数，请求估算的输入超过它时按 Config.ContextOverflow 处理，0 表示不检查
+	ContextWindow int
+	// MaxOutputTokens 是单次回复的最大 token 数
+	MaxOutputTokens int
+	SupportsTools   bool
+	// SupportsVision 表示经过代理能否发送图片。CodeWhisperer 请求目前不携带图片，图片内容块会被丢弃
+	SupportsVision bool
+}
+
+// ModelInfos 是 ModelMap 中各模型的能力，新增模型时两处一起修改
+var ModelInfos = map[string]ModelInfo{
+	"claude-3-5-sonnet-20241022": {DisplayName: "Claude Sonnet 3.5 (New)", ContextWindow: 200000, MaxOutputTokens: 8192, SupportsTools: true},
+	"claude-3-5-sonnet-20240620": {DisplayName: "Claude Sonnet 3.5 (Old)", ContextWindow: 200000, MaxOutputTokens: 8192, SupportsTools: true},
+	"claude-3-5-haiku-20241022":  {DisplayName: "Claude Haiku 3.5", ContextWindow: 200000, MaxOutputTokens: 8192, SupportsTools: true},
+	"claude-3-opus-20240229":     {DisplayName: "Claude Opus 3", ContextWindow: 200000, MaxOutputTokens: 4096, SupportsTools: true},
+	"claude-3-sonnet-20240229":   {DisplayName: "Claude Sonnet 3", ContextWindow: 200000, MaxOutputTokens: 4096, SupportsTools: true},
+	"claude-3-haiku-20240307":    {DisplayName: "Claude Haiku 3", ContextWindow: 200000, MaxOutputTokens: 4096, SupportsTools: true},
+	"claude-sonnet-4-20250514":   {DisplayName: "Claude Sonnet 4", ContextWindow: 200000, MaxOutputTokens: 64000, SupportsTools: true},
+}
+
+// modelObject 是 /v1/models 返回的模型，type/id/display_name/created_at 与 Anthropic API 相同，其余是 kiro2cc 的扩展字段
+type modelObject struct {
+	Type            string `json:"type"`
+	ID              string `json:"id"`
+	DisplayName     string `json:"display_name"`
+	CreatedAt       string `json:"created_at"`
+	ContextWindow   int    `json:"context_window,omitempty"`
+	MaxOutputTokens int    `json:"max_output_tokens,omitempty"`
+	SupportsTools   bool   `json:"supports_tools"`
+	SupportsVision  bool   `json:"supports_vision"`
+}
+
+// newModelObject 按 ModelInfos 构造模型的描述，没有登记能力的模型只有名称和发布日期
+func newModelObject(model string) modelObject {
+	info := ModelInfos[model]
+	obj := modelObject{
+		Type:            "model",
+		ID:              model,
+		DisplayName:     info.DisplayName,
+		ContextWindow:   info.ContextWindow,
+		MaxOutputTokens: info.MaxOutputTokens,
+		SupportsTools:   info.SupportsTools,
+		SupportsVision:  info.SupportsVision,
+	}
+	if obj.DisplayName == "" {
+		obj.DisplayName = model
+	}
+	if created, err := time.Parse("20060102", modelDate(model)); err == nil {
+		obj.CreatedAt = created.Format(time.RFC3339)
+	}
+	return obj
+}
+
+// handleModels 处理 GET /v1/models，按发布日期从新到旧列出代理支持的模型
+func (s *Server) handleModels(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "只支持GET请求", http.StatusMethodNotAllowed)
+		return
+	}
+	models := make([]string, 0, len(ModelMap))
+	for model := range ModelMap {
+		models = append(models, model)
+	}
+	sort.Slice(models, func(i, j int) bool {
+		if di, dj := modelDate(models[i]), modelDate(models[j]); di != dj {
+			return di > dj
+		}
+		return models[i] < models[j]
+	})
+
+	data := make([]modelObject, len(models))
+	for i, model := range models {
+		data[i] = newModelObject(model)
+	}
+	resp := map[string]any{"data": data, "has_more": false, "first_id": nil, "last_id": nil}
+	if len(models) > 0 {
+		resp["first_id"], resp["last_id"] = models[0], models[len(models)-1]
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleModel 处理 GET /v1/models/{id}
+func (s *Server) handleModel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "只支持GET请求", http.StatusMethodNotAllowed)
+		return
+	}
+	model := r.PathValue("id")
+	if _, ok := ModelMap[model]; !ok {
+		sendJSONError(w, http.StatusNotFound, "not_found_error", fmt.Sprintf("model: %s", model))
+		return
+	}
+	writeJSON(w, http.StatusOK, newModelObject(model))
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestListModels(t *testing.T) {
+	srv := newTestServer(t, Config{Backend: &fakeBackend{}})
+
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/models", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	var list struct {
+		Data    []modelObject `json:"data"`
+		FirstID string        `json:"first_id"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Data) != len(ModelMap) {
+		t.Fatalf("got %d models, want %d", len(list.Data), len(ModelMap))
+	}
+	if list.FirstID != "claude-sonnet-4-20250514" || list.Data[0].CreatedAt != "2025-05-14T00:00:00Z" {
+		t.Errorf("newest model = %+v", list.Data[0])
+	}
+	for _, m := range list.Data {
+		if _, ok := ModelInfos[m.ID]; !ok {
+			t.Errorf("%s has no ModelInfos entry", m.ID)
+		}
+		if m.ContextWindow == 0 || !m.SupportsTools {
+			t.Errorf("%s: %+v", m.ID, m)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/models/claude-3-5-haiku-20241022", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"display_name":"Claude Haiku 3.5"`) {
+		t.Errorf("get model: %d %s", rec.Code, rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/models/gpt-4", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown model: %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestContextWindowExceeded(t *testing.T) {
+	backend := &fakeBackend{events: textEvents("ok")}
+	srv := newTestServer(t, Config{Backend: backend})
+
+	long := strings.Repeat("word ", ModelInfos["claude-3-haiku-20240307"].ContextWindow)
+	body, _ := json.Marshal(map[string]any{
+		"model":      "claude-3-haiku-20240307",
+		"max_tokens": 10,
+		"messages": []map[string]any{
+			{"role": "user", "content": []map[string]any{{"type": "text", "text": long}}},
+		},
+	})
+	rec := postMessages(srv, string(body))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "prompt is too long") {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(backend.reqs) != 0 {
+		t.Error("request reached the backend")
+	}
+
+	if rec := postMessages(srv, hookTestBody); rec.Code != http.StatusOK {
+		t.Errorf("short request: %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestContextWindowCountsInjectedSystem(t *testing.T) {
+	backend := &fakeBackend{events: textEvents("ok")}
+	// 客户端的请求本身很短，注入的 SystemPrefix 占满了上下文窗口
+	prefix := strings.Repeat("rule ", ModelInfos["claude-3-haiku-20240307"].ContextWindow)
+	srv := newTestServer(t, Config{Backend: backend, SystemPrefix: prefix})
+
+	body := `{"model":"claude-3-haiku-20240307","max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`
+	if rec := postMessages(srv, body); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "prompt is too long") {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(backend.reqs) != 0 {
+		t.Error("request reached the backend")
+	}
+}
+
+func TestContextOverflowTrim(t *testing.T) {
+	backend := &fakeBackend{events: textEvents("ok")}
+	srv := newTestServer(t, Config{Backend: backend, ContextOverflow: ContextOverflowTrim})
+
+	window := ModelInfos["claude-3-haiku-20240307"].ContextWindow
+	half := strings.Repeat("word ", window/2)
+	body, _ := json.Marshal(map[string]any{
+		"model":      "claude-3-haiku-20240307",
+		"max_tokens": 10,
+		"messages": []any{
+			map[string]any{"role": "user", "content": half},
+			map[string]any{"role": "assistant", "content": []any{map[string]any{"type": "tool_use", "id": "t1", "name": "read", "input": map[string]any{}}}},
+			map[string]any{"role": "user", "content": []any{map[string]any{"type": "tool_result", "tool_use_id": "t1", "content": half}}},
+			map[string]any{"role": "assistant", "content": "done"},
+			map[string]any{"role": "user", "content": "summarize"},
+		},
+	})
+	rec := postMessages(srv, string(body))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	// tool_use 和 tool_result 一起丢弃，从下一条普通用户消息开始
+	messages := backend.reqs[0].Messages
+	if len(messages) != 1 || messages[0].Content != "summarize" {
+		t.Errorf("trimmed messages = %+v", messages)
+	}
+
+	// 只剩最后一条消息仍然超出时返回错误
+	body, _ = json.Marshal(map[string]any{
+		"model":      "claude-3-haiku-20240307",
+		"max_tokens": 10,
+		"messages":   []any{map[string]any{"role": "user", "content": half + half + half}},
+	})
+	if rec := postMessages(srv, string(body)); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "prompt is too long") {
+		t.Errorf("status %d: %s", rec.Code, rec.Body.String())
+	}
+}
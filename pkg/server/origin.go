@@ -0,0 +1,28 @@
+package server
+
+// 发送给 CodeWhisperer 的消息来源
+const (
+	// OriginAIEditor 是 Kiro IDE 编辑器内对话使用的来源
+	OriginAIEditor = "AI_EDITOR"
+	// OriginChat 是独立聊天窗口使用的来源
+	OriginChat = "CHAT"
+)
+
+// originPolicy 根据请求特征选择消息来源，Kiro 对不同来源的额度和行为可能不同
+type originPolicy struct {
+	origin            string
+	toolFree          string
+	toolFreeMaxTokens int
+}
+
+// pick 返回请求应使用的来源：没有工具定义、且 max_tokens 不超过阈值的请求使用 toolFree
+func (p originPolicy) pick(anthropicReq AnthropicRequest) string {
+	if p.toolFree != "" && len(anthropicReq.Tools) == 0 &&
+		(p.toolFreeMaxTokens <= 0 || anthropicReq.MaxTokens <= p.toolFreeMaxTokens) {
+		return p.toolFree
+	}
+	if p.origin == "" {
+		return OriginAIEditor
+	}
+	return p.origin
+}
@@ -0,0 +1,39 @@
+package server
+
+import "testing"
+
+func TestOriginPolicy(t *testing.T) {
+	withTools := AnthropicRequest{MaxTokens: 100, Tools: []AnthropicTool{{Name: "read_file"}}}
+	small := AnthropicRequest{MaxTokens: 100}
+	large := AnthropicRequest{MaxTokens: 8000}
+
+	tests := []struct {
+		name   string
+		policy originPolicy
+		req    AnthropicRequest
+		want   string
+	}{
+		{"default", originPolicy{}, small, OriginAIEditor},
+		{"configured", originPolicy{origin: "CLI"}, small, "CLI"},
+		{"tool free", originPolicy{toolFree: OriginChat}, large, OriginChat},
+		{"tools keep default", originPolicy{toolFree: OriginChat}, withTools, OriginAIEditor},
+		{"under threshold", originPolicy{toolFree: OriginChat, toolFreeMaxTokens: 1024}, small, OriginChat},
+		{"over threshold", originPolicy{toolFree: OriginChat, toolFreeMaxTokens: 1024}, large, OriginAIEditor},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.pick(tt.req); got != tt.want {
+			t.Errorf("%s: pick = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	cwReq := buildCodeWhispererRequest(AnthropicRequest{
+		Model:    "claude-sonnet-4-20250514",
+		Messages: []AnthropicRequestMessage{{Role: "user", Content: "a"}, {Role: "assistant", Content: "b"}, {Role: "user", Content: "c"}},
+	}, DefaultProfileArn, OriginChat)
+	if got := cwReq.ConversationState.CurrentMessage.UserInputMessage.Origin; got != OriginChat {
+		t.Errorf("current message origin = %q", got)
+	}
+	if user, ok := cwReq.ConversationState.History[0].(HistoryUserMessage); !ok || user.UserInputMessage.Origin != OriginChat {
+		t.Errorf("history origin = %+v", cwReq.ConversationState.History[0])
+	}
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+)
+
+// PolicyError 表示请求被代理的访问策略拒绝，例如租户不允许使用的模型或超出预算，
+// 不会发往上游
+type PolicyError struct {
+	Status  int
+	Type    string
+	Code    ErrorCode
+	Message string
+}
+
+func (e *PolicyError) Error() string {
+	return e.Message
+}
+
+// grpcCode 返回对应的 gRPC 状态码
+func (e *PolicyError) grpcCode() codes.Code {
+	switch e.Status {
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	}
+	return codes.InvalidArgument
+}
+
+// modelListed 判断模型是否在列表中，列表项可以是完整模型名或模型名的一部分，例如 sonnet
+func modelListed(list []string, model string) bool {
+	for _, item := range list {
+		if item == model || (item != "" && strings.Contains(model, item)) {
+			return true
+		}
+	}
+	return false
+}
+
+// ModelAccess 限制一个客户端 API key 可用的模型，列表项可以是完整模型名或模型名的一部分
+type ModelAccess struct {
+	// Allow 不为空时只允许其中的模型
+	Allow []string
+	// Deny 中的模型总是被拒绝，优先于 Allow
+	Deny []string
+}
+
+// allows 判断是否允许使用该模型
+func (a ModelAccess) allows(model string) bool {
+	if modelListed(a.Deny, model) {
+		return false
+	}
+	return len(a.Allow) == 0 || modelListed(a.Allow, model)
+}
+
+// ParseKeyModels 解析 "key1=haiku,key1=sonnet,key2=!opus" 形式的按 API key 模型限制，
+// 同一个 key 可以出现多次，以 ! 开头的模型加入 Deny，其他加入 Allow
+func ParseKeyModels(value string) (map[string]ModelAccess, error) {
+	access := make(map[string]ModelAccess)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		key, model, ok := strings.Cut(item, "=")
+		key, model = strings.TrimSpace(key), strings.TrimSpace(model)
+		deny := strings.HasPrefix(model, "!")
+		model = strings.TrimSpace(strings.TrimPrefix(model, "!"))
+		if !ok || key == "" || model == "" {
+			// 不在错误中回显内容，避免 API key 出现在日志里
+			return nil, errors.New("无效的模型限制，格式应为 API key=模型 或 API key=!模型")
+		}
+		a := access[key]
+		if deny {
+			a.Deny = append(a.Deny, model)
+		} else {
+			a.Allow = append(a.Allow, model)
+		}
+		access[key] = a
+	}
+	return access, nil
+}
+
+// checkModelAccess 按客户端 API key 的 KeyModels 设置检查请求的模型，拒绝时返回 *PolicyError
+func (s *Server) checkModelAccess(ctx context.Context, model string) error {
+	key := apiKeyFrom(ctx)
+	if key == "" {
+		return nil
+	}
+	access, ok := s.config.KeyModels[key]
+	if !ok || access.allows(model) {
+		return nil
+	}
+	message := fmt.Sprintf("当前 API key 不允许使用模型 %s", model)
+	if len(access.Allow) > 0 {
+		message += "，可用的模型: " + strings.Join(access.Allow, ", ")
+	}
+	return &PolicyError{Status: http.StatusForbidden, Type: "permission_error", Code: CodeModelNotAllowed, Message: message}
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestKeyModels(t *testing.T) {
+	srv := newTestServer(t, Config{
+		Backend: &fakeBackend{events: textEvents("ok")},
+		APIKeys: []string{"intern-key", "contractor-key", "staff-key"},
+		KeyModels: map[string]ModelAccess{
+			"intern-key":     {Allow: []string{"haiku"}},
+			"contractor-key": {Deny: []string{"sonnet-4"}},
+		},
+	})
+
+	// hookTestBody 请求 claude-sonnet-4-20250514
+	for _, tc := range []struct {
+		key  string
+		want int
+	}{
+		{"intern-key", http.StatusForbidden},
+		{"contractor-key", http.StatusForbidden},
+		{"staff-key", http.StatusOK},
+	} {
+		rec := postWithKey(srv, tc.key)
+		if rec.Code != tc.want {
+			t.Errorf("%s: status %d, want %d: %s", tc.key, rec.Code, tc.want, rec.Body.String())
+		}
+		if tc.want == http.StatusForbidden && (!strings.Contains(rec.Body.String(), "permission_error") || !strings.Contains(rec.Body.String(), string(CodeModelNotAllowed))) {
+			t.Errorf("%s: body %s", tc.key, rec.Body.String())
+		}
+	}
+}
+
+func TestParseKeyModels(t *testing.T) {
+	access, err := ParseKeyModels("k1=haiku, k1=sonnet-3-5 ,k2=!opus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]ModelAccess{
+		"k1": {Allow: []string{"haiku", "sonnet-3-5"}},
+		"k2": {Deny: []string{"opus"}},
+	}
+	if !reflect.DeepEqual(access, want) {
+		t.Errorf("ParseKeyModels = %+v, want %+v", access, want)
+	}
+	for _, bad := range []string{"k1", "=haiku", "k1=!", "k1="} {
+		if _, err := ParseKeyModels(bad); err == nil {
+			t.Errorf("ParseKeyModels(%q) accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// preflightTimeout 是启动预检的总期限
+const preflightTimeout = 30 * time.Second
+
+// Preflight 在接受流量之前验证 token 和上游：读取 token 文件 (SigV4 模式下为 AWS 凭证) 并用它调用一次
+// ListAvailableProfiles，token 失效时先刷新再重试，让配置问题在启动时暴露，而不是等到第一个真实请求。
+// 使用自定义 Backend 时不做检查。Start 会在监听前自动调用，除非设置了 SkipPreflight
+func (s *Server) Preflight(ctx context.Context) error {
+	if s.config.Backend != nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, preflightTimeout)
+	defer cancel()
+
+	profiles, err := s.preflightProfiles(ctx)
+	var upErr *UpstreamError
+	if errors.As(err, &upErr) && s.config.Auth == AuthBearer && (upErr.StatusCode == http.StatusUnauthorized || upErr.StatusCode == http.StatusForbidden) {
+		fmt.Printf("预检: token 无效 (状态码 %d)，尝试刷新\n", upErr.StatusCode)
+		if refreshErr := s.refreshTokenSilently(); refreshErr != nil {
+			return fmt.Errorf("预检失败: token 无效且刷新失败: %v", refreshErr)
+		}
+		profiles, err = s.preflightProfiles(ctx)
+	}
+	if err != nil {
+		return fmt.Errorf("预检失败: %v", err)
+	}
+
+	found := false
+	for _, profile := range profiles {
+		if profile.Arn == s.config.ProfileArn {
+			found = true
+			break
+		}
+	}
+	if !found && len(profiles) > 0 {
+		fmt.Printf("警告: ProfileArn %s 不在当前账号可用的 profile 中，可运行 profile 命令查询\n", s.config.ProfileArn)
+	}
+	fmt.Printf("预检通过: 凭证有效，账号有 %d 个可用 profile\n", len(profiles))
+	return nil
+}
+
+// preflightProfiles 用当前的认证方式列出可用的 profile
+func (s *Server) preflightProfiles(ctx context.Context) ([]Profile, error) {
+	return listProfiles(ctx, http.DefaultClient, s.profilesURL, s.auth)
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/bestk/kiro2cc/pkg/token"
+)
+
+func TestPreflightRefreshesInvalidToken(t *testing.T) {
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"accessToken": "fresh", "refreshToken": "refresh"})
+	}))
+	defer auth.Close()
+	defer func(url string) { token.RefreshURL = url }(token.RefreshURL)
+	token.RefreshURL = auth.URL
+
+	var calls int
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.Header.Get("Authorization") != "Bearer fresh" {
+			http.Error(w, `{"message":"expired"}`, http.StatusForbidden)
+			return
+		}
+		json.NewEncoder(w).Encode(listProfilesResponse{Profiles: []Profile{{Arn: DefaultProfileArn}}})
+	}))
+	defer upstream.Close()
+
+	srv := newTestServer(t, Config{})
+	srv.profilesURL = upstream.URL
+	if err := srv.Preflight(context.Background()); err != nil {
+		t.Fatalf("Preflight: %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("upstream called %d times, want 2", calls)
+	}
+}
+
+func TestPreflightFailures(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}))
+	defer upstream.Close()
+
+	srv := newTestServer(t, Config{})
+	srv.profilesURL = upstream.URL
+	if err := srv.Preflight(context.Background()); err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("upstream failure: err = %v", err)
+	}
+
+	os.Remove(srv.config.TokenPath)
+	if err := srv.Preflight(context.Background()); err == nil || !strings.Contains(err.Error(), "token") {
+		t.Errorf("missing token: err = %v", err)
+	}
+
+	// 自定义 Backend 不做预检
+	srv = newTestServer(t, Config{Backend: &fakeBackend{}})
+	srv.profilesURL = upstream.URL
+	if err := srv.Preflight(context.Background()); err != nil {
+		t.Errorf("custom backend: err = %v", err)
+	}
+}
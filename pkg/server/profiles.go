@@ -0,0 +1,106 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/bestk/kiro2cc/pkg/token"
+)
+
+// ListProfilesURL 是 CodeWhisperer ListAvailableProfiles 接口地址
+const ListProfilesURL = "https://codewhisperer.us-east-1.amazonaws.com/ListAvailableProfiles"
+
+// Profile 表示当前账号可用的 CodeWhisperer profile
+type Profile struct {
+	Arn         string `json:"arn"`
+	ProfileName string `json:"profileName"`
+}
+
+// listProfilesRequest ListAvailableProfiles 的请求结构
+type listProfilesRequest struct {
+	MaxResults int    `json:"maxResults"`
+	NextToken  string `json:"nextToken,omitempty"`
+}
+
+// listProfilesResponse ListAvailableProfiles 的响应结构
+type listProfilesResponse struct {
+	Profiles  []Profile `json:"profiles"`
+	NextToken string    `json:"nextToken"`
+}
+
+// ListProfiles 使用 token 文件中的 access token 列出账号可用的所有 profile
+func ListProfiles(ctx context.Context, tokenPath string) ([]Profile, error) {
+	tokenData, err := token.Read(tokenPath)
+	if err != nil {
+		return nil, err
+	}
+	return listProfiles(ctx, http.DefaultClient, ListProfilesURL, staticBearer(tokenData.AccessToken))
+}
+
+// staticBearer 使用已读取的 access token 认证
+type staticBearer string
+
+func (t staticBearer) authorize(req *http.Request, body []byte) error {
+	req.Header.Set("Authorization", "Bearer "+string(t))
+	return nil
+}
+
+// DiscoverProfileArn 返回账号的第一个可用 profile 的 ARN，避免猜测或沿用内置的 ARN
+func DiscoverProfileArn(ctx context.Context, tokenPath string) (string, error) {
+	profiles, err := ListProfiles(ctx, tokenPath)
+	if err != nil {
+		return "", err
+	}
+	if len(profiles) == 0 {
+		return "", fmt.Errorf("当前账号没有可用的 CodeWhisperer profile")
+	}
+	return profiles[0].Arn, nil
+}
+
+// listProfiles 逐页请求 ListAvailableProfiles
+func listProfiles(ctx context.Context, client *http.Client, url string, auth upstreamAuth) ([]Profile, error) {
+	var profiles []Profile
+	nextToken := ""
+	for {
+		reqBody, err := json.Marshal(listProfilesRequest{MaxResults: 10, NextToken: nextToken})
+		if err != nil {
+			return nil, fmt.Errorf("序列化请求失败: %v", err)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(reqBody))
+		if err != nil {
+			return nil, fmt.Errorf("创建请求失败: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "kiro2cc/1.0")
+		if err := auth.authorize(req, reqBody); err != nil {
+			return nil, err
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("查询 profile 失败: %v", err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("读取响应失败: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, newUpstreamError(resp, body)
+		}
+
+		var page listProfilesResponse
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, fmt.Errorf("解析 profile 列表失败: %v", err)
+		}
+		profiles = append(profiles, page.Profiles...)
+		if page.NextToken == "" {
+			return profiles, nil
+		}
+		nextToken = page.NextToken
+	}
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestListProfilesFollowsPages(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer access" {
+			t.Errorf("Authorization = %q", got)
+		}
+		var req listProfilesRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		switch req.NextToken {
+		case "":
+			json.NewEncoder(w).Encode(listProfilesResponse{
+				Profiles:  []Profile{{Arn: "arn:aws:codewhisperer:us-east-1:111111111111:profile/FIRST", ProfileName: "first"}},
+				NextToken: "page2",
+			})
+		case "page2":
+			json.NewEncoder(w).Encode(listProfilesResponse{
+				Profiles: []Profile{{Arn: "arn:aws:codewhisperer:eu-central-1:111111111111:profile/SECOND", ProfileName: "second"}},
+			})
+		default:
+			t.Errorf("unexpected nextToken %q", req.NextToken)
+		}
+	}))
+	defer upstream.Close()
+
+	profiles, err := listProfiles(context.Background(), upstream.Client(), upstream.URL, staticBearer("access"))
+	if err != nil {
+		t.Fatalf("listProfiles: %v", err)
+	}
+	if len(profiles) != 2 || profiles[1].ProfileName != "second" {
+		t.Fatalf("profiles = %+v", profiles)
+	}
+}
+
+func TestListProfilesUpstreamError(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message":"expired"}`, http.StatusForbidden)
+	}))
+	defer upstream.Close()
+
+	_, err := listProfiles(context.Background(), upstream.Client(), upstream.URL, staticBearer("access"))
+	upstreamErr, ok := err.(*UpstreamError)
+	if !ok || upstreamErr.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected 403 UpstreamError, got %v", err)
+	}
+}
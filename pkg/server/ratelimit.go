@@ -0,0 +1,113 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bestk/kiro2cc/pkg/redis"
+)
+
+// rateLimiterSweepSize 是令牌桶数量超过多少时清理已经回满的桶
+const rateLimiterSweepSize = 1024
+
+// ParseRateLimits 解析 "free=10,pro=120,*=30" 形式的按档位每分钟请求数
+func ParseRateLimits(value string) (map[string]int, error) {
+	limits := make(map[string]int)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		tier, raw, ok := strings.Cut(item, "=")
+		tier = strings.TrimSpace(tier)
+		if !ok || tier == "" {
+			return nil, fmt.Errorf("无效的限流设置 %q，格式应为 档位=每分钟请求数", item)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("档位 %s 的每分钟请求数 %q 不是整数", tier, raw)
+		}
+		limits[tier] = n
+	}
+	return limits, nil
+}
+
+// tokenBucket 是一个身份的令牌桶，容量为每分钟请求数，按相同速率回填
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// rateLimiter 按身份限制请求频率，档位的每分钟请求数来自 Config.RateLimits，
+// 未列出的档位使用 "*"，两者都没有时不限制。redis 不为空时计数保存在 Redis 中，所有副本共享
+type rateLimiter struct {
+	limits      map[string]int
+	now         func() time.Time
+	redis       *redis.Client
+	redisPrefix string
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func newRateLimiter(limits map[string]int) *rateLimiter {
+	return &rateLimiter{limits: limits, now: time.Now, buckets: make(map[string]*tokenBucket)}
+}
+
+// allow 消耗身份的一个令牌，超出限制时返回还需等待的时长。未开启限流或未认证的请求总是允许
+func (rl *rateLimiter) allow(identity *Identity) (time.Duration, bool) {
+	if rl == nil || identity == nil {
+		return 0, true
+	}
+	limit, ok := rl.limits[identity.tier()]
+	if !ok {
+		limit, ok = rl.limits["*"]
+	}
+	if !ok {
+		return 0, true
+	}
+	perSecond := float64(limit) / 60
+	// 不同档位的同名身份分开计数，档位变化后重新开始
+	key := identity.Provider + "/" + identity.tier() + "/" + identity.Subject
+	if rl.redis != nil {
+		retryAfter, ok, err := rl.allowShared(key, limit)
+		if err == nil {
+			return retryAfter, ok
+		}
+		fmt.Printf("共享限流计数失败，改用本副本的令牌桶: %v\n", err)
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := rl.now()
+	if len(rl.buckets) > rateLimiterSweepSize {
+		rl.sweepLocked(now)
+	}
+	bucket, ok := rl.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(limit), updated: now}
+		rl.buckets[key] = bucket
+	}
+	bucket.tokens = min(float64(limit), bucket.tokens+now.Sub(bucket.updated).Seconds()*perSecond)
+	bucket.updated = now
+	if bucket.tokens < 1 {
+		if perSecond <= 0 {
+			return time.Minute, false
+		}
+		return time.Duration((1 - bucket.tokens) / perSecond * float64(time.Second)), false
+	}
+	bucket.tokens--
+	return 0, true
+}
+
+// sweepLocked 删除空闲超过一分钟的桶，这些桶已经回满，删除后与新建的桶相同
+func (rl *rateLimiter) sweepLocked(now time.Time) {
+	for key, bucket := range rl.buckets {
+		if now.Sub(bucket.updated) > time.Minute {
+			delete(rl.buckets, key)
+		}
+	}
+}
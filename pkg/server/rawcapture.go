@@ -0,0 +1,96 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRawCaptureLimit 是最多保留的上游原始响应文件数
+	DefaultRawCaptureLimit = 20
+	// DefaultRawCaptureMaxBytes 是单个原始响应文件的大小上限，超出部分不保存
+	DefaultRawCaptureMaxBytes = 4 << 20
+	// DefaultRawCaptureRetention 是原始响应文件的保留时长
+	DefaultRawCaptureRetention = 7 * 24 * time.Hour
+	// rawCaptureDirName 是 StateDir 中保存原始响应的子目录
+	rawCaptureDirName = "raw-captures"
+)
+
+// rawCaptureStore 在解析上游响应失败时把原始字节写入 StateDir，数量、大小和保留时长都有上限，
+// 事后可以据此排查上游格式的变化
+type rawCaptureStore struct {
+	dir       string
+	limit     int
+	maxBytes  int
+	retention time.Duration
+	now       func() time.Time
+
+	mu sync.Mutex
+}
+
+// save 写入一份原始响应并清理过期和超出数量的文件，返回文件路径
+func (rc *rawCaptureStore) save(requestID string, raw []byte) (string, error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if err := os.MkdirAll(rc.dir, 0700); err != nil {
+		return "", err
+	}
+	if len(raw) > rc.maxBytes {
+		raw = raw[:rc.maxBytes]
+	}
+	now := rc.now().UTC()
+	if requestID == "" {
+		requestID = "unknown"
+	}
+	// 文件名以时间开头，按名称排序即按时间排序
+	name := fmt.Sprintf("%s-%09d-%s.raw", now.Format("20060102-150405"), now.Nanosecond(), requestID)
+	path := filepath.Join(rc.dir, name)
+	if err := os.WriteFile(path, raw, 0600); err != nil {
+		return "", err
+	}
+	rc.pruneLocked(now)
+	return path, nil
+}
+
+// pruneLocked 删除超过保留时长的文件，再按时间从旧到新删除超出数量上限的文件，调用方需持有锁
+func (rc *rawCaptureStore) pruneLocked(now time.Time) {
+	entries, err := os.ReadDir(rc.dir)
+	if err != nil {
+		return
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".raw") {
+			continue
+		}
+		if info, err := entry.Info(); err == nil && now.Sub(info.ModTime()) > rc.retention {
+			os.Remove(filepath.Join(rc.dir, entry.Name()))
+			continue
+		}
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	for len(names) > rc.limit {
+		os.Remove(filepath.Join(rc.dir, names[0]))
+		names = names[1:]
+	}
+}
+
+// newRawCaptureStore 按配置创建原始响应存储，没有 StateDir 或 RawCaptureLimit 小于 0 时返回 nil
+func newRawCaptureStore(config Config) *rawCaptureStore {
+	if config.StateDir == "" || config.RawCaptureLimit < 0 {
+		return nil
+	}
+	return &rawCaptureStore{
+		dir:       filepath.Join(config.StateDir, rawCaptureDirName),
+		limit:     config.RawCaptureLimit,
+		maxBytes:  DefaultRawCaptureMaxBytes,
+		retention: config.RawCaptureRetention,
+		now:       time.Now,
+	}
+}
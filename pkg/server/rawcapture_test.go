@@ -0,0 +1,60 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRawCaptureOnParseFailure(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("data: {not json}\n\n"))
+	}))
+	defer upstream.Close()
+	stateDir := t.TempDir()
+	srv := newTestServer(t, Config{StateDir: stateDir})
+	srv.backend.(*codeWhispererBackend).url = upstream.URL
+
+	postMessages(srv, hookTestBody)
+
+	files, err := filepath.Glob(filepath.Join(stateDir, rawCaptureDirName, "*.raw"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("captures = %v, %v", files, err)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil || string(data) != "data: {not json}\n\n" {
+		t.Errorf("capture = %q, %v", data, err)
+	}
+}
+
+func TestRawCapturePrune(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	rc := &rawCaptureStore{dir: t.TempDir(), limit: 2, maxBytes: 4, retention: time.Hour, now: func() time.Time { return now }}
+
+	stale, err := rc.save("msg_stale", []byte("old"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(stale, now.Add(-2*time.Hour), now.Add(-2*time.Hour))
+	var paths []string
+	for _, id := range []string{"msg_1", "msg_2", "msg_3"} {
+		now = now.Add(time.Second)
+		path, err := rc.save(id, []byte("0123456789"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	for _, path := range []string{stale, paths[0]} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s not pruned", filepath.Base(path))
+		}
+	}
+	if data, err := os.ReadFile(paths[2]); err != nil || string(data) != "0123" {
+		t.Errorf("latest capture = %q, %v", data, err)
+	}
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/bestk/kiro2cc/pkg/redis"
+)
+
+// redisRateLimitPrefix 是限流计数的键前缀，键为 <前缀><身份>:<分钟的 Unix 时间>
+const redisRateLimitPrefix = "kiro2cc:ratelimit:"
+
+// redisUsagePrefix 是租户每天用量的键前缀，键为 <前缀><租户>:<日期>:tokens 或 :requests
+const redisUsagePrefix = "kiro2cc:usage:"
+
+// redisUsageTTL 是租户用量键的保留时间，覆盖当天即可
+const redisUsageTTL = 48 * time.Hour
+
+// redisCounterTimeout 是一次计数操作的期限，Redis 不可用时不会拖住请求
+const redisCounterTimeout = 2 * time.Second
+
+// allowShared 在 Redis 中按分钟计数，负载均衡后的多个副本共用同一个窗口。
+// 与本地令牌桶不同，窗口交界处短时间内最多允许两倍的请求
+func (rl *rateLimiter) allowShared(key string, limit int) (time.Duration, bool, error) {
+	now := rl.now()
+	window := now.Truncate(time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), redisCounterTimeout)
+	defer cancel()
+	count, err := rl.redis.IncrBy(ctx, rl.redisPrefix+key+":"+strconv.FormatInt(window.Unix(), 10), 1, 2*time.Minute)
+	if err != nil {
+		return 0, false, err
+	}
+	if count > int64(limit) {
+		return window.Add(time.Minute).Sub(now), false, nil
+	}
+	return 0, true, nil
+}
+
+// redisUsageKey 返回租户当天某项用量的键
+func redisUsageKey(state *tenantState, field string) string {
+	return redisUsagePrefix + state.tenant.Name + ":" + state.day + ":" + field
+}
+
+// sharedUsage 读取所有副本累计的租户当天用量
+func (ts *tenantStore) sharedUsage(state *tenantState, field string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisCounterTimeout)
+	defer cancel()
+	value, err := ts.redis.Get(ctx, redisUsageKey(state, field))
+	if errors.Is(err, redis.ErrNil) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// addSharedUsage 把用量累加到 Redis 并返回所有副本累计的值
+func (ts *tenantStore) addSharedUsage(state *tenantState, field string, n int64) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisCounterTimeout)
+	defer cancel()
+	return ts.redis.IncrBy(ctx, redisUsageKey(state, field), n, redisUsageTTL)
+}
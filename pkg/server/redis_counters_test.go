@@ -0,0 +1,78 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/bestk/kiro2cc/pkg/redis/redistest"
+)
+
+func TestRateLimitsSharedAcrossReplicas(t *testing.T) {
+	redisSrv := redistest.NewServer(t)
+	now := time.Date(2025, 6, 1, 12, 0, 30, 0, time.UTC)
+	replica := func() *Server {
+		srv := newTestServer(t, Config{
+			Backend:    &fakeBackend{events: textEvents("ok")},
+			ClientAuth: []ClientAuthProvider{tierProvider{}},
+			RateLimits: map[string]int{"free": 2},
+			RedisURL:   redisSrv.URL(),
+		})
+		srv.limiter.now = func() time.Time { return now }
+		return srv
+	}
+	replicaA, replicaB := replica(), replica()
+
+	if rec := postWithKey(replicaA, "alice:free"); rec.Code != http.StatusOK {
+		t.Fatalf("replica A: %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := postWithKey(replicaB, "alice:free"); rec.Code != http.StatusOK {
+		t.Fatalf("replica B: %d %s", rec.Code, rec.Body.String())
+	}
+	rec := postWithKey(replicaA, "alice:free")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "30" {
+		t.Fatalf("limit should count requests on both replicas: %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	// 下一分钟重新计数
+	now = now.Add(time.Minute)
+	if rec := postWithKey(replicaB, "alice:free"); rec.Code != http.StatusOK {
+		t.Fatalf("next window: %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestTenantLimitsSharedAcrossReplicas(t *testing.T) {
+	redisSrv := redistest.NewServer(t)
+	now := time.Date(2025, 6, 1, 12, 0, 30, 0, time.UTC)
+	replica := func() *Server {
+		srv := newTestServer(t, Config{
+			Backend: &fakeBackend{events: textEvents("ok")},
+			Tenants: []Tenant{
+				{Name: "team-a", APIKeys: []string{"sk-a"}, RateLimit: 1},
+				{Name: "team-b", APIKeys: []string{"sk-b"}, DailyTokenBudget: 1},
+			},
+			RedisURL: redisSrv.URL(),
+		})
+		srv.tenants.now = func() time.Time { return now }
+		srv.tenants.tenants["team-a"].limiter.now = srv.tenants.now
+		return srv
+	}
+	replicaA, replicaB := replica(), replica()
+
+	if rec := postWithKey(replicaA, "sk-a"); rec.Code != http.StatusOK {
+		t.Fatalf("team-a on replica A: %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := postWithKey(replicaB, "sk-a"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("tenant rate limit should be shared: %d %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := postWithKey(replicaA, "sk-b"); rec.Code != http.StatusOK {
+		t.Fatalf("team-b on replica A: %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := postWithKey(replicaB, "sk-b"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("daily budget should be shared: %d %s", rec.Code, rec.Body.String())
+	}
+	if info := replicaB.tenants.list()[1]; info.Usage.Requests != 1 || info.Usage.Tokens < 1 {
+		t.Fatalf("usage = %+v", info.Usage)
+	}
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bestk/kiro2cc/pkg/redis"
+)
+
+// redisSessionTTL 是会话映射在 Redis 中的保留时间，每次使用后重新计时
+const redisSessionTTL = 7 * 24 * time.Hour
+
+// redisSessionPrefix 是会话映射的键前缀
+const redisSessionPrefix = "kiro2cc:session:"
+
+// redisSessionStore 把会话映射保存在 Redis 中，负载均衡后的多个副本共享同一个 conversationId
+type redisSessionStore struct {
+	client *redis.Client
+}
+
+func (rs *redisSessionStore) conversationID(ctx context.Context, fingerprint string) (string, error) {
+	key := redisSessionPrefix + fingerprint
+	entry := sessionEntry{Fingerprint: fingerprint, ConversationID: generateUUID(), LastUsed: time.Now()}
+
+	// SET NX 保证并发的副本对同一个新会话得到相同的 ID
+	data, _ := json.Marshal(entry)
+	created, err := rs.client.SetNX(ctx, key, string(data), redisSessionTTL)
+	if err != nil {
+		return "", err
+	}
+	if created {
+		return entry.ConversationID, nil
+	}
+
+	value, err := rs.client.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	if err := json.Unmarshal([]byte(value), &entry); err != nil {
+		return "", fmt.Errorf("解析会话 %s 失败: %v", fingerprint, err)
+	}
+	entry.LastUsed = time.Now()
+	data, _ = json.Marshal(entry)
+	if err := rs.client.Set(ctx, key, string(data), redisSessionTTL); err != nil {
+		return "", err
+	}
+	return entry.ConversationID, nil
+}
+
+func (rs *redisSessionStore) export(ctx context.Context) ([]sessionEntry, error) {
+	keys, err := rs.client.Scan(ctx, redisSessionPrefix+"*")
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]sessionEntry, 0, len(keys))
+	for _, key := range keys {
+		value, err := rs.client.Get(ctx, key)
+		if errors.Is(err, redis.ErrNil) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var entry sessionEntry
+		if err := json.Unmarshal([]byte(value), &entry); err != nil {
+			fmt.Printf("跳过无法解析的会话 %s: %v\n", strings.TrimPrefix(key, redisSessionPrefix), err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	sortSessions(entries)
+	return entries, nil
+}
+
+func (rs *redisSessionStore) merge(ctx context.Context, entries []sessionEntry) (int, error) {
+	imported := 0
+	for _, entry := range entries {
+		if entry.Fingerprint == "" || entry.ConversationID == "" {
+			continue
+		}
+		key := redisSessionPrefix + entry.Fingerprint
+		if value, err := rs.client.Get(ctx, key); err == nil {
+			var existing sessionEntry
+			if json.Unmarshal([]byte(value), &existing) == nil && existing.LastUsed.After(entry.LastUsed) {
+				continue
+			}
+		} else if !errors.Is(err, redis.ErrNil) {
+			return imported, err
+		}
+		data, _ := json.Marshal(entry)
+		if err := rs.client.Set(ctx, key, string(data), redisSessionTTL); err != nil {
+			return imported, err
+		}
+		imported++
+	}
+	return imported, nil
+}
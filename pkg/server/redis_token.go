@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/bestk/kiro2cc/pkg/redis"
+	"github.com/bestk/kiro2cc/pkg/token"
+)
+
+// Redis 中 token 刷新使用的键
+const (
+	redisTokenLockKey = "kiro2cc:token-refresh-lock"
+	redisTokenKey     = "kiro2cc:token"
+)
+
+// redisTokenLockTTL 是刷新锁的过期时间，持有者崩溃后锁会自动释放
+const redisTokenLockTTL = 30 * time.Second
+
+// redisTokenCoordinator 通过 Redis 选出一个副本刷新 token，
+// 刷新结果写入 Redis，不共享 token 文件的副本也能读取
+type redisTokenCoordinator struct {
+	client *redis.Client
+}
+
+func (rc *redisTokenCoordinator) Lock(ctx context.Context) (func(), error) {
+	owner := generateUUID()
+	for {
+		ok, err := rc.client.SetNX(ctx, redisTokenLockKey, owner, redisTokenLockTTL)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return func() {
+				// 只释放自己持有的锁；锁已过期并被其他副本持有时不做处理
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				if current, err := rc.client.Get(ctx, redisTokenLockKey); err == nil && current == owner {
+					rc.client.Del(ctx, redisTokenLockKey)
+				}
+			}, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+func (rc *redisTokenCoordinator) Latest(ctx context.Context) (token.Data, bool, error) {
+	value, err := rc.client.Get(ctx, redisTokenKey)
+	if errors.Is(err, redis.ErrNil) {
+		return token.Data{}, false, nil
+	}
+	if err != nil {
+		return token.Data{}, false, err
+	}
+	var data token.Data
+	if err := json.Unmarshal([]byte(value), &data); err != nil {
+		return token.Data{}, false, err
+	}
+	return data, true, nil
+}
+
+func (rc *redisTokenCoordinator) Publish(ctx context.Context, data token.Data) error {
+	value, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return rc.client.Set(ctx, redisTokenKey, string(value), 0)
+}
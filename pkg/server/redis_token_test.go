@@ -0,0 +1,58 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bestk/kiro2cc/pkg/redis/redistest"
+	"github.com/bestk/kiro2cc/pkg/token"
+)
+
+func TestRedisTokenLockRefreshesOnce(t *testing.T) {
+	var calls atomic.Int32
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		time.Sleep(50 * time.Millisecond)
+		json.NewEncoder(w).Encode(token.Data{AccessToken: "refreshed", RefreshToken: "refresh-2"})
+	}))
+	defer auth.Close()
+	defer func(url string) { token.RefreshURL = url }(token.RefreshURL)
+	token.RefreshURL = auth.URL
+
+	// 两个副本各自使用独立的 token 文件，只通过 Redis 共享刷新结果
+	redisSrv := redistest.NewServer(t)
+	replicas := []*Server{
+		newTestServer(t, Config{RedisURL: redisSrv.URL(), TokenLock: TokenLockRedis}),
+		newTestServer(t, Config{RedisURL: redisSrv.URL(), TokenLock: TokenLockRedis}),
+	}
+
+	var wg sync.WaitGroup
+	for _, srv := range replicas {
+		wg.Add(1)
+		go func(srv *Server) {
+			defer wg.Done()
+			if err := srv.refreshTokenSilently(); err != nil {
+				t.Errorf("refreshTokenSilently: %v", err)
+			}
+		}(srv)
+	}
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("refresh endpoint called %d times, want 1", n)
+	}
+	for i, srv := range replicas {
+		data, err := srv.getToken()
+		if err != nil || data.AccessToken != "refreshed" {
+			t.Errorf("replica %d token = %+v, %v", i, data, err)
+		}
+	}
+	if _, ok := redisSrv.Get(redisTokenLockKey); ok {
+		t.Error("refresh lock was not released")
+	}
+}
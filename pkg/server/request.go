@@ -0,0 +1,308 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// requestError 表示请求校验失败，对应 Anthropic 格式的错误响应
+type requestError struct {
+	Status  int
+	Type    string
+	Message string
+}
+
+func (e *requestError) Error() string {
+	return e.Message
+}
+
+// policyError 把请求错误转换为 generate 返回的 PolicyError，各入口据此返回相同的状态码和错误类型
+func (e *requestError) policyError(code ErrorCode) *PolicyError {
+	return &PolicyError{Status: e.Status, Type: e.Type, Code: code, Message: e.Message}
+}
+
+// invalidRequest 构造 invalid_request_error
+func invalidRequest(format string, args ...any) *requestError {
+	return &requestError{
+		Status:  http.StatusBadRequest,
+		Type:    "invalid_request_error",
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// parseAnthropicRequest 解析并校验 Anthropic 请求体，所有入口共用
+func (s *Server) parseAnthropicRequest(body []byte) (AnthropicRequest, *requestError) {
+	// 验证JSON格式
+	var testJson map[string]interface{}
+	if err := json.Unmarshal(body, &testJson); err != nil {
+		return AnthropicRequest{}, invalidRequest("请求体不是有效的JSON: %v", err)
+	}
+
+	// 缺少 max_tokens 时按模型填入配置的默认值
+	if maxTokens, ok := testJson["max_tokens"].(float64); !ok || maxTokens <= 0 {
+		model, _ := testJson["model"].(string)
+		if n, ok := defaultMaxTokensFor(s.config.DefaultMaxTokens, model); ok {
+			testJson["max_tokens"] = n
+			normalized, err := json.Marshal(testJson)
+			if err != nil {
+				return AnthropicRequest{}, invalidRequest("填入默认 max_tokens 失败: %v", err)
+			}
+			body = normalized
+		}
+	}
+
+	// CodeWhisperer 没有提示缓存，去掉客户端的 cache_control 标记，
+	// 以免缓存断点位置的变化影响请求去重和钩子看到的内容
+	if stripCacheControl(testJson) {
+		normalized, err := json.Marshal(testJson)
+		if err != nil {
+			return AnthropicRequest{}, invalidRequest("去除 cache_control 失败: %v", err)
+		}
+		body = normalized
+	}
+
+	// 兼容模式下先修正常见框架的格式差异
+	if s.config.CompatMode {
+		applyCompatQuirks(testJson, s.config.CompatMaxTokens)
+		normalized, err := json.Marshal(testJson)
+		if err != nil {
+			return AnthropicRequest{}, invalidRequest("兼容模式转换请求失败: %v", err)
+		}
+		body = normalized
+	}
+
+	// 开启 ExtraBodyField 时取出客户端的扩展字段，未开启时客户端不能设置 extra_body
+	var extraBody map[string]any
+	if field := s.config.ExtraBodyField; field != "" {
+		var reqErr *requestError
+		if extraBody, reqErr = takeExtraBody(testJson, field); reqErr != nil {
+			return AnthropicRequest{}, reqErr
+		}
+	} else if _, ok := testJson["extra_body"]; ok {
+		fmt.Printf("警告: 服务器未开启 --extra-body-field，忽略请求中的 extra_body\n")
+	}
+	if extraBody != nil {
+		normalized, err := json.Marshal(testJson)
+		if err != nil {
+			return AnthropicRequest{}, invalidRequest("取出 %s 失败: %v", s.config.ExtraBodyField, err)
+		}
+		body = normalized
+	}
+
+	// 解析 Anthropic 请求
+	var anthropicReq AnthropicRequest
+	if err := json.Unmarshal(body, &anthropicReq); err != nil {
+		return AnthropicRequest{}, invalidRequest("解析请求体失败: %v", err)
+	}
+	anthropicReq.ExtraBody = extraBody
+	return s.validateAnthropicRequest(anthropicReq)
+}
+
+// validateAnthropicRequest 校验解析后的请求并规范化消息，parseAnthropicRequest 和 request hook 改写后的请求共用
+func (s *Server) validateAnthropicRequest(anthropicReq AnthropicRequest) (AnthropicRequest, *requestError) {
+	// 基础校验，给出明确的错误提示
+	if anthropicReq.Model == "" {
+		return anthropicReq, invalidRequest("Missing required field: model")
+	}
+	if len(anthropicReq.Messages) == 0 {
+		return anthropicReq, invalidRequest("Missing required field: messages")
+	}
+	if anthropicReq.MaxTokens <= 0 {
+		return anthropicReq, invalidRequest("max_tokens must be a positive integer")
+	}
+	if _, ok := ModelMap[anthropicReq.Model]; !ok {
+		// 提示可用的模型名称
+		available := make([]string, 0, len(ModelMap))
+		for k := range ModelMap {
+			available = append(available, k)
+		}
+		return anthropicReq, invalidRequest("Unknown or unsupported model: %s. Available models: %s", anthropicReq.Model, strings.Join(available, ", "))
+	}
+
+	if rf := anthropicReq.ResponseFormat; rf != nil {
+		if rf.Type != "json_schema" {
+			return anthropicReq, invalidRequest("response_format.type must be 'json_schema'")
+		}
+		if rf.Schema == nil {
+			return anthropicReq, invalidRequest("response_format.schema is required")
+		}
+	}
+
+	// 验证消息格式
+	for i, msg := range anthropicReq.Messages {
+		if msg.Role != "user" && msg.Role != "assistant" {
+			return anthropicReq, invalidRequest("Invalid role '%s' in message %d. Must be 'user' or 'assistant'", msg.Role, i)
+		}
+		if msg.Content == nil {
+			return anthropicReq, invalidRequest("Message %d has empty content", i)
+		}
+	}
+
+	// 按配置处理空内容的消息
+	switch s.config.EmptyContent {
+	case EmptyContentStrict:
+		for i, msg := range anthropicReq.Messages {
+			if isEmptyContent(msg.Content) {
+				return anthropicReq, invalidRequest("messages.%d: content must not be empty", i)
+			}
+		}
+	case EmptyContentDrop:
+		kept := anthropicReq.Messages[:0]
+		for i, msg := range anthropicReq.Messages {
+			if isEmptyContent(msg.Content) {
+				fmt.Printf("警告: 丢弃内容为空的消息 %d\n", i)
+				continue
+			}
+			kept = append(kept, msg)
+		}
+		anthropicReq.Messages = kept
+		if len(anthropicReq.Messages) == 0 {
+			return anthropicReq, invalidRequest("All messages have empty content")
+		}
+	}
+
+	// 合并相邻的同角色消息，保证 user/assistant 交替
+	anthropicReq.Messages = normalizeMessages(anthropicReq.Messages)
+
+	// 结尾的 assistant 消息是预填充，之前必须至少有一条用户消息
+	if _, ok := assistantPrefill(anthropicReq.Messages); ok && len(anthropicReq.Messages) < 2 {
+		return anthropicReq, invalidRequest("messages: an assistant prefill must follow at least one user message")
+	}
+
+	return anthropicReq, nil
+}
+
+// stripCacheControl 删除 system、tools 和消息内容块（包括 tool_result 内嵌的内容块）上的 cache_control，
+// 工具的 input_schema 和 tool_use 的 input 是用户数据，不做处理。返回是否删除了任何字段
+func stripCacheControl(req map[string]interface{}) bool {
+	stripped := false
+	strip := func(blocks any) {
+		list, _ := blocks.([]interface{})
+		for _, block := range list {
+			m, ok := block.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if _, ok := m["cache_control"]; ok {
+				delete(m, "cache_control")
+				stripped = true
+			}
+			if m["type"] == "tool_result" {
+				if nested, ok := m["content"].([]interface{}); ok {
+					for _, inner := range nested {
+						if im, ok := inner.(map[string]interface{}); ok {
+							if _, ok := im["cache_control"]; ok {
+								delete(im, "cache_control")
+								stripped = true
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+
+	strip(req["system"])
+	strip(req["tools"])
+	if messages, ok := req["messages"].([]interface{}); ok {
+		for _, msg := range messages {
+			if m, ok := msg.(map[string]interface{}); ok {
+				strip(m["content"])
+			}
+		}
+	}
+	return stripped
+}
+
+// isEmptyContent 判断消息内容是否为空：空白字符串、空数组，或只包含空文本块的数组。
+// tool_use、tool_result 等非文本块即使没有文本也不算空
+func isEmptyContent(content any) bool {
+	switch v := content.(type) {
+	case nil:
+		return true
+	case string:
+		return strings.TrimSpace(v) == ""
+	case []interface{}:
+		for _, block := range v {
+			m, ok := block.(map[string]interface{})
+			if !ok {
+				return false
+			}
+			if m["type"] != "text" {
+				return false
+			}
+			if text, ok := m["text"].(string); ok && strings.TrimSpace(text) != "" {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// resolveStream 决定请求以流式还是 JSON 返回。?stream=true|false 优先，方便用 curl 调试；
+// 其次默认以请求体的 stream 为准，Accept 头与之矛盾时给出警告；
+// honorAccept 开启后，Accept 只接受流式格式 (SSE 或 NDJSON) 和 JSON 其中之一时以 Accept 为准
+func resolveStream(r *http.Request, bodyStream, honorAccept bool) (stream bool, warning string) {
+	if v := r.URL.Query().Get("stream"); v != "" {
+		switch strings.ToLower(v) {
+		case "true", "1":
+			return true, ""
+		case "false", "0":
+			return false, ""
+		}
+		return bodyStream, fmt.Sprintf("忽略无效的 stream 参数 %q", v)
+	}
+
+	acceptsStream, acceptsJSON := acceptedFormats(r.Header.Get("Accept"))
+	switch {
+	case bodyStream && acceptsJSON && !acceptsStream:
+		if honorAccept {
+			return false, ""
+		}
+		return true, "请求体 stream 为 true，但 Accept 只接受 application/json，按流式返回"
+	case !bodyStream && acceptsStream && !acceptsJSON:
+		if honorAccept {
+			return true, ""
+		}
+		return false, "请求体 stream 为 false，但 Accept 只接受流式格式，按 JSON 返回"
+	}
+	return bodyStream, ""
+}
+
+// acceptedFormats 判断 Accept 头是否接受流式格式 (SSE 或 NDJSON) 和 JSON，缺省和通配符视为都接受
+func acceptedFormats(accept string) (acceptsStream, acceptsJSON bool) {
+	if strings.TrimSpace(accept) == "" {
+		return true, true
+	}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType := strings.ToLower(strings.TrimSpace(strings.SplitN(part, ";", 2)[0]))
+		switch mediaType {
+		case "*/*":
+			acceptsStream, acceptsJSON = true, true
+		case "text/*", "text/event-stream", NDJSONContentType:
+			acceptsStream = true
+		case "application/*", "application/json":
+			acceptsJSON = true
+		}
+	}
+	return acceptsStream, acceptsJSON
+}
+
+// prefersNDJSON 判断流式响应是否改用 NDJSON: Accept 明确列出 application/x-ndjson 且没有列出 text/event-stream，
+// 通配符仍然按 SSE 返回
+func prefersNDJSON(accept string) bool {
+	ndjson, sse := false, false
+	for _, part := range strings.Split(accept, ",") {
+		switch strings.ToLower(strings.TrimSpace(strings.SplitN(part, ";", 2)[0])) {
+		case NDJSONContentType:
+			ndjson = true
+		case "text/event-stream":
+			sse = true
+		}
+	}
+	return ndjson && !sse
+}
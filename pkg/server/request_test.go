@@ -0,0 +1,114 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEmptyContentModes(t *testing.T) {
+	body := []byte(`{"model":"claude-sonnet-4-20250514","max_tokens":10,"messages":[
+		{"role":"user","content":"first"},
+		{"role":"assistant","content":[{"type":"text","text":"  "}]},
+		{"role":"user","content":"second"}
+	]}`)
+
+	strict := newTestServer(t, Config{})
+	_, reqErr := strict.parseAnthropicRequest(body)
+	if reqErr == nil || !strings.Contains(reqErr.Message, "messages.1") {
+		t.Fatalf("strict: expected error naming messages.1, got %v", reqErr)
+	}
+
+	// 丢弃空的 assistant 消息后，相邻的两条 user 消息会被合并
+	drop := newTestServer(t, Config{EmptyContent: EmptyContentDrop})
+	req, reqErr := drop.parseAnthropicRequest(body)
+	if reqErr != nil || len(req.Messages) != 1 || getMessageContent(req.Messages[0].Content) != "first\nsecond" {
+		t.Fatalf("drop: got %v, %+v", reqErr, req.Messages)
+	}
+
+	substitute := newTestServer(t, Config{EmptyContent: EmptyContentSubstitute})
+	req, reqErr = substitute.parseAnthropicRequest(body)
+	if reqErr != nil || len(req.Messages) != 3 {
+		t.Fatalf("substitute: got %v, %d messages", reqErr, len(req.Messages))
+	}
+	if got := getMessageContent(req.Messages[1].Content); got != emptyContentPlaceholder {
+		t.Fatalf("substitute: unexpected content %q", got)
+	}
+
+	if _, err := New(Config{TokenPath: "unused", EmptyContent: "bogus"}); err == nil {
+		t.Fatal("expected unknown EmptyContent mode to be rejected")
+	}
+}
+
+func TestToolUseIsNotEmptyContent(t *testing.T) {
+	content := []interface{}{map[string]interface{}{"type": "tool_use", "id": "t1", "name": "f", "input": map[string]interface{}{}}}
+	if isEmptyContent(content) {
+		t.Fatal("tool_use block must not count as empty content")
+	}
+}
+
+func TestResolveStream(t *testing.T) {
+	tests := []struct {
+		target      string
+		accept      string
+		bodyStream  bool
+		honorAccept bool
+		want        bool
+		warn        bool
+	}{
+		{"/v1/messages", "", true, false, true, false},
+		{"/v1/messages", "*/*", false, false, false, false},
+		{"/v1/messages?stream=true", "application/json", false, false, true, false},
+		{"/v1/messages?stream=0", "", true, false, false, false},
+		{"/v1/messages?stream=maybe", "", true, false, true, true},
+		{"/v1/messages", "application/json", true, false, true, true},
+		{"/v1/messages", "application/json", true, true, false, false},
+		{"/v1/messages", "text/event-stream", false, false, false, true},
+		{"/v1/messages", "text/event-stream", false, true, true, false},
+		{"/v1/messages", "text/event-stream, application/json;q=0.9", true, true, true, false},
+		{"/v1/messages", "application/x-ndjson", true, false, true, false},
+		{"/v1/messages", "application/x-ndjson", false, true, true, false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, tt.target, nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		got, warning := resolveStream(r, tt.bodyStream, tt.honorAccept)
+		if got != tt.want || (warning != "") != tt.warn {
+			t.Errorf("%s Accept=%q body=%v honor=%v: got %v warning %q", tt.target, tt.accept, tt.bodyStream, tt.honorAccept, got, warning)
+		}
+	}
+}
+
+func TestCacheControlStripped(t *testing.T) {
+	backend := &fakeBackend{events: textEvents("ok")}
+	srv := newTestServer(t, Config{Backend: backend})
+	rec := postMessages(srv, `{"model":"claude-sonnet-4-20250514","max_tokens":10,
+		"system":[{"type":"text","text":"be brief","cache_control":{"type":"ephemeral"}}],
+		"tools":[{"name":"ls","input_schema":{"type":"object","properties":{"cache_control":{"type":"string"}}},"cache_control":{"type":"ephemeral"}}],
+		"messages":[
+			{"role":"user","content":[{"type":"text","text":"list files","cache_control":{"type":"ephemeral"}}]},
+			{"role":"assistant","content":[{"type":"tool_use","id":"t1","name":"ls","input":{}}]},
+			{"role":"user","content":[{"type":"tool_result","tool_use_id":"t1","content":[{"type":"text","text":"a.txt","cache_control":{"type":"ephemeral"}}]}]}
+		]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	data, _ := json.Marshal(backend.reqs[0])
+	if n := strings.Count(string(data), "cache_control"); n != 1 {
+		t.Errorf("cache_control should only remain as a schema property, found %d in %s", n, data)
+	}
+	var resp struct {
+		Usage map[string]int `json:"usage"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	for _, field := range []string{"cache_creation_input_tokens", "cache_read_input_tokens"} {
+		if v, ok := resp.Usage[field]; !ok || v != 0 {
+			t.Errorf("usage.%s = %v, %v", field, v, ok)
+		}
+	}
+}
@@ -0,0 +1,808 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+
+	"github.com/bestk/kiro2cc/pkg/redis"
+	"github.com/bestk/kiro2cc/pkg/token"
+)
+
+// maxRequestBodySize 限制 /v1/messages 请求体为 10MB
+const maxRequestBodySize = 10 << 20
+
+// DefaultProfileArn 是未配置时使用的默认 ProfileArn
+const DefaultProfileArn = "arn:aws:codewhisperer:us-east-1:699475941385:profile/EHGA3GRVQMUK"
+
+// stateSessionsFile 是旧版本在状态目录中保存会话映射的文件名，启动时导入状态数据库
+const stateSessionsFile = "sessions.json"
+
+// tokenRefreshTimeout 是等待刷新锁和刷新 token 的总时长
+const tokenRefreshTimeout = 30 * time.Second
+
+// token 刷新的协调方式
+const (
+	// TokenLockProcess 只在进程内串行化刷新
+	TokenLockProcess = "process"
+	// TokenLockFile 通过共享卷上的锁文件协调多个副本
+	TokenLockFile = "file"
+	// TokenLockRedis 通过 Redis 协调多个副本并共享刷新结果
+	TokenLockRedis = "redis"
+)
+
+// 空内容消息的处理方式
+const (
+	// EmptyContentStrict 拒绝请求并指出消息序号
+	EmptyContentStrict = "strict"
+	// EmptyContentDrop 丢弃内容为空的消息
+	EmptyContentDrop = "drop"
+	// EmptyContentSubstitute 用占位文本替换空内容
+	EmptyContentSubstitute = "substitute"
+)
+
+// Config 表示代理服务器的配置
+type Config struct {
+	// Addr 是监听地址，例如 ":8080"，仅 Start 使用
+	Addr string
+	// BasePath 不为空时所有端点挂载在该前缀下，例如 "/kiro" 时消息端点为 /kiro/v1/messages，
+	// 用于部署在反向代理的子路径后面。首尾的 "/" 会被规范化，开启管理接口时必须同时设置 AdminToken
+	BasePath string
+	// TokenPath 是token文件路径，为空时使用默认路径
+	TokenPath string
+	// ProfileArn 为空时依次使用环境变量 KIRO_PROFILE_ARN 和 DefaultProfileArn
+	ProfileArn string
+	// Auth 是上游请求的认证方式: bearer (默认，使用 TokenPath 中的 token)、
+	// sigv4 (使用 AWS IAM 凭证签名，适用于直接拥有 CodeWhisperer 权限的 IAM 用户或角色)
+	Auth string
+	// AWSProfile 是 sigv4 模式使用的 AWS 配置 profile，为空时依次使用 AWS_ACCESS_KEY_ID 等环境变量、
+	// AWS_PROFILE 和 default。区域未配置时使用 ProfileArn 中的区域
+	AWSProfile string
+	// TLSCertFile 与 TLSKeyFile 同时设置时 Start 以 HTTPS 监听，并自动协商 HTTP/2
+	TLSCertFile string
+	TLSKeyFile  string
+	// H2C 开启后明文监听也接受 HTTP/2（h2c），SDK 可在一条连接上复用并发请求
+	H2C bool
+	// Origin 是发送给 CodeWhisperer 的消息来源，为空时使用 OriginAIEditor
+	Origin string
+	// ToolFreeOrigin 不为空时，没有工具定义的请求改用该来源，例如 CHAT
+	ToolFreeOrigin string
+	// ToolFreeMaxTokens 大于 0 时，只有 max_tokens 不超过该值的无工具请求才使用 ToolFreeOrigin
+	ToolFreeMaxTokens int
+	// ChatTriggerType 是发送给 CodeWhisperer 的触发类型，为空时使用 ChatTriggerManual
+	ChatTriggerType string
+	// ToolResultTriggerType 不为空时，只回传 tool_result 的 agent 后续请求改用该触发类型
+	ToolResultTriggerType string
+	// EnableWebSocket 开启 /v1/messages/ws 流式端点
+	EnableWebSocket bool
+	// WebSocketOrigins 是浏览器握手时允许的 Origin（例如 https://chat.example.com），与 Host 相同的来源总是允许
+	WebSocketOrigins []string
+	// WebSocketRequestTimeout 是握手后等待客户端发送请求的期限，为 0 时使用 DefaultWebSocketRequestTimeout
+	WebSocketRequestTimeout time.Duration
+	// SSEWriteTimeout 是写出单个 SSE 事件的期限，为 0 时使用 DefaultSSEWriteTimeout，小于 0 时不限
+	SSEWriteTimeout time.Duration
+	// SSEBufferEvents 大于 0 时 SSE 事件先进入该容量的缓冲再由独立的 goroutine 写出，
+	// 缓冲写满说明客户端跟不上，连接会以 overloaded_error 结束
+	SSEBufferEvents int
+	// HonorAccept 开启后，Accept 头只接受 JSON 或 SSE 之一时以 Accept 为准，否则以请求体的 stream 为准
+	HonorAccept bool
+	// BufferStreams 开启后 stream 为 true 的 /v1/messages 请求在代理内部完成后以单个 JSON 响应返回，
+	// 并带有 x-kiro2cc-stream-buffered 响应头，用于会无限期缓冲 SSE 的企业中间设备之后的客户端
+	BufferStreams bool
+	// EmptyContent 决定如何处理内容为空的消息，为空时使用 EmptyContentStrict
+	EmptyContent string
+	// CompatMode 开启后容忍 LangChain/LiteLLM 等框架的请求格式差异
+	CompatMode bool
+	// CompatMaxTokens 是兼容模式下缺少 max_tokens 时的默认值，为 0 时使用 DefaultCompatMaxTokens
+	CompatMaxTokens int
+	// DefaultMaxTokens 不为空时，请求缺少 max_tokens (或不大于 0) 时按模型填入默认值而不是拒绝请求。
+	// 键是完整模型名或模型名的一部分 (例如 "sonnet-4")，"*" 匹配其他所有模型，优先于 CompatMaxTokens
+	DefaultMaxTokens map[string]int
+	// LogBodies 开启后在日志中打印完整的客户端请求体、CodeWhisperer 请求体、响应体、发给客户端的流事件和 agent 执行的工具输入。
+	// 默认只记录请求体的 SHA-256 摘要，日志中不保存提示词内容
+	LogBodies bool
+	// Debug 开启后在响应头中暴露上游状态码、延迟等诊断信息，并允许用 GET 和查询参数发起调试请求
+	Debug bool
+	// APIKeys 不为空时 /v1/ 端点和 gRPC 只接受携带其中之一 (x-api-key 或 Authorization: Bearer) 的请求，
+	// 通常是 LoadOrCreateLocalAPIKey 生成的本地 key。为空时不检查客户端的 key
+	APIKeys []string
+	// JWTSecret 不为空时接受用该密钥以 HS256/HS384/HS512 签名的 JWT 作为客户端凭证
+	JWTSecret string
+	// JWKSURL 不为空时接受用该地址公布的公钥以 RS256/ES256 等算法签名的 JWT，通常是组织 SSO 的 jwks_uri
+	JWKSURL string
+	// JWTIssuer 和 JWTAudience 不为空时要求 JWT 的 iss 与 aud 与之一致
+	JWTIssuer   string
+	JWTAudience string
+	// JWTSubjectClaim 是作为用量身份的 claim，为空时使用 DefaultJWTSubjectClaim
+	JWTSubjectClaim string
+	// JWTTierClaim 是作为限流档位的 claim，为空时使用 DefaultJWTTierClaim
+	JWTTierClaim string
+	// ClientAuth 是额外的客户端认证方式，在 APIKeys 和 JWT 之后依次尝试，用于嵌入时接入自己的身份系统。
+	// APIKeys、JWT 和 ClientAuth 都没有配置时不检查客户端凭证
+	ClientAuth []ClientAuthProvider
+	// RateLimits 不为空时按客户端身份限制每分钟请求数，键是身份的限流档位，"*" 用于未列出的档位和没有档位的身份，
+	// 超出时返回 429 rate_limit_error。只对认证后的请求生效
+	RateLimits map[string]int
+	// Tenants 把 API key 分组为租户，每个租户有自己的模型范围、限流、每日 token 预算和 profile/token 绑定，
+	// 可以通过 /admin/tenants 管理，修改只保存在内存中
+	Tenants []Tenant
+	// TenantsFile 不为空时从该 JSON 文件 ({"tenants": [...]}) 加载租户，管理接口的修改写回该文件，
+	// 不能与 Tenants 同时设置。文件不存在时从空列表开始
+	TenantsFile string
+	// SigningSecret 不为空时 /v1/ 端点的响应带有 x-kiro2cc-signature: sha256=<hex>，即用该密钥对响应体计算的 HMAC-SHA256，
+	// 流式响应的签名作为 trailer 在响应体结束后发送。下游可据此确认响应来自可信的代理实例，WebSocket 端点不签名
+	SigningSecret string
+	// QuietHours 是每周重复的维护时段，期间 /v1/messages 等端点以 503 overloaded_error 拒绝新请求，
+	// 例如把 Kiro 额度留给工作时间。管理员也可以通过 /admin/maintenance 随时开启维护
+	QuietHours []QuietWindow
+	// QuietHoursLocation 是解释 QuietHours 的时区，为空时使用本机时区
+	QuietHoursLocation *time.Location
+	// MaintenanceMessage 是维护期间返回给客户端的说明，为空时使用 DefaultMaintenanceMessage
+	MaintenanceMessage string
+	// AdminToken 是访问 /admin/ 管理接口所需的 Bearer token，为空时只允许本机直接访问，不接受反向代理转发的请求
+	AdminToken string
+	// TranscriptLimit 大于 0 时在内存中保留最近的对话，供 /admin/transcripts 导出
+	TranscriptLimit int
+	// IdempotencyTTL 是按 Idempotency-Key 缓存响应的时长，为 0 时使用 DefaultIdempotencyTTL，小于 0 时关闭
+	IdempotencyTTL time.Duration
+	// DedupWindow 大于 0 时，该时间窗口内与进行中请求完全相同的请求会复用同一次上游调用
+	DedupWindow time.Duration
+	// SessionFile 不为空时同一会话的多轮请求使用固定的 conversationId，映射保存在该文件中，重启后继续生效
+	SessionFile string
+	// StateDir 不为空时作为持久状态目录，会话映射（未设置 SessionFile 和 RedisURL 时）、
+	// 幂等响应和租户用量保存在其中的 SQLite 数据库 state.db
+	StateDir string
+	// RedisURL 不为空时会话映射保存在 Redis 中 (redis://[:password@]host:port[/db])，
+	// 负载均衡后的多个副本共享同一份映射，与 SessionFile 二选一
+	RedisURL string
+	// TokenLock 决定多副本如何协调 token 刷新: process (默认，仅进程内)、file (共享卷上的锁文件)、
+	// redis (使用 RedisURL，刷新结果通过 Redis 共享给其他副本)
+	TokenLock string
+	// SystemPrefix 不为空时作为第一条 system 消息注入到每个请求中，位于客户端的 system 之前，
+	// 用于在共享代理上统一附加组织策略等内容，客户端不可见也无法去掉
+	SystemPrefix string
+	// ResponseLanguage 不为空时在每个请求的 system 末尾追加指令，要求模型始终使用该语言回复，
+	// 例如 zh、en 或任意语言名
+	ResponseLanguage string
+	// ResponseLanguageKeys 按客户端 API key (x-api-key 或 Authorization: Bearer) 覆盖 ResponseLanguage，
+	// 值为 ResponseLanguageOff 时该 key 的请求不注入指令
+	ResponseLanguageKeys map[string]string
+	// KeyModels 按客户端 API key 限制可用的模型，例如只允许某个 key 使用 haiku，
+	// 不允许的模型返回 403 permission_error。不在其中的 key 不受限制
+	KeyModels map[string]ModelAccess
+	// ExtraBodyField 不为空时，客户端可以在请求的该字段 (例如 extra_body) 中传入一个对象，
+	// 逐层合并到发往上游的 CodeWhispererRequest 中，值为 null 的字段会被删除，用于试验上游的新字段
+	ExtraBodyField string
+	// RequestHook 不为空时每个请求先交给该命令变换: 请求 JSON 写入标准输入，标准输出的 JSON 作为新的请求，
+	// 没有输出时保持不变，以退出码 2 退出表示拒绝请求，stderr 作为原因。命令按空白拆分后直接执行，不经过 shell
+	RequestHook string
+	// ResponseHook 不为空时模型输出的文本交给该命令变换: 标准输入为 {"request": ..., "text": ...}，
+	// 标准输出为 {"text": ...}，约定与 RequestHook 相同
+	ResponseHook string
+	// HookTimeout 是单次执行钩子的期限，为 0 时使用 DefaultHookTimeout
+	HookTimeout time.Duration
+	// HookFailure 决定钩子执行失败时的处理方式: reject (默认，拒绝请求)、ignore (按原内容继续)
+	HookFailure string
+	// StructuredOutputRetries 是带 response_format 的请求输出不符合 schema 时的重试次数，
+	// 为 0 时使用 DefaultStructuredOutputRetries，小于 0 时不重试
+	StructuredOutputRetries int
+	// ContextOverflow 决定估算的输入超过模型上下文窗口 (ModelInfos) 的请求如何处理，为空时使用 ContextOverflowReject
+	ContextOverflow string
+	// MaxContinuations 大于 0 时，上游因长度上限或连接断开提前结束纯文本回复后，代理以已收到的输出作为历史
+	// 自动请求续写，最多续写这么多次并把结果拼接为一个回复。为 0 时不续写
+	MaxContinuations int
+	// AgentCommands 不为空时开启 /v1/agent/messages，模型可以通过 run_command 工具执行其中列出的本地程序。
+	// 没有配置客户端认证时该接口只允许本机访问
+	AgentCommands []string
+	// AgentFetchHosts 不为空时 agent 模式提供 http_fetch 工具，只允许访问其中列出的主机
+	AgentFetchHosts []string
+	// AgentMCPServers 是 agent 模式额外连接的 MCP 服务器命令，例如 "npx -y @modelcontextprotocol/server-filesystem /tmp"，
+	// 它们的工具会提供给模型并由代理调用。命令按空白拆分后直接执行，需要调用 Close 结束子进程
+	AgentMCPServers []string
+	// AgentMaxSteps 是 agent 模式最多执行工具的轮数，为 0 时使用 DefaultAgentMaxSteps
+	AgentMaxSteps int
+	// LogBufferLines 是在内存中保留的最近日志行数，供 /admin/logs 查看，为 0 时使用 DefaultLogBufferLines，
+	// 小于 0 时关闭。日志需要写入 LogWriter 才会被保留
+	LogBufferLines int
+	// EventHistory 是在内存中保留上游事件的最近请求数，供 /admin/requests/{id}/events 排查，
+	// 为 0 时使用 DefaultEventHistory，小于 0 时关闭
+	EventHistory int
+	// RawCaptureLimit 是设置了 StateDir 时保留的解析失败的上游原始响应文件数 (raw-captures 目录)，
+	// 上游响应没有解析出事件或有无法解码的内容时自动保存，为 0 时使用 DefaultRawCaptureLimit，小于 0 时关闭
+	RawCaptureLimit int
+	// RawCaptureRetention 是原始响应文件的保留时长，为 0 时使用 DefaultRawCaptureRetention
+	RawCaptureRetention time.Duration
+	// CaptureUpstream 为 true 时同时保留上游响应的原始字节，会占用更多内存，EventHistory 关闭时无效
+	CaptureUpstream bool
+	// GoroutineLeakThreshold 是 goroutine 数量超出预期 (空闲时的数量加上进行中请求的估算) 多少个时判定为泄漏并打印警告，
+	// 为 0 时使用 DefaultGoroutineLeakThreshold，小于 0 时关闭。只在 Start 运行期间检查
+	GoroutineLeakThreshold int
+	// SlowRequestThreshold 是上游调用的耗时阈值，超过时打印带请求详情的警告并记入 /admin/requests/slow，
+	// 为 0 时使用 DefaultSlowRequestThreshold，小于 0 时关闭
+	SlowRequestThreshold time.Duration
+	// SkipPreflight 为 true 时 Start 不在监听前执行 Preflight
+	SkipPreflight bool
+	// Surfaces 是 Addr 上开启的接口类别 (SurfaceAnthropic、SurfaceAdmin)，为空时全部开启。
+	// Handler 返回的处理器不受影响，始终包含全部接口
+	Surfaces []string
+	// Listeners 是 Start 额外监听的地址，每个地址只开启各自的接口类别，例如把管理接口放在只对内网开放的端口上。
+	// 额外的地址与 Addr 使用相同的 TLS 和 H2C 设置
+	Listeners []Listener
+	// GRPCAddr 不为空时 Start 会同时在该地址启动 gRPC 服务
+	GRPCAddr string
+	// Backend 为空时使用基于 TokenPath 的 CodeWhisperer 后端
+	Backend Backend
+}
+
+// Server 是可嵌入到其他 Go 程序中的 Anthropic API 代理
+type Server struct {
+	config      Config
+	mux         *http.ServeMux
+	backend     Backend
+	transcripts *transcriptStore
+	sessions    sessionStore
+	refresher   token.Coordinator
+	// state 是设置了 StateDir 时打开的状态数据库
+	state *sql.DB
+	// auth 为发往上游的请求添加认证信息，由 Config.Auth 决定
+	auth         upstreamAuth
+	idempotency  *idempotencyCache
+	dedup        *dedupGroup
+	broker       *streamBroker
+	inflight     *inflightRegistry
+	agent        *agentRunner
+	tokenMetrics tokenMetrics
+	formats      *upstreamFormats
+	unknownBetas unknownBetas
+	logs         *logBuffer
+	watchdog     *goroutineWatchdog
+	events       *eventStore
+	slow         *slowRequestLog
+	clientAuth   []ClientAuthProvider
+	limiter      *rateLimiter
+	tenants      *tenantStore
+	maintenance  *maintenanceMode
+	bodies       *recentBodies
+	// profilesURL 是预检调用的 ListAvailableProfiles 地址
+	profilesURL string
+}
+
+// New 创建代理服务器
+func New(config Config) (*Server, error) {
+	if config.Addr == "" {
+		config.Addr = ":8080"
+	}
+	if config.TokenPath == "" {
+		path, err := token.DefaultPath()
+		if err != nil {
+			return nil, err
+		}
+		config.TokenPath = path
+	}
+	if config.ProfileArn == "" {
+		config.ProfileArn = os.Getenv("KIRO_PROFILE_ARN")
+	}
+	if config.ProfileArn == "" {
+		config.ProfileArn = DefaultProfileArn
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	config.BasePath = NormalizeBasePath(config.BasePath)
+
+	if config.Auth == "" {
+		config.Auth = AuthBearer
+	}
+	if config.EmptyContent == "" {
+		config.EmptyContent = EmptyContentStrict
+	}
+	if config.ContextOverflow == "" {
+		config.ContextOverflow = ContextOverflowReject
+	}
+	if config.CompatMaxTokens <= 0 {
+		config.CompatMaxTokens = DefaultCompatMaxTokens
+	}
+	if config.Origin == "" {
+		config.Origin = OriginAIEditor
+	}
+	if config.ChatTriggerType == "" {
+		config.ChatTriggerType = ChatTriggerManual
+	}
+	if config.WebSocketRequestTimeout == 0 {
+		config.WebSocketRequestTimeout = DefaultWebSocketRequestTimeout
+	}
+	if config.SSEWriteTimeout == 0 {
+		config.SSEWriteTimeout = DefaultSSEWriteTimeout
+	}
+	if config.StructuredOutputRetries == 0 {
+		config.StructuredOutputRetries = DefaultStructuredOutputRetries
+	}
+	if config.IdempotencyTTL == 0 {
+		config.IdempotencyTTL = DefaultIdempotencyTTL
+	}
+	if config.HookTimeout == 0 {
+		config.HookTimeout = DefaultHookTimeout
+	}
+	if config.HookFailure == "" {
+		config.HookFailure = HookFailureReject
+	}
+	if config.EventHistory == 0 {
+		config.EventHistory = DefaultEventHistory
+	}
+	if config.GoroutineLeakThreshold == 0 {
+		config.GoroutineLeakThreshold = DefaultGoroutineLeakThreshold
+	}
+	if config.LogBufferLines == 0 {
+		config.LogBufferLines = DefaultLogBufferLines
+	}
+	if config.SlowRequestThreshold == 0 {
+		config.SlowRequestThreshold = DefaultSlowRequestThreshold
+	}
+	if config.RawCaptureLimit == 0 {
+		config.RawCaptureLimit = DefaultRawCaptureLimit
+	}
+	if config.RawCaptureRetention == 0 {
+		config.RawCaptureRetention = DefaultRawCaptureRetention
+	}
+
+	s := &Server{config: config, backend: config.Backend, broker: newStreamBroker(), inflight: newInflightRegistry(), profilesURL: ListProfilesURL, formats: &upstreamFormats{}}
+	if config.StateDir != "" {
+		db, err := openStateDB(config.StateDir)
+		if err != nil {
+			return nil, err
+		}
+		s.state = db
+	}
+	var redisClient *redis.Client
+	if config.RedisURL != "" {
+		client, err := redis.ParseURL(config.RedisURL)
+		if err != nil {
+			return nil, err
+		}
+		redisClient = client
+		s.sessions = &redisSessionStore{client: client}
+	} else if config.SessionFile != "" {
+		sessions, err := loadSessions(config.SessionFile)
+		if err != nil {
+			return nil, err
+		}
+		s.sessions = sessions
+	} else if s.state != nil {
+		sessions, err := newSQLiteSessionStore(s.state, config.StateDir)
+		if err != nil {
+			return nil, err
+		}
+		s.sessions = sessions
+	}
+	switch config.TokenLock {
+	case TokenLockFile:
+		s.refresher = token.NewFileCoordinator(config.TokenPath)
+	case TokenLockRedis:
+		s.refresher = &redisTokenCoordinator{client: redisClient}
+	default:
+		s.refresher = token.NewProcessCoordinator(config.TokenPath)
+	}
+	if config.EventHistory > 0 {
+		s.events = newEventStore(config.EventHistory)
+	}
+	if config.GoroutineLeakThreshold > 0 {
+		s.watchdog = newGoroutineWatchdog(config.GoroutineLeakThreshold)
+	}
+	if config.LogBufferLines > 0 {
+		s.logs = newLogBuffer(config.LogBufferLines)
+	}
+	if config.SlowRequestThreshold > 0 {
+		s.slow = newSlowRequestLog(config.SlowRequestThreshold)
+	}
+	s.maintenance = newMaintenanceMode(config)
+	s.bodies = newRecentBodies(replayWindow)
+	s.clientAuth = clientAuthProviders(config)
+	if len(config.RateLimits) > 0 {
+		s.limiter = newRateLimiter(config.RateLimits)
+		s.limiter.redis, s.limiter.redisPrefix = redisClient, redisRateLimitPrefix
+	}
+	if config.Auth == AuthSigV4 {
+		s.auth = sigV4Auth{profile: config.AWSProfile, defaultRegion: profileArnRegion(config.ProfileArn)}
+	} else {
+		s.auth = bearerAuth{tokenPath: config.TokenPath}
+	}
+	if s.backend == nil {
+		s.backend = &codeWhispererBackend{
+			url:        CodeWhispererURL,
+			auth:       s.auth,
+			profileArn: config.ProfileArn,
+			origins:    originPolicy{origin: config.Origin, toolFree: config.ToolFreeOrigin, toolFreeMaxTokens: config.ToolFreeMaxTokens},
+			triggers:   triggerPolicy{trigger: config.ChatTriggerType, toolResult: config.ToolResultTriggerType},
+			sessions:   s.sessions,
+			client:     &http.Client{},
+			capture:    config.CaptureUpstream && config.EventHistory > 0,
+			logBodies:  config.LogBodies,
+			rawCapture: newRawCaptureStore(config),
+			formats:    s.formats,
+		}
+	}
+	if len(config.Tenants) > 0 || config.TenantsFile != "" {
+		tenants := config.Tenants
+		if config.TenantsFile != "" {
+			loaded, err := loadTenants(config.TenantsFile)
+			if err != nil {
+				return nil, err
+			}
+			tenants = loaded
+		}
+		s.tenants = newTenantStore(config.TenantsFile, s.state, redisClient, tenants, s.tenantBackend)
+		s.clientAuth = append(s.clientAuth, s.tenants)
+	}
+	if len(config.AgentCommands) > 0 || len(config.AgentFetchHosts) > 0 || len(config.AgentMCPServers) > 0 {
+		maxSteps := config.AgentMaxSteps
+		if maxSteps == 0 {
+			maxSteps = DefaultAgentMaxSteps
+		}
+		s.agent = &agentRunner{commands: config.AgentCommands, fetchHosts: config.AgentFetchHosts, maxSteps: maxSteps}
+		s.agent.client = &http.Client{CheckRedirect: s.agent.checkRedirect}
+		if err := s.agent.startMCPServers(context.Background(), config.AgentMCPServers); err != nil {
+			return nil, err
+		}
+	}
+	if config.TranscriptLimit > 0 {
+		s.transcripts = &transcriptStore{limit: config.TranscriptLimit}
+	}
+	if config.IdempotencyTTL > 0 {
+		s.idempotency = newIdempotencyCache(config.IdempotencyTTL, s.state)
+	}
+	if config.DedupWindow > 0 {
+		s.dedup = newDedupGroup(config.DedupWindow)
+	}
+	s.mux = s.routes(nil)
+	return s, nil
+}
+
+// Handler 返回代理的 http.Handler，可挂载到调用方自己的路由器上
+func (s *Server) Handler() http.Handler {
+	return s.mux
+}
+
+// Close 释放服务器持有的外部资源：agent 模式启动的 MCP 服务器子进程和状态数据库。Start 返回前会自动调用
+func (s *Server) Close() error {
+	if s.agent != nil {
+		s.agent.close()
+	}
+	if s.state != nil {
+		return s.state.Close()
+	}
+	return nil
+}
+
+// Start 启动HTTP服务器（以及配置的gRPC服务器）并阻塞，直到 ctx 取消或监听失败。
+// 监听前先执行 Preflight，预检失败时直接返回错误
+func (s *Server) Start(ctx context.Context) error {
+	defer s.Close()
+	if !s.config.SkipPreflight {
+		if err := s.Preflight(ctx); err != nil {
+			return err
+		}
+	}
+	httpServer := &http.Server{Addr: s.config.Addr, Handler: s.listenerHandler(s.config.Surfaces)}
+	servers := []*http.Server{httpServer}
+	for _, l := range s.config.Listeners {
+		servers = append(servers, &http.Server{Addr: l.Addr, Handler: s.listenerHandler(l.Surfaces)})
+	}
+	if s.watchdog != nil {
+		s.checkGoroutines()
+		watchdogCtx, stopWatchdog := context.WithCancel(ctx)
+		defer stopWatchdog()
+		go s.runWatchdog(watchdogCtx)
+	}
+
+	errCh := make(chan error, len(servers)+1)
+	for _, hs := range servers {
+		go func() {
+			if s.config.TLSCertFile != "" {
+				// ListenAndServeTLS 默认通过 ALPN 启用 HTTP/2
+				errCh <- hs.ListenAndServeTLS(s.config.TLSCertFile, s.config.TLSKeyFile)
+				return
+			}
+			errCh <- hs.ListenAndServe()
+		}()
+	}
+	closeAll := func() {
+		for _, hs := range servers {
+			hs.Close()
+		}
+	}
+
+	var grpcServer *grpc.Server
+	if s.config.GRPCAddr != "" {
+		listener, err := net.Listen("tcp", s.config.GRPCAddr)
+		if err != nil {
+			closeAll()
+			return fmt.Errorf("gRPC 监听失败: %v", err)
+		}
+		grpcServer = grpc.NewServer()
+		s.RegisterGRPC(grpcServer)
+		go func() {
+			errCh <- grpcServer.Serve(listener)
+		}()
+	}
+
+	select {
+	case err := <-errCh:
+		closeAll()
+		if grpcServer != nil {
+			grpcServer.Stop()
+		}
+		return err
+	case <-ctx.Done():
+		if grpcServer != nil {
+			grpcServer.GracefulStop()
+		}
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		var shutdownErr error
+		for _, hs := range servers {
+			if err := hs.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) && shutdownErr == nil {
+				shutdownErr = err
+			}
+		}
+		return shutdownErr
+	}
+}
+
+// listenerHandler 返回 Start 监听使用的处理器，只包含 surfaces 中的接口类别，为空时包含全部。
+// 开启 H2C 时额外接受明文 HTTP/2
+func (s *Server) listenerHandler(surfaces []string) http.Handler {
+	mux := s.mux
+	if len(surfaces) > 0 {
+		mux = s.routes(surfaces)
+	}
+	if s.config.H2C {
+		return h2c.NewHandler(mux, &http2.Server{})
+	}
+	return mux
+}
+
+// routes 创建路由器并注册 surfaces 中接口类别的端点，surfaces 为空时注册全部
+func (s *Server) routes(surfaces []string) *http.ServeMux {
+	mux := http.NewServeMux()
+	handle := func(pattern string, handler http.HandlerFunc) {
+		mux.HandleFunc(s.config.BasePath+pattern, s.recoverMiddleware(apiKeyMiddleware(s.betaMiddleware(handler))))
+	}
+
+	if surfaceEnabled(surfaces, SurfaceAnthropic) {
+		s.anthropicRoutes(handle)
+	}
+	if surfaceEnabled(surfaces, SurfaceAdmin) {
+		s.adminRoutes(handle)
+	}
+
+	// 添加健康检查端点
+	handle("/health", logMiddleware(s.handleHealth))
+
+	// 添加404处理
+	mux.HandleFunc("/", logMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Printf("警告: 访问未知端点\n")
+		http.Error(w, "404 未找到", http.StatusNotFound)
+	}))
+	return mux
+}
+
+// anthropicRoutes 注册 Anthropic 格式的端点
+func (s *Server) anthropicRoutes(handle func(string, http.HandlerFunc)) {
+	handle("/v1/messages", logMiddleware(s.signResponseMiddleware(s.requireAPIKeyMiddleware(s.maintenanceMiddleware(s.handleMessages)))))
+	handle("/v1/models", logMiddleware(s.signResponseMiddleware(s.requireAPIKeyMiddleware(s.handleModels))))
+	handle("/v1/models/{id}", logMiddleware(s.signResponseMiddleware(s.requireAPIKeyMiddleware(s.handleModel))))
+	if s.config.EnableWebSocket {
+		handle("/v1/messages/ws", logMiddleware(s.requireAPIKeyMiddleware(s.maintenanceMiddleware(s.handleMessagesWebSocket))))
+	}
+	if s.agent != nil {
+		handle("/v1/agent/messages", logMiddleware(s.signResponseMiddleware(s.requireAPIKeyMiddleware(s.agentAccessMiddleware(s.maintenanceMiddleware(s.handleAgentMessages))))))
+	}
+}
+
+// adminRoutes 注册管理接口
+func (s *Server) adminRoutes(handle func(string, http.HandlerFunc)) {
+	handle("/admin/transcripts", logMiddleware(s.adminMiddleware(s.handleTranscripts)))
+	handle("/admin/sessions", logMiddleware(s.adminMiddleware(s.handleSessions)))
+	handle("/admin/streams", logMiddleware(s.adminMiddleware(s.handleStreams)))
+	handle("/admin/streams/{id}", logMiddleware(s.adminMiddleware(s.handleWatchStream)))
+	handle("/admin/requests", logMiddleware(s.adminMiddleware(s.handleRequests)))
+	handle("/admin/requests/live", s.adminMiddleware(s.handleRequestsLive))
+	handle("/admin/requests/slow", logMiddleware(s.adminMiddleware(s.handleSlowRequests)))
+	handle("/admin/tenants", logMiddleware(s.adminMiddleware(s.handleTenants)))
+	handle("/admin/tenants/{name}", logMiddleware(s.adminMiddleware(s.handleTenant)))
+	handle("/admin/requests/{id}/events", logMiddleware(s.adminMiddleware(s.handleRequestEvents)))
+	handle("/admin/requests/{id}/cancel", logMiddleware(s.adminMiddleware(s.handleCancelRequest)))
+	handle("/admin/cancel-all", logMiddleware(s.adminMiddleware(s.handleCancelAll)))
+	handle("/admin/maintenance", logMiddleware(s.adminMiddleware(s.handleMaintenance)))
+	// 日志接口本身不记录请求日志，避免 follow 时看到自己的访问记录
+	handle("/admin/logs", s.adminMiddleware(s.handleLogs))
+	handle("/metrics", s.adminMiddleware(s.handleMetrics))
+	handle("/admin/betas", logMiddleware(s.adminMiddleware(s.handleBetas)))
+	handle("/admin/debug/pprof/", logMiddleware(s.adminMiddleware(s.handlePprof)))
+	// 面板页面本身不含数据，数据接口仍需 admin token
+	handle("/admin/dashboard", s.handleDashboard)
+}
+
+// NormalizeBasePath 把挂载前缀规范化为以 "/" 开头、不以 "/" 结尾的形式，"" 和 "/" 都表示挂载在根路径
+func NormalizeBasePath(basePath string) string {
+	basePath = strings.Trim(strings.TrimSpace(basePath), "/")
+	if basePath == "" {
+		return ""
+	}
+	return "/" + basePath
+}
+
+// getToken 获取当前token
+func (s *Server) getToken() (token.Data, error) {
+	return token.Read(s.config.TokenPath)
+}
+
+// refreshTokenSilently 静默刷新token，用于服务器内部调用。
+// 并发的刷新由 refresher 协调，同一时间只有一个副本真正请求刷新接口
+func (s *Server) refreshTokenSilently() error {
+	if s.config.Auth == AuthSigV4 {
+		return fmt.Errorf("SigV4 模式下没有可刷新的 token")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), tokenRefreshTimeout)
+	defer cancel()
+	_, err := token.RefreshCoordinated(ctx, s.config.TokenPath, s.refresher)
+	s.tokenMetrics.recordRefresh(err)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Token已静默刷新\n")
+	return nil
+}
+
+// logMiddleware 记录所有HTTP请求的中间件
+func logMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		startTime := time.Now()
+
+		// fmt.Printf("\n=== 收到请求 ===\n")
+		// fmt.Printf("时间: %s\n", startTime.Format("2006-01-02 15:04:05"))
+		// fmt.Printf("请求方法: %s\n", r.Method)
+		// fmt.Printf("请求路径: %s\n", r.URL.Path)
+		// fmt.Printf("客户端IP: %s\n", r.RemoteAddr)
+		// fmt.Printf("请求头:\n")
+		// for name, values := range r.Header {
+		// 	fmt.Printf("  %s: %s\n", name, strings.Join(values, ", "))
+		// }
+
+		// 调用下一个处理器
+		next(w, r)
+
+		// 计算处理时间
+		duration := time.Since(startTime)
+		fmt.Printf("处理时间: %v\n", duration)
+		fmt.Printf("=== 请求结束 ===\n\n")
+	}
+}
+
+// handleMessages 处理 /v1/messages 请求
+func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request) {
+	// 调试模式下允许用 GET 和查询参数发起请求
+	if r.Method == http.MethodGet && s.config.Debug {
+		s.handleDebugMessage(w, r)
+		return
+	}
+
+	// 只处理POST请求
+	if r.Method != http.MethodPost {
+		fmt.Printf("错误: 不支持的请求方法\n")
+		http.Error(w, "只支持POST请求", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// 获取当前token，SigV4 模式下凭证在签名时读取
+	if s.config.Auth == AuthBearer {
+		token, err := s.getToken()
+		if err != nil {
+			fmt.Printf("错误: 获取token失败: %v\n", err)
+			sendJSONErrorWithCode(w, http.StatusInternalServerError, CodeTokenUnavailable, "authentication_error", fmt.Sprintf("获取token失败: %v", err))
+			return
+		}
+
+		// 验证token不为空
+		if strings.TrimSpace(token.AccessToken) == "" {
+			fmt.Printf("错误: AccessToken为空\n")
+			sendJSONErrorWithCode(w, http.StatusUnauthorized, CodeTokenUnavailable, "authentication_error", "AccessToken为空，请先登录或刷新token")
+			return
+		}
+	}
+
+	// 声明的长度已超过限制时直接拒绝，带 Expect: 100-continue 的客户端不会再上传请求体
+	if r.ContentLength > maxRequestBodySize {
+		sendJSONError(w, http.StatusRequestEntityTooLarge, "request_too_large", fmt.Sprintf("请求体超过 %d 字节限制", maxRequestBodySize))
+		return
+	}
+
+	// 限制请求体大小，chunked 请求体在读取过程中超限时同样返回 413
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+	defer r.Body.Close()
+
+	// 读取请求体，首次读取时标准库会自动回复 100 Continue。
+	// 缓冲区在处理结束后放回池中，解析结果不引用 body 的内存
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if _, err := buf.ReadFrom(r.Body); err != nil {
+		fmt.Printf("错误: 读取请求体失败: %v\n", err)
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			sendJSONError(w, http.StatusRequestEntityTooLarge, "request_too_large", fmt.Sprintf("请求体超过 %d 字节限制", maxErr.Limit))
+			return
+		}
+		sendJSONError(w, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("读取请求体失败: %v", err))
+		return
+	}
+	body := buf.Bytes()
+
+	// 验证请求体不为空
+	if len(body) == 0 {
+		sendJSONError(w, http.StatusBadRequest, "invalid_request_error", "请求体不能为空")
+		return
+	}
+
+	r = r.WithContext(s.logRequestBody(r.Context(), "Anthropic", body))
+
+	anthropicReq, reqErr := s.parseAnthropicRequest(body)
+	if reqErr != nil {
+		fmt.Printf("错误: %s\n", reqErr.Message)
+		sendJSONError(w, reqErr.Status, reqErr.Type, reqErr.Message)
+		return
+	}
+
+	stream, warning := resolveStream(r, anthropicReq.Stream, s.config.HonorAccept)
+	if warning != "" {
+		fmt.Printf("警告: %s\n", warning)
+	}
+	anthropicReq.Stream = stream
+
+	// 携带幂等键的重试直接返回之前缓存的响应
+	if key := r.Header.Get(IdempotencyKeyHeader); key != "" && s.idempotency != nil {
+		w.Header().Set(IdempotencyKeyHeader, key)
+		cached, reqErr := s.idempotency.begin(key, body)
+		if reqErr != nil {
+			sendJSONError(w, reqErr.Status, reqErr.Type, reqErr.Message)
+			return
+		}
+		if cached != nil {
+			fmt.Printf("幂等键 %s 命中缓存，直接返回之前的响应\n", key)
+			cached.replay(w)
+			return
+		}
+		cw := &captureWriter{ResponseWriter: w}
+		defer func() {
+			s.idempotency.finish(key, cw.status, w.Header().Clone(), cw.body)
+		}()
+		w = cw
+	}
+
+	// 中间设备会无限期缓冲 SSE 时，流式请求在代理内部完成后以单个 JSON 返回
+	if anthropicReq.Stream && s.config.BufferStreams {
+		fmt.Printf("流式请求按 --buffer-streams 缓冲为单个 JSON 响应\n")
+		w.Header().Set(StreamBufferedHeader, "stream requested but returned as a single JSON message (--buffer-streams)")
+		s.handleNonStreamRequest(r.Context(), w, anthropicReq, streamRequestTimeout)
+		return
+	}
+
+	// 如果是流式请求
+	if anthropicReq.Stream {
+		s.handleStreamRequest(r.Context(), w, anthropicReq, prefersNDJSON(r.Header.Get("Accept")))
+		return
+	}
+
+	// 非流式请求处理
+	s.handleNonStreamRequest(r.Context(), w, anthropicReq, nonStreamRequestTimeout)
+}
@@ -0,0 +1,536 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+
+	"github.com/bestk/kiro2cc/parser"
+)
+
+// newTestServer 使用临时token文件创建服务器
+func newTestServer(t testing.TB, config Config) *Server {
+	t.Helper()
+	config.TokenPath = filepath.Join(t.TempDir(), "kiro-auth-token.json")
+	if err := os.WriteFile(config.TokenPath, []byte(`{"accessToken":"access","refreshToken":"refresh"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	srv, err := New(config)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return srv
+}
+
+func TestHandlerMountedOnExternalMux(t *testing.T) {
+	srv := newTestServer(t, Config{})
+
+	mux := http.NewServeMux()
+	mux.Handle("/v1/", srv.Handler())
+	mux.Handle("/health", srv.Handler())
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "OK" {
+		t.Fatalf("health: %d %q", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	body := strings.NewReader(`{"model":"no-such-model","max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`)
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/messages", body))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "invalid_request_error") {
+		t.Fatalf("messages: %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestNewDefaults(t *testing.T) {
+	t.Setenv("KIRO_PROFILE_ARN", "")
+	srv := newTestServer(t, Config{})
+	if srv.config.Addr != ":8080" {
+		t.Errorf("Addr = %q", srv.config.Addr)
+	}
+	if srv.config.ProfileArn != DefaultProfileArn {
+		t.Errorf("ProfileArn = %q", srv.config.ProfileArn)
+	}
+}
+
+// localRequest 构造来自本机的请求，用于访问管理接口
+func localRequest(method, target string) *http.Request {
+	req := httptest.NewRequest(method, target, nil)
+	req.RemoteAddr = "127.0.0.1:12345"
+	return req
+}
+
+// fakeBackend 返回预设事件，避免测试访问真实上游
+type fakeBackend struct {
+	events []parser.SSEEvent
+	err    error
+	reqs   []AnthropicRequest
+}
+
+func (f *fakeBackend) Generate(ctx context.Context, anthropicReq AnthropicRequest) ([]parser.SSEEvent, error) {
+	f.reqs = append(f.reqs, anthropicReq)
+	diag := diagnosticsFrom(ctx)
+	diag.Status = http.StatusOK
+	diag.Latency = 1500 * time.Millisecond
+	var upErr *UpstreamError
+	if errors.As(f.err, &upErr) {
+		diag.Status = upErr.StatusCode
+	}
+	return f.events, f.err
+}
+
+// textEvents 构造文本回复对应的上游事件
+func textEvents(texts ...string) []parser.SSEEvent {
+	var events []parser.SSEEvent
+	for _, text := range texts {
+		events = append(events, parser.TextDeltaEvent(text))
+	}
+	return events
+}
+
+func TestNonStreamUsesBackend(t *testing.T) {
+	backend := &fakeBackend{events: textEvents("Hello ", "world!")}
+	srv := newTestServer(t, Config{Backend: backend})
+
+	rec := httptest.NewRecorder()
+	body := strings.NewReader(`{"model":"claude-sonnet-4-20250514","max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`)
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/messages", body))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), `"text":"Hello world!"`) {
+		t.Fatalf("unexpected body: %s", rec.Body.String())
+	}
+	if len(backend.reqs) != 1 || backend.reqs[0].Model != "claude-sonnet-4-20250514" {
+		t.Fatalf("backend requests: %+v", backend.reqs)
+	}
+}
+
+func TestUpstreamErrorMapping(t *testing.T) {
+	backend := &fakeBackend{err: &UpstreamError{StatusCode: http.StatusTooManyRequests, Body: "throttled"}}
+	srv := newTestServer(t, Config{Backend: backend})
+
+	rec := httptest.NewRecorder()
+	body := strings.NewReader(`{"model":"claude-sonnet-4-20250514","max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`)
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/messages", body))
+	if rec.Code != http.StatusTooManyRequests || !strings.Contains(rec.Body.String(), "rate_limit_error") || !strings.Contains(rec.Body.String(), `"code":"KIRO2CC-UPSTREAM-THROTTLED"`) {
+		t.Fatalf("unexpected response: %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestProfileMismatchMapping(t *testing.T) {
+	for _, tt := range []struct {
+		header, body string
+	}{
+		{header: "AccessDeniedException:http://internal.amazon.com/coral/com.amazon.aws.codewhisperer/", body: `{"message":"User is not authorized"}`},
+		{body: `{"__type":"com.amazon.aws.codewhisperer#AccessDeniedException","message":"User is not authorized"}`},
+	} {
+		resp := &http.Response{StatusCode: http.StatusForbidden, Header: http.Header{}}
+		if tt.header != "" {
+			resp.Header.Set("x-amzn-ErrorType", tt.header)
+		}
+		upErr := newUpstreamError(resp, []byte(tt.body))
+		if upErr.Type != "AccessDeniedException" || !upErr.ProfileMismatch() {
+			t.Errorf("newUpstreamError(%q, %q) = %+v", tt.header, tt.body, upErr)
+		}
+	}
+
+	// 账号不匹配时不刷新 token，而是提示如何设置 KIRO_PROFILE_ARN
+	backend := &fakeBackend{err: &UpstreamError{StatusCode: http.StatusForbidden, Type: "AccessDeniedException"}}
+	srv := newTestServer(t, Config{Backend: backend})
+	for _, stream := range []string{"false", "true"} {
+		rec := httptest.NewRecorder()
+		body := strings.NewReader(`{"model":"claude-sonnet-4-20250514","max_tokens":10,"stream":` + stream + `,"messages":[{"role":"user","content":"hi"}]}`)
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/messages", body))
+		if !strings.Contains(rec.Body.String(), "KIRO_PROFILE_ARN") || !strings.Contains(rec.Body.String(), string(CodeProfileMismatch)) {
+			t.Errorf("stream=%s: unexpected response: %d %s", stream, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestDebugHeaders(t *testing.T) {
+	for _, debug := range []bool{false, true} {
+		srv := newTestServer(t, Config{Debug: debug, Backend: &fakeBackend{events: textEvents("hi")}})
+		for _, stream := range []string{"false", "true"} {
+			rec := httptest.NewRecorder()
+			body := strings.NewReader(`{"model":"claude-sonnet-4-20250514","max_tokens":10,"stream":` + stream + `,"messages":[{"role":"user","content":"hi"}]}`)
+			srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/messages", body))
+
+			status := rec.Header().Get("x-kiro2cc-upstream-status")
+			latency := rec.Header().Get("x-kiro2cc-upstream-latency-ms")
+			if debug && (status != "200" || latency != "1500" || rec.Header().Get("x-kiro2cc-retries") != "0") {
+				t.Errorf("stream=%s: missing debug headers: %v", stream, rec.Header())
+			}
+			if !debug && status != "" {
+				t.Errorf("stream=%s: debug headers leaked without debug mode", stream)
+			}
+		}
+	}
+}
+
+func TestDebugGetMessage(t *testing.T) {
+	backend := &fakeBackend{events: textEvents("Hello ", "world!")}
+	srv := newTestServer(t, Config{Backend: backend})
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/messages?prompt=hi", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("GET without debug: status %d", rec.Code)
+	}
+
+	srv = newTestServer(t, Config{Debug: true, Backend: backend})
+	rec = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/messages?prompt=hi&model=claude-3-5-haiku-20241022", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "Hello world!\n" {
+		t.Fatalf("unexpected response: %d %q", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/plain") {
+		t.Errorf("Content-Type = %q", got)
+	}
+	req := backend.reqs[len(backend.reqs)-1]
+	if req.Model != "claude-3-5-haiku-20241022" || getMessageContent(req.Messages[0].Content) != "hi" {
+		t.Errorf("unexpected upstream request: %+v", req)
+	}
+
+	for _, target := range []string{"/v1/messages", "/v1/messages?prompt=hi&model=gpt-4", "/v1/messages?prompt=hi&max_tokens=-1"} {
+		rec = httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", target, rec.Code)
+		}
+	}
+}
+
+// blockingBackend 阻塞直到 ctx 被取消，用于验证客户端断开时上游请求被中止
+type blockingBackend struct {
+	started   chan struct{}
+	cancelled chan error
+}
+
+func (b *blockingBackend) Generate(ctx context.Context, anthropicReq AnthropicRequest) ([]parser.SSEEvent, error) {
+	close(b.started)
+	<-ctx.Done()
+	b.cancelled <- ctx.Err()
+	return nil, ctx.Err()
+}
+
+func TestClientDisconnectCancelsUpstream(t *testing.T) {
+	backend := &blockingBackend{started: make(chan struct{}), cancelled: make(chan error, 1)}
+	srv := newTestServer(t, Config{Backend: backend})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	body := strings.NewReader(`{"model":"claude-sonnet-4-20250514","max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`)
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", body).WithContext(ctx)
+	rec := httptest.NewRecorder()
+
+	done := make(chan struct{})
+	go func() {
+		srv.Handler().ServeHTTP(rec, req)
+		close(done)
+	}()
+
+	<-backend.started
+	cancel()
+
+	select {
+	case err := <-backend.cancelled:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("upstream ctx error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("upstream request was not cancelled")
+	}
+	<-done
+	if rec.Body.Len() != 0 {
+		t.Fatalf("expected no response body for a disconnected client, got %s", rec.Body.String())
+	}
+}
+
+// countingReader 记录被读取的字节数，用于确认请求体是否被上传
+type countingReader struct {
+	r io.Reader
+	n atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+func TestChunkedAndExpectContinueBodies(t *testing.T) {
+	srv := newTestServer(t, Config{Backend: &fakeBackend{events: textEvents("hi")}})
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	client := &http.Client{Transport: &http.Transport{ExpectContinueTimeout: 5 * time.Second}}
+	post := func(body io.Reader, contentLength int64) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/v1/messages", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 长度为 -1 时以 chunked 编码发送
+		req.ContentLength = contentLength
+		req.Header.Set("Expect", "100-continue")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	errorType := func(resp *http.Response) string {
+		t.Helper()
+		defer resp.Body.Close()
+		var errResp AnthropicErrorResponse
+		json.NewDecoder(resp.Body).Decode(&errResp)
+		return errResp.Error.Type
+	}
+
+	valid := `{"model":"claude-sonnet-4-20250514","max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`
+	resp := post(io.MultiReader(strings.NewReader(valid)), -1)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("chunked request: status = %d", resp.StatusCode)
+	}
+	resp.Body.Close()
+
+	// chunked 请求体在读取过程中超限
+	huge := io.MultiReader(strings.NewReader(`{"model":"claude-sonnet-4-20250514","messages":"`), strings.NewReader(strings.Repeat("a", maxRequestBodySize+1)))
+	resp = post(huge, -1)
+	if resp.StatusCode != http.StatusRequestEntityTooLarge || errorType(resp) != "request_too_large" {
+		t.Fatalf("oversized chunked request: status = %d", resp.StatusCode)
+	}
+
+	// 声明的长度超限时不应等待上传请求体
+	body := &countingReader{r: strings.NewReader(strings.Repeat("a", maxRequestBodySize+1))}
+	resp = post(body, maxRequestBodySize+1)
+	if resp.StatusCode != http.StatusRequestEntityTooLarge || errorType(resp) != "request_too_large" {
+		t.Fatalf("oversized Content-Length: status = %d", resp.StatusCode)
+	}
+	if n := body.n.Load(); n > 0 {
+		t.Fatalf("client uploaded %d bytes despite early rejection", n)
+	}
+}
+
+// assertFlushedStream 发送流式请求，确认首个事件在流结束前到达，即 SSE 在 HTTP/2 下逐个刷新
+func assertFlushedStream(t *testing.T, srv *Server, client *http.Client, url string) {
+	t.Helper()
+	body := strings.NewReader(`{"model":"claude-sonnet-4-20250514","max_tokens":10,"stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	resp, err := client.Post(url+"/v1/messages", "application/json", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("protocol = %s, want HTTP/2", resp.Proto)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	if line, _ := reader.ReadString('\n'); line != "event: message_start\n" {
+		t.Fatalf("first line = %q", line)
+	}
+	if len(srv.inflight.snapshot()) == 0 {
+		t.Fatal("first event arrived only after the stream finished; SSE is not flushed")
+	}
+	rest, _ := io.ReadAll(reader)
+	if !strings.Contains(string(rest), "event: message_stop") {
+		t.Fatalf("incomplete stream: %s", rest)
+	}
+}
+
+func TestHTTP2OverTLS(t *testing.T) {
+	srv := newTestServer(t, Config{Backend: &fakeBackend{events: textEvents(strings.Split("a b c d e f g h i j", " ")...)}})
+	ts := httptest.NewUnstartedServer(srv.listenerHandler(nil))
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	assertFlushedStream(t, srv, ts.Client(), ts.URL)
+}
+
+func TestH2C(t *testing.T) {
+	srv := newTestServer(t, Config{H2C: true, Backend: &fakeBackend{events: textEvents(strings.Split("a b c d e f g h i j", " ")...)}})
+	ts := httptest.NewServer(srv.listenerHandler(nil))
+	defer ts.Close()
+
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+	assertFlushedStream(t, srv, client, ts.URL)
+
+	if _, err := New(Config{TokenPath: "unused", TLSCertFile: "cert.pem"}); err == nil {
+		t.Fatal("expected TLSCertFile without TLSKeyFile to be rejected")
+	}
+}
+
+func TestSystemPrefix(t *testing.T) {
+	backend := &fakeBackend{events: textEvents("ok")}
+	srv := newTestServer(t, Config{Backend: backend, SystemPrefix: "Follow org policy."})
+
+	rec := httptest.NewRecorder()
+	body := strings.NewReader(`{"model":"claude-sonnet-4-20250514","max_tokens":10,"system":[{"type":"text","text":"You are helpful."}],"messages":[{"role":"user","content":"hi"}]}`)
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/messages", body))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	system := backend.reqs[0].System
+	if len(system) != 2 || system[0].Text != "Follow org policy." || system[1].Text != "You are helpful." {
+		t.Errorf("system = %+v, want prefix before the client's system", system)
+	}
+	if strings.Contains(rec.Body.String(), "org policy") {
+		t.Errorf("prefix leaked into the response: %s", rec.Body.String())
+	}
+}
+
+func TestBasePath(t *testing.T) {
+	srv := newTestServer(t, Config{Backend: &fakeBackend{events: textEvents("ok")}, BasePath: "kiro/", AdminToken: "s3cret"})
+
+	for path, want := range map[string]int{
+		"/kiro/v1/messages": http.StatusOK,
+		"/v1/messages":      http.StatusNotFound,
+		"/kiro/health":      http.StatusOK,
+	} {
+		rec := httptest.NewRecorder()
+		body := strings.NewReader(`{"model":"claude-sonnet-4-20250514","max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`)
+		method := http.MethodPost
+		if strings.HasSuffix(path, "/health") {
+			method = http.MethodGet
+		}
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(method, path, body))
+		if rec.Code != want {
+			t.Errorf("%s: status %d, want %d", path, rec.Code, want)
+		}
+	}
+
+	for input, want := range map[string]string{"": "", "/": "", "kiro": "/kiro", "/kiro/": "/kiro", "/a/b": "/a/b"} {
+		if got := NormalizeBasePath(input); got != want {
+			t.Errorf("NormalizeBasePath(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestNonStreamResponseHasContentLength(t *testing.T) {
+	// 远超 net/http 内部缓冲的响应，直接用 Encoder 写出时会变成 chunked
+	large := strings.Repeat("长文本 long text ", 64<<10)
+	backend := &fakeBackend{events: textEvents(large)}
+	srv := newTestServer(t, Config{Backend: backend})
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	for _, tc := range []struct {
+		name, body string
+		status     int
+	}{
+		{"large", hookTestBody, http.StatusOK},
+		{"error", `{"model":"claude-sonnet-4-20250514","messages":[]}`, http.StatusBadRequest},
+	} {
+		resp, err := http.Post(ts.URL+"/v1/messages", "application/json", strings.NewReader(tc.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tc.status {
+			t.Fatalf("%s: status = %d, body = %.200s", tc.name, resp.StatusCode, body)
+		}
+		if len(resp.TransferEncoding) != 0 || resp.ContentLength != int64(len(body)) {
+			t.Errorf("%s: transfer encoding = %v, content length = %d, body length = %d", tc.name, resp.TransferEncoding, resp.ContentLength, len(body))
+		}
+		if !json.Valid(body) {
+			t.Errorf("%s: response is not complete JSON", tc.name)
+		}
+	}
+
+	var msg struct {
+		Content []struct {
+			Text string `json:"text"`
+		} `json:"content"`
+	}
+	resp, err := http.Post(ts.URL+"/v1/messages", "application/json", strings.NewReader(hookTestBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.Content) != 1 || msg.Content[0].Text != large {
+		t.Errorf("response text was truncated")
+	}
+}
+
+func TestTruncatedUpstreamReturnsPartialContent(t *testing.T) {
+	partial := "data: {\"content\":\"first half \"}\n\ndata: {\"content\":\"of the answer\"}\n\n"
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 声明的长度大于实际写出的内容，连接关闭时客户端读到 unexpected EOF
+		w.Header().Set("Content-Length", "4096")
+		io.WriteString(w, partial)
+	}))
+	defer upstream.Close()
+
+	srv := newTestServer(t, Config{})
+	srv.backend.(*codeWhispererBackend).url = upstream.URL
+
+	rec := postMessages(srv, hookTestBody)
+	if rec.Code != http.StatusOK || rec.Header().Get("x-kiro2cc-truncated") != "true" {
+		t.Fatalf("status %d, truncated %q: %s", rec.Code, rec.Header().Get("x-kiro2cc-truncated"), rec.Body.String())
+	}
+	var resp struct {
+		StopReason string `json:"stop_reason"`
+		Content    []struct {
+			Text string `json:"text"`
+		} `json:"content"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StopReason != "end_turn" || len(resp.Content) != 1 || resp.Content[0].Text != "first half of the answer" {
+		t.Errorf("response = %+v", resp)
+	}
+
+	rec = postMessages(srv, strings.Replace(hookTestBody, `"max_tokens"`, `"stream":true,"max_tokens"`, 1))
+	body := rec.Body.String()
+	if rec.Header().Get("x-kiro2cc-truncated") != "true" || !strings.Contains(body, "of the answer") {
+		t.Fatalf("stream body = %s", body)
+	}
+	if !strings.Contains(body, string(CodeUpstreamTruncated)) || strings.Contains(body, "message_stop") {
+		t.Errorf("stream should end with an error event instead of message_stop: %s", body)
+	}
+}
+
+func TestUpstreamDropWithoutContentIsError(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "4096")
+		io.WriteString(w, "data: {\"con")
+	}))
+	defer upstream.Close()
+
+	srv := newTestServer(t, Config{})
+	srv.backend.(*codeWhispererBackend).url = upstream.URL
+
+	rec := postMessages(srv, hookTestBody)
+	if rec.Code != http.StatusInternalServerError || rec.Header().Get("x-kiro2cc-truncated") != "" {
+		t.Errorf("status %d, truncated %q: %s", rec.Code, rec.Header().Get("x-kiro2cc-truncated"), rec.Body.String())
+	}
+}
@@ -0,0 +1,212 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sessionLimit 是保存的会话映射数量上限，超出时丢弃最久未使用的会话
+const sessionLimit = 1000
+
+// sessionEntry 表示一个会话指纹对应的 CodeWhisperer conversationId
+type sessionEntry struct {
+	Fingerprint    string    `json:"fingerprint"`
+	ConversationID string    `json:"conversation_id"`
+	LastUsed       time.Time `json:"last_used"`
+}
+
+// sessionStore 保存会话指纹到 conversationId 的映射，单实例使用文件，多实例共享 Redis
+type sessionStore interface {
+	// conversationID 返回指纹对应的 conversationId，新会话会分配新的 ID
+	conversationID(ctx context.Context, fingerprint string) (string, error)
+	// export 按最近使用时间返回所有会话映射
+	export(ctx context.Context) ([]sessionEntry, error)
+	// merge 导入会话映射，同一指纹保留最近使用的一条，返回导入数量
+	merge(ctx context.Context, entries []sessionEntry) (int, error)
+}
+
+// fileSessionStore 为同一个客户端会话的多轮请求分配固定的 conversationId，
+// 并把映射保存到磁盘，代理重启后进行中的 Claude Code 会话仍能延续
+type fileSessionStore struct {
+	mu       sync.Mutex
+	path     string
+	sessions map[string]*sessionEntry
+}
+
+// loadSessions 从 path 加载会话映射，文件不存在时从空映射开始
+func loadSessions(path string) (*fileSessionStore, error) {
+	sm := &fileSessionStore{path: path, sessions: make(map[string]*sessionEntry)}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return sm, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取会话文件失败: %v", err)
+	}
+	var entries []sessionEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("解析会话文件失败: %v", err)
+	}
+	sm.mergeLocked(entries)
+	return sm, nil
+}
+
+// sessionFingerprint 以 system 和第一条消息标识一个会话，后续轮次只会在末尾追加消息
+func sessionFingerprint(anthropicReq AnthropicRequest) string {
+	h := sha256.New()
+	for _, sys := range anthropicReq.System {
+		h.Write([]byte(sys.Text))
+		h.Write([]byte{0})
+	}
+	if len(anthropicReq.Messages) > 0 {
+		h.Write([]byte(getMessageContent(anthropicReq.Messages[0].Content)))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// conversationID 返回会话的 conversationId，新会话会分配新的 ID 并保存
+func (sm *fileSessionStore) conversationID(ctx context.Context, fingerprint string) (string, error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	entry, ok := sm.sessions[fingerprint]
+	if !ok {
+		entry = &sessionEntry{Fingerprint: fingerprint, ConversationID: generateUUID()}
+		sm.sessions[fingerprint] = entry
+	}
+	entry.LastUsed = time.Now()
+	sm.evictLocked()
+	if !ok {
+		// 只在新增会话时写盘，LastUsed 的变化不值得每个请求都写一次；
+		// 写盘失败只影响重启后的延续，不影响本次请求
+		if err := sm.saveLocked(); err != nil {
+			fmt.Printf("保存会话文件失败: %v\n", err)
+		}
+	}
+	return entry.ConversationID, nil
+}
+
+func (sm *fileSessionStore) export(ctx context.Context) ([]sessionEntry, error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	entries := make([]sessionEntry, 0, len(sm.sessions))
+	for _, entry := range sm.sessions {
+		entries = append(entries, *entry)
+	}
+	sortSessions(entries)
+	return entries, nil
+}
+
+func (sm *fileSessionStore) merge(ctx context.Context, entries []sessionEntry) (int, error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	imported := sm.mergeLocked(entries)
+	if err := sm.saveLocked(); err != nil {
+		return imported, fmt.Errorf("保存会话文件失败: %v", err)
+	}
+	return imported, nil
+}
+
+// mergeLocked 合并会话映射，调用方需持有锁
+func (sm *fileSessionStore) mergeLocked(entries []sessionEntry) int {
+	imported := 0
+	for _, entry := range entries {
+		if entry.Fingerprint == "" || entry.ConversationID == "" {
+			continue
+		}
+		if existing, ok := sm.sessions[entry.Fingerprint]; ok && existing.LastUsed.After(entry.LastUsed) {
+			continue
+		}
+		e := entry
+		sm.sessions[entry.Fingerprint] = &e
+		imported++
+	}
+	sm.evictLocked()
+	return imported
+}
+
+// evictLocked 丢弃超出上限的最久未使用的会话，调用方需持有锁
+func (sm *fileSessionStore) evictLocked() {
+	for len(sm.sessions) > sessionLimit {
+		var oldest *sessionEntry
+		for _, entry := range sm.sessions {
+			if oldest == nil || entry.LastUsed.Before(oldest.LastUsed) {
+				oldest = entry
+			}
+		}
+		delete(sm.sessions, oldest.Fingerprint)
+	}
+}
+
+// saveLocked 先写临时文件再重命名，避免进程中途退出留下损坏的文件，调用方需持有锁
+func (sm *fileSessionStore) saveLocked() error {
+	entries := make([]sessionEntry, 0, len(sm.sessions))
+	for _, entry := range sm.sessions {
+		entries = append(entries, *entry)
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(sm.path), 0700); err != nil {
+		return err
+	}
+	tmp := sm.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, sm.path)
+}
+
+// sortSessions 按最近使用时间排序
+func sortSessions(entries []sessionEntry) {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].LastUsed.After(entries[j].LastUsed) })
+}
+
+// handleSessions 导出 (GET) 或导入 (POST) 会话映射，可用于把会话迁移到另一台代理
+func (s *Server) handleSessions(w http.ResponseWriter, r *http.Request) {
+	if s.sessions == nil {
+		sendJSONError(w, http.StatusNotFound, "not_found_error", "未开启会话保存，请使用 --session-file 或 --redis 启动服务器")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		entries, err := s.sessions.export(r.Context())
+		if err != nil {
+			sendJSONError(w, http.StatusInternalServerError, "api_error", fmt.Sprintf("导出会话失败: %v", err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"sessions": entries})
+	case http.MethodPost:
+		var body struct {
+			Sessions []sessionEntry `json:"sessions"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			sendJSONError(w, http.StatusBadRequest, "invalid_request_error", "解析会话失败: "+strings.TrimSpace(err.Error()))
+			return
+		}
+		imported, err := s.sessions.merge(r.Context(), body.Sessions)
+		if err != nil {
+			sendJSONError(w, http.StatusInternalServerError, "api_error", fmt.Sprintf("导入会话失败: %v", err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"imported": imported})
+	default:
+		http.Error(w, "只支持GET和POST请求", http.StatusMethodNotAllowed)
+	}
+}
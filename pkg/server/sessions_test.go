@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bestk/kiro2cc/pkg/redis/redistest"
+)
+
+func TestSessionsSurviveRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.json")
+	sm, err := loadSessions(path)
+	if err != nil {
+		t.Fatalf("loadSessions: %v", err)
+	}
+	id := checkSessionStore(t, sm)
+
+	restarted, err := loadSessions(path)
+	if err != nil {
+		t.Fatalf("loadSessions after restart: %v", err)
+	}
+	if got := conversationIDFor(t, restarted, "fix the bug"); got != id {
+		t.Fatal("conversationId was not restored after restart")
+	}
+}
+
+func TestRedisSessionsSharedAcrossReplicas(t *testing.T) {
+	redisSrv := redistest.NewServer(t)
+	replicaA := newTestServer(t, Config{RedisURL: redisSrv.URL()})
+	replicaB := newTestServer(t, Config{RedisURL: redisSrv.URL()})
+
+	id := checkSessionStore(t, replicaA.sessions)
+	if got := conversationIDFor(t, replicaB.sessions, "fix the bug"); got != id {
+		t.Fatalf("replica B conversationId = %q, want %q", got, id)
+	}
+	entries, err := replicaB.sessions.export(context.Background())
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("export = %+v, %v", entries, err)
+	}
+}
+
+// conversationIDFor 返回以 first 开头的会话的 conversationId
+func conversationIDFor(t *testing.T, store sessionStore, first string, rest ...string) string {
+	t.Helper()
+	req := AnthropicRequest{Messages: []AnthropicRequestMessage{{Role: "user", Content: first}}}
+	for i, content := range rest {
+		role := "assistant"
+		if i%2 == 1 {
+			role = "user"
+		}
+		req.Messages = append(req.Messages, AnthropicRequestMessage{Role: role, Content: content})
+	}
+	id, err := store.conversationID(context.Background(), sessionFingerprint(req))
+	if err != nil {
+		t.Fatalf("conversationID: %v", err)
+	}
+	return id
+}
+
+// checkSessionStore 验证同一会话的多轮请求复用 conversationId，不同会话互不影响
+func checkSessionStore(t *testing.T, store sessionStore) string {
+	t.Helper()
+	id := conversationIDFor(t, store, "fix the bug")
+	if conversationIDFor(t, store, "fix the bug", "done", "now add a test") != id {
+		t.Fatal("later turns of the same session should reuse the conversationId")
+	}
+	if conversationIDFor(t, store, "unrelated") == id {
+		t.Fatal("different sessions should not share a conversationId")
+	}
+	return id
+}
+
+func TestSessionsExportImport(t *testing.T) {
+	src := newTestServer(t, Config{SessionFile: filepath.Join(t.TempDir(), "sessions.json")})
+	id := conversationIDFor(t, src.sessions, "hi")
+
+	rec := httptest.NewRecorder()
+	src.Handler().ServeHTTP(rec, localRequest(http.MethodGet, "/admin/sessions"))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), id) {
+		t.Fatalf("export: %d %s", rec.Code, rec.Body.String())
+	}
+
+	dst := newTestServer(t, Config{SessionFile: filepath.Join(t.TempDir(), "sessions.json")})
+	imp := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/admin/sessions", strings.NewReader(rec.Body.String()))
+	req.RemoteAddr = "127.0.0.1:12345"
+	dst.Handler().ServeHTTP(imp, req)
+	var result struct {
+		Imported int `json:"imported"`
+	}
+	if err := json.Unmarshal(imp.Body.Bytes(), &result); err != nil || result.Imported != 1 {
+		t.Fatalf("import: %d %s", imp.Code, imp.Body.String())
+	}
+	if got := conversationIDFor(t, dst.sessions, "hi"); got != id {
+		t.Fatalf("imported conversationId = %q, want %q", got, id)
+	}
+
+	off := newTestServer(t, Config{})
+	rec = httptest.NewRecorder()
+	off.Handler().ServeHTTP(rec, localRequest(http.MethodGet, "/admin/sessions"))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("sessions disabled: status = %d", rec.Code)
+	}
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"strconv"
+)
+
+// SignatureHeader 是响应签名所在的响应头，流式响应中作为 trailer 在响应体结束后发送
+const SignatureHeader = "X-Kiro2cc-Signature"
+
+// signaturePrefix 标明签名算法，值为响应体的 HMAC-SHA256 十六进制摘要
+const signaturePrefix = "sha256="
+
+// signResponseMiddleware 在配置了 SigningSecret 时用 HMAC-SHA256 对响应体签名，
+// 下游可以用共享的密钥确认响应来自可信的代理实例且未被篡改
+func (s *Server) signResponseMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	if s.config.SigningSecret == "" {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		sw := &signingWriter{ResponseWriter: w, mac: hmac.New(sha256.New, []byte(s.config.SigningSecret))}
+		next(sw, r)
+		sw.finish()
+	}
+}
+
+// signingWriter 在写出响应体的同时计算签名。普通响应先缓冲，签名作为响应头与响应体一起写出；
+// SSE、NDJSON 等流式响应不能等到结束再发送，直接写给客户端，签名作为 trailer 发送
+type signingWriter struct {
+	http.ResponseWriter
+	mac       hash.Hash
+	status    int
+	streaming bool
+	body      []byte
+}
+
+func (sw *signingWriter) WriteHeader(status int) {
+	if sw.status != 0 {
+		return
+	}
+	sw.status = status
+	if isStreamingContentType(sw.Header().Get("Content-Type")) {
+		sw.streaming = true
+		sw.Header().Add("Trailer", SignatureHeader)
+		sw.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (sw *signingWriter) Write(p []byte) (int, error) {
+	if sw.status == 0 {
+		sw.WriteHeader(http.StatusOK)
+	}
+	sw.mac.Write(p)
+	if sw.streaming {
+		return sw.ResponseWriter.Write(p)
+	}
+	sw.body = append(sw.body, p...)
+	return len(p), nil
+}
+
+// Flush 只在流式响应中转发给底层的 http.Flusher，普通响应在 finish 时一次写出
+func (sw *signingWriter) Flush() {
+	if sw.status == 0 {
+		sw.WriteHeader(http.StatusOK)
+	}
+	if !sw.streaming {
+		return
+	}
+	if flusher, ok := sw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap 供 http.ResponseController 设置写入期限
+func (sw *signingWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// finish 写出签名，以及缓冲的普通响应
+func (sw *signingWriter) finish() {
+	signature := signaturePrefix + hex.EncodeToString(sw.mac.Sum(nil))
+	if sw.streaming {
+		sw.Header().Set(SignatureHeader, signature)
+		return
+	}
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	sw.Header().Set(SignatureHeader, signature)
+	sw.Header().Set("Content-Length", strconv.Itoa(len(sw.body)))
+	sw.ResponseWriter.WriteHeader(sw.status)
+	sw.ResponseWriter.Write(sw.body)
+}
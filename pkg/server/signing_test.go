@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bestk/kiro2cc/pkg/client"
+)
+
+func TestSignedNonStreamResponse(t *testing.T) {
+	srv := newTestServer(t, Config{Backend: &fakeBackend{events: textEvents("signed")}, SigningSecret: "s3cret"})
+
+	rec := postMessages(srv, hookTestBody)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	signature := rec.Header().Get(SignatureHeader)
+	if err := client.VerifySignature("s3cret", signature, rec.Body.Bytes()); err != nil {
+		t.Fatalf("signature %q: %v", signature, err)
+	}
+	if err := client.VerifySignature("other", signature, rec.Body.Bytes()); err == nil {
+		t.Error("signature verified with wrong secret")
+	}
+	tampered := strings.Replace(rec.Body.String(), "signed", "forged", 1)
+	if err := client.VerifySignature("s3cret", signature, []byte(tampered)); err == nil {
+		t.Error("signature verified for tampered body")
+	}
+}
+
+func TestSignedErrorResponse(t *testing.T) {
+	srv := newTestServer(t, Config{Backend: &fakeBackend{}, SigningSecret: "s3cret"})
+	rec := postMessages(srv, `{"model":"claude-sonnet-4-20250514"}`)
+	if rec.Code == http.StatusOK {
+		t.Fatalf("status %d", rec.Code)
+	}
+	if err := client.VerifySignature("s3cret", rec.Header().Get(SignatureHeader), rec.Body.Bytes()); err != nil {
+		t.Errorf("error response: %v", err)
+	}
+}
+
+func TestUnsignedWithoutSecret(t *testing.T) {
+	srv := newTestServer(t, Config{Backend: &fakeBackend{events: textEvents("ok")}})
+	if rec := postMessages(srv, hookTestBody); rec.Header().Get(SignatureHeader) != "" {
+		t.Errorf("unexpected signature %q", rec.Header().Get(SignatureHeader))
+	}
+}
+
+func TestSignedStreamTrailer(t *testing.T) {
+	delay := streamEventDelay
+	streamEventDelay = func() time.Duration { return 0 }
+	defer func() { streamEventDelay = delay }()
+	srv := newTestServer(t, Config{Backend: &fakeBackend{events: textEvents("Hello ", "world!")}, SigningSecret: "s3cret"})
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/v1/messages", "application/json",
+		strings.NewReader(`{"model":"claude-sonnet-4-20250514","max_tokens":10,"stream":true,"messages":[{"role":"user","content":"hi"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.VerifySignature("s3cret", resp.Trailer.Get(SignatureHeader), body); err != nil {
+		t.Fatalf("trailer %q: %v", resp.Trailer.Get(SignatureHeader), err)
+	}
+	assertConformantStream(t, string(body))
+
+	c := client.New(ts.URL, "")
+	c.SigningSecret = "s3cret"
+	stream, err := c.MessagesStream(context.Background(), client.MessageRequest{
+		Model: "claude-sonnet-4-20250514", MaxTokens: 10, Messages: []client.Message{{Role: "user", Content: "hi"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	for stream.Next() {
+	}
+	if err := stream.Err(); err != nil {
+		t.Errorf("client stream: %v", err)
+	}
+
+	c.SigningSecret = "other"
+	if _, err := c.Messages(context.Background(), client.MessageRequest{
+		Model: "claude-sonnet-4-20250514", MaxTokens: 10, Messages: []client.Message{{Role: "user", Content: "hi"}},
+	}); err != client.ErrInvalidSignature {
+		t.Errorf("wrong secret: %v", err)
+	}
+}
@@ -0,0 +1,248 @@
+package server
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sigV4Service 是签名 CodeWhisperer 请求时使用的服务名
+const sigV4Service = "codewhisperer"
+
+// sigV4TimeFormat 是 X-Amz-Date 的时间格式
+const sigV4TimeFormat = "20060102T150405Z"
+
+// awsCredentials 是签名所需的 IAM 凭证
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken 只有临时凭证才有
+	SessionToken string
+	// Region 为空时由调用方决定
+	Region string
+}
+
+// loadAWSCredentials 按 AWS CLI/SDK 的约定读取凭证：未指定 profile 且设置了 AWS_ACCESS_KEY_ID 和
+// AWS_SECRET_ACCESS_KEY 时使用环境变量，否则从 ~/.aws/credentials 和 ~/.aws/config 中读取
+// profile (依次为参数、AWS_PROFILE、default)。区域优先取 AWS_REGION、AWS_DEFAULT_REGION，其次取 profile 的 region
+func loadAWSCredentials(profile string) (awsCredentials, error) {
+	var creds awsCredentials
+	if profile == "" && os.Getenv("AWS_ACCESS_KEY_ID") != "" && os.Getenv("AWS_SECRET_ACCESS_KEY") != "" {
+		creds = awsCredentials{
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}
+	} else {
+		if profile == "" {
+			profile = os.Getenv("AWS_PROFILE")
+		}
+		if profile == "" {
+			profile = "default"
+		}
+		// credentials 文件的节名是 profile 名，config 文件中除 default 外要加 "profile " 前缀
+		section := "profile " + profile
+		if profile == "default" {
+			section = profile
+		}
+		values := map[string]string{}
+		for _, source := range []struct {
+			path    string
+			section string
+		}{
+			{awsConfigPath("AWS_CONFIG_FILE", "config"), section},
+			{awsConfigPath("AWS_SHARED_CREDENTIALS_FILE", "credentials"), profile},
+		} {
+			if source.path == "" {
+				continue
+			}
+			found, err := readINISection(source.path, source.section)
+			if err != nil {
+				return awsCredentials{}, err
+			}
+			for k, v := range found {
+				values[k] = v
+			}
+		}
+		creds = awsCredentials{
+			AccessKeyID:     values["aws_access_key_id"],
+			SecretAccessKey: values["aws_secret_access_key"],
+			SessionToken:    values["aws_session_token"],
+			Region:          values["region"],
+		}
+		if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+			return awsCredentials{}, fmt.Errorf("AWS profile %s 中没有 aws_access_key_id 和 aws_secret_access_key", profile)
+		}
+	}
+
+	for _, env := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if region := os.Getenv(env); region != "" {
+			creds.Region = region
+			break
+		}
+	}
+	return creds, nil
+}
+
+// awsConfigPath 返回 AWS 配置文件路径，环境变量优先，否则为 ~/.aws/<name>
+func awsConfigPath(env, name string) string {
+	if path := os.Getenv(env); path != "" {
+		return path
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(homeDir, ".aws", name)
+}
+
+// readINISection 读取 INI 文件中指定节的键值，文件不存在时返回空结果
+func readINISection(path, section string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取 AWS 配置文件失败: %v", err)
+	}
+	defer f.Close()
+
+	values := map[string]string{}
+	current := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			current = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		if current != section {
+			continue
+		}
+		if key, value, ok := strings.Cut(line, "="); ok {
+			values[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取 AWS 配置文件失败: %v", err)
+	}
+	return values, nil
+}
+
+// signV4 按 AWS Signature Version 4 为请求签名，body 是完整的请求体。
+// 签名覆盖 host 和签名时请求上已有的所有头，之后不应再修改这些头
+func signV4(req *http.Request, body []byte, creds awsCredentials, region, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format(sigV4TimeFormat)
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		trimmed := make([]string, len(values))
+		for i, v := range values {
+			trimmed[i] = strings.Join(strings.Fields(v), " ")
+		}
+		headers[strings.ToLower(name)] = strings.Join(trimmed, ",")
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	payloadHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURIPath(req.URL),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalURIPath 返回签名用的路径，每一段按 RFC 3986 编码
+func canonicalURIPath(u *url.URL) string {
+	path := u.EscapedPath()
+	if path == "" {
+		return "/"
+	}
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if unescaped, err := url.PathUnescape(segment); err == nil {
+			segment = unescaped
+		}
+		segments[i] = sigV4Escape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// canonicalQuery 返回按键和值排序、按 RFC 3986 编码的查询字符串
+func canonicalQuery(query url.Values) string {
+	var pairs []string
+	for key, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, sigV4Escape(key)+"="+sigV4Escape(value))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// sigV4Escape 按 SigV4 的要求编码，只保留 A-Z a-z 0-9 - _ . ~ 不编码
+func sigV4Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// AWS 文档中 "Create a signed AWS API request" 的示例
+func TestSignV4DocumentationExample(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", nil)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	creds := awsCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	signV4(req, nil, creds, "us-east-1", "iam", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, " +
+		"SignedHeaders=content-type;host;x-amz-date, " +
+		"Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Authorization =\n  %s\nwant\n  %s", got, want)
+	}
+	if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+		t.Errorf("X-Amz-Date = %q", got)
+	}
+}
+
+func TestLoadAWSCredentials(t *testing.T) {
+	dir := t.TempDir()
+	credentials := filepath.Join(dir, "credentials")
+	config := filepath.Join(dir, "config")
+	os.WriteFile(credentials, []byte("[default]\naws_access_key_id = DEFAULTKEY\naws_secret_access_key = defaultsecret\n\n[dev]\naws_access_key_id=DEVKEY\naws_secret_access_key=devsecret\naws_session_token=devtoken\n"), 0600)
+	os.WriteFile(config, []byte("[default]\nregion = us-east-1\n\n[profile dev]\nregion = eu-central-1\n"), 0600)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", credentials)
+	t.Setenv("AWS_CONFIG_FILE", config)
+	for _, env := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_PROFILE", "AWS_REGION", "AWS_DEFAULT_REGION"} {
+		t.Setenv(env, "")
+	}
+
+	creds, err := loadAWSCredentials("dev")
+	if err != nil || creds.AccessKeyID != "DEVKEY" || creds.SessionToken != "devtoken" || creds.Region != "eu-central-1" {
+		t.Errorf("dev profile: %+v, %v", creds, err)
+	}
+	if creds, err := loadAWSCredentials(""); err != nil || creds.AccessKeyID != "DEFAULTKEY" || creds.Region != "us-east-1" {
+		t.Errorf("default profile: %+v, %v", creds, err)
+	}
+	if _, err := loadAWSCredentials("missing"); err == nil {
+		t.Error("expected an error for a profile without keys")
+	}
+
+	// 未指定 profile 时环境变量优先
+	t.Setenv("AWS_ACCESS_KEY_ID", "ENVKEY")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "envsecret")
+	t.Setenv("AWS_REGION", "ap-northeast-1")
+	if creds, err := loadAWSCredentials(""); err != nil || creds.AccessKeyID != "ENVKEY" || creds.Region != "ap-northeast-1" {
+		t.Errorf("environment: %+v, %v", creds, err)
+	}
+}
+
+func TestSigV4Backend(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "ENVKEY")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "envsecret")
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_DEFAULT_REGION", "")
+
+	var authorization string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		io.WriteString(w, "data: {\"content\":\"ok\"}\n\n")
+	}))
+	defer upstream.Close()
+
+	srv := newTestServer(t, Config{Auth: AuthSigV4, ProfileArn: "arn:aws:codewhisperer:eu-central-1:111111111111:profile/ABC"})
+	srv.backend.(*codeWhispererBackend).url = upstream.URL
+	// token 文件不存在也不影响 SigV4 模式
+	os.Remove(srv.config.TokenPath)
+
+	rec := postMessages(srv, hookTestBody)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if !strings.HasPrefix(authorization, "AWS4-HMAC-SHA256 Credential=ENVKEY/") || !strings.Contains(authorization, "/eu-central-1/codewhisperer/aws4_request") {
+		t.Errorf("Authorization = %q", authorization)
+	}
+	if err := srv.refreshTokenSilently(); err == nil {
+		t.Error("refresh should not be attempted in sigv4 mode")
+	}
+}
+
+func TestValidateAuth(t *testing.T) {
+	err := Config{Auth: "basic", AWSProfile: "dev"}.Validate()
+	if err == nil || !strings.Contains(err.Error(), "Auth:") || !strings.Contains(err.Error(), "AWSProfile:") {
+		t.Errorf("err = %v", err)
+	}
+}
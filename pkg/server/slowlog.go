@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultSlowRequestThreshold 是 SlowRequestThreshold 为 0 时判定上游调用过慢的时长
+const DefaultSlowRequestThreshold = 20 * time.Second
+
+// slowRequestLimit 是 /admin/requests/slow 保留的最近慢请求数
+const slowRequestLimit = 20
+
+// slowRequest 是一次超过阈值的上游调用
+type slowRequest struct {
+	ID                string    `json:"id"`
+	Model             string    `json:"model"`
+	Stream            bool      `json:"stream"`
+	Started           time.Time `json:"started"`
+	DurationMs        int64     `json:"duration_ms"`
+	UpstreamStatus    int       `json:"upstream_status,omitempty"`
+	UpstreamLatencyMs int64     `json:"upstream_latency_ms"`
+	HistoryTurns      int       `json:"history_turns"`
+	HistoryBytes      int       `json:"history_bytes"`
+	Error             string    `json:"error,omitempty"`
+}
+
+// slowRequestLog 保留最近若干次慢的上游调用，供 /admin/requests/slow 按耗时查看
+type slowRequestLog struct {
+	threshold time.Duration
+
+	mu      sync.Mutex
+	records []slowRequest
+}
+
+func newSlowRequestLog(threshold time.Duration) *slowRequestLog {
+	return &slowRequestLog{threshold: threshold}
+}
+
+func (sl *slowRequestLog) record(rec slowRequest) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	sl.records = append(sl.records, rec)
+	if len(sl.records) > slowRequestLimit {
+		sl.records = sl.records[len(sl.records)-slowRequestLimit:]
+	}
+}
+
+// slowest 按耗时从长到短返回最近的慢请求
+func (sl *slowRequestLog) slowest() []slowRequest {
+	sl.mu.Lock()
+	records := append([]slowRequest{}, sl.records...)
+	sl.mu.Unlock()
+	sort.SliceStable(records, func(i, j int) bool { return records[i].DurationMs > records[j].DurationMs })
+	return records
+}
+
+// watchUpstream 在上游调用开始时调用，超过阈值仍未结束时先打印一次警告，便于发现卡住的调用。
+// 返回的函数在调用结束时执行，耗时超过阈值时打印请求详情并记入慢请求列表
+func (s *Server) watchUpstream(ctx context.Context, anthropicReq AnthropicRequest) func(err error) {
+	if s.slow == nil {
+		return func(error) {}
+	}
+	id := requestIDFrom(ctx)
+	started := time.Now()
+	timer := time.AfterFunc(s.slow.threshold, func() {
+		fmt.Printf("警告: 请求 %s 的上游调用已超过 %v 仍未结束 (模型 %s, 流式 %v)\n", id, s.slow.threshold, anthropicReq.Model, anthropicReq.Stream)
+	})
+	return func(err error) {
+		timer.Stop()
+		elapsed := time.Since(started)
+		if elapsed < s.slow.threshold {
+			return
+		}
+		diag := diagnosticsFrom(ctx)
+		rec := slowRequest{
+			ID:                id,
+			Model:             anthropicReq.Model,
+			Stream:            anthropicReq.Stream,
+			Started:           started.UTC(),
+			DurationMs:        elapsed.Milliseconds(),
+			UpstreamStatus:    diag.Status,
+			UpstreamLatencyMs: diag.Latency.Milliseconds(),
+			HistoryTurns:      diag.History.Turns,
+			HistoryBytes:      diag.History.Bytes,
+		}
+		if err != nil {
+			rec.Error = err.Error()
+		}
+		fmt.Printf("警告: 慢请求 %s: 上游调用耗时 %v (阈值 %v), 模型 %s, 上游状态码 %d, 响应头延迟 %v, 历史 %d 轮 %d 字节\n",
+			id, elapsed.Round(time.Millisecond), s.slow.threshold, anthropicReq.Model, diag.Status, diag.Latency.Round(time.Millisecond), diag.History.Turns, diag.History.Bytes)
+		s.slow.record(rec)
+	}
+}
+
+// handleSlowRequests 按耗时从长到短列出最近超过阈值的上游调用
+func (s *Server) handleSlowRequests(w http.ResponseWriter, r *http.Request) {
+	if s.slow == nil {
+		sendJSONError(w, http.StatusNotFound, "not_found_error", "未开启慢请求记录")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"threshold_ms": s.slow.threshold.Milliseconds(),
+		"requests":     s.slow.slowest(),
+	})
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bestk/kiro2cc/parser"
+)
+
+// delayedBackend 在返回前等待 delay，用于触发慢请求记录
+type delayedBackend struct {
+	fakeBackend
+	delay time.Duration
+}
+
+func (d *delayedBackend) Generate(ctx context.Context, anthropicReq AnthropicRequest) ([]parser.SSEEvent, error) {
+	time.Sleep(d.delay)
+	return d.fakeBackend.Generate(ctx, anthropicReq)
+}
+
+func getSlowRequests(srv *Server) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/admin/requests/slow", nil)
+	req.RemoteAddr = "127.0.0.1:12345"
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestSlowRequestsRecorded(t *testing.T) {
+	backend := &delayedBackend{fakeBackend: fakeBackend{events: textEvents("ok")}, delay: 30 * time.Millisecond}
+	srv := newTestServer(t, Config{Backend: backend, SlowRequestThreshold: 10 * time.Millisecond})
+
+	rec := postMessages(srv, hookTestBody)
+	var resp struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.ID == "" {
+		t.Fatalf("response: %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = getSlowRequests(srv)
+	var got struct {
+		ThresholdMs int64         `json:"threshold_ms"`
+		Requests    []slowRequest `json:"requests"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("slow: %d %s", rec.Code, rec.Body.String())
+	}
+	if got.ThresholdMs != 10 || len(got.Requests) != 1 {
+		t.Fatalf("slow = %+v", got)
+	}
+	if r := got.Requests[0]; r.ID != resp.ID || r.DurationMs < 30 || r.UpstreamStatus != http.StatusOK || r.UpstreamLatencyMs != 1500 {
+		t.Errorf("record = %+v", r)
+	}
+}
+
+func TestFastRequestsNotRecorded(t *testing.T) {
+	srv := newTestServer(t, Config{Backend: &fakeBackend{events: textEvents("ok")}})
+	postMessages(srv, hookTestBody)
+	if got := srv.slow.slowest(); len(got) != 0 {
+		t.Errorf("slow = %+v", got)
+	}
+
+	srv = newTestServer(t, Config{Backend: &fakeBackend{events: textEvents("ok")}, SlowRequestThreshold: -1})
+	if rec := getSlowRequests(srv); rec.Code != http.StatusNotFound {
+		t.Errorf("disabled: %d", rec.Code)
+	}
+}
+
+func TestSlowRequestLogOrder(t *testing.T) {
+	sl := newSlowRequestLog(time.Second)
+	for i := 0; i < slowRequestLimit+5; i++ {
+		sl.record(slowRequest{ID: string(rune('a' + i)), DurationMs: int64(1000 + i%7)})
+	}
+	got := sl.slowest()
+	if len(got) != slowRequestLimit {
+		t.Fatalf("len = %d", len(got))
+	}
+	for i := 1; i < len(got); i++ {
+		if got[i].DurationMs > got[i-1].DurationMs {
+			t.Fatalf("not sorted: %+v", got)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite" // 纯 Go 实现的 SQLite 驱动，不需要 cgo
+)
+
+// stateDBFile 是状态目录中的 SQLite 数据库文件名
+const stateDBFile = "state.db"
+
+// stateMigrations 依次建立状态数据库的表结构，已执行到的版本记录在 PRAGMA user_version 中。
+// 已发布的迁移不能修改，表结构的变化只能追加新的迁移
+var stateMigrations = []string{
+	// 1: 会话映射、已完成的幂等响应和租户每天的用量
+	`CREATE TABLE sessions (
+		fingerprint     TEXT PRIMARY KEY,
+		conversation_id TEXT NOT NULL,
+		last_used       INTEGER NOT NULL
+	);
+	CREATE INDEX sessions_last_used ON sessions (last_used);
+	CREATE TABLE idempotency (
+		key       TEXT PRIMARY KEY,
+		body_hash BLOB NOT NULL,
+		status    INTEGER NOT NULL,
+		header    TEXT NOT NULL,
+		body      BLOB NOT NULL,
+		expires   INTEGER NOT NULL
+	);
+	CREATE TABLE tenant_usage (
+		tenant   TEXT NOT NULL,
+		day      TEXT NOT NULL,
+		tokens   INTEGER NOT NULL,
+		requests INTEGER NOT NULL,
+		PRIMARY KEY (tenant, day)
+	);`,
+}
+
+// openStateDB 打开（不存在时创建）dir 中的状态数据库并执行尚未执行的迁移
+func openStateDB(dir string) (*sql.DB, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("创建状态目录失败: %v", err)
+	}
+	path := filepath.Join(dir, stateDBFile)
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, fmt.Errorf("打开状态数据库失败: %v", err)
+	}
+	// SQLite 同一时间只有一个写入者，单个连接避免 database is locked
+	db.SetMaxOpenConns(1)
+	if err := migrateStateDB(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("迁移状态数据库 %s 失败: %v", path, err)
+	}
+	os.Chmod(path, 0600)
+	return db, nil
+}
+
+// migrateStateDB 在事务中逐个执行新的迁移，中途失败时数据库停留在上一个版本
+func migrateStateDB(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	if version > len(stateMigrations) {
+		return fmt.Errorf("数据库版本 %d 高于当前程序支持的版本 %d，请升级 kiro2cc", version, len(stateMigrations))
+	}
+	for i := version; i < len(stateMigrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(stateMigrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("迁移 %d: %v", i+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sqliteSessionStore 把会话映射保存在状态数据库中，是设置了 StateDir 时的默认会话存储
+type sqliteSessionStore struct {
+	db *sql.DB
+	// mu 让查找和分配在同一个进程内串行，同一指纹的并发请求得到相同的 conversationId
+	mu sync.Mutex
+}
+
+// newSQLiteSessionStore 创建会话存储。状态目录中还有旧版本留下的 sessions.json 时先导入，
+// 导入后改名为 sessions.json.imported，不会重复导入
+func newSQLiteSessionStore(db *sql.DB, dir string) (*sqliteSessionStore, error) {
+	store := &sqliteSessionStore{db: db}
+	legacy := filepath.Join(dir, stateSessionsFile)
+	if _, err := os.Stat(legacy); err != nil {
+		return store, nil
+	}
+	sessions, err := loadSessions(legacy)
+	if err != nil {
+		return nil, err
+	}
+	entries, _ := sessions.export(context.Background())
+	if _, err := store.merge(context.Background(), entries); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(legacy, legacy+".imported"); err != nil {
+		return nil, fmt.Errorf("导入 %s 后改名失败: %v", legacy, err)
+	}
+	fmt.Printf("已把 %d 个会话从 %s 导入状态数据库\n", len(entries), legacy)
+	return store, nil
+}
+
+func (ss *sqliteSessionStore) conversationID(ctx context.Context, fingerprint string) (string, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	var id string
+	err := ss.db.QueryRowContext(ctx,
+		`INSERT INTO sessions (fingerprint, conversation_id, last_used) VALUES (?, ?, ?)
+		ON CONFLICT (fingerprint) DO UPDATE SET last_used = excluded.last_used
+		RETURNING conversation_id`,
+		fingerprint, generateUUID(), time.Now().UnixNano()).Scan(&id)
+	if err != nil {
+		return "", fmt.Errorf("保存会话失败: %v", err)
+	}
+	if err := ss.evict(ctx); err != nil {
+		fmt.Printf("清理会话失败: %v\n", err)
+	}
+	return id, nil
+}
+
+func (ss *sqliteSessionStore) export(ctx context.Context) ([]sessionEntry, error) {
+	rows, err := ss.db.QueryContext(ctx, `SELECT fingerprint, conversation_id, last_used FROM sessions ORDER BY last_used DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]sessionEntry, 0)
+	for rows.Next() {
+		var entry sessionEntry
+		var lastUsed int64
+		if err := rows.Scan(&entry.Fingerprint, &entry.ConversationID, &lastUsed); err != nil {
+			return nil, err
+		}
+		entry.LastUsed = time.Unix(0, lastUsed)
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (ss *sqliteSessionStore) merge(ctx context.Context, entries []sessionEntry) (int, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	tx, err := ss.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	imported := 0
+	for _, entry := range entries {
+		if entry.Fingerprint == "" || entry.ConversationID == "" {
+			continue
+		}
+		// 与文件存储一样，同一指纹保留最近使用的一条
+		result, err := tx.ExecContext(ctx,
+			`INSERT INTO sessions (fingerprint, conversation_id, last_used) VALUES (?, ?, ?)
+			ON CONFLICT (fingerprint) DO UPDATE SET conversation_id = excluded.conversation_id, last_used = excluded.last_used
+			WHERE excluded.last_used >= sessions.last_used`,
+			entry.Fingerprint, entry.ConversationID, entry.LastUsed.UnixNano())
+		if err != nil {
+			return 0, fmt.Errorf("保存会话失败: %v", err)
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			imported++
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("保存会话失败: %v", err)
+	}
+	if err := ss.evict(ctx); err != nil {
+		return imported, fmt.Errorf("清理会话失败: %v", err)
+	}
+	return imported, nil
+}
+
+// evict 丢弃超出 sessionLimit 的最久未使用的会话
+func (ss *sqliteSessionStore) evict(ctx context.Context) error {
+	_, err := ss.db.ExecContext(ctx,
+		`DELETE FROM sessions WHERE fingerprint IN (SELECT fingerprint FROM sessions ORDER BY last_used DESC LIMIT -1 OFFSET ?)`,
+		sessionLimit)
+	return err
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStateDBMigrations(t *testing.T) {
+	dir := t.TempDir()
+	db, err := openStateDB(dir)
+	if err != nil {
+		t.Fatalf("openStateDB: %v", err)
+	}
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil || version != len(stateMigrations) {
+		t.Fatalf("user_version = %d, %v", version, err)
+	}
+	db.Close()
+
+	// 重复打开不会再次执行迁移
+	db, err = openStateDB(dir)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	db.Exec("PRAGMA user_version = 99")
+	db.Close()
+
+	if _, err := openStateDB(dir); err == nil || !strings.Contains(err.Error(), "请升级") {
+		t.Fatalf("newer database should be refused, got %v", err)
+	}
+}
+
+// newStateServer 创建使用 dir 作为状态目录的测试服务器，测试结束时关闭状态数据库
+func newStateServer(t *testing.T, dir string, config Config) *Server {
+	t.Helper()
+	config.StateDir = dir
+	srv := newTestServer(t, config)
+	t.Cleanup(func() { srv.Close() })
+	return srv
+}
+
+func TestStateDirHoldsSessions(t *testing.T) {
+	dir := t.TempDir()
+
+	// 旧版本写在状态目录中的 sessions.json 在启动时导入
+	legacy, err := loadSessions(filepath.Join(dir, "sessions.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacyID := conversationIDFor(t, legacy, "from the json file")
+
+	srv := newStateServer(t, dir, Config{})
+	if _, ok := srv.sessions.(*sqliteSessionStore); !ok {
+		t.Fatalf("sessions = %T, want the state database", srv.sessions)
+	}
+	if got := conversationIDFor(t, srv.sessions, "from the json file"); got != legacyID {
+		t.Fatalf("imported conversationId = %q, want %q", got, legacyID)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "sessions.json.imported")); err != nil {
+		t.Fatalf("sessions.json was not marked as imported: %v", err)
+	}
+	id := checkSessionStore(t, srv.sessions)
+
+	// 导入较旧的映射不覆盖已有的会话
+	stale := []sessionEntry{{Fingerprint: sessionFingerprint(AnthropicRequest{Messages: []AnthropicRequestMessage{{Role: "user", Content: "fix the bug"}}}), ConversationID: "stale"}}
+	if n, err := srv.sessions.merge(context.Background(), stale); err != nil || n != 0 {
+		t.Fatalf("merge stale entry = %d, %v", n, err)
+	}
+	srv.Close()
+
+	restarted := newStateServer(t, dir, Config{})
+	if got := conversationIDFor(t, restarted.sessions, "fix the bug"); got != id {
+		t.Fatal("conversationId was not restored after restart")
+	}
+	entries, err := restarted.sessions.export(context.Background())
+	if err != nil || len(entries) != 3 || entries[0].ConversationID != id {
+		t.Fatalf("export = %+v, %v", entries, err)
+	}
+}
+
+func TestStateDirPersistsIdempotentResponses(t *testing.T) {
+	dir := t.TempDir()
+	send := func(srv *Server) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(hookTestBody))
+		req.Header.Set(IdempotencyKeyHeader, "key-1")
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	srv := newStateServer(t, dir, Config{Backend: &fakeBackend{events: textEvents("hello")}})
+	first := send(srv)
+	if first.Code != http.StatusOK {
+		t.Fatalf("first: %d %s", first.Code, first.Body.String())
+	}
+	srv.Close()
+
+	backend := &fakeBackend{events: textEvents("other")}
+	restarted := newStateServer(t, dir, Config{Backend: backend})
+	second := send(restarted)
+	if second.Code != http.StatusOK || second.Body.String() != first.Body.String() || second.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("replay after restart: %d %v %s", second.Code, second.Header(), second.Body.String())
+	}
+	if len(backend.reqs) != 0 {
+		t.Fatal("replayed request reached the backend")
+	}
+}
+
+func TestStateDirPersistsTenantUsage(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{
+		Backend: &fakeBackend{events: textEvents("ok")},
+		Tenants: []Tenant{{Name: "team-a", APIKeys: []string{"sk-a"}, DailyTokenBudget: 1}},
+	}
+
+	srv := newStateServer(t, dir, config)
+	if rec := postWithKey(srv, "sk-a"); rec.Code != http.StatusOK {
+		t.Fatalf("first request: %d %s", rec.Code, rec.Body.String())
+	}
+	srv.Close()
+
+	// 重启后当天的预算仍然是用完的状态
+	restarted := newStateServer(t, dir, config)
+	if rec := postWithKey(restarted, "sk-a"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("after restart: %d %s", rec.Code, rec.Body.String())
+	}
+
+	// 第二天的用量从零开始
+	now := time.Now().Add(24 * time.Hour)
+	restarted.tenants.now = func() time.Time { return now }
+	if rec := postWithKey(restarted, "sk-a"); rec.Code != http.StatusOK {
+		t.Fatalf("next day: %d %s", rec.Code, rec.Body.String())
+	}
+}
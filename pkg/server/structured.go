@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/bestk/kiro2cc/parser"
+)
+
+// DefaultStructuredOutputRetries 是结构化输出校验失败后默认的重试次数
+const DefaultStructuredOutputRetries = 2
+
+// structuredOutputInstruction 追加到 system 中，要求模型只输出符合 schema 的 JSON
+const structuredOutputInstruction = "Respond with a single JSON value that conforms to the following JSON Schema. Output only the JSON, without markdown code fences or any other text.\n\nSchema:\n%s"
+
+// structuredOutputRetryPrompt 在输出不符合要求时要求模型重新作答
+const structuredOutputRetryPrompt = "Your previous response did not conform to the required JSON Schema:\n- %s\n\nRespond again with only a JSON value that conforms to the schema."
+
+// StructuredOutputError 表示多次重试后模型输出仍不符合 response_format 的要求
+type StructuredOutputError struct {
+	Attempts int
+	Problems []string
+	// Output 是最后一次的原始输出
+	Output string
+}
+
+func (e *StructuredOutputError) Error() string {
+	return fmt.Sprintf("尝试 %d 次后输出仍不符合 response_format: %s", e.Attempts, strings.Join(e.Problems, "; "))
+}
+
+// structuredOutputSystem 返回要求模型按 response_format 输出的 system 指令，由 generate 在检查上下文窗口之前注入
+func structuredOutputSystem(format *ResponseFormat) AnthropicSystemMessage {
+	schemaJSON, _ := json.Marshal(format.Schema)
+	return AnthropicSystemMessage{Type: "text", Text: fmt.Sprintf(structuredOutputInstruction, schemaJSON)}
+}
+
+// generateStructured 要求模型按 response_format 输出 JSON 并校验，不符合时把问题反馈给模型重试。
+// 请求的 system 中应已包含 structuredOutputSystem 的指令。成功时返回只包含去掉代码块包裹后的 JSON 文本的事件
+func (s *Server) generateStructured(ctx context.Context, anthropicReq AnthropicRequest) ([]parser.SSEEvent, error) {
+	schema := anthropicReq.ResponseFormat.Schema
+
+	// 复制 messages，不修改调用方的请求
+	anthropicReq.Messages = append([]AnthropicRequestMessage(nil), anthropicReq.Messages...)
+	prefill, _ := assistantPrefill(anthropicReq.Messages)
+
+	retries := s.config.StructuredOutputRetries
+	for attempt := 1; ; attempt++ {
+		events, err := s.generateOnce(ctx, anthropicReq)
+		if err != nil {
+			return nil, err
+		}
+
+		var text strings.Builder
+		for _, e := range events {
+			text.WriteString(deltaText(e.Data))
+		}
+		output := prefill + text.String()
+		cleaned, problems := parseStructuredOutput(schema, output)
+		if len(problems) == 0 {
+			if prefill != "" || cleaned == output {
+				return events, nil
+			}
+			return []parser.SSEEvent{parser.TextDeltaEvent(cleaned)}, nil
+		}
+
+		fmt.Printf("警告: 第 %d 次输出不符合 response_format: %s\n", attempt, strings.Join(problems, "; "))
+		if attempt > retries {
+			return nil, &StructuredOutputError{Attempts: attempt, Problems: problems, Output: output}
+		}
+
+		// 去掉预填充后把本次输出和问题作为新的一轮发回
+		if prefill != "" {
+			anthropicReq.Messages = anthropicReq.Messages[:len(anthropicReq.Messages)-1]
+			prefill = ""
+		}
+		anthropicReq.Messages = append(anthropicReq.Messages,
+			AnthropicRequestMessage{Role: "assistant", Content: output},
+			AnthropicRequestMessage{Role: "user", Content: fmt.Sprintf(structuredOutputRetryPrompt, strings.Join(problems, "\n- "))},
+		)
+	}
+}
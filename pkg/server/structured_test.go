@@ -0,0 +1,76 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bestk/kiro2cc/parser"
+)
+
+const structuredRequest = `{"model":"claude-sonnet-4-20250514","max_tokens":100,"stream":%s,"messages":[{"role":"user","content":"a person"}],
+	"response_format":{"type":"json_schema","schema":{"type":"object","required":["name"],"properties":{"name":{"type":"string"}}}}}`
+
+func postStructured(t *testing.T, srv *Server, stream string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	body := strings.NewReader(strings.Replace(structuredRequest, "%s", stream, 1))
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/messages", body))
+	return rec
+}
+
+func TestStructuredOutputRetries(t *testing.T) {
+	backend := &scriptedBackend{replies: [][]parser.SSEEvent{
+		textEvents("Sure! Here it is: {name: Bob}"),
+		textEvents("```json\n{\"name\": \"Bob\"}\n```"),
+	}}
+	srv := newTestServer(t, Config{Backend: backend})
+	rec := postStructured(t, srv, "false")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"text":"{\"name\": \"Bob\"}"`) {
+		t.Fatalf("unexpected response: %d %s", rec.Code, rec.Body.String())
+	}
+	if len(backend.reqs) != 2 {
+		t.Fatalf("backend called %d times, want 2", len(backend.reqs))
+	}
+
+	first, retry := backend.reqs[0], backend.reqs[1]
+	if last := first.System[len(first.System)-1].Text; !strings.Contains(last, `"required":["name"]`) {
+		t.Errorf("schema instruction missing from system: %q", last)
+	}
+	if len(retry.Messages) != 3 || !strings.Contains(getMessageContent(retry.Messages[2].Content), "不是有效的 JSON") {
+		t.Errorf("retry should feed back the problems: %+v", retry.Messages)
+	}
+}
+
+func TestStructuredOutputGivesUp(t *testing.T) {
+	delay := streamEventDelay
+	streamEventDelay = func() time.Duration { return 0 }
+	defer func() { streamEventDelay = delay }()
+
+	backend := &fakeBackend{events: textEvents(`{"age": 3}`)}
+	srv := newTestServer(t, Config{Backend: backend, StructuredOutputRetries: 1})
+	rec := postStructured(t, srv, "false")
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "structured_output_error") || !strings.Contains(rec.Body.String(), `缺少必需字段 \"name\"`) {
+		t.Fatalf("unexpected response: %d %s", rec.Code, rec.Body.String())
+	}
+	if len(backend.reqs) != 2 {
+		t.Errorf("backend called %d times, want 2", len(backend.reqs))
+	}
+
+	rec = postStructured(t, srv, "true")
+	if !strings.Contains(rec.Body.String(), "event: error") || !strings.Contains(rec.Body.String(), "structured_output_error") {
+		t.Errorf("stream should end with a structured_output_error event: %s", rec.Body.String())
+	}
+}
+
+func TestResponseFormatValidation(t *testing.T) {
+	srv := newTestServer(t, Config{Backend: &fakeBackend{events: textEvents("{}")}})
+	rec := httptest.NewRecorder()
+	body := `{"model":"claude-sonnet-4-20250514","max_tokens":10,"messages":[{"role":"user","content":"hi"}],"response_format":{"type":"text"}}`
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,553 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bestk/kiro2cc/parser"
+	"github.com/bestk/kiro2cc/pkg/redis"
+)
+
+// Tenant 是共享部署中的一个租户：一组 API key 以及它们共用的模型范围、限流、预算和上游绑定
+type Tenant struct {
+	Name string `json:"name"`
+	// APIKeys 是属于该租户的客户端 key，不能与其他租户或 Config.APIKeys 重复
+	APIKeys []string `json:"api_keys,omitempty"`
+	// Models 不为空时只允许使用其中的模型，可以是完整模型名或模型名的一部分，例如 sonnet
+	Models []string `json:"models,omitempty"`
+	// RateLimit 大于 0 时限制整个租户每分钟的请求数
+	RateLimit int `json:"rate_limit,omitempty"`
+	// DailyTokenBudget 大于 0 时限制租户每天 (UTC) 估算的输入加输出 token 数，用完后请求返回 429
+	DailyTokenBudget int `json:"daily_token_budget,omitempty"`
+	// ProfileArn 不为空时该租户的请求使用自己的 CodeWhisperer profile
+	ProfileArn string `json:"profile_arn,omitempty"`
+	// TokenPath 不为空时该租户的请求使用自己的 Kiro token 文件，只在 bearer 认证方式下可用。
+	// 代理不会自动刷新租户自己的 token
+	TokenPath string `json:"token_path,omitempty"`
+}
+
+// tenantsFile 是 TenantsFile 的内容
+type tenantsFile struct {
+	Tenants []Tenant `json:"tenants"`
+}
+
+// loadTenants 读取租户文件，文件不存在时返回空列表，之后可以通过管理接口添加
+func loadTenants(path string) ([]Tenant, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取租户文件失败: %v", err)
+	}
+	var file tenantsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("解析租户文件失败: %v", err)
+	}
+	return file.Tenants, nil
+}
+
+// validateTenant 检查单个租户的设置，返回的问题不含字段前缀
+func validateTenant(t Tenant) []string {
+	var problems []string
+	if strings.TrimSpace(t.Name) == "" || strings.ContainsAny(t.Name, "/ \t") {
+		problems = append(problems, fmt.Sprintf("租户名 %q 不能为空，也不能包含 / 或空白", t.Name))
+	}
+	for _, key := range t.APIKeys {
+		if strings.TrimSpace(key) == "" {
+			problems = append(problems, "API key 不能为空")
+			break
+		}
+	}
+	if t.RateLimit < 0 {
+		problems = append(problems, "rate_limit 不能为负数")
+	}
+	if t.DailyTokenBudget < 0 {
+		problems = append(problems, "daily_token_budget 不能为负数")
+	}
+	if t.ProfileArn != "" && !profileArnPattern.MatchString(t.ProfileArn) {
+		problems = append(problems, fmt.Sprintf("%q 不是有效的 CodeWhisperer profile ARN", t.ProfileArn))
+	}
+	if t.TokenPath != "" {
+		if err := checkReadable(t.TokenPath); err != nil {
+			problems = append(problems, fmt.Sprintf("token_path: %v", err))
+		}
+	}
+	return problems
+}
+
+// validateTenants 检查所有租户，包括租户名和 API key 是否重复
+func (c Config) validateTenants(tenants []Tenant, add func(field, format string, args ...any)) {
+	names := make(map[string]bool)
+	keys := make(map[string]string)
+	for _, key := range c.APIKeys {
+		keys[key] = "APIKeys"
+	}
+	for i, t := range tenants {
+		field := fmt.Sprintf("Tenants[%d]", i)
+		if t.Name != "" {
+			field = "Tenants[" + t.Name + "]"
+		}
+		for _, problem := range validateTenant(t) {
+			add(field, "%s", problem)
+		}
+		if names[t.Name] {
+			add(field, "租户名重复")
+		}
+		names[t.Name] = true
+		for _, key := range t.APIKeys {
+			// 错误信息中不包含 API key 本身
+			if owner, ok := keys[key]; ok {
+				add(field, "有 API key 与 %s 重复", owner)
+			}
+			keys[key] = field
+		}
+		if (t.ProfileArn != "" || t.TokenPath != "") && c.Backend != nil {
+			add(field, "使用自定义 Backend 时不能为租户绑定 profile 或 token")
+		}
+		if t.TokenPath != "" && c.Auth == AuthSigV4 {
+			add(field, "token_path 只在 bearer 认证方式下使用")
+		}
+	}
+}
+
+// tenantState 是租户的设置和运行时状态
+type tenantState struct {
+	tenant  Tenant
+	limiter *rateLimiter
+	// backend 是绑定了租户自己的 profile 或 token 的后端，没有绑定时为空
+	backend Backend
+
+	// day 是 usedTokens 所属的日期 (UTC)
+	day        string
+	usedTokens int
+	requests   int64
+}
+
+// tenantStore 保存所有租户，按 API key 认证客户端并执行租户的访问策略。
+// path 不为空时管理接口的修改写回该文件，db 不为空时每天的用量保存在状态数据库中，重启后预算不会重置。
+// redis 不为空时限流和每天的用量在 Redis 中计数，负载均衡后的多个副本共享同一份预算
+type tenantStore struct {
+	path       string
+	db         *sql.DB
+	redis      *redis.Client
+	newBackend func(Tenant) Backend
+	now        func() time.Time
+
+	mu      sync.Mutex
+	tenants map[string]*tenantState
+	keys    map[[sha256.Size]byte]*tenantState
+}
+
+func newTenantStore(path string, db *sql.DB, rc *redis.Client, tenants []Tenant, newBackend func(Tenant) Backend) *tenantStore {
+	ts := &tenantStore{path: path, db: db, redis: rc, newBackend: newBackend, now: time.Now, tenants: make(map[string]*tenantState)}
+	for _, t := range tenants {
+		ts.tenants[t.Name] = ts.stateFor(t, nil)
+	}
+	ts.indexLocked()
+	return ts
+}
+
+// stateFor 按租户设置创建状态，替换已有租户时保留当天的用量
+func (ts *tenantStore) stateFor(t Tenant, previous *tenantState) *tenantState {
+	state := &tenantState{tenant: t, backend: ts.newBackend(t)}
+	if t.RateLimit > 0 {
+		state.limiter = newRateLimiter(map[string]int{"*": t.RateLimit})
+		state.limiter.redis, state.limiter.redisPrefix = ts.redis, redisRateLimitPrefix+"tenant:"
+	}
+	if previous != nil {
+		state.day, state.usedTokens, state.requests = previous.day, previous.usedTokens, previous.requests
+	} else {
+		ts.loadUsage(state)
+	}
+	return state
+}
+
+// loadUsage 从状态数据库读取租户当天已有的用量
+func (ts *tenantStore) loadUsage(state *tenantState) {
+	if ts.db == nil {
+		return
+	}
+	day := ts.now().UTC().Format(time.DateOnly)
+	err := ts.db.QueryRow(`SELECT tokens, requests FROM tenant_usage WHERE tenant = ? AND day = ?`, state.tenant.Name, day).
+		Scan(&state.usedTokens, &state.requests)
+	if err == nil {
+		state.day = day
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		fmt.Printf("读取租户 %s 的用量失败: %v\n", state.tenant.Name, err)
+	}
+}
+
+// saveUsageLocked 把租户当天的用量写入状态数据库，调用方需持有锁。写入失败只影响重启后的预算
+func (ts *tenantStore) saveUsageLocked(state *tenantState) {
+	if ts.db == nil {
+		return
+	}
+	_, err := ts.db.Exec(`INSERT INTO tenant_usage (tenant, day, tokens, requests) VALUES (?, ?, ?, ?)
+		ON CONFLICT (tenant, day) DO UPDATE SET tokens = excluded.tokens, requests = excluded.requests`,
+		state.tenant.Name, state.day, state.usedTokens, state.requests)
+	if err != nil {
+		fmt.Printf("保存租户 %s 的用量失败: %v\n", state.tenant.Name, err)
+	}
+}
+
+// indexLocked 重建 API key 索引，调用方需持有锁。索引以 key 的摘要为键，避免按 key 查找泄露时间差
+func (ts *tenantStore) indexLocked() {
+	ts.keys = make(map[[sha256.Size]byte]*tenantState)
+	for _, state := range ts.tenants {
+		for _, key := range state.tenant.APIKeys {
+			ts.keys[sha256.Sum256([]byte(key))] = state
+		}
+	}
+}
+
+// Authenticate 接受属于某个租户的 API key
+func (ts *tenantStore) Authenticate(ctx context.Context, credential string) (*Identity, error) {
+	sum := sha256.Sum256([]byte(credential))
+	ts.mu.Lock()
+	state, ok := ts.keys[sum]
+	ts.mu.Unlock()
+	if !ok {
+		return nil, ErrUnknownCredential
+	}
+	name := state.tenant.Name
+	return &Identity{Subject: name + "/key-" + hex.EncodeToString(sum[:6]), Provider: "tenant", Tenant: name}, nil
+}
+
+// lookup 返回身份所属的租户，不属于任何租户时返回 nil
+func (ts *tenantStore) lookup(identity *Identity) *tenantState {
+	if ts == nil || identity == nil || identity.Tenant == "" {
+		return nil
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return ts.tenants[identity.Tenant]
+}
+
+// allow 检查租户的请求频率限制
+func (ts *tenantStore) allow(identity *Identity) (time.Duration, bool) {
+	state := ts.lookup(identity)
+	if state == nil || state.limiter == nil {
+		return 0, true
+	}
+	return state.limiter.allow(&Identity{Subject: state.tenant.Name})
+}
+
+// admit 检查租户是否允许使用该模型、当天的预算是否还有剩余，拒绝时返回 *PolicyError
+func (ts *tenantStore) admit(ctx context.Context, anthropicReq AnthropicRequest) error {
+	state := ts.lookup(identityFrom(ctx))
+	if state == nil {
+		return nil
+	}
+	t := state.tenant
+	if len(t.Models) > 0 && !modelListed(t.Models, anthropicReq.Model) {
+		return &PolicyError{
+			Status:  http.StatusForbidden,
+			Type:    "permission_error",
+			Code:    CodeModelNotAllowed,
+			Message: fmt.Sprintf("租户 %s 不允许使用模型 %s，可用的模型: %s", t.Name, anthropicReq.Model, strings.Join(t.Models, ", ")),
+		}
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.rolloverLocked(state)
+	if ts.redis != nil {
+		// 以所有副本累计的用量判断预算，Redis 不可用时退回本副本的计数
+		if tokens, err := ts.sharedUsage(state, "tokens"); err == nil {
+			state.usedTokens = int(tokens)
+		} else {
+			fmt.Printf("读取租户 %s 的共享用量失败: %v\n", t.Name, err)
+		}
+	}
+	if t.DailyTokenBudget > 0 && state.usedTokens >= t.DailyTokenBudget {
+		return &PolicyError{
+			Status:  http.StatusTooManyRequests,
+			Type:    "rate_limit_error",
+			Code:    CodeBudgetExceeded,
+			Message: fmt.Sprintf("租户 %s 今天的 token 预算 (%d) 已用完，UTC 零点后重置", t.Name, t.DailyTokenBudget),
+		}
+	}
+	state.requests++
+	if ts.redis != nil {
+		if requests, err := ts.addSharedUsage(state, "requests", 1); err == nil {
+			state.requests = requests
+		} else {
+			fmt.Printf("保存租户 %s 的共享用量失败: %v\n", t.Name, err)
+		}
+	}
+	ts.saveUsageLocked(state)
+	return nil
+}
+
+// charge 把一次成功调用估算的输入和输出 token 计入租户当天的用量
+func (ts *tenantStore) charge(ctx context.Context, anthropicReq AnthropicRequest, events []parser.SSEEvent) {
+	state := ts.lookup(identityFrom(ctx))
+	if state == nil {
+		return
+	}
+	tokens := estimateInputTokens(anthropicReq) + responseOutputTokens(events)
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.rolloverLocked(state)
+	state.usedTokens += tokens
+	if ts.redis != nil {
+		if used, err := ts.addSharedUsage(state, "tokens", int64(tokens)); err == nil {
+			state.usedTokens = int(used)
+		} else {
+			fmt.Printf("保存租户 %s 的共享用量失败: %v\n", state.tenant.Name, err)
+		}
+	}
+	ts.saveUsageLocked(state)
+}
+
+// rolloverLocked 在日期变化后清零用量，调用方需持有锁
+func (ts *tenantStore) rolloverLocked(state *tenantState) {
+	if day := ts.now().UTC().Format(time.DateOnly); state.day != day {
+		state.day, state.usedTokens, state.requests = day, 0, 0
+	}
+}
+
+// backend 返回身份所属租户绑定的后端，没有绑定时返回 nil
+func (ts *tenantStore) backend(ctx context.Context) Backend {
+	if state := ts.lookup(identityFrom(ctx)); state != nil {
+		return state.backend
+	}
+	return nil
+}
+
+// responseOutputTokens 优先使用上游返回的输出 token 数，没有时按文本长度估算
+func responseOutputTokens(events []parser.SSEEvent) int {
+	for _, e := range events {
+		if tokens, ok := upstreamOutputTokens(e.Data); ok {
+			return tokens
+		}
+	}
+	return estimateTokens(len(eventsText(events)))
+}
+
+// tenantInfo 是管理接口返回的租户信息，API key 只以摘要表示
+type tenantInfo struct {
+	Tenant
+	APIKeys []string    `json:"api_keys"`
+	Usage   tenantUsage `json:"usage"`
+}
+
+// tenantUsage 是租户当天的用量
+type tenantUsage struct {
+	Day      string `json:"day"`
+	Tokens   int    `json:"tokens"`
+	Requests int64  `json:"requests"`
+}
+
+func (ts *tenantStore) infoLocked(state *tenantState) tenantInfo {
+	ts.rolloverLocked(state)
+	if ts.redis != nil {
+		// 管理接口显示所有副本累计的用量
+		tokens, err := ts.sharedUsage(state, "tokens")
+		var requests int64
+		if err == nil {
+			requests, err = ts.sharedUsage(state, "requests")
+		}
+		if err == nil {
+			state.usedTokens, state.requests = int(tokens), requests
+		} else {
+			fmt.Printf("读取租户 %s 的共享用量失败: %v\n", state.tenant.Name, err)
+		}
+	}
+	keys := make([]string, 0, len(state.tenant.APIKeys))
+	for _, key := range state.tenant.APIKeys {
+		sum := sha256.Sum256([]byte(key))
+		keys = append(keys, "key-"+hex.EncodeToString(sum[:6]))
+	}
+	return tenantInfo{
+		Tenant:  state.tenant,
+		APIKeys: keys,
+		Usage:   tenantUsage{Day: state.day, Tokens: state.usedTokens, Requests: state.requests},
+	}
+}
+
+// list 按名称返回所有租户
+func (ts *tenantStore) list() []tenantInfo {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	infos := make([]tenantInfo, 0, len(ts.tenants))
+	for _, state := range ts.tenants {
+		infos = append(infos, ts.infoLocked(state))
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// put 新增或替换租户。替换时 APIKeys 为空表示保留原有的 key，管理接口读不到 key 的原文
+func (ts *tenantStore) put(t Tenant, reserved []string) (tenantInfo, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	previous := ts.tenants[t.Name]
+	if previous != nil && t.APIKeys == nil {
+		t.APIKeys = previous.tenant.APIKeys
+	}
+	if problems := validateTenant(t); len(problems) > 0 {
+		return tenantInfo{}, errors.New(strings.Join(problems, "; "))
+	}
+	used := make(map[string]bool)
+	for _, key := range reserved {
+		used[key] = true
+	}
+	for name, state := range ts.tenants {
+		if name == t.Name {
+			continue
+		}
+		for _, key := range state.tenant.APIKeys {
+			used[key] = true
+		}
+	}
+	for _, key := range t.APIKeys {
+		if used[key] {
+			return tenantInfo{}, errors.New("有 API key 已被其他租户或 --require-api-key 使用")
+		}
+	}
+
+	state := ts.stateFor(t, previous)
+	ts.tenants[t.Name] = state
+	ts.indexLocked()
+	if err := ts.saveLocked(); err != nil {
+		return tenantInfo{}, fmt.Errorf("保存租户文件失败: %v", err)
+	}
+	return ts.infoLocked(state), nil
+}
+
+// remove 删除租户，租户不存在时返回 false
+func (ts *tenantStore) remove(name string) (bool, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if _, ok := ts.tenants[name]; !ok {
+		return false, nil
+	}
+	delete(ts.tenants, name)
+	ts.indexLocked()
+	if err := ts.saveLocked(); err != nil {
+		return true, fmt.Errorf("保存租户文件失败: %v", err)
+	}
+	return true, nil
+}
+
+// saveLocked 把租户写回 path，先写临时文件再重命名，调用方需持有锁
+func (ts *tenantStore) saveLocked() error {
+	if ts.path == "" {
+		return nil
+	}
+	file := tenantsFile{Tenants: make([]Tenant, 0, len(ts.tenants))}
+	for _, state := range ts.tenants {
+		file.Tenants = append(file.Tenants, state.tenant)
+	}
+	sort.Slice(file.Tenants, func(i, j int) bool { return file.Tenants[i].Name < file.Tenants[j].Name })
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(ts.path), 0700); err != nil {
+		return err
+	}
+	tmp := ts.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, ts.path)
+}
+
+// handleTenants 列出所有租户及其当天的用量
+func (s *Server) handleTenants(w http.ResponseWriter, r *http.Request) {
+	if s.tenants == nil {
+		sendJSONError(w, http.StatusNotFound, "not_found_error", "未开启租户，请使用 --tenants 启动服务器")
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "只支持GET请求", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"tenants": s.tenants.list()})
+}
+
+// handleTenant 查看 (GET)、新增或替换 (PUT)、删除 (DELETE) 单个租户，修改立即生效
+func (s *Server) handleTenant(w http.ResponseWriter, r *http.Request) {
+	if s.tenants == nil {
+		sendJSONError(w, http.StatusNotFound, "not_found_error", "未开启租户，请使用 --tenants 启动服务器")
+		return
+	}
+	name := r.PathValue("name")
+	switch r.Method {
+	case http.MethodGet:
+		for _, info := range s.tenants.list() {
+			if info.Name == name {
+				writeJSON(w, http.StatusOK, info)
+				return
+			}
+		}
+		sendJSONError(w, http.StatusNotFound, "not_found_error", "租户不存在")
+	case http.MethodPut:
+		var t Tenant
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodySize)).Decode(&t); err != nil {
+			sendJSONError(w, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("解析租户失败: %v", err))
+			return
+		}
+		t.Name = name
+		if (t.ProfileArn != "" || t.TokenPath != "") && s.config.Backend != nil {
+			sendJSONError(w, http.StatusBadRequest, "invalid_request_error", "使用自定义 Backend 时不能为租户绑定 profile 或 token")
+			return
+		}
+		if t.TokenPath != "" && s.config.Auth == AuthSigV4 {
+			sendJSONError(w, http.StatusBadRequest, "invalid_request_error", "token_path 只在 bearer 认证方式下使用")
+			return
+		}
+		info, err := s.tenants.put(t, s.config.APIKeys)
+		if err != nil {
+			sendJSONError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+			return
+		}
+		fmt.Printf("管理员更新了租户 %s\n", name)
+		writeJSON(w, http.StatusOK, info)
+	case http.MethodDelete:
+		found, err := s.tenants.remove(name)
+		if !found {
+			sendJSONError(w, http.StatusNotFound, "not_found_error", "租户不存在")
+			return
+		}
+		if err != nil {
+			sendJSONError(w, http.StatusInternalServerError, "api_error", err.Error())
+			return
+		}
+		fmt.Printf("管理员删除了租户 %s\n", name)
+		writeJSON(w, http.StatusOK, map[string]any{"deleted": name})
+	default:
+		http.Error(w, "只支持GET、PUT和DELETE请求", http.StatusMethodNotAllowed)
+	}
+}
+
+// tenantBackend 为绑定了 profile 或 token 的租户创建独立的 CodeWhisperer 后端，其他设置与默认后端相同
+func (s *Server) tenantBackend(t Tenant) Backend {
+	base, ok := s.backend.(*codeWhispererBackend)
+	if !ok || (t.ProfileArn == "" && t.TokenPath == "") {
+		return nil
+	}
+	backend := *base
+	if t.ProfileArn != "" {
+		backend.profileArn = t.ProfileArn
+	}
+	if t.TokenPath != "" {
+		backend.auth = bearerAuth{tokenPath: t.TokenPath}
+	}
+	return &backend
+}
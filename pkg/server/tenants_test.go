@@ -0,0 +1,125 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// adminRequest 以本机地址请求管理接口
+func adminRequest(srv *Server, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.RemoteAddr = "127.0.0.1:12345"
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestTenantPolicies(t *testing.T) {
+	srv := newTestServer(t, Config{
+		Backend: &fakeBackend{events: textEvents("ok")},
+		Tenants: []Tenant{
+			{Name: "team-a", APIKeys: []string{"sk-a"}, Models: []string{"sonnet"}, RateLimit: 2},
+			{Name: "team-b", APIKeys: []string{"sk-b"}, Models: []string{"haiku"}},
+		},
+	})
+
+	if rec := postWithKey(srv, "sk-b"); rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), string(CodeModelNotAllowed)) {
+		t.Fatalf("model not allowed: %d %s", rec.Code, rec.Body.String())
+	}
+	for i := 0; i < 2; i++ {
+		if rec := postWithKey(srv, "sk-a"); rec.Code != http.StatusOK {
+			t.Fatalf("request %d: %d %s", i, rec.Code, rec.Body.String())
+		}
+	}
+	if rec := postWithKey(srv, "sk-a"); rec.Code != http.StatusTooManyRequests || !strings.Contains(rec.Body.String(), "team-a") {
+		t.Fatalf("tenant rate limit: %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := postWithKey(srv, "sk-unknown"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("unknown key: %d", rec.Code)
+	}
+}
+
+func TestTenantDailyBudget(t *testing.T) {
+	srv := newTestServer(t, Config{
+		Backend: &fakeBackend{events: textEvents("ok")},
+		Tenants: []Tenant{{Name: "team-a", APIKeys: []string{"sk-a"}, DailyTokenBudget: 1}},
+	})
+	now := time.Date(2025, 6, 1, 23, 0, 0, 0, time.UTC)
+	srv.tenants.now = func() time.Time { return now }
+
+	if rec := postWithKey(srv, "sk-a"); rec.Code != http.StatusOK {
+		t.Fatalf("first request: %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := postWithKey(srv, "sk-a"); rec.Code != http.StatusTooManyRequests || !strings.Contains(rec.Body.String(), string(CodeBudgetExceeded)) {
+		t.Fatalf("over budget: %d %s", rec.Code, rec.Body.String())
+	}
+	now = now.Add(2 * time.Hour)
+	if rec := postWithKey(srv, "sk-a"); rec.Code != http.StatusOK {
+		t.Errorf("next day: %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestTenantAdminAPI(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tenants.json")
+	srv := newTestServer(t, Config{Backend: &fakeBackend{events: textEvents("ok")}, TenantsFile: path})
+
+	if rec := postWithKey(srv, "sk-c"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("before create: %d", rec.Code)
+	}
+	if rec := adminRequest(srv, http.MethodPut, "/admin/tenants/team-c", `{"api_keys":["sk-c"],"models":["haiku"]}`); rec.Code != http.StatusOK {
+		t.Fatalf("create: %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := postWithKey(srv, "sk-c"); rec.Code != http.StatusForbidden {
+		t.Fatalf("model allowlist: %d %s", rec.Code, rec.Body.String())
+	}
+
+	// 更新时省略 api_keys 保留原有的 key
+	if rec := adminRequest(srv, http.MethodPut, "/admin/tenants/team-c", `{"models":["sonnet"]}`); rec.Code != http.StatusOK {
+		t.Fatalf("update: %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := postWithKey(srv, "sk-c"); rec.Code != http.StatusOK {
+		t.Fatalf("after update: %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec := adminRequest(srv, http.MethodGet, "/admin/tenants", "")
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "sk-c") || !strings.Contains(rec.Body.String(), `"requests":1`) {
+		t.Fatalf("list: %d %s", rec.Code, rec.Body.String())
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || !strings.Contains(string(data), "sk-c") {
+		t.Fatalf("tenants file: %s %v", data, err)
+	}
+
+	if rec := adminRequest(srv, http.MethodPut, "/admin/tenants/team-d", `{"api_keys":["sk-c"]}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("duplicate key: %d", rec.Code)
+	}
+	if rec := adminRequest(srv, http.MethodDelete, "/admin/tenants/team-c", ""); rec.Code != http.StatusOK {
+		t.Fatalf("delete: %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := postWithKey(srv, "sk-c"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("after delete: %d", rec.Code)
+	}
+}
+
+func TestValidateTenants(t *testing.T) {
+	err := Config{
+		APIKeys: []string{"sk-local"},
+		Tenants: []Tenant{
+			{Name: "team-a", APIKeys: []string{"sk-local"}},
+			{Name: "team-a", ProfileArn: "not-an-arn", DailyTokenBudget: -1},
+		},
+	}.Validate()
+	if err == nil {
+		t.Fatal("Validate accepted invalid tenants")
+	}
+	for _, want := range []string{"与 APIKeys 重复", "租户名重复", "profile ARN", "daily_token_budget"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate = %v, missing %q", err, want)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bestk/kiro2cc/parser"
+)
+
+// transcriptMessage 表示会话记录中的一条消息
+type transcriptMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// transcriptEntry 表示代理处理过的一次对话
+type transcriptEntry struct {
+	ID       string              `json:"id"`
+	Time     time.Time           `json:"time"`
+	Model    string              `json:"model"`
+	System   []string            `json:"system,omitempty"`
+	Messages []transcriptMessage `json:"messages"`
+}
+
+// transcriptStore 以环形缓冲保存最近的对话
+type transcriptStore struct {
+	mu      sync.Mutex
+	limit   int
+	entries []transcriptEntry
+}
+
+// add 追加一条记录，超过上限时丢弃最旧的记录
+func (ts *transcriptStore) add(entry transcriptEntry) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.entries = append(ts.entries, entry)
+	if len(ts.entries) > ts.limit {
+		ts.entries = ts.entries[len(ts.entries)-ts.limit:]
+	}
+}
+
+// recent 返回最近 n 条记录，n <= 0 时返回全部
+func (ts *transcriptStore) recent(n int) []transcriptEntry {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	entries := ts.entries
+	if n > 0 && n < len(entries) {
+		entries = entries[len(entries)-n:]
+	}
+	return append([]transcriptEntry(nil), entries...)
+}
+
+// redactRules 描述导出时需要隐藏的敏感信息及替换方式
+var redactRules = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`sk-[A-Za-z0-9_\-]{16,}`), "[REDACTED]"},
+	{regexp.MustCompile(`AKIA[0-9A-Z]{16}`), "[REDACTED]"},
+	{regexp.MustCompile(`(?i)bearer\s+[A-Za-z0-9._\-]{16,}`), "[REDACTED]"},
+	{regexp.MustCompile(`(?i)(password|passwd|secret|api[_-]?key|token)(\s*[:=]\s*)\S+`), "${1}${2}[REDACTED]"},
+	{regexp.MustCompile(`\b[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}\b`), "[REDACTED]"},
+}
+
+// redact 隐藏文本中的密钥、token 和邮箱地址
+func redact(text string) string {
+	for _, rule := range redactRules {
+		text = rule.pattern.ReplaceAllString(text, rule.replacement)
+	}
+	return text
+}
+
+// recordTranscript 在开启会话记录时保存请求与回复（已脱敏）
+func (s *Server) recordTranscript(anthropicReq AnthropicRequest, events []parser.SSEEvent) {
+	if s.transcripts == nil {
+		return
+	}
+
+	entry := transcriptEntry{
+		ID:    generateUUID(),
+		Time:  time.Now().UTC(),
+		Model: anthropicReq.Model,
+	}
+	for _, sysMsg := range anthropicReq.System {
+		entry.System = append(entry.System, redact(sysMsg.Text))
+	}
+	for _, msg := range anthropicReq.Messages {
+		entry.Messages = append(entry.Messages, transcriptMessage{Role: msg.Role, Content: redact(getMessageContent(msg.Content))})
+	}
+
+	var reply strings.Builder
+	if contents, ok := buildMessageResponse(anthropicReq, events)["content"].([]map[string]any); ok {
+		for _, block := range contents {
+			switch block["type"] {
+			case "text":
+				reply.WriteString(fmt.Sprint(block["text"]))
+			case "tool_use":
+				input, _ := json.Marshal(block["input"])
+				fmt.Fprintf(&reply, "\n[tool_use %v] %s\n", block["name"], input)
+			}
+		}
+	}
+	entry.Messages = append(entry.Messages, transcriptMessage{Role: "assistant", Content: redact(reply.String())})
+
+	s.transcripts.add(entry)
+}
+
+// handleTranscripts 导出最近的对话，format 支持 jsonl（默认）和 markdown
+func (s *Server) handleTranscripts(w http.ResponseWriter, r *http.Request) {
+	if s.transcripts == nil {
+		sendJSONError(w, http.StatusNotFound, "not_found_error", "会话记录未开启，请使用 --transcripts 启动服务器")
+		return
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	entries := s.transcripts.recent(limit)
+
+	switch r.URL.Query().Get("format") {
+	case "markdown", "md":
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		writeTranscriptMarkdown(w, entries)
+	case "", "jsonl":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		encoder := json.NewEncoder(w)
+		for _, entry := range entries {
+			encoder.Encode(entry)
+		}
+	default:
+		sendJSONError(w, http.StatusBadRequest, "invalid_request_error", "format 只支持 jsonl 或 markdown")
+	}
+}
+
+// writeTranscriptMarkdown 以 markdown 格式输出对话
+func writeTranscriptMarkdown(w http.ResponseWriter, entries []transcriptEntry) {
+	for _, entry := range entries {
+		fmt.Fprintf(w, "## %s · %s\n\n", entry.Time.Format(time.RFC3339), entry.Model)
+		fmt.Fprintf(w, "_id: %s_\n\n", entry.ID)
+		for _, sys := range entry.System {
+			fmt.Fprintf(w, "**system**\n\n%s\n\n", sys)
+		}
+		for _, msg := range entry.Messages {
+			fmt.Fprintf(w, "**%s**\n\n%s\n\n", msg.Role, msg.Content)
+		}
+		fmt.Fprint(w, "---\n\n")
+	}
+}
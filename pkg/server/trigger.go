@@ -0,0 +1,43 @@
+package server
+
+// CodeWhisperer 的 chatTriggerType 取值
+const (
+	// ChatTriggerManual 表示用户手动发起的对话
+	ChatTriggerManual = "MANUAL"
+	// ChatTriggerDiagnostic 表示由诊断信息等自动触发的对话
+	ChatTriggerDiagnostic = "DIAGNOSTIC"
+	// ChatTriggerInlineChat 表示编辑器内联对话
+	ChatTriggerInlineChat = "INLINE_CHAT"
+)
+
+// triggerPolicy 根据请求特征选择 chatTriggerType，上游对自动化的 agent 流量可能区别对待
+type triggerPolicy struct {
+	trigger    string
+	toolResult string
+}
+
+// pick 返回请求应使用的 chatTriggerType：当前用户消息只包含 tool_result 时，
+// 说明是 agent 循环自动发起的后续请求，使用 toolResult
+func (p triggerPolicy) pick(anthropicReq AnthropicRequest) string {
+	if p.toolResult != "" && isToolResultTurn(anthropicReq.Messages) {
+		return p.toolResult
+	}
+	if p.trigger == "" {
+		return ChatTriggerManual
+	}
+	return p.trigger
+}
+
+// isToolResultTurn 判断最后一条消息是否是只包含 tool_result 的用户消息
+func isToolResultTurn(messages []AnthropicRequestMessage) bool {
+	if len(messages) == 0 || messages[len(messages)-1].Role != "user" {
+		return false
+	}
+	blocks := contentBlocks(messages[len(messages)-1].Content)
+	for _, block := range blocks {
+		if m, ok := block.(map[string]interface{}); !ok || m["type"] != "tool_result" {
+			return false
+		}
+	}
+	return len(blocks) > 0
+}
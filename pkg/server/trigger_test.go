@@ -0,0 +1,50 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestChatTriggerTypeForToolResults(t *testing.T) {
+	var triggers []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var cwReq CodeWhispererRequest
+		json.NewDecoder(r.Body).Decode(&cwReq)
+		triggers = append(triggers, cwReq.ConversationState.ChatTriggerType)
+		io.WriteString(w, "data: {\"content\":\"ok\"}\n\n")
+	}))
+	defer upstream.Close()
+
+	srv := newTestServer(t, Config{Debug: true, ToolResultTriggerType: ChatTriggerDiagnostic})
+	srv.backend.(*codeWhispererBackend).url = upstream.URL
+
+	bodies := []string{
+		`{"model":"claude-sonnet-4-20250514","max_tokens":10,"messages":[{"role":"user","content":"list files"}]}`,
+		`{"model":"claude-sonnet-4-20250514","max_tokens":10,"messages":[
+			{"role":"user","content":"list files"},
+			{"role":"assistant","content":[{"type":"tool_use","id":"t1","name":"ls","input":{}}]},
+			{"role":"user","content":[{"type":"tool_result","tool_use_id":"t1","content":"a.txt"}]}
+		]}`,
+	}
+	want := []string{ChatTriggerManual, ChatTriggerDiagnostic}
+	for i, body := range bodies {
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+		}
+		if got := rec.Header().Get("x-kiro2cc-chat-trigger-type"); got != want[i] {
+			t.Errorf("request %d: x-kiro2cc-chat-trigger-type = %q, want %q", i, got, want[i])
+		}
+		if got := rec.Header().Get("x-kiro2cc-origin"); got != OriginAIEditor {
+			t.Errorf("request %d: x-kiro2cc-origin = %q", i, got)
+		}
+	}
+	if len(triggers) != 2 || triggers[0] != want[0] || triggers[1] != want[1] {
+		t.Errorf("upstream chatTriggerType = %v", triggers)
+	}
+}
@@ -0,0 +1,215 @@
+package server
+
+import "strings"
+
+// AnthropicTool 表示 Anthropic API 的工具结构
+type AnthropicTool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	InputSchema map[string]any `json:"input_schema"`
+}
+
+// InputSchema 表示工具输入模式的结构
+type InputSchema struct {
+	Json map[string]any `json:"json"`
+}
+
+// ToolSpecification 表示工具规范的结构
+type ToolSpecification struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	InputSchema InputSchema `json:"inputSchema"`
+}
+
+// CodeWhispererTool 表示 CodeWhisperer API 的工具结构
+type CodeWhispererTool struct {
+	ToolSpecification ToolSpecification `json:"toolSpecification"`
+}
+
+// HistoryUserMessage 表示历史记录中的用户消息
+type HistoryUserMessage struct {
+	UserInputMessage struct {
+		Content string `json:"content"`
+		ModelId string `json:"modelId"`
+		Origin  string `json:"origin"`
+		// UserInputMessageContext 只在该轮是工具结果回传时出现
+		UserInputMessageContext *HistoryUserInputContext `json:"userInputMessageContext,omitempty"`
+	} `json:"userInputMessage"`
+}
+
+// HistoryUserInputContext 携带历史用户消息回传的工具结果
+type HistoryUserInputContext struct {
+	ToolResults []CodeWhispererToolResult `json:"toolResults"`
+}
+
+// HistoryAssistantMessage 表示历史记录中的助手消息
+type HistoryAssistantMessage struct {
+	AssistantResponseMessage struct {
+		Content  string `json:"content"`
+		ToolUses []any  `json:"toolUses"`
+	} `json:"assistantResponseMessage"`
+}
+
+// AnthropicErrorResponse 表示 Anthropic API 的错误响应结构
+type AnthropicErrorResponse struct {
+	Type  string `json:"type"`
+	Error struct {
+		Type string `json:"type"`
+		// Code 是 kiro2cc 的错误码，例如 KIRO2CC-UPSTREAM-THROTTLED
+		Code    ErrorCode `json:"code,omitempty"`
+		Message string    `json:"message"`
+	} `json:"error"`
+}
+
+// AnthropicRequest 表示 Anthropic API 的请求结构
+type AnthropicRequest struct {
+	Model       string                    `json:"model"`
+	MaxTokens   int                       `json:"max_tokens"`
+	Messages    []AnthropicRequestMessage `json:"messages"`
+	System      []AnthropicSystemMessage  `json:"system,omitempty"`
+	Tools       []AnthropicTool           `json:"tools,omitempty"`
+	Stream      bool                      `json:"stream"`
+	Temperature *float64                  `json:"temperature,omitempty"`
+	Metadata    map[string]any            `json:"metadata,omitempty"`
+	// ResponseFormat 是 kiro2cc 的扩展字段，要求回复是符合 schema 的 JSON
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+	// ExtraBody 是 kiro2cc 的扩展字段，合并到发往上游的 CodeWhisperer 请求中。
+	// 客户端通过 Config.ExtraBodyField 指定的字段传入，RequestHook 可以直接设置
+	ExtraBody map[string]any `json:"extra_body,omitempty"`
+}
+
+// ResponseFormat 描述结构化输出的要求，Type 目前只支持 json_schema
+type ResponseFormat struct {
+	Type   string         `json:"type"`
+	Schema map[string]any `json:"schema"`
+}
+
+// AnthropicStreamResponse 表示 Anthropic 流式响应的结构
+type AnthropicStreamResponse struct {
+	Type         string `json:"type"`
+	Index        int    `json:"index"`
+	ContentDelta struct {
+		Text string `json:"text"`
+		Type string `json:"type"`
+	} `json:"delta,omitempty"`
+	Content []struct {
+		Text string `json:"text"`
+		Type string `json:"type"`
+	} `json:"content,omitempty"`
+	StopReason   string `json:"stop_reason,omitempty"`
+	StopSequence string `json:"stop_sequence,omitempty"`
+	Usage        struct {
+		InputTokens  int `json:"input_tokens"`
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage,omitempty"`
+}
+
+// AnthropicRequestMessage 表示 Anthropic API 的消息结构
+type AnthropicRequestMessage struct {
+	Role    string `json:"role"`
+	Content any    `json:"content"` // 可以是 string 或 []ContentBlock
+}
+
+type AnthropicSystemMessage struct {
+	Type string `json:"type"`
+	Text string `json:"text"` // 可以是 string 或 []ContentBlock
+}
+
+// ContentBlock 表示消息内容块的结构
+type ContentBlock struct {
+	Type      string  `json:"type"`
+	Text      *string `json:"text,omitempty"`
+	ToolUseId *string `json:"tool_use_id,omitempty"`
+	Content   *string `json:"content,omitempty"`
+	Name      *string `json:"name,omitempty"`
+	Input     *any    `json:"input,omitempty"`
+}
+
+// CodeWhispererRequest 表示 CodeWhisperer API 的请求结构
+type CodeWhispererRequest struct {
+	ConversationState struct {
+		ChatTriggerType string `json:"chatTriggerType"`
+		ConversationId  string `json:"conversationId"`
+		CurrentMessage  struct {
+			UserInputMessage struct {
+				Content                 string `json:"content"`
+				ModelId                 string `json:"modelId"`
+				Origin                  string `json:"origin"`
+				UserInputMessageContext struct {
+					ToolResults []CodeWhispererToolResult `json:"toolResults,omitempty"`
+					Tools       []CodeWhispererTool       `json:"tools,omitempty"`
+				} `json:"userInputMessageContext"`
+			} `json:"userInputMessage"`
+		} `json:"currentMessage"`
+		History []any `json:"history"`
+	} `json:"conversationState"`
+	ProfileArn string `json:"profileArn"`
+}
+
+// CodeWhispererToolResult 表示当前消息中回传给模型的工具执行结果
+type CodeWhispererToolResult struct {
+	Content []CodeWhispererToolResultContent `json:"content"`
+	Status  string                           `json:"status"`
+	// ToolUseId 对应 Anthropic tool_result 块的 tool_use_id
+	ToolUseId string `json:"toolUseId"`
+}
+
+// CodeWhispererToolUse 表示历史助手消息中的一次工具调用，与后续的工具结果通过 ToolUseId 对应
+type CodeWhispererToolUse struct {
+	ToolUseId string `json:"toolUseId"`
+	Name      string `json:"name"`
+	Input     any    `json:"input"`
+}
+
+// CodeWhispererToolResultContent 是工具结果中的一段文本
+type CodeWhispererToolResultContent struct {
+	Text string `json:"text"`
+}
+
+// CodeWhispererEvent 表示 CodeWhisperer 的事件响应
+type CodeWhispererEvent struct {
+	ContentType string `json:"content-type"`
+	MessageType string `json:"message-type"`
+	Content     string `json:"content"`
+	EventType   string `json:"event-type"`
+}
+
+var ModelMap = map[string]string{
+	"claude-3-5-sonnet-20241022": "CLAUDE_3_5_SONNET_20241022_V2_0",
+	"claude-3-5-sonnet-20240620": "CLAUDE_3_5_SONNET_20240620_V1_0",
+	"claude-3-5-haiku-20241022":  "CLAUDE_3_5_HAIKU_20241022_V1_0",
+	"claude-3-opus-20240229":     "CLAUDE_3_OPUS_20240229_V1_0",
+	"claude-3-sonnet-20240229":   "CLAUDE_3_SONNET_20240229_V1_0",
+	"claude-3-haiku-20240307":    "CLAUDE_3_HAIKU_20240307_V1_0",
+	"claude-sonnet-4-20250514":   "CLAUDE_SONNET_4_20250514_V1_0",
+}
+
+// MatchModel 把模型名或其一部分（例如 "sonnet"、"claude-3-5-haiku"）解析为 ModelMap 中的模型，
+// 多个匹配时取发布日期最新的
+func MatchModel(hint string) (string, bool) {
+	name := strings.ToLower(strings.TrimSpace(hint))
+	if name == "" {
+		return "", false
+	}
+	if _, ok := ModelMap[name]; ok {
+		return name, true
+	}
+	var matched string
+	for model := range ModelMap {
+		if !strings.Contains(model, name) {
+			continue
+		}
+		if matched == "" || modelDate(model) > modelDate(matched) || (modelDate(model) == modelDate(matched) && model > matched) {
+			matched = model
+		}
+	}
+	return matched, matched != ""
+}
+
+// modelDate 返回模型名末尾的发布日期（YYYYMMDD）
+func modelDate(model string) string {
+	if i := strings.LastIndex(model, "-"); i >= 0 {
+		return model[i+1:]
+	}
+	return ""
+}
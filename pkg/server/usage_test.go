@@ -0,0 +1,65 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bestk/kiro2cc/parser"
+)
+
+func TestUpstreamUsageInFinalMessageDelta(t *testing.T) {
+	delay := streamEventDelay
+	streamEventDelay = func() time.Duration { return 0 }
+	defer func() { streamEventDelay = delay }()
+
+	usage := parser.SSEEvent{Event: "message_delta", Data: parser.MessageDelta{
+		Type:  "message_delta",
+		Delta: parser.MessageDeltaBody{StopReason: "end_turn"},
+		Usage: parser.Usage{OutputTokens: 42},
+	}}
+
+	for _, tt := range []struct {
+		name   string
+		events []parser.SSEEvent
+		want   int
+	}{
+		{"upstream usage", append(textEvents("Hello"), usage), 42},
+		{"length estimate", textEvents("Hello"), len("Hello")},
+	} {
+		srv := newTestServer(t, Config{Backend: &fakeBackend{events: tt.events}})
+
+		rec := httptest.NewRecorder()
+		body := `{"model":"claude-sonnet-4-20250514","max_tokens":10,"stream":true,"messages":[{"role":"user","content":"hi"}]}`
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body)))
+		if n := strings.Count(rec.Body.String(), "event: message_delta"); n != 1 {
+			t.Errorf("%s: %d message_delta events, want 1", tt.name, n)
+		}
+		assertConformantStream(t, rec.Body.String())
+		var final parser.MessageDelta
+		for _, line := range strings.Split(rec.Body.String(), "\n") {
+			if strings.HasPrefix(line, `data: {"type":"message_delta"`) {
+				json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &final)
+			}
+		}
+		if final.Usage.OutputTokens != tt.want {
+			t.Errorf("%s: stream output_tokens = %d, want %d", tt.name, final.Usage.OutputTokens, tt.want)
+		}
+
+		rec = httptest.NewRecorder()
+		body = strings.Replace(body, `"stream":true`, `"stream":false`, 1)
+		srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body)))
+		var resp struct {
+			Usage struct {
+				OutputTokens int `json:"output_tokens"`
+			} `json:"usage"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if resp.Usage.OutputTokens != tt.want {
+			t.Errorf("%s: non-stream output_tokens = %d, want %d", tt.name, resp.Usage.OutputTokens, tt.want)
+		}
+	}
+}
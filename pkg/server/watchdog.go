@@ -0,0 +1,187 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultGoroutineLeakThreshold 是 GoroutineLeakThreshold 为 0 时允许超出预期的 goroutine 数量
+const DefaultGoroutineLeakThreshold = 200
+
+const (
+	// watchdogInterval 是看门狗采样 goroutine 数量的间隔
+	watchdogInterval = 30 * time.Second
+	// watchdogSamples 是连续超出阈值多少次才判定为泄漏，避免把突发流量误报为泄漏
+	watchdogSamples = 3
+	// goroutinesPerRequest 是每个进行中请求预计占用的 goroutine 数量：
+	// 客户端连接、上游连接的读写、流式心跳等
+	goroutinesPerRequest = 8
+)
+
+// goroutineWatchdog 定期比较 goroutine 数量与进行中的请求数，请求结束后 goroutine 没有回落时告警。
+// 流式处理器在客户端断开或上游出错时如果没有退出，goroutine 会随请求数持续增长
+type goroutineWatchdog struct {
+	threshold int
+
+	mu sync.Mutex
+	// baseline 是没有进行中请求时观察到的最少 goroutine 数量
+	baseline   int
+	goroutines int
+	inflight   int
+	// over 是连续超出阈值的采样次数
+	over      int
+	suspected bool
+}
+
+func newGoroutineWatchdog(threshold int) *goroutineWatchdog {
+	return &goroutineWatchdog{threshold: threshold}
+}
+
+// observe 记录一次采样，goroutine 数量连续 watchdogSamples 次超出预期时返回 true，
+// 同一次泄漏只返回一次 true，回落到阈值以内后重新计数
+func (wd *goroutineWatchdog) observe(goroutines, inflight int) bool {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+
+	if wd.baseline == 0 || (inflight == 0 && goroutines < wd.baseline) {
+		wd.baseline = goroutines
+	}
+	wd.goroutines, wd.inflight = goroutines, inflight
+
+	if wd.excessLocked() <= wd.threshold {
+		wd.over = 0
+		wd.suspected = false
+		return false
+	}
+	wd.over++
+	if wd.over < watchdogSamples || wd.suspected {
+		return false
+	}
+	wd.suspected = true
+	return true
+}
+
+// excessLocked 返回超出预期的 goroutine 数量，调用方需持有 mu
+func (wd *goroutineWatchdog) excessLocked() int {
+	return wd.goroutines - wd.baseline - wd.inflight*goroutinesPerRequest
+}
+
+// watchdogStatus 是看门狗状态的快照
+type watchdogStatus struct {
+	Baseline  int  `json:"goroutine_baseline"`
+	Excess    int  `json:"goroutine_excess"`
+	Suspected bool `json:"goroutine_leak_suspected"`
+}
+
+func (wd *goroutineWatchdog) status() watchdogStatus {
+	wd.mu.Lock()
+	defer wd.mu.Unlock()
+	return watchdogStatus{Baseline: wd.baseline, Excess: max(wd.excessLocked(), 0), Suspected: wd.suspected}
+}
+
+// runWatchdog 每隔 watchdogInterval 采样一次，直到 ctx 取消
+func (s *Server) runWatchdog(ctx context.Context) {
+	ticker := time.NewTicker(watchdogInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.checkGoroutines()
+		}
+	}
+}
+
+// checkGoroutines 采样当前的 goroutine 数量和进行中的请求数，判定为泄漏时打印警告
+func (s *Server) checkGoroutines() {
+	goroutines, inflight := runtime.NumGoroutine(), s.inflight.count()
+	if s.watchdog.observe(goroutines, inflight) {
+		status := s.watchdog.status()
+		fmt.Printf("警告: goroutine 数量疑似泄漏: 当前 %d 个，空闲时 %d 个，进行中请求 %d 个，超出预期 %d 个，"+
+			"可通过 /admin/debug/pprof/goroutine?debug=1 查看调用栈\n", goroutines, status.Baseline, inflight, status.Excess)
+	}
+}
+
+// healthStatus 是 /health 详细模式返回的运行状态
+type healthStatus struct {
+	Status           string             `json:"status"`
+	Maintenance      *maintenanceStatus `json:"maintenance,omitempty"`
+	Goroutines       int                `json:"goroutines"`
+	InflightRequests int                `json:"inflight_requests"`
+	*watchdogStatus
+	HeapInuseBytes uint64 `json:"heap_inuse_bytes"`
+	HeapAllocBytes uint64 `json:"heap_alloc_bytes"`
+	HeapObjects    uint64 `json:"heap_objects"`
+	NumGC          uint32 `json:"num_gc"`
+}
+
+// handleHealth 默认返回纯文本 OK；带 ?verbose 参数或 Accept: application/json 时返回 goroutine 和堆内存统计。
+// 读取堆内存统计需要短暂暂停所有 goroutine，因此只在详细模式下读取。
+// 维护期间返回 503，让负载均衡器暂时不把请求发往这个实例
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	maintenance, inMaintenance := s.maintenance.active()
+	code := http.StatusOK
+	if inMaintenance {
+		code = http.StatusServiceUnavailable
+	}
+	if !r.URL.Query().Has("verbose") && !strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.WriteHeader(code)
+		if inMaintenance {
+			w.Write([]byte("MAINTENANCE"))
+		} else {
+			w.Write([]byte("OK"))
+		}
+		return
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	health := healthStatus{
+		Status:           "ok",
+		Goroutines:       runtime.NumGoroutine(),
+		InflightRequests: s.inflight.count(),
+		HeapInuseBytes:   mem.HeapInuse,
+		HeapAllocBytes:   mem.HeapAlloc,
+		HeapObjects:      mem.HeapObjects,
+		NumGC:            mem.NumGC,
+	}
+	if s.watchdog != nil {
+		status := s.watchdog.status()
+		health.watchdogStatus = &status
+		if status.Suspected {
+			health.Status = "warning"
+		}
+	}
+	if inMaintenance {
+		health.Status = "maintenance"
+		health.Maintenance = &maintenance
+	}
+	writeJSON(w, code, health)
+}
+
+// handlePprof 提供 net/http/pprof 的分析接口。pprof.Index 只识别 /debug/pprof/ 前缀，
+// 挂载在 /admin/debug/pprof/ 下需要自己按名称分发
+func (s *Server) handlePprof(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, s.config.BasePath+"/admin/debug/pprof/")
+	switch name {
+	case "":
+		pprof.Index(w, r)
+	case "cmdline":
+		pprof.Cmdline(w, r)
+	case "profile":
+		pprof.Profile(w, r)
+	case "symbol":
+		pprof.Symbol(w, r)
+	case "trace":
+		pprof.Trace(w, r)
+	default:
+		pprof.Handler(name).ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGoroutineWatchdogObserve(t *testing.T) {
+	wd := newGoroutineWatchdog(10)
+	wd.observe(20, 0)
+
+	// 进行中的请求占用的 goroutine 不算泄漏
+	for i := 0; i < 5; i++ {
+		if wd.observe(20+5*goroutinesPerRequest, 5) {
+			t.Fatal("busy server reported as leaking")
+		}
+	}
+
+	// 请求结束后 goroutine 没有回落，连续超出阈值才告警，且只告警一次
+	var warnings int
+	for i := 0; i < watchdogSamples+2; i++ {
+		if wd.observe(50, 0) {
+			warnings++
+		}
+	}
+	if warnings != 1 {
+		t.Errorf("warnings = %d, want 1", warnings)
+	}
+	if status := wd.status(); !status.Suspected || status.Baseline != 20 || status.Excess != 30 {
+		t.Errorf("status = %+v", status)
+	}
+
+	// 回落后清除
+	if wd.observe(21, 0) || wd.status().Suspected {
+		t.Errorf("status after recovery = %+v", wd.status())
+	}
+}
+
+func TestHealthVerbose(t *testing.T) {
+	srv := newTestServer(t, Config{})
+
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "OK" {
+		t.Fatalf("plain health: %d %q", rec.Code, rec.Body.String())
+	}
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/health?verbose", nil),
+		func() *http.Request {
+			req := httptest.NewRequest(http.MethodGet, "/health", nil)
+			req.Header.Set("Accept", "application/json")
+			return req
+		}(),
+	} {
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		var health map[string]any
+		if err := json.Unmarshal(rec.Body.Bytes(), &health); err != nil {
+			t.Fatalf("%s: %v: %s", req.URL, err, rec.Body.String())
+		}
+		if health["status"] != "ok" || health["goroutines"].(float64) <= 0 || health["heap_inuse_bytes"].(float64) <= 0 {
+			t.Errorf("%s: health = %v", req.URL, health)
+		}
+		if _, ok := health["goroutine_leak_suspected"]; !ok {
+			t.Errorf("%s: missing watchdog status: %v", req.URL, health)
+		}
+	}
+
+	srv = newTestServer(t, Config{GoroutineLeakThreshold: -1})
+	rec = httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health?verbose", nil))
+	if strings.Contains(rec.Body.String(), "goroutine_leak_suspected") {
+		t.Errorf("watchdog disabled but reported: %s", rec.Body.String())
+	}
+}
+
+func TestPprofRequiresAdmin(t *testing.T) {
+	srv := newTestServer(t, Config{AdminToken: "secret"})
+
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/debug/pprof/", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("without token: %d", rec.Code)
+	}
+
+	for path, want := range map[string]string{
+		"/admin/debug/pprof/":                  "goroutine",
+		"/admin/debug/pprof/goroutine?debug=1": "goroutine profile",
+	} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("%s: %d %.200s", path, rec.Code, rec.Body.String())
+		}
+	}
+}
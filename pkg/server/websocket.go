@@ -0,0 +1,311 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// websocketGUID 是 RFC 6455 握手使用的固定 GUID
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket 帧类型
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+)
+
+// wsMaxMessageSize 与 HTTP 入口一致，限制请求消息为 10MB
+const wsMaxMessageSize = maxRequestBodySize
+
+// DefaultWebSocketRequestTimeout 是握手后等待客户端发送请求消息的默认期限
+const DefaultWebSocketRequestTimeout = 30 * time.Second
+
+// wsConn 是仅支持服务端所需功能的最小 WebSocket 连接
+type wsConn struct {
+	conn net.Conn
+	rw   *bufio.ReadWriter
+	mu   sync.Mutex
+}
+
+// upgradeWebSocket 完成 WebSocket 握手并接管底层连接
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if r.Method != http.MethodGet {
+		return nil, errors.New("WebSocket 握手必须使用GET请求")
+	}
+	if !headerContainsToken(r.Header, "Connection", "upgrade") || !headerContainsToken(r.Header, "Upgrade", "websocket") {
+		return nil, errors.New("缺少 Upgrade: websocket 请求头")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		return nil, errors.New("只支持 Sec-WebSocket-Version: 13")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return nil, errors.New("缺少 Sec-WebSocket-Key 请求头")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, errors.New("当前连接不支持 WebSocket")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("接管连接失败: %v", err)
+	}
+
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	accept := base64.StdEncoding.EncodeToString(sum[:])
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", accept)
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("写入握手响应失败: %v", err)
+	}
+
+	return &wsConn{conn: conn, rw: rw}, nil
+}
+
+// checkWebSocketOrigin 校验浏览器发起握手时带的 Origin，防止任意网页借用户的浏览器连接代理。
+// 与 Host 相同的来源和 WebSocketOrigins 中的来源允许连接，不带 Origin 的非浏览器客户端不受限制
+func (s *Server) checkWebSocketOrigin(r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return nil
+	}
+	for _, allowed := range s.config.WebSocketOrigins {
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return nil
+		}
+	}
+	return fmt.Errorf("不允许来自 %s 的 WebSocket 连接", origin)
+}
+
+// headerContainsToken 判断逗号分隔的请求头中是否包含指定值（忽略大小写）
+func headerContainsToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ReadMessage 读取一条完整的文本或二进制消息，自动应答 ping 并处理分片
+func (c *wsConn) ReadMessage() ([]byte, error) {
+	var message []byte
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		switch opcode {
+		case wsOpPing:
+			if err := c.writeFrame(wsOpPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			c.writeFrame(wsOpClose, payload)
+			return nil, io.EOF
+		case wsOpText, wsOpBinary, wsOpContinuation:
+			message = append(message, payload...)
+			if len(message) > wsMaxMessageSize {
+				return nil, errors.New("WebSocket 消息超过大小限制")
+			}
+			if fin {
+				return message, nil
+			}
+		default:
+			return nil, fmt.Errorf("未知的 WebSocket 帧类型: %d", opcode)
+		}
+	}
+}
+
+// readFrame 读取单个帧，客户端帧必须带掩码
+func (c *wsConn) readFrame() (bool, byte, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.rw, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin := header[0]&0x80 != 0
+	opcode := header[0] & 0x0f
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7f)
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.rw, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.rw, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > wsMaxMessageSize {
+		return false, 0, nil, errors.New("WebSocket 帧超过大小限制")
+	}
+	if !masked {
+		return false, 0, nil, errors.New("客户端帧未使用掩码")
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.rw, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.rw, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// writeFrame 写入单个不分片、不带掩码的服务端帧
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	header := []byte{0x80 | opcode}
+	switch {
+	case len(payload) < 126:
+		header = append(header, byte(len(payload)))
+	case len(payload) <= 0xffff:
+		header = append(header, 126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(len(payload)))
+	default:
+		header = append(header, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(len(payload)))
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+	if _, err := c.rw.Write(header); err != nil {
+		return err
+	}
+	if _, err := c.rw.Write(payload); err != nil {
+		return err
+	}
+	return c.rw.Flush()
+}
+
+// Close 发送关闭帧并关闭连接
+func (c *wsConn) Close() error {
+	c.writeFrame(wsOpClose, []byte{0x03, 0xe8}) // 1000: 正常关闭
+	return c.conn.Close()
+}
+
+// wsWriter 以 WebSocket 文本帧输出事件，每帧携带与 SSE data 相同的 JSON
+type wsWriter struct {
+	conn *wsConn
+	// logEvents 为 true 时同时把事件打印到日志，与 sseWriter 一样只在开启 LogBodies 时使用
+	logEvents bool
+}
+
+// WriteEvent 发送 WebSocket 事件
+func (ww *wsWriter) WriteEvent(eventType string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	if ww.logEvents {
+		fmt.Printf("ws event: %s\n", eventType)
+		fmt.Printf("data: %v\n\n", string(payload))
+	}
+
+	return ww.conn.writeFrame(wsOpText, payload)
+}
+
+// handleMessagesWebSocket 处理 /v1/messages/ws 请求
+// 客户端握手后发送一条与 /v1/messages 相同的 JSON 请求，服务端以文本帧逐个返回流式事件
+func (s *Server) handleMessagesWebSocket(w http.ResponseWriter, r *http.Request) {
+	if err := s.checkWebSocketOrigin(r); err != nil {
+		fmt.Printf("错误: %v\n", err)
+		sendJSONError(w, http.StatusForbidden, "permission_error", err.Error())
+		return
+	}
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		fmt.Printf("错误: WebSocket 握手失败: %v\n", err)
+		sendJSONError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	defer conn.Close()
+
+	ew := &wsWriter{conn: conn, logEvents: s.config.LogBodies}
+
+	// 客户端必须在期限内发送请求，不发请求的连接不会一直占用
+	conn.conn.SetReadDeadline(time.Now().Add(s.config.WebSocketRequestTimeout))
+	body, err := conn.ReadMessage()
+	if err != nil {
+		fmt.Printf("错误: 读取 WebSocket 请求失败: %v\n", err)
+		return
+	}
+	conn.conn.SetReadDeadline(time.Time{})
+
+	ctx := s.logRequestBody(r.Context(), "Anthropic WebSocket", body)
+
+	anthropicReq, reqErr := s.parseAnthropicRequest(body)
+	if reqErr != nil {
+		fmt.Printf("错误: %s\n", reqErr.Message)
+		writeErrorEvent(ew, reqErr.Type, reqErr.Message)
+		return
+	}
+
+	// 获取当前token，SigV4 模式下凭证在签名时读取
+	if s.config.Auth == AuthBearer {
+		token, err := s.getToken()
+		if err != nil {
+			fmt.Printf("错误: 获取token失败: %v\n", err)
+			writeErrorEventWithCode(ew, CodeTokenUnavailable, "authentication_error", fmt.Sprintf("获取token失败: %v", err))
+			return
+		}
+		if strings.TrimSpace(token.AccessToken) == "" {
+			writeErrorEventWithCode(ew, CodeTokenUnavailable, "authentication_error", "AccessToken为空，请先登录或刷新token")
+			return
+		}
+	}
+
+	// 连接已被接管，请求的 ctx 不再反映连接状态。后台继续读取连接（应答 ping），
+	// 客户端发送关闭帧或读取失败时取消 ctx，与 SSE 客户端断开一样停止生成
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		defer cancel()
+		for {
+			if _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	s.streamResponse(ctx, ew, anthropicReq)
+}
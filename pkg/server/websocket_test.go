@@ -0,0 +1,202 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeClientFrame 写入带掩码的客户端文本帧
+func writeClientFrame(t *testing.T, conn net.Conn, payload []byte) {
+	t.Helper()
+	mask := [4]byte{1, 2, 3, 4}
+	frame := []byte{0x80 | wsOpText}
+	if len(payload) < 126 {
+		frame = append(frame, 0x80|byte(len(payload)))
+	} else {
+		frame = append(frame, 0x80|126, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(len(payload)))
+	}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	if _, err := conn.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readServerFrame 读取不带掩码的服务端帧
+func readServerFrame(t *testing.T, r *bufio.Reader) (byte, []byte) {
+	t.Helper()
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		t.Fatal(err)
+	}
+	length := int(header[1] & 0x7f)
+	if length == 126 {
+		var ext [2]byte
+		io.ReadFull(r, ext[:])
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatal(err)
+	}
+	return header[0] & 0x0f, payload
+}
+
+// dialWebSocket 连接 ts 的 /v1/messages/ws 并完成握手，headers 是附加的请求头行，连接在测试结束时关闭
+func dialWebSocket(t *testing.T, ts *httptest.Server, headers ...string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(ts.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	handshake := "GET /v1/messages/ws HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n"
+	for _, header := range headers {
+		handshake += header + "\r\n"
+	}
+	handshake += "\r\n"
+	conn.Write([]byte(handshake))
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("Sec-WebSocket-Accept = %q", got)
+	}
+	return conn, r
+}
+
+func TestWebSocketValidationError(t *testing.T) {
+	srv := newTestServer(t, Config{EnableWebSocket: true})
+
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+	conn, r := dialWebSocket(t, ts)
+
+	writeClientFrame(t, conn, []byte(`{"model":"no-such-model","max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`))
+
+	opcode, payload := readServerFrame(t, r)
+	if opcode != wsOpText {
+		t.Fatalf("opcode = %d", opcode)
+	}
+	var event struct {
+		Type  string `json:"type"`
+		Error struct {
+			Type string `json:"type"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != "error" || event.Error.Type != "invalid_request_error" {
+		t.Fatalf("unexpected event: %s", payload)
+	}
+
+	if opcode, _ := readServerFrame(t, r); opcode != wsOpClose {
+		t.Fatalf("expected close frame, got opcode %d", opcode)
+	}
+}
+
+func TestWebSocketStream(t *testing.T) {
+	defer func(delay func() time.Duration) { streamEventDelay = delay }(streamEventDelay)
+	streamEventDelay = func() time.Duration { return 0 }
+	srv := newTestServer(t, Config{Backend: &fakeBackend{events: textEvents("Hello ", "world!")}, EnableWebSocket: true})
+
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+	conn, r := dialWebSocket(t, ts)
+
+	writeClientFrame(t, conn, []byte(`{"model":"claude-sonnet-4-20250514","max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`))
+
+	var frames []sseFrame
+	for {
+		opcode, payload := readServerFrame(t, r)
+		if opcode == wsOpClose {
+			break
+		}
+		var data map[string]any
+		if err := json.Unmarshal(payload, &data); err != nil {
+			t.Fatalf("frame %q: %v", payload, err)
+		}
+		event, _ := data["type"].(string)
+		frames = append(frames, sseFrame{Event: event, Data: data})
+	}
+	assertConformantFrames(t, frames)
+}
+
+func TestWebSocketDisabledByDefault(t *testing.T) {
+	srv := newTestServer(t, Config{})
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/messages/ws", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d", rec.Code)
+	}
+}
+
+func TestWebSocketChecksOrigin(t *testing.T) {
+	srv := newTestServer(t, Config{EnableWebSocket: true, WebSocketOrigins: []string{"https://chat.example.com"}})
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/messages/ws", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("cross-origin handshake status = %d", rec.Code)
+	}
+
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+	dialWebSocket(t, ts, "Origin: http://localhost")
+	dialWebSocket(t, ts, "Origin: https://chat.example.com")
+}
+
+func TestWebSocketClosesIdleConnection(t *testing.T) {
+	srv := newTestServer(t, Config{EnableWebSocket: true, WebSocketRequestTimeout: 50 * time.Millisecond})
+
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+	conn, r := dialWebSocket(t, ts)
+
+	// 不发送请求，期限过后服务端关闭连接
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if opcode, _ := readServerFrame(t, r); opcode != wsOpClose {
+		t.Fatalf("expected close frame, got opcode %d", opcode)
+	}
+}
+
+func TestWebSocketDisconnectCancelsGeneration(t *testing.T) {
+	backend := &blockingBackend{started: make(chan struct{}), cancelled: make(chan error, 1)}
+	srv := newTestServer(t, Config{Backend: backend, EnableWebSocket: true})
+
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+	conn, _ := dialWebSocket(t, ts)
+
+	writeClientFrame(t, conn, []byte(`{"model":"claude-sonnet-4-20250514","max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`))
+	<-backend.started
+	conn.Close()
+
+	select {
+	case <-backend.cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("generation was not cancelled after the client disconnected")
+	}
+}
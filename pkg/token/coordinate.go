@@ -0,0 +1,139 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// lockStaleAfter 超过该时间的锁文件视为持有者已崩溃，可以被清除
+const lockStaleAfter = 30 * time.Second
+
+// lockPollInterval 是等待锁时的重试间隔
+const lockPollInterval = 100 * time.Millisecond
+
+// Coordinator 协调多个副本对同一个 token 的刷新。refresh token 刷新后旧值即失效，
+// 多个副本同时刷新会互相使对方的结果失效，因此只允许持有锁的副本刷新，其他副本读取它的结果
+type Coordinator interface {
+	// Lock 获取刷新锁，返回的 unlock 用于释放
+	Lock(ctx context.Context) (unlock func(), err error)
+	// Latest 返回其他副本最近一次刷新的 token，没有时 ok 为 false
+	Latest(ctx context.Context) (data Data, ok bool, err error)
+	// Publish 把刷新结果共享给其他副本
+	Publish(ctx context.Context, data Data) error
+}
+
+// RefreshCoordinated 在持有刷新锁的情况下刷新 token。拿到锁后如果发现其他副本已经刷新过，
+// 直接使用其结果写回 path，不再发起网络请求
+func RefreshCoordinated(ctx context.Context, path string, c Coordinator) (Data, error) {
+	current, err := Read(path)
+	if err != nil {
+		return Data{}, err
+	}
+
+	unlock, err := c.Lock(ctx)
+	if err != nil {
+		return Data{}, fmt.Errorf("获取token刷新锁失败: %v", err)
+	}
+	defer unlock()
+
+	// Kiro IDE 不参与加锁，它在等待期间刷新过时 token 文件中已是新的 refresh token，
+	// 直接使用它的结果，再刷新会让 IDE 手中的 refresh token 失效
+	if onDisk, err := Read(path); err == nil && onDisk.RefreshToken != "" && onDisk.RefreshToken != current.RefreshToken {
+		if err := c.Publish(ctx, onDisk); err != nil {
+			return onDisk, fmt.Errorf("共享刷新后的token失败: %v", err)
+		}
+		return onDisk, nil
+	}
+
+	latest, ok, err := c.Latest(ctx)
+	if err != nil {
+		return Data{}, err
+	}
+	if ok && latest.AccessToken != "" && latest.AccessToken != current.AccessToken {
+		if err := Write(path, latest); err != nil {
+			return Data{}, err
+		}
+		return latest, nil
+	}
+
+	newToken, err := Refresh(path)
+	if err != nil {
+		return Data{}, err
+	}
+	if err := c.Publish(ctx, newToken); err != nil {
+		return newToken, fmt.Errorf("共享刷新后的token失败: %v", err)
+	}
+	return newToken, nil
+}
+
+// processCoordinator 只在进程内串行化刷新，适用于单实例部署
+type processCoordinator struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewProcessCoordinator 返回进程内的协调器，同一进程内并发的刷新只会发起一次
+func NewProcessCoordinator(path string) Coordinator {
+	return &processCoordinator{path: path}
+}
+
+func (pc *processCoordinator) Lock(ctx context.Context) (func(), error) {
+	pc.mu.Lock()
+	return pc.mu.Unlock, nil
+}
+
+func (pc *processCoordinator) Latest(ctx context.Context) (Data, bool, error) {
+	data, err := Read(pc.path)
+	return data, err == nil, nil
+}
+
+func (pc *processCoordinator) Publish(ctx context.Context, data Data) error {
+	return nil
+}
+
+// fileCoordinator 通过 token 文件旁的锁文件协调共享卷上的多个副本，
+// 刷新结果直接写在共享的 token 文件中
+type fileCoordinator struct {
+	processCoordinator
+	lockPath string
+}
+
+// NewFileCoordinator 返回基于锁文件的协调器，适用于多个副本挂载同一个 token 文件的部署
+func NewFileCoordinator(path string) Coordinator {
+	return &fileCoordinator{processCoordinator: processCoordinator{path: path}, lockPath: LockPath(path)}
+}
+
+func (fc *fileCoordinator) Lock(ctx context.Context) (func(), error) {
+	// 先在进程内排队，避免同一进程的多个请求争抢锁文件
+	fc.mu.Lock()
+	for {
+		f, err := os.OpenFile(fc.lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			fmt.Fprintf(f, "%d\n", os.Getpid())
+			f.Close()
+			return func() {
+				os.Remove(fc.lockPath)
+				fc.mu.Unlock()
+			}, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			fc.mu.Unlock()
+			return nil, err
+		}
+		if info, statErr := os.Stat(fc.lockPath); statErr == nil && time.Since(info.ModTime()) > lockStaleAfter {
+			os.Remove(fc.lockPath)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			fc.mu.Unlock()
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package token
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFileCoordinatorRefreshesOnce(t *testing.T) {
+	var calls atomic.Int32
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		time.Sleep(50 * time.Millisecond)
+		json.NewEncoder(w).Encode(refreshResponse{AccessToken: fmt.Sprintf("access-%d", n), RefreshToken: fmt.Sprintf("refresh-%d", n)})
+	}))
+	defer auth.Close()
+	defer func(url string) { RefreshURL = url }(RefreshURL)
+	RefreshURL = auth.URL
+
+	path := filepath.Join(t.TempDir(), "kiro-auth-token.json")
+	if err := Write(path, Data{AccessToken: "access-0", RefreshToken: "refresh-0"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 每个协调器模拟一个副本，它们只通过共享的 token 文件和锁文件交互
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var wg sync.WaitGroup
+	results := make([]Data, 4)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			data, err := RefreshCoordinated(ctx, path, NewFileCoordinator(path))
+			if err != nil {
+				t.Errorf("replica %d: %v", i, err)
+			}
+			results[i] = data
+		}(i)
+	}
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("refresh endpoint called %d times, want 1", n)
+	}
+	for i, data := range results {
+		if data.AccessToken != "access-1" {
+			t.Errorf("replica %d got %q", i, data.AccessToken)
+		}
+	}
+}
+
+func TestFileCoordinatorClearsStaleLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kiro-auth-token.json")
+	c := NewFileCoordinator(path).(*fileCoordinator)
+	if err := Write(c.lockPath, Data{}); err != nil {
+		t.Fatal(err)
+	}
+	stale := time.Now().Add(-2 * lockStaleAfter)
+	if err := os.Chtimes(c.lockPath, stale, stale); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	unlock, err := c.Lock(ctx)
+	if err != nil {
+		t.Fatalf("Lock should clear the stale lock file: %v", err)
+	}
+	unlock()
+}
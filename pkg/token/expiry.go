@@ -0,0 +1,79 @@
+package token
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// expiresAtLayouts 是 RFC 3339 之外 expiresAt 可能使用的格式，不带时区的按 UTC 解释
+var expiresAtLayouts = []string{
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+}
+
+// ParseExpiresAt 解析 token 的过期时间，支持 RFC 3339 (带或不带小数秒、任意时区)、
+// 不带时区的日期时间以及 Unix 秒或毫秒时间戳，返回 UTC 时间
+func ParseExpiresAt(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, fmt.Errorf("过期时间为空")
+	}
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t.UTC(), nil
+	}
+	for _, layout := range expiresAtLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	if n, err := strconv.ParseFloat(value, 64); err == nil && n > 0 {
+		// 超过 1e12 的时间戳视为毫秒，秒级时间戳要到 33658 年才会超过
+		if n > 1e12 {
+			return time.UnixMilli(int64(n)).UTC(), nil
+		}
+		return time.Unix(int64(n), 0).UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("无法解析过期时间 %q", value)
+}
+
+// FormatExpiresAt 以 token 文件使用的格式 (UTC 的 RFC 3339) 输出过期时间
+func FormatExpiresAt(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// Expiry 返回 token 的过期时间，缺少或无法解析时 ok 为 false。
+// 调用方应把未知的过期时间当作可能已过期处理，并依靠上游的 401/403 触发刷新
+func (d Data) Expiry() (expiresAt time.Time, ok bool) {
+	t, err := ParseExpiresAt(d.ExpiresAt)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// ValidFor 判断 token 在 now 之后是否还能使用至少 margin，过期时间缺少或无法解析时返回 false
+func (d Data) ValidFor(now time.Time, margin time.Duration) bool {
+	expiresAt, ok := d.Expiry()
+	return ok && expiresAt.After(now.Add(margin))
+}
+
+// normalizeExpiresAt 把 JSON 中的 expiresAt (字符串或数字) 统一为 UTC 的 RFC 3339，
+// 无法识别时原样保留字符串形式，不因此拒绝整个 token 文件
+func normalizeExpiresAt(raw json.RawMessage) string {
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		var number json.Number
+		if err := json.Unmarshal(raw, &number); err != nil {
+			return ""
+		}
+		value = number.String()
+	}
+	if t, err := ParseExpiresAt(value); err == nil {
+		return FormatExpiresAt(t)
+	}
+	return value
+}
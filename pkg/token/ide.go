@@ -0,0 +1,118 @@
+package token
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Sibling 是 token 文件所在目录中保存同一账号 token 的其他缓存文件，
+// 例如 aws sso login 按 startUrl 写入 ~/.aws/sso/cache 的缓存
+type Sibling struct {
+	Path string
+	// Stale 为 true 时其中的 refresh token 与 token 文件不同，轮换后可能已经失效
+	Stale bool
+}
+
+// siblingFields 是识别缓存文件属于哪个账号所需的字段
+type siblingFields struct {
+	RefreshToken string `json:"refreshToken"`
+	StartURL     string `json:"startUrl"`
+	ClientID     string `json:"clientId"`
+}
+
+// sameAccount 判断缓存文件是否与 current 属于同一账号：refresh token 相同，
+// 或者 startUrl、clientId 其中之一相同
+func (f siblingFields) sameAccount(current Data) bool {
+	return f.RefreshToken == current.RefreshToken ||
+		(f.StartURL != "" && f.StartURL == current.StartURL) ||
+		(f.ClientID != "" && f.ClientID == current.ClientID)
+}
+
+// readSiblings 读取 path 所在目录中除 token 文件、锁文件和客户端注册文件以外带有 refresh token 的 JSON 文件
+func readSiblings(path string, current Data) map[string]siblingFields {
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return nil
+	}
+	siblings := make(map[string]siblingFields)
+	for _, entry := range entries {
+		name := entry.Name()
+		sibling := filepath.Join(filepath.Dir(path), name)
+		if entry.IsDir() || !strings.HasSuffix(name, ".json") || sibling == filepath.Clean(path) ||
+			(current.ClientIDHash != "" && name == current.ClientIDHash+".json") {
+			continue
+		}
+		data, err := os.ReadFile(sibling)
+		if err != nil {
+			continue
+		}
+		var fields siblingFields
+		if json.Unmarshal(data, &fields) != nil || fields.RefreshToken == "" {
+			continue
+		}
+		siblings[sibling] = fields
+	}
+	return siblings
+}
+
+// FindSiblings 返回与 current 属于同一账号的其他缓存文件，按路径排序
+func FindSiblings(path string, current Data) []Sibling {
+	var siblings []Sibling
+	for sibling, fields := range readSiblings(path, current) {
+		if fields.sameAccount(current) {
+			siblings = append(siblings, Sibling{Path: sibling, Stale: fields.RefreshToken != current.RefreshToken})
+		}
+	}
+	sort.Slice(siblings, func(i, j int) bool { return siblings[i].Path < siblings[j].Path })
+	return siblings
+}
+
+// syncSiblings 把刷新结果写入仍保存着旧 refresh token 的缓存文件，其他字段原样保留。
+// refresh token 轮换后旧值失效，不同步的话这些文件的使用者下次刷新会失败。
+// 同步失败不影响本次刷新，doctor 会把这些文件列为可能失效
+func syncSiblings(path string, old, updated Data) {
+	if old.RefreshToken == "" || old.RefreshToken == updated.RefreshToken {
+		return
+	}
+	for sibling, fields := range readSiblings(path, old) {
+		if fields.RefreshToken != old.RefreshToken {
+			continue
+		}
+		data, err := os.ReadFile(sibling)
+		if err != nil {
+			continue
+		}
+		var raw map[string]json.RawMessage
+		if json.Unmarshal(data, &raw) != nil {
+			continue
+		}
+		for key, value := range map[string]string{"accessToken": updated.AccessToken, "refreshToken": updated.RefreshToken, "expiresAt": updated.ExpiresAt} {
+			if value == "" {
+				continue
+			}
+			encoded, _ := json.Marshal(value)
+			raw[key] = encoded
+		}
+		if out, err := json.MarshalIndent(raw, "", "  "); err == nil {
+			os.WriteFile(sibling, out, 0600)
+		}
+	}
+}
+
+// LockPath 返回 token 文件旁的刷新锁文件路径，NewFileCoordinator 使用它协调多个进程
+func LockPath(path string) string {
+	return path + ".lock"
+}
+
+// LockState 返回刷新锁文件的状态：held 表示锁文件存在，stale 表示它已超过 lockStaleAfter，持有者可能已崩溃
+func LockState(path string, now time.Time) (held, stale bool) {
+	info, err := os.Stat(LockPath(path))
+	if err != nil {
+		return false, false
+	}
+	return true, now.Sub(info.ModTime()) > lockStaleAfter
+}
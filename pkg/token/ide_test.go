@@ -0,0 +1,121 @@
+package token
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRefreshSyncsSiblings(t *testing.T) {
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(refreshResponse{AccessToken: "access-1", RefreshToken: "refresh-1"})
+	}))
+	defer auth.Close()
+	defer func(url string) { RefreshURL = url }(RefreshURL)
+	RefreshURL = auth.URL
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, FileName)
+	if err := Write(path, Data{AccessToken: "access-0", RefreshToken: "refresh-0"}); err != nil {
+		t.Fatal(err)
+	}
+	copyPath := filepath.Join(dir, "copy.json")
+	otherPath := filepath.Join(dir, "other.json")
+	os.WriteFile(copyPath, []byte(`{"accessToken":"access-0","refreshToken":"refresh-0","custom":1}`), 0600)
+	os.WriteFile(otherPath, []byte(`{"accessToken":"x","refreshToken":"another-account"}`), 0600)
+
+	if _, err := Refresh(path); err != nil {
+		t.Fatal(err)
+	}
+	var synced map[string]any
+	data, _ := os.ReadFile(copyPath)
+	if err := json.Unmarshal(data, &synced); err != nil || synced["refreshToken"] != "refresh-1" || synced["accessToken"] != "access-1" || synced["custom"] != 1.0 {
+		t.Errorf("sibling = %s", data)
+	}
+	if data, _ := os.ReadFile(otherPath); string(data) != `{"accessToken":"x","refreshToken":"another-account"}` {
+		t.Errorf("unrelated cache file changed: %s", data)
+	}
+}
+
+func TestRefreshAdoptsIDERefreshOnFailure(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, FileName)
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// IDE 在同一时间刷新成功，代理手中的 refresh token 已被轮换
+		Write(path, Data{AccessToken: "ide-access", RefreshToken: "ide-refresh"})
+		http.Error(w, "invalid refresh token", http.StatusUnauthorized)
+	}))
+	defer auth.Close()
+	defer func(url string) { RefreshURL = url }(RefreshURL)
+	RefreshURL = auth.URL
+
+	if err := Write(path, Data{AccessToken: "access-0", RefreshToken: "refresh-0"}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := Refresh(path)
+	if err != nil || data.AccessToken != "ide-access" {
+		t.Fatalf("Refresh = %+v, %v", data, err)
+	}
+}
+
+// ideDuringLock 模拟 Kiro IDE 在代理等待刷新锁期间刷新了 token 文件
+type ideDuringLock struct {
+	Coordinator
+	path string
+}
+
+func (c ideDuringLock) Lock(ctx context.Context) (func(), error) {
+	Write(c.path, Data{AccessToken: "ide-access", RefreshToken: "ide-refresh"})
+	return c.Coordinator.Lock(ctx)
+}
+
+func (c ideDuringLock) Latest(ctx context.Context) (Data, bool, error) {
+	return Data{}, false, nil
+}
+
+func TestRefreshCoordinatedAdoptsIDERefresh(t *testing.T) {
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("refresh endpoint called after the IDE refreshed")
+	}))
+	defer auth.Close()
+	defer func(url string) { RefreshURL = url }(RefreshURL)
+	RefreshURL = auth.URL
+
+	path := filepath.Join(t.TempDir(), FileName)
+	if err := Write(path, Data{AccessToken: "access-0", RefreshToken: "refresh-0"}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := RefreshCoordinated(context.Background(), path, ideDuringLock{Coordinator: NewProcessCoordinator(path), path: path})
+	if err != nil || data.RefreshToken != "ide-refresh" {
+		t.Fatalf("RefreshCoordinated = %+v, %v", data, err)
+	}
+}
+
+func TestFindSiblingsAndLockState(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, FileName)
+	current := Data{AccessToken: "a", RefreshToken: "r", StartURL: "https://example.awsapps.com/start", ClientIDHash: "hash"}
+	os.WriteFile(filepath.Join(dir, "same.json"), []byte(`{"refreshToken":"r"}`), 0600)
+	os.WriteFile(filepath.Join(dir, "stale.json"), []byte(`{"refreshToken":"old","startUrl":"https://example.awsapps.com/start"}`), 0600)
+	os.WriteFile(filepath.Join(dir, "other.json"), []byte(`{"refreshToken":"x","startUrl":"https://other.awsapps.com/start"}`), 0600)
+	os.WriteFile(filepath.Join(dir, "hash.json"), []byte(`{"clientId":"c","clientSecret":"s","refreshToken":"old"}`), 0600)
+
+	siblings := FindSiblings(path, current)
+	if len(siblings) != 2 || filepath.Base(siblings[0].Path) != "same.json" || siblings[0].Stale || !siblings[1].Stale {
+		t.Errorf("siblings = %+v", siblings)
+	}
+
+	now := time.Now()
+	if held, _ := LockState(path, now); held {
+		t.Error("no lock file, held = true")
+	}
+	os.WriteFile(LockPath(path), nil, 0600)
+	if held, stale := LockState(path, now.Add(2*lockStaleAfter)); !held || !stale {
+		t.Errorf("old lock: held %v stale %v", held, stale)
+	}
+}
@@ -0,0 +1,112 @@
+package token
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// OIDCTokenURL 是 SSO OIDC CreateToken 接口地址，{region} 会被替换为 token 的区域
+var OIDCTokenURL = "https://oidc.{region}.amazonaws.com/token"
+
+// defaultOIDCRegion 是 token 文件没有记录区域时使用的区域
+const defaultOIDCRegion = "us-east-1"
+
+// oidcRefreshRequest 是 CreateToken 刷新请求
+type oidcRefreshRequest struct {
+	ClientID     string `json:"clientId"`
+	ClientSecret string `json:"clientSecret"`
+	GrantType    string `json:"grantType"`
+	RefreshToken string `json:"refreshToken"`
+}
+
+// oidcRefreshResponse 是 CreateToken 的响应，ExpiresIn 以秒计
+type oidcRefreshResponse struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+	ExpiresIn    int    `json:"expiresIn"`
+}
+
+// clientRegistration 是 Kiro 保存在 <clientIdHash>.json 中的客户端注册信息
+type clientRegistration struct {
+	ClientID     string `json:"clientId"`
+	ClientSecret string `json:"clientSecret"`
+	ExpiresAt    string `json:"expiresAt,omitempty"`
+}
+
+// UsesOIDC 判断 token 是否是需要通过 SSO OIDC 刷新的 Builder ID token
+func (d Data) UsesOIDC() bool {
+	return d.ClientID != "" || d.ClientIDHash != ""
+}
+
+// refreshOIDC 使用客户端注册信息和 refresh token 调用 SSO OIDC 刷新 Builder ID token
+func refreshOIDC(path string, currentToken Data) (Data, error) {
+	reg := clientRegistration{
+		ClientID:     currentToken.ClientID,
+		ClientSecret: currentToken.ClientSecret,
+		ExpiresAt:    currentToken.RegistrationExpiresAt,
+	}
+	if reg.ClientID == "" {
+		regPath := filepath.Join(filepath.Dir(path), currentToken.ClientIDHash+".json")
+		data, err := os.ReadFile(regPath)
+		if err != nil {
+			return Data{}, fmt.Errorf("读取客户端注册文件失败: %v", err)
+		}
+		if err := json.Unmarshal(data, &reg); err != nil {
+			return Data{}, fmt.Errorf("解析客户端注册文件 %s 失败: %v", regPath, err)
+		}
+	}
+	if reg.ClientID == "" || reg.ClientSecret == "" {
+		return Data{}, fmt.Errorf("缺少 clientId 或 clientSecret，无法刷新 Builder ID token")
+	}
+	if expiresAt, err := time.Parse(time.RFC3339, reg.ExpiresAt); err == nil && time.Now().After(expiresAt) {
+		return Data{}, fmt.Errorf("客户端注册已于 %s 过期，请重新登录 (aws sso login 或在 Kiro 中重新登录)", reg.ExpiresAt)
+	}
+
+	reqBody, err := json.Marshal(oidcRefreshRequest{
+		ClientID:     reg.ClientID,
+		ClientSecret: reg.ClientSecret,
+		GrantType:    "refresh_token",
+		RefreshToken: currentToken.RefreshToken,
+	})
+	if err != nil {
+		return Data{}, fmt.Errorf("序列化请求失败: %v", err)
+	}
+
+	region := currentToken.Region
+	if region == "" {
+		region = defaultOIDCRegion
+	}
+	resp, err := http.Post(strings.ReplaceAll(OIDCTokenURL, "{region}", region), "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return Data{}, fmt.Errorf("刷新token请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return Data{}, fmt.Errorf("刷新token失败，状态码: %d, 响应: %s", resp.StatusCode, string(body))
+	}
+
+	var refreshResp oidcRefreshResponse
+	if err := json.NewDecoder(resp.Body).Decode(&refreshResp); err != nil {
+		return Data{}, fmt.Errorf("解析刷新响应失败: %v", err)
+	}
+
+	newToken := currentToken
+	newToken.AccessToken = refreshResp.AccessToken
+	// OIDC 不一定轮换 refresh token，没有返回时继续使用原来的
+	if refreshResp.RefreshToken != "" {
+		newToken.RefreshToken = refreshResp.RefreshToken
+	}
+	if refreshResp.ExpiresIn > 0 {
+		newToken.ExpiresAt = FormatExpiresAt(time.Now().Add(time.Duration(refreshResp.ExpiresIn) * time.Second))
+	}
+	return newToken, nil
+}
@@ -0,0 +1,63 @@
+package token
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRefreshBuilderIDToken(t *testing.T) {
+	var got oidcRefreshRequest
+	var gotPath string
+	oidc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&got)
+		json.NewEncoder(w).Encode(oidcRefreshResponse{AccessToken: "new-access", ExpiresIn: 3600})
+	}))
+	defer oidc.Close()
+	defer func(url string) { OIDCTokenURL = url }(OIDCTokenURL)
+	OIDCTokenURL = oidc.URL + "/{region}/token"
+
+	// aws sso login 的缓存文件直接包含客户端注册信息
+	path := filepath.Join(t.TempDir(), "aws-sso.json")
+	Write(path, Data{AccessToken: "old", RefreshToken: "refresh", StartURL: "https://view.awsapps.com/start", Region: "eu-west-1", ClientID: "client", ClientSecret: "secret"})
+	newToken, err := Refresh(path)
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if gotPath != "/eu-west-1/token" || got.ClientID != "client" || got.GrantType != "refresh_token" || got.RefreshToken != "refresh" {
+		t.Errorf("request %s %+v", gotPath, got)
+	}
+	written, _ := Read(path)
+	if written.AccessToken != "new-access" || written.RefreshToken != "refresh" || written.ClientSecret != "secret" || written.StartURL == "" {
+		t.Errorf("written token = %+v", written)
+	}
+	if expiresAt, err := time.Parse(time.RFC3339, newToken.ExpiresAt); err != nil || time.Until(expiresAt) < 50*time.Minute {
+		t.Errorf("expiresAt = %q", newToken.ExpiresAt)
+	}
+
+	// Kiro 的 Builder ID 登录把注册信息放在 <clientIdHash>.json 中
+	dir := t.TempDir()
+	path = filepath.Join(dir, "kiro-auth-token.json")
+	Write(path, Data{AccessToken: "old", RefreshToken: "refresh", AuthMethod: "IdC", ClientIDHash: "abc123"})
+	os.WriteFile(filepath.Join(dir, "abc123.json"), []byte(`{"clientId":"hashed-client","clientSecret":"hashed-secret"}`), 0600)
+	if _, err := Refresh(path); err != nil {
+		t.Fatalf("Refresh with clientIdHash: %v", err)
+	}
+	if got.ClientID != "hashed-client" || gotPath != "/us-east-1/token" {
+		t.Errorf("request %s %+v", gotPath, got)
+	}
+}
+
+func TestRefreshBuilderIDExpiredRegistration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "aws-sso.json")
+	Write(path, Data{RefreshToken: "refresh", ClientID: "client", ClientSecret: "secret", RegistrationExpiresAt: "2020-01-01T00:00:00Z"})
+	if _, err := Refresh(path); err == nil || !strings.Contains(err.Error(), "过期") {
+		t.Errorf("err = %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+package token
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// FileName 是 Kiro 保存登录 token 的文件名，Kiro 在所有系统上都把它放在用户目录的 .aws/sso/cache 下
+const FileName = "kiro-auth-token.json"
+
+// cacheDir 是 token 文件相对于用户目录的位置
+var cacheDir = []string{".aws", "sso", "cache"}
+
+// Platform 是查找 token 文件所需的操作系统信息，测试中可以构造其他系统的取值
+type Platform struct {
+	// GOOS 决定路径分隔符和候选位置，取值与 runtime.GOOS 相同
+	GOOS   string
+	Getenv func(string) string
+	// HomeDir 返回当前用户的目录，Windows 上为 %USERPROFILE%，其他系统为 $HOME
+	HomeDir func() (string, error)
+	// LookupHome 返回指定用户的目录，用于 sudo 运行时找到原用户的 token
+	LookupHome func(username string) (string, error)
+	Exists     func(path string) bool
+	Glob       func(pattern string) ([]string, error)
+}
+
+// CurrentPlatform 返回当前运行环境对应的 Platform
+func CurrentPlatform() Platform {
+	return Platform{
+		GOOS:    runtime.GOOS,
+		Getenv:  os.Getenv,
+		HomeDir: os.UserHomeDir,
+		LookupHome: func(username string) (string, error) {
+			u, err := user.Lookup(username)
+			if err != nil {
+				return "", err
+			}
+			return u.HomeDir, nil
+		},
+		Exists: func(path string) bool {
+			_, err := os.Stat(path)
+			return err == nil
+		},
+		Glob: filepath.Glob,
+	}
+}
+
+// join 按目标系统的分隔符拼接路径，Windows 的路径在其他系统上测试时也使用反斜杠
+func (p Platform) join(elem ...string) string {
+	if p.GOOS != "windows" {
+		return path.Join(elem...)
+	}
+	parts := make([]string, 0, len(elem))
+	for i, e := range elem {
+		e = strings.ReplaceAll(e, "/", `\`)
+		if i > 0 {
+			e = strings.TrimLeft(e, `\`)
+		}
+		if e = strings.TrimRight(e, `\`); e != "" {
+			parts = append(parts, e)
+		}
+	}
+	return strings.Join(parts, `\`)
+}
+
+// tokenPathIn 返回用户目录 home 下的 token 文件路径
+func (p Platform) tokenPathIn(home string) string {
+	return p.join(append(append([]string{home}, cacheDir...), FileName)...)
+}
+
+// Candidates 按优先级返回可能的 token 文件路径：
+//   - 当前用户目录 (Windows 为 %USERPROFILE%，其他系统为 $HOME)
+//   - Windows 上 %HOMEDRIVE%%HOMEPATH% 与 %USERPROFILE% 不同时 (例如用户目录被重定向) 的路径
+//   - 通过 sudo 运行时原用户的目录，此时 HOME 可能已是 root 的目录
+//   - WSL 中运行时 Windows 各用户目录下由 Windows 版 Kiro 写入的文件
+func (p Platform) Candidates() ([]string, error) {
+	var candidates []string
+	add := func(path string) {
+		for _, c := range candidates {
+			if c == path {
+				return
+			}
+		}
+		candidates = append(candidates, path)
+	}
+
+	home, homeErr := p.HomeDir()
+	if homeErr == nil && home != "" {
+		add(p.tokenPathIn(home))
+	}
+
+	switch p.GOOS {
+	case "windows":
+		if drive, dir := p.Getenv("HOMEDRIVE"), p.Getenv("HOMEPATH"); drive != "" && dir != "" {
+			add(p.tokenPathIn(drive + dir))
+		}
+	default:
+		if sudoUser := p.Getenv("SUDO_USER"); sudoUser != "" && sudoUser != "root" {
+			if sudoHome, err := p.LookupHome(sudoUser); err == nil && sudoHome != "" {
+				add(p.tokenPathIn(sudoHome))
+			}
+		}
+		if p.GOOS == "linux" && p.Getenv("WSL_DISTRO_NAME") != "" {
+			matches, _ := p.Glob(p.tokenPathIn("/mnt/c/Users/*"))
+			for _, match := range matches {
+				add(match)
+			}
+		}
+	}
+
+	if len(candidates) == 0 {
+		if homeErr == nil {
+			homeErr = errors.New("用户目录为空")
+		}
+		return nil, fmt.Errorf("获取用户目录失败: %v", homeErr)
+	}
+	return candidates, nil
+}
+
+// DefaultPath 返回第一个存在的候选路径，都不存在时返回当前用户目录下的路径，登录 Kiro 后文件会出现在那里
+func (p Platform) DefaultPath() (string, error) {
+	candidates, err := p.Candidates()
+	if err != nil {
+		return "", err
+	}
+	for _, c := range candidates {
+		if p.Exists(c) {
+			return c, nil
+		}
+	}
+	return candidates[0], nil
+}
+
+// DefaultPath 获取跨平台的默认token文件路径
+func DefaultPath() (string, error) {
+	return CurrentPlatform().DefaultPath()
+}
@@ -0,0 +1,137 @@
+package token
+
+import (
+	"errors"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// fakePlatform 构造指定系统的 Platform，existing 中的路径视为存在
+func fakePlatform(goos, home string, env map[string]string, homes map[string]string, existing ...string) Platform {
+	return Platform{
+		GOOS:   goos,
+		Getenv: func(key string) string { return env[key] },
+		HomeDir: func() (string, error) {
+			if home == "" {
+				return "", errors.New("$HOME is not defined")
+			}
+			return home, nil
+		},
+		LookupHome: func(username string) (string, error) {
+			if h, ok := homes[username]; ok {
+				return h, nil
+			}
+			return "", errors.New("unknown user " + username)
+		},
+		Exists: func(path string) bool {
+			for _, e := range existing {
+				if e == path {
+					return true
+				}
+			}
+			return false
+		},
+		Glob: func(pattern string) ([]string, error) {
+			prefix, suffix, _ := strings.Cut(pattern, "*")
+			var matches []string
+			for _, e := range existing {
+				if strings.HasPrefix(e, prefix) && strings.HasSuffix(e, suffix) {
+					matches = append(matches, e)
+				}
+			}
+			return matches, nil
+		},
+	}
+}
+
+func TestPlatformCandidates(t *testing.T) {
+	tests := []struct {
+		name     string
+		platform Platform
+		want     []string
+	}{
+		{
+			name:     "windows",
+			platform: fakePlatform("windows", `C:\Users\alice`, map[string]string{"HOMEDRIVE": "C:", "HOMEPATH": `\Users\alice`}, nil),
+			want:     []string{`C:\Users\alice\.aws\sso\cache\kiro-auth-token.json`},
+		},
+		{
+			name:     "windows redirected profile",
+			platform: fakePlatform("windows", `D:\Profiles\alice\`, map[string]string{"HOMEDRIVE": "H:", "HOMEPATH": `\`}, nil),
+			want:     []string{`D:\Profiles\alice\.aws\sso\cache\kiro-auth-token.json`, `H:\.aws\sso\cache\kiro-auth-token.json`},
+		},
+		{
+			name:     "macos",
+			platform: fakePlatform("darwin", "/Users/alice", nil, nil),
+			want:     []string{"/Users/alice/.aws/sso/cache/kiro-auth-token.json"},
+		},
+		{
+			name:     "macos sudo",
+			platform: fakePlatform("darwin", "/var/root", map[string]string{"SUDO_USER": "alice"}, map[string]string{"alice": "/Users/alice"}),
+			want:     []string{"/var/root/.aws/sso/cache/kiro-auth-token.json", "/Users/alice/.aws/sso/cache/kiro-auth-token.json"},
+		},
+		{
+			name:     "linux",
+			platform: fakePlatform("linux", "/home/alice/", map[string]string{"SUDO_USER": "root"}, nil),
+			want:     []string{"/home/alice/.aws/sso/cache/kiro-auth-token.json"},
+		},
+		{
+			name: "wsl",
+			platform: fakePlatform("linux", "/home/alice", map[string]string{"WSL_DISTRO_NAME": "Ubuntu"}, nil,
+				"/mnt/c/Users/Alice/.aws/sso/cache/kiro-auth-token.json"),
+			want: []string{"/home/alice/.aws/sso/cache/kiro-auth-token.json", "/mnt/c/Users/Alice/.aws/sso/cache/kiro-auth-token.json"},
+		},
+	}
+	for _, tt := range tests {
+		got, err := tt.platform.Candidates()
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: candidates = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPlatformDefaultPath(t *testing.T) {
+	// 都不存在时使用当前用户目录下的路径
+	p := fakePlatform("darwin", "/var/root", map[string]string{"SUDO_USER": "alice"}, map[string]string{"alice": "/Users/alice"})
+	if got, _ := p.DefaultPath(); got != "/var/root/.aws/sso/cache/kiro-auth-token.json" {
+		t.Errorf("default path = %s", got)
+	}
+
+	// sudo 运行时使用原用户已有的 token
+	p = fakePlatform("darwin", "/var/root", map[string]string{"SUDO_USER": "alice"}, map[string]string{"alice": "/Users/alice"},
+		"/Users/alice/.aws/sso/cache/kiro-auth-token.json")
+	if got, _ := p.DefaultPath(); got != "/Users/alice/.aws/sso/cache/kiro-auth-token.json" {
+		t.Errorf("default path = %s", got)
+	}
+
+	// 没有用户目录时仍可以使用原用户的目录
+	p = fakePlatform("linux", "", map[string]string{"SUDO_USER": "alice"}, map[string]string{"alice": "/home/alice"})
+	if got, err := p.DefaultPath(); err != nil || got != "/home/alice/.aws/sso/cache/kiro-auth-token.json" {
+		t.Errorf("default path = %s, %v", got, err)
+	}
+
+	if _, err := fakePlatform("linux", "", nil, nil).DefaultPath(); err == nil {
+		t.Error("expected an error without a home directory")
+	}
+}
+
+func TestCurrentPlatformDefaultPath(t *testing.T) {
+	// 在各系统的 CI 上检查真实环境得到的路径格式
+	path, err := DefaultPath()
+	if err != nil {
+		t.Skipf("no home directory: %v", err)
+	}
+	sep := "/"
+	if runtime.GOOS == "windows" {
+		sep = `\`
+	}
+	if want := sep + strings.Join(append(cacheDir, FileName), sep); !strings.HasSuffix(path, want) {
+		t.Errorf("DefaultPath() = %s, want suffix %s", path, want)
+	}
+}
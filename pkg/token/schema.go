@@ -0,0 +1,116 @@
+package token
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// SchemaVersion 是当前 token 文件的结构版本，写回文件时记录在 schemaVersion 字段中。
+// 没有该字段的文件视为版本 1
+const SchemaVersion = 2
+
+// schemaVersionKey 是记录结构版本的字段名
+const schemaVersionKey = "schemaVersion"
+
+// migrations[i] 把版本 i+1 的文件升级到版本 i+2，新增结构变化时在末尾追加并增大 SchemaVersion
+var migrations = []func(fields map[string]json.RawMessage) error{
+	migrateV1,
+}
+
+// migrateV1 把早期工具写入的下划线字段名改为驼峰
+func migrateV1(fields map[string]json.RawMessage) error {
+	for old, renamed := range map[string]string{
+		"access_token":  "accessToken",
+		"refresh_token": "refreshToken",
+		"expires_at":    "expiresAt",
+	} {
+		if value, ok := fields[old]; ok {
+			if _, exists := fields[renamed]; !exists {
+				fields[renamed] = value
+			}
+			delete(fields, old)
+		}
+	}
+	return nil
+}
+
+// dataFields 与 Data 字段相同但没有自定义的编解码方法
+type dataFields Data
+
+// UnmarshalJSON 按文件记录的结构版本逐级升级后解析，不认识的字段保存在 Extra 中
+func (d *Data) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+
+	version := 1
+	if raw, ok := fields[schemaVersionKey]; ok {
+		if err := json.Unmarshal(raw, &version); err != nil {
+			return fmt.Errorf("无效的 schemaVersion: %s", raw)
+		}
+		delete(fields, schemaVersionKey)
+	}
+	// 更新版本的程序写入的文件按当前结构尽量读取，不认识的字段仍会保留
+	for ; version >= 1 && version < SchemaVersion; version++ {
+		if err := migrations[version-1](fields); err != nil {
+			return fmt.Errorf("升级 token 文件结构 (版本 %d) 失败: %v", version, err)
+		}
+	}
+
+	// expiresAt 不论文件版本都统一为 UTC 的 RFC 3339，其他程序 (例如 Kiro) 可能以任意格式写回
+	if raw, ok := fields["expiresAt"]; ok {
+		fields["expiresAt"], _ = json.Marshal(normalizeExpiresAt(raw))
+	}
+
+	normalized, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	var known dataFields
+	if err := json.Unmarshal(normalized, &known); err != nil {
+		return err
+	}
+	for _, name := range knownFieldNames() {
+		delete(fields, name)
+	}
+	if len(fields) > 0 {
+		known.Extra = fields
+	}
+	*d = Data(known)
+	return nil
+}
+
+// MarshalJSON 输出当前结构版本，并带上读取时保留的未知字段
+func (d Data) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(dataFields(d))
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for name, value := range d.Extra {
+		if _, ok := fields[name]; !ok {
+			fields[name] = value
+		}
+	}
+	fields[schemaVersionKey], _ = json.Marshal(SchemaVersion)
+	return json.Marshal(fields)
+}
+
+// knownFieldNames 返回 Data 中有对应字段的 JSON 字段名
+func knownFieldNames() []string {
+	var names []string
+	t := reflect.TypeOf(dataFields{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,100 @@
+package token
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadMigratesLegacyFile(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		file string
+		want string
+	}{
+		{"unix seconds", `{"access_token":"a","refresh_token":"r","expires_at":1735689600}`, "2025-01-01T00:00:00Z"},
+		{"unix millis", `{"accessToken":"a","refreshToken":"r","expiresAt":1735689600000}`, "2025-01-01T00:00:00Z"},
+		{"no time zone", `{"accessToken":"a","refreshToken":"r","expiresAt":"2025-01-01 00:00:00"}`, "2025-01-01T00:00:00Z"},
+		{"offset to utc", `{"accessToken":"a","refreshToken":"r","expiresAt":"2025-01-01T08:00:00.000+08:00"}`, "2025-01-01T00:00:00Z"},
+		{"unknown kept", `{"accessToken":"a","refreshToken":"r","expiresAt":"next tuesday"}`, "next tuesday"},
+	} {
+		path := filepath.Join(t.TempDir(), "token.json")
+		os.WriteFile(path, []byte(tt.file), 0600)
+		data, err := Read(path)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if data.AccessToken != "a" || data.RefreshToken != "r" || data.ExpiresAt != tt.want {
+			t.Errorf("%s: got %+v, want expiresAt %s", tt.name, data, tt.want)
+		}
+	}
+}
+
+func TestWritePreservesUnknownFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token.json")
+	os.WriteFile(path, []byte(`{"accessToken":"a","refreshToken":"r","profileArn":"arn:x","futureField":{"nested":true}}`), 0600)
+
+	data, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data.AccessToken = "b"
+	if err := Write(path, data); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, _ := os.ReadFile(path)
+	var fields map[string]json.RawMessage
+	json.Unmarshal(raw, &fields)
+	if string(fields["accessToken"]) != `"b"` || string(fields["profileArn"]) != `"arn:x"` || !strings.Contains(string(fields["futureField"]), "nested") {
+		t.Errorf("written file = %s", raw)
+	}
+	if string(fields[schemaVersionKey]) != "2" {
+		t.Errorf("schemaVersion = %s", fields[schemaVersionKey])
+	}
+
+	// 再次读取时不把 schemaVersion 当作未知字段
+	data, _ = Read(path)
+	if _, ok := data.Extra[schemaVersionKey]; ok || len(data.Extra) != 2 {
+		t.Errorf("extra = %v", data.Extra)
+	}
+}
+
+func TestParseExpiresAt(t *testing.T) {
+	for _, value := range []string{"2025-01-01T00:00:00Z", "2025-01-01T08:00:00+08:00", "2024-12-31T19:00:00.123-05:00", "1735689600", "1735689600000", "2025-01-01T00:00:00"} {
+		got, err := ParseExpiresAt(value)
+		if err != nil || FormatExpiresAt(got) != "2025-01-01T00:00:00Z" {
+			t.Errorf("ParseExpiresAt(%q) = %v, %v", value, got, err)
+		}
+	}
+	for _, value := range []string{"", "soon", "-5"} {
+		if _, err := ParseExpiresAt(value); err == nil {
+			t.Errorf("ParseExpiresAt(%q) should fail", value)
+		}
+	}
+	if _, ok := (Data{}).Expiry(); ok {
+		t.Error("missing expiresAt should not have an expiry")
+	}
+}
+
+func TestValidFor(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		expiresAt string
+		want      bool
+	}{
+		{"2025-01-01T01:00:00Z", true},
+		{"2025-01-01T00:05:00Z", false},
+		{"2024-12-31T00:00:00Z", false},
+		{"", false},
+		{"garbage", false},
+	}
+	for _, tt := range tests {
+		if got := (Data{ExpiresAt: tt.expiresAt}).ValidFor(now, 10*time.Minute); got != tt.want {
+			t.Errorf("ValidFor(%q) = %v, want %v", tt.expiresAt, got, tt.want)
+		}
+	}
+}
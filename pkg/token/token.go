@@ -0,0 +1,147 @@
+// Package token 负责读取和刷新 Kiro 认证 token 文件
+package token
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+)
+
+// RefreshURL 是 Kiro 认证服务的刷新地址
+var RefreshURL = "https://prod.us-east-1.auth.desktop.kiro.dev/refreshToken"
+
+// Data 表示token文件的结构
+type Data struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+	ExpiresAt    string `json:"expiresAt,omitempty"`
+
+	// 以下字段只出现在 Builder ID 的 token 中 (aws sso login 或 Kiro 的 Builder ID 登录)，
+	// 这类 token 通过 SSO OIDC 刷新，写回时需要原样保留
+	AuthMethod string `json:"authMethod,omitempty"`
+	Provider   string `json:"provider,omitempty"`
+	StartURL   string `json:"startUrl,omitempty"`
+	Region     string `json:"region,omitempty"`
+	// ClientID 和 ClientSecret 是 OIDC 客户端注册信息，aws sso login 直接写在 token 文件中
+	ClientID              string `json:"clientId,omitempty"`
+	ClientSecret          string `json:"clientSecret,omitempty"`
+	RegistrationExpiresAt string `json:"registrationExpiresAt,omitempty"`
+	// ClientIDHash 不为空时注册信息保存在同目录下的 <ClientIDHash>.json 中 (Kiro 的做法)
+	ClientIDHash string `json:"clientIdHash,omitempty"`
+
+	// Extra 保存本版本不认识的字段，写回文件时原样输出，避免刷新后丢失其他程序写入的内容
+	Extra map[string]json.RawMessage `json:"-"`
+}
+
+// refreshRequest 刷新token的请求结构
+type refreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// refreshResponse 刷新token的响应结构
+type refreshResponse struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+	ExpiresAt    string `json:"expiresAt,omitempty"`
+}
+
+// Read 读取token文件
+func Read(path string) (Data, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Data{}, fmt.Errorf("读取token文件失败: %v", err)
+	}
+
+	var token Data
+	if err := json.Unmarshal(data, &token); err != nil {
+		return Data{}, fmt.Errorf("解析token文件失败: %v", err)
+	}
+
+	return token, nil
+}
+
+// Write 将token写回文件
+func Write(path string, token Data) error {
+	newData, err := json.MarshalIndent(token, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化新token失败: %v", err)
+	}
+
+	if err := os.WriteFile(path, newData, 0600); err != nil {
+		return fmt.Errorf("写入token文件失败: %v", err)
+	}
+	return nil
+}
+
+// Refresh 使用refresh token刷新access token并写回文件，Builder ID 的 token 通过 SSO OIDC 刷新。
+// 同目录下仍保存着旧 refresh token 的其他缓存文件一并更新
+func Refresh(path string) (Data, error) {
+	// 读取当前token
+	currentToken, err := Read(path)
+	if err != nil {
+		return Data{}, err
+	}
+
+	var newToken Data
+	if currentToken.UsesOIDC() {
+		newToken, err = refreshOIDC(path, currentToken)
+	} else {
+		newToken, err = refreshKiro(currentToken)
+	}
+	if err != nil {
+		// Kiro IDE 可能同时刷新并轮换了 refresh token，此时使用它写入 token 文件的结果
+		if latest, readErr := Read(path); readErr == nil && latest.RefreshToken != "" && latest.RefreshToken != currentToken.RefreshToken {
+			return latest, nil
+		}
+		return Data{}, err
+	}
+
+	// 更新token文件
+	if err := Write(path, newToken); err != nil {
+		return Data{}, err
+	}
+	syncSiblings(path, currentToken, newToken)
+
+	return newToken, nil
+}
+
+// refreshKiro 通过 Kiro 认证服务刷新社交登录的 token
+func refreshKiro(currentToken Data) (Data, error) {
+	// 准备刷新请求
+	reqBody, err := json.Marshal(refreshRequest{
+		RefreshToken: currentToken.RefreshToken,
+	})
+	if err != nil {
+		return Data{}, fmt.Errorf("序列化请求失败: %v", err)
+	}
+
+	// 发送刷新请求
+	resp, err := http.Post(RefreshURL, "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return Data{}, fmt.Errorf("刷新token请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return Data{}, fmt.Errorf("刷新token失败，状态码: %d, 响应: %s", resp.StatusCode, string(body))
+	}
+
+	// 解析响应
+	var refreshResp refreshResponse
+	if err := json.NewDecoder(resp.Body).Decode(&refreshResp); err != nil {
+		return Data{}, fmt.Errorf("解析刷新响应失败: %v", err)
+	}
+
+	newToken := currentToken
+	newToken.AccessToken = refreshResp.AccessToken
+	newToken.RefreshToken = refreshResp.RefreshToken
+	newToken.ExpiresAt = refreshResp.ExpiresAt
+	if expiresAt, err := ParseExpiresAt(refreshResp.ExpiresAt); err == nil {
+		newToken.ExpiresAt = FormatExpiresAt(expiresAt)
+	}
+	return newToken, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// pprofKinds 是 profile 子命令支持的分析类型，对应 /admin/debug/pprof/ 下的名称
+var pprofKinds = map[string]string{
+	"cpu":          "profile",
+	"trace":        "trace",
+	"heap":         "heap",
+	"allocs":       "allocs",
+	"goroutine":    "goroutine",
+	"block":        "block",
+	"mutex":        "mutex",
+	"threadcreate": "threadcreate",
+}
+
+// runProfile 处理 profile 子命令：不带参数时列出账号可用的 CodeWhisperer profile，
+// 第一个参数是分析类型时从运行中的服务器下载 pprof 数据并保存到文件
+func runProfile(args []string) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		fs := flag.NewFlagSet("profile", flag.ExitOnError)
+		addJSONFlag(fs, "以 JSON 输出 profile 列表")
+		fs.Parse(args)
+		listProfiles()
+		return
+	}
+	kind := args[0]
+	name, ok := pprofKinds[kind]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "未知的分析类型 %q (可选: cpu, heap, allocs, goroutine, block, mutex, threadcreate, trace)\n", kind)
+		exitf(2, os.Stderr, "用法: %s profile <类型> [时长] [--server url] [-o 文件]", os.Args[0])
+	}
+
+	// 时长是可选的位置参数，写在选项之前，例如 profile cpu 30s --server url
+	var duration time.Duration
+	rest := args[1:]
+	if len(rest) > 0 && !strings.HasPrefix(rest[0], "-") {
+		d, err := time.ParseDuration(rest[0])
+		if err != nil || d <= 0 {
+			exitf(2, os.Stderr, "无效的时长 %q，例如 30s", rest[0])
+		}
+		duration, rest = d, rest[1:]
+	}
+
+	fs := flag.NewFlagSet("profile "+kind, flag.ExitOnError)
+	serverURL := fs.String("server", "http://localhost:8080", "kiro2cc 服务器地址，包括 --base-path")
+	adminToken := fs.String("admin-token", os.Getenv("KIRO2CC_ADMIN_TOKEN"), "管理接口的访问token")
+	output := fs.String("o", "", "保存的文件名，默认为 kiro2cc-<类型>-<时间>.pprof")
+	addJSONFlag(fs, "以 JSON 输出保存的文件")
+	fs.Parse(rest)
+
+	// cpu 和 trace 必须采样一段时间，其他类型带时长时下载这段时间内的增量
+	if duration == 0 && (kind == "cpu" || kind == "trace") {
+		duration = 30 * time.Second
+	}
+	url := strings.TrimRight(*serverURL, "/") + "/admin/debug/pprof/" + name
+	if duration > 0 {
+		url += fmt.Sprintf("?seconds=%d", int(duration.Round(time.Second).Seconds()))
+	}
+	if *output == "" {
+		ext := ".pprof"
+		if kind == "trace" {
+			ext = ".trace"
+		}
+		*output = fmt.Sprintf("kiro2cc-%s-%s%s", kind, time.Now().Format("20060102-150405"), ext)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		exitf(1, os.Stderr, "下载分析数据失败: %v", err)
+	}
+	if *adminToken != "" {
+		req.Header.Set("Authorization", "Bearer "+*adminToken)
+	}
+	if duration > 0 {
+		fmt.Fprintf(os.Stderr, "正在采集 %s 的 %s 数据...\n", duration, kind)
+	}
+
+	client := &http.Client{Timeout: duration + 30*time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		exitf(1, os.Stderr, "下载分析数据失败: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		exitf(1, os.Stderr, "下载分析数据失败: 状态码: %d, 响应: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	file, err := os.Create(*output)
+	if err != nil {
+		exitf(1, os.Stderr, "创建文件失败: %v", err)
+	}
+	size, err := io.Copy(file, resp.Body)
+	if err != nil {
+		file.Close()
+		os.Remove(*output)
+		exitf(1, os.Stderr, "下载分析数据中断: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		exitf(1, os.Stderr, "保存文件失败: %v", err)
+	}
+
+	if jsonOutput {
+		writeJSON(os.Stdout, map[string]any{"kind": kind, "path": *output, "bytes": size})
+		return
+	}
+	tool := "go tool pprof -http=:0"
+	if kind == "trace" {
+		tool = "go tool trace"
+	}
+	fmt.Printf("已保存到 %s，查看: %s %s\n", *output, tool, *output)
+}
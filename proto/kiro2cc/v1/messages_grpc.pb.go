@@ -0,0 +1,178 @@
+// kiro2cc 的 gRPC 接口定义，字段与 Anthropic Messages API 保持一致。
+//
+// 生成代码：
+//   protoc --go_out=. --go_opt=paths=source_relative \
+//          --go-grpc_out=. --go-grpc_opt=paths=source_relative \
+//          proto/kiro2cc/v1/messages.proto
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.28.3
+// source: proto/kiro2cc/v1/messages.proto
+
+package kiro2ccv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	MessagesService_Message_FullMethodName       = "/kiro2cc.v1.MessagesService/Message"
+	MessagesService_MessageStream_FullMethodName = "/kiro2cc.v1.MessagesService/MessageStream"
+)
+
+// MessagesServiceClient is the client API for MessagesService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// MessagesService 对应 Anthropic 的 /v1/messages 接口
+type MessagesServiceClient interface {
+	// Message 对应非流式请求
+	Message(ctx context.Context, in *MessageRequest, opts ...grpc.CallOption) (*MessageResponse, error)
+	// MessageStream 对应流式请求，逐个返回与 SSE 相同的事件
+	MessageStream(ctx context.Context, in *MessageRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[StreamEvent], error)
+}
+
+type messagesServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewMessagesServiceClient(cc grpc.ClientConnInterface) MessagesServiceClient {
+	return &messagesServiceClient{cc}
+}
+
+func (c *messagesServiceClient) Message(ctx context.Context, in *MessageRequest, opts ...grpc.CallOption) (*MessageResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(MessageResponse)
+	err := c.cc.Invoke(ctx, MessagesService_Message_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *messagesServiceClient) MessageStream(ctx context.Context, in *MessageRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[StreamEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &MessagesService_ServiceDesc.Streams[0], MessagesService_MessageStream_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[MessageRequest, StreamEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type MessagesService_MessageStreamClient = grpc.ServerStreamingClient[StreamEvent]
+
+// MessagesServiceServer is the server API for MessagesService service.
+// All implementations must embed UnimplementedMessagesServiceServer
+// for forward compatibility.
+//
+// MessagesService 对应 Anthropic 的 /v1/messages 接口
+type MessagesServiceServer interface {
+	// Message 对应非流式请求
+	Message(context.Context, *MessageRequest) (*MessageResponse, error)
+	// MessageStream 对应流式请求，逐个返回与 SSE 相同的事件
+	MessageStream(*MessageRequest, grpc.ServerStreamingServer[StreamEvent]) error
+	mustEmbedUnimplementedMessagesServiceServer()
+}
+
+// UnimplementedMessagesServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedMessagesServiceServer struct{}
+
+func (UnimplementedMessagesServiceServer) Message(context.Context, *MessageRequest) (*MessageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Message not implemented")
+}
+func (UnimplementedMessagesServiceServer) MessageStream(*MessageRequest, grpc.ServerStreamingServer[StreamEvent]) error {
+	return status.Errorf(codes.Unimplemented, "method MessageStream not implemented")
+}
+func (UnimplementedMessagesServiceServer) mustEmbedUnimplementedMessagesServiceServer() {}
+func (UnimplementedMessagesServiceServer) testEmbeddedByValue()                         {}
+
+// UnsafeMessagesServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to MessagesServiceServer will
+// result in compilation errors.
+type UnsafeMessagesServiceServer interface {
+	mustEmbedUnimplementedMessagesServiceServer()
+}
+
+func RegisterMessagesServiceServer(s grpc.ServiceRegistrar, srv MessagesServiceServer) {
+	// If the following call pancis, it indicates UnimplementedMessagesServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&MessagesService_ServiceDesc, srv)
+}
+
+func _MessagesService_Message_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MessageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MessagesServiceServer).Message(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MessagesService_Message_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MessagesServiceServer).Message(ctx, req.(*MessageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MessagesService_MessageStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(MessageRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MessagesServiceServer).MessageStream(m, &grpc.GenericServerStream[MessageRequest, StreamEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type MessagesService_MessageStreamServer = grpc.ServerStreamingServer[StreamEvent]
+
+// MessagesService_ServiceDesc is the grpc.ServiceDesc for MessagesService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var MessagesService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "kiro2cc.v1.MessagesService",
+	HandlerType: (*MessagesServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Message",
+			Handler:    _MessagesService_Message_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "MessageStream",
+			Handler:       _MessagesService_MessageStream_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "proto/kiro2cc/v1/messages.proto",
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/bestk/kiro2cc/pkg/prompt"
+	"github.com/bestk/kiro2cc/pkg/server"
+)
+
+// stringList 是可重复指定的字符串参数
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// runTemplate 处理 run 子命令：渲染提示词模板并发送一次非流式请求。
+// 退出码与 ask 一致：0 表示成功，1 表示请求失败，2 表示模板或参数错误
+func runTemplate(args []string) {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	var vars stringList
+	fs.Var(&vars, "var", "模板变量 name=value，可重复指定")
+	model := fs.String("m", "", "覆盖模板中的模型")
+	dryRun := fs.Bool("dry-run", false, "只输出渲染后的请求，不发送")
+	addJSONFlag(fs, "输出完整的响应 JSON，而不只是文本")
+	serverURL := fs.String("server", "", "连接已运行的 kiro2cc 服务器，留空时在本进程内启动")
+	apiKey := fs.String("api-key", defaultAPIKey(), "连接服务器时使用的 API key，默认为环境变量 ANTHROPIC_API_KEY 或本地 API key")
+	verbose := fs.Bool("verbose", false, "把服务器日志输出到 stderr")
+
+	// 允许模板路径写在参数前面：kiro2cc run template.yaml --var name=value
+	var path string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		path, args = args[0], args[1:]
+	}
+	fs.Parse(args)
+	if path == "" {
+		path = fs.Arg(0)
+	}
+	if path == "" {
+		exitf(2, os.Stderr, "用法: %s run <模板.yaml> [--var name=value ...]", os.Args[0])
+	}
+
+	tmpl, err := prompt.Load(path)
+	if err != nil {
+		exitf(2, os.Stderr, "%v", err)
+	}
+	values, err := prompt.ParseVars(vars)
+	if err != nil {
+		exitf(2, os.Stderr, "%v", err)
+	}
+	req, err := tmpl.Render(values)
+	if err != nil {
+		exitf(2, os.Stderr, "渲染模板失败: %v", err)
+	}
+	if *model != "" {
+		req.Model = *model
+	}
+	if req.Model == "" {
+		req.Model = defaultChatModel
+	}
+	resolved, ok := server.MatchModel(req.Model)
+	if !ok {
+		exitf(2, os.Stderr, "不支持的模型: %s", req.Model)
+	}
+	req.Model = resolved
+
+	if *dryRun {
+		writeJSON(os.Stdout, req)
+		return
+	}
+
+	out := quietServerLogs(*verbose)
+	c, closeServer, err := newLocalClient(*serverURL, *apiKey)
+	if err != nil {
+		exitf(1, os.Stderr, "%v", err)
+	}
+	defer closeServer()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	resp, err := c.Messages(ctx, req)
+	if err != nil {
+		closeServer()
+		exitf(1, os.Stderr, "请求失败: %v", err)
+	}
+	if jsonOutput {
+		writeJSON(out, resp)
+		return
+	}
+	fmt.Fprintln(out, resp.Text())
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/bestk/kiro2cc/pkg/token"
+)
+
+// trayPollInterval 是托盘刷新服务器和 token 状态的间隔
+const trayPollInterval = 5 * time.Second
+
+// trayController 是托盘背后与界面无关的部分：查询服务器和 token 状态，以子进程启动和停止服务器。
+// 界面由 -tags tray 编译的 tray_systray.go 提供
+type trayController struct {
+	port       string
+	serverArgs []string
+
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	exited chan struct{}
+}
+
+// trayStatus 是托盘菜单显示的状态
+type trayStatus struct {
+	// Running 表示 /health 可以访问，服务器可能是托盘启动的，也可能是用户自己启动的
+	Running bool
+	// Owned 表示服务器是托盘启动的子进程，只有这时才能从托盘停止
+	Owned bool
+	// TokenExpiry 是 token 的过期时间，无法读取时 TokenErr 非空
+	TokenExpiry time.Time
+	TokenErr    error
+}
+
+// newTrayController 返回管理 port 端口上服务器的控制器，serverArgs 是启动服务器时附加的 server 参数
+func newTrayController(port string, serverArgs []string) *trayController {
+	return &trayController{port: port, serverArgs: serverArgs}
+}
+
+// Status 查询服务器是否在运行以及 token 的过期时间
+func (c *trayController) Status() trayStatus {
+	var status trayStatus
+	client := http.Client{Timeout: 2 * time.Second}
+	if resp, err := client.Get(fmt.Sprintf("http://localhost:%s/health", c.port)); err == nil {
+		resp.Body.Close()
+		// 维护期间 /health 返回 503，服务器仍在运行
+		status.Running = resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusServiceUnavailable
+	}
+	status.Owned = c.owned()
+
+	data, err := token.Read(getTokenFilePath())
+	if err != nil {
+		status.TokenErr = err
+	} else if expiresAt, ok := data.Expiry(); ok {
+		status.TokenExpiry = expiresAt
+	} else {
+		status.TokenErr = fmt.Errorf("无法解析过期时间 %q", data.ExpiresAt)
+	}
+	return status
+}
+
+// owned 判断托盘启动的服务器子进程是否仍在运行
+func (c *trayController) owned() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cmd != nil
+}
+
+// Start 以子进程启动服务器，参数与 kiro2cc server 相同，输出丢弃 (可以通过 logs tail 查看)
+func (c *trayController) Start() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cmd != nil {
+		return errors.New("服务器已在运行")
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("获取可执行文件路径失败: %v", err)
+	}
+	args := []string{"-f", getTokenFilePath(), "server"}
+	args = append(append(args, c.serverArgs...), c.port)
+	cmd := exec.Command(exe, args...)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("启动服务器失败: %v", err)
+	}
+
+	exited := make(chan struct{})
+	c.cmd, c.exited = cmd, exited
+	go func() {
+		cmd.Wait()
+		c.mu.Lock()
+		if c.cmd == cmd {
+			c.cmd = nil
+		}
+		c.mu.Unlock()
+		close(exited)
+	}()
+	return nil
+}
+
+// Stop 停止托盘启动的服务器，先发送中断信号等待正常退出，超时后强制结束
+func (c *trayController) Stop() error {
+	c.mu.Lock()
+	cmd, exited := c.cmd, c.exited
+	c.mu.Unlock()
+	if cmd == nil {
+		return errors.New("服务器不是由托盘启动的，无法停止")
+	}
+
+	// Windows 不支持向子进程发送中断信号，直接结束
+	if err := cmd.Process.Signal(os.Interrupt); err != nil {
+		cmd.Process.Kill()
+	}
+	select {
+	case <-exited:
+	case <-time.After(10 * time.Second):
+		cmd.Process.Kill()
+		<-exited
+	}
+	return nil
+}
+
+// RefreshToken 刷新 token，返回新的过期时间
+func (c *trayController) RefreshToken() (time.Time, error) {
+	path := getTokenFilePath()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	data, err := token.RefreshCoordinated(ctx, path, token.NewFileCoordinator(path))
+	if err != nil {
+		return time.Time{}, err
+	}
+	expiresAt, _ := data.Expiry()
+	return expiresAt, nil
+}
+
+// trayIcon 生成托盘图标：服务器运行时为绿色圆点，否则为灰色。
+// Windows 的托盘图标需要 ICO 格式，其他系统使用 PNG
+func trayIcon(running, windows bool) []byte {
+	const size = 32
+	fill := color.RGBA{0x9e, 0x9e, 0x9e, 0xff}
+	if running {
+		fill = color.RGBA{0x2e, 0xb8, 0x4d, 0xff}
+	}
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	center, radius := float64(size-1)/2, float64(size)/2-2
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			dx, dy := float64(x)-center, float64(y)-center
+			if dx*dx+dy*dy <= radius*radius {
+				img.Set(x, y, fill)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	if !windows {
+		return buf.Bytes()
+	}
+
+	// ICO 文件头 + 一个目录项，图像数据直接使用 PNG (Windows Vista 起支持)
+	var ico bytes.Buffer
+	binary.Write(&ico, binary.LittleEndian, []uint16{0, 1, 1})
+	ico.Write([]byte{size, size, 0, 0})
+	binary.Write(&ico, binary.LittleEndian, []uint16{1, 32})
+	binary.Write(&ico, binary.LittleEndian, []uint32{uint32(buf.Len()), 6 + 16})
+	ico.Write(buf.Bytes())
+	return ico.Bytes()
+}
@@ -0,0 +1,11 @@
+//go:build !tray
+
+package main
+
+import "os"
+
+// runTray 在未使用 -tags tray 编译时提示如何获得托盘支持。托盘依赖第三方库 fyne.io/systray，
+// 默认构建不包含它，命令行程序不需要额外的依赖
+func runTray(args []string) {
+	exitf(1, os.Stderr, "此版本未包含托盘支持，请使用 go build -tags tray 编译，详见 README 的“托盘模式”")
+}
@@ -0,0 +1,119 @@
+//go:build tray
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"runtime"
+	"time"
+
+	"fyne.io/systray"
+)
+
+// runTray 处理 tray 子命令：在系统托盘显示服务器状态和 token 过期时间，提供刷新 token 和启动/停止服务器。
+// 托盘退出时停止由它启动的服务器
+func runTray(args []string) {
+	fs := flag.NewFlagSet("tray", flag.ExitOnError)
+	port := fs.String("port", "8080", "服务器端口")
+	autoStart := fs.Bool("start", false, "打开托盘时启动服务器")
+	fs.Parse(args)
+
+	// 剩余参数原样传给 server 命令，例如 kiro2cc tray -- --require-api-key
+	controller := newTrayController(*port, fs.Args())
+	systray.Run(func() { trayReady(controller, *autoStart) }, func() { controller.Stop() })
+}
+
+// trayReady 创建托盘菜单并定时刷新状态
+func trayReady(c *trayController, autoStart bool) {
+	windows := runtime.GOOS == "windows"
+	systray.SetTitle("kiro2cc")
+	serverItem := systray.AddMenuItem("服务器: 检查中", "")
+	serverItem.Disable()
+	tokenItem := systray.AddMenuItem("Token: 检查中", "")
+	tokenItem.Disable()
+	systray.AddSeparator()
+	refreshItem := systray.AddMenuItem("刷新 token", "立即刷新 token")
+	toggleItem := systray.AddMenuItem("启动服务器", "")
+	systray.AddSeparator()
+	quitItem := systray.AddMenuItem("退出", "退出托盘，同时停止由托盘启动的服务器")
+
+	// message 是最近一次操作的结果，显示在提示中直到下次操作
+	message := ""
+	update := func() {
+		status := c.Status()
+		systray.SetIcon(trayIcon(status.Running, windows))
+		switch {
+		case status.Owned && status.Running:
+			serverItem.SetTitle(fmt.Sprintf("服务器: 运行中 (端口 %s)", c.port))
+			toggleItem.SetTitle("停止服务器")
+			toggleItem.Enable()
+		case status.Owned:
+			serverItem.SetTitle("服务器: 启动中")
+			toggleItem.SetTitle("停止服务器")
+			toggleItem.Enable()
+		case status.Running:
+			// 用户自己启动的服务器，托盘无法停止
+			serverItem.SetTitle(fmt.Sprintf("服务器: 运行中 (端口 %s，非托盘启动)", c.port))
+			toggleItem.SetTitle("停止服务器")
+			toggleItem.Disable()
+		default:
+			serverItem.SetTitle("服务器: 未运行")
+			toggleItem.SetTitle("启动服务器")
+			toggleItem.Enable()
+		}
+
+		switch {
+		case status.TokenErr != nil:
+			tokenItem.SetTitle("Token: 无法读取")
+			tokenItem.SetTooltip(status.TokenErr.Error())
+		case !status.TokenExpiry.After(time.Now()):
+			tokenItem.SetTitle(fmt.Sprintf("Token: 已于 %s 过期", status.TokenExpiry.Local().Format(time.DateTime)))
+		default:
+			tokenItem.SetTitle(fmt.Sprintf("Token: %s 过期", status.TokenExpiry.Local().Format(time.DateTime)))
+		}
+		tooltip := "kiro2cc"
+		if message != "" {
+			tooltip += "\n" + message
+		}
+		systray.SetTooltip(tooltip)
+	}
+
+	if autoStart {
+		if err := c.Start(); err != nil {
+			message = err.Error()
+		}
+	}
+	update()
+
+	go func() {
+		ticker := time.NewTicker(trayPollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-refreshItem.ClickedCh:
+				if expiresAt, err := c.RefreshToken(); err != nil {
+					message = fmt.Sprintf("刷新 token 失败: %v", err)
+				} else {
+					message = fmt.Sprintf("token 已刷新，%s 过期", expiresAt.Local().Format(time.DateTime))
+				}
+			case <-toggleItem.ClickedCh:
+				var err error
+				if c.owned() {
+					err = c.Stop()
+				} else {
+					err = c.Start()
+				}
+				message = ""
+				if err != nil {
+					message = err.Error()
+				}
+			case <-quitItem.ClickedCh:
+				systray.Quit()
+				return
+			}
+			update()
+		}
+	}()
+}